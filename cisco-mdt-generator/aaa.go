@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// AAAState is the authentication of management logins against TACACS+ or
+// RADIUS servers. Requests go to the first server of the list that is not
+// dead; with every server dead the switch falls back to local accounts.
+type AAAState struct {
+	Servers []*AAAServer
+
+	Requests       uint64
+	Accepted       uint64
+	Rejected       uint64 // wrong credentials
+	LocalFallbacks uint64 // authenticated locally with every server dead
+
+	credit float64
+}
+
+// AAAServer is a configured AAA server and its statistics
+type AAAServer struct {
+	Address  string
+	Protocol string // tacacs or radius
+	Group    string
+
+	// Unreachable is set while an aaa_server_outage event cuts the server off
+	Unreachable bool
+	Dead        bool
+	DeadSince   time.Time
+	DeadCount   uint32
+
+	Requests   uint64
+	Accepts    uint64
+	Rejects    uint64
+	Timeouts   uint64
+	ResponseMS uint32 // response time of the last answered request
+}
+
+// initAAAFromConfig creates the AAA servers of the node, all alive
+func initAAAFromConfig(cfg *Config) *AAAState {
+	if !cfg.AAA.Enabled {
+		return nil
+	}
+	a := &AAAState{}
+	for _, sc := range cfg.AAA.Servers {
+		srv := &AAAServer{Address: sc.Address, Protocol: sc.Protocol, Group: sc.Group}
+		if srv.Protocol == "" {
+			srv.Protocol = "tacacs"
+		}
+		a.Servers = append(a.Servers, srv)
+	}
+	return a
+}
+
+// findAAAServers returns the AAA servers with the given address or in the
+// given group
+func (s *Simulator) findAAAServers(target string) []*AAAServer {
+	if s.AAA == nil {
+		return nil
+	}
+	var servers []*AAAServer
+	for _, srv := range s.AAA.Servers {
+		if srv.Address == target || srv.Group == target {
+			servers = append(servers, srv)
+		}
+	}
+	return servers
+}
+
+// stepAAA retries dead servers whose deadtime elapsed and authenticates
+// the logins of the interval
+func (s *Simulator) stepAAA(now time.Time, seconds float64) {
+	a := s.AAA
+	if a == nil {
+		return
+	}
+	for _, srv := range a.Servers {
+		if srv.Dead && !srv.Unreachable && now.Sub(srv.DeadSince) >= s.cfg.AAA.Deadtime {
+			srv.Dead = false
+			s.event("aaa_server_alive", srv.Address, "%s server %s is alive again after deadtime", srv.Protocol, srv.Address)
+		}
+	}
+
+	a.credit += s.cfg.AAA.RequestsPerMinute * seconds / 60
+	for ; a.credit >= 1; a.credit-- {
+		s.authenticate(now, s.rng.Float64()*100 < s.cfg.AAA.FailurePercent)
+	}
+}
+
+// authenticate sends one login, rejected for wrong credentials or not, to
+// the first server that is not dead. A server that times out is marked dead
+// and the next one is tried.
+func (s *Simulator) authenticate(now time.Time, reject bool) {
+	a := s.AAA
+	a.Requests++
+	for _, srv := range a.Servers {
+		if srv.Dead {
+			continue
+		}
+		srv.Requests++
+		if srv.Unreachable {
+			srv.Timeouts++
+			srv.Dead = true
+			srv.DeadSince = now
+			srv.DeadCount++
+			facility := strings.ToUpper(srv.Protocol)
+			s.Syslog.Emit(now, SeverityError, facility, facility+"_ERROR_MESSAGE",
+				fmt.Sprintf("%s server %s did not respond, marked dead", srv.Protocol, srv.Address))
+			s.event("aaa_server_dead", srv.Address, "%s server %s timed out and is marked dead", srv.Protocol, srv.Address)
+			continue
+		}
+		srv.ResponseMS = uint32(5 + s.rng.Intn(25))
+		if reject {
+			srv.Rejects++
+			a.Rejected++
+		} else {
+			srv.Accepts++
+			a.Accepted++
+		}
+		return
+	}
+	a.LocalFallbacks++
+}
+
+// SetAAAReachable cuts off or restores AAA servers by address or group. A
+// restored server stays dead until its deadtime elapses.
+func (s *Simulator) SetAAAReachable(target string, reachable bool) error {
+	servers := s.findAAAServers(target)
+	if len(servers) == 0 {
+		return fmt.Errorf("unknown AAA server or group %s", target)
+	}
+	for _, srv := range servers {
+		srv.Unreachable = !reachable
+	}
+	if reachable {
+		s.event("aaa_outage_end", target, "AAA %s reachable again (%d servers)", target, len(servers))
+	} else {
+		s.event("aaa_outage", target, "AAA %s unreachable (%d servers)", target, len(servers))
+	}
+	return nil
+}
+
+// checkAAATarget ensures an event targets a configured AAA server or group
+func checkAAATarget(s *Simulator, ev ScenarioEvent) error {
+	if len(s.findAAAServers(ev.Target)) == 0 {
+		return fmt.Errorf("unknown AAA server or group %q", ev.Target)
+	}
+	return nil
+}
+
+// aaaServerOutageAction is the aaa_server_outage scenario action
+var aaaServerOutageAction = scenarioAction{
+	check: checkAAATarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetAAAReachable(ev.Target, false)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetAAAReachable(ev.Target, true)
+	},
+}
+
+// checkAAA ensures the AAA servers are usable
+func checkAAA(cfg AAAConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if len(cfg.Servers) == 0 {
+		return fmt.Errorf("at least one server must be configured")
+	}
+	seen := make(map[string]bool)
+	for _, srv := range cfg.Servers {
+		if net.ParseIP(srv.Address) == nil {
+			return fmt.Errorf("server address %q is not an IP address", srv.Address)
+		}
+		if seen[srv.Address] {
+			return fmt.Errorf("duplicate server %s", srv.Address)
+		}
+		seen[srv.Address] = true
+		if srv.Protocol != "" && srv.Protocol != "tacacs" && srv.Protocol != "radius" {
+			return fmt.Errorf("server %s: protocol must be tacacs or radius", srv.Address)
+		}
+	}
+	if cfg.RequestsPerMinute < 0 || cfg.FailurePercent < 0 || cfg.FailurePercent > 100 {
+		return fmt.Errorf("requests_per_minute must be non-negative and failure_percent between 0 and 100")
+	}
+	if cfg.Deadtime <= 0 {
+		return fmt.Errorf("deadtime must be positive")
+	}
+	return nil
+}
+
+// buildAAAServerTelemetry reports the reachability and statistics of every
+// AAA server
+func buildAAAServerTelemetry(ts uint64, nodeID string, a *AAAState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, srv := range a.Servers {
+		state, code := "alive", uint32(1)
+		if srv.Dead {
+			state, code = "dead", 0
+		}
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("server", srv.Address, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("protocol", srv.Protocol, ts),
+				telemetry.StringField("group", srv.Group, ts),
+				telemetry.StringField("state", state, ts),
+				telemetry.Uint32Field("state-code", code, ts),
+				telemetry.Uint32Field("dead-count", srv.DeadCount, ts),
+				telemetry.Uint64Field("auth-requests", srv.Requests, ts),
+				telemetry.Uint64Field("auth-accepts", srv.Accepts, ts),
+				telemetry.Uint64Field("auth-rejects", srv.Rejects, ts),
+				telemetry.Uint64Field("auth-timeouts", srv.Timeouts, ts),
+				telemetry.Uint32Field("response-time-ms", srv.ResponseMS, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "aaa_servers",
+		EncodingPath:        "Cisco-NX-OS-device:System/aaa-items/server-items/Server-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildAAAAuthTelemetry reports the authentication outcomes of the node
+func buildAAAAuthTelemetry(ts uint64, nodeID string, a *AAAState) *telemetry.Telemetry {
+	var alive uint32
+	for _, srv := range a.Servers {
+		if !srv.Dead {
+			alive++
+		}
+	}
+	row := telemetry.RowField(
+		[]*telemetry.TelemetryField{
+			telemetry.StringField("method", "login", ts),
+		},
+		[]*telemetry.TelemetryField{
+			telemetry.Uint64Field("auth-requests", a.Requests, ts),
+			telemetry.Uint64Field("auth-success", a.Accepted+a.LocalFallbacks, ts),
+			telemetry.Uint64Field("auth-failures", a.Rejected, ts),
+			telemetry.Uint64Field("local-fallbacks", a.LocalFallbacks, ts),
+			telemetry.Uint32Field("servers-alive", alive, ts),
+		},
+		ts,
+	)
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "aaa_authentication",
+		EncodingPath:        "Cisco-NX-OS-device:System/aaa-items/authstats-items",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           []*telemetry.TelemetryField{row},
+	}
+}
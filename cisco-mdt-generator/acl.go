@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"cisco-mdt-generator/pkg/mdt_dialout"
+)
+
+// Results of a single ACL probe
+const (
+	aclAccepted = "accepted"
+	aclRejected = "rejected"
+	aclSkipped  = "skipped"
+)
+
+// aclProbe is one connection attempt from a fixed source address and port
+type aclProbe struct {
+	source  string
+	result  string
+	detail  string
+	latency time.Duration
+}
+
+// parsePortRange parses "40000-40009" or "40000,40005" into a list of ports.
+// An empty string yields a single ephemeral port (0).
+func parsePortRange(spec string) ([]int, error) {
+	if spec == "" {
+		return []int{0}, nil
+	}
+
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("invalid port %q", part)
+			}
+		}
+		if first < 0 || last > 65535 || first > last {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		for p := first; p <= last; p++ {
+			ports = append(ports, p)
+		}
+	}
+	return ports, nil
+}
+
+// probeCollector opens a dial-out stream from the given source address,
+// sends one telemetry message and classifies how the collector reacted
+func probeCollector(server string, source *net.TCPAddr, dialOpts []grpc.DialOption, payload []byte, timeout time.Duration) aclProbe {
+	probe := aclProbe{source: source.String()}
+	if source.IP == nil && source.Port == 0 {
+		probe.source = "(default)"
+	}
+
+	// The dialer runs on a gRPC goroutine, so its findings are guarded
+	var (
+		mu        sync.Mutex
+		bindErr   error
+		connected bool
+	)
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		d := net.Dialer{LocalAddr: source}
+		conn, err := d.DialContext(ctx, "tcp", addr)
+
+		mu.Lock()
+		defer mu.Unlock()
+		// A source address that is not local to this host cannot be used
+		var sysErr *os.SyscallError
+		if err == nil {
+			connected = true
+		} else if errors.As(err, &sysErr) && sysErr.Syscall == "bind" {
+			bindErr = err
+		}
+		return conn, err
+	}
+
+	opts := append([]grpc.DialOption{
+		grpc.WithContextDialer(dialer),
+		grpc.WithDisableRetry(),
+	}, dialOpts...)
+
+	conn, err := grpc.NewClient("passthrough:///"+server, opts...)
+	if err != nil {
+		probe.result, probe.detail = aclSkipped, err.Error()
+		return probe
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	stream, err := mdt_dialout.NewGRPCMdtDialoutClient(conn).MdtDialout(ctx, grpc.WaitForReady(false))
+	if err == nil {
+		err = stream.Send(&mdt_dialout.MdtDialoutArgs{ReqId: 1, Data: payload})
+	}
+	if err == nil {
+		// A collector that accepts the device keeps reading until the stream
+		// closes; one that rejects it ends the RPC with an error
+		_, err = stream.CloseAndRecv()
+	}
+	probe.latency = time.Since(start)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch {
+	case bindErr != nil:
+		probe.result, probe.detail = aclSkipped, bindErr.Error()
+	case err == nil || errors.Is(err, io.EOF):
+		probe.result, probe.detail = aclAccepted, "stream completed"
+	default:
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.DeadlineExceeded:
+			if !connected {
+				probe.result, probe.detail = aclRejected, "connection timed out (silently dropped)"
+				break
+			}
+			probe.result, probe.detail = aclAccepted, "stream held open"
+		case codes.Internal:
+			// Collectors that never reply to the client stream end it with an
+			// empty OK status, which gRPC reports as a cardinality violation
+			probe.result, probe.detail = aclAccepted, "stream completed without reply"
+		default:
+			probe.result, probe.detail = aclRejected, fmt.Sprintf("%s: %s", st.Code(), st.Message())
+		}
+	}
+	return probe
+}
+
+// aclOptions are the flags of the acl-probe command
+type aclOptions struct {
+	server     string
+	nodeID     string
+	configPath string
+	sources    string
+	ports      string
+	timeout    time.Duration
+	csvPath    string
+}
+
+func newACLProbeCmd() *cobra.Command {
+	var o aclOptions
+	cmd := &cobra.Command{
+		Use:   "acl-probe",
+		Short: "Report which source addresses and ports the collector accepts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exitWith(runACLProbe(o))
+		},
+	}
+	fs := cmd.Flags()
+	addServerFlag(fs, &o.server)
+	fs.StringVar(&o.nodeID, "node", "leaf-101", "Simulated NX-OS leaf node-id-str")
+	fs.StringVar(&o.configPath, "config", "config/generator.yaml", "Path to YAML configuration file")
+	fs.StringVar(&o.sources, "sources", "", "Comma-separated local source addresses to dial from (default: OS choice)")
+	fs.StringVar(&o.ports, "ports", "", "Source ports to dial from, e.g. 40000-40009 (default: ephemeral)")
+	fs.DurationVar(&o.timeout, "timeout", 3*time.Second, "How long to wait for the collector's verdict per connection")
+	fs.StringVar(&o.csvPath, "csv", "", "Also write the report as CSV to this file")
+	cmd.MarkFlagFilename("config", "yaml", "yml")
+	return cmd
+}
+
+// runACLProbe cycles through source addresses and ports, dialing the
+// collector from each one, and reports which connections the collector
+// accepted or rejected. It returns the process exit code.
+func runACLProbe(o aclOptions) int {
+	cfg, err := LoadConfig(o.configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 2
+	}
+	portList, err := parsePortRange(o.ports)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid ports: %v\n", err)
+		return 2
+	}
+	creds, err := dialCredentials(cfg.TLS, o.nodeID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up TLS: %v\n", err)
+		return 2
+	}
+
+	var addrs []net.IP
+	for _, s := range strings.Split(o.sources, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			addrs = append(addrs, nil)
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			fmt.Fprintf(os.Stderr, "Invalid source address %q\n", s)
+			return 2
+		}
+		addrs = append(addrs, ip)
+	}
+
+	// Every probe sends the node's VXLAN counters as a realistic first message
+	log.SetOutput(io.Discard)
+	syslog, _ := NewSyslog(SyslogConfig{}, o.nodeID)
+	now := time.Now()
+	sim := NewSimulator(cfg, o.nodeID, 0, syslog, now)
+	payload, err := sim.BuildTelemetry(now)[0].Marshal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build telemetry: %v\n", err)
+		return 2
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	var probes []aclProbe
+	counts := make(map[string]int)
+
+	fmt.Printf("Probing collector %s from %d source addresses x %d ports\n", o.server, len(addrs), len(portList))
+	fmt.Printf("%-28s %-9s %-10s %s\n", "SOURCE", "RESULT", "LATENCY", "DETAIL")
+	for _, ip := range addrs {
+		for _, port := range portList {
+			p := probeCollector(o.server, &net.TCPAddr{IP: ip, Port: port}, dialOpts, payload, o.timeout)
+			probes = append(probes, p)
+			counts[p.result]++
+			fmt.Printf("%-28s %-9s %-10s %s\n", p.source, p.result, p.latency.Round(time.Millisecond), p.detail)
+		}
+	}
+
+	fmt.Printf("Summary: %d accepted, %d rejected, %d skipped\n",
+		counts[aclAccepted], counts[aclRejected], counts[aclSkipped])
+
+	if o.csvPath != "" {
+		if err := writeACLReport(o.csvPath, probes); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			return 2
+		}
+	}
+	return 0
+}
+
+// writeACLReport writes probe results as CSV
+func writeACLReport(path string, probes []aclProbe) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"source", "result", "latency_ms", "detail"})
+	for _, p := range probes {
+		w.Write([]string{p.source, p.result, strconv.FormatInt(p.latency.Milliseconds(), 10), p.detail})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
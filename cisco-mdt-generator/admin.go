@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"time"
+
+	"cisco-mdt-generator/pkg/admin"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// AdminService implements the gRPC admin service so test harnesses can drive
+// a running simulator: inject events, read state, tune simulation parameters
+// and follow ground-truth events
+type AdminService struct {
+	sim      *Simulator
+	scenario *ScenarioEngine
+}
+
+// NewAdminService creates an admin service for a simulator. Injected events
+// are scheduled on the given scenario engine so timed events end on their own.
+func NewAdminService(sim *Simulator, scenario *ScenarioEngine) *AdminService {
+	return &AdminService{sim: sim, scenario: scenario}
+}
+
+// InjectEvent applies a scenario action immediately
+func (a *AdminService) InjectEvent(ctx context.Context, req *admin.InjectEventRequest) (*admin.InjectEventResponse, error) {
+	ev := ScenarioEvent{
+		Action:   req.Action,
+		Target:   req.Target,
+		Duration: time.Duration(req.DurationMs) * time.Millisecond,
+		Params:   req.Params,
+	}
+
+	a.sim.Lock()
+	defer a.sim.Unlock()
+
+	if err := a.scenario.Inject(a.sim, ev, time.Now()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s: %v", req.Action, req.Target, err)
+	}
+	return &admin.InjectEventResponse{}, nil
+}
+
+// GetState returns a snapshot of the simulated device
+func (a *AdminService) GetState(ctx context.Context, req *admin.GetStateRequest) (*admin.SimulatorState, error) {
+	a.sim.Lock()
+	defer a.sim.Unlock()
+
+	s := a.sim
+	state := &admin.SimulatorState{
+		NodeID:       s.nodeID,
+		ElapsedMs:    s.lastStep.Sub(s.startTime).Milliseconds(),
+		IngressBytes: s.IngressBytes,
+		EgressBytes:  s.EgressBytes,
+		EVPN: &admin.EVPNState{
+			Type2Routes: s.EVPN.Type2Routes,
+			Type3Routes: s.EVPN.Type3Routes,
+			Type5Routes: s.EVPN.Type5Routes,
+			TotalRoutes: s.EVPN.TotalRoutes,
+		},
+		CPUPercent: s.CPU.User + s.CPU.Kernel,
+	}
+
+	for _, n := range s.BGPNeighbors {
+		state.BGPNeighbors = append(state.BGPNeighbors, &admin.BGPNeighborState{
+			Address:          n.Address,
+			RemoteAS:         n.RemoteAS,
+			State:            n.State,
+			StateCode:        n.StateCode,
+			PrefixesReceived: n.PrefixesRecv,
+			PrefixesSent:     n.PrefixesSent,
+			FlapCount:        n.FlapCount,
+			Maintenance:      n.Maintenance,
+		})
+	}
+	for _, v := range s.VNIs {
+		state.VNIs = append(state.VNIs, &admin.VNIState{
+			VNIID:          v.VNIID,
+			State:          v.State,
+			MACCount:       v.MACCount,
+			VTEPCount:      v.VTEPCount,
+			ARPCount:       v.ARPCount,
+			ARPSuppression: v.ARPSuppression,
+		})
+	}
+	for _, e := range s.Breaker.Errors() {
+		state.Subscriptions = append(state.Subscriptions, &admin.SubscriptionState{
+			Subscription: e.subscription,
+			EncodeErrors: e.errors,
+			LastError:    e.lastError,
+			Disabled:     e.disabled,
+		})
+	}
+	return state, nil
+}
+
+// UpdateConfig overlays the simulation section of the configuration at
+// runtime. Other sections shape the initial state and cannot be changed.
+func (a *AdminService) UpdateConfig(ctx context.Context, req *admin.UpdateConfigRequest) (*admin.UpdateConfigResponse, error) {
+	a.sim.Lock()
+	defer a.sim.Unlock()
+
+	// The decoder fills existing maps, so a rejected update must not share
+	// them with the live configuration
+	next := *a.sim.cfg
+	next.Simulation.Bounds = maps.Clone(next.Simulation.Bounds)
+	overlay := struct {
+		Simulation *SimulationConfig `yaml:"simulation"`
+	}{&next.Simulation}
+
+	dec := yaml.NewDecoder(strings.NewReader(req.YAML))
+	dec.KnownFields(true)
+	if err := dec.Decode(&overlay); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to parse config YAML: %v", err)
+	}
+	if err := validateConfig(&next); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid configuration: %v", err)
+	}
+
+	a.sim.cfg.Simulation = next.Simulation
+	log.Printf("Simulation parameters updated via admin service")
+	a.sim.Events.Publish(SimEvent{Time: time.Now(), Type: "config_update", Target: "simulation",
+		Detail: fmt.Sprintf("%+v", next.Simulation)})
+	return &admin.UpdateConfigResponse{}, nil
+}
+
+// GetCapture returns the last messages the node sent on its dial-out
+// streams
+func (a *AdminService) GetCapture(ctx context.Context, req *admin.GetCaptureRequest) (*admin.Capture, error) {
+	if a.sim.Capture == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "capture is disabled, set dialout.capture.size")
+	}
+	return a.sim.Capture.Snapshot(int(req.Last)), nil
+}
+
+// StreamEvents sends every simulation event until the client goes away
+func (a *AdminService) StreamEvents(req *admin.StreamEventsRequest, stream admin.Admin_StreamEventsServer) error {
+	events, cancel := a.sim.Events.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-events:
+			err := stream.Send(&admin.Event{
+				TimestampMs: ev.Time.UnixMilli(),
+				Type:        ev.Type,
+				Target:      ev.Target,
+				Detail:      ev.Detail,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
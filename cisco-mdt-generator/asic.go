@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Kinds of internal forwarding-engine errors
+const (
+	asicParity        = "parity"
+	asicECCCorrected  = "ecc_corrected"
+	asicECCUncorrect  = "ecc_uncorrected"
+	asicInterrupt     = "interrupt"
+	asicFabricCRC     = "fabric_crc"
+	defaultASICErrors = 10 // errors per minute of an asic_error event
+)
+
+// asicErrorKinds lists the kinds an asic_error event or the injector picks from
+var asicErrorKinds = []string{asicParity, asicECCCorrected, asicECCUncorrect, asicInterrupt, asicFabricCRC}
+
+// ASICState holds the internal error counters of one forwarding ASIC. They
+// are zero on a healthy switch and only ever grow.
+type ASICState struct {
+	Module   uint32
+	Instance uint32
+
+	Parity        uint64
+	ECCCorrected  uint64
+	ECCUncorrect  uint64
+	Interrupts    uint64 // error interrupts raised to the supervisor
+	FabricCRC     uint64
+	LastError     time.Time
+	LastErrorKind string
+
+	// errorRate is the errors per minute of a kind while an asic_error
+	// event is active
+	errorRate map[string]float64
+	credit    map[string]float64
+}
+
+// name returns the module/instance name an event targets
+func (a *ASICState) name() string {
+	return fmt.Sprintf("%d/%d", a.Module, a.Instance)
+}
+
+// initASICsFromConfig creates the ASICs of every module, all clean
+func initASICsFromConfig(cfg *Config) []*ASICState {
+	if !cfg.ASIC.Enabled {
+		return nil
+	}
+	var asics []*ASICState
+	for m := range cfg.ASIC.Modules {
+		for i := range cfg.ASIC.PerModule {
+			asics = append(asics, &ASICState{
+				Module:    uint32(m + 1),
+				Instance:  uint32(i),
+				errorRate: make(map[string]float64),
+				credit:    make(map[string]float64),
+			})
+		}
+	}
+	return asics
+}
+
+// FindASIC returns the ASIC with the given module/instance name, or nil
+func (s *Simulator) FindASIC(name string) *ASICState {
+	for _, a := range s.ASICs {
+		if a.name() == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// recordASICErrors counts errors of a kind, raising an error interrupt for
+// each and logging the first of a burst
+func (s *Simulator) recordASICErrors(now time.Time, a *ASICState, kind string, n uint64) {
+	if n == 0 {
+		return
+	}
+	switch kind {
+	case asicParity:
+		a.Parity += n
+	case asicECCCorrected:
+		a.ECCCorrected += n
+	case asicECCUncorrect:
+		a.ECCUncorrect += n
+	case asicFabricCRC:
+		a.FabricCRC += n
+	}
+	a.Interrupts += n
+	if now.Sub(a.LastError) > time.Minute || a.LastErrorKind != kind {
+		severity := SeverityError
+		if kind == asicECCCorrected {
+			severity = SeverityWarning
+		}
+		s.Syslog.Emit(now, severity, "MODULE", "ASIC_ERROR",
+			fmt.Sprintf("Module %d ASIC %d: %s error detected (%d)", a.Module, a.Instance, kind, n))
+	}
+	a.LastError = now
+	a.LastErrorKind = kind
+}
+
+// stepASICs raises the errors of active asic_error events and, rarely,
+// a spontaneous single error on a random ASIC
+func (s *Simulator) stepASICs(now time.Time, seconds float64) {
+	for _, a := range s.ASICs {
+		for _, kind := range asicErrorKinds {
+			rate := a.errorRate[kind]
+			if rate == 0 {
+				continue
+			}
+			a.credit[kind] += rate * seconds / 60
+			n := uint64(a.credit[kind])
+			a.credit[kind] -= float64(n)
+			s.recordASICErrors(now, a, kind, n)
+		}
+	}
+
+	mtbe := s.cfg.ASIC.MeanTimeBetweenErrors
+	if len(s.ASICs) > 0 && mtbe > 0 && s.rng.Float64() < seconds/mtbe.Seconds() {
+		a := s.ASICs[s.rng.Intn(len(s.ASICs))]
+		kind := asicErrorKinds[s.rng.Intn(len(asicErrorKinds))]
+		s.recordASICErrors(now, a, kind, 1)
+		s.event("asic_error", a.name(), "Spontaneous %s error on module %d ASIC %d", kind, a.Module, a.Instance)
+	}
+}
+
+// SetASICErrors raises errors of the given kinds at rate per minute on an
+// ASIC, or stops them with a rate of 0
+func (s *Simulator) SetASICErrors(name string, kinds []string, rate float64) error {
+	a := s.FindASIC(name)
+	if a == nil {
+		return fmt.Errorf("unknown ASIC %s", name)
+	}
+	for _, kind := range kinds {
+		a.errorRate[kind] = rate
+		if rate == 0 {
+			a.credit[kind] = 0
+		}
+	}
+	if rate > 0 {
+		s.event("asic_error", name, "Module %d ASIC %d raising %s errors at %.0f/min", a.Module, a.Instance, strings.Join(kinds, ","), rate)
+	} else {
+		s.event("asic_error_cleared", name, "Module %d ASIC %d no longer raising %s errors", a.Module, a.Instance, strings.Join(kinds, ","))
+	}
+	return nil
+}
+
+// parseASICErrorParams returns the kinds and rate of an asic_error event:
+// kind is one of the error kinds or all (default parity), rate the errors
+// per minute
+func parseASICErrorParams(ev ScenarioEvent) ([]string, float64, error) {
+	kind := ev.Param("kind", asicParity)
+	kinds := asicErrorKinds
+	if kind != "all" {
+		found := false
+		for _, k := range asicErrorKinds {
+			found = found || k == kind
+		}
+		if !found {
+			return nil, 0, fmt.Errorf("kind must be all or one of %s", strings.Join(asicErrorKinds, ", "))
+		}
+		kinds = []string{kind}
+	}
+	rate, err := strconv.ParseFloat(ev.Param("rate", strconv.Itoa(defaultASICErrors)), 64)
+	if err != nil || rate <= 0 {
+		return nil, 0, fmt.Errorf("rate must be a positive number of errors per minute")
+	}
+	return kinds, rate, nil
+}
+
+// checkASICTarget ensures an event targets a configured ASIC with usable params
+func checkASICTarget(s *Simulator, ev ScenarioEvent) error {
+	if s.FindASIC(ev.Target) == nil {
+		return fmt.Errorf("unknown ASIC %q (module/instance, e.g. 1/0)", ev.Target)
+	}
+	_, _, err := parseASICErrorParams(ev)
+	return err
+}
+
+// asicErrorAction is the asic_error scenario action
+var asicErrorAction = scenarioAction{
+	check: checkASICTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		kinds, rate, err := parseASICErrorParams(ev)
+		if err != nil {
+			return err
+		}
+		return s.SetASICErrors(ev.Target, kinds, rate)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		kinds, _, err := parseASICErrorParams(ev)
+		if err != nil {
+			return err
+		}
+		return s.SetASICErrors(ev.Target, kinds, 0)
+	},
+}
+
+// checkASIC ensures the ASIC layout is usable
+func checkASIC(cfg ASICConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Modules < 1 || cfg.PerModule < 1 {
+		return fmt.Errorf("modules and asics_per_module must be at least 1")
+	}
+	if cfg.MeanTimeBetweenErrors < 0 {
+		return fmt.Errorf("mean_time_between_errors must not be negative")
+	}
+	return nil
+}
+
+// buildASICTelemetry reports the internal error counters of every ASIC
+func buildASICTelemetry(ts uint64, nodeID string, asics []*ASICState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, a := range asics {
+		var last uint64
+		if !a.LastError.IsZero() {
+			last = uint64(a.LastError.Unix())
+		}
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.Uint32Field("module", a.Module, ts),
+				telemetry.Uint32Field("asic", a.Instance, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.Uint64Field("parity-errors", a.Parity, ts),
+				telemetry.Uint64Field("ecc-single-bit-errors", a.ECCCorrected, ts),
+				telemetry.Uint64Field("ecc-multi-bit-errors", a.ECCUncorrect, ts),
+				telemetry.Uint64Field("error-interrupts", a.Interrupts, ts),
+				telemetry.Uint64Field("fabric-crc-errors", a.FabricCRC, ts),
+				telemetry.Uint64Field("last-error-time", last, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "asic_errors",
+		EncodingPath:        "Cisco-NX-OS-device:System/ch-items/lcslot-items/LCSlot-list/lc-items/asic-items/Asic-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
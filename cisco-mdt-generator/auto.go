@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// autoParams are the --auto settings of a fabricated fabric
+type autoParams struct {
+	leafs            int
+	vnis             int
+	neighborsPerLeaf int
+	hostPorts        int
+	seed             int64
+	underlay         string
+	spineASN         uint32
+}
+
+// autoKeys documents every --auto setting and its default
+var autoKeys = map[string]string{
+	"leafs":              "16",
+	"vnis":               "50",
+	"neighbors-per-leaf": "2",
+	"host-ports":         "8",
+	"seed":               "1",
+	"underlay":           "10.1.0.0/16",
+	"spine-asn":          "65000",
+}
+
+// parseAutoParams reads --auto key=value settings over the defaults
+func parseAutoParams(settings map[string]string) (autoParams, error) {
+	for key := range settings {
+		if _, ok := autoKeys[key]; !ok {
+			keys := make([]string, 0, len(autoKeys))
+			for k := range autoKeys {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return autoParams{}, fmt.Errorf("unknown setting %q, expected one of %s", key, strings.Join(keys, ", "))
+		}
+	}
+	values := make(map[string]string)
+	for key, def := range autoKeys {
+		values[key] = def
+		if v, ok := settings[key]; ok {
+			values[key] = v
+		}
+	}
+	number := func(key string) int64 {
+		n, err := strconv.ParseInt(values[key], 10, 64)
+		if err != nil {
+			n = -1
+		}
+		return n
+	}
+
+	p := autoParams{
+		leafs:            int(number("leafs")),
+		vnis:             int(number("vnis")),
+		neighborsPerLeaf: int(number("neighbors-per-leaf")),
+		hostPorts:        int(number("host-ports")),
+		seed:             number("seed"),
+		underlay:         values["underlay"],
+		spineASN:         uint32(number("spine-asn")),
+	}
+	switch {
+	case p.leafs < 1 || p.leafs > 4096:
+		return p, fmt.Errorf("leafs must be between 1 and 4096")
+	case p.vnis < 1 || p.vnis > 4000:
+		return p, fmt.Errorf("vnis must be between 1 and 4000")
+	case p.neighborsPerLeaf < 1 || p.neighborsPerLeaf > 16:
+		return p, fmt.Errorf("neighbors-per-leaf must be between 1 and 16")
+	case p.hostPorts < 0 || p.hostPorts > 48:
+		return p, fmt.Errorf("host-ports must be between 0 and 48")
+	case p.seed < 0:
+		return p, fmt.Errorf("seed must be a non-negative number")
+	case number("spine-asn") < 1 || number("spine-asn") > 4294967295:
+		return p, fmt.Errorf("spine-asn must be between 1 and 4294967295")
+	}
+	return p, nil
+}
+
+// autoConfig fabricates a plausible eBGP EVPN fabric without a config file:
+// leafs leaf-101 onwards, each peering with neighbors-per-leaf spines over
+// /31 uplinks allocated from the underlay pool, and sharing vnis L2 VNIs from
+// 10000. The same settings and seed always fabricate the same fabric.
+func autoConfig(settings map[string]string) (*Config, error) {
+	p, err := parseAutoParams(settings)
+	if err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(p.seed))
+	cfg := DefaultConfig()
+
+	// Uplinks to the spines, then host ports with storm-control
+	cfg.Interfaces = nil
+	for s := 0; s < p.neighborsPerLeaf; s++ {
+		cfg.Interfaces = append(cfg.Interfaces, InterfaceConfig{
+			Name:      fmt.Sprintf("eth1/%d", 49+s),
+			SpeedMbps: 100000,
+		})
+	}
+	for h := 1; h <= p.hostPorts; h++ {
+		cfg.Interfaces = append(cfg.Interfaces, InterfaceConfig{
+			Name:                  fmt.Sprintf("eth1/%d", h),
+			SpeedMbps:             25000,
+			StormControlBroadcast: 1.0,
+			StormControlMulticast: 2.0,
+		})
+	}
+
+	// VNIs carry a few dozen hosts each, seen behind a handful of VTEPs
+	cfg.VNIStates = nil
+	var macs uint32
+	for v := 0; v < p.vnis; v++ {
+		mac := uint32(5 + rng.Intn(116))
+		macs += mac
+		cfg.VNIStates = append(cfg.VNIStates, VNIStateConfig{
+			VNIID:            uint32(10000 + v),
+			InitialMACCount:  mac,
+			InitialVTEPCount: uint32(min(p.leafs, 2+rng.Intn(7))),
+			InitialARPCount:  mac * uint32(85+rng.Intn(11)) / 100,
+		})
+	}
+	cfg.VXLAN.VNIID = cfg.VNIStates[0].VNIID
+
+	// Every MAC is a type-2 route, every VTEP of a VNI a type-3 route
+	cfg.EVPN = EVPNConfig{
+		Type2Routes: macs,
+		Type3Routes: uint32(p.vnis * p.leafs),
+		Type5Routes: uint32(20 + rng.Intn(181)),
+	}
+	cfg.NodeTemplates = map[string]NodeTemplateConfig{
+		"leaf": {Ranges: map[string][]float64{
+			"evpn.type2_routes": {float64(macs * 4 / 5), float64(macs * 6 / 5)},
+		}},
+	}
+
+	// The pools give each leaf its own uplinks towards the same spines
+	cfg.Pools = PoolsConfig{
+		Spines:    p.neighborsPerLeaf,
+		Underlay:  []string{p.underlay},
+		SpineASNs: []uint32{p.spineASN},
+	}
+	cfg.BGPNeighbors = make([]BGPNeighborConfig, p.neighborsPerLeaf)
+	for s := range cfg.BGPNeighbors {
+		cfg.BGPNeighbors[s] = BGPNeighborConfig{
+			InitialPrefixesRecv: uint32(2*p.leafs + rng.Intn(p.leafs+1)),
+			InitialPrefixesSent: uint32(2 + rng.Intn(8)),
+		}
+	}
+	cfg.Nodes = []NodeConfig{{ID: "leaf-%d", Count: p.leafs, First: 101, Template: "leaf"}}
+
+	if err := validateConfig(cfg); err != nil {
+		return nil, err
+	}
+	// The pool must hold an uplink to every spine for every leaf
+	if _, err := cfg.configFor(fmt.Sprintf("leaf-%d", 100+p.leafs)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Backpressure tracks collector send latency and decides how much telemetry
+// to shed and how far to stretch the collection interval
+type Backpressure struct {
+	cfg        BackpressureConfig
+	priorities Priorities
+	level      int
+	drops      classDrops
+	slowSends  uint64
+	slowTick   bool
+}
+
+// NewBackpressure creates a backpressure tracker from configuration
+func NewBackpressure(cfg BackpressureConfig, priorities Priorities) *Backpressure {
+	return &Backpressure{cfg: cfg, priorities: priorities, drops: make(classDrops)}
+}
+
+// Enabled reports whether adaptive sending is turned on
+func (b *Backpressure) Enabled() bool {
+	return b.cfg.Enabled
+}
+
+// ObserveSend records the latency of a single stream Send
+func (b *Backpressure) ObserveSend(subscription string, latency time.Duration) {
+	if !b.cfg.Enabled || latency < b.cfg.SlowSendThreshold {
+		return
+	}
+	b.slowSends++
+	b.slowTick = true
+	log.Printf("Slow send for %s: %s (threshold %s)", subscription, latency, b.cfg.SlowSendThreshold)
+}
+
+// EndTick adjusts the degradation level based on the sends of the last
+// interval. It returns true when the level changed.
+func (b *Backpressure) EndTick() bool {
+	if !b.cfg.Enabled {
+		return false
+	}
+
+	slow := b.slowTick
+	b.slowTick = false
+
+	switch {
+	case slow && b.level < b.cfg.MaxLevel:
+		b.level++
+		log.Printf("Collector backpressure detected, degrading to level %d", b.level)
+		return true
+	case !slow && b.level > 0:
+		b.level--
+		log.Printf("Collector recovered, relaxing to level %d", b.level)
+		return true
+	}
+	return false
+}
+
+// Interval returns the collection interval stretched for the current level
+func (b *Backpressure) Interval(base time.Duration) time.Duration {
+	return base * time.Duration(1+b.level)
+}
+
+// Shed drops the subscriptions of the lowest priority classes for the
+// current level, low from level 1 and normal from level 2, mirroring how
+// NX-OS sheds bulk counters before control-plane state. High priority
+// subscriptions are never shed. Every dropped message is counted per class.
+func (b *Backpressure) Shed(messages []*telemetry.Telemetry) []*telemetry.Telemetry {
+	if b.level == 0 {
+		return messages
+	}
+	shedRank := min(b.level, len(priorityClasses)-1)
+
+	kept := messages[:0]
+	for _, m := range messages {
+		if b.priorities.rank(m.SubscriptionIDStr) < shedRank {
+			b.drops.add(b.priorities, m)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// BuildTelemetry reports the generator's own shedding counters
+func (b *Backpressure) BuildTelemetry(ts uint64, nodeID string, interval time.Duration) *telemetry.Telemetry {
+	row := telemetry.RowField(
+		[]*telemetry.TelemetryField{
+			telemetry.StringField("destination-group", "default", ts),
+		},
+		[]*telemetry.TelemetryField{
+			telemetry.Uint64Field("telemetry-drops", b.drops.total(), ts),
+			telemetry.Uint64Field("low-priority-drops", b.drops[priorityLow], ts),
+			telemetry.Uint64Field("normal-priority-drops", b.drops[priorityNormal], ts),
+			telemetry.Uint64Field("high-priority-drops", b.drops[priorityHigh], ts),
+			telemetry.Uint64Field("slow-sends", b.slowSends, ts),
+			telemetry.Uint32Field("degrade-level", uint32(b.level), ts),
+			telemetry.Uint64Field("sample-interval-ms", uint64(interval.Milliseconds()), ts),
+		},
+		ts,
+	)
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "telemetry_stats",
+		EncodingPath:        "Cisco-NX-OS-device:System/telemetry-items/stats-items",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           []*telemetry.TelemetryField{row},
+	}
+}
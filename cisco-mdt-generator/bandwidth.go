@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Bandwidth counts the messages and GPB bytes sent per node and
+// subscription, for estimating the capacity telemetry collection needs. The
+// counts are served as Prometheus metrics while running and summarized in a
+// report at the end of the run. The wall time of every send to the collector
+// is counted in a histogram per node, and the gRPC connections in connStats.
+// The encoding failures of the subscriptions of every node are served from
+// its EncodeBreaker.
+type Bandwidth struct {
+	mu       sync.Mutex
+	start    time.Time
+	counts   map[bandwidthKey]*bandwidthCount
+	latency  map[string]*sendHistogram
+	conns    map[string]*connStats
+	breakers map[string]*EncodeBreaker
+}
+
+// bandwidthKey identifies the stream of one subscription of one node
+type bandwidthKey struct {
+	node, subscription string
+}
+
+// bandwidthCount holds the totals of one subscription of one node
+type bandwidthCount struct {
+	messages, bytes uint64
+}
+
+// bandwidthOptions are the accounting flags of the streaming commands
+type bandwidthOptions struct {
+	bandwidthReport string
+	metricsAddr     string
+}
+
+// NewBandwidth creates empty counters
+func NewBandwidth() *Bandwidth {
+	return &Bandwidth{
+		start:    time.Now(),
+		counts:   make(map[bandwidthKey]*bandwidthCount),
+		latency:  make(map[string]*sendHistogram),
+		conns:    make(map[string]*connStats),
+		breakers: make(map[string]*EncodeBreaker),
+	}
+}
+
+// Observe counts the messages a node sent. Observe does nothing on a nil
+// Bandwidth.
+func (b *Bandwidth) Observe(nodeID string, messages []*telemetry.Telemetry) {
+	if b == nil {
+		return
+	}
+	sizes := make([]int, len(messages))
+	for i, m := range messages {
+		sizes[i] = m.Size()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, m := range messages {
+		key := bandwidthKey{node: nodeID, subscription: m.SubscriptionIDStr}
+		c, ok := b.counts[key]
+		if !ok {
+			c = &bandwidthCount{}
+			b.counts[key] = c
+		}
+		c.messages++
+		c.bytes += uint64(sizes[i])
+	}
+}
+
+// ObserveSend counts the wall time of one send of a node to the collector.
+// ObserveSend does nothing on a nil Bandwidth.
+func (b *Bandwidth) ObserveSend(nodeID string, latency time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.latency[nodeID]
+	if !ok {
+		h = &sendHistogram{}
+		b.latency[nodeID] = h
+	}
+	h.observe(latency)
+}
+
+// bandwidthLine is one line of the report and the metrics
+type bandwidthLine struct {
+	node, subscription string
+	bandwidthCount
+}
+
+// lines returns the counts sorted by node and subscription, and the time
+// they were counted over
+func (b *Bandwidth) lines() ([]bandwidthLine, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := make([]bandwidthLine, 0, len(b.counts))
+	for key, c := range b.counts {
+		lines = append(lines, bandwidthLine{node: key.node, subscription: key.subscription, bandwidthCount: *c})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].node != lines[j].node {
+			return lines[i].node < lines[j].node
+		}
+		return lines[i].subscription < lines[j].subscription
+	})
+	return lines, time.Since(b.start)
+}
+
+// ServeHTTP serves the counts in the Prometheus text exposition format
+func (b *Bandwidth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lines, _ := b.lines()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP mdtsim_subscription_messages_total Telemetry messages sent per node and subscription.")
+	fmt.Fprintln(w, "# TYPE mdtsim_subscription_messages_total counter")
+	for _, l := range lines {
+		fmt.Fprintf(w, "mdtsim_subscription_messages_total{node=%q,subscription=%q} %d\n", l.node, l.subscription, l.messages)
+	}
+	fmt.Fprintln(w, "# HELP mdtsim_subscription_bytes_total GPB bytes of the telemetry messages sent per node and subscription.")
+	fmt.Fprintln(w, "# TYPE mdtsim_subscription_bytes_total counter")
+	for _, l := range lines {
+		fmt.Fprintf(w, "mdtsim_subscription_bytes_total{node=%q,subscription=%q} %d\n", l.node, l.subscription, l.bytes)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	nodes := make([]string, 0, len(b.latency))
+	for node := range b.latency {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	fmt.Fprintln(w, "# HELP mdtsim_send_duration_seconds Wall time of the sends of telemetry messages to the collector per node.")
+	fmt.Fprintln(w, "# TYPE mdtsim_send_duration_seconds histogram")
+	for _, node := range nodes {
+		b.latency[node].writeProm(w, node)
+	}
+	b.writeConnStats(w)
+	b.writeEncodeErrors(w)
+}
+
+// WriteReport writes the bandwidth report to path, "-" for stdout: the
+// totals and rates of every subscription of every node, then of every
+// subscription and of the whole run across nodes, with the volume a day of
+// collection takes at those rates. Paths ending in .csv get CSV, anything
+// else an aligned table.
+func (b *Bandwidth) WriteReport(path string) error {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	lines, elapsed := b.lines()
+	bySub := make(map[string]*bandwidthCount)
+	var total bandwidthCount
+	for _, l := range lines {
+		c, ok := bySub[l.subscription]
+		if !ok {
+			c = &bandwidthCount{}
+			bySub[l.subscription] = c
+		}
+		c.messages += l.messages
+		c.bytes += l.bytes
+		total.messages += l.messages
+		total.bytes += l.bytes
+	}
+	subs := make([]string, 0, len(bySub))
+	for sub := range bySub {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	for _, sub := range subs {
+		lines = append(lines, bandwidthLine{node: "*", subscription: sub, bandwidthCount: *bySub[sub]})
+	}
+	lines = append(lines, bandwidthLine{node: "*", subscription: "*", bandwidthCount: total})
+
+	if strings.HasSuffix(path, ".csv") {
+		return writeBandwidthCSV(w, lines, elapsed)
+	}
+	return writeBandwidthTable(w, lines, elapsed)
+}
+
+// rates returns the messages and bytes per second of a count over elapsed
+func (c bandwidthCount) rates(elapsed time.Duration) (float64, float64) {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return 0, 0
+	}
+	return float64(c.messages) / seconds, float64(c.bytes) / seconds
+}
+
+func writeBandwidthTable(w io.Writer, lines []bandwidthLine, elapsed time.Duration) error {
+	fmt.Fprintf(w, "Bandwidth over %s (* totals across nodes or subscriptions)\n", elapsed.Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tSUBSCRIPTION\tMESSAGES\tBYTES\tMSG/S\tBYTES/S\tAVG BYTES\tPER DAY")
+	for _, l := range lines {
+		msgRate, byteRate := l.rates(elapsed)
+		avg := 0.0
+		if l.messages > 0 {
+			avg = float64(l.bytes) / float64(l.messages)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.2f\t%.0f\t%.0f\t%s\n", l.node, l.subscription, l.messages, l.bytes,
+			msgRate, byteRate, avg, formatVolume(byteRate*86400))
+	}
+	return tw.Flush()
+}
+
+func writeBandwidthCSV(w io.Writer, lines []bandwidthLine, elapsed time.Duration) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"node", "subscription", "messages", "bytes", "messages_per_second", "bytes_per_second", "bytes_per_day"})
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	for _, l := range lines {
+		msgRate, byteRate := l.rates(elapsed)
+		cw.Write([]string{l.node, l.subscription, strconv.FormatUint(l.messages, 10), strconv.FormatUint(l.bytes, 10),
+			format(msgRate), format(byteRate), format(byteRate * 86400)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatVolume renders a byte count with a binary unit
+func formatVolume(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[i])
+}
+
+// start creates the counters when the flags ask for them and serves the
+// metrics, returning nil otherwise
+func (o bandwidthOptions) start() (*Bandwidth, error) {
+	if o.bandwidthReport == "" && o.metricsAddr == "" {
+		return nil, nil
+	}
+	b := NewBandwidth()
+	if o.metricsAddr != "" {
+		ln, err := net.Listen("tcp", o.metricsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start metrics listener: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", b)
+		go http.Serve(ln, mux)
+		log.Printf("Serving bandwidth metrics on http://%s/metrics", ln.Addr())
+	}
+	return b, nil
+}
+
+// finish writes the bandwidth report when the flags ask for one
+func (o bandwidthOptions) finish(b *Bandwidth) error {
+	if o.bandwidthReport == "" || b == nil {
+		return nil
+	}
+	if err := b.WriteReport(o.bandwidthReport); err != nil {
+		return fmt.Errorf("failed to write bandwidth report: %w", err)
+	}
+	return nil
+}
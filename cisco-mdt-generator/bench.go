@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newBenchCmd() *cobra.Command {
+	var o simOptions
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Measure the CPU cost of building and encoding one collection",
+		Long: "Builds one collection of the simulated node and benchmarks building it,\n" +
+			"encoding it with the plain encoder and encoding it with the caching encoder\n" +
+			"the streaming commands use, to size hosts for large fleets.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBench(os.Stdout, o)
+		},
+	}
+	addSimFlags(cmd, &o)
+	return cmd
+}
+
+// runBench benchmarks one collection of the node
+func runBench(w io.Writer, o simOptions) error {
+	log.SetOutput(io.Discard)
+	cfg, err := o.config()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	cfg.Syslog = SyslogConfig{}
+
+	start := time.Now()
+	sim, scenario, err := o.newNode(cfg, o.nodeID, start)
+	if err != nil {
+		return err
+	}
+	now := start.Add(o.interval)
+	scenario.Advance(sim, now)
+	sim.Step(now)
+	messages := sim.BuildTelemetry(now)
+
+	rows, size := 0, 0
+	for _, m := range messages {
+		rows += len(m.DataGpbkv)
+		size += m.Size()
+	}
+	fmt.Fprintf(w, "Node %s: %d messages, %d rows, %d bytes per collection\n", o.nodeID, len(messages), rows, size)
+
+	benchmarks := []struct {
+		name string
+		fn   func()
+	}{
+		{"build", func() { sim.BuildTelemetry(now) }},
+		{"encode", func() {
+			for _, m := range messages {
+				m.AppendTo(make([]byte, 0, m.Size()))
+			}
+		}},
+		{"encode cached", func() {
+			for _, m := range messages {
+				m.Marshal()
+			}
+		}},
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "BENCHMARK\tCOLLECTIONS\tTIME/COLLECTION\tROWS/S\tMB/S\tALLOCS/COLLECTION\tBYTES ALLOCATED\t")
+	for _, bm := range benchmarks {
+		r := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				bm.fn()
+			}
+		})
+		perOp := time.Duration(r.NsPerOp())
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.0f\t%.1f\t%d\t%d\t\n", bm.name, r.N, perOp,
+			float64(rows)/perOp.Seconds(), float64(size)/perOp.Seconds()/1e6, r.AllocsPerOp(), r.AllocedBytesPerOp())
+	}
+	return tw.Flush()
+}
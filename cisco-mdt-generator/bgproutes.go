@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"log"
+	"net/netip"
+
+	"cisco-mdt-generator/pkg/bgp"
+)
+
+// bgpRoutes numbers the routes each simulated neighbor contributes from the
+// bgp_speaker prefixes pool, shared equally by the neighbors, and tracks how
+// many were advertised, so the BGP speaker and BMP export describe the same
+// routes
+type bgpRoutes struct {
+	nodeID     string
+	pool       netip.Prefix
+	length     int
+	stride     int   // routes of the pool reserved per neighbor
+	advertised []int // routes advertised per neighbor
+	truncated  bool
+}
+
+// bgpPeer is the state of a simulated neighbor, copied under the simulator
+// lock
+type bgpPeer struct {
+	address     netip.Addr
+	as          uint32
+	established bool
+	maintenance bool
+	routes      int
+}
+
+func newBGPRoutes(sim *Simulator) *bgpRoutes {
+	cfg := sim.cfg.BGPSpeaker
+	pool := netip.MustParsePrefix(cfg.Prefixes)
+	return &bgpRoutes{
+		nodeID: sim.nodeID,
+		pool:   pool,
+		length: cfg.PrefixLength,
+		stride: (1 << (cfg.PrefixLength - pool.Bits())) / max(1, len(sim.cfg.BGPNeighbors)),
+	}
+}
+
+// bgpPeers copies the state of every simulated neighbor
+func bgpPeers(sim *Simulator) []bgpPeer {
+	sim.Lock()
+	defer sim.Unlock()
+	peers := make([]bgpPeer, len(sim.BGPNeighbors))
+	for i, n := range sim.BGPNeighbors {
+		peers[i].address, _ = netip.ParseAddr(n.Address)
+		peers[i].as = n.RemoteAS
+		peers[i].established = n.State == "Established"
+		peers[i].maintenance = n.Maintenance
+		if peers[i].established {
+			peers[i].routes = int(n.PrefixesRecv)
+		}
+	}
+	return peers
+}
+
+// bgpRouterID returns the configured router ID, or a stable one per node in
+// 10.255.0.0/16
+func bgpRouterID(cfg BGPSpeakerConfig, nodeID string) netip.Addr {
+	if id, err := netip.ParseAddr(cfg.RouterID); err == nil {
+		return id
+	}
+	h := fnv.New32a()
+	h.Write([]byte(nodeID))
+	sum := h.Sum32()
+	return netip.AddrFrom4([4]byte{10, 255, byte(sum >> 8), byte(sum)})
+}
+
+// reset forgets every advertised route, e.g. for a new session
+func (r *bgpRoutes) reset() {
+	r.advertised = nil
+}
+
+// change sets the number of routes of neighbor i and returns the routes to
+// announce and to withdraw
+func (r *bgpRoutes) change(i int, routes int) (announce, withdraw []netip.Prefix) {
+	for len(r.advertised) <= i {
+		r.advertised = append(r.advertised, 0)
+	}
+	if routes > r.stride {
+		if !r.truncated {
+			log.Printf("%s: prefixes pool holds %d routes per BGP neighbor, %d wanted", r.nodeID, r.stride, routes)
+			r.truncated = true
+		}
+		routes = r.stride
+	}
+	for k := routes; k < r.advertised[i]; k++ {
+		withdraw = append(withdraw, r.route(i, k))
+	}
+	for k := r.advertised[i]; k < routes; k++ {
+		announce = append(announce, r.route(i, k))
+	}
+	r.advertised[i] = routes
+	return announce, withdraw
+}
+
+// route returns the k-th route of neighbor i
+func (r *bgpRoutes) route(i, k int) netip.Prefix {
+	slot := uint32(i*r.stride + k)
+	base := r.pool.Addr().As4()
+	var addr [4]byte
+	binary.BigEndian.PutUint32(addr[:], binary.BigEndian.Uint32(base[:])+slot<<(32-r.length))
+	return netip.PrefixFrom(netip.AddrFrom4(addr), r.length)
+}
+
+// splitUpdate spreads prefixes over as few updates as fit in a message, as
+// NLRI of u, or as withdrawn routes when u has no path
+func splitUpdate(u *bgp.Update, as4 bool, prefixes []netip.Prefix) []*bgp.Update {
+	withdraw := len(u.ASPath) == 0
+	var updates []*bgp.Update
+	for len(prefixes) > 0 {
+		// Size with the first prefix, then add prefixes while they fit
+		msg := *u
+		if withdraw {
+			msg.Withdrawn = prefixes[:1]
+		} else {
+			msg.NLRI = prefixes[:1]
+		}
+		size, n := msg.Len(as4), 1
+		for n < len(prefixes) && size+bgp.PrefixLen(prefixes[n]) <= bgp.MaxMessageLen {
+			size += bgp.PrefixLen(prefixes[n])
+			n++
+		}
+		if withdraw {
+			msg.Withdrawn = prefixes[:n]
+		} else {
+			msg.NLRI = prefixes[:n]
+		}
+		updates = append(updates, &msg)
+		prefixes = prefixes[n:]
+	}
+	return updates
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/bgp"
+)
+
+// bgpConnectRetry is the wait before reconnecting a failed session
+const bgpConnectRetry = 5 * time.Second
+
+// bgpSpeaker peers with a route monitor and advertises, for every simulated
+// neighbor that is established, as many routes as the neighbor's simulated
+// prefixes-received, so BGP feeds and telemetry agree. Each neighbor's routes
+// carry its address as next hop and its AS after the local AS in the path.
+type bgpSpeaker struct {
+	cfg      BGPSpeakerConfig
+	sim      *Simulator
+	routerID netip.Addr
+	routes   *bgpRoutes
+
+	mu sync.Mutex // serializes writes to the session
+}
+
+func newBGPSpeaker(sim *Simulator) *bgpSpeaker {
+	return &bgpSpeaker{
+		cfg:      sim.cfg.BGPSpeaker,
+		sim:      sim,
+		routerID: bgpRouterID(sim.cfg.BGPSpeaker, sim.nodeID),
+		routes:   newBGPRoutes(sim),
+	}
+}
+
+// runBGPSpeaker keeps the session of a node up until ctx is done, syncing
+// routes every interval
+func runBGPSpeaker(ctx context.Context, sim *Simulator, interval time.Duration) {
+	s := newBGPSpeaker(sim)
+	for {
+		err := s.session(ctx, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("%s: bgp speaker: %v, reconnecting in %s", sim.nodeID, err, bgpConnectRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(bgpConnectRetry):
+		}
+	}
+}
+
+// session opens one session, advertises the current routes and follows the
+// simulation until the session fails or ctx is done
+func (s *bgpSpeaker) session(ctx context.Context, interval time.Duration) error {
+	dialer := net.Dialer{Timeout: s.cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.cfg.Peer)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		s.write(conn, bgp.Cease.Marshal())
+		conn.Close()
+	})
+	defer stop()
+
+	// OPEN and KEEPALIVE exchange
+	hold := uint16(s.cfg.HoldTime / time.Second)
+	open := &bgp.Open{AS: s.cfg.LocalAS, HoldTime: hold, RouterID: s.routerID}
+	if err := s.write(conn, open.Marshal()); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(s.cfg.Timeout))
+	typ, body, err := bgp.ReadMessage(conn)
+	if err != nil {
+		return err
+	}
+	if typ != bgp.MsgOpen {
+		return fmt.Errorf("expected open, got message type %d", typ)
+	}
+	peer, err := bgp.ParseOpen(body)
+	if err != nil {
+		return err
+	}
+	hold = min(hold, peer.HoldTime)
+	if err := s.write(conn, bgp.Keepalive()); err != nil {
+		return err
+	}
+	if typ, _, err = bgp.ReadMessage(conn); err != nil {
+		return err
+	}
+	if typ != bgp.MsgKeepalive {
+		return fmt.Errorf("expected keepalive, got message type %d", typ)
+	}
+	log.Printf("%s: bgp speaker established with %s (AS %d, router ID %s, hold %ds)",
+		s.sim.nodeID, s.cfg.Peer, peer.AS, peer.RouterID, hold)
+
+	// Messages from the monitor only keep the session alive
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			deadline := time.Time{}
+			if hold > 0 {
+				deadline = time.Now().Add(time.Duration(hold) * time.Second)
+			}
+			conn.SetReadDeadline(deadline)
+			if _, _, err := bgp.ReadMessage(conn); err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					err = errors.New("hold timer expired")
+				}
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	// Start from an empty RIB, then mark the end of the initial routes
+	s.routes.reset()
+	if err := s.sync(conn, peer.AS4); err != nil {
+		return err
+	}
+	if err := s.write(conn, (&bgp.Update{}).Marshal(peer.AS4)); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var keepalive <-chan time.Time
+	if hold > 0 {
+		t := time.NewTicker(time.Duration(hold) * time.Second / 3)
+		defer t.Stop()
+		keepalive = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case <-keepalive:
+			if err := s.write(conn, bgp.Keepalive()); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := s.sync(conn, peer.AS4); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sync advertises and withdraws routes so each neighbor has as many routes
+// as it has prefixes received, and none while it is not established
+func (s *bgpSpeaker) sync(conn net.Conn, as4 bool) error {
+	var withdrawn []netip.Prefix
+	for i, p := range bgpPeers(s.sim) {
+		announce, withdraw := s.routes.change(i, p.routes)
+		withdrawn = append(withdrawn, withdraw...)
+
+		nextHop := p.address
+		if !nextHop.Is4() {
+			nextHop = s.routerID
+		}
+		if err := s.announce(conn, as4, &bgp.Update{
+			Origin:  bgp.OriginIGP,
+			ASPath:  []uint32{s.cfg.LocalAS, p.as},
+			NextHop: nextHop,
+		}, announce); err != nil {
+			return err
+		}
+	}
+	return s.announce(conn, as4, &bgp.Update{}, withdrawn)
+}
+
+// announce sends the prefixes as NLRI of u, or as withdrawn routes when u
+// has no path
+func (s *bgpSpeaker) announce(conn net.Conn, as4 bool, u *bgp.Update, prefixes []netip.Prefix) error {
+	for _, msg := range splitUpdate(u, as4, prefixes) {
+		if err := s.write(conn, msg.Marshal(as4)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *bgpSpeaker) write(conn net.Conn, msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(s.cfg.Timeout))
+	_, err := conn.Write(msg)
+	return err
+}
+
+// checkBGPSpeaker ensures the speaker has a peer, an AS and a usable pool.
+// BMP export needs the same settings but no peer.
+func checkBGPSpeaker(cfg BGPSpeakerConfig) error {
+	if _, _, err := net.SplitHostPort(cfg.Peer); cfg.Enabled && err != nil {
+		return fmt.Errorf("peer %q must be host:port", cfg.Peer)
+	}
+	if cfg.LocalAS == 0 {
+		return fmt.Errorf("local_as must be set")
+	}
+	if cfg.RouterID != "" {
+		if addr, err := netip.ParseAddr(cfg.RouterID); err != nil || !addr.Is4() {
+			return fmt.Errorf("router_id %q is not an IPv4 address", cfg.RouterID)
+		}
+	}
+	pool, err := netip.ParsePrefix(cfg.Prefixes)
+	if err != nil || !pool.Addr().Is4() || pool != pool.Masked() {
+		return fmt.Errorf("prefixes %q is not an IPv4 prefix", cfg.Prefixes)
+	}
+	if cfg.PrefixLength < pool.Bits() || cfg.PrefixLength > 32 {
+		return fmt.Errorf("prefix_length must be between %d and 32", pool.Bits())
+	}
+	if cfg.HoldTime != 0 && (cfg.HoldTime < 3*time.Second || cfg.HoldTime > 65535*time.Second) {
+		return fmt.Errorf("hold_time must be 0 or between 3s and 65535s")
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
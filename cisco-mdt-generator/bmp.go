@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/bgp"
+	"cisco-mdt-generator/pkg/bmp"
+)
+
+// bmpExporter reports the simulated BGP neighbors of a node to a BMP
+// station: peer up and down as sessions change state, and route monitoring
+// of the routes each neighbor advertises, numbered like the BGP speaker's
+type bmpExporter struct {
+	cfg      BMPConfig
+	sim      *Simulator
+	localAS  uint32
+	routerID netip.Addr
+	routes   *bgpRoutes
+	up       []bool // peers reported up on the current session
+
+	mu sync.Mutex // serializes writes to the session
+}
+
+// runBMP keeps the station session of a node up until ctx is done,
+// reporting changes every interval
+func runBMP(ctx context.Context, sim *Simulator, interval time.Duration) {
+	e := &bmpExporter{
+		cfg:      sim.cfg.BMP,
+		sim:      sim,
+		localAS:  sim.cfg.BGPSpeaker.LocalAS,
+		routerID: bgpRouterID(sim.cfg.BGPSpeaker, sim.nodeID),
+		routes:   newBGPRoutes(sim),
+	}
+	for {
+		err := e.session(ctx, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("%s: bmp: %v, reconnecting in %s", sim.nodeID, err, bgpConnectRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(bgpConnectRetry):
+		}
+	}
+}
+
+// session sends the initiation and the current state, then follows the
+// simulation until the station closes the session or ctx is done
+func (e *bmpExporter) session(ctx context.Context, interval time.Duration) error {
+	dialer := net.Dialer{Timeout: e.cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", e.cfg.Station)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		e.write(conn, bmp.Termination(bmp.TerminationAdminClose, "simulator stopped"))
+		conn.Close()
+	})
+	defer stop()
+
+	descr := fmt.Sprintf("Cisco Nexus Operating System (NX-OS) Software, Version %s", e.sim.Version())
+	if err := e.write(conn, bmp.Initiation(e.sim.nodeID, descr)); err != nil {
+		return err
+	}
+	log.Printf("%s: bmp session established with %s", e.sim.nodeID, e.cfg.Station)
+
+	// Stations send nothing, so a read only returns when the session closes
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, conn)
+		if err == nil {
+			err = io.EOF
+		}
+		closed <- err
+	}()
+
+	e.routes.reset()
+	e.up = nil
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.sync(conn, time.Now()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-closed:
+			return fmt.Errorf("station closed the session: %w", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync reports peers changing state and the routes that changed since the
+// last call
+func (e *bmpExporter) sync(conn net.Conn, now time.Time) error {
+	for i, p := range bgpPeers(e.sim) {
+		if len(e.up) <= i {
+			e.up = append(e.up, false)
+		}
+		if !p.address.Is4() {
+			continue
+		}
+		h := &bmp.PeerHeader{Address: p.address, AS: p.as, BGPID: p.address, Time: now}
+		initial := false
+
+		switch {
+		case e.up[i] && !p.established:
+			// A spine in maintenance shuts the session administratively,
+			// anything else is a lost session
+			notification := &bgp.Notification{Code: 4} // hold timer expired
+			if p.maintenance {
+				notification = bgp.Cease
+			}
+			if err := e.write(conn, bmp.PeerDown(h, bmp.DownRemoteNotification, notification.Marshal())); err != nil {
+				return err
+			}
+			e.routes.change(i, 0)
+			e.up[i] = false
+			continue
+		case !e.up[i] && p.established:
+			sent := &bgp.Open{AS: e.localAS, HoldTime: 180, RouterID: e.routerID, AS4: true}
+			received := &bgp.Open{AS: p.as, HoldTime: 180, RouterID: p.address, AS4: true}
+			if err := e.write(conn, bmp.PeerUp(h, e.routerID, uint16(32768+i), bgp.Port, sent.Marshal(), received.Marshal())); err != nil {
+				return err
+			}
+			e.up[i] = true
+			initial = true
+		case !p.established:
+			continue
+		}
+
+		// Routes as received from the neighbor, before the local AS is added
+		announce, withdraw := e.routes.change(i, p.routes)
+		updates := splitUpdate(&bgp.Update{Origin: bgp.OriginIGP, ASPath: []uint32{p.as}, NextHop: p.address}, true, announce)
+		updates = append(updates, splitUpdate(&bgp.Update{}, true, withdraw)...)
+		if initial {
+			updates = append(updates, &bgp.Update{}) // End-of-RIB of the initial dump
+		}
+		for _, u := range updates {
+			if err := e.write(conn, bmp.RouteMonitoring(h, u.Marshal(true))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *bmpExporter) write(conn net.Conn, msg []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(e.cfg.Timeout))
+	_, err := conn.Write(msg)
+	return err
+}
+
+// checkBMP ensures an enabled export has a station
+func checkBMP(cfg BMPConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(cfg.Station); err != nil {
+		return fmt.Errorf("station %q must be host:port", cfg.Station)
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
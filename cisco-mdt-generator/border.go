@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Modes of an external peer: the whole Internet table or only a default route
+const (
+	borderFullTable   = "full-table"
+	borderDefaultOnly = "default-only"
+)
+
+// defaultFullTablePrefixes is the size of an IPv4 full table
+const defaultFullTablePrefixes = 1000000
+
+// BorderState is the external connectivity of a border leaf
+type BorderState struct {
+	Peers []*ExternalPeer
+	Leaks []*VRFLeak
+}
+
+// ExternalPeer is an eBGP session of a border leaf with a router outside
+// the fabric, such as an ISP edge or a WAN router
+type ExternalPeer struct {
+	VRF      string
+	Address  string
+	RemoteAS uint32
+	Mode     string // full-table or default-only
+
+	State        string
+	StateCode    uint32
+	TablePfx     uint32 // prefixes the peer advertises once the table is loaded
+	PrefixesRecv uint32
+	PrefixesSent uint32
+	Uptime       uint64
+	LastFlap     time.Time
+	FlapCount    uint32
+
+	// Down is set while an isp_flap event holds the session down
+	Down bool
+}
+
+// VRFLeak counts the routes of one VRF leaked into another
+type VRFLeak struct {
+	From, To  string
+	Routes    uint32 // leaked routes installed in the target VRF
+	Static    uint32 // leaked when the source VRF has no external peers
+	MaxRoutes uint32 // 0 for no limit
+	Updates   uint64 // leaked route installs and withdrawals
+}
+
+// initBorderFromConfig creates the external peers and VRF leaks of a border
+// leaf, or returns nil when the node is not one. Peers start Established
+// with their table loaded.
+func initBorderFromConfig(cfg *Config, now time.Time, rng *rand.Rand) *BorderState {
+	if !cfg.Border.Enabled {
+		return nil
+	}
+	b := &BorderState{}
+	for _, pc := range cfg.Border.Peers {
+		p := &ExternalPeer{
+			VRF:       pc.VRF,
+			Address:   pc.Address,
+			RemoteAS:  pc.RemoteAS,
+			Mode:      pc.Mode,
+			State:     "Established",
+			StateCode: 6,
+			TablePfx:  1,
+			LastFlap:  now,
+		}
+		if p.VRF == "" {
+			p.VRF = "default"
+		}
+		if p.Mode == "" {
+			p.Mode = borderFullTable
+		}
+		if p.Mode == borderFullTable {
+			p.TablePfx = pc.Prefixes
+			if p.TablePfx == 0 {
+				p.TablePfx = defaultFullTablePrefixes
+			}
+		}
+		p.PrefixesRecv = p.TablePfx
+		b.Peers = append(b.Peers, p)
+	}
+	for _, lc := range cfg.Border.Leaks {
+		b.Leaks = append(b.Leaks, &VRFLeak{From: lc.From, To: lc.To, Static: lc.Routes, MaxRoutes: lc.MaxRoutes})
+	}
+	b.stepLeaks(rng)
+	return b
+}
+
+// FindExternalPeer returns the external peer with the given address, or nil
+func (s *Simulator) FindExternalPeer(address string) *ExternalPeer {
+	if s.Border == nil {
+		return nil
+	}
+	for _, p := range s.Border.Peers {
+		if p.Address == address {
+			return p
+		}
+	}
+	return nil
+}
+
+// stepBorder loads the tables of external peers that came up, churns the
+// full tables and follows with the leaked routes. The fabric advertises its
+// type-5 prefixes to every established peer.
+func (s *Simulator) stepBorder(now time.Time, seconds float64) {
+	if s.Border == nil {
+		return
+	}
+	rate := float64(s.cfg.Border.TableLoadRate)
+	for _, p := range s.Border.Peers {
+		if p.State != "Established" {
+			continue
+		}
+		p.Uptime = uint64(now.Sub(p.LastFlap).Seconds())
+		p.PrefixesSent = s.EVPN.Type5Routes
+		switch {
+		case p.PrefixesRecv < p.TablePfx:
+			// The table arrives at the rate the peer sends updates
+			p.PrefixesRecv = min(p.TablePfx, p.PrefixesRecv+uint32(rate*seconds)+1)
+		case p.Mode == borderFullTable:
+			// The Internet table changes by a few hundred prefixes at a time
+			churn := int(p.TablePfx / 5000)
+			p.TablePfx = uint32(max(1, int(p.TablePfx)+s.rng.Intn(2*churn+1)-churn))
+			p.PrefixesRecv = p.TablePfx
+		}
+	}
+	s.Border.stepLeaks(s.rng)
+}
+
+// stepLeaks sets the routes leaked from every VRF: those received from its
+// established external peers, or the static count of a VRF without peers,
+// up to the leak's limit
+func (b *BorderState) stepLeaks(rng *rand.Rand) {
+	for _, l := range b.Leaks {
+		routes, peered := uint64(0), false
+		for _, p := range b.Peers {
+			if p.VRF != l.From {
+				continue
+			}
+			peered = true
+			if p.State == "Established" {
+				routes += uint64(p.PrefixesRecv)
+			}
+		}
+		if !peered {
+			routes = uint64(l.Static)
+		}
+		if l.MaxRoutes > 0 {
+			routes = min(routes, uint64(l.MaxRoutes))
+		}
+		routes = min(routes, uint64(^uint32(0)))
+
+		// Every installed or withdrawn route is an update, plus the
+		// background churn of routes replaced in place
+		if routes > uint64(l.Routes) {
+			l.Updates += routes - uint64(l.Routes)
+		} else {
+			l.Updates += uint64(l.Routes) - routes
+		}
+		if routes > 0 {
+			l.Updates += uint64(rng.Intn(int(routes/10000) + 2))
+		}
+		l.Routes = uint32(routes)
+	}
+}
+
+// StartISPFlap drops an external session and holds it down, withdrawing the
+// peer's table and the routes leaked from it
+func (s *Simulator) StartISPFlap(address string, now time.Time) error {
+	p := s.FindExternalPeer(address)
+	if p == nil {
+		return fmt.Errorf("unknown external peer %s", address)
+	}
+	if p.Down {
+		return nil
+	}
+	p.Down = true
+	p.State = "Idle"
+	p.StateCode = 1
+	p.PrefixesRecv = 0
+	p.PrefixesSent = 0
+	p.Uptime = 0
+	p.FlapCount++
+	p.LastFlap = now
+	s.Border.stepLeaks(s.rng)
+	s.event("isp_flap", p.Address, "External BGP peer %s (AS %d, vrf %s) DOWN, %s withdrawn (flap #%d)",
+		p.Address, p.RemoteAS, p.VRF, p.Mode, p.FlapCount)
+	return nil
+}
+
+// EndISPFlap brings an external session back up; its table loads again over
+// the following intervals
+func (s *Simulator) EndISPFlap(address string, now time.Time) error {
+	p := s.FindExternalPeer(address)
+	if p == nil {
+		return fmt.Errorf("unknown external peer %s", address)
+	}
+	if !p.Down {
+		return nil
+	}
+	p.Down = false
+	p.State = "Established"
+	p.StateCode = 6
+	p.LastFlap = now
+	s.event("isp_recover", p.Address, "External BGP peer %s (AS %d, vrf %s) RECOVERED to Established, loading %d prefixes",
+		p.Address, p.RemoteAS, p.VRF, p.TablePfx)
+	return nil
+}
+
+// checkExternalPeerTarget ensures an event targets a configured external peer
+func checkExternalPeerTarget(s *Simulator, ev ScenarioEvent) error {
+	if s.FindExternalPeer(ev.Target) == nil {
+		return fmt.Errorf("unknown external peer %q", ev.Target)
+	}
+	return nil
+}
+
+// ispFlapAction is the isp_flap scenario action
+var ispFlapAction = scenarioAction{
+	check: checkExternalPeerTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.StartISPFlap(ev.Target, now)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.EndISPFlap(ev.Target, now)
+	},
+}
+
+// checkBorder ensures the external peers and VRF leaks of a border leaf are usable
+func checkBorder(cfg BorderConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if len(cfg.Peers) == 0 {
+		return fmt.Errorf("a border leaf needs at least one peer")
+	}
+	seen := make(map[string]bool)
+	for _, p := range cfg.Peers {
+		if net.ParseIP(p.Address) == nil {
+			return fmt.Errorf("peer address %q is not an IP address", p.Address)
+		}
+		if seen[p.Address] {
+			return fmt.Errorf("duplicate peer %s", p.Address)
+		}
+		seen[p.Address] = true
+		if p.Mode != "" && p.Mode != borderFullTable && p.Mode != borderDefaultOnly {
+			return fmt.Errorf("peer %s: mode must be %s or %s", p.Address, borderFullTable, borderDefaultOnly)
+		}
+	}
+	for _, l := range cfg.Leaks {
+		if l.From == "" || l.To == "" || l.From == l.To {
+			return fmt.Errorf("vrf_leaks: from and to must name two different VRFs")
+		}
+	}
+	if cfg.TableLoadRate == 0 {
+		return fmt.Errorf("table_load_rate must be positive")
+	}
+	return nil
+}
+
+// buildExternalBGPTelemetry reports the external sessions of a border leaf
+func buildExternalBGPTelemetry(ts uint64, nodeID string, peers []*ExternalPeer) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, p := range peers {
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("vrf-name", p.VRF, ts),
+				telemetry.StringField("neighbor-address", p.Address, ts),
+				telemetry.Uint32Field("remote-as", p.RemoteAS, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("state", p.State, ts),
+				telemetry.Uint32Field("state-code", p.StateCode, ts),
+				telemetry.StringField("table-mode", p.Mode, ts),
+				telemetry.Uint32Field("prefixes-received", p.PrefixesRecv, ts),
+				telemetry.Uint32Field("prefixes-sent", p.PrefixesSent, ts),
+				telemetry.Uint64Field("uptime-seconds", p.Uptime, ts),
+				telemetry.Uint32Field("flap-count", p.FlapCount, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "external_bgp",
+		EncodingPath:        "Cisco-NX-OS-device:System/bgp-items/inst-items/dom-items/Dom-list/peer-items/Peer-list/ent-items/PeerEntry-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildVRFLeakTelemetry reports the routes leaked between VRFs
+func buildVRFLeakTelemetry(ts uint64, nodeID string, leaks []*VRFLeak) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, l := range leaks {
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("vrf-name", l.To, ts),
+				telemetry.StringField("source-vrf", l.From, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.Uint32Field("leaked-routes", l.Routes, ts),
+				telemetry.Uint32Field("max-routes", l.MaxRoutes, ts),
+				telemetry.Uint64Field("leak-updates", l.Updates, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "vrf_route_leaking",
+		EncodingPath:        "Cisco-NX-OS-device:System/urib-items/table4-items/Table4-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
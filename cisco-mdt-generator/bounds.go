@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Behaviors of a gauge at its bounds
+const (
+	boundClamp   = "clamp"   // stay at the bound
+	boundReflect = "reflect" // bounce back by the overshoot
+	boundWrap    = "wrap"    // continue from the other bound
+)
+
+// boundedMetrics lists the gauges that take bounds
+var boundedMetrics = []string{
+	"bgp_prefixes_received",
+	"evpn_type2_routes",
+	"evpn_type3_routes",
+	"evpn_type5_routes",
+	"vni_mac_count",
+	"vni_arp_count",
+	"cpu_user",
+	"cpu_kernel",
+}
+
+// BoundConfig limits a gauge to [Min, Max] and selects what happens when a
+// step would cross a limit
+type BoundConfig struct {
+	Min      float64 `yaml:"min"`
+	Max      float64 `yaml:"max"`      // 0 for no ceiling
+	Behavior string  `yaml:"behavior"` // clamp (default), reflect or wrap
+}
+
+// bound returns the bounds of a gauge: those configured, or a floor of zero
+func (s *Simulator) bound(metric string) BoundConfig {
+	return s.cfg.Simulation.Bounds[metric]
+}
+
+// walk moves a gauge by a random step of up to ±delta within its bounds
+func (s *Simulator) walk(metric string, value uint32, delta int) uint32 {
+	step := s.rng.Intn(delta*2+1) - delta
+	return uint32(math.Round(s.bound(metric).apply(float64(value) + float64(step))))
+}
+
+// apply brings a value that crossed a bound back within [Min, Max]
+func (b BoundConfig) apply(v float64) float64 {
+	lo, hi := b.Min, b.Max
+	if hi <= lo {
+		hi = math.Inf(1)
+	}
+	if v >= lo && v <= hi {
+		return v
+	}
+
+	switch b.Behavior {
+	case boundReflect:
+		if v < lo {
+			v = lo + (lo - v)
+		} else {
+			v = hi - (v - hi)
+		}
+	case boundWrap:
+		if !math.IsInf(hi, 1) {
+			v = lo + math.Mod(math.Mod(v-lo, hi-lo)+hi-lo, hi-lo)
+		}
+	}
+	// An overshoot larger than the range is clamped either way
+	return min(max(v, lo), hi)
+}
+
+// checkBounds validates the configured bounds
+func checkBounds(bounds map[string]BoundConfig) error {
+	names := make([]string, 0, len(bounds))
+	for name := range bounds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		b := bounds[name]
+		if !slices.Contains(boundedMetrics, name) {
+			return fmt.Errorf("bounds: unknown metric %q (one of %s)", name, strings.Join(boundedMetrics, ", "))
+		}
+		switch b.Behavior {
+		case "", boundClamp, boundReflect:
+		case boundWrap:
+			if b.Max == 0 {
+				return fmt.Errorf("bounds: %s: wrap needs a max", name)
+			}
+		default:
+			return fmt.Errorf("bounds: %s: behavior must be clamp, reflect or wrap", name)
+		}
+		if b.Min < 0 {
+			return fmt.Errorf("bounds: %s: min must be non-negative", name)
+		}
+		if b.Max != 0 && b.Max <= b.Min {
+			return fmt.Errorf("bounds: %s: max must be greater than min", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// EncodeBreaker counts the encoding failures of every subscription of a
+// node. A subscription whose messages fail to encode threshold times in a
+// row is disabled for the cooldown and then retried once: a message that
+// encodes enables it again, another failure disables it for another
+// cooldown. Only the first failure of a streak and changes of state are
+// logged, so a broken generator does not flood the log.
+type EncodeBreaker struct {
+	cfg    EncodeBreakerConfig
+	nodeID string
+
+	mu   sync.Mutex
+	subs map[string]*encodeState
+}
+
+// encodeState is the failure history of one subscription
+type encodeState struct {
+	errors    uint64    // messages that failed to encode
+	streak    int       // failures since the last message that encoded
+	lastError string    // of the latest failure
+	until     time.Time // end of the cooldown, zero while enabled
+}
+
+// subscriptionErrors is a snapshot of the failures of one subscription
+type subscriptionErrors struct {
+	subscription string
+	errors       uint64
+	lastError    string
+	disabled     bool
+}
+
+// NewEncodeBreaker creates the breaker of a node
+func NewEncodeBreaker(cfg EncodeBreakerConfig, nodeID string) *EncodeBreaker {
+	return &EncodeBreaker{cfg: cfg, nodeID: nodeID, subs: make(map[string]*encodeState)}
+}
+
+// Filter removes the messages of disabled subscriptions. Once its cooldown
+// has passed a subscription is let through again for a retry. A nil breaker
+// keeps every message.
+func (b *EncodeBreaker) Filter(messages []*telemetry.Telemetry) []*telemetry.Telemetry {
+	if b == nil {
+		return messages
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	kept := messages[:0]
+	for _, m := range messages {
+		if s, ok := b.subs[m.SubscriptionIDStr]; !ok || !now.Before(s.until) {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// Record counts the outcome of encoding one message of a subscription.
+// Record does nothing on a nil breaker.
+func (b *EncodeBreaker) Record(subscription string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.subs[subscription]
+	if err == nil {
+		if ok && !s.until.IsZero() {
+			log.Printf("%s: subscription %s encodes again, re-enabled", b.nodeID, subscription)
+		}
+		if ok {
+			s.streak, s.until = 0, time.Time{}
+		}
+		return
+	}
+
+	if !ok {
+		s = &encodeState{}
+		b.subs[subscription] = s
+	}
+	s.errors++
+	s.streak++
+	s.lastError = err.Error()
+	if s.streak == 1 {
+		log.Printf("%s: failed to encode subscription %s: %v", b.nodeID, subscription, err)
+	}
+	if b.cfg.Threshold > 0 && s.streak >= b.cfg.Threshold && !time.Now().Before(s.until) {
+		s.until = time.Now().Add(b.cfg.Cooldown)
+		log.Printf("%s: subscription %s failed to encode %d times in a row, disabled for %s: %v",
+			b.nodeID, subscription, s.streak, b.cfg.Cooldown, err)
+	}
+}
+
+// Errors returns the failures of every subscription that failed to encode,
+// sorted by subscription. A nil breaker has none.
+func (b *EncodeBreaker) Errors() []subscriptionErrors {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	errs := make([]subscriptionErrors, 0, len(b.subs))
+	for sub, s := range b.subs {
+		errs = append(errs, subscriptionErrors{
+			subscription: sub,
+			errors:       s.errors,
+			lastError:    s.lastError,
+			disabled:     now.Before(s.until),
+		})
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].subscription < errs[j].subscription })
+	return errs
+}
+
+// AddBreaker serves the encoding failures of a node's subscriptions with
+// the metrics. AddBreaker does nothing on a nil Bandwidth.
+func (b *Bandwidth) AddBreaker(nodeID string, breaker *EncodeBreaker) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.breakers[nodeID] = breaker
+}
+
+// writeEncodeErrors writes the encoding failures of every node in the
+// Prometheus text exposition format. The caller holds b.mu.
+func (b *Bandwidth) writeEncodeErrors(w io.Writer) {
+	nodes := make([]string, 0, len(b.breakers))
+	for node := range b.breakers {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	errs := make(map[string][]subscriptionErrors, len(nodes))
+	for _, node := range nodes {
+		errs[node] = b.breakers[node].Errors()
+	}
+
+	fmt.Fprintln(w, "# HELP mdtsim_encode_errors_total Telemetry messages that failed to encode per node and subscription.")
+	fmt.Fprintln(w, "# TYPE mdtsim_encode_errors_total counter")
+	for _, node := range nodes {
+		for _, e := range errs[node] {
+			fmt.Fprintf(w, "mdtsim_encode_errors_total{node=%q,subscription=%q} %d\n", node, e.subscription, e.errors)
+		}
+	}
+	fmt.Fprintln(w, "# HELP mdtsim_subscription_disabled Whether a subscription is disabled after failing to encode repeatedly.")
+	fmt.Fprintln(w, "# TYPE mdtsim_subscription_disabled gauge")
+	for _, node := range nodes {
+		for _, e := range errs[node] {
+			disabled := 0
+			if e.disabled {
+				disabled = 1
+			}
+			fmt.Fprintf(w, "mdtsim_subscription_disabled{node=%q,subscription=%q} %d\n", node, e.subscription, disabled)
+		}
+	}
+}
+
+// checkEncodeBreaker ensures the breaker settings are usable
+func checkEncodeBreaker(c EncodeBreakerConfig) error {
+	if c.Threshold < 0 {
+		return fmt.Errorf("threshold must be non-negative")
+	}
+	if c.Threshold > 0 && c.Cooldown <= 0 {
+		return fmt.Errorf("cooldown must be positive")
+	}
+	return nil
+}
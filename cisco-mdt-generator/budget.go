@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// budgetCheckInterval is how often usage is compared with the budget
+	budgetCheckInterval = 5 * time.Second
+	// budgetMaxStretch bounds how far intervals are stretched
+	budgetMaxStretch = 16
+)
+
+// Budget caps the CPU and memory of the process, so a simulator sharing a
+// test host with collectors cannot starve them. Over budget, every node's
+// interval is stretched, which lowers the message rate; the memory budget
+// also limits how many nodes a fleet starts.
+type Budget struct {
+	cfg BudgetConfig
+
+	mu      sync.Mutex
+	stretch int    // interval multiplier, 1 within budget
+	base    uint64 // memory in use before any node was created
+	lastCPU float64
+	lastAt  time.Time
+}
+
+// NewBudget applies the hard limits of a budget to the Go runtime: the
+// processors used for Go code and the memory limit the GC keeps to. It
+// returns nil when no budget is configured.
+func NewBudget(cfg BudgetConfig) *Budget {
+	if cfg.CPUCores <= 0 && cfg.MemoryMB <= 0 {
+		return nil
+	}
+	if cfg.CPUCores > 0 {
+		procs := int(math.Ceil(cfg.CPUCores))
+		if procs < runtime.GOMAXPROCS(0) {
+			runtime.GOMAXPROCS(procs)
+		}
+	}
+	if cfg.MemoryMB > 0 {
+		debug.SetMemoryLimit(int64(cfg.MemoryMB) << 20)
+	}
+	log.Printf("Resource budget: %s", cfg)
+	return &Budget{cfg: cfg, stretch: 1, base: memoryInUse()}
+}
+
+// Admit reports whether another node fits in the memory budget after n
+// nodes were created. Node state may use half of what the budget leaves
+// above the memory in use at the start; the other half is left for building
+// and sending messages. A nil budget admits every node.
+func (b *Budget) Admit(n int) bool {
+	if b == nil || b.cfg.MemoryMB <= 0 || n == 0 {
+		return true
+	}
+	limit := uint64(b.cfg.MemoryMB) << 20
+	used := memoryInUse()
+	if used <= b.base {
+		return true
+	}
+	if limit <= b.base {
+		return false
+	}
+	nodes := used - b.base
+	return nodes+nodes/uint64(n) <= (limit-b.base)/2
+}
+
+// Stretch returns the factor by which intervals are stretched, 1 within
+// budget and for a nil budget
+func (b *Budget) Stretch() int {
+	if b == nil {
+		return 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stretch
+}
+
+// Watch compares usage with the budget until ctx ends, doubling the stretch
+// while over budget and halving it once usage falls well below
+func (b *Budget) Watch(ctx context.Context) {
+	if b == nil {
+		return
+	}
+	b.lastCPU, b.lastAt = cpuSeconds(), time.Now()
+
+	ticker := time.NewTicker(budgetCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.check(now)
+		}
+	}
+}
+
+// check measures CPU and memory use since the last check and adjusts the
+// stretch
+func (b *Budget) check(now time.Time) {
+	used := cpuSeconds()
+	cores := (used - b.lastCPU) / now.Sub(b.lastAt).Seconds()
+	b.lastCPU, b.lastAt = used, now
+	memory := memoryInUse() >> 20
+
+	cpuLoad, memLoad := 0.0, 0.0
+	if b.cfg.CPUCores > 0 {
+		cpuLoad = cores / b.cfg.CPUCores
+	}
+	if b.cfg.MemoryMB > 0 {
+		memLoad = float64(memory) / float64(b.cfg.MemoryMB)
+	}
+	load := max(cpuLoad, memLoad)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case load > 1 && b.stretch < budgetMaxStretch:
+		b.stretch *= 2
+		log.Printf("Over resource budget (%.2f cores, %d MiB of %s), stretching intervals %dx",
+			cores, memory, b.cfg, b.stretch)
+	case load < 0.4 && b.stretch > 1:
+		b.stretch /= 2
+		log.Printf("Back within resource budget (%.2f cores, %d MiB), stretching intervals %dx",
+			cores, memory, b.stretch)
+	}
+}
+
+// memoryInUse returns the memory the Go runtime holds from the OS
+func memoryInUse() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// String describes the budget for logs
+func (b BudgetConfig) String() string {
+	var limits []string
+	if b.CPUCores > 0 {
+		limits = append(limits, fmt.Sprintf("%g cores", b.CPUCores))
+	}
+	if b.MemoryMB > 0 {
+		limits = append(limits, fmt.Sprintf("%d MiB", b.MemoryMB))
+	}
+	return strings.Join(limits, ", ")
+}
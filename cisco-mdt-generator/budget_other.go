@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// cpuSeconds is not measured on this platform, so the CPU budget only caps
+// the processors used for Go code
+func cpuSeconds() float64 {
+	return 0
+}
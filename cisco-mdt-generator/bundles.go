@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// sensorBundle is a built-in sensor set with the sample interval of each of
+// its subscriptions. Subscriptions without an interval are sent every
+// collection, like the event-driven config_changes and mac_mobility.
+type sensorBundle map[string]time.Duration
+
+// Sensors lists the subscriptions of a bundle in name order
+func (b sensorBundle) Sensors() []string {
+	return slices.Sorted(maps.Keys(b))
+}
+
+// with returns a copy of a bundle with more subscriptions
+func (b sensorBundle) with(more sensorBundle) sensorBundle {
+	c := maps.Clone(b)
+	maps.Copy(c, more)
+	return c
+}
+
+// leafBundle is what a VXLAN EVPN leaf typically streams: fast counters,
+// slower control-plane tables and rarely changing inventory
+var leafBundle = sensorBundle{
+	"interface_counters": 30 * time.Second,
+	"vxlan_stats":        30 * time.Second,
+	"svi_counters":       30 * time.Second,
+	"vlan_counters":      30 * time.Second,
+	"bgp_neighbors":      time.Minute,
+	"evpn_routes":        time.Minute,
+	"vni_state":          time.Minute,
+	"arp_suppression":    time.Minute,
+	"copp_stats":         time.Minute,
+	"cpu_utilization":    time.Minute,
+	"tunnel_interfaces":  time.Minute,
+	"asic_errors":        5 * time.Minute,
+	"inventory":          5 * time.Minute,
+	"mac_mobility":       0,
+	"config_changes":     0,
+}
+
+// sensorBundles are the bundles nodes select with bundle, by name
+var sensorBundles = map[string]sensorBundle{
+	"nxos-leaf-standard": leafBundle,
+	"nxos-spine-minimal": {
+		"interface_counters": 30 * time.Second,
+		"bgp_neighbors":      time.Minute,
+		"evpn_routes":        time.Minute,
+		"cpu_utilization":    time.Minute,
+		"inventory":          5 * time.Minute,
+	},
+	"border-full": leafBundle.with(sensorBundle{
+		"external_bgp":        30 * time.Second,
+		"itd_buckets":         30 * time.Second,
+		"itd_nodes":           30 * time.Second,
+		"vrf_route_leaking":   time.Minute,
+		"ecmp_groups":         time.Minute,
+		"ecmp_next_hops":      time.Minute,
+		"pbr_stats":           time.Minute,
+		"pbr_next_hops":       time.Minute,
+		"srv6_locators":       time.Minute,
+		"srv6_sids":           time.Minute,
+		"srv6_behaviors":      time.Minute,
+		"aaa_servers":         5 * time.Minute,
+		"aaa_authentication":  5 * time.Minute,
+		"mgmt_sessions":       5 * time.Minute,
+		"mgmt_login_failures": 5 * time.Minute,
+		"mgmt_acl":            5 * time.Minute,
+	}),
+}
+
+// bundleNames lists the built-in bundles, after the empty name of none
+func bundleNames() []string {
+	return append([]string{""}, slices.Sorted(maps.Keys(sensorBundles))...)
+}
+
+// checkBundle ensures a bundle name is empty or built in
+func checkBundle(name string) error {
+	if !slices.Contains(bundleNames(), name) {
+		return fmt.Errorf("unknown bundle %q, expected one of %s", name, strings.Join(bundleNames()[1:], ", "))
+	}
+	return nil
+}
+
+// checkSampleIntervals ensures sample intervals name known subscriptions
+// and are positive
+func checkSampleIntervals(intervals map[string]time.Duration, plugins []PluginConfig) error {
+	for _, sub := range slices.Sorted(maps.Keys(intervals)) {
+		if err := checkSensors([]string{sub}, plugins); err != nil {
+			return err
+		}
+		if intervals[sub] <= 0 {
+			return fmt.Errorf("subscription %s: interval must be positive", sub)
+		}
+	}
+	return nil
+}
+
+// StreamedSensors returns the subscriptions a node streams: its sensors,
+// else those of its bundle, nil for all of them
+func (c *Config) StreamedSensors() []string {
+	if len(c.Sensors) > 0 || c.Bundle == "" {
+		return c.Sensors
+	}
+	return sensorBundles[c.Bundle].Sensors()
+}
+
+// SampleInterval returns the sample interval of a subscription, set by
+// sample_intervals or the bundle; 0 sends it every collection
+func (c *Config) SampleInterval(sub string) time.Duration {
+	if d, ok := c.SampleIntervals[sub]; ok {
+		return d
+	}
+	return sensorBundles[c.Bundle][sub]
+}
+
+// useBundle selects the bundle of a template or node, which replaces the
+// inherited sensor set
+func (c *Config) useBundle(name string) {
+	if name != "" {
+		c.Bundle, c.Sensors = name, nil
+	}
+}
+
+// sample removes the messages of subscriptions collected again before their
+// sample interval has passed. A collection due slightly early still counts,
+// so wall-clock ticks do not skip a whole interval. The caller holds the
+// simulator lock.
+func (s *Simulator) sample(messages []*telemetry.Telemetry, now time.Time) []*telemetry.Telemetry {
+	return slices.DeleteFunc(messages, func(m *telemetry.Telemetry) bool {
+		interval := s.cfg.SampleInterval(m.SubscriptionIDStr)
+		if interval == 0 {
+			return false
+		}
+		if last, ok := s.sampled[m.SubscriptionIDStr]; ok && now.Sub(last) < interval-interval/10 {
+			return true
+		}
+		if s.sampled == nil {
+			s.sampled = make(map[string]time.Time)
+		}
+		s.sampled[m.SubscriptionIDStr] = now
+		return false
+	})
+}
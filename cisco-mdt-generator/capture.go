@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"cisco-mdt-generator/pkg/admin"
+	"cisco-mdt-generator/pkg/mdt_dialout"
+	"cisco-mdt-generator/pkg/recording"
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Capture keeps the last messages a node sent on its dial-out streams in a
+// ring buffer, to answer what exactly was sent at a given time without a
+// packet capture. The admin service reads it, and it is written to
+// capture.dir when a send fails.
+type Capture struct {
+	nodeID string
+	dir    string
+
+	mu    sync.Mutex
+	ring  []capturedMessage
+	next  int    // index the next message is stored at
+	total uint64 // messages captured since the start
+}
+
+// capturedMessage is one message as it was sent
+type capturedMessage struct {
+	time         time.Time
+	collector    string
+	reqID        int64
+	subscription string
+	encodingPath string
+	encoding     string
+	rows         int
+	data         []byte // payload as sent
+	errors       string // of the MdtDialoutArgs
+	sendError    string // empty when the send succeeded
+}
+
+// NewCapture creates the capture of a node, nil when capture.size is 0
+func NewCapture(cfg CaptureConfig, nodeID string) *Capture {
+	if cfg.Size == 0 {
+		return nil
+	}
+	return &Capture{nodeID: nodeID, dir: cfg.Dir, ring: make([]capturedMessage, 0, cfg.Size)}
+}
+
+// Add captures a message sent to a collector at sent and the error of the
+// send. Add does nothing on a nil Capture.
+func (c *Capture) Add(sent time.Time, collector, encoding string, telem *telemetry.Telemetry, msg *mdt_dialout.MdtDialoutArgs, sendErr error) {
+	if c == nil {
+		return
+	}
+	m := capturedMessage{
+		time:         sent,
+		collector:    collector,
+		reqID:        msg.ReqId,
+		subscription: telem.SubscriptionIDStr,
+		encodingPath: telem.EncodingPath,
+		encoding:     encoding,
+		rows:         len(telem.DataGpbkv),
+		data:         msg.Data,
+		errors:       msg.Errors,
+	}
+	if m.encoding == "" {
+		m.encoding = encodingGPBKV
+	}
+	if sendErr != nil {
+		m.sendError = sendErr.Error()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.ring) < cap(c.ring) {
+		c.ring = append(c.ring, m)
+	} else {
+		c.ring[c.next] = m
+	}
+	c.next = (c.next + 1) % cap(c.ring)
+	c.total++
+}
+
+// Snapshot returns the last n captured messages, all of them when n is 0,
+// oldest first
+func (c *Capture) Snapshot(n int) *admin.Capture {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := &admin.Capture{Size: uint32(cap(c.ring)), Total: c.total}
+	count := len(c.ring)
+	if n > 0 && n < count {
+		count = n
+	}
+	for i := len(c.ring) - count; i < len(c.ring); i++ {
+		// The oldest message is the next overwritten, or the first while
+		// the ring is filling up
+		m := c.ring[(c.next+i)%len(c.ring)]
+		s.Messages = append(s.Messages, &admin.CapturedMessage{
+			TimestampMs:  m.time.UnixMilli(),
+			Collector:    m.collector,
+			ReqID:        m.reqID,
+			Subscription: m.subscription,
+			EncodingPath: m.encodingPath,
+			Encoding:     m.encoding,
+			Rows:         uint32(m.rows),
+			Data:         m.data,
+			Errors:       m.errors,
+			SendError:    m.sendError,
+			TimestampUs:  m.time.UnixMicro(),
+		})
+	}
+	return s
+}
+
+// Dump writes the captured messages to capture.dir after a failed send: the
+// payloads as a recording and their summary next to it. Dump does nothing on
+// a nil Capture or without a directory.
+func (c *Capture) Dump() {
+	if c == nil || c.dir == "" {
+		return
+	}
+	s := c.Snapshot(0)
+	base := filepath.Join(c.dir, fmt.Sprintf("%s-%s", c.nodeID, time.Now().Format("20060102T150405")))
+	err := os.MkdirAll(c.dir, 0o755)
+	if err == nil {
+		err = writeCaptureFile(base+".rec", s, writeCaptureRecording)
+	}
+	if err == nil {
+		err = writeCaptureFile(base+".txt", s, func(w io.Writer, s *admin.Capture) error {
+			return printCapture(w, s)
+		})
+	}
+	if err != nil {
+		log.Printf("%s: failed to dump the capture: %v", c.nodeID, err)
+		return
+	}
+	log.Printf("%s: wrote the last %d messages sent to %s.rec and .txt", c.nodeID, len(s.Messages), base)
+}
+
+// writeCaptureFile writes a capture to a file with write
+func writeCaptureFile(path string, s *admin.Capture, write func(io.Writer, *admin.Capture) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeCaptureRecording writes the payloads of a capture as a recording,
+// which decode prints and replay sends again with the gaps they were sent
+// with
+func writeCaptureRecording(w io.Writer, s *admin.Capture) error {
+	rw, err := recording.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	for _, m := range s.Messages {
+		// Simulators before timestamp_us only report milliseconds
+		sent := time.UnixMicro(m.TimestampUs)
+		if m.TimestampUs == 0 {
+			sent = time.UnixMilli(m.TimestampMs)
+		}
+		if err := rw.Write(recording.Record{Timestamp: sent, Payload: m.Data}); err != nil {
+			return err
+		}
+	}
+	return rw.Flush()
+}
+
+// printCapture writes a line per captured message: when and where it was
+// sent, what it held and how the send went
+func printCapture(w io.Writer, s *admin.Capture) error {
+	fmt.Fprintf(w, "%d of %d messages sent, ring of %d\n\n", len(s.Messages), s.Total, s.Size)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tCOLLECTOR\tREQ ID\tSUBSCRIPTION\tENCODING\tROWS\tBYTES\tSTATUS")
+	for _, m := range s.Messages {
+		status := "ok"
+		switch {
+		case m.SendError != "":
+			status = "send failed: " + m.SendError
+		case m.Errors != "":
+			status = "errors: " + m.Errors
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%d\t%d\t%s\n", time.UnixMilli(m.TimestampMs).Format("2006-01-02 15:04:05.000"),
+			m.Collector, m.ReqID, m.Subscription, m.Encoding, m.Rows, len(m.Data), status)
+	}
+	return tw.Flush()
+}
+
+// checkCapture ensures the capture settings are usable
+func checkCapture(cfg CaptureConfig) error {
+	if cfg.Size < 0 {
+		return fmt.Errorf("size must be non-negative")
+	}
+	if cfg.Dir != "" && cfg.Size == 0 {
+		return fmt.Errorf("dir needs a size")
+	}
+	return nil
+}
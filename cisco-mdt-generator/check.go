@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// gaugeCeiling catches unsigned gauges that wrapped around after being
+// decremented below zero
+const gaugeCeiling = 1 << 31
+
+// invariantChecker evaluates consistency rules after every simulation step
+// and remembers counter values between steps
+type invariantChecker struct {
+	last       map[string]uint64
+	violations map[string]*violation
+}
+
+// violation records how often an invariant failed and its first occurrence
+type violation struct {
+	count int
+	first string
+	at    time.Duration
+}
+
+// invariants lists every rule checked by the check subcommand
+var invariants = []struct {
+	name  string
+	check func(c *invariantChecker, s *Simulator) []string
+}{
+	{"evpn-total-equals-type-sum", checkEVPNTotals},
+	{"gauges-not-wrapped", checkGaugeWrap},
+	{"counters-monotonic", checkCountersMonotonic},
+	{"bgp-down-has-no-prefixes", checkBGPDownState},
+	{"arp-suppressed-matches-cache-hits", checkARPSuppression},
+	{"storm-drops-within-received", checkStormDrops},
+	{"cpu-percentages-sum-to-100", checkCPU},
+}
+
+func newInvariantChecker() *invariantChecker {
+	return &invariantChecker{
+		last:       make(map[string]uint64),
+		violations: make(map[string]*violation),
+	}
+}
+
+// monotonic returns an error message when a counter went backwards
+func (c *invariantChecker) monotonic(key string, value uint64) string {
+	prev, seen := c.last[key]
+	c.last[key] = value
+	if seen && value < prev {
+		return fmt.Sprintf("%s decreased from %d to %d", key, prev, value)
+	}
+	return ""
+}
+
+// record counts a violation of the named invariant
+func (c *invariantChecker) record(name, msg string, elapsed time.Duration) {
+	v, ok := c.violations[name]
+	if !ok {
+		v = &violation{first: msg, at: elapsed}
+		c.violations[name] = v
+	}
+	v.count++
+}
+
+// run evaluates every invariant and records violations at the given offset
+func (c *invariantChecker) run(s *Simulator, elapsed time.Duration) {
+	for _, inv := range invariants {
+		for _, msg := range inv.check(c, s) {
+			if msg != "" {
+				c.record(inv.name, msg, elapsed)
+			}
+		}
+	}
+}
+
+func checkEVPNTotals(c *invariantChecker, s *Simulator) []string {
+	sum := s.EVPN.Type2Routes + s.EVPN.Type3Routes + s.EVPN.Type5Routes
+	if s.EVPN.TotalRoutes != sum {
+		return []string{fmt.Sprintf("total-routes %d != type2+type3+type5 %d", s.EVPN.TotalRoutes, sum)}
+	}
+	return nil
+}
+
+func checkGaugeWrap(c *invariantChecker, s *Simulator) []string {
+	var msgs []string
+	gauge := func(name string, v uint32) {
+		if v >= gaugeCeiling {
+			msgs = append(msgs, fmt.Sprintf("%s wrapped around to %d", name, v))
+		}
+	}
+
+	gauge("evpn type2-routes", s.EVPN.Type2Routes)
+	gauge("evpn type3-routes", s.EVPN.Type3Routes)
+	gauge("evpn type5-routes", s.EVPN.Type5Routes)
+	for _, n := range s.BGPNeighbors {
+		gauge("bgp "+n.Address+" prefixes-received", n.PrefixesRecv)
+	}
+	for _, v := range s.VNIs {
+		gauge(fmt.Sprintf("vni %d mac-count", v.VNIID), v.MACCount)
+		gauge(fmt.Sprintf("vni %d arp-count", v.VNIID), v.ARPCount)
+	}
+	return msgs
+}
+
+func checkCountersMonotonic(c *invariantChecker, s *Simulator) []string {
+	msgs := []string{
+		c.monotonic("vxlan ingress-bytes", s.IngressBytes),
+		c.monotonic("vxlan egress-bytes", s.EgressBytes),
+		c.monotonic("evpn type2-updates", uint64(s.EVPN.Type2Updates)),
+	}
+	for _, n := range s.BGPNeighbors {
+		msgs = append(msgs, c.monotonic("bgp "+n.Address+" flap-count", uint64(n.FlapCount)))
+	}
+	for _, v := range s.VNIs {
+		prefix := fmt.Sprintf("vni %d ", v.VNIID)
+		msgs = append(msgs,
+			c.monotonic(prefix+"mac-moves", uint64(v.MACMoves)),
+			c.monotonic(prefix+"requests-flooded", v.ARPFlooded),
+			c.monotonic(prefix+"requests-suppressed", v.ARPSuppressed))
+	}
+	for _, i := range s.Interfaces {
+		prefix := "interface " + i.Name + " "
+		msgs = append(msgs,
+			c.monotonic(prefix+"in-octets", i.InOctets),
+			c.monotonic(prefix+"in-bcast-pkts", i.InBcastPkts),
+			c.monotonic(prefix+"storm-control-bcast-drops", i.StormBcastDrops))
+	}
+	for _, cl := range s.CoPP {
+		msgs = append(msgs,
+			c.monotonic("copp "+cl.Name+" conformed-packets", cl.ConformedPkts),
+			c.monotonic("copp "+cl.Name+" violated-packets", cl.ViolatedPkts))
+	}
+	return msgs
+}
+
+func checkBGPDownState(c *invariantChecker, s *Simulator) []string {
+	var msgs []string
+	for _, n := range s.BGPNeighbors {
+		if n.State != "Established" && n.PrefixesRecv != 0 {
+			msgs = append(msgs, fmt.Sprintf("bgp %s is %s but reports %d prefixes received", n.Address, n.State, n.PrefixesRecv))
+		}
+		if n.State != "Established" && n.Uptime != 0 {
+			msgs = append(msgs, fmt.Sprintf("bgp %s is %s but reports uptime %ds", n.Address, n.State, n.Uptime))
+		}
+	}
+	return msgs
+}
+
+func checkARPSuppression(c *invariantChecker, s *Simulator) []string {
+	var msgs []string
+	for _, v := range s.VNIs {
+		if v.ARPSuppressed != v.ARPCacheHits {
+			msgs = append(msgs, fmt.Sprintf("vni %d requests-suppressed %d != cache-hits %d", v.VNIID, v.ARPSuppressed, v.ARPCacheHits))
+		}
+	}
+	return msgs
+}
+
+func checkStormDrops(c *invariantChecker, s *Simulator) []string {
+	var msgs []string
+	for _, i := range s.Interfaces {
+		if i.StormBcastDrops > i.InBcastPkts {
+			msgs = append(msgs, fmt.Sprintf("interface %s storm-control-bcast-drops %d > in-bcast-pkts %d", i.Name, i.StormBcastDrops, i.InBcastPkts))
+		}
+		if i.StormMcastDrops > i.InMcastPkts {
+			msgs = append(msgs, fmt.Sprintf("interface %s storm-control-mcast-drops %d > in-mcast-pkts %d", i.Name, i.StormMcastDrops, i.InMcastPkts))
+		}
+		if i.StormUnkUcstDrops > i.InUnkUcastPkts {
+			msgs = append(msgs, fmt.Sprintf("interface %s storm-control-unknown-ucast-drops %d > in-unknown-ucast-pkts %d", i.Name, i.StormUnkUcstDrops, i.InUnkUcastPkts))
+		}
+	}
+	return msgs
+}
+
+func checkCPU(c *invariantChecker, s *Simulator) []string {
+	sum := s.CPU.User + s.CPU.Kernel + s.CPU.Idle
+	if math.Abs(sum-100) > 0.01 || s.CPU.Idle < 0 {
+		return []string{fmt.Sprintf("cpu user %.2f + kernel %.2f + idle %.2f = %.2f", s.CPU.User, s.CPU.Kernel, s.CPU.Idle, sum)}
+	}
+	return nil
+}
+
+func newCheckCmd() *cobra.Command {
+	var o simOptions
+	var minutes int
+	var verbose bool
+	var report string
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Run the simulation headless and assert internal invariants",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exitWith(runCheck(o, minutes, verbose, report))
+		},
+	}
+	addSimFlags(cmd, &o)
+	cmd.Flags().IntVar(&minutes, "minutes", 60, "Virtual minutes to simulate")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show simulation log output")
+	addReportFlag(cmd.Flags(), &report)
+	return cmd
+}
+
+// runCheck runs the simulation headless on a virtual clock for the requested
+// duration, asserting internal invariants after every step. It returns the
+// process exit code.
+func runCheck(o simOptions, minutes int, verbose bool, report string) int {
+	cfg, err := o.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 2
+	}
+	if !verbose {
+		log.SetOutput(io.Discard)
+	}
+	ok, err := checkNode(o, cfg, o.nodeID, minutes, report, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// checkNode simulates one node headless on a virtual clock, writes the
+// violated invariants and failed scenario assertions to w, and reports
+// whether there were none
+func checkNode(o simOptions, cfg *Config, nodeID string, minutes int, report string, w io.Writer) (bool, error) {
+	// Headless runs never send syslog anywhere
+	cfg.Syslog = SyslogConfig{}
+	start := time.Unix(0, 0).UTC()
+	sim, scenario, err := o.newNode(cfg, nodeID, start)
+	if err != nil {
+		return false, err
+	}
+	if report != "" {
+		sim.Stats = NewSeriesStats()
+	}
+
+	checker := newInvariantChecker()
+	steps := int(time.Duration(minutes) * time.Minute / o.interval)
+	for i := 1; i <= steps; i++ {
+		elapsed := time.Duration(i) * o.interval
+		now := start.Add(elapsed)
+		scenario.Advance(sim, now)
+		sim.Step(now)
+		checker.run(sim, elapsed)
+		// Every message must still encode
+		messages := sim.BuildTelemetry(now)
+		sim.OnChange.Commit(messages)
+		sim.MessagesSent += uint64(len(messages))
+		for _, telem := range messages {
+			if _, err := telem.Marshal(); err != nil {
+				checker.record("marshal-"+telem.SubscriptionIDStr, err.Error(), elapsed)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "Checked %d invariants over %d steps (%d virtual minutes at %s)\n",
+		len(invariants), steps, minutes, o.interval.String())
+	if report != "" {
+		if err := sim.Stats.WriteReport(report); err != nil {
+			return false, fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+	asserted, failures := scenario.Assertions()
+	if asserted > 0 {
+		fmt.Fprintf(w, "Checked %d scenario assertions\n", asserted)
+	}
+	for _, f := range failures {
+		fmt.Fprintf(w, "FAIL assertion at %s\n", f)
+	}
+	if len(checker.violations) == 0 && len(failures) == 0 {
+		fmt.Fprintln(w, "OK: no violations")
+		return true, nil
+	}
+	names := make([]string, 0, len(checker.violations))
+	for name := range checker.violations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := checker.violations[name]
+		fmt.Fprintf(w, "FAIL %s: %d violations, first at t=%s: %s\n", name, v.count, v.at, v.first)
+	}
+	return false, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// exitError ends the process with a specific exit code without printing
+// anything further, for commands whose output already explains the failure
+type exitError struct {
+	code int
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// exitWith turns a command's exit code into the error returned to cobra
+func exitWith(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return exitError{code: code}
+}
+
+// addSimFlags registers the flags shared by every command that simulates nodes
+func addSimFlags(cmd *cobra.Command, o *simOptions) {
+	fs := cmd.Flags()
+	fs.StringVar(&o.nodeID, "node", "leaf-101", "Simulated NX-OS leaf node-id-str")
+	fs.StringVar(&o.configPath, "config", "config/generator.yaml", "Path to YAML configuration file")
+	fs.StringVar(&o.scenarioPath, "scenario", "", "Path to YAML scenario file with scripted events")
+	fs.BoolVar(&o.allowScripts, "allow-scripts", false, "Run the Starlark script of the scenario (scripts are sandboxed, but disabled by default)")
+	fs.Float64Var(&o.flapChance, "flap-chance", 0.02, "Chance of BGP neighbor flap per interval (0.0-1.0)")
+	fs.DurationVar(&o.interval, "interval", 5*time.Second, "Interval between telemetry updates")
+	fs.StringVar(&o.platform, "platform", "", "Device personality: nxos or iosxr encoding paths and keys (default platform in the configuration, else nxos)")
+	fs.Int64Var(&o.seed, "seed", 0, "Seed every random draw, so runs with the same seed, configuration and scenario produce the same telemetry (default simulation.seed, 0 for random)")
+	fs.StringToStringVar(&o.auto, "auto", nil, "Fabricate a fabric instead of reading --config, e.g. leafs=32,vnis=200,neighbors-per-leaf=4 (also host-ports, seed)")
+	cmd.MarkFlagFilename("config", "yaml", "yml")
+	cmd.MarkFlagFilename("scenario", "yaml", "yml")
+}
+
+// addServerFlag registers the collector address flag
+func addServerFlag(fs *pflag.FlagSet, server *string) {
+	fs.StringVar(server, "server", "10.10.20.10:57500", "gRPC MDT collector address")
+}
+
+// addReportFlag registers the end-of-run statistics report flag
+func addReportFlag(fs *pflag.FlagSet, report *string) {
+	fs.StringVar(report, "report", "", "Write mean, variance, autocorrelation and entropy of every series to this file at the end of the run (- for stdout, .csv for CSV)")
+}
+
+// addBandwidthFlags registers the per-subscription bandwidth accounting flags
+func addBandwidthFlags(fs *pflag.FlagSet, o *bandwidthOptions) {
+	fs.StringVar(&o.bandwidthReport, "bandwidth-report", "", "Write messages, bytes and rates sent per node and subscription to this file at the end of the run (- for stdout, .csv for CSV)")
+	fs.StringVar(&o.metricsAddr, "metrics-addr", "", "Serve the per-subscription message and byte counters as Prometheus metrics on this address, e.g. :9273")
+}
+
+// addTransportFlag registers the transport flag of the collector streams
+func addTransportFlag(fs *pflag.FlagSet, transport *string) {
+	fs.StringVar(transport, "transport", "", "Transport of the telemetry sent to the collectors: grpc dial-out or udp datagrams (default dialout.transport, else grpc)")
+}
+
+// addEncodingFlag registers the encoding flag of the collector stream
+func addEncodingFlag(fs *pflag.FlagSet, encoding *string) {
+	fs.StringVar(encoding, "encoding", "", "Encoding of the telemetry sent to --server: gpbkv, gpb-compact or json (default dialout.encoding, else gpbkv)")
+}
+
+// addControlFlag registers the address flag of the control API
+func addControlFlag(fs *pflag.FlagSet, addr *string) {
+	fs.StringVar(addr, "control-addr", "", "Serve the HTTP control API on this address to flap BGP neighbors, bring VNIs down and up, change counter rates and pause the streams at runtime, e.g. :8080")
+}
+
+func addTUIFlag(fs *pflag.FlagSet, tui *bool) {
+	fs.BoolVar(tui, "tui", false, "Show live send rates, BGP and VNI state and recent events in the terminal instead of the log")
+}
+
+// newRootCmd builds the command tree of the simulator
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "cisco-mdt-generator",
+		Short: "Cisco NX-OS MDT telemetry simulator",
+		Long: "Simulates NX-OS VXLAN EVPN leafs and streams their model-driven telemetry\n" +
+			"to a gRPC dial-out or UDP collector in GPB-KV, compact GPB or JSON encoding, on the\n" +
+			"NX-OS or, with --platform iosxr, the IOS-XR encoding paths.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	root.AddCommand(
+		newRunCmd(),
+		newFleetCmd(),
+		newRecordCmd(),
+		newReplayCmd(),
+		newValidateCmd(),
+		newSchemaCmd(),
+		newCheckCmd(),
+		newPlaylistCmd(),
+		newPreviewCmd(),
+		newBenchCmd(),
+		newEstimateCmd(),
+		newProbeCmd(),
+		newCompareCmd(),
+		newDiffCmd(),
+		newDecodeCmd(),
+		newACLProbeCmd(),
+		newConformanceCmd(),
+		newCtlCmd(),
+		newOperatorCmd(),
+		newVersionCmd(),
+	)
+	return root
+}
+
+func newRunCmd() *cobra.Command {
+	var o runOptions
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Simulate a leaf and stream telemetry to a collector",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGenerator(o)
+		},
+	}
+	addSimFlags(cmd, &o.simOptions)
+	addServerFlag(cmd.Flags(), &o.server)
+	addEncodingFlag(cmd.Flags(), &o.encoding)
+	addTransportFlag(cmd.Flags(), &o.transport)
+	addReportFlag(cmd.Flags(), &o.report)
+	addTUIFlag(cmd.Flags(), &o.tui)
+	addBandwidthFlags(cmd.Flags(), &o.bandwidthOptions)
+	cmd.Flags().StringVar(&o.gnmiAddr, "gnmi-addr", "", "Serve gNMI Subscribe (STREAM with SAMPLE or ON_CHANGE, ONCE, POLL) on this address instead of dialing out to --server, e.g. :57400")
+	cmd.Flags().StringVar(&o.grpcAddr, "grpc-addr", "", "Listen address for the gRPC server (health, reflection, admin), e.g. :50051")
+	addControlFlag(cmd.Flags(), &o.control)
+	cmd.Flags().StringVar(&o.record, "record-scenario", "", "Record events injected through the admin service or command feed to this scenario file")
+	cmd.MarkFlagFilename("record-scenario", "yaml", "yml")
+	return cmd
+}
+
+func newValidateCmd() *cobra.Command {
+	var o simOptions
+	cmd := &cobra.Command{
+		Use:   "validate [scenario files...]",
+		Short: "Validate the configuration and scenario files without running",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runValidate(o, args)
+		},
+	}
+	addSimFlags(cmd, &o)
+	return cmd
+}
+
+// runValidate loads the configuration and checks every scenario against the
+// simulated topology it describes
+func runValidate(o simOptions, scenarios []string) error {
+	source := o.configPath
+	if len(o.auto) > 0 {
+		source = "--auto"
+	}
+	cfg, err := o.config()
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	fmt.Printf("%s: OK\n", source)
+
+	// Templates and overrides are only resolved per node
+	for _, nodeID := range cfg.NodeIDs() {
+		if _, err := cfg.configFor(nodeID); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	if err := checkAddressing(cfg); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	for _, p := range cfg.Plugins {
+		if _, err := compilePlugin(p.Path); err != nil {
+			return fmt.Errorf("%s: plugin %s: %w", source, p.Path, err)
+		}
+	}
+	if ids := cfg.NodeIDs(); len(ids) > 0 {
+		fmt.Printf("%s: %d nodes OK\n", source, len(ids))
+	}
+
+	if o.scenarioPath != "" {
+		scenarios = append([]string{o.scenarioPath}, scenarios...)
+	}
+
+	syslog, _ := NewSyslog(SyslogConfig{}, o.nodeID)
+	sim := NewSimulator(cfg, o.nodeID, 0, syslog, time.Unix(0, 0))
+
+	failed := 0
+	for _, path := range scenarios {
+		sc, err := LoadScenario(path)
+		if err == nil {
+			err = NewScenarioEngine(sc, time.Unix(0, 0)).CheckTargets(sim)
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: OK (%d events)\n", path, len(sc.Events))
+	}
+
+	if failed > 0 {
+		return exitWith(1)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clocks the steps of a streamed node are timestamped with
+const (
+	clockWall      = "wall"
+	clockMonotonic = "monotonic"
+)
+
+// What a stream does with the collections missed during a clock jump
+const (
+	catchUpOff      = "off"      // one collection covering the whole gap
+	catchUpBackfill = "backfill" // the missed collections, with interpolated timestamps
+	catchUpSkip     = "skip"     // none, the gap is logged as an event
+)
+
+// simClock turns the ticks of a stream into the time of each simulation
+// step. The wall clock uses the time of the tick as is. The monotonic clock
+// adds the monotonic time elapsed since the start to the start time, so an
+// NTP step or a suspended host does not show up as a gap or a spike.
+type simClock struct {
+	start     time.Time
+	monotonic bool
+	last      time.Time // time of the previous tick
+}
+
+// newSimClock returns the clock of the given kind for a node started at start
+func newSimClock(kind string, start time.Time) *simClock {
+	return &simClock{start: start, monotonic: kind == clockMonotonic, last: start.Round(0)}
+}
+
+// At returns the simulation time of a tick. Simulation times carry no
+// monotonic reading, so steps are measured on the clock that stamps them.
+func (c *simClock) At(tick time.Time) time.Time {
+	if !c.monotonic {
+		return tick.Round(0)
+	}
+	return c.start.Add(tick.Sub(c.start)).Round(0)
+}
+
+// Missed returns the times of the collections missed between the previous
+// tick and now, when at least threshold of them were missed, e.g. because
+// the host was suspended
+func (c *simClock) Missed(now time.Time, interval time.Duration, threshold int) []time.Time {
+	last := c.last
+	c.last = now
+	if interval <= 0 || now.Sub(last) < time.Duration(threshold+1)*interval {
+		return nil
+	}
+	var missed []time.Time
+	for at := last.Add(interval); now.Sub(at) >= interval/2; at = at.Add(interval) {
+		missed = append(missed, at)
+	}
+	return missed
+}
+
+// skipGap continues the simulation after the clock jumped from last to now
+// as if only one interval had passed, and logs the gap
+func (s *Simulator) skipGap(last, now time.Time, interval time.Duration, missed int) {
+	s.lastStep = now.Add(-interval)
+	s.event("clock_gap", "simulation", "Clock jumped %s from %s, skipped %d collections",
+		now.Sub(last).Round(time.Second), last.Format(time.RFC3339), missed)
+}
+
+// checkClock ensures the clock setting names a known clock
+func checkClock(kind string) error {
+	if kind != "" && kind != clockWall && kind != clockMonotonic {
+		return fmt.Errorf("clock must be %s or %s", clockWall, clockMonotonic)
+	}
+	return nil
+}
+
+// checkCatchUp ensures the catch-up settings are usable
+func checkCatchUp(cfg CatchUpConfig) error {
+	switch cfg.Behavior {
+	case "", catchUpOff, catchUpBackfill, catchUpSkip:
+	default:
+		return fmt.Errorf("behavior must be %s, %s or %s", catchUpOff, catchUpBackfill, catchUpSkip)
+	}
+	if cfg.Threshold < 1 || cfg.MaxBackfill < 1 {
+		return fmt.Errorf("threshold and max_backfill must be at least 1")
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"cisco-mdt-generator/pkg/cluster"
+)
+
+// clusterStartDelay leaves every member time to set up its simulators
+// between the last follower joining and the shared start
+const clusterStartDelay = 3 * time.Second
+
+// ClusterLeader hands out slices of a fleet to the followers that join it,
+// along with the configuration, scenario and start time they all share, so
+// fabric-wide events fire at the same moment in every process
+type ClusterLeader struct {
+	listener *GRPCListener
+	want     int
+
+	mu          sync.Mutex
+	followers   []*cluster.JoinRequest // in order of joining
+	assignments map[*cluster.JoinRequest]*cluster.Assignment
+	vacant      []*cluster.Assignment // slices of followers that left
+	full        chan struct{}         // closed when every follower has joined
+	ready       chan struct{}         // closed when the assignments are made
+	stopping    chan struct{}
+
+	// nodeIDs and start are the leader's own slice and the shared start
+	nodeIDs []string
+	start   time.Time
+}
+
+// leadCluster serves the cluster service on o.clusterListen and waits until
+// o.followers followers have joined, then assigns every member its slice of
+// nodeIDs. The leader keeps the first slice.
+func leadCluster(ctx context.Context, o fleetOptions, nodeIDs []string) (*ClusterLeader, error) {
+	if o.followers < 1 {
+		return nil, fmt.Errorf("followers must be at least 1")
+	}
+	if len(nodeIDs) < o.followers+1 {
+		return nil, fmt.Errorf("%d leafs cannot be shared by a leader and %d followers", len(nodeIDs), o.followers)
+	}
+	shared, err := o.clusterAssignment()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := NewGRPCListener(o.clusterListen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start cluster listener: %w", err)
+	}
+	l := &ClusterLeader{
+		listener:    listener,
+		want:        o.followers,
+		assignments: make(map[*cluster.JoinRequest]*cluster.Assignment),
+		full:        make(chan struct{}),
+		ready:       make(chan struct{}),
+		stopping:    make(chan struct{}),
+	}
+	cluster.RegisterClusterServer(listener.Server, l)
+	listener.Serve()
+
+	log.Printf("Cluster leader waiting for %d followers", o.followers)
+	select {
+	case <-ctx.Done():
+		l.Stop()
+		return nil, ctx.Err()
+	case <-l.full:
+	}
+
+	members := o.followers + 1
+	l.mu.Lock()
+	l.start = time.Now().Add(clusterStartDelay).Truncate(time.Millisecond)
+	l.nodeIDs = clusterSlice(nodeIDs, 0, members)
+	for i, req := range l.followers {
+		a := *shared
+		a.Index = uint32(i + 1)
+		a.Members = uint32(members)
+		a.StartMs = l.start.UnixMilli()
+		a.NodeIDs = clusterSlice(nodeIDs, i+1, members)
+		l.assignments[req] = &a
+		log.Printf("Cluster member %d (%s) simulates %d leafs: %s to %s",
+			a.Index, req.Member, len(a.NodeIDs), a.NodeIDs[0], a.NodeIDs[len(a.NodeIDs)-1])
+	}
+	l.mu.Unlock()
+	close(l.ready)
+
+	listener.SetServing(true)
+	log.Printf("Cluster of %d members starts at %s, the leader simulates %d leafs",
+		members, l.start.Format(time.RFC3339Nano), len(l.nodeIDs))
+	return l, nil
+}
+
+// clusterSlice returns the nodes member i of members simulates. Slices are
+// contiguous and differ in size by at most one leaf.
+func clusterSlice(nodeIDs []string, i, members int) []string {
+	return nodeIDs[i*len(nodeIDs)/members : (i+1)*len(nodeIDs)/members]
+}
+
+// clusterAssignment returns what every follower shares with the leader: the
+// configuration and scenario as the leader read them, and the fleet flags
+func (o fleetOptions) clusterAssignment() (*cluster.Assignment, error) {
+	a := &cluster.Assignment{
+		IntervalMs:   o.interval.Milliseconds(),
+		FlapChance:   o.flapChance,
+		Server:       o.server,
+		Auto:         o.auto,
+		NodeFormat:   o.nodeFormat,
+		Count:        int64(o.count),
+		First:        int64(o.first),
+		Template:     o.template,
+		AllowScripts: o.allowScripts,
+	}
+	if len(o.auto) == 0 {
+		data, err := os.ReadFile(o.configPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		a.Config = data
+	}
+	if o.scenarioPath != "" {
+		data, err := os.ReadFile(o.scenarioPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scenario file: %w", err)
+		}
+		a.Scenario = data
+	}
+	return a, nil
+}
+
+// Join registers a follower, sends its assignment once every follower has
+// joined and holds the stream open until the follower or the leader stops. A
+// follower joining a running cluster takes over the slice of one that left.
+func (l *ClusterLeader) Join(req *cluster.JoinRequest, stream cluster.Cluster_JoinServer) error {
+	l.mu.Lock()
+	if len(l.followers) == l.want {
+		if len(l.vacant) == 0 {
+			l.mu.Unlock()
+			return status.Errorf(codes.FailedPrecondition, "the cluster already has its %d followers", l.want)
+		}
+		a := l.vacant[0]
+		l.vacant = l.vacant[1:]
+		l.mu.Unlock()
+		log.Printf("Cluster follower %s takes over the %d leafs of member %d", req.Member, len(a.NodeIDs), a.Index)
+		return l.serve(req, stream, a)
+	}
+	l.followers = append(l.followers, req)
+	log.Printf("Cluster follower %s joined (%d of %d)", req.Member, len(l.followers), l.want)
+	if len(l.followers) == l.want {
+		close(l.full)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-stream.Context().Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-l.full:
+			log.Printf("Cluster follower %s left before the start, its leafs will not be simulated", req.Member)
+		default:
+			l.followers = slices.DeleteFunc(l.followers, func(r *cluster.JoinRequest) bool { return r == req })
+			log.Printf("Cluster follower %s left, waiting for %d more", req.Member, l.want-len(l.followers))
+		}
+		return nil
+	case <-l.stopping:
+		return nil
+	case <-l.ready:
+	}
+
+	l.mu.Lock()
+	a := l.assignments[req]
+	l.mu.Unlock()
+	return l.serve(req, stream, a)
+}
+
+// serve sends a follower its assignment and waits until the follower or the
+// leader stops. The slice of a follower that leaves waits for a new one.
+func (l *ClusterLeader) serve(req *cluster.JoinRequest, stream cluster.Cluster_JoinServer, a *cluster.Assignment) error {
+	err := stream.Send(a)
+	if err == nil {
+		select {
+		case <-stream.Context().Done():
+		case <-l.stopping:
+			return nil
+		}
+	}
+
+	l.mu.Lock()
+	l.vacant = append(l.vacant, a)
+	l.mu.Unlock()
+	log.Printf("Cluster member %d (%s) left, its %d leafs stopped until a follower joins", a.Index, req.Member, len(a.NodeIDs))
+	return err
+}
+
+// Stop ends the stream of every follower, which stops them, and closes the
+// listener
+func (l *ClusterLeader) Stop() {
+	close(l.stopping)
+	l.listener.Server.Stop()
+}
+
+// followCluster joins the cluster leader at o.join and simulates the slice
+// it assigns until the leader stops, a stream fails or on interrupt
+func followCluster(ctx context.Context, o fleetOptions) error {
+	conn, err := grpc.NewClient(o.join, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to dial cluster leader: %w", err)
+	}
+	defer conn.Close()
+
+	interrupted := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	member, _ := os.Hostname()
+	// Followers may start before their leader, e.g. as pods of one fleet
+	stream, err := cluster.NewClusterClient(conn).Join(ctx, &cluster.JoinRequest{Member: fmt.Sprintf("%s/%d", member, os.Getpid())},
+		grpc.WaitForReady(true))
+	if err != nil {
+		return fmt.Errorf("failed to join cluster: %w", err)
+	}
+	log.Printf("Joined cluster leader at %s, waiting for the other followers", o.join)
+	a, err := stream.Recv()
+	if err != nil {
+		if interrupted.Err() != nil {
+			log.Printf("Interrupted, stopping")
+			return nil
+		}
+		return fmt.Errorf("failed to join cluster: %w", err)
+	}
+
+	// The assignment replaces the local configuration, scenario and flags
+	o.auto = a.Auto
+	o.interval = time.Duration(a.IntervalMs) * time.Millisecond
+	o.flapChance = a.FlapChance
+	o.server = a.Server
+	o.nodeFormat, o.count, o.first, o.template = a.NodeFormat, int(a.Count), int(a.First), a.Template
+	o.allowScripts = a.AllowScripts
+
+	var cfg *Config
+	if len(a.Auto) > 0 {
+		cfg, err = autoConfig(a.Auto)
+	} else {
+		cfg, err = ParseConfig(a.Config)
+	}
+	if err != nil {
+		return fmt.Errorf("leader configuration: %w", err)
+	}
+	if len(a.Scenario) > 0 {
+		if o.scenario, err = ParseScenario(a.Scenario); err != nil {
+			return fmt.Errorf("leader scenario: %w", err)
+		}
+	}
+	if _, err := o.fleetNodes(cfg); err != nil {
+		return fmt.Errorf("leader configuration: %w", err)
+	}
+
+	start := time.UnixMilli(a.StartMs)
+	log.Printf("Cluster member %d of %d simulates %d leafs from %s", a.Index, a.Members, len(a.NodeIDs), start.Format(time.RFC3339Nano))
+
+	// A follower replacing one that left sends on the same interval
+	// boundaries as the rest of the cluster
+	if late := time.Since(start); late > 0 {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(o.interval - late%o.interval):
+		}
+	}
+
+	// The leader ends the stream when it stops
+	leaderGone := make(chan struct{})
+	go func() {
+		for {
+			if _, err := stream.Recv(); err != nil {
+				if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+					log.Printf("Cluster leader stream ended: %v", err)
+				}
+				close(leaderGone)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err = runNodes(ctx, o, cfg, a.NodeIDs, start)
+	if interrupted.Err() != nil {
+		log.Printf("Interrupted, stopping")
+		return nil
+	}
+	select {
+	case <-leaderGone:
+		log.Printf("Cluster leader stopped, stopping")
+		return nil
+	default:
+	}
+	return err
+}
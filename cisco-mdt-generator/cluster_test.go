@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestClusterSlice(t *testing.T) {
+	tests := []struct {
+		nodes, members int
+		want           []int // slice sizes by member
+	}{
+		{nodes: 9, members: 3, want: []int{3, 3, 3}},
+		{nodes: 10, members: 3, want: []int{3, 3, 4}},
+		{nodes: 11, members: 3, want: []int{3, 4, 4}},
+		{nodes: 1000, members: 7, want: []int{142, 143, 143, 143, 143, 143, 143}},
+		{nodes: 5, members: 1, want: []int{5}},
+		{nodes: 2, members: 3, want: []int{0, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d nodes on %d members", tt.nodes, tt.members), func(t *testing.T) {
+			var nodeIDs []string
+			for i := range tt.nodes {
+				nodeIDs = append(nodeIDs, fmt.Sprintf("leaf-%d", 101+i))
+			}
+
+			// Every node is simulated once, in order
+			var joined []string
+			var sizes []int
+			for i := range tt.members {
+				s := clusterSlice(nodeIDs, i, tt.members)
+				joined = append(joined, s...)
+				sizes = append(sizes, len(s))
+			}
+			if !slices.Equal(joined, nodeIDs) {
+				t.Errorf("slices join to %v, want %v", joined, nodeIDs)
+			}
+			if !slices.Equal(sizes, tt.want) {
+				t.Errorf("slice sizes %v, want %v", sizes, tt.want)
+			}
+		})
+	}
+}
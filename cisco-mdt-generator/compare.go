@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"cisco-mdt-generator/pkg/gnmi"
+)
+
+// compareOptions are the flags of the compare command
+type compareOptions struct {
+	target     string
+	origin     string
+	username   string
+	password   string
+	plaintext  bool
+	caFile     string
+	serverName string
+	skipVerify bool
+	encoding   string
+	timeout    time.Duration
+	paths      []string
+	verbose    bool
+}
+
+// compareEncodings maps --encoding values to gNMI encodings
+var compareEncodings = map[string]gnmi.Encoding{
+	"json":      gnmi.Encoding_JSON,
+	"json_ietf": gnmi.Encoding_JSON_IETF,
+	"proto":     gnmi.Encoding_PROTO,
+}
+
+// simPath is the simulated schema of one sensor path
+type simPath struct {
+	subscription string
+	path         string
+	fields       map[string]string
+}
+
+func newCompareCmd() *cobra.Command {
+	var o compareOptions
+	cmd := &cobra.Command{
+		Use:   "compare",
+		Short: "Compare the simulated schema against a real switch over gNMI",
+		Long: "Subscribes once over gNMI to a real switch for every sensor path the simulator\n" +
+			"emits and reports fields the device does not have, fields whose type differs\n" +
+			"and paths the device does not return.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exitWith(runCompare(o))
+		},
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&o.target, "target", "", "gNMI address of the switch, e.g. 10.10.20.101:50051")
+	fs.StringVar(&o.origin, "origin", "device", "gNMI path origin of the Cisco-NX-OS-device model")
+	fs.StringVarP(&o.username, "username", "u", "", "gNMI username")
+	fs.StringVarP(&o.password, "password", "p", os.Getenv("MDT_SIM_GNMI_PASSWORD"), "gNMI password (default $MDT_SIM_GNMI_PASSWORD)")
+	fs.BoolVar(&o.plaintext, "plaintext", false, "Connect without TLS")
+	fs.StringVar(&o.caFile, "tls-ca", "", "CA certificate to verify the switch")
+	fs.StringVar(&o.serverName, "tls-server-name", "", "Server name to verify instead of the target host")
+	fs.BoolVar(&o.skipVerify, "tls-skip-verify", false, "Do not verify the switch certificate")
+	fs.StringVar(&o.encoding, "encoding", "json", "Value encoding to request: json, json_ietf or proto")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "How long to wait for the device to send every path")
+	fs.StringArrayVar(&o.paths, "path", nil, "Only compare this subscription or sensor path, repeatable")
+	fs.BoolVarP(&o.verbose, "verbose", "v", false, "Also list device fields the simulator does not emit")
+	cmd.MarkFlagRequired("target")
+	cmd.MarkFlagFilename("tls-ca")
+	return cmd
+}
+
+// runCompare subscribes to the device and compares what it returns with the
+// simulated schema. It returns the process exit code: 1 when the schemas
+// diverge, 2 when the comparison could not be made.
+func runCompare(o compareOptions) int {
+	encoding, ok := compareEncodings[o.encoding]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown encoding %q\n", o.encoding)
+		return 2
+	}
+
+	paths := simPaths(o.paths)
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "no simulated path matches %s\n", strings.Join(o.paths, ", "))
+		return 2
+	}
+
+	device, err := subscribeOnce(o, paths, encoding)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	divergences := 0
+	for _, p := range paths {
+		divergences += reportPath(os.Stdout, p, device[p.path], o.verbose)
+	}
+
+	fmt.Printf("\nCompared %d paths against %s: %d divergences\n", len(paths), o.target, divergences)
+	if divergences > 0 {
+		return 1
+	}
+	return 0
+}
+
+// simPaths returns the simulated schema grouped by sensor path, limited to
+// the paths or subscriptions in filter when it is not empty
+func simPaths(filter []string) []*simPath {
+	byPath := make(map[string]*simPath)
+	var paths []*simPath
+	for _, line := range schemaLines() {
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			continue
+		}
+		sub, path := parts[0], parts[1]
+		if len(filter) > 0 && !slices.Contains(filter, sub) && !slices.Contains(filter, path) {
+			continue
+		}
+
+		p, ok := byPath[path]
+		if !ok {
+			p = &simPath{subscription: sub, path: path, fields: make(map[string]string)}
+			byPath[path] = p
+			paths = append(paths, p)
+		}
+		_, name, _ := strings.Cut(parts[2], "/")
+		name, typ, _ := strings.Cut(name, ":")
+		p.fields[name] = typ
+	}
+	return paths
+}
+
+// gnmiPath converts a telemetry encoding path to a gNMI path
+func gnmiPath(encodingPath, origin string) *gnmi.Path {
+	_, path, found := strings.Cut(encodingPath, ":")
+	if !found {
+		path = encodingPath
+	}
+	p := gnmi.ParsePath(path)
+	p.Origin = origin
+	return p
+}
+
+// subscribeOnce runs a ONCE subscription for every path and returns the
+// leaf types the device reported, keyed by encoding path and field name
+func subscribeOnce(o compareOptions, paths []*simPath, encoding gnmi.Encoding) (map[string]map[string]string, error) {
+	creds, err := dialCredentials(TLSConfig{
+		Enabled:            !o.plaintext,
+		CAFile:             o.caFile,
+		ServerName:         o.serverName,
+		InsecureSkipVerify: o.skipVerify,
+	}, o.target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up TLS: %w", err)
+	}
+
+	conn, err := grpc.NewClient(o.target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", o.target, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	if o.username != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "username", o.username, "password", o.password)
+	}
+
+	stream, err := gnmi.NewGNMIClient(conn).Subscribe(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	list := &gnmi.SubscriptionList{Mode: gnmi.SubscriptionList_ONCE, Encoding: encoding}
+	requested := make([]*gnmi.Path, len(paths))
+	for i, p := range paths {
+		requested[i] = gnmiPath(p.path, o.origin)
+		list.Subscription = append(list.Subscription, &gnmi.Subscription{Path: requested[i]})
+	}
+	if err := stream.Send(&gnmi.SubscribeRequest{Subscribe: list}); err != nil {
+		return nil, fmt.Errorf("failed to send subscription: %w", err)
+	}
+
+	device := make(map[string]map[string]string)
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("subscription failed: %w", err)
+		}
+		if resp.SyncResponse {
+			break
+		}
+		if resp.Update == nil {
+			continue
+		}
+
+		for _, u := range resp.Update.Update {
+			full := joinPaths(resp.Update.Prefix, u.Path)
+			i := matchPath(requested, full)
+			if i < 0 || u.Val == nil {
+				continue
+			}
+			leaves := device[paths[i].path]
+			if leaves == nil {
+				leaves = make(map[string]string)
+				device[paths[i].path] = leaves
+			}
+			collectLeaves(leaves, full, len(requested[i].Elem), u.Val)
+		}
+	}
+	return device, nil
+}
+
+// joinPaths appends the elements of path to prefix
+func joinPaths(prefix, path *gnmi.Path) []*gnmi.PathElem {
+	var elems []*gnmi.PathElem
+	if prefix != nil {
+		elems = append(elems, prefix.Elem...)
+	}
+	if path != nil {
+		elems = append(elems, path.Elem...)
+	}
+	return elems
+}
+
+// matchPath returns the index of the longest requested path the update path
+// lies under, or -1
+func matchPath(requested []*gnmi.Path, elems []*gnmi.PathElem) int {
+	best := -1
+	for i, p := range requested {
+		if len(p.Elem) > len(elems) || (best >= 0 && len(p.Elem) <= len(requested[best].Elem)) {
+			continue
+		}
+		if slices.EqualFunc(p.Elem, elems[:len(p.Elem)], func(a, b *gnmi.PathElem) bool {
+			return a.Name == b.Name
+		}) {
+			best = i
+		}
+	}
+	return best
+}
+
+// collectLeaves records the names and types of the leaves in a value. JSON
+// values are walked recursively; scalar values are named by the last
+// element of their path when it lies below the subscribed path.
+func collectLeaves(leaves map[string]string, elems []*gnmi.PathElem, depth int, v *gnmi.TypedValue) {
+	var doc []byte
+	switch {
+	case v.JSONVal != nil:
+		doc = v.JSONVal
+	case v.JSONIETFVal != nil:
+		doc = v.JSONIETFVal
+	default:
+		if len(elems) > depth {
+			leaves[elems[len(elems)-1].Name] = typedValueType(v)
+		}
+		return
+	}
+
+	var tree any
+	if err := json.Unmarshal(doc, &tree); err != nil {
+		return
+	}
+	walkJSONLeaves(leaves, "", tree)
+}
+
+// walkJSONLeaves records the scalar members of a JSON tree by name
+func walkJSONLeaves(leaves map[string]string, name string, v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			// Strip YANG module prefixes of JSON_IETF member names
+			if _, local, ok := strings.Cut(k, ":"); ok {
+				k = local
+			}
+			walkJSONLeaves(leaves, k, child)
+		}
+	case []any:
+		for _, child := range v {
+			walkJSONLeaves(leaves, name, child)
+		}
+	case string:
+		// NX-OS renders many numbers as JSON strings
+		typ := "string"
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			typ = "number"
+		}
+		if leaves[name] != "string" {
+			leaves[name] = typ
+		}
+	case float64:
+		leaves[name] = "number"
+	case bool:
+		leaves[name] = "bool"
+	}
+}
+
+// typedValueType classifies a scalar gNMI value
+func typedValueType(v *gnmi.TypedValue) string {
+	switch {
+	case v.IntVal != nil, v.UintVal != nil, v.FloatVal != nil, v.DoubleVal != nil:
+		return "number"
+	case v.BoolVal != nil:
+		return "bool"
+	}
+	return "string"
+}
+
+// simTypeClass maps a telemetry field type to the classes a device reports
+func simTypeClass(typ string) string {
+	switch typ {
+	case "string", "bool":
+		return typ
+	}
+	return "number"
+}
+
+// compatibleTypes reports whether a simulated field can carry what the
+// device reports. Strings on the device that only ever held numbers are
+// compatible with string fields, as NX-OS quotes many numeric values.
+func compatibleTypes(simType, deviceType string) bool {
+	class := simTypeClass(simType)
+	return class == deviceType || (class == "string" && deviceType == "number")
+}
+
+// reportPath writes the comparison of one path and returns the number of
+// divergences found
+func reportPath(w io.Writer, p *simPath, device map[string]string, verbose bool) int {
+	fmt.Fprintf(w, "%s (%s)\n", p.path, p.subscription)
+	if device == nil {
+		fmt.Fprintf(w, "  MISSING  the device returned no data for this path\n")
+		return 1
+	}
+
+	divergences, matched := 0, 0
+	for _, name := range slices.Sorted(maps.Keys(p.fields)) {
+		typ := p.fields[name]
+		devType, ok := device[name]
+		switch {
+		case !ok:
+			fmt.Fprintf(w, "  SIM-ONLY %s (%s): not reported by the device\n", name, typ)
+			divergences++
+		case !compatibleTypes(typ, devType):
+			fmt.Fprintf(w, "  TYPE     %s: simulator %s, device %s\n", name, typ, devType)
+			divergences++
+		default:
+			matched++
+		}
+	}
+	fmt.Fprintf(w, "  OK       %d of %d simulated fields match\n", matched, len(p.fields))
+
+	var extra []string
+	for name := range device {
+		if _, ok := p.fields[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+	slices.Sort(extra)
+	if len(extra) > 0 && verbose {
+		for _, name := range extra {
+			fmt.Fprintf(w, "  EXTRA    %s (%s)\n", name, device[name])
+		}
+	} else if len(extra) > 0 {
+		fmt.Fprintf(w, "  EXTRA    %d device fields not simulated (-v to list)\n", len(extra))
+	}
+	return divergences
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// condition is a test of simulator state guarding a scenario event, written
+// as comparisons joined by "and" and "or", e.g.
+// "flaps(10.0.0.1) >= 3 and established(10.0.0.2) == 1". "and" binds
+// tighter than "or".
+type condition struct {
+	text string
+	any  [][]comparison // holds when every comparison of any group holds
+}
+
+// comparison tests one metric against a number
+type comparison struct {
+	metric string
+	arg    string
+	op     string
+	value  float64
+}
+
+// conditionMetric reads one value from the simulator. arg names the kind of
+// object the metric takes, or is empty when it takes none. Gauges also have
+// set, which overwrites the value for the command feed.
+type conditionMetric struct {
+	arg   string
+	value func(s *Simulator, arg string) (float64, error)
+	set   func(s *Simulator, arg string, v float64) error
+}
+
+// conditionMetrics maps the metric names usable in conditions to their implementation
+var conditionMetrics = map[string]conditionMetric{
+	"flaps": {arg: "BGP neighbor", value: neighborMetric(func(n *BGPNeighbor) float64 {
+		return float64(n.FlapCount)
+	})},
+	"prefixes": {arg: "BGP neighbor", value: neighborMetric(func(n *BGPNeighbor) float64 {
+		return float64(n.PrefixesRecv)
+	}), set: func(s *Simulator, arg string, v float64) error {
+		n := s.FindNeighbor(arg)
+		if n == nil {
+			return fmt.Errorf("unknown BGP neighbor %q", arg)
+		}
+		n.PrefixesRecv = uint32(v)
+		return nil
+	}},
+	"established": {arg: "BGP neighbor", value: neighborMetric(func(n *BGPNeighbor) float64 {
+		return boolMetric(n.State == "Established")
+	})},
+	"bgp_state": {arg: "BGP neighbor", value: neighborMetric(func(n *BGPNeighbor) float64 {
+		return float64(n.StateCode)
+	})},
+	"vni_up": {arg: "VNI", value: vniMetric(func(v *VNIState) float64 {
+		return boolMetric(v.StateCode == 1)
+	})},
+	"macs": {arg: "VNI", value: vniMetric(func(v *VNIState) float64 {
+		return float64(v.MACCount)
+	}), set: setVNIMetric(func(v *VNIState, value uint32) { v.MACCount = value })},
+	"mac_moves": {arg: "VNI", value: vniMetric(func(v *VNIState) float64 {
+		return float64(v.MACMoves)
+	})},
+	"arp": {arg: "VNI", value: vniMetric(func(v *VNIState) float64 {
+		return float64(v.ARPCount)
+	}), set: setVNIMetric(func(v *VNIState, value uint32) { v.ARPCount = value })},
+	"storm_drops": {arg: "interface", value: func(s *Simulator, arg string) (float64, error) {
+		i := s.FindInterface(arg)
+		if i == nil {
+			return 0, fmt.Errorf("unknown interface %q", arg)
+		}
+		return float64(i.StormBcastDrops + i.StormMcastDrops + i.StormUnkUcstDrops), nil
+	}},
+	"cpu": {value: func(s *Simulator, arg string) (float64, error) {
+		return 100 - s.CPU.Idle, nil
+	}},
+	"evpn_routes": {value: func(s *Simulator, arg string) (float64, error) {
+		return float64(s.EVPN.TotalRoutes), nil
+	}},
+	"messages_sent": {value: func(s *Simulator, arg string) (float64, error) {
+		return float64(s.MessagesSent), nil
+	}},
+}
+
+// neighborMetric returns a metric of the BGP neighbor named by its argument
+func neighborMetric(f func(n *BGPNeighbor) float64) func(s *Simulator, arg string) (float64, error) {
+	return func(s *Simulator, arg string) (float64, error) {
+		n := s.FindNeighbor(arg)
+		if n == nil {
+			return 0, fmt.Errorf("unknown BGP neighbor %q", arg)
+		}
+		return f(n), nil
+	}
+}
+
+// vniMetric returns a metric of the VNI named by its argument
+func vniMetric(f func(v *VNIState) float64) func(s *Simulator, arg string) (float64, error) {
+	return func(s *Simulator, arg string) (float64, error) {
+		id, err := parseVNITarget(arg)
+		if err != nil {
+			return 0, err
+		}
+		v := s.FindVNI(id)
+		if v == nil {
+			return 0, fmt.Errorf("unknown VNI %d", id)
+		}
+		return f(v), nil
+	}
+}
+
+// setVNIMetric returns a setter of a gauge of the VNI named by its argument
+func setVNIMetric(f func(v *VNIState, value uint32)) func(s *Simulator, arg string, v float64) error {
+	return func(s *Simulator, arg string, v float64) error {
+		id, err := parseVNITarget(arg)
+		if err != nil {
+			return err
+		}
+		vni := s.FindVNI(id)
+		if vni == nil {
+			return fmt.Errorf("unknown VNI %d", id)
+		}
+		f(vni, uint32(v))
+		return nil
+	}
+}
+
+// boolMetric returns 1 for true and 0 for false
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var (
+	comparisonPattern = regexp.MustCompile(`^(\w+)\(\s*([^()\s]*)\s*\)\s*(>=|<=|==|!=|>|<)\s*(\S+)$`)
+	orPattern         = regexp.MustCompile(`\s+or\s+`)
+	andPattern        = regexp.MustCompile(`\s+and\s+`)
+)
+
+// parseCondition parses a condition and checks its metrics are known
+func parseCondition(text string) (condition, error) {
+	c := condition{text: strings.TrimSpace(text)}
+	for _, group := range orPattern.Split(c.text, -1) {
+		var all []comparison
+		for _, term := range andPattern.Split(group, -1) {
+			m := comparisonPattern.FindStringSubmatch(strings.TrimSpace(term))
+			if m == nil {
+				return condition{}, fmt.Errorf("invalid comparison %q, expected metric(target) op number", term)
+			}
+			metric, ok := conditionMetrics[m[1]]
+			if !ok {
+				return condition{}, fmt.Errorf("unknown metric %q", m[1])
+			}
+			if metric.arg == "" && m[2] != "" {
+				return condition{}, fmt.Errorf("metric %s takes no target", m[1])
+			}
+			if metric.arg != "" && m[2] == "" {
+				return condition{}, fmt.Errorf("metric %s needs a %s", m[1], metric.arg)
+			}
+			value, err := strconv.ParseFloat(m[4], 64)
+			if err != nil {
+				return condition{}, fmt.Errorf("invalid number %q in %q", m[4], term)
+			}
+			all = append(all, comparison{metric: m[1], arg: m[2], op: m[3], value: value})
+		}
+		c.any = append(c.any, all)
+	}
+	return c, nil
+}
+
+// check ensures every metric of the condition refers to objects that exist
+// in the simulation
+func (c condition) check(s *Simulator) error {
+	for _, all := range c.any {
+		for _, cmp := range all {
+			if _, err := conditionMetrics[cmp.metric].value(s, cmp.arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// holds evaluates the condition against the current simulator state
+func (c condition) holds(s *Simulator) bool {
+	for _, all := range c.any {
+		ok := true
+		for _, cmp := range all {
+			if !cmp.holds(s) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// values describes the current value of every metric of the condition, e.g.
+// "flaps(10.0.0.1) = 2"
+func (c condition) values(s *Simulator) string {
+	var values []string
+	for _, all := range c.any {
+		for _, cmp := range all {
+			v, err := conditionMetrics[cmp.metric].value(s, cmp.arg)
+			text := strconv.FormatFloat(v, 'g', -1, 64)
+			if err != nil {
+				text = "unknown"
+			}
+			values = append(values, fmt.Sprintf("%s(%s) = %s", cmp.metric, cmp.arg, text))
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// holds evaluates a single comparison. A metric of an object that no
+// longer exists never holds.
+func (cmp comparison) holds(s *Simulator) bool {
+	v, err := conditionMetrics[cmp.metric].value(s, cmp.arg)
+	if err != nil {
+		return false
+	}
+	switch cmp.op {
+	case ">=":
+		return v >= cmp.value
+	case "<=":
+		return v <= cmp.value
+	case "==":
+		return v == cmp.value
+	case "!=":
+		return v != cmp.value
+	case ">":
+		return v > cmp.value
+	default:
+		return v < cmp.value
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Defaults of a config_change event
+const (
+	defaultChangeTerminal = "pts/0"
+	defaultChangeCommands = "interface Ethernet1/1; description changed"
+)
+
+// ConfigChange is a configuration change committed on the node
+type ConfigChange struct {
+	ID       uint64 // running-config version after the change
+	Time     time.Time
+	User     string
+	Terminal string
+	Commands []string
+}
+
+// summary returns the commands of the change on one line
+func (c *ConfigChange) summary() string {
+	return strings.Join(c.Commands, "; ")
+}
+
+// RecordConfigChange commits a configuration change. It is streamed on
+// change, in the collection after the next step, and logged to syslog.
+func (s *Simulator) RecordConfigChange(now time.Time, user, terminal string, commands []string) {
+	s.configVersion++
+	c := &ConfigChange{ID: s.configVersion, Time: now, User: user, Terminal: terminal, Commands: commands}
+	s.pendingChanges = append(s.pendingChanges, c)
+	s.Syslog.Emit(now, SeverityNotice, "VSHD", "VSHD_SYSLOG_CONFIG_I",
+		fmt.Sprintf("Configured from vty by %s on %s", user, terminal))
+	s.event("config_change", user, "Configuration changed by %s on %s: %s", user, terminal, c.summary())
+}
+
+// stepConfigChanges moves the changes committed since the last step into
+// the ones reported this interval
+func (s *Simulator) stepConfigChanges() {
+	s.ConfigChanges, s.pendingChanges = s.pendingChanges, nil
+}
+
+// parseChangeCommands returns the commands of a config_change event,
+// separated by semicolons
+func parseChangeCommands(ev ScenarioEvent) []string {
+	var commands []string
+	for _, cmd := range strings.Split(ev.Param("commands", defaultChangeCommands), ";") {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			commands = append(commands, cmd)
+		}
+	}
+	return commands
+}
+
+// checkConfigChange ensures a config_change event names the user and commands
+func checkConfigChange(s *Simulator, ev ScenarioEvent) error {
+	if ev.Target == "" {
+		return fmt.Errorf("config_change needs the user as target")
+	}
+	if len(parseChangeCommands(ev)) == 0 {
+		return fmt.Errorf("config_change needs at least one command")
+	}
+	return nil
+}
+
+// configChangeAction is the config_change scenario action. With a duration
+// the user rolls the change back when it elapses.
+var configChangeAction = scenarioAction{
+	check: checkConfigChange,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		s.RecordConfigChange(now, ev.Target, ev.Param("terminal", defaultChangeTerminal), parseChangeCommands(ev))
+		return nil
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		s.RecordConfigChange(now, ev.Target, ev.Param("terminal", defaultChangeTerminal),
+			[]string{"rollback running-config checkpoint before-change"})
+		return nil
+	},
+}
+
+// buildConfigChangeTelemetry reports the configuration changes committed
+// since the last collection
+func buildConfigChangeTelemetry(ts uint64, nodeID string, changes []*ConfigChange) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, c := range changes {
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.Uint64Field("change-id", c.ID, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.Uint64Field("change-time", uint64(c.Time.UnixMilli()), ts),
+				telemetry.StringField("user", c.User, ts),
+				telemetry.StringField("terminal", c.Terminal, ts),
+				telemetry.StringField("command-summary", c.summary(), ts),
+				telemetry.Uint32Field("command-count", uint32(len(c.Commands)), ts),
+				telemetry.Uint64Field("running-config-version", c.ID, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "config_changes",
+		EncodingPath:        "Cisco-NX-OS-device:System/accounting-items/log-items/Entry-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+//go:generate sh -c "go run . schema config > ../config/schema/config.schema.json"
+//go:generate sh -c "go run . schema scenario > ../config/schema/scenario.schema.json"
+//go:generate sh -c "go run . schema playlist > ../config/schema/playlist.schema.json"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// durationPattern matches the durations time.ParseDuration accepts
+const durationPattern = `^(0|-?([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|ms|s|m|h))+$`
+
+// schemaEnums are the choices of string settings, by type and key. The empty
+// string selects the default where a setting has one.
+var schemaEnums = map[string][]string{
+	"SimulationConfig.clock":    {clockWall, clockMonotonic},
+	"CatchUpConfig.behavior":    {catchUpOff, catchUpBackfill, catchUpSkip},
+	"LoadProfileConfig.shape":   {"", loadLinear, loadStep, loadSpike, loadSawtooth},
+	"StateConfig.backend":       {"", stateRedis, stateEtcd},
+	"CollectorConfig.encoding":  {"", encodingGPBKV, encodingCompact, encodingJSON},
+	"PcapSinkConfig.encoding":   {"", encodingGPBKV, encodingCompact, encodingJSON},
+	"DialoutConfig.encoding":    {"", encodingGPBKV, encodingCompact, encodingJSON},
+	"DialoutConfig.transport":   {"", transportGRPC, transportUDP},
+	"NodeTemplateConfig.role":   {"", roleLeaf, roleSpine},
+	"NodeTemplateConfig.bundle": bundleNames(),
+	"NodeConfig.bundle":         bundleNames(),
+	"Config.bundle":             bundleNames(),
+	"Config.platform":           platforms,
+	"BoundConfig.behavior":      {"", "clamp", "reflect", "wrap"},
+	"TunnelConfig.mode":         {"", "gre", "ipip"},
+	"ExternalPeerConfig.mode":   {"", borderFullTable, borderDefaultOnly},
+	"ITDServiceConfig.probe":    {"", "icmp", "tcp", "udp", "http", "dns"},
+	"AAAServerConfig.protocol":  {"", "tacacs", "radius"},
+	"ScenarioEvent.action":      slices.Sorted(maps.Keys(scenarioActions)),
+	"Priorities.additionalKeys": {"high", "normal", "low"},
+}
+
+// jsonSchemas are the file formats the schema command describes, by name
+var jsonSchemas = map[string]struct {
+	title string
+	root  reflect.Type
+}{
+	"config":   {"cisco-mdt-generator configuration", reflect.TypeOf(Config{})},
+	"scenario": {"cisco-mdt-generator scenario", reflect.TypeOf(Scenario{})},
+	"playlist": {"cisco-mdt-generator playlist", reflect.TypeOf(Playlist{})},
+}
+
+func newSchemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema [config|scenario|playlist]",
+		Short: "Print the JSON Schema of configuration, scenario or playlist files",
+		Long: "Prints a JSON Schema (draft 2020-12) of configuration files, including their\n" +
+			"nodes and node templates, or of scenario or playlist files, for editor validation and\n" +
+			"completion and for checking files in CI. The default is config.",
+		Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"config", "scenario", "playlist"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kind := "config"
+			if len(args) > 0 {
+				kind = args[0]
+			}
+			return writeJSONSchema(cmd.OutOrStdout(), kind)
+		},
+	}
+}
+
+// writeJSONSchema writes the schema of a file format as indented JSON
+func writeJSONSchema(w io.Writer, kind string) error {
+	s, ok := jsonSchemas[kind]
+	if !ok {
+		return fmt.Errorf("unknown schema %q", kind)
+	}
+	b := &schemaBuilder{root: s.root, defs: make(map[string]any)}
+	doc := b.object(s.root)
+	doc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	doc["title"] = s.title
+	if len(b.defs) > 0 {
+		doc["$defs"] = b.defs
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(doc)
+}
+
+// schemaBuilder derives a JSON Schema from the types files are decoded into.
+// Named structs become definitions, so recursive types such as scenario
+// branches refer to themselves.
+type schemaBuilder struct {
+	root reflect.Type
+	defs map[string]any
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	yamlNodeType = reflect.TypeOf(yaml.Node{})
+)
+
+// schema returns the schema of values of type t
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch t {
+	case durationType:
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	case yamlNodeType:
+		// Node template and node overrides are top-level settings
+		return map[string]any{"$ref": "#"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		values := b.schema(t.Elem())
+		if enum, ok := schemaEnums[t.Name()+".additionalKeys"]; ok {
+			values["enum"] = enum
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}
+	case reflect.Struct:
+		if t == b.root {
+			return map[string]any{"$ref": "#"}
+		}
+		if _, ok := b.defs[t.Name()]; !ok {
+			b.defs[t.Name()] = nil // placeholder for types that contain themselves
+			b.defs[t.Name()] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// object returns the schema of a struct decoded from a YAML mapping. Unknown
+// keys are not allowed, as strict decoding rejects them.
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		prop := b.schema(f.Type)
+		if enum, ok := schemaEnums[t.Name()+"."+name]; ok {
+			prop["enum"] = enum
+		}
+		props[name] = prop
+	}
+	return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"cisco-mdt-generator/pkg/mdt_dialout"
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// conformanceCase is one message sequence sent on its own dial-out stream
+type conformanceCase struct {
+	name        string
+	valid       bool // a conforming collector must ingest it
+	description string
+	messages    []*mdt_dialout.MdtDialoutArgs
+}
+
+// conformanceResult is how the collector reacted to a case
+type conformanceResult struct {
+	name     string
+	valid    bool
+	accepted bool
+	alive    bool // the collector still accepts valid telemetry afterwards
+	detail   string
+	latency  time.Duration
+}
+
+// conformanceOptions are the flags of the conformance command
+type conformanceOptions struct {
+	server     string
+	nodeID     string
+	configPath string
+	cases      []string
+	list       bool
+	timeout    time.Duration
+	csvPath    string
+}
+
+func newConformanceCmd() *cobra.Command {
+	var o conformanceOptions
+	cmd := &cobra.Command{
+		Use:   "conformance",
+		Short: "Send known-good and malformed message sequences to a collector and report which it ingests",
+		Long: "Runs a battery of dial-out streams against a collector, one stream per case. Valid\n" +
+			"cases must be accepted; malformed cases may be accepted or rejected, but the\n" +
+			"collector must keep accepting valid telemetry after each of them.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exitWith(runConformance(o))
+		},
+	}
+	fs := cmd.Flags()
+	addServerFlag(fs, &o.server)
+	fs.StringVar(&o.nodeID, "node", "leaf-101", "Simulated NX-OS leaf node-id-str")
+	fs.StringVar(&o.configPath, "config", "config/generator.yaml", "Path to YAML configuration file")
+	fs.StringArrayVar(&o.cases, "case", nil, "Only run this case, repeatable")
+	fs.BoolVar(&o.list, "list", false, "List the cases without running them")
+	fs.DurationVar(&o.timeout, "timeout", 5*time.Second, "How long to wait for the collector's verdict per case")
+	fs.StringVar(&o.csvPath, "csv", "", "Also write the report as CSV to this file")
+	cmd.MarkFlagFilename("config", "yaml", "yml")
+	return cmd
+}
+
+// runConformance runs every selected case and prints the report. It returns
+// the process exit code: 1 when a valid case was rejected or the collector
+// stopped accepting valid telemetry, 2 when the suite could not run.
+func runConformance(o conformanceOptions) int {
+	cfg, err := LoadConfig(o.configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 2
+	}
+	creds, err := dialCredentials(cfg.TLS, o.nodeID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up TLS: %v\n", err)
+		return 2
+	}
+
+	log.SetOutput(io.Discard)
+	cases := conformanceCases(cfg, o.nodeID, time.Now())
+	probe := cases[0]
+	if o.list {
+		for _, c := range cases {
+			fmt.Printf("%-22s %-9s %s\n", c.name, caseKind(c.valid), c.description)
+		}
+		return 0
+	}
+	if len(o.cases) > 0 {
+		for _, name := range o.cases {
+			if !slices.ContainsFunc(cases, func(c conformanceCase) bool { return c.name == name }) {
+				fmt.Fprintf(os.Stderr, "Unknown case %q (--list shows them)\n", name)
+				return 2
+			}
+		}
+		cases = slices.DeleteFunc(cases, func(c conformanceCase) bool { return !slices.Contains(o.cases, c.name) })
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds), grpc.WithDisableRetry()}
+	fmt.Printf("Running %d conformance cases against collector %s\n", len(cases), o.server)
+	fmt.Printf("%-22s %-9s %-9s %-10s %s\n", "CASE", "KIND", "RESULT", "LATENCY", "DETAIL")
+
+	var results []conformanceResult
+	failures := 0
+	for _, c := range cases {
+		r := sendCase(o.server, dialOpts, c, o.timeout)
+		if !r.valid {
+			// A malformed case must not break ingestion of what follows
+			check := sendCase(o.server, dialOpts, probe, o.timeout)
+			r.alive = check.accepted
+			if !r.alive {
+				r.detail += "; collector rejected valid telemetry afterwards: " + check.detail
+			}
+		}
+		if (r.valid && !r.accepted) || !r.alive {
+			failures++
+		}
+		results = append(results, r)
+		fmt.Printf("%-22s %-9s %-9s %-10s %s\n", r.name, caseKind(r.valid), r.verdict(), r.latency.Round(time.Millisecond), r.detail)
+	}
+
+	var validOK, valid, malformedRejected, malformed int
+	for _, r := range results {
+		if r.valid {
+			valid++
+			if r.accepted {
+				validOK++
+			}
+		} else {
+			malformed++
+			if !r.accepted {
+				malformedRejected++
+			}
+		}
+	}
+	fmt.Printf("Summary: %d of %d valid cases accepted, %d of %d malformed cases rejected, %d failures\n",
+		validOK, valid, malformedRejected, malformed, failures)
+
+	if o.csvPath != "" {
+		if err := writeConformanceReport(o.csvPath, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			return 2
+		}
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func caseKind(valid bool) string {
+	if valid {
+		return "valid"
+	}
+	return "malformed"
+}
+
+// verdict names the result, FAIL marking what a conforming collector must
+// not do
+func (r conformanceResult) verdict() string {
+	switch {
+	case r.valid && !r.accepted, !r.alive:
+		return "FAIL"
+	case r.accepted:
+		return aclAccepted
+	}
+	return aclRejected
+}
+
+// sendCase sends the messages of a case on a new connection and classifies
+// how the stream ended, like the ACL probe
+func sendCase(server string, dialOpts []grpc.DialOption, c conformanceCase, timeout time.Duration) conformanceResult {
+	r := conformanceResult{name: c.name, valid: c.valid, alive: true}
+	conn, err := grpc.NewClient(server, dialOpts...)
+	if err != nil {
+		r.detail = err.Error()
+		return r
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	stream, err := mdt_dialout.NewGRPCMdtDialoutClient(conn).MdtDialout(ctx)
+	for i := 0; err == nil && i < len(c.messages); i++ {
+		err = stream.Send(c.messages[i])
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		// Send reports EOF once the collector ended the RPC; the status
+		// comes from CloseAndRecv
+		_, err = stream.CloseAndRecv()
+	}
+	r.latency = time.Since(start)
+
+	if err == nil || errors.Is(err, io.EOF) {
+		r.accepted, r.detail = true, "stream completed"
+		return r
+	}
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.DeadlineExceeded:
+		r.accepted, r.detail = true, "stream held open"
+	case codes.Internal:
+		// Collectors that never reply end the stream with an empty OK status
+		r.accepted, r.detail = true, "stream completed without reply"
+	default:
+		r.detail = fmt.Sprintf("%s: %s", st.Code(), st.Message())
+	}
+	return r
+}
+
+// conformanceCases builds the battery from a simulated node, so valid cases
+// carry realistic telemetry
+func conformanceCases(cfg *Config, nodeID string, now time.Time) []conformanceCase {
+	syslog, _ := NewSyslog(SyslogConfig{}, nodeID)
+	sim := NewSimulator(cfg, nodeID, 0, syslog, now)
+	interval := sim.BuildTelemetry(now)
+	sim.OnChange.Commit(interval)
+	base := interval[0]
+	ts := uint64(now.UnixMilli())
+
+	payload := func(m *telemetry.Telemetry) []byte {
+		b, _ := m.Marshal()
+		return b
+	}
+	args := func(payloads ...[]byte) []*mdt_dialout.MdtDialoutArgs {
+		msgs := make([]*mdt_dialout.MdtDialoutArgs, len(payloads))
+		for i, p := range payloads {
+			msgs[i] = &mdt_dialout.MdtDialoutArgs{ReqId: 1, Data: p}
+		}
+		return msgs
+	}
+	message := func(path string, rows ...*telemetry.TelemetryField) *telemetry.Telemetry {
+		return &telemetry.Telemetry{
+			NodeIDStr:           nodeID,
+			SubscriptionIDStr:   "conformance",
+			EncodingPath:        path,
+			CollectionID:        1,
+			CollectionStartTime: ts,
+			MsgTimestamp:        ts,
+			CollectionEndTime:   ts,
+			DataGpbkv:           rows,
+		}
+	}
+	row := func(key string, content ...*telemetry.TelemetryField) *telemetry.TelemetryField {
+		return telemetry.RowField([]*telemetry.TelemetryField{telemetry.StringField("id", key, ts)}, content, ts)
+	}
+
+	var intervals [][]byte
+	for _, m := range interval {
+		intervals = append(intervals, payload(m))
+	}
+	for i := 1; i <= 2; i++ {
+		next := now.Add(time.Duration(i) * 5 * time.Second)
+		sim.Step(next)
+		messages := sim.BuildTelemetry(next)
+		sim.OnChange.Commit(messages)
+		for _, m := range messages {
+			intervals = append(intervals, payload(m))
+		}
+	}
+
+	typed := &telemetry.TelemetryField{Name: "bytes-value", Timestamp: ts, BytesValue: []byte{0xde, 0xad, 0xbe, 0xef}}
+	float32Value, sint32Value, sint64Value := float32(1.5), int32(-42), int64(-1<<40)
+	allTypes := message("Cisco-NX-OS-device:System/conformance-items/types-items", row("types",
+		telemetry.StringField("string-value", "text", ts),
+		telemetry.Uint32Field("uint32-value", 1<<31, ts),
+		telemetry.Uint64Field("uint64-value", 1<<63, ts),
+		telemetry.BoolField("bool-value", true, ts),
+		telemetry.DoubleField("double-value", 0.125, ts),
+		&telemetry.TelemetryField{Name: "float-value", Timestamp: ts, FloatValue: &float32Value},
+		&telemetry.TelemetryField{Name: "sint32-value", Timestamp: ts, Sint32Value: &sint32Value},
+		&telemetry.TelemetryField{Name: "sint64-value", Timestamp: ts, Sint64Value: &sint64Value},
+		typed,
+	))
+
+	nested := func(depth int) *telemetry.Telemetry {
+		leaf := telemetry.Uint32Field("depth", uint32(depth), ts)
+		for i := depth; i > 0; i-- {
+			leaf = telemetry.ContainerField(fmt.Sprintf("level-%d", i), []*telemetry.TelemetryField{leaf}, ts)
+		}
+		return message("Cisco-NX-OS-device:System/conformance-items/nested-items", row("nested", leaf))
+	}
+	rows := func(n int) *telemetry.Telemetry {
+		m := message("Cisco-NX-OS-device:System/conformance-items/rows-items")
+		for i := range n {
+			m.DataGpbkv = append(m.DataGpbkv, row(fmt.Sprintf("row-%d", i),
+				telemetry.StringField("description", strings.Repeat("x", 150), ts),
+				telemetry.Uint64Field("counter", uint64(i), ts)))
+		}
+		return m
+	}
+
+	nonASCII := base.Clone()
+	nonASCII.DataGpbkv = append(nonASCII.DataGpbkv, row("ünïcødé ✓", telemetry.StringField("description", "Uplink to spine-1 — 100G 光", ts)))
+
+	badUTF8 := base.Clone()
+	badUTF8.NodeIDStr = nodeID + "\xff\xfe"
+	noNode := base.Clone()
+	noNode.NodeIDStr = ""
+	noPath := base.Clone()
+	noPath.EncodingPath = ""
+	future := base.Clone()
+	future.MsgTimestamp = uint64(now.AddDate(1, 0, 0).UnixMilli())
+	future.CollectionStartTime, future.CollectionEndTime = future.MsgTimestamp, future.MsgTimestamp
+	earlier := base.Clone()
+	earlier.MsgTimestamp -= uint64(time.Hour.Milliseconds())
+	earlier.CollectionStartTime, earlier.CollectionEndTime = earlier.MsgTimestamp, earlier.MsgTimestamp
+
+	valid := payload(base)
+	wrongType := protowire.AppendTag(nil, 6, protowire.VarintType)
+	wrongType = protowire.AppendVarint(wrongType, 42)
+	unknown := protowire.AppendTag(append([]byte{}, valid...), 50, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, "future field")
+	errorReport := args(valid)
+	errorReport[0].Errors = "Sensor path error: sensor-group conformance"
+
+	return []conformanceCase{
+		{name: "single-message", valid: true, description: "One message of the first subscription", messages: args(valid)},
+		{name: "full-interval", valid: true, description: "Every subscription of one interval", messages: args(intervals[:len(interval)]...)},
+		{name: "multiple-intervals", valid: true, description: "Three consecutive intervals on one stream", messages: args(intervals...)},
+		{name: "all-value-types", valid: true, description: "Every scalar value type of TelemetryField", messages: args(payload(allTypes))},
+		{name: "nested-containers", valid: true, description: "Containers nested eight levels deep", messages: args(payload(nested(8)))},
+		{name: "large-message", valid: true, description: "One message of about 1 MB", messages: args(payload(rows(4000)))},
+		{name: "unicode-strings", valid: true, description: "Keys and values with non-ASCII UTF-8", messages: args(payload(nonASCII))},
+		{name: "unknown-field", valid: true, description: "An unknown field that decoders must skip", messages: args(unknown)},
+		{name: "req-id-zero", valid: true, description: "MdtDialoutArgs without ReqId", messages: []*mdt_dialout.MdtDialoutArgs{{Data: valid}}},
+		{name: "empty-stream", valid: true, description: "A stream closed without messages", messages: nil},
+		{name: "empty-data", description: "MdtDialoutArgs without data", messages: args(nil)},
+		{name: "garbage", description: "Random bytes instead of a Telemetry message", messages: args([]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 0x00, 0x13, 0x37})},
+		{name: "truncated", description: "A message cut in the middle", messages: args(valid[:len(valid)/2])},
+		{name: "wrong-wire-type", description: "encoding_path encoded as a varint", messages: args(append(wrongType, valid...))},
+		{name: "invalid-utf8", description: "node_id_str that is not UTF-8", messages: args(payload(badUTF8))},
+		{name: "missing-node-id", description: "No node_id_str", messages: args(payload(noNode))},
+		{name: "missing-encoding-path", description: "No encoding_path", messages: args(payload(noPath))},
+		{name: "device-error", description: "MdtDialoutArgs reporting an error with its data", messages: errorReport},
+		{name: "future-timestamp", description: "Timestamps one year ahead", messages: args(payload(future))},
+		{name: "time-backwards", description: "A message an hour older than the previous one", messages: args(valid, payload(earlier))},
+		{name: "deep-nesting", description: "Containers nested 200 levels deep", messages: args(payload(nested(200)))},
+		{name: "oversized", description: "A 5 MB message, beyond the default gRPC limit", messages: args(payload(rows(20000)))},
+	}
+}
+
+// writeConformanceReport writes case results as CSV
+func writeConformanceReport(path string, results []conformanceResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"case", "kind", "result", "latency_ms", "detail"})
+	for _, r := range results {
+		w.Write([]string{r.name, caseKind(r.valid), r.verdict(), strconv.FormatInt(r.latency.Milliseconds(), 10), r.detail})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Control serves an HTTP API to drive the simulated nodes of the process
+// while they run, e.g. with curl during a demo: flap a BGP neighbor, bring a
+// VNI down and up, change the counter rates and pause the streams. Requests
+// address the nodes matching the node query parameter, a node-id-str or
+// glob pattern, every node when it is absent.
+type Control struct {
+	mu    sync.Mutex
+	nodes []feedNode
+}
+
+// controlNode is the state of a node the control API reports
+type controlNode struct {
+	Node         string               `json:"node"`
+	Paused       bool                 `json:"paused"`
+	MessagesSent uint64               `json:"messages_sent"`
+	BGPNeighbors []controlBGPNeighbor `json:"bgp_neighbors"`
+	VNIs         []controlVNI         `json:"vnis"`
+	Counters     map[string]int       `json:"counters"` // simulation.counters
+}
+
+type controlBGPNeighbor struct {
+	Address   string `json:"address"`
+	State     string `json:"state"`
+	FlapCount uint32 `json:"flap_count"`
+}
+
+type controlVNI struct {
+	VNI      uint32 `json:"vni"`
+	State    string `json:"state"`
+	MACCount uint32 `json:"mac_count"`
+}
+
+// startControl serves the control API on addr until ctx is done, returning
+// nil when addr is empty
+func startControl(ctx context.Context, addr string) (*Control, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start control listener: %w", err)
+	}
+	c := &Control{}
+	server := &http.Server{Handler: c.handler(), ReadHeaderTimeout: 10 * time.Second}
+	context.AfterFunc(ctx, func() { server.Close() })
+	go server.Serve(ln)
+	log.Printf("Serving the control API on http://%s/nodes", ln.Addr())
+	return c, nil
+}
+
+// Add registers a node the control API can address. A nil Control ignores
+// it.
+func (c *Control) Add(sim *Simulator, scenario *ScenarioEngine) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nodes = append(c.nodes, feedNode{sim: sim, scenario: scenario})
+}
+
+// handler routes the endpoints of the control API
+func (c *Control) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /nodes", c.getNodes)
+	mux.HandleFunc("POST /bgp/{neighbor}/flap", func(w http.ResponseWriter, r *http.Request) {
+		c.inject(w, r, "bgp_flap", r.PathValue("neighbor"))
+	})
+	mux.HandleFunc("POST /vnis/{vni}/down", func(w http.ResponseWriter, r *http.Request) {
+		c.inject(w, r, "vni_down", r.PathValue("vni"))
+	})
+	mux.HandleFunc("POST /vnis/{vni}/up", c.vniUp)
+	mux.HandleFunc("PATCH /counters", c.setCounters)
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		c.setPaused(w, r, true)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		c.setPaused(w, r, false)
+	})
+	return mux
+}
+
+// match returns the nodes addressed by the node query parameter
+func (c *Control) match(r *http.Request) ([]feedNode, error) {
+	pattern := r.URL.Query().Get("node")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid node pattern %q", pattern)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var matched []feedNode
+	for _, n := range c.nodes {
+		if ok, _ := path.Match(pattern, n.sim.nodeID); pattern == "" || ok {
+			matched = append(matched, n)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, errNoNode{pattern}
+	}
+	return matched, nil
+}
+
+// errNoNode is returned when no node matches the node query parameter
+type errNoNode struct{ pattern string }
+
+func (e errNoNode) Error() string { return fmt.Sprintf("no node matches %q", e.pattern) }
+
+// each applies fn to every addressed node under its lock and answers with
+// their state. When check is set it must accept every node first, so a
+// request changes all the nodes it addresses or none.
+func (c *Control) each(w http.ResponseWriter, r *http.Request, check, fn func(n feedNode) error) {
+	nodes, err := c.match(r)
+	if err != nil {
+		controlError(w, err)
+		return
+	}
+	locked := func(n feedNode, fn func(n feedNode) error) error {
+		n.sim.Lock()
+		defer n.sim.Unlock()
+		if err := fn(n); err != nil {
+			return fmt.Errorf("%s: %w", n.sim.nodeID, err)
+		}
+		return nil
+	}
+	if check != nil {
+		for _, n := range nodes {
+			if err := locked(n, check); err != nil {
+				controlError(w, err)
+				return
+			}
+		}
+	}
+	states := make([]controlNode, 0, len(nodes))
+	for _, n := range nodes {
+		err := locked(n, func(n feedNode) error {
+			err := fn(n)
+			states = append(states, nodeState(n.sim))
+			return err
+		})
+		if err != nil {
+			controlError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, states)
+}
+
+func (c *Control) getNodes(w http.ResponseWriter, r *http.Request) {
+	c.each(w, r, nil, func(n feedNode) error { return nil })
+}
+
+// inject applies a scenario action to a target, reverted after the
+// duration query parameter when there is one
+func (c *Control) inject(w http.ResponseWriter, r *http.Request, action, target string) {
+	ev := ScenarioEvent{Action: action, Target: target}
+	if d := r.URL.Query().Get("duration"); d != "" {
+		var err error
+		if ev.Duration, err = time.ParseDuration(d); err != nil {
+			controlError(w, fmt.Errorf("invalid duration: %w", err))
+			return
+		}
+	}
+	check := func(n feedNode) error { return scenarioActions[action].check(n.sim, ev) }
+	c.each(w, r, check, func(n feedNode) error {
+		return n.scenario.Inject(n.sim, ev, time.Now())
+	})
+}
+
+// vniUp brings a VNI up, whether a vni_down event or the control API took
+// it down
+func (c *Control) vniUp(w http.ResponseWriter, r *http.Request) {
+	vni, err := parseVNITarget(r.PathValue("vni"))
+	if err != nil {
+		controlError(w, err)
+		return
+	}
+	check := func(n feedNode) error {
+		if n.sim.FindVNI(vni) == nil {
+			return fmt.Errorf("unknown VNI %d", vni)
+		}
+		return nil
+	}
+	c.each(w, r, check, func(n feedNode) error { return n.sim.SetVNIUp(vni, true) })
+}
+
+// setCounters overlays the counter increments of simulation.counters with
+// the fields of the JSON or YAML body
+func (c *Control) setCounters(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		controlError(w, err)
+		return
+	}
+	check := func(n feedNode) error {
+		_, err := overlayCounters(n.sim.cfg, body)
+		return err
+	}
+	c.each(w, r, check, func(n feedNode) error {
+		counters, err := overlayCounters(n.sim.cfg, body)
+		if err != nil {
+			return err
+		}
+		n.sim.cfg.Simulation.Counters = counters
+		n.sim.event("config_update", "simulation", "Counter increments set by the control API: %+v", counters)
+		return nil
+	})
+}
+
+// overlayCounters returns the counter increments of cfg overlaid with the
+// fields of body, once the configuration with them is valid
+func overlayCounters(cfg *Config, body []byte) (CountersConfig, error) {
+	next := *cfg
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	dec.KnownFields(true)
+	if err := dec.Decode(&next.Simulation.Counters); err != nil {
+		return CountersConfig{}, fmt.Errorf("failed to parse counters: %w", err)
+	}
+	if err := validateConfig(&next); err != nil {
+		return CountersConfig{}, fmt.Errorf("invalid counters: %w", err)
+	}
+	return next.Simulation.Counters, nil
+}
+
+// setPaused pauses or resumes the telemetry streams
+func (c *Control) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	c.each(w, r, nil, func(n feedNode) error {
+		if n.sim.Paused == paused {
+			return nil
+		}
+		n.sim.Paused = paused
+		if paused {
+			n.sim.event("stream_paused", n.sim.nodeID, "%s: telemetry stream paused by the control API", n.sim.nodeID)
+		} else {
+			n.sim.event("stream_resumed", n.sim.nodeID, "%s: telemetry stream resumed by the control API", n.sim.nodeID)
+		}
+		return nil
+	})
+}
+
+// nodeState reports the state of a node, with its lock held
+func nodeState(s *Simulator) controlNode {
+	state := controlNode{
+		Node:         s.nodeID,
+		Paused:       s.Paused,
+		MessagesSent: s.MessagesSent,
+		BGPNeighbors: []controlBGPNeighbor{},
+		VNIs:         []controlVNI{},
+	}
+	// The counters keep the names of the configuration
+	out, _ := yaml.Marshal(s.cfg.Simulation.Counters)
+	yaml.Unmarshal(out, &state.Counters)
+	for _, n := range s.BGPNeighbors {
+		state.BGPNeighbors = append(state.BGPNeighbors, controlBGPNeighbor{Address: n.Address, State: n.State, FlapCount: n.FlapCount})
+	}
+	for _, v := range s.VNIs {
+		state.VNIs = append(state.VNIs, controlVNI{VNI: v.VNIID, State: v.State, MACCount: v.MACCount})
+	}
+	return state
+}
+
+// controlError answers with an error: 404 when no node matches, 400
+// otherwise
+func controlError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	if errors.As(err, new(errNoNode)) {
+		code = http.StatusNotFound
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// CoPP classes punted traffic is policed into
+const (
+	coppClassCritical = "copp-system-p-class-critical"
+	coppClassNormal   = "copp-system-p-class-normal"
+	coppClassL2       = "copp-system-p-class-l2-default"
+)
+
+// cpuPerPuntedPPS is the CPU load, in percent, caused by each policed packet
+// per second that reaches the supervisor
+const cpuPerPuntedPPS = 0.04
+
+// CoPPClass tracks control-plane policing counters for a class
+type CoPPClass struct {
+	Name      string
+	PolicePPS uint64
+
+	ConformedPkts uint64
+	ViolatedPkts  uint64
+}
+
+// CPUState tracks supervisor CPU utilization in percent
+type CPUState struct {
+	User   float64
+	Kernel float64
+	Idle   float64
+}
+
+// initCoPPClasses creates the CoPP classes of the default strict profile
+func initCoPPClasses() []*CoPPClass {
+	return []*CoPPClass{
+		{Name: coppClassCritical, PolicePPS: 3000},
+		{Name: coppClassNormal, PolicePPS: 1500},
+		{Name: coppClassL2, PolicePPS: 500},
+	}
+}
+
+// findCoPPClass returns the CoPP class with the given name, or nil
+func (s *Simulator) findCoPPClass(name string) *CoPPClass {
+	for _, c := range s.CoPP {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// police counts punted packets against a class's rate and returns the
+// packets that conformed and reached the CPU
+func (c *CoPPClass) police(punted uint64, seconds float64) uint64 {
+	allowed := uint64(float64(c.PolicePPS) * seconds)
+	if punted <= allowed {
+		c.ConformedPkts += punted
+		return punted
+	}
+	c.ConformedPkts += allowed
+	c.ViolatedPkts += punted - allowed
+	return allowed
+}
+
+// stepControlPlane polices packets punted to the supervisor and derives CPU
+// utilization from what CoPP lets through, so a broadcast storm shows up
+// consistently in interface, CoPP and CPU telemetry
+func (s *Simulator) stepControlPlane(arpPunts uint64, seconds float64) {
+	var reachedCPU uint64
+
+	// BGP keepalives and updates from every established neighbor
+	var bgpPunts uint64
+	for _, n := range s.BGPNeighbors {
+		if n.State == "Established" {
+			bgpPunts += uint64((1 + s.rng.Float64()) * seconds)
+		}
+	}
+	if c := s.findCoPPClass(coppClassCritical); c != nil {
+		reachedCPU += c.police(bgpPunts, seconds)
+	}
+
+	// ARP requests and replies
+	if c := s.findCoPPClass(coppClassNormal); c != nil {
+		reachedCPU += c.police(arpPunts, seconds)
+	}
+
+	// Spanning tree and other layer-2 control traffic
+	if c := s.findCoPPClass(coppClassL2); c != nil {
+		reachedCPU += c.police(uint64(float64(s.rng.Intn(5))*seconds), seconds)
+	}
+
+	pps := 0.0
+	if seconds > 0 {
+		pps = float64(reachedCPU) / seconds
+	}
+
+	s.CPU.User = s.bound("cpu_user").apply(4 + s.rng.Float64()*4 + pps*cpuPerPuntedPPS*0.7)
+	s.CPU.Kernel = s.bound("cpu_kernel").apply(2 + s.rng.Float64()*3 + pps*cpuPerPuntedPPS*0.3)
+	if total := s.CPU.User + s.CPU.Kernel; total > 100 {
+		s.CPU.User = s.CPU.User * 100 / total
+		s.CPU.Kernel = s.CPU.Kernel * 100 / total
+	}
+	s.CPU.Idle = 100 - s.CPU.User - s.CPU.Kernel
+}
+
+// buildCoPPTelemetry reports per-class control-plane policing counters
+func buildCoPPTelemetry(ts uint64, nodeID string, classes []*CoPPClass) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, c := range classes {
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("class-map", c.Name, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.Uint64Field("police-rate-pps", c.PolicePPS, ts),
+				telemetry.Uint64Field("conformed-packets", c.ConformedPkts, ts),
+				telemetry.Uint64Field("violated-packets", c.ViolatedPkts, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "copp_stats",
+		EncodingPath:        "Cisco-NX-OS-device:System/copp-items/classp-items/CPlane-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildCPUTelemetry reports supervisor CPU utilization
+func buildCPUTelemetry(ts uint64, nodeID string, cpu CPUState) *telemetry.Telemetry {
+	row := telemetry.RowField(
+		[]*telemetry.TelemetryField{
+			telemetry.StringField("cpu", "all", ts),
+		},
+		[]*telemetry.TelemetryField{
+			telemetry.DoubleField("user-percent", cpu.User, ts),
+			telemetry.DoubleField("kernel-percent", cpu.Kernel, ts),
+			telemetry.DoubleField("idle-percent", cpu.Idle, ts),
+		},
+		ts,
+	)
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "cpu_utilization",
+		EncodingPath:        "Cisco-NX-OS-device:System/procsys-items/syscpusummary-items",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           []*telemetry.TelemetryField{row},
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"cisco-mdt-generator/pkg/admin"
+)
+
+// newCtlCmd builds the client commands for the admin service of a running
+// simulator
+func newCtlCmd() *cobra.Command {
+	var addr string
+	cmd := &cobra.Command{
+		Use:   "ctl",
+		Short: "Control a running simulator through its admin service",
+	}
+	cmd.PersistentFlags().StringVar(&addr, "addr", "localhost:50051", "Address of the simulator's --grpc-addr listener")
+
+	// withClient runs fn with an admin client connected to addr
+	withClient := func(fn func(ctx context.Context, c admin.AdminClient) error) error {
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return fn(context.Background(), admin.NewAdminClient(conn))
+	}
+
+	state := &cobra.Command{
+		Use:   "state",
+		Short: "Show the simulated state",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c admin.AdminClient) error {
+				s, err := c.GetState(ctx, &admin.GetStateRequest{})
+				if err != nil {
+					return err
+				}
+				printState(os.Stdout, s)
+				return nil
+			})
+		},
+	}
+
+	var duration time.Duration
+	var params []string
+	inject := &cobra.Command{
+		Use:   "inject ACTION TARGET",
+		Short: "Apply a scenario action now, e.g. broadcast_storm eth1/1",
+		Args:  cobra.ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			var actions []string
+			for name := range scenarioActions {
+				actions = append(actions, name)
+			}
+			return actions, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req := &admin.InjectEventRequest{
+				Action:     args[0],
+				Target:     args[1],
+				DurationMs: duration.Milliseconds(),
+				Params:     make(map[string]string),
+			}
+			for _, p := range params {
+				k, v, ok := strings.Cut(p, "=")
+				if !ok {
+					return fmt.Errorf("invalid param %q, expected key=value", p)
+				}
+				req.Params[k] = v
+			}
+			return withClient(func(ctx context.Context, c admin.AdminClient) error {
+				_, err := c.InjectEvent(ctx, req)
+				return err
+			})
+		},
+	}
+	inject.Flags().DurationVar(&duration, "duration", 0, "Revert the event after this long (0 = never)")
+	inject.Flags().StringArrayVar(&params, "param", nil, "Action parameter as key=value, repeatable")
+
+	updateConfig := &cobra.Command{
+		Use:   "update-config FILE",
+		Short: "Overlay the simulation section from a YAML file (- for stdin)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data []byte
+			var err error
+			if args[0] == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			return withClient(func(ctx context.Context, c admin.AdminClient) error {
+				_, err := c.UpdateConfig(ctx, &admin.UpdateConfigRequest{YAML: string(data)})
+				return err
+			})
+		},
+	}
+
+	events := &cobra.Command{
+		Use:   "events",
+		Short: "Follow ground-truth simulation events",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c admin.AdminClient) error {
+				stream, err := c.StreamEvents(ctx, &admin.StreamEventsRequest{})
+				if err != nil {
+					return err
+				}
+				for {
+					ev, err := stream.Recv()
+					if err != nil {
+						return err
+					}
+					fmt.Printf("%s %-20s %-12s %s\n", time.UnixMilli(ev.TimestampMs).Format(time.RFC3339),
+						ev.Type, ev.Target, ev.Detail)
+				}
+			})
+		},
+	}
+
+	var last int
+	var out string
+	capture := &cobra.Command{
+		Use:   "capture",
+		Short: "Show the last messages sent on the dial-out streams, or write them to a recording",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if last < 0 {
+				return fmt.Errorf("last must be non-negative")
+			}
+			return withClient(func(ctx context.Context, c admin.AdminClient) error {
+				s, err := c.GetCapture(ctx, &admin.GetCaptureRequest{Last: uint32(last)})
+				if err != nil {
+					return err
+				}
+				if out == "" {
+					return printCapture(os.Stdout, s)
+				}
+				if err := writeCaptureFile(out, s, writeCaptureRecording); err != nil {
+					return err
+				}
+				fmt.Printf("Wrote %d messages to %s\n", len(s.Messages), out)
+				return nil
+			})
+		},
+	}
+	capture.Flags().IntVar(&last, "last", 0, "Most recent messages to show (0 = all captured)")
+	capture.Flags().StringVarP(&out, "out", "o", "", "Write the payloads to this recording for decode or replay instead")
+
+	cmd.AddCommand(state, inject, updateConfig, events, capture)
+	return cmd
+}
+
+// printState writes a human-readable summary of a simulator state snapshot
+func printState(w io.Writer, s *admin.SimulatorState) {
+	fmt.Fprintf(w, "Node %s, running %s, CPU %.1f%%\n", s.NodeID,
+		(time.Duration(s.ElapsedMs) * time.Millisecond).Round(time.Second), s.CPUPercent)
+	fmt.Fprintf(w, "VXLAN ingress %d bytes, egress %d bytes\n", s.IngressBytes, s.EgressBytes)
+	if s.EVPN != nil {
+		fmt.Fprintf(w, "EVPN routes: type-2 %d, type-3 %d, type-5 %d, total %d\n",
+			s.EVPN.Type2Routes, s.EVPN.Type3Routes, s.EVPN.Type5Routes, s.EVPN.TotalRoutes)
+	}
+
+	fmt.Fprintf(w, "\n%-16s %-8s %-14s %-10s %-6s %s\n", "NEIGHBOR", "AS", "STATE", "PFX RCVD", "FLAPS", "MAINT")
+	for _, n := range s.BGPNeighbors {
+		fmt.Fprintf(w, "%-16s %-8d %-14s %-10d %-6d %t\n", n.Address, n.RemoteAS, n.State,
+			n.PrefixesReceived, n.FlapCount, n.Maintenance)
+	}
+
+	fmt.Fprintf(w, "\n%-8s %-6s %-6s %-6s %-6s %s\n", "VNI", "STATE", "MACS", "VTEPS", "ARP", "ARP-SUPP")
+	for _, v := range s.VNIs {
+		fmt.Fprintf(w, "%-8d %-6s %-6d %-6d %-6d %t\n", v.VNIID, v.State, v.MACCount, v.VTEPCount,
+			v.ARPCount, v.ARPSuppression)
+	}
+
+	if len(s.Subscriptions) > 0 {
+		fmt.Fprintf(w, "\n%-24s %-8s %-9s %s\n", "SUBSCRIPTION", "ERRORS", "DISABLED", "LAST ERROR")
+		for _, sub := range s.Subscriptions {
+			fmt.Fprintf(w, "%-24s %-8d %-9t %s\n", sub.Subscription, sub.EncodeErrors, sub.Disabled, sub.LastError)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"cisco-mdt-generator/pkg/mdt_dialout"
+	"cisco-mdt-generator/pkg/recording"
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// tcpDialoutHeaderLen is the header of the NX-OS and IOS-XR TCP dial-out
+// transport: message type, encapsulation, header version and flags as
+// 16-bit values and the payload length as a 32-bit value
+const tcpDialoutHeaderLen = 12
+
+// decodeOptions are the flags of the decode command
+type decodeOptions struct {
+	hex     []string
+	noTimes bool
+}
+
+func newDecodeCmd() *cobra.Command {
+	var o decodeOptions
+	cmd := &cobra.Command{
+		Use:   "decode [files...]",
+		Short: "Pretty-print raw GPB-KV telemetry payloads",
+		Long: "Decodes MDT telemetry and prints the Telemetry tree. Each file (- for stdin) may\n" +
+			"hold a binary payload, a hex stream or hex dump copied from Wireshark, or a\n" +
+			"recording. Payloads may be wrapped in MdtDialoutArgs, a gRPC frame, TCP\n" +
+			"dial-out frames or an NX-OS UDP header; the wrappers are removed automatically.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && len(o.hex) == 0 {
+				return fmt.Errorf("no files or --hex payloads given")
+			}
+			return runDecode(os.Stdout, o, args)
+		},
+	}
+	cmd.Flags().StringArrayVarP(&o.hex, "hex", "x", nil, "Decode this hex payload, repeatable")
+	cmd.Flags().BoolVar(&o.noTimes, "raw-times", false, "Print timestamps as milliseconds only")
+	return cmd
+}
+
+// runDecode prints every payload of the --hex values and files
+func runDecode(w io.Writer, o decodeOptions, files []string) error {
+	for i, h := range o.hex {
+		data, err := parseHex(h)
+		if err != nil {
+			return fmt.Errorf("--hex %d: %w", i+1, err)
+		}
+		if err := printPayloads(w, o, fmt.Sprintf("--hex %d", i+1), data); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(data, []byte("MDTREC")) {
+			if err := printRecording(w, o, file, data); err != nil {
+				return err
+			}
+			continue
+		}
+		if isText(data) {
+			if data, err = parseHex(string(data)); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
+		if err := printPayloads(w, o, file, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printRecording prints every record of a recording
+func printRecording(w io.Writer, o decodeOptions, file string, data []byte) error {
+	r, err := recording.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	for i := 1; ; i++ {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: record %d: %w", file, i, err)
+		}
+		source := fmt.Sprintf("%s record %d at %s", file, i, rec.Timestamp.UTC().Format(time.RFC3339Nano))
+		if err := printPayloads(w, o, source, rec.Payload); err != nil {
+			return err
+		}
+	}
+}
+
+// printPayloads removes transport wrappers and prints the messages inside
+func printPayloads(w io.Writer, o decodeOptions, source string, data []byte) error {
+	payloads, wrapper := unwrapPayload(data)
+	for i, payload := range payloads {
+		name := source
+		if len(payloads) > 1 {
+			name = fmt.Sprintf("%s message %d", source, i+1)
+		}
+		m, err := telemetry.Unmarshal(payload)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Fprintf(w, "# %s: %d bytes%s\n", name, len(payload), wrapper)
+		printTelemetry(w, o, m)
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// unwrapPayload returns the Telemetry payloads in data, described by the
+// wrappers that were removed
+func unwrapPayload(data []byte) ([][]byte, string) {
+	// TCP dial-out frames, possibly several back to back
+	if frames := tcpDialoutFrames(data); frames != nil {
+		return frames, " in TCP dial-out frame"
+	}
+
+	// NX-OS UDP header: version 1, GPB encoding and the payload size
+	if len(data) >= nxosUDPHeaderLen && data[0] == 1 && data[1] == 1 &&
+		int(binary.BigEndian.Uint16(data[2:])) == len(data)-nxosUDPHeaderLen {
+		return [][]byte{data[nxosUDPHeaderLen:]}, " in NX-OS UDP datagram"
+	}
+
+	wrapper := ""
+	// gRPC length-prefixed message: uncompressed flag and 32-bit length
+	if len(data) >= 5 && data[0] == 0 && int(binary.BigEndian.Uint32(data[1:])) == len(data)-5 {
+		data = data[5:]
+		wrapper = " in gRPC frame"
+	}
+
+	// Telemetry starts with string fields; MdtDialoutArgs with ReqId or data
+	num, typ, n := protowire.ConsumeTag(data)
+	if n > 0 && (num == 1 && typ == protowire.VarintType || num == 2 && typ == protowire.BytesType) {
+		var args mdt_dialout.MdtDialoutArgs
+		if err := args.Unmarshal(data); err == nil {
+			wrapper += fmt.Sprintf(" in MdtDialoutArgs (ReqId %d)", args.ReqId)
+			if args.Errors != "" {
+				wrapper += fmt.Sprintf(" with errors %q", args.Errors)
+			}
+			data = args.Data
+		}
+	}
+	return [][]byte{data}, wrapper
+}
+
+// tcpDialoutFrames splits data into the payloads of TCP dial-out frames, or
+// returns nil when data is not a sequence of GPB data frames
+func tcpDialoutFrames(data []byte) [][]byte {
+	var frames [][]byte
+	for len(data) > 0 {
+		if len(data) < tcpDialoutHeaderLen {
+			return nil
+		}
+		msgType := binary.BigEndian.Uint16(data[0:])
+		encap := binary.BigEndian.Uint16(data[2:])
+		version := binary.BigEndian.Uint16(data[4:])
+		size := int(binary.BigEndian.Uint32(data[8:]))
+		if msgType != 1 || encap != 1 || version != 1 || size > len(data)-tcpDialoutHeaderLen {
+			return nil
+		}
+		frames = append(frames, data[tcpDialoutHeaderLen:tcpDialoutHeaderLen+size])
+		data = data[tcpDialoutHeaderLen+size:]
+	}
+	return frames
+}
+
+// isText reports whether data looks like hex text rather than a binary
+// payload
+func isText(data []byte) bool {
+	for _, r := range string(data) {
+		if r == unicode.ReplacementChar || (r < ' ' && !unicode.IsSpace(r)) {
+			return false
+		}
+	}
+	return len(bytes.TrimSpace(data)) > 0
+}
+
+// parseHex decodes a hex stream, optionally separated by spaces or colons,
+// or a hex dump with offsets and an ASCII column as Wireshark copies it
+func parseHex(text string) ([]byte, error) {
+	var digits strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if isDumpOffset(fields[0]) && len(fields) > 1 && isHexByte(fields[1]) {
+			// Offset, up to 16 bytes, then the ASCII column
+			for _, f := range fields[1:min(len(fields), 17)] {
+				if !isHexByte(f) {
+					break
+				}
+				digits.WriteString(f)
+			}
+			continue
+		}
+		for _, f := range fields {
+			f = strings.ReplaceAll(f, ":", "")
+			f = strings.TrimPrefix(strings.TrimPrefix(f, "0x"), "0X")
+			digits.WriteString(f)
+		}
+	}
+	data, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil, fmt.Errorf("not a hex payload: %w", err)
+	}
+	return data, nil
+}
+
+// isDumpOffset reports whether s is the offset column of a hex dump
+func isDumpOffset(s string) bool {
+	if len(s) < 4 || len(s) > 8 {
+		return false
+	}
+	_, err := hex.DecodeString(strings.Repeat("0", len(s)%2) + s)
+	return err == nil
+}
+
+func isHexByte(s string) bool {
+	_, err := hex.DecodeString(s)
+	return len(s) == 2 && err == nil
+}
+
+// printTelemetry writes the header fields and the field tree of a message
+func printTelemetry(w io.Writer, o decodeOptions, m *telemetry.Telemetry) {
+	header := []struct {
+		name  string
+		value string
+	}{
+		{"node_id_str", m.NodeIDStr},
+		{"subscription_id_str", m.SubscriptionIDStr},
+		{"encoding_path", m.EncodingPath},
+		{"collection_id", fmt.Sprint(m.CollectionID)},
+		{"collection_start_time", o.timestamp(m.CollectionStartTime)},
+		{"msg_timestamp", o.timestamp(m.MsgTimestamp)},
+		{"collection_end_time", o.timestamp(m.CollectionEndTime)},
+	}
+	for _, h := range header {
+		fmt.Fprintf(w, "%-22s %s\n", h.name+":", h.value)
+	}
+	fmt.Fprintf(w, "data_gpbkv: %d rows\n", len(m.DataGpbkv))
+	for _, f := range m.DataGpbkv {
+		printField(w, o, f, 1, m.MsgTimestamp)
+	}
+}
+
+// printField writes a field and its children indented by depth, with its
+// timestamp when it differs from the parent's
+func printField(w io.Writer, o decodeOptions, f *telemetry.TelemetryField, depth int, parentTS uint64) {
+	indent := strings.Repeat("  ", depth)
+	name := f.Name
+	if name == "" {
+		name = "(row)"
+	}
+	ts := ""
+	if f.Timestamp != 0 && f.Timestamp != parentTS {
+		ts = "  @" + o.timestamp(f.Timestamp)
+	}
+
+	if len(f.Fields) > 0 {
+		fmt.Fprintf(w, "%s%s%s\n", indent, name, ts)
+		if f.Timestamp != 0 {
+			parentTS = f.Timestamp
+		}
+		for _, child := range f.Fields {
+			printField(w, o, child, depth+1, parentTS)
+		}
+		return
+	}
+	fmt.Fprintf(w, "%s%s = %s (%s)%s\n", indent, name, fieldValue(f), fieldType(f), ts)
+}
+
+// timestamp renders milliseconds since the epoch, with the UTC time unless
+// --raw-times is set
+func (o decodeOptions) timestamp(ms uint64) string {
+	if ms == 0 || o.noTimes {
+		return fmt.Sprint(ms)
+	}
+	return fmt.Sprintf("%d (%s)", ms, time.UnixMilli(int64(ms)).UTC().Format("2006-01-02T15:04:05.000Z"))
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"cisco-mdt-generator/pkg/ssh"
+)
+
+// CLIConfig serves a small NX-OS-like CLI over SSH, backed by the simulated
+// state, for screen-scraping fallbacks and cross-checking telemetry by hand
+type CLIConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Listen      string `yaml:"listen"`        // host:port, set per node in the nodes section for a fleet
+	Username    string `yaml:"username"`      // empty accepts any user
+	Password    string `yaml:"password"`      // empty logs in without a password
+	HostKeyFile string `yaml:"host_key_file"` // ed25519 key in PEM, created when missing; empty for a new key every run
+}
+
+// cliCommand is a command of the device CLI. Words may be abbreviated to
+// any unique prefix; an argument word matches anything.
+type cliCommand struct {
+	words []string
+	run   func(s *Simulator, args []string) string
+}
+
+const cliArg = "<arg>"
+
+var cliCommands = []cliCommand{
+	{strings.Fields("show bgp summary"), showBGPSummary},
+	{strings.Fields("show bgp l2vpn evpn summary"), showBGPSummary},
+	{strings.Fields("show ip bgp summary"), showBGPSummary},
+	{strings.Fields("show nve vni"), showNVEVNI},
+	{strings.Fields("show interface counters"), showInterfaceCounters},
+	{strings.Fields("show version"), showVersion},
+	// Sent by screen scrapers before anything else
+	{[]string{"terminal", "length", cliArg}, nil},
+	{[]string{"terminal", "width", cliArg}, nil},
+}
+
+// runDeviceCLI serves the CLI of a node until ctx is done. A node that cannot
+// listen logs why and streams on without a CLI.
+func runDeviceCLI(ctx context.Context, sim *Simulator) {
+	cfg := sim.cfg.CLI
+	key, err := cliHostKey(cfg.HostKeyFile)
+	if err != nil {
+		log.Printf("%s: cli: %v", sim.nodeID, err)
+		return
+	}
+	lis, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Printf("%s: cli: %v", sim.nodeID, err)
+		return
+	}
+	context.AfterFunc(ctx, func() { lis.Close() })
+	log.Printf("%s: cli listening for SSH on %s", sim.nodeID, lis.Addr())
+
+	server := &ssh.Config{HostKey: key, Version: "SSH-2.0-Cisco-1.25"}
+	if cfg.Password != "" {
+		server.Password = func(user, password string) bool {
+			ok := (cfg.Username == "" || user == cfg.Username) && password == cfg.Password
+			sim.Lock()
+			if m := sim.Mgmt; m != nil && !ok {
+				m.FailedLogins++
+			}
+			sim.Unlock()
+			return ok
+		}
+	}
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("%s: cli: %v", sim.nodeID, err)
+			}
+			return
+		}
+		go func() {
+			defer cliRecover(sim, conn.RemoteAddr(), nil)
+			err := ssh.Serve(conn, server, func(s *ssh.Session) (status uint32) {
+				// Sessions run on goroutines of their own
+				defer cliRecover(sim, conn.RemoteAddr(), &status)
+				return cliSession(sim, s)
+			})
+			if err != nil {
+				log.Printf("%s: cli: %s: %v", sim.nodeID, conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// cliRecover logs the panic of a client's connection or session instead of
+// letting one malformed client take the node down. A session that panicked
+// exits with status 1.
+func cliRecover(sim *Simulator, remote net.Addr, status *uint32) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("%s: cli: %s: panic: %v\n%s", sim.nodeID, remote, r, debug.Stack())
+	if status != nil {
+		*status = 1
+	}
+}
+
+// cliHostKey loads the host key from path, creating it when missing, or
+// generates one for this run when path is empty
+func cliHostKey(path string) (ed25519.PrivateKey, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return nil, fmt.Errorf("%s: no PEM key", path)
+			}
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			ed, ok := key.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("%s: not an ed25519 key", path)
+			}
+			return ed, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, err
+		}
+		log.Printf("Created SSH host key %s", path)
+	}
+	return key, nil
+}
+
+// cliSession runs a command, or a shell reading commands until exit, and
+// returns the exit status
+func cliSession(sim *Simulator, s *ssh.Session) uint32 {
+	sim.Lock()
+	if m := sim.Mgmt; m != nil {
+		m.Logins++
+	}
+	sim.Unlock()
+
+	out := &cliWriter{w: s, crlf: s.PTY}
+	if s.Command != "" {
+		text, ok := runCLI(sim, s.Command, 0)
+		io.WriteString(out, text)
+		if !ok {
+			return 1
+		}
+		return 0
+	}
+
+	prompt := sim.nodeID + "# "
+	io.WriteString(out, "Cisco Nexus Operating System (NX-OS) Software\nTAC support: http://www.cisco.com/tac\n")
+	lines := &cliLineReader{r: s, echo: out, terminal: s.PTY}
+	for {
+		io.WriteString(out, prompt)
+		line, err := lines.readLine()
+		if err != nil {
+			return 0
+		}
+		switch strings.TrimSpace(line) {
+		case "":
+			continue
+		case "exit", "quit", "logout":
+			return 0
+		}
+		text, _ := runCLI(sim, line, len(prompt))
+		io.WriteString(out, text)
+	}
+}
+
+// runCLI runs a command line and returns its output, or the NX-OS error
+// pointing at the word it did not understand with a marker indented by
+// indent, and false
+func runCLI(sim *Simulator, line string, indent int) (string, bool) {
+	words := strings.Fields(line)
+	var matched []cliCommand
+	longest := 0
+	for _, c := range cliCommands {
+		n := 0
+		for n < len(words) && n < len(c.words) && cliWordMatches(words[n], c.words[n]) {
+			n++
+		}
+		longest = max(longest, n)
+		if n == len(words) && n == len(c.words) {
+			matched = append(matched, c)
+		}
+	}
+
+	switch {
+	case len(matched) == 1:
+		if matched[0].run == nil {
+			return "", true
+		}
+		var args []string
+		for i, w := range matched[0].words {
+			if w == cliArg {
+				args = append(args, words[i])
+			}
+		}
+		sim.Lock()
+		defer sim.Unlock()
+		return matched[0].run(sim, args), true
+	case len(matched) > 1:
+		return cliError(line, indent, len(strings.TrimRight(line, " ")), "Ambiguous command"), false
+	case longest == len(words):
+		return cliError(line, indent, len(strings.TrimRight(line, " ")), "Incomplete command"), false
+	}
+	// The marker points at the first word no command has there
+	pos := 0
+	for i := 0; i <= longest; i++ {
+		pos += strings.Index(line[pos:], words[i])
+		if i < longest {
+			pos += len(words[i])
+		}
+	}
+	return cliError(line, indent, pos, "Invalid command"), false
+}
+
+// cliWordMatches reports whether a typed word abbreviates a command word
+func cliWordMatches(typed, word string) bool {
+	return word == cliArg || strings.HasPrefix(word, strings.ToLower(typed))
+}
+
+// cliError renders an error with its marker under column pos of the line
+func cliError(line string, indent, pos int, msg string) string {
+	return fmt.Sprintf("%s^\n%% %s at '^' marker.\n", strings.Repeat(" ", indent+pos), msg)
+}
+
+// showBGPSummary renders show bgp summary from the BGP neighbors
+func showBGPSummary(s *Simulator, _ []string) string {
+	var b strings.Builder
+	established := 0
+	for _, n := range s.BGPNeighbors {
+		if n.State == "Established" {
+			established++
+		}
+	}
+	routes := s.EVPN.TotalRoutes
+	fmt.Fprintf(&b, "BGP summary information for VRF default, address family L2VPN EVPN\n")
+	fmt.Fprintf(&b, "BGP router identifier %s, local AS number %d\n", bgpRouterID(s.cfg.BGPSpeaker, s.nodeID), s.cfg.BGPSpeaker.LocalAS)
+	fmt.Fprintf(&b, "BGP table version is %d, L2VPN EVPN config peers %d, capable peers %d\n", routes, len(s.BGPNeighbors), established)
+	fmt.Fprintf(&b, "%d network entries and %d paths using %d bytes of memory\n\n", routes, routes, routes*256)
+	fmt.Fprintf(&b, "Neighbor        V    AS MsgRcvd MsgSent   TblVer  InQ OutQ Up/Down  State/PfxRcd\n")
+	now := s.lastStep
+	for _, n := range s.BGPNeighbors {
+		// A keepalive a minute, and an update per prefix
+		keepalives := n.Uptime / 60
+		state := n.State
+		since := time.Duration(n.Uptime) * time.Second
+		if n.State == "Established" {
+			state = fmt.Sprint(n.PrefixesRecv)
+		} else if !n.LastFlap.IsZero() {
+			since = now.Sub(n.LastFlap)
+		}
+		fmt.Fprintf(&b, "%-15s %d %5d %7d %7d %8d %4d %4d %-8s %s\n",
+			n.Address, 4, n.RemoteAS, keepalives+uint64(n.PrefixesRecv)+1, keepalives+uint64(n.PrefixesSent)+1,
+			routes, 0, 0, cliUpDown(since), state)
+	}
+	return b.String()
+}
+
+// cliUpDown renders a duration as NX-OS does in Up/Down columns
+func cliUpDown(d time.Duration) string {
+	d = max(d, 0)
+	h := int(d.Hours())
+	switch {
+	case h >= 7*24:
+		return fmt.Sprintf("%dw%dd", h/(7*24), h/24%7)
+	case h >= 24:
+		return fmt.Sprintf("%dd%02dh", h/24, h%24)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", h, int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// showNVEVNI renders show nve vni from the VNIs and the VLANs they map to
+func showNVEVNI(s *Simulator, _ []string) string {
+	var b strings.Builder
+	b.WriteString("Codes: CP - Control Plane        DP - Data Plane\n" +
+		"       UC - Unconfigured         SA - Suppress ARP\n" +
+		"       SU - Suppress Unknown Unicast\n" +
+		"       Xconn - Crossconnect\n" +
+		"       MS-IR - Multisite Ingress Replication\n\n" +
+		"Interface VNI      Multicast-group   State Mode Type [BD/VRF]      Flags\n" +
+		"--------- -------- ----------------- ----- ---- ------------------ -----\n")
+	for _, v := range s.VNIs {
+		bd := "L2 [-]"
+		for _, vlan := range s.VLANs {
+			if vlan.VNI == v {
+				bd = fmt.Sprintf("L2 [%d]", vlan.ID)
+				break
+			}
+		}
+		flags := ""
+		if v.ARPSuppression {
+			flags = "SA"
+		}
+		fmt.Fprintf(&b, "%-9s %-8d %-17s %-5s %-4s %-18s %s\n", "nve1", v.VNIID, "UnicastBGP", v.State, "CP", bd, flags)
+	}
+	return b.String()
+}
+
+// showInterfaceCounters renders show interface counters from the
+// interfaces
+func showInterfaceCounters(s *Simulator, _ []string) string {
+	const rule = "--------------------------------------------------------------------------------\n"
+	var b strings.Builder
+	table := func(a, c string, value func(i *InterfaceState) (uint64, uint64)) {
+		b.WriteString("\n" + rule)
+		fmt.Fprintf(&b, "%-15s%34s%31s\n", "Port", a, c)
+		b.WriteString(rule)
+		for _, i := range s.Interfaces {
+			x, y := value(i)
+			fmt.Fprintf(&b, "%-15s%34d%31d\n", cliInterfaceName(i.Name), x, y)
+		}
+	}
+	table("InOctets", "InUcastPkts", func(i *InterfaceState) (uint64, uint64) { return i.InOctets, i.InUcastPkts })
+	table("InMcastPkts", "InBcastPkts", func(i *InterfaceState) (uint64, uint64) { return i.InMcastPkts, i.InBcastPkts })
+	return b.String()
+}
+
+// cliInterfaceName shortens an interface name as NX-OS tables do, e.g.
+// eth1/1 to Eth1/1
+func cliInterfaceName(name string) string {
+	name = strings.Replace(strings.ToLower(name), "ethernet", "eth", 1)
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// showVersion renders the parts of show version scrapers look for
+func showVersion(s *Simulator, _ []string) string {
+	uptime := s.lastStep.Sub(s.startTime)
+	days := int(uptime.Hours()) / 24
+	return fmt.Sprintf("Cisco Nexus Operating System (NX-OS) Software\n\n"+
+		"Software\n  NXOS: version %s\n\n"+
+		"Hardware\n  cisco Nexus9000 C93180YC-FX Chassis\n\n"+
+		"  Device name: %s\n\n"+
+		"Kernel uptime is %d day(s), %d hour(s), %d minute(s), %d second(s)\n",
+		s.Version(), s.nodeID, days, int(uptime.Hours())%24, int(uptime.Minutes())%60, int(uptime.Seconds())%60)
+}
+
+// cliWriter writes CLI output, with CRLF line ends on a terminal
+type cliWriter struct {
+	w    io.Writer
+	crlf bool
+}
+
+func (c *cliWriter) Write(p []byte) (int, error) {
+	if !c.crlf {
+		return c.w.Write(p)
+	}
+	if _, err := c.w.Write([]byte(strings.ReplaceAll(string(p), "\n", "\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// cliLineReader reads command lines. On a terminal it echoes what is typed
+// and handles backspace, Ctrl-C, Ctrl-D and, by ignoring them, escape
+// sequences such as arrow keys.
+type cliLineReader struct {
+	r        io.Reader
+	echo     io.Writer
+	terminal bool
+	buf      []byte
+	pending  []byte
+}
+
+// readLine returns the next line without its end
+func (l *cliLineReader) readLine() (string, error) {
+	var line []byte
+	escape := 0
+	for {
+		if len(l.pending) == 0 {
+			if l.buf == nil {
+				l.buf = make([]byte, 256)
+			}
+			n, err := l.r.Read(l.buf)
+			if err != nil {
+				return "", err
+			}
+			l.pending = l.buf[:n]
+		}
+		c := l.pending[0]
+		l.pending = l.pending[1:]
+
+		if !l.terminal {
+			switch c {
+			case '\n':
+				return strings.TrimSuffix(string(line), "\r"), nil
+			default:
+				line = append(line, c)
+			}
+			continue
+		}
+		switch {
+		case escape == 1:
+			escape = 2
+			if c != '[' && c != 'O' {
+				escape = 0
+			}
+		case escape == 2:
+			// Parameters until the final byte of the sequence
+			if c >= 0x40 && c <= 0x7e {
+				escape = 0
+			}
+		case c == 0x1b:
+			escape = 1
+		case c == '\r' || c == '\n':
+			io.WriteString(l.echo, "\n")
+			// A CR LF pair ends one line
+			if c == '\r' && len(l.pending) > 0 && l.pending[0] == '\n' {
+				l.pending = l.pending[1:]
+			}
+			return string(line), nil
+		case c == 0x7f || c == 0x08:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				io.WriteString(l.echo, "\b \b")
+			}
+		case c == 0x03:
+			io.WriteString(l.echo, "^C\n")
+			return "", nil
+		case c == 0x04:
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+		case c >= 0x20:
+			line = append(line, c)
+			l.echo.Write([]byte{c})
+		}
+	}
+}
+
+// checkCLI ensures an enabled CLI has an address to listen on
+func checkCLI(cfg CLIConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// collectorDialOptions returns the gRPC options dialing the collector with
+// the dial-out settings: the dialer and the HTTP/2 keepalive pings
+func collectorDialOptions(cfg DialoutConfig) []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithContextDialer(collectorDialer(cfg))}
+	if cfg.GRPCKeepAlive > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cfg.GRPCKeepAlive,
+			Timeout:             cfg.GRPCKeepAliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	return opts
+}
+
+// collectorTarget returns the gRPC target of a collector address. The
+// dialer resolves the address itself, or leaves it to the proxy.
+func collectorTarget(server string) (string, error) {
+	if strings.Contains(server, "://") {
+		return server, nil
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return "", fmt.Errorf("collector address %q must be host:port, with IPv6 literals in brackets", server)
+	}
+	return "passthrough:///" + server, nil
+}
+
+// collectorDialer returns the dialer of dial-out connections. It applies
+// the TCP keepalive and address family settings and tunnels through the
+// configured forward proxy, or the one of HTTPS_PROXY when none is
+// configured. Through a proxy, the proxy resolves the collector name.
+func collectorDialer(cfg DialoutConfig) func(ctx context.Context, addr string) (net.Conn, error) {
+	d := &net.Dialer{}
+	if cfg.TCPKeepAlive < 0 {
+		d.KeepAlive = -1
+	} else {
+		d.KeepAliveConfig = net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     cfg.TCPKeepAlive,
+			Interval: cfg.TCPKeepAliveInterval,
+			Count:    cfg.TCPKeepAliveCount,
+		}
+	}
+
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		proxy, err := collectorProxy(cfg.Proxy, addr)
+		if err != nil {
+			return nil, err
+		}
+		if proxy == nil {
+			return dialFamilies(ctx, d, addr, cfg)
+		}
+		conn, err := d.DialContext(ctx, "tcp", proxy.Host)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+		}
+		tunnel, err := proxyConnect(ctx, conn, proxy, addr)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+		}
+		return tunnel, nil
+	}
+}
+
+// dialFamilies connects to a collector address in the configured address
+// families. With both families, the preferred one gets a head start of
+// fallback_delay before the other races it (Happy Eyeballs, RFC 8305).
+func dialFamilies(ctx context.Context, d *net.Dialer, addr string, cfg DialoutConfig) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	family := cfg.AddressFamily
+	if pinned, ok := cfg.AddressFamilies[addr]; ok {
+		family = pinned
+	}
+	var v4, v6 []string
+	for _, ip := range ips {
+		target := net.JoinHostPort(ip.String(), port)
+		if ip.IP.To4() != nil {
+			v4 = append(v4, target)
+		} else {
+			v6 = append(v6, target)
+		}
+	}
+	primaries, fallbacks := v6, v4
+	if cfg.Prefer == "ipv4" {
+		primaries, fallbacks = v4, v6
+	}
+	switch family {
+	case "ipv4":
+		primaries, fallbacks = v4, nil
+	case "ipv6":
+		primaries, fallbacks = v6, nil
+	}
+	if len(primaries) == 0 {
+		primaries, fallbacks = fallbacks, nil
+	}
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("%s has no %s address", host, family)
+	}
+	if len(fallbacks) == 0 || cfg.FallbackDelay < 0 {
+		return dialSerial(ctx, d, append(primaries, fallbacks...))
+	}
+
+	// Race the families; the loser is closed if it connects too
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialed, 2)
+	race := func(targets []string) {
+		conn, err := dialSerial(ctx, d, targets)
+		results <- dialed{conn, err}
+	}
+	go race(primaries)
+	fallback := time.NewTimer(cfg.FallbackDelay)
+	defer fallback.Stop()
+
+	running, started := 1, false
+	var firstErr error
+	for {
+		select {
+		case <-fallback.C:
+			if !started {
+				started, running = true, running+1
+				go race(fallbacks)
+			}
+		case r := <-results:
+			running--
+			if r.err == nil {
+				if running > 0 {
+					go func() {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !started {
+				// The preferred family failed, try the other one now
+				started, running = true, running+1
+				go race(fallbacks)
+			} else if running == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dialSerial connects to the first of the addresses that accepts
+func dialSerial(ctx context.Context, d *net.Dialer, targets []string) (net.Conn, error) {
+	var firstErr error
+	for _, target := range targets {
+		conn, err := d.DialContext(ctx, "tcp", target)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// collectorProxy returns the forward proxy to reach addr through, or nil to
+// connect directly
+func collectorProxy(configured, addr string) (*url.URL, error) {
+	if configured != "" {
+		return url.Parse(configured)
+	}
+	return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+}
+
+// proxyConnect asks an HTTP proxy to open a tunnel to addr with CONNECT,
+// authenticating with the user info of the proxy URL
+func proxyConnect(ctx context.Context, conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were already read into r
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// checkDialout ensures the proxy, keepalive, address family, slow send and
+// discovery settings are usable
+func checkDialout(cfg DialoutConfig) error {
+	if err := checkReqIDStrategy(cfg.ReqID); err != nil {
+		return err
+	}
+	for addr, family := range cfg.AddressFamilies {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("address_families: %q is not host:port (IPv6 literals in brackets)", addr)
+		}
+		if family != "ipv4" && family != "ipv6" && family != "any" {
+			return fmt.Errorf("address_families: %s: family must be any, ipv4 or ipv6", addr)
+		}
+	}
+	if f := cfg.AddressFamily; f != "any" && f != "ipv4" && f != "ipv6" {
+		return fmt.Errorf("address_family must be any, ipv4 or ipv6")
+	}
+	if cfg.Prefer != "ipv6" && cfg.Prefer != "ipv4" {
+		return fmt.Errorf("prefer must be ipv6 or ipv4")
+	}
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil || u.Scheme != "http" || u.Port() == "" {
+			return fmt.Errorf("proxy must be an http://host:port URL")
+		}
+	}
+	if cfg.TCPKeepAliveInterval < 0 || cfg.TCPKeepAliveCount < 0 {
+		return fmt.Errorf("tcp_keepalive_interval and tcp_keepalive_count must be non-negative")
+	}
+	if cfg.GRPCKeepAlive < 0 || cfg.GRPCKeepAliveTimeout < 0 {
+		return fmt.Errorf("grpc_keepalive and grpc_keepalive_timeout must be non-negative")
+	}
+	if cfg.GRPCKeepAlive > 0 && cfg.GRPCKeepAlive < 10*time.Second {
+		return fmt.Errorf("grpc_keepalive must be at least 10s")
+	}
+	if cfg.SlowSendWarning < 0 {
+		return fmt.Errorf("slow_send_warning must be non-negative")
+	}
+	if cfg.Encoding != "" && !slices.Contains(encodings, cfg.Encoding) {
+		return fmt.Errorf("encoding must be one of %s", strings.Join(encodings, ", "))
+	}
+	if cfg.Transport != "" {
+		if err := checkTransport(cfg.Transport); err != nil {
+			return err
+		}
+	}
+	if err := checkDiscovery(cfg.Discovery); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	if err := checkCapture(cfg.Capture); err != nil {
+		return fmt.Errorf("capture: %w", err)
+	}
+	for i, c := range cfg.Collectors {
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return fmt.Errorf("collectors[%d]: address %q is not host:port", i, c.Address)
+		}
+		if c.Encoding != "" && !slices.Contains(encodings, c.Encoding) {
+			return fmt.Errorf("collectors[%d]: encoding must be one of %s", i, strings.Join(encodings, ", "))
+		}
+	}
+	return nil
+}
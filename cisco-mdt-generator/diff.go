@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"cisco-mdt-generator/pkg/recording"
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// diffOptions are the flags of the diff command
+type diffOptions struct {
+	values   bool
+	maxLines int
+}
+
+// capture is a decoded recording grouped by encoding path
+type capture struct {
+	file     string
+	messages int
+	paths    map[string]*capturePath
+}
+
+// capturePath is what a recording holds for one encoding path
+type capturePath struct {
+	subscriptions map[string]bool
+	fields        map[string]string                 // leaf name to type
+	samples       map[string][]*telemetry.Telemetry // messages in order by node
+}
+
+func newDiffCmd() *cobra.Command {
+	var o diffOptions
+	cmd := &cobra.Command{
+		Use:   "diff FILE-A FILE-B",
+		Short: "Report the structural and field-level differences of two recordings",
+		Long: "Decodes two telemetry recordings and reports the sensor paths, fields and field\n" +
+			"types found in only one of them. With --values, the nth message of each node and\n" +
+			"path in one recording is also compared leaf by leaf with the nth in the other.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exitWith(runDiff(o, args[0], args[1]))
+		},
+	}
+	cmd.Flags().BoolVar(&o.values, "values", false, "Also compare leaf values of messages paired by node, path and order")
+	cmd.Flags().IntVar(&o.maxLines, "max-values", 20, "Value differences listed per path, 0 for all")
+	return cmd
+}
+
+// runDiff compares two recordings. It returns the process exit code: 1 when
+// they differ, 2 when they could not be read.
+func runDiff(o diffOptions, fileA, fileB string) int {
+	a, err := readCapture(fileA, o.values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	b, err := readCapture(fileB, o.values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	fmt.Printf("A: %s (%d messages, %d paths)\n", a.file, a.messages, len(a.paths))
+	fmt.Printf("B: %s (%d messages, %d paths)\n\n", b.file, b.messages, len(b.paths))
+
+	paths := slices.Sorted(maps.Keys(a.paths))
+	for path := range b.paths {
+		if _, ok := a.paths[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	differences := 0
+	for _, path := range paths {
+		differences += diffPath(os.Stdout, o, path, a.paths[path], b.paths[path])
+	}
+
+	fmt.Printf("\nCompared %d paths: %d differences\n", len(paths), differences)
+	if differences > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readCapture decodes every message of a recording, keeping the messages
+// themselves only when values are compared
+func readCapture(file string, keepMessages bool) (*capture, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := recording.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	c := &capture{file: file, paths: make(map[string]*capturePath)}
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return c, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", file, c.messages+1, err)
+		}
+		m, err := telemetry.Unmarshal(rec.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", file, c.messages+1, err)
+		}
+		c.messages++
+
+		p, ok := c.paths[m.EncodingPath]
+		if !ok {
+			p = &capturePath{
+				subscriptions: make(map[string]bool),
+				fields:        make(map[string]string),
+				samples:       make(map[string][]*telemetry.Telemetry),
+			}
+			c.paths[m.EncodingPath] = p
+		}
+		p.subscriptions[m.SubscriptionIDStr] = true
+		for _, row := range m.DataGpbkv {
+			walkLeaves(row, "", func(name string, f *telemetry.TelemetryField) {
+				p.fields[name] = fieldType(f)
+			})
+		}
+		if keepMessages {
+			p.samples[m.NodeIDStr] = append(p.samples[m.NodeIDStr], m)
+		}
+	}
+}
+
+// walkLeaves calls fn with every leaf under f, named by the slash-joined
+// names of its ancestors. Unnamed rows add nothing to the name.
+func walkLeaves(f *telemetry.TelemetryField, prefix string, fn func(name string, f *telemetry.TelemetryField)) {
+	name := prefix
+	if f.Name != "" {
+		if name != "" {
+			name += "/"
+		}
+		name += f.Name
+	}
+	if len(f.Fields) == 0 {
+		fn(name, f)
+		return
+	}
+	for _, child := range f.Fields {
+		walkLeaves(child, name, fn)
+	}
+}
+
+// fieldValue renders the value of a leaf, quoting strings
+func fieldValue(f *telemetry.TelemetryField) string {
+	switch {
+	case f.StringValue != nil:
+		return strconv.Quote(*f.StringValue)
+	case f.Uint32Value != nil:
+		return strconv.FormatUint(uint64(*f.Uint32Value), 10)
+	case f.Uint64Value != nil:
+		return strconv.FormatUint(*f.Uint64Value, 10)
+	case f.BoolValue != nil:
+		return strconv.FormatBool(*f.BoolValue)
+	case f.DoubleValue != nil:
+		return strconv.FormatFloat(*f.DoubleValue, 'g', -1, 64)
+	case f.FloatValue != nil:
+		return strconv.FormatFloat(float64(*f.FloatValue), 'g', -1, 32)
+	case f.Sint32Value != nil:
+		return strconv.FormatInt(int64(*f.Sint32Value), 10)
+	case f.Sint64Value != nil:
+		return strconv.FormatInt(*f.Sint64Value, 10)
+	case f.BytesValue != nil:
+		return fmt.Sprintf("0x%x", f.BytesValue)
+	}
+	return "(empty)"
+}
+
+// diffPath writes the differences of one path and returns their number
+func diffPath(w io.Writer, o diffOptions, path string, a, b *capturePath) int {
+	fmt.Fprintf(w, "%s\n", path)
+	switch {
+	case b == nil:
+		fmt.Fprintf(w, "  ONLY-A   path with %d fields\n", len(a.fields))
+		return 1
+	case a == nil:
+		fmt.Fprintf(w, "  ONLY-B   path with %d fields\n", len(b.fields))
+		return 1
+	}
+
+	differences := 0
+	if subsA, subsB := setString(a.subscriptions), setString(b.subscriptions); subsA != subsB {
+		fmt.Fprintf(w, "  SUBSCR   A %s, B %s\n", subsA, subsB)
+		differences++
+	}
+
+	names := slices.Sorted(maps.Keys(a.fields))
+	for name := range b.fields {
+		if _, ok := a.fields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	matched := 0
+	for _, name := range names {
+		typA, inA := a.fields[name]
+		typB, inB := b.fields[name]
+		switch {
+		case !inB:
+			fmt.Fprintf(w, "  ONLY-A   %s (%s)\n", name, typA)
+			differences++
+		case !inA:
+			fmt.Fprintf(w, "  ONLY-B   %s (%s)\n", name, typB)
+			differences++
+		case typA != typB:
+			fmt.Fprintf(w, "  TYPE     %s: A %s, B %s\n", name, typA, typB)
+			differences++
+		default:
+			matched++
+		}
+	}
+	fmt.Fprintf(w, "  OK       %d of %d fields match\n", matched, len(names))
+
+	if o.values {
+		differences += diffValues(w, o.maxLines, a, b)
+	}
+	return differences
+}
+
+// diffValues pairs the messages of each node present in both recordings by
+// order and reports leaves whose values differ, ignoring timestamps
+func diffValues(w io.Writer, maxLines int, a, b *capturePath) int {
+	differences, listed := 0, 0
+	for _, node := range slices.Sorted(maps.Keys(a.samples)) {
+		msgsA, msgsB := a.samples[node], b.samples[node]
+		if len(msgsB) == 0 {
+			continue
+		}
+		if len(msgsA) != len(msgsB) {
+			fmt.Fprintf(w, "  COUNT    %s: A %d messages, B %d\n", node, len(msgsA), len(msgsB))
+			differences++
+		}
+		for i := range min(len(msgsA), len(msgsB)) {
+			leavesA, leavesB := messageLeaves(msgsA[i]), messageLeaves(msgsB[i])
+			keys := slices.Sorted(maps.Keys(leavesA))
+			for k := range leavesB {
+				if _, ok := leavesA[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				valA, inA := leavesA[k]
+				valB, inB := leavesB[k]
+				if inA && inB && valA == valB {
+					continue
+				}
+				differences++
+				if maxLines > 0 && listed >= maxLines {
+					continue
+				}
+				listed++
+				if !inA {
+					valA = "(absent)"
+				}
+				if !inB {
+					valB = "(absent)"
+				}
+				fmt.Fprintf(w, "  VALUE    %s #%d %s: A %s, B %s\n", node, i+1, k, valA, valB)
+			}
+		}
+	}
+	if differences > listed {
+		fmt.Fprintf(w, "  VALUE    %d more differences (--max-values 0 to list all)\n", differences-listed)
+	}
+	return differences
+}
+
+// messageLeaves returns the leaf values of a message keyed by row keys and
+// leaf name, so rows are paired by key rather than by position
+func messageLeaves(m *telemetry.Telemetry) map[string]string {
+	leaves := make(map[string]string)
+	for i, row := range m.DataGpbkv {
+		var keys []string
+		for _, section := range row.Fields {
+			if section.Name == "keys" {
+				walkLeaves(section, "", func(name string, f *telemetry.TelemetryField) {
+					keys = append(keys, strings.TrimPrefix(name, "keys/")+"="+fieldValue(f))
+				})
+			}
+		}
+		rowID := "[" + strings.Join(keys, ",") + "]"
+		if len(keys) == 0 {
+			rowID = fmt.Sprintf("[row %d]", i+1)
+		}
+		walkLeaves(row, "", func(name string, f *telemetry.TelemetryField) {
+			if !strings.HasPrefix(name, "keys/") {
+				leaves[rowID+" "+name] = fieldValue(f)
+			}
+		})
+	}
+	return leaves
+}
+
+// setString renders a set of names in order
+func setString(set map[string]bool) string {
+	return strings.Join(slices.Sorted(maps.Keys(set)), ",")
+}
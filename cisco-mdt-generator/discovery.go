@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discovery finds the collectors in DNS and re-resolves them periodically,
+// following collector scale-out behind DNS. The collectors are the targets
+// of an SRV record, or the addresses of the --server name. Each node streams
+// to one of them, picked by weighted rendezvous hashing of its node ID, so
+// when a collector is added or removed only the nodes whose pick changed
+// migrate their stream.
+type Discovery struct {
+	cfg    DiscoveryConfig
+	server string // --server, resolved when no SRV name is configured
+
+	mu         sync.Mutex
+	collectors []discoveredCollector
+}
+
+// discoveredCollector is one collector address found in DNS
+type discoveredCollector struct {
+	addr   string
+	weight float64
+}
+
+// NewDiscovery resolves the collectors once, failing when none is found. It
+// returns nil when discovery is disabled.
+func NewDiscovery(ctx context.Context, cfg DiscoveryConfig, server string) (*Discovery, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.SRV == "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return nil, fmt.Errorf("discovery without an srv name needs a host:port collector address")
+		}
+	}
+	d := &Discovery{cfg: cfg, server: server}
+	collectors, err := d.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.collectors = collectors
+	log.Printf("Discovered collectors of %s: %s", d.name(), collectorList(collectors))
+	return d, nil
+}
+
+// name returns the DNS name the collectors are discovered from
+func (d *Discovery) name() string {
+	if d.cfg.SRV != "" {
+		return d.cfg.SRV
+	}
+	return d.server
+}
+
+// Run re-resolves the collectors every refresh interval until ctx is done.
+// Lookup failures and empty answers keep the previous collectors, like a
+// resolver serving stale records. Run returns at once on a nil discovery.
+func (d *Discovery) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	ticker := time.NewTicker(d.cfg.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		collectors, err := d.resolve(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Collector discovery: %v, keeping the previous collectors", err)
+			}
+			continue
+		}
+		d.mu.Lock()
+		changed := collectorList(collectors) != collectorList(d.collectors)
+		d.collectors = collectors
+		d.mu.Unlock()
+		if changed {
+			log.Printf("Collectors of %s changed: %s", d.name(), collectorList(collectors))
+		}
+	}
+}
+
+// resolve looks up the collectors: the targets of the SRV record with the
+// lowest priority, or every address of the collector name
+func (d *Discovery) resolve(ctx context.Context) ([]discoveredCollector, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	var collectors []discoveredCollector
+	if d.cfg.SRV != "" {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", d.cfg.SRV)
+		if err != nil {
+			return nil, err
+		}
+		// Lower priorities are backups, only used once the others are gone
+		for _, r := range records {
+			if r.Priority != records[0].Priority || r.Target == "." {
+				continue
+			}
+			// Zero weights still get picked, rarely (RFC 2782)
+			weight := math.Max(float64(r.Weight), 0.1)
+			addr := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+			collectors = append(collectors, discoveredCollector{addr: addr, weight: weight})
+		}
+	} else {
+		host, port, _ := net.SplitHostPort(d.server)
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			collectors = append(collectors, discoveredCollector{addr: net.JoinHostPort(ip.String(), port), weight: 1})
+		}
+	}
+	if len(collectors) == 0 {
+		return nil, fmt.Errorf("no collector found for %s", d.name())
+	}
+	slices.SortFunc(collectors, func(a, b discoveredCollector) int { return strings.Compare(a.addr, b.addr) })
+	return collectors, nil
+}
+
+// Server returns the collector address of a command: the SRV name when
+// collectors are discovered from one and no --server is given
+func (d *Discovery) Server(server string) string {
+	if d == nil || server != "" {
+		return server
+	}
+	return d.name()
+}
+
+// Pick returns the collector a node streams to. A nil discovery returns
+// the collector address it is given.
+func (d *Discovery) Pick(nodeID, server string) string {
+	if d == nil {
+		return server
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	best, bestScore := server, math.Inf(-1)
+	for _, c := range d.collectors {
+		h := fnv.New64a()
+		h.Write([]byte(nodeID + "|" + c.addr))
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		if score := -c.weight / math.Log(u); score > bestScore {
+			best, bestScore = c.addr, score
+		}
+	}
+	return best
+}
+
+// collectorList formats discovered collectors for the log
+func collectorList(collectors []discoveredCollector) string {
+	addrs := make([]string, len(collectors))
+	for i, c := range collectors {
+		addrs[i] = c.addr
+	}
+	return strings.Join(addrs, ", ")
+}
+
+// checkDiscovery ensures the discovery settings are usable
+func checkDiscovery(cfg DiscoveryConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Refresh <= 0 || cfg.Timeout <= 0 {
+		return fmt.Errorf("refresh and timeout must be positive")
+	}
+	return nil
+}
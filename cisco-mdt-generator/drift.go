@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// StartSoftwareUpgrade emulates an NX-OS upgrade to the given version. From
+// now on every path with a schema_drift entry is emitted with the post-upgrade
+// field names, as if the new release changed the data model.
+func (s *Simulator) StartSoftwareUpgrade(version string, now time.Time) {
+	s.SoftwareVersion = version
+	s.event("software_upgrade", version, "Software upgraded to %s, %d paths change schema", version, len(s.cfg.SchemaDrift))
+}
+
+// EndSoftwareUpgrade rolls back to the original release and its schema
+func (s *Simulator) EndSoftwareUpgrade(now time.Time) {
+	version := s.SoftwareVersion
+	s.SoftwareVersion = ""
+	s.event("software_rollback", version, "Software %s rolled back, original schema restored", version)
+}
+
+// checkUpgradeTarget ensures an upgrade names the version being installed
+func checkUpgradeTarget(s *Simulator, ev ScenarioEvent) error {
+	if ev.Target == "" {
+		return fmt.Errorf("software_upgrade needs the new version as target")
+	}
+	return nil
+}
+
+// applySchemaDrift rewrites messages whose subscription has a drift entry:
+// fields are renamed or removed and new fields are added to every row
+func applySchemaDrift(messages []*telemetry.Telemetry, drifts []SchemaDriftConfig) {
+	for _, m := range messages {
+		for _, d := range drifts {
+			if d.Subscription != m.SubscriptionIDStr {
+				continue
+			}
+			if d.EncodingPath != "" {
+				m.EncodingPath = d.EncodingPath
+			}
+			for _, row := range m.DataGpbkv {
+				driftRow(row, d, m.MsgTimestamp)
+			}
+		}
+	}
+}
+
+// driftRow applies a drift entry to the keys and content of a single row
+func driftRow(row *telemetry.TelemetryField, d SchemaDriftConfig, ts uint64) {
+	removed := make(map[string]bool, len(d.Remove))
+	for _, name := range d.Remove {
+		removed[name] = true
+	}
+
+	for _, section := range row.Fields {
+		kept := section.Fields[:0]
+		for _, f := range section.Fields {
+			if removed[f.Name] {
+				continue
+			}
+			if renamed, ok := d.Rename[f.Name]; ok {
+				f.Name = renamed
+			}
+			kept = append(kept, f)
+		}
+		section.Fields = kept
+
+		if section.Name == "content" {
+			for _, name := range slices.Sorted(maps.Keys(d.Add)) {
+				section.Fields = append(section.Fields, telemetry.StringField(name, d.Add[name], ts))
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// ECMPGroup is a group of prefixes sharing a set of weighted ECMP next hops.
+// Traffic is hashed across the next hops in proportion to their weights,
+// give or take the unevenness of real flows.
+type ECMPGroup struct {
+	Name     string
+	Prefixes []string
+	PPS      uint64
+	NextHops []*ECMPNextHop
+}
+
+// ECMPNextHop is a next hop of an ECMP group and the traffic hashed to it
+type ECMPNextHop struct {
+	Address string
+	Weight  uint32
+	Pkts    uint64
+	Bytes   uint64
+
+	// Skew is the share of the group's traffic an ecmp_skew event forces
+	// onto this next hop, 0 when hashing is even
+	Skew float64
+
+	lastPkts uint64 // packets of the last interval, for the current share
+}
+
+// initECMPFromConfig creates the ECMP groups of the node
+func initECMPFromConfig(cfg *Config) []*ECMPGroup {
+	if !cfg.ECMP.Enabled {
+		return nil
+	}
+	var groups []*ECMPGroup
+	for _, gc := range cfg.ECMP.Groups {
+		g := &ECMPGroup{Name: gc.Name, Prefixes: gc.Prefixes, PPS: gc.PPS}
+		for _, nc := range gc.NextHops {
+			nh := &ECMPNextHop{Address: nc.Address, Weight: nc.Weight}
+			if nh.Weight == 0 {
+				nh.Weight = 1
+			}
+			g.NextHops = append(g.NextHops, nh)
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// expected returns the share of the group's traffic a next hop should carry
+// by its weight
+func (g *ECMPGroup) expected(nh *ECMPNextHop) float64 {
+	var total uint32
+	for _, n := range g.NextHops {
+		total += n.Weight
+	}
+	return float64(nh.Weight) / float64(total)
+}
+
+// share returns the share of the group's traffic a next hop carried in the
+// last interval
+func (g *ECMPGroup) share(nh *ECMPNextHop) float64 {
+	var total uint64
+	for _, n := range g.NextHops {
+		total += n.lastPkts
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(nh.lastPkts) / float64(total)
+}
+
+// imbalance returns the largest deviation of a next hop's share of the last
+// interval from its weighted share, in percent of the weighted share
+func (g *ECMPGroup) imbalance() float64 {
+	var worst float64
+	for _, nh := range g.NextHops {
+		want := g.expected(nh)
+		worst = max(worst, math.Abs(g.share(nh)-want)/want*100)
+	}
+	return worst
+}
+
+// findECMPNextHops returns every ECMP next hop with the given address
+func (s *Simulator) findECMPNextHops(address string) []*ECMPNextHop {
+	var hops []*ECMPNextHop
+	for _, g := range s.ECMP {
+		for _, nh := range g.NextHops {
+			if nh.Address == address {
+				hops = append(hops, nh)
+			}
+		}
+	}
+	return hops
+}
+
+// stepECMP hashes the traffic of every group across its next hops. A skewed
+// next hop takes its forced share and the others split the rest by weight.
+func (s *Simulator) stepECMP(seconds float64) {
+	for _, g := range s.ECMP {
+		pkts := float64(g.PPS) * seconds * (0.9 + s.rng.Float64()*0.2)
+
+		var skew float64
+		var weights uint32
+		for _, nh := range g.NextHops {
+			if nh.Skew > 0 {
+				skew += nh.Skew
+			} else {
+				weights += nh.Weight
+			}
+		}
+		skew = min(skew, 1)
+
+		for _, nh := range g.NextHops {
+			share := nh.Skew
+			if share == 0 && weights > 0 {
+				// Flows never hash perfectly evenly
+				share = (1 - skew) * float64(nh.Weight) / float64(weights) * (0.97 + s.rng.Float64()*0.06)
+			}
+			n := uint64(pkts * share)
+			nh.lastPkts = n
+			nh.Pkts += n
+			nh.Bytes += n * uint64(700+s.rng.Intn(200))
+		}
+	}
+}
+
+// SetECMPSkew forces a share of the traffic of every group using a next hop
+// onto it, e.g. elephant flows polarizing the hash; 0 restores even hashing
+func (s *Simulator) SetECMPSkew(address string, share float64) error {
+	hops := s.findECMPNextHops(address)
+	if len(hops) == 0 {
+		return fmt.Errorf("unknown ECMP next hop %s", address)
+	}
+	for _, nh := range hops {
+		nh.Skew = share
+	}
+	if share > 0 {
+		s.event("ecmp_skew", address, "ECMP traffic skewed to next hop %s (%.0f%% of its groups)", address, share*100)
+	} else {
+		s.event("ecmp_rebalanced", address, "ECMP hashing across next hop %s even again", address)
+	}
+	return nil
+}
+
+// checkECMPSkewTarget ensures an event targets an ECMP next hop with a
+// usable share
+func checkECMPSkewTarget(s *Simulator, ev ScenarioEvent) error {
+	if len(s.findECMPNextHops(ev.Target)) == 0 {
+		return fmt.Errorf("unknown ECMP next hop %q", ev.Target)
+	}
+	_, err := parseECMPShare(ev)
+	return err
+}
+
+// parseECMPShare returns the share parameter of an ecmp_skew event, a
+// percentage defaulting to 80
+func parseECMPShare(ev ScenarioEvent) (float64, error) {
+	pct, err := strconv.ParseFloat(ev.Param("share", "80"), 64)
+	if err != nil || pct <= 0 || pct > 100 {
+		return 0, fmt.Errorf("share must be a percentage from 0 to 100")
+	}
+	return pct / 100, nil
+}
+
+// ecmpSkewAction is the ecmp_skew scenario action
+var ecmpSkewAction = scenarioAction{
+	check: checkECMPSkewTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		share, err := parseECMPShare(ev)
+		if err != nil {
+			return err
+		}
+		return s.SetECMPSkew(ev.Target, share)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetECMPSkew(ev.Target, 0)
+	},
+}
+
+// checkECMP ensures the ECMP groups are usable
+func checkECMP(cfg ECMPConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if len(cfg.Groups) == 0 {
+		return fmt.Errorf("at least one group must be configured")
+	}
+	names := make(map[string]bool)
+	for _, g := range cfg.Groups {
+		if g.Name == "" || names[g.Name] {
+			return fmt.Errorf("groups need unique names")
+		}
+		names[g.Name] = true
+		for _, p := range g.Prefixes {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				return fmt.Errorf("group %s: prefix %q is not a CIDR prefix", g.Name, p)
+			}
+		}
+		if len(g.NextHops) < 2 {
+			return fmt.Errorf("group %s: at least two next hops must be configured", g.Name)
+		}
+		seen := make(map[string]bool)
+		for _, nh := range g.NextHops {
+			if net.ParseIP(nh.Address) == nil {
+				return fmt.Errorf("group %s: next hop %q is not an IP address", g.Name, nh.Address)
+			}
+			if seen[nh.Address] {
+				return fmt.Errorf("group %s: duplicate next hop %s", g.Name, nh.Address)
+			}
+			seen[nh.Address] = true
+		}
+	}
+	return nil
+}
+
+// buildECMPGroupTelemetry reports the traffic and imbalance of every group
+func buildECMPGroupTelemetry(ts uint64, nodeID string, groups []*ECMPGroup) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, g := range groups {
+		var pkts, bytes uint64
+		for _, nh := range g.NextHops {
+			pkts += nh.Pkts
+			bytes += nh.Bytes
+		}
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("group-name", g.Name, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.Uint32Field("prefix-count", uint32(len(g.Prefixes)), ts),
+				telemetry.Uint32Field("next-hop-count", uint32(len(g.NextHops)), ts),
+				telemetry.Uint64Field("packets", pkts, ts),
+				telemetry.Uint64Field("bytes", bytes, ts),
+				telemetry.DoubleField("imbalance-percent", math.Round(g.imbalance()*10)/10, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "ecmp_groups",
+		EncodingPath:        "Cisco-NX-OS-device:System/urib-items/ecmp-items/Group-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildECMPNextHopTelemetry reports the weight, expected and actual share
+// and traffic of every next hop of every group
+func buildECMPNextHopTelemetry(ts uint64, nodeID string, groups []*ECMPGroup) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, g := range groups {
+		for _, nh := range g.NextHops {
+			row := telemetry.RowField(
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("group-name", g.Name, ts),
+					telemetry.StringField("next-hop", nh.Address, ts),
+				},
+				[]*telemetry.TelemetryField{
+					telemetry.Uint32Field("weight", nh.Weight, ts),
+					telemetry.DoubleField("expected-percent", math.Round(g.expected(nh)*1000)/10, ts),
+					telemetry.DoubleField("actual-percent", math.Round(g.share(nh)*1000)/10, ts),
+					telemetry.Uint64Field("packets", nh.Pkts, ts),
+					telemetry.Uint64Field("bytes", nh.Bytes, ts),
+				},
+				ts,
+			)
+			rows = append(rows, row)
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "ecmp_next_hops",
+		EncodingPath:        "Cisco-NX-OS-device:System/urib-items/ecmp-items/Group-list/nh-items/Nh-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Index template placeholders besides {node} and {subscription}
+const (
+	pathPlaceholder = "{path}"
+	datePlaceholder = "{date}"
+)
+
+// ElasticsearchSink decodes telemetry into one JSON document per row and
+// bulk-indexes them into Elasticsearch or OpenSearch
+type ElasticsearchSink struct {
+	cfg    ElasticsearchSinkConfig
+	client *http.Client
+}
+
+// NewElasticsearchSink creates a sink writing to the _bulk API at cfg.URL
+func NewElasticsearchSink(cfg ElasticsearchSinkConfig) (*ElasticsearchSink, error) {
+	if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+		return nil, fmt.Errorf("unsupported url %q, expected http:// or https://", cfg.URL)
+	}
+	return &ElasticsearchSink{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (s *ElasticsearchSink) Name() string { return "elasticsearch" }
+
+// Write indexes a round of telemetry with one bulk request
+func (s *ElasticsearchSink) Write(messages []*telemetry.Telemetry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, m := range messages {
+		for _, row := range m.DataGpbkv {
+			ts := row.Timestamp
+			if ts == 0 {
+				ts = m.MsgTimestamp
+			}
+			at := time.UnixMilli(int64(ts)).UTC()
+
+			action := map[string]map[string]string{"index": {"_index": s.indexName(m, at)}}
+			if err := enc.Encode(action); err != nil {
+				return err
+			}
+			if err := enc.Encode(telemetryDocument(m, row, at)); err != nil {
+				return err
+			}
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return s.bulk(buf.Bytes())
+}
+
+// bulk posts an NDJSON body and reports the first rejected document
+func (s *ElasticsearchSink) bulk(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.cfg.URL, "/")+"/_bulk", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case s.cfg.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.cfg.APIKey)
+	case s.cfg.Username != "":
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("bulk request failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Index  string `json:"_index"`
+			Status int    `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+
+	failed := 0
+	var first string
+	for _, item := range result.Items {
+		for _, r := range item {
+			if r.Error == nil {
+				continue
+			}
+			if failed == 0 {
+				first = fmt.Sprintf("%s: %s: %s", r.Index, r.Error.Type, r.Error.Reason)
+			}
+			failed++
+		}
+	}
+	return fmt.Errorf("%d of %d documents rejected, first: %s", failed, len(result.Items), first)
+}
+
+func (s *ElasticsearchSink) Close() error { return nil }
+
+// indexName expands the index template of a document. Index names must be
+// lowercase and cannot contain path separators, so the encoding path is
+// sanitized.
+func (s *ElasticsearchSink) indexName(m *telemetry.Telemetry, at time.Time) string {
+	path := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/*?"<>| ,#:`, r) {
+			return '_'
+		}
+		return r
+	}, m.EncodingPath)
+
+	name := expandSinkTemplate(s.cfg.Index, m)
+	name = strings.NewReplacer(pathPlaceholder, path, datePlaceholder, at.Format(s.cfg.DateFormat)).Replace(name)
+	return strings.ToLower(name)
+}
+
+// telemetryDocument decodes one row into a document with the message
+// metadata, the row keys and the content tree
+func telemetryDocument(m *telemetry.Telemetry, row *telemetry.TelemetryField, at time.Time) map[string]any {
+	doc := map[string]any{
+		"@timestamp":    at.Format(time.RFC3339Nano),
+		"node":          m.NodeIDStr,
+		"subscription":  m.SubscriptionIDStr,
+		"encoding_path": m.EncodingPath,
+		"collection_id": m.CollectionID,
+	}
+	for _, section := range row.Fields {
+		switch section.Name {
+		case "keys", "content":
+			doc[section.Name] = fieldsJSON(section.Fields)
+		}
+	}
+	return doc
+}
+
+// fieldsJSON converts telemetry fields to a JSON object. Repeated names,
+// such as list entries, become arrays.
+func fieldsJSON(fields []*telemetry.TelemetryField) map[string]any {
+	obj := make(map[string]any, len(fields))
+	for _, f := range fields {
+		v := fieldJSON(f)
+		switch prev := obj[f.Name].(type) {
+		case nil:
+			obj[f.Name] = v
+		case []any:
+			obj[f.Name] = append(prev, v)
+		default:
+			obj[f.Name] = []any{prev, v}
+		}
+	}
+	return obj
+}
+
+// fieldJSON converts a telemetry field to its JSON value
+func fieldJSON(f *telemetry.TelemetryField) any {
+	switch {
+	case len(f.Fields) > 0:
+		return fieldsJSON(f.Fields)
+	case f.StringValue != nil:
+		return *f.StringValue
+	case f.BoolValue != nil:
+		return *f.BoolValue
+	case f.Uint32Value != nil:
+		return *f.Uint32Value
+	case f.Uint64Value != nil:
+		return *f.Uint64Value
+	case f.Sint32Value != nil:
+		return *f.Sint32Value
+	case f.Sint64Value != nil:
+		return *f.Sint64Value
+	case f.DoubleValue != nil:
+		return *f.DoubleValue
+	case f.FloatValue != nil:
+		return *f.FloatValue
+	case f.BytesValue != nil:
+		return f.BytesValue
+	}
+	return nil
+}
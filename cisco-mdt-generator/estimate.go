@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// estimateOptions are the flags of the estimate command
+type estimateOptions struct {
+	fleetOptions
+	minutes int
+}
+
+func newEstimateCmd() *cobra.Command {
+	var o estimateOptions
+	cmd := &cobra.Command{
+		Use:   "estimate",
+		Short: "Estimate the messages and bytes per second every collector receives, without sending anything",
+		Long: "Simulates the nodes of the configuration, or the leafs a fleet would run, on a\n" +
+			"virtual clock and encodes every collection for each dial-out collector: --server\n" +
+			"and the dialout.collectors, each in its own encoding. Reports the average\n" +
+			"messages and payload bytes per second per collector and per subscription, to\n" +
+			"size collectors before running at scale.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEstimate(os.Stdout, o)
+		},
+	}
+	addSimFlags(cmd, &o.simOptions)
+	addServerFlag(cmd.Flags(), &o.server)
+	addEncodingFlag(cmd.Flags(), &o.encoding)
+	cmd.Flags().IntVar(&o.minutes, "minutes", 10, "Virtual minutes to average over, at least the longest sample interval")
+	cmd.Flags().IntVar(&o.count, "count", 4, "Number of leafs to estimate, as with fleet (ignored when the config lists nodes)")
+	cmd.Flags().IntVar(&o.first, "first", 101, "Number of the first leaf")
+	cmd.Flags().StringVar(&o.nodeFormat, "node-format", "leaf-%d", "Printf format of node-id-str for each leaf number")
+	cmd.Flags().StringVar(&o.template, "template", "", "Node template applied to every leaf (ignored when the config lists nodes)")
+	cmd.Flags().MarkHidden("node")
+	return cmd
+}
+
+// estimateCollector is a dial-out collector and the encoding it receives
+type estimateCollector struct {
+	address, encoding string
+}
+
+// runEstimate simulates every node for the given virtual minutes, following
+// the load profile, and writes the average rates each collector receives
+func runEstimate(w io.Writer, o estimateOptions) error {
+	if o.minutes < 1 {
+		return fmt.Errorf("minutes must be at least 1")
+	}
+	log.SetOutput(io.Discard)
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Syslog = SyslogConfig{}
+	nodeIDs, err := o.fleetNodes(cfg)
+	if err != nil {
+		return err
+	}
+
+	collectors := []estimateCollector{{o.server, cfg.Dialout.Encoding}}
+	for _, c := range cfg.Dialout.Collectors {
+		collectors = append(collectors, estimateCollector{c.Address, c.Encoding})
+	}
+
+	// Bytes are counted per collector, messages and the bytes of the first
+	// collector per subscription
+	bytes := make([]uint64, len(collectors))
+	var messages uint64
+	bySub := make(map[string]*bandwidthCount)
+
+	start := time.Now().Truncate(time.Second)
+	if cfg.Simulation.Seed != 0 {
+		start = seededStart
+	}
+	window := time.Duration(o.minutes) * time.Minute
+	for _, nodeID := range nodeIDs {
+		sim, scenario, err := o.newNode(cfg, nodeID, start)
+		if err != nil {
+			return err
+		}
+		for now := start; ; {
+			now = now.Add(loadInterval(o.interval, sim.cfg.LoadProfile.Rate(now.Sub(start))))
+			if now.After(start.Add(window)) {
+				break
+			}
+			scenario.Advance(sim, now)
+			sim.Step(now)
+			collection := sim.BuildTelemetry(now)
+			sim.OnChange.Commit(collection)
+			for i, c := range collectors {
+				payloads, _ := sim.Marshal.Marshal(collection, c.encoding)
+				for j, p := range payloads {
+					bytes[i] += uint64(len(p))
+					if i > 0 {
+						continue
+					}
+					sub := collection[j].SubscriptionIDStr
+					if bySub[sub] == nil {
+						bySub[sub] = &bandwidthCount{}
+					}
+					bySub[sub].messages++
+					bySub[sub].bytes += uint64(len(p))
+				}
+			}
+			messages += uint64(len(collection))
+		}
+	}
+
+	fmt.Fprintf(w, "Estimated over %d virtual minutes at %s for %d nodes\n\n", o.minutes, o.interval, len(nodeIDs))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLLECTOR\tENCODING\tMSG/S\tBYTES/S\tMBIT/S\tPER DAY")
+	for i, c := range collectors {
+		encoding := c.encoding
+		if encoding == "" {
+			encoding = encodingGPBKV
+		}
+		count := bandwidthCount{messages: messages, bytes: bytes[i]}
+		msgRate, byteRate := count.rates(window)
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.0f\t%.3f\t%s\n", c.address, encoding, msgRate, byteRate, byteRate*8/1e6, formatVolume(byteRate*86400))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nPer subscription, in the encoding of %s:\n\n", o.server)
+	fmt.Fprintln(tw, "SUBSCRIPTION\tMSG/S\tBYTES/S\tAVG BYTES\tPER DAY")
+	for _, sub := range slices.Sorted(maps.Keys(bySub)) {
+		c := bySub[sub]
+		msgRate, byteRate := c.rates(window)
+		fmt.Fprintf(tw, "%s\t%.2f\t%.0f\t%.0f\t%s\n", sub, msgRate, byteRate, float64(c.bytes)/float64(c.messages), formatVolume(byteRate*86400))
+	}
+	return tw.Flush()
+}
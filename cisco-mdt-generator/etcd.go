@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// etcdClient stores keys through the JSON gateway of etcd v3, trying the
+// endpoints in turn until one answers
+type etcdClient struct {
+	endpoints []string
+	client    *http.Client
+}
+
+// newEtcdClient takes a comma-separated list of http:// or https://
+// endpoints
+func newEtcdClient(address string, timeout time.Duration) (*etcdClient, error) {
+	c := &etcdClient{client: &http.Client{Timeout: timeout}}
+	for _, e := range strings.Split(address, ",") {
+		e = strings.TrimSuffix(strings.TrimSpace(e), "/")
+		if !strings.HasPrefix(e, "http://") && !strings.HasPrefix(e, "https://") {
+			return nil, fmt.Errorf("unsupported endpoint %q, expected http:// or https://", e)
+		}
+		c.endpoints = append(c.endpoints, e)
+	}
+	return c, nil
+}
+
+// Get returns the value of key, or nil when it is not set
+func (c *etcdClient) Get(ctx context.Context, key string) ([]byte, error) {
+	var resp struct {
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := c.call(ctx, "/v3/kv/range", map[string][]byte{"key": []byte(key)}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	if resp.Kvs[0].Value == nil {
+		return []byte{}, nil
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+// Set stores value under key
+func (c *etcdClient) Set(ctx context.Context, key string, value []byte) error {
+	return c.call(ctx, "/v3/kv/put", map[string][]byte{"key": []byte(key), "value": value}, nil)
+}
+
+// Close does nothing, as requests do not keep a session
+func (c *etcdClient) Close() error { return nil }
+
+// call posts a request to the first endpoint that answers and decodes its
+// response into out. Byte fields are base64 in both directions, as the
+// gateway expects.
+func (c *etcdClient) call(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, e := range c.endpoints {
+		err := c.post(ctx, e+path, body, out)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// post sends one request to one endpoint
+func (c *etcdClient) post(ctx context.Context, url string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// eventBufferSize is how many events a slow subscriber may fall behind
+// before further events are dropped for it
+const eventBufferSize = 256
+
+// SimEvent is a ground-truth state change of the simulation, such as a BGP
+// flap or a scenario step, at simulated time
+type SimEvent struct {
+	Time   time.Time
+	Type   string
+	Target string
+	Detail string
+}
+
+// EventBus fans simulation events out to subscribers. Publishing never
+// blocks the simulation; events for a full subscriber are dropped.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[int]chan SimEvent
+	next int
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]chan SimEvent)}
+}
+
+// Publish delivers an event to every subscriber that has room for it
+func (b *EventBus) Publish(ev SimEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of future events and a function that ends
+// the subscription and closes the channel
+func (b *EventBus) Subscribe() (<-chan SimEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	ch := make(chan SimEvent, eventBufferSize)
+	b.subs[id] = ch
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// event logs a state change and publishes it on the simulator's event bus
+// at the time of the current step
+func (s *Simulator) event(typ, target, format string, args ...interface{}) {
+	detail := fmt.Sprintf(format, args...)
+	log.Print(detail)
+	s.Events.Publish(SimEvent{Time: s.lastStep, Type: typ, Target: target, Detail: detail})
+}
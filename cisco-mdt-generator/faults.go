@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Malformed row variants
+const (
+	malformedMissingContent = "missing_content"
+	malformedMissingKeys    = "missing_keys"
+	malformedDuplicateKeys  = "duplicate_keys"
+)
+
+// malformedModes lists every malformed row variant
+var malformedModes = []string{malformedMissingContent, malformedMissingKeys, malformedDuplicateKeys}
+
+// fuzzStrings are edge-case values injected into string fields: empty, very
+// long, multibyte UTF-8, control characters and characters that need escaping
+// in common storage formats. All are valid UTF-8 so the protobuf stays valid.
+var fuzzStrings = []string{
+	"",
+	strings.Repeat("Ethernet1/1-very-long-description-", 2048),
+	"Ünïcødé-接口-インターフェース-🚀",
+	"שלום-مرحبا-right-to-left",
+	"e\u0301-combining-e\u0301\u0301",
+	"tab\there\nnewline\rcarriage\x00nul\x1b[31mescape",
+	"\"double\" 'single' back\\slash,comma=equals space",
+	"\ufeffbom-\u200bzero-width-\u00a0nbsp",
+}
+
+// checkMalformedModes ensures configured malformed row variants are known
+func checkMalformedModes(modes []string) error {
+	for _, mode := range modes {
+		known := false
+		for _, m := range malformedModes {
+			known = known || m == mode
+		}
+		if !known {
+			return fmt.Errorf("unknown malformed row mode %q", mode)
+		}
+	}
+	return nil
+}
+
+// applyFaults degrades built telemetry according to the fault-injection
+// configuration, emulating imperfect data from busy or buggy devices
+func applyFaults(messages []*telemetry.Telemetry, cfg FaultsConfig, rng *rand.Rand) {
+	if cfg.SparseRowPercent > 0 {
+		omitRows(messages, cfg.SparseRowPercent, rng)
+	}
+	if cfg.MalformedRowPercent > 0 {
+		modes := cfg.MalformedModes
+		if len(modes) == 0 {
+			modes = malformedModes
+		}
+		malformRows(messages, cfg.MalformedRowPercent, modes, rng)
+	}
+	if cfg.StringFuzzPercent > 0 {
+		fuzzStringFields(messages, cfg.StringFuzzPercent, cfg.StringFuzzFields, rng)
+	}
+}
+
+// omitRows randomly drops the given percentage of rows from each collection,
+// like a DME query that times out part-way on a busy device
+func omitRows(messages []*telemetry.Telemetry, percent float64, rng *rand.Rand) {
+	for _, m := range messages {
+		kept := m.DataGpbkv[:0]
+		for _, row := range m.DataGpbkv {
+			if rng.Float64()*100 < percent {
+				continue
+			}
+			kept = append(kept, row)
+		}
+		m.DataGpbkv = kept
+	}
+}
+
+// malformRows breaks the keys/content structure Telegraf expects in the
+// given percentage of rows, using a random variant for each row
+func malformRows(messages []*telemetry.Telemetry, percent float64, modes []string, rng *rand.Rand) {
+	for _, m := range messages {
+		for _, row := range m.DataGpbkv {
+			if rng.Float64()*100 >= percent {
+				continue
+			}
+			malformRow(row, modes[rng.Intn(len(modes))])
+		}
+	}
+}
+
+// malformRow applies a single malformed variant to a row
+func malformRow(row *telemetry.TelemetryField, mode string) {
+	var kept []*telemetry.TelemetryField
+	for _, section := range row.Fields {
+		switch {
+		case mode == malformedMissingContent && section.Name == "content":
+			continue
+		case mode == malformedMissingKeys && section.Name == "keys":
+			continue
+		case mode == malformedDuplicateKeys && section.Name == "keys":
+			// Repeat every key field, the second copy with a conflicting value
+			for _, key := range append([]*telemetry.TelemetryField(nil), section.Fields...) {
+				dup := *key
+				if key.StringValue != nil {
+					v := *key.StringValue + "-dup"
+					dup.StringValue = &v
+				}
+				section.Fields = append(section.Fields, &dup)
+			}
+		}
+		kept = append(kept, section)
+	}
+	row.Fields = kept
+}
+
+// fuzzStringFields replaces the given percentage of string values in row
+// content with edge-case strings. With fields set, only those names are
+// fuzzed; keys are left alone so series identity stays intact.
+func fuzzStringFields(messages []*telemetry.Telemetry, percent float64, fields []string, rng *rand.Rand) {
+	only := make(map[string]bool, len(fields))
+	for _, name := range fields {
+		only[name] = true
+	}
+
+	for _, m := range messages {
+		for _, row := range m.DataGpbkv {
+			for _, section := range row.Fields {
+				if section.Name != "content" {
+					continue
+				}
+				for _, f := range section.Fields {
+					if f.StringValue == nil || (len(only) > 0 && !only[f.Name]) {
+						continue
+					}
+					if rng.Float64()*100 < percent {
+						v := fuzzStrings[rng.Intn(len(fuzzStrings))]
+						f.StringValue = &v
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// feedRetryInterval is how long the feed waits before reconnecting
+const feedRetryInterval = 5 * time.Second
+
+// FeedCommand is one command of the external feed, JSON encoded. Action is
+// a scenario action, applied like an injected event, or "set", which
+// overwrites Metric of Target with Value.
+type FeedCommand struct {
+	Node     string            `json:"node"` // node-id-str or glob pattern, every node when empty
+	Action   string            `json:"action"`
+	Target   string            `json:"target"`
+	Duration string            `json:"duration"` // e.g. "5m", reverted when it elapses
+	Params   map[string]string `json:"params"`
+	Metric   string            `json:"metric"`
+	Value    float64           `json:"value"`
+}
+
+// feedNode is a simulated node the feed drives
+type feedNode struct {
+	sim      *Simulator
+	scenario *ScenarioEngine
+}
+
+// Feed consumes commands from a NATS subject so an external orchestrator can
+// drive the simulated nodes of this process during end-to-end rehearsals.
+// Commands published with a reply subject are answered with "OK" or the
+// error.
+type Feed struct {
+	cfg NATSFeedConfig
+
+	mu    sync.Mutex
+	nodes []feedNode
+}
+
+// NewFeed returns the command feed of a configuration, or nil when it is disabled
+func NewFeed(cfg FeedConfig) *Feed {
+	if !cfg.NATS.Enabled {
+		return nil
+	}
+	return &Feed{cfg: cfg.NATS}
+}
+
+// Add registers a node the feed's commands can address. A nil feed ignores it.
+func (f *Feed) Add(sim *Simulator, scenario *ScenarioEngine) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes = append(f.nodes, feedNode{sim: sim, scenario: scenario})
+}
+
+// Run consumes commands until ctx ends, reconnecting when the connection fails
+func (f *Feed) Run(ctx context.Context) {
+	if f == nil {
+		return
+	}
+	for {
+		err := f.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Command feed: %v, reconnecting in %s", err, feedRetryInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(feedRetryInterval):
+		}
+	}
+}
+
+// consume subscribes to the subject and applies commands until the
+// connection fails or ctx ends
+func (f *Feed) consume(ctx context.Context) error {
+	addr := strings.TrimPrefix(f.cfg.URL, "nats://")
+	conn, err := net.DialTimeout("tcp", addr, f.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	if err := f.handshake(conn, r, w); err != nil {
+		return err
+	}
+	log.Printf("Command feed subscribed to %s on %s", f.cfg.Subject, f.cfg.URL)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("connection lost: %w", err)
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PING":
+			w.WriteString("PONG\r\n")
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(line[4:]))
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			parts := strings.Fields(line)
+			size, _ := strconv.Atoi(parts[len(parts)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return fmt.Errorf("connection lost: %w", err)
+			}
+
+			reply := "OK"
+			if err := f.apply(payload[:size]); err != nil {
+				log.Printf("Command feed: %v", err)
+				reply = err.Error()
+			}
+			if len(parts) == 5 {
+				fmt.Fprintf(w, "PUB %s %d\r\n%s\r\n", parts[3], len(reply), reply)
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// handshake reads the server INFO, authenticates, subscribes and waits for
+// the server to answer a PING, which proves CONNECT and SUB were accepted
+func (f *Feed) handshake(conn net.Conn, r *bufio.Reader, w *bufio.Writer) error {
+	conn.SetDeadline(time.Now().Add(f.cfg.Timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read server info: %w", err)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(line[5:]), &info) != nil {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if info.TLSRequired {
+		return fmt.Errorf("server requires TLS, which the command feed does not support")
+	}
+
+	connect, _ := json.Marshal(map[string]any{
+		"verbose":    false,
+		"pedantic":   false,
+		"name":       "cisco-mdt-generator",
+		"lang":       "go",
+		"version":    version,
+		"protocol":   1,
+		"user":       f.cfg.User,
+		"pass":       f.cfg.Password,
+		"auth_token": f.cfg.Token,
+	})
+	fmt.Fprintf(w, "CONNECT %s\r\n", connect)
+	if f.cfg.Queue != "" {
+		fmt.Fprintf(w, "SUB %s %s 1\r\nPING\r\n", f.cfg.Subject, f.cfg.Queue)
+	} else {
+		fmt.Fprintf(w, "SUB %s 1\r\nPING\r\n", f.cfg.Subject)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	if line = strings.TrimSpace(line); line != "PONG" {
+		return fmt.Errorf("subscribe rejected: %s", line)
+	}
+	return nil
+}
+
+// apply decodes a command and applies it to every node it addresses
+func (f *Feed) apply(payload []byte) error {
+	var cmd FeedCommand
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return fmt.Errorf("invalid command %q: %w", payload, err)
+	}
+	if _, err := path.Match(cmd.Node, ""); err != nil {
+		return fmt.Errorf("invalid node pattern %q", cmd.Node)
+	}
+
+	f.mu.Lock()
+	nodes := f.nodes
+	f.mu.Unlock()
+
+	matched := 0
+	for _, n := range nodes {
+		if ok, _ := path.Match(cmd.Node, n.sim.nodeID); cmd.Node != "" && !ok {
+			continue
+		}
+		matched++
+		if err := n.apply(cmd); err != nil {
+			return fmt.Errorf("%s: %s %s: %w", n.sim.nodeID, cmd.Action, cmd.Target, err)
+		}
+	}
+	if matched == 0 {
+		return fmt.Errorf("no node matches %q", cmd.Node)
+	}
+	return nil
+}
+
+// apply applies a command to one node
+func (n feedNode) apply(cmd FeedCommand) error {
+	n.sim.Lock()
+	defer n.sim.Unlock()
+	now := time.Now()
+
+	if cmd.Action == "set" {
+		metric, ok := conditionMetrics[cmd.Metric]
+		if !ok || metric.set == nil {
+			return fmt.Errorf("metric %q cannot be set", cmd.Metric)
+		}
+		if cmd.Value < 0 {
+			return fmt.Errorf("value must be non-negative")
+		}
+		if err := metric.set(n.sim, cmd.Target, cmd.Value); err != nil {
+			return err
+		}
+		n.sim.event("feed_set", cmd.Target, "%s of %s set to %g by the command feed", cmd.Metric, cmd.Target, cmd.Value)
+		return nil
+	}
+
+	ev := ScenarioEvent{Action: cmd.Action, Target: cmd.Target, Params: cmd.Params}
+	if cmd.Duration != "" {
+		d, err := time.ParseDuration(cmd.Duration)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		ev.Duration = d
+	}
+	return n.scenario.Inject(n.sim, ev, now)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// fexAvgBytes is the average packet size on FEX host ports
+const fexAvgBytes = 700
+
+// FEXState is a Fabric Extender attached to the node. Its host ports are
+// statically pinned to its fabric uplinks round-robin, so every uplink
+// carries the traffic of the host ports pinned to it.
+type FEXState struct {
+	ID           uint32
+	Model        string
+	Serial       string
+	LoadMbps     uint64
+	Online       bool
+	StateChanges uint32
+	Uplinks      []*FEXUplink
+	HostPorts    []*FEXHostPort
+}
+
+// FEXUplink is a fabric interface of the parent switch connecting the FEX
+type FEXUplink struct {
+	Name              string
+	InPkts, InBytes   uint64
+	OutPkts, OutBytes uint64
+}
+
+// FEXHostPort is a host interface of a FEX, e.g. Ethernet101/1/1
+type FEXHostPort struct {
+	Name              string
+	Uplink            *FEXUplink
+	InPkts, InBytes   uint64
+	OutPkts, OutBytes uint64
+}
+
+// initFEXFromConfig creates the FEXes of the node, all online
+func initFEXFromConfig(cfg *Config) []*FEXState {
+	if !cfg.FEX.Enabled {
+		return nil
+	}
+	var units []*FEXState
+	for _, fc := range cfg.FEX.Units {
+		f := &FEXState{
+			ID:       fc.ID,
+			Model:    fc.Model,
+			Serial:   fmt.Sprintf("FOC%04d%04X", fc.ID, fc.ID*7919%0xffff),
+			LoadMbps: fc.LoadMbps,
+			Online:   true,
+		}
+		if f.Model == "" {
+			f.Model = "N2K-C2348UPQ"
+		}
+		for _, name := range fc.Uplinks {
+			f.Uplinks = append(f.Uplinks, &FEXUplink{Name: name})
+		}
+		for p := range fc.HostPorts {
+			f.HostPorts = append(f.HostPorts, &FEXHostPort{
+				Name:   fmt.Sprintf("Ethernet%d/1/%d", fc.ID, p+1),
+				Uplink: f.Uplinks[p%len(f.Uplinks)],
+			})
+		}
+		units = append(units, f)
+	}
+	return units
+}
+
+// FindFEX returns the FEX with the given ID, or nil
+func (s *Simulator) FindFEX(id uint32) *FEXState {
+	for _, f := range s.FEX {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// stepFEX counts the traffic of every host port of an online FEX on the port
+// and the uplink it is pinned to
+func (s *Simulator) stepFEX(seconds float64) {
+	for _, f := range s.FEX {
+		if !f.Online || len(f.HostPorts) == 0 {
+			continue
+		}
+		perPort := float64(f.LoadMbps) * 1e6 / 8 / fexAvgBytes / float64(len(f.HostPorts)) * seconds
+		for _, p := range f.HostPorts {
+			in := uint64(perPort * (0.5 + s.rng.Float64()))
+			out := uint64(perPort * (0.5 + s.rng.Float64()))
+			p.InPkts += in
+			p.InBytes += in * fexAvgBytes
+			p.OutPkts += out
+			p.OutBytes += out * fexAvgBytes
+
+			// What hosts send enters the parent switch over the uplink
+			p.Uplink.InPkts += in
+			p.Uplink.InBytes += in * fexAvgBytes
+			p.Uplink.OutPkts += out
+			p.Uplink.OutBytes += out * fexAvgBytes
+		}
+	}
+}
+
+// SetFEXOnline brings a FEX online or takes it offline with its host ports
+func (s *Simulator) SetFEXOnline(id uint32, online bool) error {
+	f := s.FindFEX(id)
+	if f == nil {
+		return fmt.Errorf("unknown FEX %d", id)
+	}
+	if f.Online == online {
+		return nil
+	}
+	f.Online = online
+	f.StateChanges++
+	if online {
+		s.event("fex_online", fmt.Sprint(id), "FEX %d (%s, serial %s) is online, %d host ports up", id, f.Model, f.Serial, len(f.HostPorts))
+	} else {
+		s.event("fex_offline", fmt.Sprint(id), "FEX %d (%s, serial %s) is offline, %d host ports down", id, f.Model, f.Serial, len(f.HostPorts))
+	}
+	return nil
+}
+
+// parseFEXTarget parses the FEX ID an event targets
+func parseFEXTarget(target string) (uint32, error) {
+	id, err := strconv.ParseUint(target, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid FEX id %q", target)
+	}
+	return uint32(id), nil
+}
+
+// checkFEXTarget ensures an event targets a configured FEX
+func checkFEXTarget(s *Simulator, ev ScenarioEvent) error {
+	id, err := parseFEXTarget(ev.Target)
+	if err != nil {
+		return err
+	}
+	if s.FindFEX(id) == nil {
+		return fmt.Errorf("unknown FEX %d", id)
+	}
+	return nil
+}
+
+// fexOfflineAction is the fex_offline scenario action
+var fexOfflineAction = scenarioAction{
+	check: checkFEXTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		id, err := parseFEXTarget(ev.Target)
+		if err != nil {
+			return err
+		}
+		return s.SetFEXOnline(id, false)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		id, err := parseFEXTarget(ev.Target)
+		if err != nil {
+			return err
+		}
+		return s.SetFEXOnline(id, true)
+	},
+}
+
+// checkFEX ensures the FEXes are usable
+func checkFEX(cfg FEXConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if len(cfg.Units) == 0 {
+		return fmt.Errorf("at least one unit must be configured")
+	}
+	ids := make(map[uint32]bool)
+	for _, f := range cfg.Units {
+		if f.ID < 100 || f.ID > 199 || ids[f.ID] {
+			return fmt.Errorf("units need unique ids from 100 to 199")
+		}
+		ids[f.ID] = true
+		if len(f.Uplinks) == 0 {
+			return fmt.Errorf("fex %d: at least one uplink must be configured", f.ID)
+		}
+		if f.HostPorts < 1 || f.HostPorts > 96 {
+			return fmt.Errorf("fex %d: host_ports must be from 1 to 96", f.ID)
+		}
+	}
+	return nil
+}
+
+// buildFEXTelemetry reports the state of every FEX
+func buildFEXTelemetry(ts uint64, nodeID string, units []*FEXState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, f := range units {
+		state, code, portsUp := "Offline", uint32(0), uint32(0)
+		if f.Online {
+			state, code, portsUp = "Online", 1, uint32(len(f.HostPorts))
+		}
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.Uint32Field("fex-id", f.ID, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("model", f.Model, ts),
+				telemetry.StringField("serial", f.Serial, ts),
+				telemetry.StringField("oper-state", state, ts),
+				telemetry.Uint32Field("oper-state-code", code, ts),
+				telemetry.Uint32Field("state-changes", f.StateChanges, ts),
+				telemetry.Uint32Field("fabric-uplinks", uint32(len(f.Uplinks)), ts),
+				telemetry.Uint32Field("host-ports", uint32(len(f.HostPorts)), ts),
+				telemetry.Uint32Field("host-ports-up", portsUp, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "fex_state",
+		EncodingPath:        "Cisco-NX-OS-device:System/fex-items/Fex-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildFEXUplinkTelemetry reports the counters of every FEX fabric uplink
+func buildFEXUplinkTelemetry(ts uint64, nodeID string, units []*FEXState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, f := range units {
+		state := "down"
+		if f.Online {
+			state = "up"
+		}
+		for _, u := range f.Uplinks {
+			var pinned uint32
+			for _, p := range f.HostPorts {
+				if p.Uplink == u {
+					pinned++
+				}
+			}
+			row := telemetry.RowField(
+				[]*telemetry.TelemetryField{
+					telemetry.Uint32Field("fex-id", f.ID, ts),
+					telemetry.StringField("interface", u.Name, ts),
+				},
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("oper-state", state, ts),
+					telemetry.Uint32Field("pinned-host-ports", pinned, ts),
+					telemetry.Uint64Field("in-packets", u.InPkts, ts),
+					telemetry.Uint64Field("in-octets", u.InBytes, ts),
+					telemetry.Uint64Field("out-packets", u.OutPkts, ts),
+					telemetry.Uint64Field("out-octets", u.OutBytes, ts),
+				},
+				ts,
+			)
+			rows = append(rows, row)
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "fex_uplinks",
+		EncodingPath:        "Cisco-NX-OS-device:System/fex-items/Fex-list/fabricport-items/FabricPort-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildFEXHostPortTelemetry reports every FEX host port, the uplink it is
+// pinned to and its counters
+func buildFEXHostPortTelemetry(ts uint64, nodeID string, units []*FEXState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, f := range units {
+		state := "down"
+		if f.Online {
+			state = "up"
+		}
+		for _, p := range f.HostPorts {
+			row := telemetry.RowField(
+				[]*telemetry.TelemetryField{
+					telemetry.Uint32Field("fex-id", f.ID, ts),
+					telemetry.StringField("interface", p.Name, ts),
+				},
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("fabric-uplink", p.Uplink.Name, ts),
+					telemetry.StringField("oper-state", state, ts),
+					telemetry.Uint64Field("in-packets", p.InPkts, ts),
+					telemetry.Uint64Field("in-octets", p.InBytes, ts),
+					telemetry.Uint64Field("out-packets", p.OutPkts, ts),
+					telemetry.Uint64Field("out-octets", p.OutBytes, ts),
+				},
+				ts,
+			)
+			rows = append(rows, row)
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "fex_host_ports",
+		EncodingPath:        "Cisco-NX-OS-device:System/fex-items/Fex-list/hostport-items/HostPort-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// fleetOptions are the flags of the fleet command
+type fleetOptions struct {
+	simOptions
+	server     string
+	count      int
+	first      int
+	nodeFormat string
+	template   string
+	tui        bool
+	control    string // serve the control API on this address
+
+	clusterListen string // lead a cluster of fleet processes
+	followers     int
+	join          string // follow the cluster leader at this address
+
+	bandwidthOptions
+}
+
+func newFleetCmd() *cobra.Command {
+	var o fleetOptions
+	cmd := &cobra.Command{
+		Use:   "fleet",
+		Short: "Simulate several leafs, each with its own dial-out stream",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFleet(o)
+		},
+	}
+	addSimFlags(cmd, &o.simOptions)
+	addServerFlag(cmd.Flags(), &o.server)
+	addEncodingFlag(cmd.Flags(), &o.encoding)
+	addTransportFlag(cmd.Flags(), &o.transport)
+	addTUIFlag(cmd.Flags(), &o.tui)
+	addControlFlag(cmd.Flags(), &o.control)
+	addBandwidthFlags(cmd.Flags(), &o.bandwidthOptions)
+	cmd.Flags().IntVar(&o.count, "count", 4, "Number of leafs to simulate")
+	cmd.Flags().IntVar(&o.first, "first", 101, "Number of the first leaf")
+	cmd.Flags().StringVar(&o.nodeFormat, "node-format", "leaf-%d", "Printf format of node-id-str for each leaf number")
+	cmd.Flags().StringVar(&o.template, "template", "", "Node template applied to every leaf (ignored when the config lists nodes)")
+	cmd.Flags().StringVar(&o.clusterListen, "cluster-listen", "", "Lead a cluster: listen for --join followers on this address, e.g. :50060")
+	cmd.Flags().IntVar(&o.followers, "followers", 1, "Followers the cluster leader waits for before starting")
+	cmd.Flags().StringVar(&o.join, "join", "", "Simulate the slice of the fabric assigned by the cluster leader at this address")
+	cmd.MarkFlagsMutuallyExclusive("cluster-listen", "join")
+	cmd.Flags().MarkHidden("node")
+	return cmd
+}
+
+// runFleet runs one independent simulator and stream per leaf, all sharing
+// the same scenario, and stops when any stream fails or on interrupt. The
+// nodes section of the configuration, when present, replaces the generated
+// leaf list. A cluster leader keeps the first slice of the leafs and hands
+// the others to its followers.
+func runFleet(o fleetOptions) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if o.join != "" {
+		return followCluster(ctx, o)
+	}
+	if o.count < 1 {
+		return fmt.Errorf("count must be at least 1")
+	}
+
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+	nodeIDs, err := o.fleetNodes(cfg)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if o.clusterListen != "" {
+		leader, err := leadCluster(ctx, o, nodeIDs)
+		if err != nil {
+			return err
+		}
+		defer leader.Stop()
+		nodeIDs, start = leader.nodeIDs, leader.start
+	}
+
+	err = runNodes(ctx, o, cfg, nodeIDs, start)
+	if ctx.Err() != nil {
+		log.Printf("Interrupted, stopping")
+		err = nil
+	}
+	return err
+}
+
+// fleetNodes returns the leafs of the fleet: those of the nodes section, or
+// a series generated from the flags
+func (o fleetOptions) fleetNodes(cfg *Config) ([]string, error) {
+	if len(cfg.Nodes) == 0 {
+		cfg.Nodes = []NodeConfig{{ID: o.nodeFormat, Count: o.count, First: o.first, Template: o.template}}
+		if err := checkNodes(cfg); err != nil {
+			return nil, err
+		}
+	} else if o.template != "" {
+		log.Printf("Config lists nodes, ignoring --template %s", o.template)
+	}
+	if err := checkAddressing(cfg); err != nil {
+		return nil, err
+	}
+	return cfg.NodeIDs(), nil
+}
+
+// runNodes simulates the given nodes of the configuration from start, which
+// may lie ahead, until a stream fails or ctx ends
+func runNodes(ctx context.Context, o fleetOptions, cfg *Config, nodeIDs []string, start time.Time) (err error) {
+	discovery, err := NewDiscovery(ctx, cfg.Dialout.Discovery, o.server)
+	if err != nil {
+		return fmt.Errorf("collector discovery: %w", err)
+	}
+	o.server = discovery.Server(o.server)
+
+	middleware := NewMiddleware(cfg.Sinks.Middleware, cfg.Priorities)
+	sink, err := openSinks(cfg, middleware, o.server)
+	if err != nil {
+		return err
+	}
+	if sink != nil {
+		defer sink.Close()
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var rates *SendRates
+	var sims []*Simulator
+	var scenarios []*ScenarioEngine
+	if o.tui {
+		rates = NewSendRates()
+	}
+	budget := NewBudget(cfg.Budget)
+	marshal := NewMarshalPool(cfg.Budget.MarshalWorkers)
+	bandwidth, err := o.bandwidthOptions.start()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if reportErr := o.bandwidthOptions.finish(bandwidth); reportErr != nil && err == nil {
+			err = reportErr
+		}
+	}()
+	store, err := NewStateStore(cfg.State)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	feed := NewFeed(cfg.Feed)
+	control, err := startControl(ctx, o.control)
+	if err != nil {
+		return err
+	}
+	for i, nodeID := range nodeIDs {
+		if !budget.Admit(i) {
+			log.Printf("Memory budget of %d MiB reached, simulating %d of %d leafs", cfg.Budget.MemoryMB, i, len(nodeIDs))
+			break
+		}
+		sim, scenario, err := o.newNode(cfg, nodeID, start)
+		if err != nil {
+			return fmt.Errorf("%s: %w", nodeID, err)
+		}
+		sim.Rates = rates
+		sim.Budget = budget
+		sim.Marshal = marshal
+		sim.Discovery = discovery
+		sim.Bandwidth = bandwidth
+		sim.Middleware = middleware
+		if err := store.Restore(sim, scenario); err != nil {
+			return err
+		}
+		sim.State = store
+		feed.Add(sim, scenario)
+		control.Add(sim, scenario)
+		sims = append(sims, sim)
+		scenarios = append(scenarios, scenario)
+	}
+
+	// Every member of a cluster starts its first interval at the same time
+	if wait := time.Until(start); wait > 0 {
+		log.Printf("Starting in %s", wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+
+	go budget.Watch(ctx)
+	go NewSoakGuard(cfg.Soak).Watch(ctx, cancel)
+	go discovery.Run(ctx)
+	go feed.Run(ctx)
+
+	errs := make(chan error, len(sims))
+	for i, sim := range sims {
+		go func() {
+			err := streamNode(ctx, o.server, o.interval, sim, scenarios[i], sink, nil)
+			errs <- fmt.Errorf("%s: %w", sim.nodeID, err)
+		}()
+	}
+
+	if o.tui {
+		defer startConsoleUI(ctx, sims, rates, o.server)()
+	}
+	log.Printf("Fleet of %d leafs started", len(sims))
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); errors.Is(cause, errSoak) {
+			err = cause
+		}
+	}
+	cancel(nil)
+	if err == nil {
+		err = assertionFailures(sims, scenarios)
+	}
+	return err
+}
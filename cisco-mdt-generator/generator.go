@@ -0,0 +1,630 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"cisco-mdt-generator/pkg/admin"
+	"cisco-mdt-generator/pkg/gnmi"
+	"cisco-mdt-generator/pkg/mdt_dialout"
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// simOptions are the flags shared by every command that runs a simulation
+type simOptions struct {
+	nodeID       string
+	configPath   string
+	scenarioPath string
+	flapChance   float64
+	interval     time.Duration
+	auto         map[string]string // fabricate a fabric instead of reading configPath
+	encoding     string            // of the --server stream, overriding the configuration
+	transport    string            // of every stream, overriding the configuration
+	seed         int64             // overriding simulation.seed when set
+	platform     string            // overriding the platform of every node when set
+	scenario     *Scenario         // sent by a cluster leader instead of read from scenarioPath
+	allowScripts bool
+}
+
+// runOptions are the flags of the run command
+type runOptions struct {
+	simOptions
+	server   string
+	grpcAddr string
+	gnmiAddr string // serve gNMI subscriptions instead of dialing out
+	control  string // serve the control API on this address
+	report   string
+	tui      bool
+	record   string // scenario file recording injected events
+	bandwidthOptions
+}
+
+// check ensures the flags are usable before any command steps a simulation
+// with them
+func (o simOptions) check() error {
+	if o.interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	return nil
+}
+
+// config loads the configuration file, or fabricates a fabric with --auto
+func (o simOptions) config() (*Config, error) {
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	load := func() (*Config, error) { return LoadConfig(o.configPath) }
+	if len(o.auto) > 0 {
+		load = func() (*Config, error) { return autoConfig(o.auto) }
+	}
+	cfg, err := load()
+	if err == nil && o.seed != 0 {
+		cfg.Simulation.Seed = o.seed
+	}
+	if err == nil && o.platform != "" {
+		if err := checkPlatform(o.platform); err != nil {
+			return nil, fmt.Errorf("--platform: %w", err)
+		}
+		cfg.Platform = o.platform
+		if err := checkSRv6(cfg.SRv6, cfg.Platform); err != nil {
+			return nil, fmt.Errorf("--platform: srv6: %w", err)
+		}
+	}
+	return cfg, err
+}
+
+// loadConfig loads the configuration and logs where it came from
+func (o simOptions) loadConfig() (*Config, error) {
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	cfg, err := o.config()
+	if err != nil && len(o.auto) > 0 {
+		return nil, fmt.Errorf("failed to fabricate configuration: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if o.encoding != "" {
+		if !slices.Contains(encodings, o.encoding) {
+			return nil, fmt.Errorf("--encoding must be one of %s", strings.Join(encodings, ", "))
+		}
+		cfg.Dialout.Encoding = o.encoding
+	}
+	if o.transport != "" {
+		if err := checkTransport(o.transport); err != nil {
+			return nil, fmt.Errorf("--transport: %w", err)
+		}
+		cfg.Dialout.Transport = o.transport
+	}
+
+	if len(o.auto) > 0 {
+		log.Printf("Fabricated a fabric of %d leafs with %d VNIs and %d spines",
+			len(cfg.NodeIDs()), len(cfg.VNIStates), len(cfg.BGPNeighbors))
+	} else if _, statErr := os.Stat(o.configPath); statErr == nil {
+		log.Printf("Loaded configuration from: %s", o.configPath)
+	} else {
+		log.Printf("Config file not found, using hardcoded defaults")
+	}
+	return cfg, nil
+}
+
+// newNode creates the simulator of a node and its scenario engine. The engine
+// always exists so the admin service can inject events into it. A node listed
+// in the nodes section gets its template and overrides.
+func (o simOptions) newNode(cfg *Config, nodeID string, start time.Time) (*Simulator, *ScenarioEngine, error) {
+	cfg, err := cfg.configFor(nodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	syslog, err := NewSyslog(cfg.Syslog, nodeID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set up syslog: %w", err)
+	}
+	sim := NewSimulator(cfg, nodeID, o.flapChance, syslog, start)
+	if sim.Plugins, err = newPlugins(cfg.Plugins, nodeID); err != nil {
+		return nil, nil, err
+	}
+
+	scenario := NewScenarioEngine(&Scenario{Name: "admin"}, start)
+	sc, source := o.scenario, "cluster leader"
+	if sc == nil && o.scenarioPath != "" {
+		if sc, err = LoadScenario(o.scenarioPath); err != nil {
+			return nil, nil, fmt.Errorf("failed to load scenario: %w", err)
+		}
+		source = o.scenarioPath
+	}
+	if sc != nil && sc.Script != "" && !o.allowScripts {
+		return nil, nil, fmt.Errorf("scenario %q has a script, which only runs with --allow-scripts", sc.Name)
+	}
+	if sc != nil {
+		scenario = NewScenarioEngine(sc, start)
+		if err := scenario.CheckTargets(sim); err != nil {
+			return nil, nil, fmt.Errorf("invalid scenario: %w", err)
+		}
+		log.Printf("Loaded scenario %q with %d events from: %s", sc.Name, len(sc.Events), source)
+	}
+	return sim, scenario, nil
+}
+
+// runGenerator simulates a single node and streams its telemetry to the
+// collector until the stream fails or the process is interrupted
+func runGenerator(o runOptions) error {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	sim, scenario, err := o.newNode(cfg, o.nodeID, time.Now())
+	if err != nil {
+		return err
+	}
+	if sim.State, err = NewStateStore(cfg.State); err != nil {
+		return err
+	}
+	defer sim.State.Close()
+	if err := sim.State.Restore(sim, scenario); err != nil {
+		return err
+	}
+	if o.report != "" {
+		sim.Stats = NewSeriesStats()
+	}
+	sim.Budget = NewBudget(cfg.Budget)
+	sim.Marshal = NewMarshalPool(cfg.Budget.MarshalWorkers)
+	if sim.Bandwidth, err = o.bandwidthOptions.start(); err != nil {
+		return err
+	}
+	if o.gnmiAddr != "" {
+		// gNMI clients subscribe instead
+		o.server = ""
+	}
+	sim.Discovery, err = NewDiscovery(context.Background(), cfg.Dialout.Discovery, o.server)
+	if err != nil {
+		return fmt.Errorf("collector discovery: %w", err)
+	}
+	o.server = sim.Discovery.Server(o.server)
+	if o.record != "" {
+		if err := scenario.RecordTo(o.record); err != nil {
+			return err
+		}
+		log.Printf("Recording injected events to scenario: %s", o.record)
+	}
+
+	// Optional server-mode gRPC listener
+	var listener *GRPCListener
+	if o.grpcAddr != "" {
+		listener, err = NewGRPCListener(o.grpcAddr)
+		if err != nil {
+			return fmt.Errorf("failed to start gRPC listener: %w", err)
+		}
+		admin.RegisterAdminServer(listener.Server, NewAdminService(sim, scenario))
+		listener.Serve()
+		defer listener.Server.Stop()
+	}
+
+	// Optional gNMI server replacing the dial-out stream
+	var gnmiListener *GRPCListener
+	var extra []Sink
+	if o.gnmiAddr != "" {
+		gnmiListener, err = NewGRPCListener(o.gnmiAddr)
+		if err != nil {
+			return fmt.Errorf("failed to start gNMI server: %w", err)
+		}
+		server := NewGNMIServer()
+		gnmi.RegisterGNMIServer(gnmiListener.Server, server)
+		log.Printf("Serving gNMI subscriptions instead of dialing out")
+		gnmiListener.Serve()
+		defer gnmiListener.Server.Stop()
+		extra = append(extra, server)
+	}
+
+	sim.Middleware = NewMiddleware(cfg.Sinks.Middleware, cfg.Priorities)
+	sink, err := openSinks(cfg, sim.Middleware, o.server, extra...)
+	if err != nil {
+		return err
+	}
+	if sink != nil {
+		defer sink.Close()
+	}
+
+	ready := func() {
+		if listener != nil {
+			listener.SetServing(true)
+		}
+		if gnmiListener != nil {
+			gnmiListener.SetServing(true)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, fail := context.WithCancelCause(ctx)
+	defer fail(nil)
+
+	go sim.Budget.Watch(ctx)
+	go NewSoakGuard(cfg.Soak).Watch(ctx, fail)
+	go sim.Discovery.Run(ctx)
+
+	feed := NewFeed(cfg.Feed)
+	feed.Add(sim, scenario)
+	go feed.Run(ctx)
+
+	control, err := startControl(ctx, o.control)
+	if err != nil {
+		return err
+	}
+	control.Add(sim, scenario)
+
+	stopUI := func() {}
+	if o.tui {
+		sim.Rates = NewSendRates()
+		stopUI = startConsoleUI(ctx, []*Simulator{sim}, sim.Rates, o.server)
+	}
+
+	err = streamNode(ctx, o.server, o.interval, sim, scenario, sink, ready)
+	stopUI()
+	if cause := context.Cause(ctx); errors.Is(cause, errSoak) {
+		err = cause
+	} else if ctx.Err() != nil {
+		log.Printf("Interrupted, stopping")
+		err = nil
+	}
+	if err == nil {
+		err = assertionFailures([]*Simulator{sim}, []*ScenarioEngine{scenario})
+	}
+	if o.report != "" {
+		if reportErr := sim.Stats.WriteReport(o.report); reportErr != nil && err == nil {
+			err = fmt.Errorf("failed to write report: %w", reportErr)
+		}
+	}
+	if reportErr := o.bandwidthOptions.finish(sim.Bandwidth); reportErr != nil && err == nil {
+		err = reportErr
+	}
+	return err
+}
+
+// dialoutStream sends the messages of a dial-out stream
+type dialoutStream interface {
+	Send(*mdt_dialout.MdtDialoutArgs) error
+}
+
+// dialStream opens the dial-out stream of one node to a collector on the
+// configured transport. The returned close function releases it.
+func dialStream(ctx context.Context, server, encoding string, sim *Simulator) (dialoutStream, func(), error) {
+	if sim.cfg.Dialout.Transport == transportUDP {
+		return dialUDP(server, sim, encoding)
+	}
+	return dialCollector(ctx, server, sim)
+}
+
+// dialCollector opens the gRPC dial-out stream of one node. The returned
+// close function releases the connection.
+func dialCollector(ctx context.Context, server string, sim *Simulator) (mdt_dialout.MdtDialout_MdtDialoutClient, func(), error) {
+	log.Printf("Connecting to MDT collector at %s ...", server)
+
+	creds, err := dialCredentials(sim.cfg.TLS, sim.nodeID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set up TLS: %w", err)
+	}
+
+	target, err := collectorTarget(server)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := append(collectorDialOptions(sim.cfg.Dialout), grpc.WithTransportCredentials(creds))
+	if h := sim.Bandwidth.ConnStats(sim.nodeID); h != nil {
+		opts = append(opts, grpc.WithStatsHandler(h))
+	}
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dial collector: %w", err)
+	}
+
+	stream, err := mdt_dialout.NewGRPCMdtDialoutClient(conn).MdtDialout(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to open MdtDialout stream: %w", err)
+	}
+	return stream, func() { conn.Close() }, nil
+}
+
+// streamNode sends the telemetry of one simulated node every interval to
+// the collector, unless server is empty, and to sink, if any. ready is
+// called once the stream is established.
+func streamNode(ctx context.Context, server string, interval time.Duration, sim *Simulator, scenario *ScenarioEngine, sink Sink, ready func()) error {
+	cfg := sim.cfg
+	nodeID := sim.nodeID
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The BGP speaker, BMP export and device CLI follow the simulation on
+	// their own sessions
+	if cfg.BGPSpeaker.Enabled {
+		go runBGPSpeaker(ctx, sim, interval)
+	}
+	if cfg.BMP.Enabled {
+		go runBMP(ctx, sim, interval)
+	}
+	if cfg.CLI.Enabled {
+		go runDeviceCLI(ctx, sim)
+	}
+
+	// The RESTCONF endpoint answers from the collections sent
+	if cfg.RESTCONF.Enabled {
+		rest := NewRESTCONF()
+		go runRESTCONF(ctx, sim, rest)
+		if sink != nil {
+			sink = multiSink{sink, rest}
+		} else {
+			sink = rest
+		}
+	}
+
+	sim.Bandwidth.AddBreaker(nodeID, sim.Breaker)
+
+	// The streams of the node keep the middleware, and so the bandwidth
+	// buckets, of the run across reconnects
+	mw := sim.Middleware
+	if mw == nil {
+		mw = NewMiddleware(cfg.Sinks.Middleware, cfg.Priorities)
+	}
+
+	// Adaptive sending under collector backpressure and the resource budget
+	backpressure := NewBackpressure(cfg.Backpressure, cfg.Priorities)
+	stretch := sim.Budget.Stretch()
+	rate := cfg.LoadProfile.Rate(0)
+	loggedRate := rate
+	currentInterval := loadInterval(interval*time.Duration(stretch), rate)
+
+	// The messages of a collection that reached a transport, so the
+	// on-change filter only counts what was delivered as sent
+	var delivered []*telemetry.Telemetry
+	deliver := func(m *telemetry.Telemetry) { delivered = append(delivered, m) }
+
+	// The collector is the discovered one when collectors are found in DNS
+	var collector Sink
+	closeConn := func() {}
+	defer func() { closeConn() }()
+	connected := ""
+	connect := func(addr string) error {
+		stream, closeStream, err := dialStream(ctx, addr, cfg.Dialout.Encoding, sim)
+		if err != nil {
+			return err
+		}
+		closeConn()
+		closeConn, connected = closeStream, addr
+		collector = mw.Wrap(&collectorSink{stream: stream, address: addr, sim: sim, reqIDs: newReqIDs(cfg.Dialout, nodeID, newRand(cfg.Simulation.Seed, nodeID+"/req_id")), backpressure: backpressure, encoding: cfg.Dialout.Encoding, delivered: deliver})
+		return nil
+	}
+	if server != "" {
+		if err := connect(sim.Discovery.Pick(nodeID, server)); err != nil {
+			return err
+		}
+	}
+
+	// The additional collectors each receive the same collections in
+	// their own encoding
+	var others []Sink
+	for _, c := range cfg.Dialout.Collectors {
+		stream, closeStream, err := dialStream(ctx, c.Address, c.Encoding, sim)
+		if err != nil {
+			return err
+		}
+		defer closeStream()
+		others = append(others, mw.Wrap(&collectorSink{stream: stream, address: c.Address, sim: sim, reqIDs: newReqIDs(cfg.Dialout, nodeID, newRand(cfg.Simulation.Seed, nodeID+"/req_id/"+c.Address)), backpressure: backpressure, encoding: c.Encoding, delivered: deliver}))
+	}
+	if collector != nil || len(others) > 0 {
+		log.Printf("MDT dial-out stream established. Sending telemetry every %s ...", interval.String())
+	} else {
+		log.Printf("No collector set, sending telemetry to sinks only every %s ...", interval.String())
+	}
+	if ready != nil {
+		ready()
+	}
+
+	// collect simulates the node up to now and sends its telemetry
+	collect := func(now time.Time) error {
+		sim.Lock()
+		scenario.Advance(sim, now)
+		sim.Step(now)
+
+		// Send all telemetry messages, except those of subscriptions
+		// disabled after failing to encode
+		messages := sim.Breaker.Filter(sim.BuildTelemetry(now))
+		checkpoint := sim.State.Checkpoint(sim, scenario)
+		paused := sim.Paused
+		sim.Unlock()
+
+		// The checkpoint is saved before the telemetry is sent, so an
+		// instance taking over never sends lower counters
+		sim.State.Save(nodeID, checkpoint)
+		if paused {
+			return nil
+		}
+
+		if backpressure.Enabled() {
+			messages = backpressure.Shed(messages)
+			messages = append(messages, backpressure.BuildTelemetry(uint64(now.UnixMilli()), nodeID, currentInterval))
+		}
+
+		delivered = delivered[:0]
+		if sink != nil {
+			// Sinks report no single messages; what they accept counts as
+			// delivered
+			if err := sink.Write(messages); err != nil {
+				log.Printf("%s: %v", nodeID, err)
+			} else {
+				delivered = append(delivered, messages...)
+			}
+		}
+
+		// Migrate the stream when the records moved the node to
+		// another collector
+		if addr := sim.Discovery.Pick(nodeID, connected); collector != nil && addr != connected {
+			log.Printf("%s: collector records changed, migrating stream from %s to %s", nodeID, connected, addr)
+			if err := connect(addr); err != nil {
+				return err
+			}
+		}
+
+		if collector != nil {
+			if err := collector.Write(messages); err != nil {
+				return err
+			}
+		}
+		for i, c := range others {
+			if err := c.Write(messages); err != nil {
+				return fmt.Errorf("%s: %w", cfg.Dialout.Collectors[i].Address, err)
+			}
+		}
+		if sim.Rates != nil {
+			sim.Rates.Observe(messages)
+		}
+		sim.Bandwidth.Observe(nodeID, messages)
+
+		sim.Lock()
+		sim.OnChange.Commit(delivered)
+		sim.MessagesSent += uint64(len(messages))
+		log.Printf("Sent telemetry: vxlan=%d/%d, bgp_neighbors=%d, evpn_routes=%d, vnis=%d",
+			sim.IngressBytes, sim.EgressBytes, len(sim.BGPNeighbors), sim.EVPN.TotalRoutes, len(sim.VNIs))
+		sim.Unlock()
+		return nil
+	}
+
+	clock := newSimClock(cfg.Simulation.Clock, sim.startTime)
+	ticker := time.NewTicker(currentInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case tick := <-ticker.C:
+			now := clock.At(tick)
+			for _, at := range catchUp(clock, sim, now, currentInterval) {
+				if err := collect(at); err != nil {
+					return err
+				}
+			}
+			if err := collect(now); err != nil {
+				return err
+			}
+
+			// Stretch or restore the interval when the degradation level,
+			// the budget stretch or the rate of the load profile changes
+			levelChanged := backpressure.EndTick()
+			s, r := sim.Budget.Stretch(), cfg.LoadProfile.Rate(now.Sub(sim.startTime))
+			if levelChanged || s != stretch || r != rate {
+				currentInterval = loadInterval(backpressure.Interval(interval)*time.Duration(s), r)
+				ticker.Reset(currentInterval)
+				// A ramp changes the rate a little every tick; log it in
+				// steps of 10%
+				if levelChanged || s != stretch || math.Abs(r-loggedRate) >= 0.1*loggedRate {
+					log.Printf("Telemetry interval now %s", currentInterval)
+					loggedRate = r
+				}
+				stretch, rate = s, r
+			}
+		}
+	}
+}
+
+// catchUp returns the collections missed since the previous tick to send
+// before the one at now, when the clock jumped and missed collections are
+// backfilled. With skip the simulation continues from now instead.
+func catchUp(clock *simClock, sim *Simulator, now time.Time, interval time.Duration) []time.Time {
+	cfg := sim.cfg.Simulation.CatchUp
+	last := clock.last
+	missed := clock.Missed(now, interval, cfg.Threshold)
+	if len(missed) == 0 || cfg.Behavior == "" || cfg.Behavior == catchUpOff {
+		return nil
+	}
+
+	sim.Lock()
+	defer sim.Unlock()
+	if cfg.Behavior == catchUpSkip {
+		sim.skipGap(last, now, interval, len(missed))
+		return nil
+	}
+	skipped := max(len(missed)-cfg.MaxBackfill, 0)
+	missed = missed[skipped:]
+	if skipped > 0 {
+		// Only the most recent collections are backfilled
+		sim.lastStep = missed[0].Add(-interval)
+	}
+	sim.event("clock_gap", "simulation", "Clock jumped %s from %s, backfilling %d collections, skipped %d",
+		now.Sub(last).Round(time.Second), last.Format(time.RFC3339), len(missed), skipped)
+	return missed
+}
+
+// collectorSink sends telemetry on the dial-out stream of one node, so the
+// stream can be wrapped in the same middleware as the sinks
+type collectorSink struct {
+	stream       dialoutStream
+	address      string // of the collector
+	sim          *Simulator
+	reqIDs       func(subscription string) int64
+	backpressure *Backpressure
+	encoding     string                     // of the payloads, GPB-KV when empty
+	delivered    func(*telemetry.Telemetry) // called with every message sent
+}
+
+func (c *collectorSink) Name() string { return "collector" }
+
+// Write sends every message, failing on the first send error. Messages of
+// subscriptions reporting errors carry them in Errors, without the data
+// unless the error keeps it.
+func (c *collectorSink) Write(messages []*telemetry.Telemetry) error {
+	sensorErrors := c.sim.SensorErrors()
+	payloads, errs := c.sim.Marshal.Marshal(messages, c.encoding)
+	for i, telem := range messages {
+		c.sim.Breaker.Record(telem.SubscriptionIDStr, errs[i])
+	}
+	for i, telem := range messages {
+		msg := &mdt_dialout.MdtDialoutArgs{ReqId: c.reqIDs(telem.SubscriptionIDStr)}
+		sensorErr, failing := sensorErrors[telem.SubscriptionIDStr]
+		if failing {
+			msg.Errors = strings.ReplaceAll(sensorErr.text, "{path}", telem.EncodingPath)
+		}
+		if !failing || sensorErr.keep {
+			if errs[i] != nil {
+				continue
+			}
+			msg.Data = payloads[i]
+		}
+
+		sendStart := time.Now()
+		err := c.stream.Send(msg)
+		c.sim.Capture.Add(sendStart, c.address, c.encoding, telem, msg, err)
+		if err != nil {
+			c.sim.Capture.Dump()
+			return fmt.Errorf("failed to send MdtDialoutArgs: %w", err)
+		}
+		c.delivered(telem)
+		latency := time.Since(sendStart)
+		c.backpressure.ObserveSend(telem.SubscriptionIDStr, latency)
+		c.sim.Bandwidth.ObserveSend(c.sim.nodeID, latency)
+		warnSlowSend(c.sim.nodeID, telem.SubscriptionIDStr, len(msg.Data), latency, c.sim.cfg.Dialout.SlowSendWarning)
+	}
+	return nil
+}
+
+// PayloadSize returns the bytes a message takes in the encoding of the
+// stream
+func (c *collectorSink) PayloadSize(m *telemetry.Telemetry) int {
+	return encodedSize(c.encoding, m)
+}
+
+// Close does nothing; streamNode owns the connection
+func (c *collectorSink) Close() error { return nil }
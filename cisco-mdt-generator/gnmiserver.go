@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/gnmi"
+	"cisco-mdt-generator/pkg/telemetry"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GNMIServer serves the telemetry of a node to gNMI clients, such as gnmic
+// or OpenConfig collectors, instead of dialing out. It is a sink: every
+// collection replaces the rows of its subscriptions, and each Subscribe
+// stream sends the leaves under its paths from the latest rows.
+//
+// A row is addressed by its encoding path, without the YANG module, with
+// the row keys on the last element, e.g.
+// System/bgp-items/inst-items/dom-items/Dom-list/peer-items/Peer-list[neighbor-address=10.0.0.1][remote-as=65001],
+// and its leaves lie below it. Subscribed paths match with * for any
+// element or key value and ... for any number of elements.
+type GNMIServer struct {
+	mu      sync.Mutex
+	rows    map[string][]*gnmiRow // latest rows by subscription
+	order   []string              // subscriptions in the order first collected
+	ready   chan struct{}         // closed at the first collection
+	streams map[chan struct{}]struct{}
+}
+
+// gnmiRow is a row of a collection with its leaves
+type gnmiRow struct {
+	path      []*gnmi.PathElem
+	timestamp int64 // in nanoseconds
+	leaves    []gnmiLeaf
+}
+
+// gnmiLeaf is a value of a row
+type gnmiLeaf struct {
+	id    string           // the full path, counting repeated names
+	path  []*gnmi.PathElem // below the row
+	field *telemetry.TelemetryField
+}
+
+// NewGNMIServer creates a server without collections
+func NewGNMIServer() *GNMIServer {
+	return &GNMIServer{
+		rows:    make(map[string][]*gnmiRow),
+		ready:   make(chan struct{}),
+		streams: make(map[chan struct{}]struct{}),
+	}
+}
+
+// gnmiOutput is the output name of the gNMI server
+const gnmiOutput = "gnmi"
+
+func (g *GNMIServer) Name() string { return gnmiOutput }
+
+// Write replaces the rows of the collected subscriptions and wakes up the
+// streams. Subscriptions missing from a collection keep their rows.
+func (g *GNMIServer) Write(messages []*telemetry.Telemetry) error {
+	rows := make(map[string][]*gnmiRow)
+	for _, m := range messages {
+		rows[m.SubscriptionIDStr] = append(rows[m.SubscriptionIDStr], gnmiRows(m)...)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for sub, r := range rows {
+		if _, ok := g.rows[sub]; !ok {
+			g.order = append(g.order, sub)
+		}
+		g.rows[sub] = r
+	}
+	select {
+	case <-g.ready:
+	default:
+		close(g.ready)
+	}
+	// A stream busy sending skips to the latest collection
+	for wake := range g.streams {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close does nothing; the listener stops the streams
+func (g *GNMIServer) Close() error { return nil }
+
+// snapshot returns the latest rows of every subscription
+func (g *GNMIServer) snapshot() []*gnmiRow {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var rows []*gnmiRow
+	for _, sub := range g.order {
+		rows = append(rows, g.rows[sub]...)
+	}
+	return rows
+}
+
+// watch registers a stream to wake up at every collection
+func (g *GNMIServer) watch() (chan struct{}, func()) {
+	wake := make(chan struct{}, 1)
+	g.mu.Lock()
+	g.streams[wake] = struct{}{}
+	g.mu.Unlock()
+	return wake, func() {
+		g.mu.Lock()
+		delete(g.streams, wake)
+		g.mu.Unlock()
+	}
+}
+
+// gnmiRows converts the rows of a message, the keys of a row becoming the
+// keys of the last element of its path
+func gnmiRows(m *telemetry.Telemetry) []*gnmiRow {
+	base := gnmiPath(m.EncodingPath, "").Elem
+	var rows []*gnmiRow
+	for _, r := range m.DataGpbkv {
+		last := &gnmi.PathElem{}
+		if len(base) > 0 {
+			last.Name = base[len(base)-1].Name
+		}
+		row := &gnmiRow{timestamp: int64(r.Timestamp) * int64(time.Millisecond)}
+		if row.timestamp == 0 {
+			row.timestamp = int64(m.MsgTimestamp) * int64(time.Millisecond)
+		}
+		for _, section := range r.Fields {
+			if section.Name != "keys" {
+				continue
+			}
+			for _, k := range section.Fields {
+				if last.Key == nil {
+					last.Key = make(map[string]string)
+				}
+				last.Key[k.Name] = keyValue(k)
+			}
+		}
+		if len(base) > 0 {
+			row.path = append(base[:len(base)-1:len(base)-1], last)
+		}
+
+		prefix := (&gnmi.Path{Elem: row.path}).String()
+		for _, section := range r.Fields {
+			if section.Name != "keys" {
+				row.leaves = appendLeaves(row.leaves, prefix, nil, section.Fields)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// appendLeaves appends the values below a container, walking nested
+// containers
+func appendLeaves(leaves []gnmiLeaf, prefix string, path []*gnmi.PathElem, fields []*telemetry.TelemetryField) []gnmiLeaf {
+	seen := make(map[string]int)
+	for _, f := range fields {
+		p := append(path[:len(path):len(path)], &gnmi.PathElem{Name: f.Name})
+		id := valueID(prefix, f.Name, seen)
+		if len(f.Fields) > 0 {
+			leaves = appendLeaves(leaves, id, p, f.Fields)
+			continue
+		}
+		leaves = append(leaves, gnmiLeaf{id: id, path: p, field: f})
+	}
+	return leaves
+}
+
+// keyValue renders a key field as a path key value
+func keyValue(f *telemetry.TelemetryField) string {
+	if f.StringValue != nil {
+		return *f.StringValue
+	}
+	return fieldValue(f)
+}
+
+// Subscribe serves one Subscribe stream until the client goes away
+func (g *GNMIServer) Subscribe(stream gnmi.GNMI_SubscribeServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	list := req.Subscribe
+	if list == nil {
+		return status.Error(codes.InvalidArgument, "the first request must be a subscription list")
+	}
+	s, err := newGNMIStream(list)
+	if err != nil {
+		return err
+	}
+
+	// Nothing can be sent or checked before the first collection
+	select {
+	case <-g.ready:
+	case <-stream.Context().Done():
+		return stream.Context().Err()
+	}
+	wake, cancel := g.watch()
+	defer cancel()
+
+	rows := g.snapshot()
+	for _, sub := range s.subs {
+		if !sub.matchesAny(rows) {
+			return status.Errorf(codes.NotFound, "no simulated data at %s", (&gnmi.Path{Elem: sub.path}).String())
+		}
+	}
+	if err := s.send(stream, rows, time.Now(), true); err != nil {
+		return err
+	}
+
+	switch list.Mode {
+	case gnmi.SubscriptionList_ONCE:
+		return nil
+	case gnmi.SubscriptionList_POLL:
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			if !req.Poll {
+				return status.Error(codes.InvalidArgument, "a POLL subscription only accepts poll requests")
+			}
+			if err := s.send(stream, g.snapshot(), time.Now(), true); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The client only ever closes a STREAM subscription
+	go func() {
+		for {
+			if _, err := stream.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-wake:
+			if err := s.send(stream, g.snapshot(), time.Now(), false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// gnmiStream is the state of one Subscribe stream
+type gnmiStream struct {
+	list   *gnmi.SubscriptionList
+	prefix *gnmi.Path
+	subs   []*gnmiSubscription
+	synced bool
+}
+
+// gnmiSubscription is a subscribed path and what it last sent
+type gnmiSubscription struct {
+	*gnmi.Subscription
+	path     []*gnmi.PathElem // with the prefix of the list
+	lastSent time.Time
+	lastFull time.Time
+	sent     map[string]gnmiSent // by leaf id
+}
+
+// gnmiSent is a leaf as last sent
+type gnmiSent struct {
+	path []*gnmi.PathElem
+	hash uint64
+}
+
+// newGNMIStream checks a subscription list
+func newGNMIStream(list *gnmi.SubscriptionList) (*gnmiStream, error) {
+	switch list.Mode {
+	case gnmi.SubscriptionList_STREAM, gnmi.SubscriptionList_ONCE, gnmi.SubscriptionList_POLL:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown subscription list mode %d", list.Mode)
+	}
+	switch list.Encoding {
+	case gnmi.Encoding_JSON, gnmi.Encoding_JSON_IETF, gnmi.Encoding_PROTO, gnmi.Encoding_ASCII:
+	default:
+		return nil, status.Errorf(codes.Unimplemented, "unsupported encoding %d, expected JSON, JSON_IETF, PROTO or ASCII", list.Encoding)
+	}
+	if len(list.Subscription) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no subscription")
+	}
+
+	s := &gnmiStream{list: list, prefix: &gnmi.Path{}}
+	if list.Prefix != nil {
+		// The response prefix names the requested target, not the elements
+		s.prefix.Target = list.Prefix.Target
+	}
+	for _, sub := range list.Subscription {
+		switch sub.Mode {
+		case gnmi.SubscriptionMode_TARGET_DEFINED, gnmi.SubscriptionMode_ON_CHANGE, gnmi.SubscriptionMode_SAMPLE:
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown subscription mode %d", sub.Mode)
+		}
+		var path []*gnmi.PathElem
+		if list.Prefix != nil {
+			path = append(path, list.Prefix.Elem...)
+		}
+		if sub.Path != nil {
+			path = append(path, sub.Path.Elem...)
+		}
+		s.subs = append(s.subs, &gnmiSubscription{Subscription: sub, path: path, sent: make(map[string]gnmiSent)})
+	}
+	return s, nil
+}
+
+// send sends the leaves due at now and the deletes of the leaves gone, then
+// a sync response after the initial values or a poll. The first call of a
+// stream sends every leaf, unless the list asks for updates only.
+func (s *gnmiStream) send(stream gnmi.GNMI_SubscribeServer, rows []*gnmiRow, now time.Time, sync bool) error {
+	initial := !s.synced
+	var due []*gnmiSubscription
+	for _, sub := range s.subs {
+		if initial || sync || sub.due(now) {
+			due = append(due, sub)
+		}
+	}
+
+	for _, row := range rows {
+		n := &gnmi.Notification{Timestamp: row.timestamp, Prefix: &gnmi.Path{Elem: row.path, Target: s.prefix.Target}}
+		for _, leaf := range row.leaves {
+			full := append(row.path[:len(row.path):len(row.path)], leaf.path...)
+			send := false
+			for _, sub := range due {
+				if !matchElems(sub.path, full) {
+					continue
+				}
+				hash := fieldHash(leaf.field)
+				prev, known := sub.sent[leaf.id]
+				sub.sent[leaf.id] = gnmiSent{path: full, hash: hash}
+				switch {
+				case initial:
+					send = send || !s.list.UpdatesOnly
+				case sync || sub.full(now):
+					send = true
+				case sub.Mode == gnmi.SubscriptionMode_ON_CHANGE || sub.SuppressRedundant:
+					send = send || !known || prev.hash != hash
+				default:
+					send = true
+				}
+			}
+			if !send {
+				continue
+			}
+			val, err := typedValue(leaf.field, s.list.Encoding)
+			if err != nil {
+				return status.Errorf(codes.Internal, "%s: %v", leaf.id, err)
+			}
+			if val != nil {
+				n.Update = append(n.Update, &gnmi.Update{Path: &gnmi.Path{Elem: leaf.path}, Val: val})
+			}
+		}
+		if len(n.Update) > 0 {
+			if err := stream.Send(&gnmi.SubscribeResponse{Update: n}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Leaves of rows that are gone are deleted and forgotten
+	if !initial {
+		current := make(map[string]bool)
+		for _, row := range rows {
+			for _, leaf := range row.leaves {
+				current[leaf.id] = true
+			}
+		}
+		deleted := &gnmi.Notification{Timestamp: now.UnixNano(), Prefix: s.prefix}
+		gone := make(map[string]bool)
+		for _, sub := range due {
+			for id, leaf := range sub.sent {
+				if current[id] {
+					continue
+				}
+				delete(sub.sent, id)
+				if !gone[id] {
+					gone[id] = true
+					deleted.Delete = append(deleted.Delete, &gnmi.Path{Elem: leaf.path})
+				}
+			}
+		}
+		if len(deleted.Delete) > 0 {
+			if err := stream.Send(&gnmi.SubscribeResponse{Update: deleted}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, sub := range due {
+		sub.lastSent = now
+		if initial || sync || sub.full(now) {
+			sub.lastFull = now
+		}
+	}
+	if initial || sync {
+		s.synced = true
+		return stream.Send(&gnmi.SubscribeResponse{SyncResponse: true})
+	}
+	return nil
+}
+
+// due reports whether a subscription sends at now. Collections come at the
+// simulation interval, so a sample is due up to a tenth of its interval
+// early rather than a whole interval late.
+func (s *gnmiSubscription) due(now time.Time) bool {
+	if s.Mode != gnmi.SubscriptionMode_SAMPLE || s.SampleInterval == 0 {
+		return true
+	}
+	interval := time.Duration(s.SampleInterval)
+	return now.Sub(s.lastSent) >= interval-interval/10
+}
+
+// full reports whether the heartbeat of a subscription sending only changes
+// has passed, so it sends every leaf again
+func (s *gnmiSubscription) full(now time.Time) bool {
+	return s.HeartbeatInterval > 0 && now.Sub(s.lastFull) >= time.Duration(s.HeartbeatInterval)
+}
+
+// matchesAny reports whether a subscribed path covers a leaf of the rows
+func (s *gnmiSubscription) matchesAny(rows []*gnmiRow) bool {
+	for _, row := range rows {
+		for _, leaf := range row.leaves {
+			if matchElems(s.path, append(row.path[:len(row.path):len(row.path)], leaf.path...)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchElems reports whether a leaf lies under a subscribed path. * matches
+// any element name or key value, ... any number of elements, and keys the
+// subscribed element leaves out match any value. YANG module prefixes of
+// subscribed names are ignored.
+func matchElems(pattern, path []*gnmi.PathElem) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if pattern[0].Name == "..." {
+		for i := range len(path) + 1 {
+			if matchElems(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	p, e := pattern[0], path[0]
+	if _, local, ok := strings.Cut(p.Name, ":"); ok {
+		p = &gnmi.PathElem{Name: local, Key: p.Key}
+	}
+	if p.Name != "*" && p.Name != e.Name {
+		return false
+	}
+	for k, v := range p.Key {
+		if got, ok := e.Key[k]; !ok || (v != "*" && v != got) {
+			return false
+		}
+	}
+	return matchElems(pattern[1:], path[1:])
+}
+
+// typedValue converts a leaf to a value in the requested encoding: scalar
+// values for PROTO, JSON documents for JSON and JSON_IETF, where 64-bit
+// integers are strings as RFC 7951 has them, and text for ASCII. A leaf
+// without a value has none.
+func typedValue(f *telemetry.TelemetryField, encoding gnmi.Encoding) (*gnmi.TypedValue, error) {
+	var v any
+	switch {
+	case f.StringValue != nil:
+		v = *f.StringValue
+	case f.BytesValue != nil:
+		v = f.BytesValue
+	case f.BoolValue != nil:
+		v = *f.BoolValue
+	case f.Uint32Value != nil:
+		v = uint64(*f.Uint32Value)
+	case f.Uint64Value != nil:
+		v = *f.Uint64Value
+	case f.Sint32Value != nil:
+		v = int64(*f.Sint32Value)
+	case f.Sint64Value != nil:
+		v = *f.Sint64Value
+	case f.DoubleValue != nil:
+		v = *f.DoubleValue
+	case f.FloatValue != nil:
+		v = float64(*f.FloatValue)
+	default:
+		return nil, nil
+	}
+
+	switch encoding {
+	case gnmi.Encoding_PROTO:
+		switch v := v.(type) {
+		case string:
+			return &gnmi.TypedValue{StringVal: &v}, nil
+		case []byte:
+			return &gnmi.TypedValue{BytesVal: v}, nil
+		case bool:
+			return &gnmi.TypedValue{BoolVal: &v}, nil
+		case uint64:
+			return &gnmi.TypedValue{UintVal: &v}, nil
+		case int64:
+			return &gnmi.TypedValue{IntVal: &v}, nil
+		case float64:
+			return &gnmi.TypedValue{DoubleVal: &v}, nil
+		}
+	case gnmi.Encoding_ASCII:
+		s := keyValue(f)
+		return &gnmi.TypedValue{ASCIIVal: &s}, nil
+	}
+
+	if d, ok := v.(float64); ok && (math.IsNaN(d) || math.IsInf(d, 0)) {
+		return nil, fmt.Errorf("%v is not a JSON number", d)
+	}
+	if encoding == gnmi.Encoding_JSON_IETF && (f.Uint64Value != nil || f.Sint64Value != nil) {
+		v = fieldValue(f)
+	}
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == gnmi.Encoding_JSON_IETF {
+		return &gnmi.TypedValue{JSONIETFVal: doc}, nil
+	}
+	return &gnmi.TypedValue{JSONVal: doc}, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// GRPCListener is a server-mode gRPC listener of the simulator. Every
+// listener registers the standard health and reflection services so tools
+// like grpcurl and Kubernetes gRPC probes work against it.
+type GRPCListener struct {
+	Server *grpc.Server
+	Health *health.Server
+	lis    net.Listener
+}
+
+// NewGRPCListener listens on addr and creates a server with health and
+// reflection registered. Health starts as NOT_SERVING until the caller marks
+// the simulator ready.
+func NewGRPCListener(addr string, opts ...grpc.ServerOption) (*GRPCListener, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	server := grpc.NewServer(opts...)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+
+	return &GRPCListener{Server: server, Health: healthServer, lis: lis}, nil
+}
+
+// Serve starts serving in the background. Services must be registered first.
+func (l *GRPCListener) Serve() {
+	log.Printf("gRPC listener serving on %s", l.lis.Addr())
+	go func() {
+		if err := l.Server.Serve(l.lis); err != nil {
+			log.Printf("gRPC listener on %s stopped: %v", l.lis.Addr(), err)
+		}
+	}()
+}
+
+// SetServing marks the overall health status of the simulator
+func (l *GRPCListener) SetServing(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	l.Health.SetServingStatus("", status)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// connStats is the gRPC stats handler of the dial-out connections of one
+// node. It counts what the application does not see: connections, bytes on
+// the wire including gRPC framing, RPC durations and how RPCs ended.
+type connStats struct {
+	mu        sync.Mutex
+	opened    uint64
+	open      int64
+	bytesOut  uint64
+	bytesIn   uint64
+	rpcs      uint64
+	rpcTime   time.Duration // of the RPCs that ended
+	rpcsEnded uint64
+	errors    map[string]uint64 // RPCs that ended with an error, by status code
+}
+
+// TagRPC leaves the RPC context as it is
+func (c *connStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context { return ctx }
+
+// TagConn leaves the connection context as it is
+func (c *connStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+
+// HandleRPC counts the wire bytes, start and end of the RPCs
+func (c *connStats) HandleRPC(_ context.Context, s stats.RPCStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch s := s.(type) {
+	case *stats.Begin:
+		c.rpcs++
+	case *stats.OutPayload:
+		c.bytesOut += uint64(s.WireLength)
+	case *stats.InPayload:
+		c.bytesIn += uint64(s.WireLength)
+	case *stats.InHeader:
+		c.bytesIn += uint64(s.WireLength)
+	case *stats.InTrailer:
+		c.bytesIn += uint64(s.WireLength)
+	case *stats.End:
+		c.rpcsEnded++
+		c.rpcTime += s.EndTime.Sub(s.BeginTime)
+		if s.Error != nil {
+			if c.errors == nil {
+				c.errors = make(map[string]uint64)
+			}
+			c.errors[status.Code(s.Error).String()]++
+		}
+	}
+}
+
+// HandleConn counts the connections opened and still open
+func (c *connStats) HandleConn(_ context.Context, s stats.ConnStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch s.(type) {
+	case *stats.ConnBegin:
+		c.opened++
+		c.open++
+	case *stats.ConnEnd:
+		c.open--
+	}
+}
+
+// ConnStats returns the gRPC stats handler of the connections of a node,
+// or nil on a nil Bandwidth
+func (b *Bandwidth) ConnStats(nodeID string) stats.Handler {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.conns[nodeID]
+	if !ok {
+		c = &connStats{}
+		b.conns[nodeID] = c
+	}
+	return c
+}
+
+// writeConnStats writes the connection statistics of every node in the
+// Prometheus text format. The caller holds b.mu.
+func (b *Bandwidth) writeConnStats(w io.Writer) {
+	nodes := make([]string, 0, len(b.conns))
+	for node := range b.conns {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	metric := func(name, typ, help string, value func(c *connStats) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		for _, node := range nodes {
+			c := b.conns[node]
+			c.mu.Lock()
+			fmt.Fprintf(w, "%s{node=%q} %s\n", name, node, value(c))
+			c.mu.Unlock()
+		}
+	}
+	metric("mdtsim_grpc_connections_total", "counter", "gRPC connections opened to the collector per node.",
+		func(c *connStats) string { return fmt.Sprint(c.opened) })
+	metric("mdtsim_grpc_connections_open", "gauge", "gRPC connections to the collector open per node.",
+		func(c *connStats) string { return fmt.Sprint(c.open) })
+	metric("mdtsim_grpc_wire_bytes_sent_total", "counter", "Bytes sent to the collector including gRPC framing per node.",
+		func(c *connStats) string { return fmt.Sprint(c.bytesOut) })
+	metric("mdtsim_grpc_wire_bytes_received_total", "counter", "Bytes received from the collector including gRPC framing per node.",
+		func(c *connStats) string { return fmt.Sprint(c.bytesIn) })
+	metric("mdtsim_grpc_rpcs_started_total", "counter", "MdtDialout RPCs started per node.",
+		func(c *connStats) string { return fmt.Sprint(c.rpcs) })
+
+	fmt.Fprintln(w, "# HELP mdtsim_grpc_rpc_duration_seconds Duration of the MdtDialout RPCs that ended per node.")
+	fmt.Fprintln(w, "# TYPE mdtsim_grpc_rpc_duration_seconds summary")
+	for _, node := range nodes {
+		c := b.conns[node]
+		c.mu.Lock()
+		fmt.Fprintf(w, "mdtsim_grpc_rpc_duration_seconds_sum{node=%q} %g\n", node, c.rpcTime.Seconds())
+		fmt.Fprintf(w, "mdtsim_grpc_rpc_duration_seconds_count{node=%q} %d\n", node, c.rpcsEnded)
+		c.mu.Unlock()
+	}
+
+	fmt.Fprintln(w, "# HELP mdtsim_grpc_rpc_errors_total MdtDialout RPCs that ended with an error per node and status code.")
+	fmt.Fprintln(w, "# TYPE mdtsim_grpc_rpc_errors_total counter")
+	for _, node := range nodes {
+		c := b.conns[node]
+		c.mu.Lock()
+		codes := make([]string, 0, len(c.errors))
+		for code := range c.errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "mdtsim_grpc_rpc_errors_total{node=%q,code=%q} %d\n", node, code, c.errors[code])
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// InfluxSink flattens telemetry into Influx line protocol the way Telegraf's
+// cisco_telemetry_mdt input does: one point per row, measured by encoding
+// path, tagged with source, subscription, path and the row keys, with the
+// content leaves as fields
+type InfluxSink struct {
+	mu     sync.Mutex
+	cfg    InfluxSinkConfig
+	client *http.Client
+	conn   net.Conn
+}
+
+// NewInfluxSink creates a sink writing to an InfluxDB v2 HTTP API
+// (http:// or https://) or a raw line protocol socket (udp:// or tcp://)
+func NewInfluxSink(cfg InfluxSinkConfig) (*InfluxSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+
+	s := &InfluxSink{cfg: cfg}
+	switch u.Scheme {
+	case "http", "https":
+		s.client = &http.Client{Timeout: cfg.Timeout}
+	case "udp", "tcp":
+		s.conn, err = net.DialTimeout(u.Scheme, u.Host, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	return s, nil
+}
+
+func (s *InfluxSink) Name() string { return "influx" }
+
+// Write sends one batch of points per call
+func (s *InfluxSink) Write(messages []*telemetry.Telemetry) error {
+	var buf bytes.Buffer
+	for _, m := range messages {
+		appendLineProtocol(&buf, m)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	if s.conn != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, err := s.conn.Write(buf.Bytes())
+		return err
+	}
+	return s.post(buf.Bytes())
+}
+
+// post writes a batch to the InfluxDB v2 write API
+func (s *InfluxSink) post(body []byte) error {
+	q := url.Values{}
+	q.Set("org", s.cfg.Org)
+	q.Set("bucket", s.cfg.Bucket)
+	q.Set("precision", "ns")
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.cfg.URL, "/")+"/api/v2/write?"+q.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.cfg.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("write failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *InfluxSink) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// appendLineProtocol writes one line per row of a message
+func appendLineProtocol(buf *bytes.Buffer, m *telemetry.Telemetry) {
+	for _, row := range m.DataGpbkv {
+		tags := map[string]string{
+			"source": m.NodeIDStr,
+			"path":   m.EncodingPath,
+		}
+		if m.SubscriptionIDStr != "" {
+			tags["subscription"] = m.SubscriptionIDStr
+		}
+
+		fields := make(map[string]string)
+		for _, section := range row.Fields {
+			switch section.Name {
+			case "keys":
+				for _, k := range section.Fields {
+					flattenInflux(k, "", func(name, value string) {
+						tags[name] = value
+					}, true)
+				}
+			case "content":
+				for _, f := range section.Fields {
+					flattenInflux(f, "", func(name, value string) {
+						fields[name] = value
+					}, false)
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		buf.WriteString(escapeInflux(m.EncodingPath, ", "))
+		for _, k := range sortedKeys(tags) {
+			if tags[k] == "" {
+				continue
+			}
+			buf.WriteString("," + escapeInflux(k, ",= ") + "=" + escapeInflux(tags[k], ",= "))
+		}
+		for i, k := range sortedKeys(fields) {
+			if i == 0 {
+				buf.WriteByte(' ')
+			} else {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(escapeInflux(k, ",= ") + "=" + fields[k])
+		}
+
+		ts := row.Timestamp
+		if ts == 0 {
+			ts = m.MsgTimestamp
+		}
+		buf.WriteString(" " + strconv.FormatInt(int64(ts)*int64(time.Millisecond), 10) + "\n")
+	}
+}
+
+// flattenInflux calls emit for every leaf under f, naming nested leaves by
+// their slash-joined path. Tag values are rendered raw, field values in
+// line protocol syntax.
+func flattenInflux(f *telemetry.TelemetryField, prefix string, emit func(name, value string), tag bool) {
+	name := f.Name
+	if prefix != "" {
+		name = prefix + "/" + name
+	}
+	if len(f.Fields) > 0 {
+		for _, child := range f.Fields {
+			flattenInflux(child, name, emit, tag)
+		}
+		return
+	}
+
+	var value string
+	switch {
+	case f.StringValue != nil && tag:
+		value = *f.StringValue
+	case f.StringValue != nil:
+		value = `"` + escapeInflux(*f.StringValue, `"\`) + `"`
+	case f.BoolValue != nil:
+		value = strconv.FormatBool(*f.BoolValue)
+	case f.DoubleValue != nil:
+		value = strconv.FormatFloat(*f.DoubleValue, 'f', -1, 64)
+	case f.FloatValue != nil:
+		value = strconv.FormatFloat(float64(*f.FloatValue), 'f', -1, 32)
+	case f.Uint32Value != nil:
+		value = strconv.FormatUint(uint64(*f.Uint32Value), 10)
+	case f.Uint64Value != nil:
+		// Telegraf writes unsigned values as integers by default
+		value = strconv.FormatUint(min(*f.Uint64Value, math.MaxInt64), 10)
+	case f.Sint32Value != nil:
+		value = strconv.FormatInt(int64(*f.Sint32Value), 10)
+	case f.Sint64Value != nil:
+		value = strconv.FormatInt(*f.Sint64Value, 10)
+	default:
+		return
+	}
+
+	// Integer fields carry the i suffix
+	if !tag && (f.Uint32Value != nil || f.Uint64Value != nil || f.Sint32Value != nil || f.Sint64Value != nil) {
+		value += "i"
+	}
+	emit(name, value)
+}
+
+// escapeInflux backslash-escapes the given characters and newlines, which
+// line protocol cannot carry literally
+func escapeInflux(s, chars string) string {
+	if !strings.ContainsAny(s, chars+"\n") {
+		return s
+	}
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '\n':
+			b.WriteString(`\n`)
+		case strings.ContainsRune(chars, c):
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of m in order, for stable line protocol output
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Baseline traffic model per interface, in packets per second
+const (
+	ifUcastPPSMin    = 2000
+	ifUcastPPSMax    = 20000
+	ifBcastPPSMax    = 20
+	ifMcastPPSMax    = 50
+	ifUnkUcastPPSMax = 10
+
+	ifAvgUcastBytes = 800
+	ifAvgBUMBytes   = 64
+)
+
+// InterfaceState tracks counters of a physical interface
+type InterfaceState struct {
+	Name      string
+	SpeedMbps uint64
+
+	// Storm-control levels as percent of bandwidth, 0 = disabled
+	StormControlBroadcast float64
+	StormControlMulticast float64
+	StormControlUnicast   float64
+
+	InOctets       uint64
+	InUcastPkts    uint64
+	InBcastPkts    uint64
+	InMcastPkts    uint64
+	InUnkUcastPkts uint64
+
+	StormBcastDrops   uint64
+	StormMcastDrops   uint64
+	StormUnkUcstDrops uint64
+
+	// StormPPS is the offered broadcast rate while a storm event is active
+	StormPPS uint64
+	// aboveThreshold is set while storm-control is dropping traffic
+	aboveThreshold bool
+
+	// forwarded are the packets of the last interval that passed
+	// storm-control, shared among the VLANs of the interface
+	forwarded ifPackets
+}
+
+// ifPackets counts the packets of an interval by kind
+type ifPackets struct {
+	ucast, bcast, mcast, unkUcast uint64
+}
+
+// FindInterface returns the interface with the given name, or nil
+func (s *Simulator) FindInterface(name string) *InterfaceState {
+	for _, i := range s.Interfaces {
+		if i.Name == name {
+			return i
+		}
+	}
+	return nil
+}
+
+// stormControl limits offered BUM packets to the configured percent of
+// bandwidth and returns the packets passed and dropped
+func stormControl(offered uint64, levelPercent float64, speedMbps uint64, seconds float64) (passed, dropped uint64) {
+	if levelPercent <= 0 {
+		return offered, 0
+	}
+
+	allowedBytes := float64(speedMbps) * 1e6 / 8 * levelPercent / 100 * seconds
+	allowed := uint64(allowedBytes / ifAvgBUMBytes)
+	if offered <= allowed {
+		return offered, 0
+	}
+	return allowed, offered - allowed
+}
+
+// stepInterfaces advances interface counters for an interval of the given
+// length and returns the number of broadcast packets punted to the CPU
+func (s *Simulator) stepInterfaces(now time.Time, seconds float64) uint64 {
+	var punted uint64
+
+	for _, intf := range s.Interfaces {
+		ucast := uint64(float64(ifUcastPPSMin+s.rng.Intn(ifUcastPPSMax-ifUcastPPSMin)) * seconds)
+		bcast := uint64(float64(s.rng.Intn(ifBcastPPSMax+1)+int(intf.StormPPS)) * seconds)
+		mcast := uint64(float64(s.rng.Intn(ifMcastPPSMax+1)) * seconds)
+		unkUcast := uint64(float64(s.rng.Intn(ifUnkUcastPPSMax+1)) * seconds)
+
+		bcastPassed, bcastDropped := stormControl(bcast, intf.StormControlBroadcast, intf.SpeedMbps, seconds)
+		mcastPassed, mcastDropped := stormControl(mcast, intf.StormControlMulticast, intf.SpeedMbps, seconds)
+		unkPassed, unkDropped := stormControl(unkUcast, intf.StormControlUnicast, intf.SpeedMbps, seconds)
+		intf.forwarded = ifPackets{ucast: ucast, bcast: bcastPassed, mcast: mcastPassed, unkUcast: unkPassed}
+
+		// Input counters count every received frame, storm-control drops included
+		intf.InUcastPkts += ucast
+		intf.InBcastPkts += bcast
+		intf.InMcastPkts += mcast
+		intf.InUnkUcastPkts += unkUcast
+		intf.InOctets += ucast*ifAvgUcastBytes + (bcast+mcast+unkUcast)*ifAvgBUMBytes
+
+		intf.StormBcastDrops += bcastDropped
+		intf.StormMcastDrops += mcastDropped
+		intf.StormUnkUcstDrops += unkDropped
+
+		// Storm-control logs threshold crossings like NX-OS ETHPORT messages
+		dropping := bcastDropped+mcastDropped+unkDropped > 0
+		if dropping && !intf.aboveThreshold {
+			s.Syslog.Emit(now, SeverityNotice, "ETHPORT", "STORM_CONTROL_ABOVE_THRESHOLD",
+				fmt.Sprintf("Traffic in port %s exceeds the configured threshold %.2f", intf.Name, intf.StormControlBroadcast))
+		} else if !dropping && intf.aboveThreshold {
+			s.Syslog.Emit(now, SeverityNotice, "ETHPORT", "STORM_CONTROL_BELOW_THRESHOLD",
+				fmt.Sprintf("Traffic in port %s has fallen below the configured threshold %.2f", intf.Name, intf.StormControlBroadcast))
+		}
+		intf.aboveThreshold = dropping
+
+		// Broadcasts that survive storm-control (mostly ARP) are punted to the CPU
+		punted += bcastPassed
+	}
+
+	return punted
+}
+
+// StartBroadcastStorm floods an interface with broadcast traffic at the given rate
+func (s *Simulator) StartBroadcastStorm(name string, pps uint64) error {
+	intf := s.FindInterface(name)
+	if intf == nil {
+		return fmt.Errorf("unknown interface %s", name)
+	}
+
+	intf.StormPPS = pps
+	s.event("storm_start", name, "Broadcast STORM started on %s at %d pps (storm-control broadcast level %.2f%%)",
+		name, pps, intf.StormControlBroadcast)
+	return nil
+}
+
+// StopBroadcastStorm ends a broadcast storm on an interface
+func (s *Simulator) StopBroadcastStorm(name string) error {
+	intf := s.FindInterface(name)
+	if intf == nil {
+		return fmt.Errorf("unknown interface %s", name)
+	}
+
+	intf.StormPPS = 0
+	s.event("storm_end", name, "Broadcast storm on %s ended", name)
+	return nil
+}
+
+// buildInterfaceTelemetry reports per-interface BUM and storm-control counters
+func buildInterfaceTelemetry(ts uint64, nodeID string, interfaces []*InterfaceState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, i := range interfaces {
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("id", i.Name, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.Uint64Field("in-octets", i.InOctets, ts),
+				telemetry.Uint64Field("in-ucast-pkts", i.InUcastPkts, ts),
+				telemetry.Uint64Field("in-bcast-pkts", i.InBcastPkts, ts),
+				telemetry.Uint64Field("in-mcast-pkts", i.InMcastPkts, ts),
+				telemetry.Uint64Field("in-unknown-ucast-pkts", i.InUnkUcastPkts, ts),
+				telemetry.Uint64Field("storm-control-bcast-drops", i.StormBcastDrops, ts),
+				telemetry.Uint64Field("storm-control-mcast-drops", i.StormMcastDrops, ts),
+				telemetry.Uint64Field("storm-control-unknown-ucast-drops", i.StormUnkUcstDrops, ts),
+				telemetry.Uint64Field("storm-control-total-drops", i.StormBcastDrops+i.StormMcastDrops+i.StormUnkUcstDrops, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "interface_counters",
+		EncodingPath:        "Cisco-NX-OS-device:System/intf-items/phys-items/PhysIf-list/dbgIfIn-items",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
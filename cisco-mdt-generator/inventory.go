@@ -0,0 +1,49 @@
+package main
+
+import (
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// baseSoftwareVersion is the NX-OS release simulated before any upgrade
+const baseSoftwareVersion = "9.3(10)"
+
+// inventoryInterval is how often inventory telemetry is sent
+const inventoryInterval = 5 * time.Minute
+
+// Version returns the NX-OS release the node currently runs
+func (s *Simulator) Version() string {
+	if s.SoftwareVersion != "" {
+		return s.SoftwareVersion
+	}
+	return baseSoftwareVersion
+}
+
+// buildInventoryTelemetry reports the simulated software version together
+// with the simulator build and schema fingerprint, so recorded data shows
+// exactly which simulator generated it
+func buildInventoryTelemetry(ts uint64, nodeID, nxosVersion, fingerprint string) *telemetry.Telemetry {
+	row := telemetry.RowField(
+		[]*telemetry.TelemetryField{
+			telemetry.StringField("hostName", nodeID, ts),
+		},
+		[]*telemetry.TelemetryField{
+			telemetry.StringField("nxosVersion", nxosVersion, ts),
+			telemetry.StringField("simulatorVersion", version, ts),
+			telemetry.StringField("simulatorCommit", buildCommit(), ts),
+			telemetry.StringField("schemaFingerprint", fingerprint, ts),
+		},
+		ts,
+	)
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "inventory",
+		EncodingPath:        "Cisco-NX-OS-device:System/showversion-items",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           []*telemetry.TelemetryField{row},
+	}
+}
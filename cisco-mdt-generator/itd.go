@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"net"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// ITD node states, with the state codes of the probe results
+const (
+	itdNodeActive      = "ACTIVE"
+	itdNodeProbeFailed = "PROBE_FAILED"
+)
+
+// ITDService is an Intelligent Traffic Director service spreading the
+// traffic of a virtual IP over a device group of L4-L7 appliances, such as
+// firewalls or load balancers, by hashing flows into buckets
+type ITDService struct {
+	Name    string
+	VIP     string
+	Probe   string
+	PPS     uint64
+	PktSize uint64
+	Nodes   []*ITDNode
+	Buckets []*ITDBucket
+}
+
+// ITDNode is an appliance of a device group, health-checked by probes
+type ITDNode struct {
+	Address       string
+	State         string
+	StateCode     uint32 // 1 active, 2 probe failed
+	ProbesSent    uint64
+	ProbesFailed  uint64
+	Transitions   uint32
+	Packets       uint64 // received over the buckets assigned to the node
+	Bytes         uint64
+	Failed        bool // set while an itd_node_failure event fails the appliance
+	failedProbes  int  // consecutive failed probes
+	passedProbes  int  // consecutive passed probes while down
+	assignedCount uint32
+}
+
+// ITDBucket is a share of the flows hashed to one node. Buckets of a node
+// that fails its probes are reassigned to the active nodes and return once
+// it passes them again.
+type ITDBucket struct {
+	ID            uint32
+	Home          *ITDNode // node the bucket belongs to when every node is up
+	Node          *ITDNode // node the bucket is currently sent to
+	weight        float64  // share of the flows hashing into the bucket
+	Packets       uint64
+	Bytes         uint64
+	Dropped       uint64 // sent to a failed node before its buckets moved
+	Reassignments uint32
+}
+
+// initITDFromConfig creates the ITD services of the node, with buckets
+// spread round-robin over the nodes and hash imbalance drawn per bucket
+func initITDFromConfig(cfg *Config, rng *rand.Rand) []*ITDService {
+	if !cfg.ITD.Enabled {
+		return nil
+	}
+	var services []*ITDService
+	for _, sc := range cfg.ITD.Services {
+		svc := &ITDService{Name: sc.Name, VIP: sc.VIP, Probe: sc.Probe, PPS: sc.PPS, PktSize: sc.PacketSize}
+		if svc.Probe == "" {
+			svc.Probe = "icmp"
+		}
+		if svc.PktSize == 0 {
+			svc.PktSize = 800
+		}
+		for _, addr := range sc.Nodes {
+			svc.Nodes = append(svc.Nodes, &ITDNode{Address: addr, State: itdNodeActive, StateCode: 1})
+		}
+		buckets := sc.Buckets
+		if buckets == 0 {
+			buckets = 1 << bits.Len(uint(len(svc.Nodes)-1))
+		}
+		for i := range buckets {
+			home := svc.Nodes[i%len(svc.Nodes)]
+			svc.Buckets = append(svc.Buckets, &ITDBucket{
+				ID:     uint32(i + 1),
+				Home:   home,
+				Node:   home,
+				weight: 0.8 + rng.Float64()*0.4,
+			})
+		}
+		services = append(services, svc)
+	}
+	return services
+}
+
+// findITDNodes returns every ITD node with the given address
+func (s *Simulator) findITDNodes(address string) []*ITDNode {
+	var nodes []*ITDNode
+	for _, svc := range s.ITD {
+		for _, n := range svc.Nodes {
+			if n.Address == address {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes
+}
+
+// stepITD probes the nodes of every service, moves the buckets of nodes
+// that changed state and spreads the offered traffic over the buckets. A
+// failed node's buckets drop their traffic until the probes take it out.
+func (s *Simulator) stepITD(seconds float64) {
+	cfg := s.cfg.ITD
+	for _, svc := range s.ITD {
+		changed := false
+		for _, n := range svc.Nodes {
+			n.ProbesSent++
+			if n.Failed {
+				n.ProbesFailed++
+				n.failedProbes++
+				n.passedProbes = 0
+			} else {
+				n.failedProbes = 0
+				n.passedProbes++
+			}
+
+			switch {
+			case n.State == itdNodeActive && n.failedProbes >= cfg.RetryDown:
+				n.State, n.StateCode = itdNodeProbeFailed, 2
+				n.Transitions++
+				changed = true
+				s.event("itd_node_down", n.Address, "ITD service %s: node %s failed %d %s probes, reassigning its buckets",
+					svc.Name, n.Address, n.failedProbes, svc.Probe)
+			case n.State == itdNodeProbeFailed && n.passedProbes >= cfg.RetryUp:
+				n.State, n.StateCode = itdNodeActive, 1
+				n.Transitions++
+				changed = true
+				s.event("itd_node_up", n.Address, "ITD service %s: node %s passed %d %s probes, rebalancing its buckets back",
+					svc.Name, n.Address, n.passedProbes, svc.Probe)
+			}
+		}
+		if changed {
+			svc.assignBuckets()
+		}
+		svc.stepTraffic(seconds, s.rng)
+	}
+}
+
+// assignBuckets sends every bucket to its home node when it is active, and
+// the buckets of probe-failed nodes round-robin to the active nodes
+func (svc *ITDService) assignBuckets() {
+	var active []*ITDNode
+	for _, n := range svc.Nodes {
+		if n.State == itdNodeActive {
+			active = append(active, n)
+		}
+	}
+	next := 0
+	for _, b := range svc.Buckets {
+		target := b.Home
+		if b.Home.State != itdNodeActive && len(active) > 0 {
+			target = active[next%len(active)]
+			next++
+		}
+		if target != b.Node {
+			b.Node = target
+			b.Reassignments++
+		}
+	}
+}
+
+// stepTraffic spreads the offered packets of an interval over the buckets
+// by their hash share, with some jitter per interval
+func (svc *ITDService) stepTraffic(seconds float64, rng *rand.Rand) {
+	total := 0.0
+	for _, b := range svc.Buckets {
+		total += b.weight
+	}
+	for _, n := range svc.Nodes {
+		n.assignedCount = 0
+	}
+	for _, b := range svc.Buckets {
+		b.Node.assignedCount++
+		pkts := uint64(float64(svc.PPS) * seconds * b.weight / total * (0.95 + rng.Float64()*0.1))
+		if b.Node.Failed {
+			b.Dropped += pkts
+			continue
+		}
+		b.Packets += pkts
+		b.Bytes += pkts * svc.PktSize
+		b.Node.Packets += pkts
+		b.Node.Bytes += pkts * svc.PktSize
+	}
+}
+
+// StartITDNodeFailure fails an appliance: its probes fail from the next
+// interval on and ITD takes it out of service once retry_down probes failed
+func (s *Simulator) StartITDNodeFailure(address string) error {
+	nodes := s.findITDNodes(address)
+	if len(nodes) == 0 {
+		return fmt.Errorf("unknown ITD node %s", address)
+	}
+	for _, n := range nodes {
+		n.Failed = true
+	}
+	s.event("itd_node_failure", address, "ITD node %s FAILED, probes stop answering", address)
+	return nil
+}
+
+// StopITDNodeFailure repairs an appliance; ITD brings it back into service
+// once retry_up probes passed
+func (s *Simulator) StopITDNodeFailure(address string) error {
+	nodes := s.findITDNodes(address)
+	if len(nodes) == 0 {
+		return fmt.Errorf("unknown ITD node %s", address)
+	}
+	for _, n := range nodes {
+		n.Failed = false
+	}
+	s.event("itd_node_repaired", address, "ITD node %s REPAIRED, probes answer again", address)
+	return nil
+}
+
+// checkITDNodeTarget ensures an event targets a node of an ITD service
+func checkITDNodeTarget(s *Simulator, ev ScenarioEvent) error {
+	if len(s.findITDNodes(ev.Target)) == 0 {
+		return fmt.Errorf("unknown ITD node %q", ev.Target)
+	}
+	return nil
+}
+
+// itdNodeFailureAction is the itd_node_failure scenario action
+var itdNodeFailureAction = scenarioAction{
+	check: checkITDNodeTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.StartITDNodeFailure(ev.Target)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.StopITDNodeFailure(ev.Target)
+	},
+}
+
+// checkITD ensures the ITD services are usable
+func checkITD(cfg ITDConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if len(cfg.Services) == 0 {
+		return fmt.Errorf("at least one service must be configured")
+	}
+	if cfg.RetryDown < 1 || cfg.RetryUp < 1 {
+		return fmt.Errorf("retry_down and retry_up must be at least 1")
+	}
+	names := make(map[string]bool)
+	for _, svc := range cfg.Services {
+		if svc.Name == "" || names[svc.Name] {
+			return fmt.Errorf("services need unique names")
+		}
+		names[svc.Name] = true
+		if net.ParseIP(svc.VIP) == nil {
+			return fmt.Errorf("service %s: vip %q is not an IP address", svc.Name, svc.VIP)
+		}
+		if len(svc.Nodes) < 2 {
+			return fmt.Errorf("service %s: a device group needs at least two nodes", svc.Name)
+		}
+		for _, addr := range svc.Nodes {
+			if net.ParseIP(addr) == nil {
+				return fmt.Errorf("service %s: node %q is not an IP address", svc.Name, addr)
+			}
+		}
+		if b := svc.Buckets; b != 0 && (b < len(svc.Nodes) || b > 256 || b&(b-1) != 0) {
+			return fmt.Errorf("service %s: buckets must be a power of two from the node count up to 256", svc.Name)
+		}
+		switch svc.Probe {
+		case "", "icmp", "tcp", "udp", "http", "dns":
+		default:
+			return fmt.Errorf("service %s: probe must be icmp, tcp, udp, http or dns", svc.Name)
+		}
+	}
+	return nil
+}
+
+// buildITDNodeTelemetry reports the health and load of every ITD node
+func buildITDNodeTelemetry(ts uint64, nodeID string, services []*ITDService) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, svc := range services {
+		for _, n := range svc.Nodes {
+			row := telemetry.RowField(
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("service-name", svc.Name, ts),
+					telemetry.StringField("node-address", n.Address, ts),
+				},
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("vip", svc.VIP, ts),
+					telemetry.StringField("state", n.State, ts),
+					telemetry.Uint32Field("state-code", n.StateCode, ts),
+					telemetry.StringField("probe-type", svc.Probe, ts),
+					telemetry.Uint64Field("probes-sent", n.ProbesSent, ts),
+					telemetry.Uint64Field("probes-failed", n.ProbesFailed, ts),
+					telemetry.Uint32Field("state-transitions", n.Transitions, ts),
+					telemetry.Uint32Field("buckets", n.assignedCount, ts),
+					telemetry.Uint64Field("packets", n.Packets, ts),
+					telemetry.Uint64Field("bytes", n.Bytes, ts),
+				},
+				ts,
+			)
+			rows = append(rows, row)
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "itd_nodes",
+		EncodingPath:        "Cisco-NX-OS-device:System/itd-items/service-items/Service-list/node-items/Node-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildITDBucketTelemetry reports the traffic distribution over the buckets
+// of every ITD service
+func buildITDBucketTelemetry(ts uint64, nodeID string, services []*ITDService) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, svc := range services {
+		for _, b := range svc.Buckets {
+			row := telemetry.RowField(
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("service-name", svc.Name, ts),
+					telemetry.Uint32Field("bucket-id", b.ID, ts),
+				},
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("node-address", b.Node.Address, ts),
+					telemetry.StringField("home-node-address", b.Home.Address, ts),
+					telemetry.Uint64Field("packets", b.Packets, ts),
+					telemetry.Uint64Field("bytes", b.Bytes, ts),
+					telemetry.Uint64Field("dropped-packets", b.Dropped, ts),
+					telemetry.Uint32Field("reassignments", b.Reassignments, ts),
+				},
+				ts,
+			)
+			rows = append(rows, row)
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "itd_buckets",
+		EncodingPath:        "Cisco-NX-OS-device:System/itd-items/service-items/Service-list/bucket-items/Bucket-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
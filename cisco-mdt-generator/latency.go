@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// sendBuckets are the upper bounds in seconds of the send latency histogram
+var sendBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// sendHistogram counts the wall time of the Send calls of one node
+type sendHistogram struct {
+	counts []uint64 // per bucket of sendBuckets, not cumulative
+	count  uint64
+	sum    float64 // seconds
+}
+
+// observe counts one send
+func (h *sendHistogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(sendBuckets))
+	}
+	seconds := d.Seconds()
+	for i, le := range sendBuckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// writeProm writes the histogram of a node in the Prometheus text format
+func (h *sendHistogram) writeProm(w io.Writer, node string) {
+	var cumulative uint64
+	for i, le := range sendBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(w, "mdtsim_send_duration_seconds_bucket{node=%q,le=%q} %d\n", node, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "mdtsim_send_duration_seconds_bucket{node=%q,le=\"+Inf\"} %d\n", node, h.count)
+	fmt.Fprintf(w, "mdtsim_send_duration_seconds_sum{node=%q} %g\n", node, h.sum)
+	fmt.Fprintf(w, "mdtsim_send_duration_seconds_count{node=%q} %d\n", node, h.count)
+}
+
+// warnSlowSend logs a send to the collector that took at least threshold, as
+// key=value pairs for log pipelines to pick up
+func warnSlowSend(nodeID, subscription string, bytes int, latency, threshold time.Duration) {
+	if threshold <= 0 || latency < threshold {
+		return
+	}
+	log.Printf("level=warn msg=\"slow send\" node=%s subscription=%s bytes=%d duration=%s threshold=%s",
+		nodeID, subscription, bytes, latency.Round(time.Microsecond), threshold)
+}
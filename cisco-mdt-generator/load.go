@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Shapes of a load profile
+const (
+	loadLinear   = "linear"   // ramp from base to peak over duration, then hold
+	loadStep     = "step"     // the same ramp in steps
+	loadSpike    = "spike"    // base with a spike to peak at the end of every duration
+	loadSawtooth = "sawtooth" // ramp from base to peak every duration
+)
+
+// minLoadInterval bounds how short a load profile makes the interval
+const minLoadInterval = 10 * time.Millisecond
+
+// Rate returns the message rate of the profile after elapsed, as a multiple
+// of the rate of the configured interval. Without a shape it is always 1.
+func (p LoadProfileConfig) Rate(elapsed time.Duration) float64 {
+	if p.Shape == "" || p.Duration <= 0 {
+		return 1
+	}
+	frac := elapsed.Seconds() / p.Duration.Seconds()
+	switch p.Shape {
+	case loadLinear:
+		return p.Base + (p.Peak-p.Base)*math.Min(frac, 1)
+	case loadStep:
+		step := math.Min(math.Floor(frac*float64(p.Steps)), float64(p.Steps))
+		return p.Base + (p.Peak-p.Base)*step/float64(p.Steps)
+	case loadSpike:
+		if elapsed%p.Duration >= p.Duration-p.Width {
+			return p.Peak
+		}
+		return p.Base
+	case loadSawtooth:
+		return p.Base + (p.Peak-p.Base)*(frac-math.Floor(frac))
+	}
+	return 1
+}
+
+// loadInterval returns the interval that sends at rate times the rate of
+// interval, no shorter than minLoadInterval. A rate that is not positive
+// keeps the interval.
+func loadInterval(interval time.Duration, rate float64) time.Duration {
+	if !(rate > 0) {
+		return max(interval, minLoadInterval)
+	}
+	return max(time.Duration(float64(interval)/rate), minLoadInterval)
+}
+
+// checkLoadProfile ensures the load profile settings are usable
+func checkLoadProfile(p LoadProfileConfig) error {
+	switch p.Shape {
+	case "":
+		return nil
+	case loadLinear, loadStep, loadSpike, loadSawtooth:
+	default:
+		return fmt.Errorf("shape must be %s, %s, %s or %s", loadLinear, loadStep, loadSpike, loadSawtooth)
+	}
+	if p.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if p.Base <= 0 || p.Peak <= 0 {
+		return fmt.Errorf("base and peak must be positive")
+	}
+	if p.Shape == loadStep && p.Steps < 1 {
+		return fmt.Errorf("steps must be at least 1")
+	}
+	if p.Shape == loadSpike && (p.Width <= 0 || p.Width >= p.Duration) {
+		return fmt.Errorf("width must be positive and shorter than duration")
+	}
+	return nil
+}
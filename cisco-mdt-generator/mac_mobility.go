@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// NX-OS duplicate host detection defaults: 5 moves within 180 seconds
+const (
+	macDupMaxMoves = 5
+	macDupWindow   = 180 * time.Second
+)
+
+// MACMobilityEntry tracks a MAC address moving between remote VTEPs
+type MACMobilityEntry struct {
+	VNIID       uint32
+	MAC         string
+	VTEPs       []string
+	CurrentIdx  int
+	Moves       uint32
+	Duplicate   bool
+	recentMoves []time.Time
+}
+
+// CurrentVTEP returns the VTEP the MAC is currently learned behind
+func (e *MACMobilityEntry) CurrentVTEP() string {
+	return e.VTEPs[e.CurrentIdx]
+}
+
+// StartMACFlap starts moving a MAC back and forth between VTEPs in a VNI,
+// emulating a duplicate host or a layer-2 loop behind two leafs
+func (s *Simulator) StartMACFlap(vniID uint32, mac string, vteps []string) error {
+	if s.FindVNI(vniID) == nil {
+		return fmt.Errorf("unknown VNI %d", vniID)
+	}
+	if len(vteps) < 2 {
+		return fmt.Errorf("MAC flap needs at least two VTEPs, got %d", len(vteps))
+	}
+
+	s.MACMobility = append(s.MACMobility, &MACMobilityEntry{
+		VNIID: vniID,
+		MAC:   mac,
+		VTEPs: vteps,
+	})
+	s.event("mac_flap_start", fmt.Sprint(vniID), "MAC %s started flapping in VNI %d between %v", mac, vniID, vteps)
+	return nil
+}
+
+// StopMACFlap stops the flapping MAC and clears its mobility entry, as if the
+// duplicate host was removed and the entry cleared by the operator
+func (s *Simulator) StopMACFlap(vniID uint32, mac string) {
+	kept := s.MACMobility[:0]
+	for _, e := range s.MACMobility {
+		if e.VNIID == vniID && e.MAC == mac {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	s.MACMobility = kept
+	s.event("mac_flap_end", fmt.Sprint(vniID), "MAC %s stopped flapping in VNI %d", mac, vniID)
+}
+
+// stepMACMobility moves each flapping MAC to its next VTEP. Every move
+// withdraws and re-advertises the EVPN type-2 route until duplicate
+// detection freezes the MAC.
+func (s *Simulator) stepMACMobility(now time.Time) {
+	for _, e := range s.MACMobility {
+		if e.Duplicate {
+			continue
+		}
+
+		e.CurrentIdx = (e.CurrentIdx + 1) % len(e.VTEPs)
+		e.Moves++
+		s.EVPN.Type2Updates += 2 // withdraw + advertise
+		if vni := s.FindVNI(e.VNIID); vni != nil {
+			vni.MACMoves++
+		}
+
+		// Keep only moves inside the detection window
+		e.recentMoves = append(e.recentMoves, now)
+		for len(e.recentMoves) > 0 && now.Sub(e.recentMoves[0]) > macDupWindow {
+			e.recentMoves = e.recentMoves[1:]
+		}
+
+		if len(e.recentMoves) >= macDupMaxMoves {
+			e.Duplicate = true
+			s.Syslog.Emit(now, SeverityCritical, "L2RIB", "L2RIB_MAC_DUP_DETECTED",
+				fmt.Sprintf("Detected duplicate host %s, topology %d, during remote update, with host located at remote VTEP %s, %d moves in %d seconds - l2rib",
+					e.MAC, e.VNIID, e.CurrentVTEP(), len(e.recentMoves), int(macDupWindow.Seconds())))
+			s.Events.Publish(SimEvent{Time: now, Type: "mac_duplicate", Target: fmt.Sprint(e.VNIID),
+				Detail: fmt.Sprintf("MAC %s frozen as duplicate after %d moves", e.MAC, len(e.recentMoves))})
+		}
+	}
+}
+
+// buildMACMobilityTelemetry reports MAC mobility and duplicate detection state
+func buildMACMobilityTelemetry(ts uint64, nodeID string, entries []*MACMobilityEntry) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, e := range entries {
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.Uint32Field("vni-id", e.VNIID, ts),
+				telemetry.StringField("mac-address", e.MAC, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("remote-vtep", e.CurrentVTEP(), ts),
+				telemetry.Uint32Field("mac-moves", e.Moves, ts),
+				telemetry.BoolField("duplicate-detected", e.Duplicate, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "mac_mobility",
+		EncodingPath:        "Cisco-NX-OS-device:System/l2rib-items/inst-items/mac-items/Mac-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// parseVNITarget parses a scenario target as a VNI ID
+func parseVNITarget(target string) (uint32, error) {
+	id, err := strconv.ParseUint(target, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid VNI %q", target)
+	}
+	return uint32(id), nil
+}
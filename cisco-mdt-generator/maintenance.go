@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// FlapNeighbor drops an established BGP session to Idle now. It recovers
+// after the configured recovery time like a random flap.
+func (s *Simulator) FlapNeighbor(address string, now time.Time) error {
+	neighbor := s.FindNeighbor(address)
+	if neighbor == nil {
+		return fmt.Errorf("unknown BGP neighbor %s", address)
+	}
+	if neighbor.State != "Established" || neighbor.Maintenance {
+		return fmt.Errorf("BGP neighbor %s is not Established", address)
+	}
+	s.flap(neighbor, now)
+	return nil
+}
+
+// flap drops a session to Idle and counts the flap
+func (s *Simulator) flap(neighbor *BGPNeighbor, now time.Time) {
+	neighbor.State = "Idle"
+	neighbor.StateCode = 1
+	neighbor.PrefixesRecv = 0
+	neighbor.Uptime = 0
+	neighbor.FlapCount++
+	neighbor.LastFlap = now
+	s.event("bgp_flap", neighbor.Address, "BGP neighbor %s FLAPPED to Idle (flap #%d)", neighbor.Address, neighbor.FlapCount)
+}
+
+// StartSpineMaintenance emulates a spine entering maintenance mode with BGP
+// graceful shutdown: the session stays up while the spine withdraws its
+// routes, then the spine shuts the session administratively
+func (s *Simulator) StartSpineMaintenance(address string, now time.Time) error {
+	neighbor := s.FindNeighbor(address)
+	if neighbor == nil {
+		return fmt.Errorf("unknown BGP neighbor %s", address)
+	}
+	if neighbor.Maintenance {
+		return nil
+	}
+
+	neighbor.Maintenance = true
+	neighbor.SavedPrefixesRecv = neighbor.PrefixesRecv
+	s.event("maintenance_start", neighbor.Address, "BGP neighbor %s entering maintenance (graceful shutdown), draining %d prefixes",
+		neighbor.Address, neighbor.PrefixesRecv)
+	return nil
+}
+
+// EndSpineMaintenance brings a spine back from maintenance mode and restores
+// the session with the prefixes it advertised before the drain
+func (s *Simulator) EndSpineMaintenance(address string, now time.Time) error {
+	neighbor := s.FindNeighbor(address)
+	if neighbor == nil {
+		return fmt.Errorf("unknown BGP neighbor %s", address)
+	}
+	if !neighbor.Maintenance {
+		return nil
+	}
+
+	neighbor.Maintenance = false
+	neighbor.State = "Established"
+	neighbor.StateCode = 6
+	neighbor.PrefixesRecv = neighbor.SavedPrefixesRecv
+	neighbor.LastFlap = now
+	s.event("maintenance_end", neighbor.Address, "BGP neighbor %s leaving maintenance, RESTORED to Established", neighbor.Address)
+	return nil
+}
+
+// stepMaintenance drains a neighbor in maintenance by halving its received
+// prefixes each interval and shuts the session once nothing is left
+func (s *Simulator) stepMaintenance(neighbor *BGPNeighbor, now time.Time) {
+	if neighbor.State != "Established" {
+		return
+	}
+
+	neighbor.Uptime = uint64(now.Sub(neighbor.LastFlap).Seconds())
+	neighbor.PrefixesRecv /= 2
+
+	if neighbor.PrefixesRecv == 0 {
+		// Admin shutdown is not a flap, so FlapCount is left untouched
+		neighbor.State = "Shut (Admin)"
+		neighbor.StateCode = 1
+		neighbor.Uptime = 0
+		neighbor.LastFlap = now
+		s.event("maintenance_drained", neighbor.Address, "BGP neighbor %s drained, session administratively shut down", neighbor.Address)
+	}
+}
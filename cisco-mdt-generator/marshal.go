@@ -0,0 +1,88 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Telemetry encodings of the dial-out streams
+const (
+	encodingGPBKV   = "gpbkv"
+	encodingCompact = "gpb-compact"
+	encodingJSON    = "json"
+)
+
+// compactSchema numbers the fields of compact GPB messages, shared by every
+// node of the process so their streams agree
+var compactSchema = telemetry.NewCompactSchema()
+
+// encodeTelemetry encodes a message in one of the encodings, GPB-KV when
+// empty
+func encodeTelemetry(encoding string, m *telemetry.Telemetry) ([]byte, error) {
+	switch encoding {
+	case encodingCompact:
+		return compactSchema.Marshal(m)
+	case encodingJSON:
+		return m.EncodeJSON()
+	}
+	return m.Marshal()
+}
+
+// encodedSize returns the bytes a message takes in one of the encodings,
+// computed without encoding it for GPB-KV. A message that cannot be encoded
+// takes none.
+func encodedSize(encoding string, m *telemetry.Telemetry) int {
+	if encoding == "" || encoding == encodingGPBKV {
+		return m.Size()
+	}
+	payload, _ := encodeTelemetry(encoding, m)
+	return len(payload)
+}
+
+// MarshalPool encodes the collections of every node of the process on a
+// bounded number of workers, so hundreds of nodes whose intervals line up
+// share the cores instead of all encoding at once. Each collection is
+// encoded in parallel and returned in message order, which keeps the order
+// of every stream.
+type MarshalPool struct {
+	workers chan struct{}
+}
+
+// NewMarshalPool creates a pool of workers encoders, GOMAXPROCS when 0
+func NewMarshalPool(workers int) *MarshalPool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &MarshalPool{workers: make(chan struct{}, workers)}
+}
+
+// Marshal encodes the messages of a collection in an encoding and returns the payloads in
+// the order of the messages, with nil and the error of a message that could
+// not be encoded. A nil pool encodes the messages one after another.
+func (p *MarshalPool) Marshal(messages []*telemetry.Telemetry, encoding string) ([][]byte, []error) {
+	payloads := make([][]byte, len(messages))
+	errs := make([]error, len(messages))
+	if p == nil || len(messages) < 2 {
+		for i, m := range messages {
+			payloads[i], errs[i] = encodeTelemetry(encoding, m)
+		}
+		return payloads, errs
+	}
+
+	var wg sync.WaitGroup
+	for i, m := range messages {
+		p.workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-p.workers
+				wg.Done()
+			}()
+			payloads[i], errs[i] = encodeTelemetry(encoding, m)
+		}()
+	}
+	wg.Wait()
+	return payloads, errs
+}
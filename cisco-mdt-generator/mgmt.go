@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Management plane traffic model
+const (
+	mgmtSessionPPS    = 20 // packets per second of an open SSH session
+	mgmtPktsPerLogin  = 12 // packets of a login attempt up to authentication
+	mgmtSynsPerProbe  = 3  // SYN retransmissions of an attempt the ACL drops
+	defaultBruteForce = 60 // attempts per minute of an ssh_brute_force event
+)
+
+// MgmtState is the SSH management plane of the node: open sessions,
+// logins, failed logins and what the management ACL drops
+type MgmtState struct {
+	Active           uint32
+	Logins           uint64
+	FailedLogins     uint64
+	SessionsRejected uint64 // logins refused with max_sessions open
+
+	ACLPermitted uint64
+	ACLDenied    uint64
+
+	// Attackers are the sources of active or past brute-force attempts
+	Attackers []*MgmtAttacker
+
+	loginCredit  float64
+	deniedCredit float64
+}
+
+// MgmtAttacker is a source address trying passwords over SSH
+type MgmtAttacker struct {
+	Source       string
+	User         string
+	Rate         float64 // attempts per minute, 0 once the attempt ended
+	Blocked      bool    // dropped by the management ACL before reaching sshd
+	FailedLogins uint64
+	ACLDrops     uint64
+	LastLog      time.Time
+
+	credit float64
+}
+
+// initMgmtFromConfig creates the management plane with its usual sessions open
+func initMgmtFromConfig(cfg *Config) *MgmtState {
+	if !cfg.Management.Enabled {
+		return nil
+	}
+	return &MgmtState{Active: cfg.Management.Sessions}
+}
+
+// findAttacker returns the brute-force source with the given address, or nil
+func (m *MgmtState) findAttacker(source string) *MgmtAttacker {
+	for _, a := range m.Attackers {
+		if a.Source == source {
+			return a
+		}
+	}
+	return nil
+}
+
+// stepMgmt opens and closes sessions, counts management ACL hits and runs
+// the brute-force attempts. Sessions last long enough for sessions to stay
+// open on average at logins_per_minute.
+func (s *Simulator) stepMgmt(now time.Time, seconds float64) {
+	m := s.Mgmt
+	if m == nil {
+		return
+	}
+	cfg := s.cfg.Management
+
+	// Open sessions end at the rate logins replace them
+	if cfg.Sessions > 0 {
+		closing := cfg.LoginsPerMinute / 60 / float64(cfg.Sessions) * seconds
+		for range m.Active {
+			if s.rng.Float64() < closing {
+				m.Active--
+			}
+		}
+	}
+	m.loginCredit += cfg.LoginsPerMinute * seconds / 60
+	for ; m.loginCredit >= 1; m.loginCredit-- {
+		m.ACLPermitted += mgmtPktsPerLogin
+		if s.rng.Float64()*100 < cfg.FailurePercent {
+			// A mistyped password, retried right away
+			m.FailedLogins++
+			m.ACLPermitted += mgmtPktsPerLogin
+			if s.AAA != nil {
+				s.authenticate(now, true)
+			}
+		}
+		if s.AAA != nil {
+			s.authenticate(now, false)
+		}
+		m.Logins++
+		if m.Active >= cfg.MaxSessions {
+			m.SessionsRejected++
+			continue
+		}
+		m.Active++
+	}
+	m.ACLPermitted += uint64(float64(m.Active*mgmtSessionPPS) * seconds)
+
+	// Background scans of the management port from outside the ACL
+	m.deniedCredit += cfg.ACLDeniedPPS * seconds
+	denied := uint64(m.deniedCredit)
+	m.deniedCredit -= float64(denied)
+	m.ACLDenied += denied
+
+	for _, a := range m.Attackers {
+		a.credit += a.Rate * seconds / 60
+		attempts := uint64(a.credit)
+		a.credit -= float64(attempts)
+		if attempts == 0 {
+			continue
+		}
+		if a.Blocked {
+			a.ACLDrops += attempts * mgmtSynsPerProbe
+			m.ACLDenied += attempts * mgmtSynsPerProbe
+			continue
+		}
+		a.FailedLogins += attempts
+		m.FailedLogins += attempts
+		m.ACLPermitted += attempts * mgmtPktsPerLogin
+		for range attempts {
+			if s.AAA != nil {
+				s.authenticate(now, true)
+			}
+		}
+		if now.Sub(a.LastLog) >= time.Minute {
+			a.LastLog = now
+			s.Syslog.Emit(now, SeverityError, "AUTHPRIV", "SYSTEM_MSG",
+				fmt.Sprintf("error: PAM: Authentication failure for illegal user %s from %s - dcos_sshd", a.User, a.Source))
+		}
+	}
+}
+
+// StartBruteForce starts password guessing over SSH from a source address.
+// A blocked source is dropped by the management ACL instead.
+func (s *Simulator) StartBruteForce(source, user string, rate float64, blocked bool) error {
+	m := s.Mgmt
+	if m == nil {
+		return fmt.Errorf("management plane not enabled")
+	}
+	a := m.findAttacker(source)
+	if a == nil {
+		a = &MgmtAttacker{Source: source}
+		m.Attackers = append(m.Attackers, a)
+	}
+	a.User, a.Rate, a.Blocked = user, rate, blocked
+	if blocked {
+		s.event("ssh_brute_force", source, "SSH brute force from %s at %.0f attempts/min, dropped by the management ACL", source, rate)
+	} else {
+		s.event("ssh_brute_force", source, "SSH brute force from %s as %s at %.0f attempts/min", source, user, rate)
+	}
+	return nil
+}
+
+// StopBruteForce stops the attempts of a source. Its counters remain.
+func (s *Simulator) StopBruteForce(source string) error {
+	if s.Mgmt == nil {
+		return fmt.Errorf("management plane not enabled")
+	}
+	a := s.Mgmt.findAttacker(source)
+	if a == nil {
+		return fmt.Errorf("no brute force from %s", source)
+	}
+	a.Rate = 0
+	a.credit = 0
+	s.event("ssh_brute_force_end", source, "SSH brute force from %s stopped after %d failed logins", source, a.FailedLogins)
+	return nil
+}
+
+// parseBruteForceParams returns the user, rate and blocked params of an
+// ssh_brute_force event
+func parseBruteForceParams(ev ScenarioEvent) (string, float64, bool, error) {
+	rate, err := strconv.ParseFloat(ev.Param("rate", strconv.Itoa(defaultBruteForce)), 64)
+	if err != nil || rate <= 0 {
+		return "", 0, false, fmt.Errorf("rate must be a positive number of attempts per minute")
+	}
+	blocked, err := strconv.ParseBool(ev.Param("blocked", "false"))
+	if err != nil {
+		return "", 0, false, fmt.Errorf("blocked must be true or false")
+	}
+	return ev.Param("user", "admin"), rate, blocked, nil
+}
+
+// checkBruteForce ensures an ssh_brute_force event has a source address,
+// usable params and a management plane to attack
+func checkBruteForce(s *Simulator, ev ScenarioEvent) error {
+	if s.Mgmt == nil {
+		return fmt.Errorf("management plane not enabled")
+	}
+	if net.ParseIP(ev.Target) == nil {
+		return fmt.Errorf("source %q is not an IP address", ev.Target)
+	}
+	_, _, _, err := parseBruteForceParams(ev)
+	return err
+}
+
+// sshBruteForceAction is the ssh_brute_force scenario action
+var sshBruteForceAction = scenarioAction{
+	check: checkBruteForce,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		user, rate, blocked, err := parseBruteForceParams(ev)
+		if err != nil {
+			return err
+		}
+		return s.StartBruteForce(ev.Target, user, rate, blocked)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.StopBruteForce(ev.Target)
+	},
+}
+
+// checkManagement ensures the management plane settings are usable
+func checkManagement(cfg ManagementConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxSessions == 0 || cfg.Sessions > cfg.MaxSessions {
+		return fmt.Errorf("max_sessions must be positive and at least sessions")
+	}
+	if cfg.LoginsPerMinute < 0 || cfg.ACLDeniedPPS < 0 || cfg.FailurePercent < 0 || cfg.FailurePercent > 100 {
+		return fmt.Errorf("logins_per_minute and acl_denied_pps must be non-negative and failure_percent between 0 and 100")
+	}
+	return nil
+}
+
+// buildMgmtSessionTelemetry reports the SSH sessions and logins of the node
+func buildMgmtSessionTelemetry(ts uint64, nodeID string, m *MgmtState, maxSessions uint32) *telemetry.Telemetry {
+	row := telemetry.RowField(
+		[]*telemetry.TelemetryField{
+			telemetry.StringField("protocol", "ssh", ts),
+		},
+		[]*telemetry.TelemetryField{
+			telemetry.Uint32Field("active-sessions", m.Active, ts),
+			telemetry.Uint32Field("max-sessions", maxSessions, ts),
+			telemetry.Uint64Field("logins", m.Logins, ts),
+			telemetry.Uint64Field("failed-logins", m.FailedLogins, ts),
+			telemetry.Uint64Field("sessions-rejected", m.SessionsRejected, ts),
+		},
+		ts,
+	)
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "mgmt_sessions",
+		EncodingPath:        "Cisco-NX-OS-device:System/mgmt-items/ssh-items",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           []*telemetry.TelemetryField{row},
+	}
+}
+
+// buildMgmtACLTelemetry reports the hits of the management ACL
+func buildMgmtACLTelemetry(ts uint64, nodeID string, m *MgmtState, acl string) *telemetry.Telemetry {
+	row := telemetry.RowField(
+		[]*telemetry.TelemetryField{
+			telemetry.StringField("acl-name", acl, ts),
+		},
+		[]*telemetry.TelemetryField{
+			telemetry.Uint64Field("permitted-packets", m.ACLPermitted, ts),
+			telemetry.Uint64Field("denied-packets", m.ACLDenied, ts),
+		},
+		ts,
+	)
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "mgmt_acl",
+		EncodingPath:        "Cisco-NX-OS-device:System/acl-items/ipv4-items/name-items/ACL-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           []*telemetry.TelemetryField{row},
+	}
+}
+
+// buildMgmtLoginFailureTelemetry reports every source that tried to guess
+// passwords
+func buildMgmtLoginFailureTelemetry(ts uint64, nodeID string, attackers []*MgmtAttacker) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, a := range attackers {
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("source-address", a.Source, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("user", a.User, ts),
+				telemetry.BoolField("active", a.Rate > 0, ts),
+				telemetry.BoolField("acl-blocked", a.Blocked, ts),
+				telemetry.Uint64Field("failed-logins", a.FailedLogins, ts),
+				telemetry.Uint64Field("acl-drops", a.ACLDrops, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "mgmt_login_failures",
+		EncodingPath:        "Cisco-NX-OS-device:System/mgmt-items/ssh-items/loginfail-items/Source-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Middleware stage types
+const (
+	middlewareDelay         = "delay"
+	middlewareDrop          = "drop"
+	middlewareDuplicate     = "duplicate"
+	middlewareBandwidth     = "bandwidth"
+	middlewareSparseRows    = "sparse_rows"
+	middlewareMalformedRows = "malformed_rows"
+	middlewareStringFuzz    = "string_fuzz"
+)
+
+// middlewareTypes lists every middleware stage type
+var middlewareTypes = []string{
+	middlewareDelay, middlewareDrop, middlewareDuplicate, middlewareBandwidth,
+	middlewareSparseRows, middlewareMalformedRows, middlewareStringFuzz,
+}
+
+// outputNames returns the outputs a middleware stage can be applied to: the
+// dial-out stream, every sink and the gNMI server
+func outputNames() []string {
+	names := []string{"collector"}
+	for _, t := range sinkTypes {
+		names = append(names, t.name)
+	}
+	return append(names, gnmiOutput)
+}
+
+// checkMiddleware ensures every middleware stage is complete
+func checkMiddleware(stages []SinkMiddlewareConfig) error {
+	for i, st := range stages {
+		if !slices.Contains(middlewareTypes, st.Type) {
+			return fmt.Errorf("stage %d: unknown type %q", i+1, st.Type)
+		}
+		if st.Percent < 0 || st.Percent > 100 {
+			return fmt.Errorf("stage %d: percent must be between 0 and 100", i+1)
+		}
+		if st.Delay < 0 || st.Jitter < 0 {
+			return fmt.Errorf("stage %d: delay and jitter must not be negative", i+1)
+		}
+		if st.Type == middlewareBandwidth && st.BytesPerSecond <= 0 {
+			return fmt.Errorf("stage %d: bandwidth needs a positive bytes_per_second", i+1)
+		}
+		if err := checkMalformedModes(st.Modes); err != nil {
+			return fmt.Errorf("stage %d: %w", i+1, err)
+		}
+		for _, name := range st.Apply {
+			if !slices.Contains(outputNames(), name) {
+				return fmt.Errorf("stage %d: unknown output %q, must be one of %s", i+1, name, strings.Join(outputNames(), ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// middlewareSink replaces the Write of the sink it wraps
+type middlewareSink struct {
+	Sink
+	write func(messages []*telemetry.Telemetry) error
+}
+
+func (m middlewareSink) Write(messages []*telemetry.Telemetry) error {
+	return m.write(messages)
+}
+
+// Middleware applies the middleware chain of a run to its outputs. A run
+// builds one and shares it with every node, so a bandwidth stage keeps one
+// bucket per output name, whichever node or stream writes to the output and
+// however often it reconnects.
+type Middleware struct {
+	stages     []SinkMiddlewareConfig
+	priorities Priorities
+
+	mu   sync.Mutex
+	caps map[capKey]*bandwidthCap
+}
+
+// capKey identifies the bucket of a bandwidth stage on an output
+type capKey struct {
+	stage  int
+	output string
+}
+
+// NewMiddleware creates the middleware chain of a run
+func NewMiddleware(stages []SinkMiddlewareConfig, priorities Priorities) *Middleware {
+	return &Middleware{stages: stages, priorities: priorities, caps: make(map[capKey]*bandwidthCap)}
+}
+
+// payloadSizer is implemented by outputs that can tell the bytes a message
+// takes in the encoding they send. Other outputs are charged its GPB-KV size.
+type payloadSizer interface {
+	PayloadSize(m *telemetry.Telemetry) int
+}
+
+// Wrap applies the chain to an output. The first stage sees messages first;
+// stages whose apply list omits the output are skipped.
+func (mw *Middleware) Wrap(s Sink) Sink {
+	name := s.Name()
+	size := (*telemetry.Telemetry).Size
+	if sizer, ok := s.(payloadSizer); ok {
+		size = sizer.PayloadSize
+	}
+	for i := len(mw.stages) - 1; i >= 0; i-- {
+		st := mw.stages[i]
+		if len(st.Apply) > 0 && !slices.Contains(st.Apply, name) {
+			continue
+		}
+		if st.Type == middlewareBandwidth {
+			s = middlewareSink{Sink: s, write: mw.bandwidthCap(i, name).limit(s, size)}
+			continue
+		}
+		s = newMiddleware(st, s)
+	}
+	return s
+}
+
+// bandwidthCap returns the bucket of a bandwidth stage on an output,
+// creating it on first use
+func (mw *Middleware) bandwidthCap(stage int, output string) *bandwidthCap {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	key := capKey{stage, output}
+	b, ok := mw.caps[key]
+	if !ok {
+		b = newBandwidthCap(mw.stages[stage], output, mw.priorities)
+		mw.caps[key] = b
+	}
+	return b
+}
+
+// newMiddleware wraps next in one stage other than bandwidth. Outputs can be
+// shared by several nodes, so the stage draws from its own random source
+// under a lock.
+func newMiddleware(st SinkMiddlewareConfig, next Sink) Sink {
+	var mu sync.Mutex
+	rng := newRand(0, st.Type)
+	random := func(draw func(rng *rand.Rand)) {
+		mu.Lock()
+		defer mu.Unlock()
+		draw(rng)
+	}
+
+	var write func(messages []*telemetry.Telemetry) error
+	switch st.Type {
+	case middlewareDelay:
+		write = func(messages []*telemetry.Telemetry) error {
+			d := st.Delay
+			if st.Jitter > 0 {
+				random(func(rng *rand.Rand) { d += time.Duration(rng.Int63n(int64(st.Jitter))) })
+			}
+			time.Sleep(d)
+			return next.Write(messages)
+		}
+	case middlewareDrop:
+		write = func(messages []*telemetry.Telemetry) error {
+			var kept []*telemetry.Telemetry
+			random(func(rng *rand.Rand) {
+				for _, m := range messages {
+					if rng.Float64()*100 >= st.Percent {
+						kept = append(kept, m)
+					}
+				}
+			})
+			return next.Write(kept)
+		}
+	case middlewareDuplicate:
+		write = func(messages []*telemetry.Telemetry) error {
+			var out []*telemetry.Telemetry
+			random(func(rng *rand.Rand) {
+				for _, m := range messages {
+					out = append(out, m)
+					if rng.Float64()*100 < st.Percent {
+						out = append(out, m)
+					}
+				}
+			})
+			return next.Write(out)
+		}
+	default:
+		// Row faults change messages in place, so they work on a copy that
+		// other outputs do not see
+		apply := map[string]func([]*telemetry.Telemetry, *rand.Rand){
+			middlewareSparseRows: func(ms []*telemetry.Telemetry, rng *rand.Rand) { omitRows(ms, st.Percent, rng) },
+			middlewareMalformedRows: func(ms []*telemetry.Telemetry, rng *rand.Rand) {
+				modes := st.Modes
+				if len(modes) == 0 {
+					modes = malformedModes
+				}
+				malformRows(ms, st.Percent, modes, rng)
+			},
+			middlewareStringFuzz: func(ms []*telemetry.Telemetry, rng *rand.Rand) {
+				fuzzStringFields(ms, st.Percent, st.Fields, rng)
+			},
+		}[st.Type]
+		write = func(messages []*telemetry.Telemetry) error {
+			copies := make([]*telemetry.Telemetry, len(messages))
+			for i, m := range messages {
+				copies[i] = m.Clone()
+			}
+			random(func(rng *rand.Rand) { apply(copies, rng) })
+			return next.Write(copies)
+		}
+	}
+	return middlewareSink{Sink: next, write: write}
+}
+
+// bandwidthCap polices an output with a token bucket: messages that do not
+// fit in the bucket are dropped, like a device exceeding its telemetry rate
+// limit. Higher priority classes are admitted first. The bucket is shared
+// by every node writing to the output.
+type bandwidthCap struct {
+	output     string
+	rate       float64 // bytes per second
+	burst      float64
+	priorities Priorities
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	dropped classDrops
+}
+
+func newBandwidthCap(st SinkMiddlewareConfig, output string, priorities Priorities) *bandwidthCap {
+	burst := float64(st.Burst)
+	if burst <= 0 {
+		burst = float64(st.BytesPerSecond)
+	}
+	return &bandwidthCap{
+		output:     output,
+		rate:       float64(st.BytesPerSecond),
+		burst:      burst,
+		priorities: priorities,
+		tokens:     burst,
+		dropped:    make(classDrops),
+	}
+}
+
+// limit returns the write function passing the messages that fit on to
+// next, each charged the bytes size returns
+func (b *bandwidthCap) limit(next Sink, size func(*telemetry.Telemetry) int) func([]*telemetry.Telemetry) error {
+	return func(messages []*telemetry.Telemetry) error {
+		b.mu.Lock()
+		now := time.Now()
+		if !b.last.IsZero() {
+			b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		}
+		b.last = now
+
+		// Admit from the highest class down, then keep the original order
+		order := make([]int, len(messages))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortStableFunc(order, func(i, j int) int {
+			return b.priorities.rank(messages[j].SubscriptionIDStr) - b.priorities.rank(messages[i].SubscriptionIDStr)
+		})
+		admitted := make([]bool, len(messages))
+		dropped := make(classDrops)
+		for _, i := range order {
+			if n := float64(size(messages[i])); n <= b.tokens {
+				b.tokens -= n
+				admitted[i] = true
+			} else {
+				dropped.add(b.priorities, messages[i])
+			}
+		}
+		for class, n := range dropped {
+			b.dropped[class] += n
+		}
+		total := b.dropped.String()
+		b.mu.Unlock()
+
+		var kept []*telemetry.Telemetry
+		for i, m := range messages {
+			if admitted[i] {
+				kept = append(kept, m)
+			}
+		}
+		if len(dropped) > 0 {
+			log.Printf("%s bandwidth cap dropped %s messages (%s total)", b.output, dropped, total)
+		}
+		return next.Write(kept)
+	}
+}
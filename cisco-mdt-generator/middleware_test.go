@@ -0,0 +1,182 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// recordingSink keeps the messages written to it and charges each the same
+// size
+type recordingSink struct {
+	name    string
+	size    int
+	written []*telemetry.Telemetry
+}
+
+func (s *recordingSink) Name() string { return s.name }
+
+func (s *recordingSink) Write(messages []*telemetry.Telemetry) error {
+	s.written = append(s.written, messages...)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func (s *recordingSink) PayloadSize(m *telemetry.Telemetry) int { return s.size }
+
+// subscriptions returns the subscriptions of messages in order
+func subscriptions(messages []*telemetry.Telemetry) string {
+	var subs []string
+	for _, m := range messages {
+		subs = append(subs, m.SubscriptionIDStr)
+	}
+	return strings.Join(subs, ",")
+}
+
+// collection returns a message per subscription
+func collection(subs ...string) []*telemetry.Telemetry {
+	messages := make([]*telemetry.Telemetry, len(subs))
+	for i, sub := range subs {
+		messages[i] = &telemetry.Telemetry{NodeIDStr: "leaf-101", SubscriptionIDStr: sub}
+	}
+	return messages
+}
+
+func TestCheckMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		stages  []SinkMiddlewareConfig
+		wantErr string
+	}{
+		{name: "no stages"},
+		{name: "every type", stages: []SinkMiddlewareConfig{
+			{Type: middlewareDelay, Delay: time.Millisecond, Jitter: time.Millisecond},
+			{Type: middlewareDrop, Percent: 10},
+			{Type: middlewareDuplicate, Percent: 100},
+			{Type: middlewareBandwidth, BytesPerSecond: 1000},
+			{Type: middlewareSparseRows, Percent: 5},
+			{Type: middlewareMalformedRows, Percent: 5, Modes: malformedModes},
+			{Type: middlewareStringFuzz, Percent: 5},
+		}},
+		{name: "every output", stages: []SinkMiddlewareConfig{
+			{Type: middlewareDrop, Percent: 1, Apply: outputNames()},
+		}},
+		{name: "pcap and gnmi outputs", stages: []SinkMiddlewareConfig{
+			{Type: middlewareDrop, Percent: 1, Apply: []string{"pcap", gnmiOutput}},
+		}},
+		{name: "unknown type", stages: []SinkMiddlewareConfig{{Type: "corrupt"}},
+			wantErr: `stage 1: unknown type "corrupt"`},
+		{name: "percent above 100", stages: []SinkMiddlewareConfig{{Type: middlewareDrop, Percent: 101}},
+			wantErr: "stage 1: percent must be between 0 and 100"},
+		{name: "negative percent", stages: []SinkMiddlewareConfig{{Type: middlewareDuplicate, Percent: -1}},
+			wantErr: "stage 1: percent must be between 0 and 100"},
+		{name: "negative jitter", stages: []SinkMiddlewareConfig{{Type: middlewareDelay, Jitter: -time.Second}},
+			wantErr: "stage 1: delay and jitter must not be negative"},
+		{name: "bandwidth without rate", stages: []SinkMiddlewareConfig{
+			{Type: middlewareDrop},
+			{Type: middlewareBandwidth},
+		}, wantErr: "stage 2: bandwidth needs a positive bytes_per_second"},
+		{name: "unknown malformed mode", stages: []SinkMiddlewareConfig{{Type: middlewareMalformedRows, Modes: []string{"bogus"}}},
+			wantErr: `stage 1: unknown malformed row mode "bogus"`},
+		{name: "unknown output", stages: []SinkMiddlewareConfig{{Type: middlewareDrop, Apply: []string{"collector", "kafka"}}},
+			wantErr: `stage 1: unknown output "kafka"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMiddleware(tt.stages)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOutputNamesCoverSinks(t *testing.T) {
+	names := outputNames()
+	for _, want := range []string{"collector", "pcap", gnmiOutput} {
+		if !slices.Contains(names, want) {
+			t.Errorf("outputs %v miss %s", names, want)
+		}
+	}
+	if len(names) != len(sinkTypes)+2 {
+		t.Errorf("%d outputs for %d sinks", len(names), len(sinkTypes))
+	}
+}
+
+func TestBandwidthCap(t *testing.T) {
+	priorities := Priorities{"bgp": priorityHigh, "inventory": priorityLow}
+
+	tests := []struct {
+		name  string
+		stage SinkMiddlewareConfig
+		size  int
+		idle  time.Duration // since the previous write
+		in    []string
+		want  string
+	}{
+		{name: "everything fits", stage: SinkMiddlewareConfig{BytesPerSecond: 1000},
+			size: 100, in: []string{"bgp", "vni", "inventory"}, want: "bgp,vni,inventory"},
+		{name: "burst defaults to one second", stage: SinkMiddlewareConfig{BytesPerSecond: 250},
+			size: 100, in: []string{"vni", "vni", "vni"}, want: "vni,vni"},
+		{name: "burst caps the bucket", stage: SinkMiddlewareConfig{BytesPerSecond: 1000, Burst: 150},
+			size: 100, in: []string{"vni", "vni"}, want: "vni"},
+		{name: "high priority is admitted first", stage: SinkMiddlewareConfig{BytesPerSecond: 200},
+			size: 100, in: []string{"inventory", "vni", "bgp"}, want: "vni,bgp"},
+		{name: "a message larger than the bucket is dropped", stage: SinkMiddlewareConfig{BytesPerSecond: 100},
+			size: 101, in: []string{"bgp"}, want: ""},
+		{name: "the bucket refills while idle", stage: SinkMiddlewareConfig{BytesPerSecond: 100, Burst: 300},
+			size: 100, idle: 2 * time.Second, in: []string{"vni", "vni", "vni"}, want: "vni,vni"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.stage.Type = middlewareBandwidth
+			b := newBandwidthCap(tt.stage, "collector", priorities)
+			if tt.idle > 0 {
+				// Empty the bucket, then let it refill
+				b.tokens, b.last = 0, time.Now().Add(-tt.idle)
+			}
+			out := &recordingSink{name: "collector", size: tt.size}
+			if err := b.limit(out, out.PayloadSize)(collection(tt.in...)); err != nil {
+				t.Fatal(err)
+			}
+			if got := subscriptions(out.written); got != tt.want {
+				t.Errorf("passed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareSharesBandwidthBuckets(t *testing.T) {
+	stages := []SinkMiddlewareConfig{{Type: middlewareBandwidth, BytesPerSecond: 200, Apply: []string{"collector", "influx"}}}
+	mw := NewMiddleware(stages, nil)
+
+	// Two nodes, or a node reconnecting, write to the same output
+	first := &recordingSink{name: "collector", size: 100}
+	second := &recordingSink{name: "collector", size: 100}
+	other := &recordingSink{name: "influx", size: 100}
+	for _, s := range []*recordingSink{first, second, other} {
+		if err := mw.Wrap(s).Write(collection("vni", "vni")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(first.written) != 2 || len(second.written) != 0 {
+		t.Errorf("collector passed %d and %d messages, want 2 and 0 from one bucket", len(first.written), len(second.written))
+	}
+	if len(other.written) != 2 {
+		t.Errorf("influx passed %d messages, want 2 from its own bucket", len(other.written))
+	}
+
+	// Outputs the stage does not apply to are not policed
+	pcap := &recordingSink{name: "pcap", size: 1000}
+	mw.Wrap(pcap).Write(collection("vni", "vni"))
+	if len(pcap.written) != 2 {
+		t.Errorf("pcap passed %d messages, want 2", len(pcap.written))
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// MQTT 3.1.1 control packet types, shifted into the fixed header
+const (
+	mqttConnect    = 1 << 4
+	mqttConnack    = 2 << 4
+	mqttPublish    = 3 << 4
+	mqttPuback     = 4 << 4
+	mqttPubrec     = 5 << 4
+	mqttPubrel     = 6 << 4
+	mqttPubcomp    = 7 << 4
+	mqttDisconnect = 14 << 4
+)
+
+// MQTTSink publishes every telemetry message as its GPB-KV encoding to an
+// MQTT topic. With QoS 1 or 2 every publish waits for the broker to
+// complete its acknowledgement flow.
+type MQTTSink struct {
+	cfg  MQTTSinkConfig
+	conn net.Conn
+
+	mu       sync.Mutex // guards writes, packetID and pending
+	w        *bufio.Writer
+	packetID uint16
+	pending  map[uint16]chan error
+}
+
+// NewMQTTSink connects to the broker at cfg.URL (mqtt://host:1883)
+func NewMQTTSink(cfg MQTTSinkConfig) (*MQTTSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "mqtt" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1883")
+	}
+
+	conn, err := net.DialTimeout("tcp", host, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	s := &MQTTSink{
+		cfg:     cfg,
+		conn:    conn,
+		w:       bufio.NewWriter(conn),
+		pending: make(map[uint16]chan error),
+	}
+	r := bufio.NewReader(conn)
+	if err := s.handshake(r); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go s.readLoop(r)
+	return s, nil
+}
+
+// handshake sends CONNECT with a clean session and no keepalive, so an idle
+// run is not disconnected between long intervals, and checks the CONNACK
+func (s *MQTTSink) handshake(r *bufio.Reader) error {
+	s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+	defer s.conn.SetDeadline(time.Time{})
+
+	clientID := s.cfg.ClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("mdtsim-%x", rand.Uint32())
+	}
+
+	flags := byte(0x02) // clean session
+	payload := mqttString(clientID)
+	if s.cfg.User != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(s.cfg.User)...)
+	}
+	if s.cfg.Password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(s.cfg.Password)...)
+	}
+	body := append(mqttString("MQTT"), 4, flags, 0, 0)
+	s.writePacket(mqttConnect, append(body, payload...))
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	typ, body, err := readMQTTPacket(r)
+	if err != nil {
+		return fmt.Errorf("failed to read connack: %w", err)
+	}
+	if typ&0xF0 != mqttConnack || len(body) < 2 {
+		return fmt.Errorf("unexpected packet type %d", typ>>4)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("connection refused: %s", mqttConnackReason(body[1]))
+	}
+	return nil
+}
+
+// readLoop routes acknowledgements to the publishes waiting for them and
+// answers PUBREC with PUBREL for QoS 2
+func (s *MQTTSink) readLoop(r *bufio.Reader) {
+	for {
+		typ, body, err := readMQTTPacket(r)
+		if err != nil {
+			s.failPending(fmt.Errorf("connection lost: %w", err))
+			return
+		}
+		if len(body) < 2 {
+			continue
+		}
+		id := binary.BigEndian.Uint16(body)
+
+		switch typ & 0xF0 {
+		case mqttPuback, mqttPubcomp:
+			s.mu.Lock()
+			ch, ok := s.pending[id]
+			delete(s.pending, id)
+			s.mu.Unlock()
+			if ok {
+				ch <- nil
+			}
+		case mqttPubrec:
+			s.mu.Lock()
+			s.writePacket(mqttPubrel|0x02, body[:2])
+			s.w.Flush()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// failPending fails every publish waiting for an acknowledgement
+func (s *MQTTSink) failPending(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, ch := range s.pending {
+		ch <- err
+		delete(s.pending, id)
+	}
+}
+
+func (s *MQTTSink) Name() string { return "mqtt" }
+
+// Write publishes each message to its templated topic
+func (s *MQTTSink) Write(messages []*telemetry.Telemetry) error {
+	var acks []chan error
+	var ids []uint16
+
+	header := byte(mqttPublish | s.cfg.QoS<<1)
+	if s.cfg.Retain {
+		header |= 0x01
+	}
+
+	s.mu.Lock()
+	for _, m := range messages {
+		payload, err := m.Marshal()
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+
+		topic := strings.ReplaceAll(expandSinkTemplate(s.cfg.Topic, m), pathPlaceholder, m.EncodingPath)
+		body := mqttString(topic)
+		if s.cfg.QoS > 0 {
+			s.packetID++
+			if s.packetID == 0 {
+				s.packetID = 1
+			}
+			ch := make(chan error, 1)
+			s.pending[s.packetID] = ch
+			acks = append(acks, ch)
+			ids = append(ids, s.packetID)
+			body = binary.BigEndian.AppendUint16(body, s.packetID)
+		}
+		s.writePacket(header, append(body, payload...))
+	}
+	err := s.w.Flush()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	timeout := time.After(s.cfg.Timeout)
+	for _, ch := range acks {
+		select {
+		case err := <-ch:
+			if err != nil {
+				return fmt.Errorf("publish not acknowledged: %w", err)
+			}
+		case <-timeout:
+			s.mu.Lock()
+			for _, id := range ids {
+				delete(s.pending, id)
+			}
+			s.mu.Unlock()
+			return fmt.Errorf("timed out waiting for QoS %d acknowledgements", s.cfg.QoS)
+		}
+	}
+	return nil
+}
+
+func (s *MQTTSink) Close() error {
+	s.mu.Lock()
+	s.writePacket(mqttDisconnect, nil)
+	s.w.Flush()
+	s.mu.Unlock()
+	return s.conn.Close()
+}
+
+// writePacket buffers a control packet
+func (s *MQTTSink) writePacket(header byte, body []byte) {
+	s.w.WriteByte(header)
+	// Remaining length is a base-128 varint
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		s.w.WriteByte(b)
+		if n == 0 {
+			break
+		}
+	}
+	s.w.Write(body)
+}
+
+// readMQTTPacket reads one control packet
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, fmt.Errorf("invalid remaining length")
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+// mqttString encodes a length-prefixed UTF-8 string
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// mqttConnackReason describes a CONNACK return code
+func mqttConnackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
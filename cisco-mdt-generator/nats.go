@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// subscriptionPlaceholder is replaced by the subscription-id-str in sink
+// subject, topic and key templates
+const subscriptionPlaceholder = "{subscription}"
+
+// expandSinkTemplate fills the node and subscription placeholders of a
+// subject, topic or key template
+func expandSinkTemplate(template string, m *telemetry.Telemetry) string {
+	return strings.NewReplacer(nodePlaceholder, m.NodeIDStr, subscriptionPlaceholder, m.SubscriptionIDStr).Replace(template)
+}
+
+// NATSSink publishes every telemetry message as its GPB-KV encoding to a
+// NATS subject. With JetStream enabled every publish waits for the stream's
+// acknowledgement.
+type NATSSink struct {
+	cfg   NATSSinkConfig
+	conn  net.Conn
+	inbox string
+
+	mu      sync.Mutex // guards writes, seq and pending
+	w       *bufio.Writer
+	seq     int
+	pending map[string]chan error
+}
+
+// natsAck is a JetStream publish acknowledgement
+type natsAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+	Error  *struct {
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// NewNATSSink connects to the NATS server at cfg.URL (nats://host:4222)
+func NewNATSSink(cfg NATSSinkConfig) (*NATSSink, error) {
+	addr := strings.TrimPrefix(cfg.URL, "nats://")
+	conn, err := net.DialTimeout("tcp", addr, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &NATSSink{
+		cfg:     cfg,
+		conn:    conn,
+		inbox:   fmt.Sprintf("_INBOX.mdtsim.%x", rand.Uint64()),
+		w:       bufio.NewWriter(conn),
+		pending: make(map[string]chan error),
+	}
+	r := bufio.NewReader(conn)
+	if err := s.handshake(r); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go s.readLoop(r)
+	return s, nil
+}
+
+// handshake reads the server INFO, authenticates and waits for the server
+// to answer a PING, which proves CONNECT was accepted
+func (s *NATSSink) handshake(r *bufio.Reader) error {
+	s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+	defer s.conn.SetDeadline(time.Time{})
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read server info: %w", err)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(line[5:]), &info) != nil {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if info.TLSRequired {
+		return fmt.Errorf("server requires TLS, which the NATS sink does not support")
+	}
+
+	connect, _ := json.Marshal(map[string]any{
+		"verbose":    false,
+		"pedantic":   false,
+		"name":       "cisco-mdt-generator",
+		"lang":       "go",
+		"version":    version,
+		"protocol":   1,
+		"user":       s.cfg.User,
+		"pass":       s.cfg.Password,
+		"auth_token": s.cfg.Token,
+		// Publishing to a subject no stream captures then fails fast with a
+		// 503 status instead of timing out
+		"headers":       true,
+		"no_responders": true,
+	})
+	fmt.Fprintf(s.w, "CONNECT %s\r\nPING\r\n", connect)
+	if s.cfg.JetStream {
+		fmt.Fprintf(s.w, "SUB %s.* 1\r\n", s.inbox)
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	if line = strings.TrimSpace(line); line != "PONG" {
+		return fmt.Errorf("connect rejected: %s", line)
+	}
+	return nil
+}
+
+// readLoop answers server pings and routes JetStream acknowledgements to
+// the publishes waiting for them
+func (s *NATSSink) readLoop(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			s.failPending(fmt.Errorf("connection lost: %w", err))
+			return
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PING":
+			s.mu.Lock()
+			s.w.WriteString("PONG\r\n")
+			s.w.Flush()
+			s.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			s.failPending(fmt.Errorf("server error: %s", strings.TrimSpace(line[4:])))
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>, HMSG also has the
+			// header length before the total length
+			parts := strings.Fields(line)
+			size, _ := strconv.Atoi(parts[len(parts)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				s.failPending(fmt.Errorf("connection lost: %w", err))
+				return
+			}
+			s.ack(parts[1], parts[0] == "HMSG", payload[:size])
+		}
+	}
+}
+
+// ack completes the publish waiting on an inbox subject. Replies with
+// headers only carry a status, such as 503 when no stream responded.
+func (s *NATSSink) ack(subject string, headers bool, payload []byte) {
+	s.mu.Lock()
+	ch, ok := s.pending[subject]
+	delete(s.pending, subject)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	var a natsAck
+	switch {
+	case headers:
+		status, _, _ := strings.Cut(string(payload), "\r\n")
+		ch <- fmt.Errorf("no JetStream stream captures the subject (%s)", status)
+	case json.Unmarshal(payload, &a) != nil:
+		ch <- fmt.Errorf("invalid JetStream ack %q", payload)
+	case a.Error != nil:
+		ch <- errors.New(a.Error.Description)
+	default:
+		ch <- nil
+	}
+}
+
+// failPending fails every publish waiting for an acknowledgement
+func (s *NATSSink) failPending(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for subject, ch := range s.pending {
+		ch <- err
+		delete(s.pending, subject)
+	}
+}
+
+func (s *NATSSink) Name() string { return "nats" }
+
+// Write publishes each message to its templated subject
+func (s *NATSSink) Write(messages []*telemetry.Telemetry) error {
+	var acks []chan error
+	var replies []string
+
+	s.mu.Lock()
+	for _, m := range messages {
+		payload, err := m.Marshal()
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+
+		subject := expandSinkTemplate(s.cfg.Subject, m)
+		if s.cfg.JetStream {
+			s.seq++
+			reply := s.inbox + "." + strconv.Itoa(s.seq)
+			ch := make(chan error, 1)
+			s.pending[reply] = ch
+			acks = append(acks, ch)
+			replies = append(replies, reply)
+			fmt.Fprintf(s.w, "PUB %s %s %d\r\n", subject, reply, len(payload))
+		} else {
+			fmt.Fprintf(s.w, "PUB %s %d\r\n", subject, len(payload))
+		}
+		s.w.Write(payload)
+		s.w.WriteString("\r\n")
+	}
+	err := s.w.Flush()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	timeout := time.After(s.cfg.Timeout)
+	for _, ch := range acks {
+		select {
+		case err := <-ch:
+			if err != nil {
+				return fmt.Errorf("publish not acknowledged: %w", err)
+			}
+		case <-timeout:
+			s.mu.Lock()
+			for _, reply := range replies {
+				delete(s.pending, reply)
+			}
+			s.mu.Unlock()
+			return fmt.Errorf("timed out waiting for JetStream acks")
+		}
+	}
+	return nil
+}
+
+func (s *NATSSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NodeTemplateConfig is a reusable node profile such as leaf, spine or
+// border. Settings are applied over the top-level configuration, parent
+// template first.
+type NodeTemplateConfig struct {
+	Extends string               `yaml:"extends"` // parent template
+	Role    string               `yaml:"role"`    // leaf or spine, empty to inherit, leaf at the root
+	Sensors []string             `yaml:"sensors"` // subscriptions streamed, empty to inherit
+	Bundle  string               `yaml:"bundle"`  // built-in sensor set replacing the inherited one
+	Ranges  map[string][]float64 `yaml:"ranges"`  // setting -> [min, max], drawn per node
+	Config  yaml.Node            `yaml:"config"`  // overrides of top-level settings
+}
+
+// Roles of nodes in a fabric whose uplinks the pools allocate
+const (
+	roleLeaf  = "leaf"
+	roleSpine = "spine"
+)
+
+// NodeConfig lists a node, or with count a series of nodes whose id is a
+// printf format of the node number, e.g. leaf-%d
+type NodeConfig struct {
+	ID       string    `yaml:"id"`
+	Count    int       `yaml:"count"`
+	First    int       `yaml:"first"` // number of the first node of a series, default 1
+	Template string    `yaml:"template"`
+	Bundle   string    `yaml:"bundle"` // built-in sensor set, applied before sensors
+	Sensors  []string  `yaml:"sensors"`
+	Config   yaml.Node `yaml:"config"` // per-node overrides, applied after the template
+}
+
+// nodeIDs returns the node-id-str of every node of an entry
+func (n NodeConfig) nodeIDs() []string {
+	if n.Count == 0 {
+		return []string{n.ID}
+	}
+	first := n.First
+	if first == 0 {
+		first = 1
+	}
+	ids := make([]string, n.Count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf(n.ID, first+i)
+	}
+	return ids
+}
+
+// NodeIDs lists every node of the nodes section in order
+func (c *Config) NodeIDs() []string {
+	var ids []string
+	for _, n := range c.Nodes {
+		ids = append(ids, n.nodeIDs()...)
+	}
+	return ids
+}
+
+// configFor returns the configuration of a node: its resolved template and
+// overrides when the nodes section lists it, the shared configuration with
+// pool-allocated uplinks otherwise
+func (c *Config) configFor(nodeID string) (*Config, error) {
+	for _, n := range c.Nodes {
+		if slices.Contains(n.nodeIDs(), nodeID) {
+			return c.resolveNode(nodeID, n)
+		}
+	}
+	if c.Pools.Spines > 0 {
+		return c.resolveNode(nodeID, NodeConfig{ID: nodeID})
+	}
+	return c, nil
+}
+
+// resolveNode builds the configuration of one node from a copy of the
+// top-level settings, the template chain and the node's own overrides
+func (c *Config) resolveNode(nodeID string, node NodeConfig) (*Config, error) {
+	cfg, err := c.base()
+	if err != nil {
+		return nil, err
+	}
+
+	// Ranges draw the same values for a node on every run
+	h := fnv.New64a()
+	h.Write([]byte(nodeID))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	chain, err := c.templateChain(node.Template)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range chain {
+		t := c.NodeTemplates[name]
+		cfg.useBundle(t.Bundle)
+		if err := overlayConfig(cfg, &t.Config); err != nil {
+			return nil, fmt.Errorf("node %s: template %s: %w", nodeID, name, err)
+		}
+		if len(t.Sensors) > 0 {
+			cfg.Sensors = t.Sensors
+		}
+		if err := applyRanges(cfg, t.Ranges, rng); err != nil {
+			return nil, fmt.Errorf("node %s: template %s: %w", nodeID, name, err)
+		}
+	}
+
+	// Pools give every leaf its own uplinks, in node list order, and every
+	// spine the other end of its uplink of each leaf
+	if cfg.Pools.Spines > 0 {
+		role, index, leafs := c.fabricPosition(nodeID)
+		if role == roleSpine {
+			cfg.BGPNeighbors, err = cfg.Pools.downlinks(index, leafs, cfg.BGPNeighbors)
+		} else {
+			cfg.BGPNeighbors, err = cfg.Pools.uplinks(index, cfg.BGPNeighbors)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %w", nodeID, err)
+		}
+	}
+
+	cfg.useBundle(node.Bundle)
+	if err := overlayConfig(cfg, &node.Config); err != nil {
+		return nil, fmt.Errorf("node %s: %w", nodeID, err)
+	}
+	if len(node.Sensors) > 0 {
+		cfg.Sensors = node.Sensors
+	}
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("node %s: %w", nodeID, err)
+	}
+	return cfg, nil
+}
+
+// fabricPosition returns the role of a node, its index among the nodes of
+// that role in node list order, and the number of leafs. Nodes the list
+// does not name are the first leaf.
+func (c *Config) fabricPosition(nodeID string) (role string, index, leafs int) {
+	role = roleLeaf
+	counts := make(map[string]int)
+	for _, n := range c.Nodes {
+		r := c.nodeRole(n)
+		for _, id := range n.nodeIDs() {
+			if id == nodeID {
+				role, index = r, counts[r]
+			}
+			counts[r]++
+		}
+	}
+	return role, index, counts[roleLeaf]
+}
+
+// nodeRole returns the role of a node entry, set by the nearest template
+// of its chain that has one
+func (c *Config) nodeRole(n NodeConfig) string {
+	chain, _ := c.templateChain(n.Template)
+	for _, name := range slices.Backward(chain) {
+		if role := c.NodeTemplates[name].Role; role != "" {
+			return role
+		}
+	}
+	return roleLeaf
+}
+
+// base returns a deep copy of the top-level settings without templates and
+// nodes
+func (c *Config) base() (*Config, error) {
+	top := *c
+	top.NodeTemplates = nil
+	top.Nodes = nil
+	data, err := yaml.Marshal(&top)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// templateChain lists a template and its ancestors, root first
+func (c *Config) templateChain(name string) ([]string, error) {
+	var chain []string
+	for name != "" {
+		if slices.Contains(chain, name) {
+			return nil, fmt.Errorf("template %s: inheritance cycle", name)
+		}
+		t, ok := c.NodeTemplates[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown template %q", name)
+		}
+		chain = append(chain, name)
+		name = t.Extends
+	}
+	slices.Reverse(chain)
+	return chain, nil
+}
+
+// overlayConfig decodes YAML settings over cfg. Lists replace the inherited
+// list; unknown settings are rejected so typos do not pass silently.
+func overlayConfig(cfg *Config, node *yaml.Node) error {
+	if node.Kind == 0 {
+		return nil
+	}
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(cfg)
+}
+
+// applyRanges sets each dotted setting, e.g. evpn.type2_routes, to a random
+// value within its range. Ranges of whole numbers draw whole numbers.
+func applyRanges(cfg *Config, ranges map[string][]float64, rng *rand.Rand) error {
+	keys := make([]string, 0, len(ranges))
+	for k := range ranges {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		r := ranges[key]
+		if len(r) != 2 || r[0] > r[1] {
+			return fmt.Errorf("range %s must be [min, max]", key)
+		}
+		var value string
+		if r[0] == math.Trunc(r[0]) && r[1] == math.Trunc(r[1]) {
+			value = strconv.FormatInt(int64(r[0])+rng.Int63n(int64(r[1]-r[0])+1), 10)
+		} else {
+			value = strconv.FormatFloat(r[0]+rng.Float64()*(r[1]-r[0]), 'f', -1, 64)
+		}
+
+		// Nest the value under every part of the dotted name
+		node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+		parts := strings.Split(key, ".")
+		for i := len(parts) - 1; i >= 0; i-- {
+			node = &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: parts[i]}, node,
+			}}
+		}
+		if err := overlayConfig(cfg, node); err != nil {
+			return fmt.Errorf("range %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// checkSensors ensures a sensor set only names subscriptions the simulator
+// or its plugins emit
+func checkSensors(sensors []string, plugins []PluginConfig) error {
+	known := sensorSubscriptions()
+	for _, p := range plugins {
+		known = append(known, p.Subscription)
+	}
+	for _, name := range sensors {
+		if !slices.Contains(known, name) {
+			return fmt.Errorf("unknown subscription %q", name)
+		}
+	}
+	return nil
+}
+
+// checkNodes ensures templates and nodes reference known templates and
+// subscriptions and that node ids are unique
+func checkNodes(cfg *Config) error {
+	for name, t := range cfg.NodeTemplates {
+		if _, err := cfg.templateChain(name); err != nil {
+			return err
+		}
+		if err := checkSensors(t.Sensors, cfg.Plugins); err != nil {
+			return fmt.Errorf("template %s sensors: %w", name, err)
+		}
+		if err := checkBundle(t.Bundle); err != nil {
+			return fmt.Errorf("template %s: %w", name, err)
+		}
+		if t.Role != "" && t.Role != roleLeaf && t.Role != roleSpine {
+			return fmt.Errorf("template %s: role must be %s or %s", name, roleLeaf, roleSpine)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, n := range cfg.Nodes {
+		if n.ID == "" {
+			return fmt.Errorf("nodes: every entry needs an id")
+		}
+		if n.Count < 0 {
+			return fmt.Errorf("nodes %s: count must not be negative", n.ID)
+		}
+		if n.Count > 0 && !strings.Contains(n.ID, "%") {
+			return fmt.Errorf("nodes %s: a series needs a printf format id such as leaf-%%d", n.ID)
+		}
+		if _, err := cfg.templateChain(n.Template); err != nil {
+			return fmt.Errorf("nodes %s: %w", n.ID, err)
+		}
+		if err := checkSensors(n.Sensors, cfg.Plugins); err != nil {
+			return fmt.Errorf("nodes %s sensors: %w", n.ID, err)
+		}
+		if err := checkBundle(n.Bundle); err != nil {
+			return fmt.Errorf("nodes %s: %w", n.ID, err)
+		}
+		for _, id := range n.nodeIDs() {
+			if seen[id] {
+				return fmt.Errorf("nodes: duplicate node %s", id)
+			}
+			seen[id] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// OnChangeConfig streams subscriptions on change, as with an NX-OS
+// sample-interval of 0 or a gNMI ON_CHANGE or suppress_redundant
+// subscription
+type OnChangeConfig struct {
+	Subscriptions []string      `yaml:"subscriptions"` // streamed on change
+	Heartbeat     time.Duration `yaml:"heartbeat"`     // every row is sent at least this often, 0 = only on change
+}
+
+// OnChange withholds the unchanged values of on-change subscriptions. A row
+// is identified by its keys: a new row, or one back after it was gone, is
+// sent whole; a known row is sent with its keys and only the values that
+// changed since they were last sent, or not at all. Every value is sent at
+// the first collection and again once the heartbeat has passed, so
+// collectors can tell an unchanged value from a lost one. Values count as
+// sent once Commit reports their message delivered, so a collection that
+// is paused, shed or dropped is sent again at the next.
+type OnChange struct {
+	cfg OnChangeConfig
+
+	subs    map[string]*onChangeState
+	pending map[string]*onChangeState // after the last Filter, by the subscriptions left to commit
+}
+
+// onChangeState is what a subscription last sent
+type onChangeState struct {
+	rows     map[uint64]map[string]uint64 // value hashes by key hash, then section/name#occurrence
+	lastFull time.Time                    // of the last collection sending every value
+}
+
+// NewOnChange returns the on-change filter of a node, or nil when no
+// subscription streams on change
+func NewOnChange(cfg OnChangeConfig) *OnChange {
+	if len(cfg.Subscriptions) == 0 {
+		return nil
+	}
+	return &OnChange{cfg: cfg, subs: make(map[string]*onChangeState), pending: make(map[string]*onChangeState)}
+}
+
+// Filter removes the unchanged values of on-change subscriptions from the
+// collection at now, and the messages left without rows. The values stay
+// unsent until Commit. The caller holds the simulator lock. A nil OnChange
+// keeps every message.
+func (o *OnChange) Filter(messages []*telemetry.Telemetry, now time.Time) []*telemetry.Telemetry {
+	if o == nil {
+		return messages
+	}
+	clear(o.pending)
+	return slices.DeleteFunc(messages, func(m *telemetry.Telemetry) bool {
+		if !slices.Contains(o.cfg.Subscriptions, m.SubscriptionIDStr) {
+			return false
+		}
+		m.DataGpbkv = o.changed(m.SubscriptionIDStr, m.DataGpbkv, now)
+		return len(m.DataGpbkv) == 0
+	})
+}
+
+// Commit records the values of the messages delivered since the last
+// Filter as sent. Messages are matched by subscription, so copies made on
+// the way to the transport count too. The caller holds the simulator lock.
+// Commit does nothing on a nil OnChange.
+func (o *OnChange) Commit(delivered []*telemetry.Telemetry) {
+	if o == nil {
+		return
+	}
+	for _, m := range delivered {
+		if next, ok := o.pending[m.SubscriptionIDStr]; ok {
+			o.subs[m.SubscriptionIDStr] = next
+			delete(o.pending, m.SubscriptionIDStr)
+		}
+	}
+}
+
+// changed returns the rows of a subscription to send at now, reduced to
+// their changed values. The current values of every row are committed
+// right away when nothing is left to send, or else once the message is
+// delivered.
+func (o *OnChange) changed(sub string, rows []*telemetry.TelemetryField, now time.Time) []*telemetry.TelemetryField {
+	s, ok := o.subs[sub]
+	if !ok {
+		s = &onChangeState{}
+	}
+	next := &onChangeState{lastFull: s.lastFull}
+	heartbeat := s.lastFull.IsZero() || (o.cfg.Heartbeat > 0 && now.Sub(s.lastFull) >= o.cfg.Heartbeat)
+	if heartbeat {
+		next.lastFull = now
+	}
+
+	current := make(map[uint64]map[string]uint64, len(rows))
+	var kept []*telemetry.TelemetryField
+	for i, row := range rows {
+		key, values := rowHashes(row, i)
+		current[key] = values
+		prev, seen := s.rows[key]
+		if heartbeat || !seen {
+			kept = append(kept, row)
+		} else if r := changedValues(row, prev); r != nil {
+			kept = append(kept, r)
+		}
+	}
+	// Rows that are gone are forgotten, so they are sent whole when they
+	// return
+	next.rows = current
+	if len(kept) == 0 {
+		o.subs[sub] = next
+	} else {
+		o.pending[sub] = next
+	}
+	return kept
+}
+
+// changedValues returns a copy of a row with its keys and the values whose
+// hash differs from prev, or nil when none does
+func changedValues(row *telemetry.TelemetryField, prev map[string]uint64) *telemetry.TelemetryField {
+	r := &telemetry.TelemetryField{Timestamp: row.Timestamp, Name: row.Name}
+	changed := false
+	for _, section := range row.Fields {
+		if section.Name == "keys" {
+			r.Fields = append(r.Fields, section)
+			continue
+		}
+		c := &telemetry.TelemetryField{Timestamp: section.Timestamp, Name: section.Name}
+		seen := make(map[string]int)
+		for _, f := range section.Fields {
+			id := valueID(section.Name, f.Name, seen)
+			if h, ok := prev[id]; !ok || h != fieldHash(f) {
+				c.Fields = append(c.Fields, f)
+			}
+		}
+		if len(c.Fields) > 0 {
+			r.Fields = append(r.Fields, c)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return r
+}
+
+// rowHashes returns the hash of the keys of a row and the hash of each of
+// its other values. Timestamps are left out, as they change every
+// collection. A row without keys is identified by its position.
+func rowHashes(row *telemetry.TelemetryField, index int) (uint64, map[string]uint64) {
+	kh := fnv.New64a()
+	hasKeys := false
+	values := make(map[string]uint64)
+	for _, section := range row.Fields {
+		if section.Name == "keys" {
+			hashField(kh, section)
+			hasKeys = true
+			continue
+		}
+		seen := make(map[string]int)
+		for _, f := range section.Fields {
+			values[valueID(section.Name, f.Name, seen)] = fieldHash(f)
+		}
+	}
+	if !hasKeys {
+		binary.Write(kh, binary.LittleEndian, int64(index))
+	}
+	return kh.Sum64(), values
+}
+
+// valueID identifies a value of a row section, counting repeated names
+func valueID(section, name string, seen map[string]int) string {
+	n := seen[name]
+	seen[name]++
+	return section + "/" + name + "#" + strconv.Itoa(n)
+}
+
+// fieldHash returns the hash of a field and its children
+func fieldHash(f *telemetry.TelemetryField) uint64 {
+	h := fnv.New64a()
+	hashField(h, f)
+	return h.Sum64()
+}
+
+// hashField writes the name, value and children of a field to h
+func hashField(h hash.Hash64, f *telemetry.TelemetryField) {
+	var b []byte
+	b = append(b, f.Name...)
+	b = append(b, 0)
+	switch {
+	case f.StringValue != nil:
+		b = append(append(b, 's'), *f.StringValue...)
+	case f.BytesValue != nil:
+		b = append(append(b, 'b'), f.BytesValue...)
+	case f.BoolValue != nil:
+		b = append(b, 't', boolByte(*f.BoolValue))
+	case f.Uint32Value != nil:
+		b = binary.LittleEndian.AppendUint64(append(b, 'u'), uint64(*f.Uint32Value))
+	case f.Uint64Value != nil:
+		b = binary.LittleEndian.AppendUint64(append(b, 'U'), *f.Uint64Value)
+	case f.Sint32Value != nil:
+		b = binary.LittleEndian.AppendUint64(append(b, 'i'), uint64(*f.Sint32Value))
+	case f.Sint64Value != nil:
+		b = binary.LittleEndian.AppendUint64(append(b, 'I'), uint64(*f.Sint64Value))
+	case f.DoubleValue != nil:
+		b = binary.LittleEndian.AppendUint64(append(b, 'd'), math.Float64bits(*f.DoubleValue))
+	case f.FloatValue != nil:
+		b = binary.LittleEndian.AppendUint32(append(b, 'f'), math.Float32bits(*f.FloatValue))
+	}
+	b = append(b, '{')
+	h.Write(b)
+	for _, child := range f.Fields {
+		hashField(h, child)
+	}
+	h.Write([]byte{'}'})
+}
+
+// boolByte returns 1 for true and 0 for false
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// checkOnChange ensures the on-change settings name known subscriptions
+func checkOnChange(c OnChangeConfig, plugins []PluginConfig) error {
+	if err := checkSensors(c.Subscriptions, plugins); err != nil {
+		return fmt.Errorf("subscriptions: %w", err)
+	}
+	if c.Heartbeat < 0 {
+		return fmt.Errorf("heartbeat must be non-negative")
+	}
+	return nil
+}
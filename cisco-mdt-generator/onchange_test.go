@@ -0,0 +1,194 @@
+package main
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// onChangeStep is one collection of an on-change test: the values of every
+// row, whether the filtered messages are delivered, and the leaves expected
+// in each row sent
+type onChangeStep struct {
+	at      time.Duration
+	rows    map[string]map[string]uint64 // values by row key
+	deliver bool
+	want    map[string][]string // leaves by row key, nil when nothing is sent
+}
+
+func TestOnChangeFilter(t *testing.T) {
+	up := map[string]uint64{"state": 6, "prefixes": 150}
+	flapped := map[string]uint64{"state": 1, "prefixes": 150}
+
+	tests := []struct {
+		name      string
+		heartbeat time.Duration
+		steps     []onChangeStep
+	}{
+		{
+			name: "first collection sends every value",
+			steps: []onChangeStep{
+				{rows: map[string]map[string]uint64{"a": up, "b": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}, "b": {"prefixes", "state"}}},
+			},
+		},
+		{
+			name: "unchanged values are withheld",
+			steps: []onChangeStep{
+				{rows: map[string]map[string]uint64{"a": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+				{at: 5 * time.Second, rows: map[string]map[string]uint64{"a": up}, deliver: true},
+			},
+		},
+		{
+			name: "a changed row carries only the changed values",
+			steps: []onChangeStep{
+				{rows: map[string]map[string]uint64{"a": up, "b": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}, "b": {"prefixes", "state"}}},
+				{at: 5 * time.Second, rows: map[string]map[string]uint64{"a": flapped, "b": up}, deliver: true,
+					want: map[string][]string{"a": {"state"}}},
+			},
+		},
+		{
+			name: "an undelivered change is sent again",
+			steps: []onChangeStep{
+				{rows: map[string]map[string]uint64{"a": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+				{at: 5 * time.Second, rows: map[string]map[string]uint64{"a": flapped},
+					want: map[string][]string{"a": {"state"}}},
+				{at: 10 * time.Second, rows: map[string]map[string]uint64{"a": flapped}, deliver: true,
+					want: map[string][]string{"a": {"state"}}},
+				{at: 15 * time.Second, rows: map[string]map[string]uint64{"a": flapped}, deliver: true},
+			},
+		},
+		{
+			name: "an undelivered first collection is sent whole again",
+			steps: []onChangeStep{
+				{rows: map[string]map[string]uint64{"a": up},
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+				{at: 5 * time.Second, rows: map[string]map[string]uint64{"a": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+			},
+		},
+		{
+			name: "a row back after it was gone is sent whole",
+			steps: []onChangeStep{
+				{rows: map[string]map[string]uint64{"a": up, "b": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}, "b": {"prefixes", "state"}}},
+				{at: 5 * time.Second, rows: map[string]map[string]uint64{"a": up}, deliver: true},
+				{at: 10 * time.Second, rows: map[string]map[string]uint64{"a": up, "b": up}, deliver: true,
+					want: map[string][]string{"b": {"prefixes", "state"}}},
+			},
+		},
+		{
+			name:      "the heartbeat sends every value",
+			heartbeat: time.Minute,
+			steps: []onChangeStep{
+				{rows: map[string]map[string]uint64{"a": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+				{at: 30 * time.Second, rows: map[string]map[string]uint64{"a": up}, deliver: true},
+				{at: time.Minute, rows: map[string]map[string]uint64{"a": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+				{at: 90 * time.Second, rows: map[string]map[string]uint64{"a": up}, deliver: true},
+			},
+		},
+		{
+			name:      "an undelivered heartbeat is sent again",
+			heartbeat: time.Minute,
+			steps: []onChangeStep{
+				{rows: map[string]map[string]uint64{"a": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+				{at: time.Minute, rows: map[string]map[string]uint64{"a": up},
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+				{at: 65 * time.Second, rows: map[string]map[string]uint64{"a": up}, deliver: true,
+					want: map[string][]string{"a": {"prefixes", "state"}}},
+			},
+		},
+	}
+
+	start := time.Unix(0, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewOnChange(OnChangeConfig{Subscriptions: []string{"bgp"}, Heartbeat: tt.heartbeat})
+			for i, step := range tt.steps {
+				now := start.Add(step.at)
+				messages := o.Filter([]*telemetry.Telemetry{
+					onChangeMessage("bgp", now, step.rows),
+					onChangeMessage("interfaces", now, step.rows),
+				}, now)
+				if step.deliver {
+					o.Commit(messages)
+				}
+
+				var got map[string][]string
+				for _, m := range messages {
+					switch m.SubscriptionIDStr {
+					case "bgp":
+						got = sentLeaves(m)
+					case "interfaces":
+						if len(m.DataGpbkv) != len(step.rows) {
+							t.Errorf("step %d: other subscription has %d rows, want %d", i, len(m.DataGpbkv), len(step.rows))
+						}
+					}
+				}
+				if !reflect.DeepEqual(got, step.want) {
+					t.Errorf("step %d: sent %v, want %v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOnChangeNil(t *testing.T) {
+	var o *OnChange
+	now := time.Unix(0, 0)
+	messages := []*telemetry.Telemetry{onChangeMessage("bgp", now, map[string]map[string]uint64{"a": {"state": 6}})}
+	if got := o.Filter(messages, now); len(got) != 1 {
+		t.Errorf("nil filter kept %d messages, want 1", len(got))
+	}
+	o.Commit(messages)
+	if NewOnChange(OnChangeConfig{}) != nil {
+		t.Errorf("filter without subscriptions is not nil")
+	}
+}
+
+// onChangeMessage builds a message of a subscription with a row per key,
+// in key order
+func onChangeMessage(sub string, now time.Time, rows map[string]map[string]uint64) *telemetry.Telemetry {
+	ts := uint64(now.UnixMilli())
+	m := &telemetry.Telemetry{NodeIDStr: "leaf-101", SubscriptionIDStr: sub, EncodingPath: "test:" + sub, MsgTimestamp: ts}
+	for _, key := range slices.Sorted(maps.Keys(rows)) {
+		var content []*telemetry.TelemetryField
+		for _, name := range slices.Sorted(maps.Keys(rows[key])) {
+			content = append(content, telemetry.Uint64Field(name, rows[key][name], ts))
+		}
+		keys := []*telemetry.TelemetryField{telemetry.StringField("name", key, ts)}
+		m.DataGpbkv = append(m.DataGpbkv, telemetry.RowField(keys, content, ts))
+	}
+	return m
+}
+
+// sentLeaves returns the content leaf names of every row of a message by
+// the row's key
+func sentLeaves(m *telemetry.Telemetry) map[string][]string {
+	leaves := make(map[string][]string)
+	for _, row := range m.DataGpbkv {
+		var key string
+		var names []string
+		for _, section := range row.Fields {
+			for _, f := range section.Fields {
+				if section.Name == "keys" {
+					key = *f.StringValue
+				} else {
+					names = append(names, f.Name)
+				}
+			}
+		}
+		leaves[key] = names
+	}
+	return leaves
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"cisco-mdt-generator/pkg/kube"
+)
+
+// Resource of the fleets managed by the operator, see
+// config/kubernetes/crd.yaml
+const (
+	fleetGroup    = "mdtsim.io"
+	fleetVersion  = "v1alpha1"
+	fleetKind     = "TelemetrySimulatorFleet"
+	fleetResource = "telemetrysimulatorfleets"
+	fieldManager  = "mdtsim-operator"
+	clusterPort   = 50060
+	configDir     = "/etc/mdtsim"
+)
+
+// Phases reported in the status of a fleet
+const (
+	phasePending  = "Pending"  // the leader is not ready yet
+	phaseRunning  = "Running"  // every member is ready
+	phaseDegraded = "Degraded" // some followers are missing
+	phaseInvalid  = "Invalid"  // the spec cannot be simulated
+)
+
+// operatorOptions are the flags of the operator command
+type operatorOptions struct {
+	apiServer string
+	namespace string
+	image     string
+	resync    time.Duration
+}
+
+// Fleet is a TelemetrySimulatorFleet resource
+type Fleet struct {
+	Metadata struct {
+		Name       string `json:"name"`
+		Namespace  string `json:"namespace"`
+		UID        string `json:"uid"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec   FleetSpec   `json:"spec"`
+	Status FleetStatus `json:"status"`
+}
+
+// FleetSpec describes the simulated fabric, the collector it streams to
+// and how many pods share it
+type FleetSpec struct {
+	Image      string            `json:"image"`      // operator's --image when empty
+	Members    int               `json:"members"`    // pods, a cluster leader and followers when above 1
+	Leafs      int               `json:"leafs"`      // fleet --count, when the config lists no nodes
+	First      int               `json:"first"`      // fleet --first
+	NodeFormat string            `json:"nodeFormat"` // fleet --node-format
+	Template   string            `json:"template"`   // fleet --template
+	Collector  string            `json:"collector"`  // fleet --server
+	Interval   string            `json:"interval"`
+	FlapChance *float64          `json:"flapChance"`
+	Auto       map[string]string `json:"auto"`     // fabricate the topology instead of config
+	Config     string            `json:"config"`   // generator.yaml
+	Scenario   string            `json:"scenario"` // scenario YAML
+	// AllowScripts runs the Starlark script of the scenario, fleet --allow-scripts
+	AllowScripts bool `json:"allowScripts"`
+}
+
+// FleetStatus is what the operator reports about a fleet
+type FleetStatus struct {
+	Phase              string `json:"phase,omitempty"`
+	Members            int    `json:"members"`
+	ReadyMembers       int    `json:"readyMembers"`
+	Leafs              int    `json:"leafs"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Message            string `json:"message,omitempty"`
+}
+
+func newOperatorCmd() *cobra.Command {
+	var o operatorOptions
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Manage simulator pods from TelemetrySimulatorFleet resources in Kubernetes",
+		Long: "Reconciles every TelemetrySimulatorFleet resource into a ConfigMap and simulator\n" +
+			"Deployments: one fleet pod, or a cluster leader and followers sharing the leafs,\n" +
+			"and reports their readiness in the resource status. Runs in the cluster with the\n" +
+			"pod's service account, or outside of it through kubectl proxy with --api-server.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runOperator(o)
+		},
+	}
+	cmd.Flags().StringVar(&o.apiServer, "api-server", "", "API server without authentication, e.g. http://127.0.0.1:8001 from kubectl proxy (default in-cluster)")
+	cmd.Flags().StringVar(&o.namespace, "namespace", "", "Only manage fleets of this namespace (default all)")
+	cmd.Flags().StringVar(&o.image, "image", "cisco-mdt-generator:latest", "Simulator image of fleets that set none")
+	cmd.Flags().DurationVar(&o.resync, "resync", 10*time.Second, "Interval between reconciliations of every fleet")
+	return cmd
+}
+
+// runOperator reconciles every fleet each resync interval until interrupted
+func runOperator(o operatorOptions) error {
+	client := kube.New(o.apiServer)
+	if o.apiServer == "" {
+		var err error
+		if client, err = kube.InCluster(); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	scope := "all namespaces"
+	if o.namespace != "" {
+		scope = "namespace " + o.namespace
+	}
+	log.Printf("Operator managing %s fleets in %s every %s", fleetKind, scope, o.resync)
+
+	ticker := time.NewTicker(o.resync)
+	defer ticker.Stop()
+	for {
+		if err := reconcileFleets(ctx, client, o); err != nil && ctx.Err() == nil {
+			log.Printf("Reconcile: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Interrupted, stopping")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcileFleets lists the fleets and reconciles each of them
+func reconcileFleets(ctx context.Context, client *kube.Client, o operatorOptions) error {
+	path := "/apis/" + fleetGroup + "/" + fleetVersion + "/" + fleetResource
+	if o.namespace != "" {
+		path = "/apis/" + fleetGroup + "/" + fleetVersion + "/namespaces/" + o.namespace + "/" + fleetResource
+	}
+	var list struct {
+		Items []Fleet `json:"items"`
+	}
+	if err := client.Get(ctx, path, &list); err != nil {
+		return fmt.Errorf("failed to list fleets: %w", err)
+	}
+
+	for _, f := range list.Items {
+		status := reconcileFleet(ctx, client, o, &f)
+		status.ObservedGeneration = f.Metadata.Generation
+		if status == f.Status {
+			continue
+		}
+		if status.Phase != f.Status.Phase {
+			log.Printf("Fleet %s/%s: %s %s", f.Metadata.Namespace, f.Metadata.Name, status.Phase, status.Message)
+		}
+		err := client.MergePatch(ctx, f.path()+"/status", map[string]any{"status": status})
+		if err != nil {
+			log.Printf("Fleet %s/%s: failed to update status: %v", f.Metadata.Namespace, f.Metadata.Name, err)
+		}
+	}
+	return nil
+}
+
+// reconcileFleet applies the objects of a fleet and returns its status
+func reconcileFleet(ctx context.Context, client *kube.Client, o operatorOptions, f *Fleet) FleetStatus {
+	spec := f.Spec.withDefaults(o.image)
+	status := FleetStatus{Members: spec.Members}
+
+	leafs, err := spec.check()
+	if err != nil {
+		status.Phase, status.Message = phaseInvalid, err.Error()
+		return status
+	}
+	status.Leafs = leafs
+
+	ns, name := f.Metadata.Namespace, f.Metadata.Name
+	type object struct {
+		path string
+		obj  map[string]any
+	}
+	objects := []object{
+		{"/api/v1/namespaces/" + ns + "/configmaps/" + name + "-config", f.configMap()},
+		{"/apis/apps/v1/namespaces/" + ns + "/deployments/" + name + "-leader", f.leaderDeployment(spec)},
+	}
+	if spec.Members > 1 {
+		objects = append(objects,
+			object{"/api/v1/namespaces/" + ns + "/services/" + name + "-leader", f.leaderService()},
+			object{"/apis/apps/v1/namespaces/" + ns + "/deployments/" + name + "-followers", f.followerDeployment(spec)},
+		)
+	} else {
+		// A fleet scaled down to one pod needs no cluster
+		for _, path := range []string{
+			"/apis/apps/v1/namespaces/" + ns + "/deployments/" + name + "-followers",
+			"/api/v1/namespaces/" + ns + "/services/" + name + "-leader",
+		} {
+			if err := client.Delete(ctx, path); err != nil {
+				status.Phase, status.Message = phasePending, err.Error()
+				return status
+			}
+		}
+	}
+	for _, obj := range objects {
+		if err := client.Apply(ctx, obj.path, fieldManager, obj.obj); err != nil {
+			status.Phase, status.Message = phasePending, fmt.Sprintf("failed to apply %s: %v", obj.path, err)
+			return status
+		}
+	}
+
+	// The leader only reports ready once every follower has joined
+	ready := func(deployment string) int {
+		var d struct {
+			Status struct {
+				ReadyReplicas int `json:"readyReplicas"`
+			} `json:"status"`
+		}
+		if err := client.Get(ctx, "/apis/apps/v1/namespaces/"+ns+"/deployments/"+deployment, &d); err != nil {
+			return 0
+		}
+		return d.Status.ReadyReplicas
+	}
+	status.ReadyMembers = ready(name + "-leader")
+	if spec.Members > 1 {
+		status.ReadyMembers += ready(name + "-followers")
+	}
+
+	switch {
+	case status.ReadyMembers == 0:
+		status.Phase, status.Message = phasePending, "waiting for the leader"
+	case status.ReadyMembers < spec.Members:
+		status.Phase, status.Message = phaseDegraded, fmt.Sprintf("%d of %d members ready", status.ReadyMembers, spec.Members)
+	default:
+		status.Phase = phaseRunning
+	}
+	return status
+}
+
+// path returns the API path of the fleet
+func (f *Fleet) path() string {
+	return "/apis/" + fleetGroup + "/" + fleetVersion + "/namespaces/" + f.Metadata.Namespace + "/" + fleetResource + "/" + f.Metadata.Name
+}
+
+// withDefaults fills in the settings a spec leaves out with the defaults of
+// the fleet command
+func (s FleetSpec) withDefaults(image string) FleetSpec {
+	if s.Image == "" {
+		s.Image = image
+	}
+	if s.Members == 0 {
+		s.Members = 1
+	}
+	if s.Leafs == 0 {
+		s.Leafs = 4
+	}
+	if s.First == 0 {
+		s.First = 101
+	}
+	if s.NodeFormat == "" {
+		s.NodeFormat = "leaf-%d"
+	}
+	if s.Interval == "" {
+		s.Interval = "5s"
+	}
+	return s
+}
+
+// check validates a spec the way the simulator will and returns the number
+// of leafs it simulates
+func (s FleetSpec) check() (int, error) {
+	if s.Collector == "" {
+		return 0, fmt.Errorf("collector is required")
+	}
+	if s.Members < 1 {
+		return 0, fmt.Errorf("members must be at least 1")
+	}
+	interval, err := time.ParseDuration(s.Interval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("interval %q is not a positive duration", s.Interval)
+	}
+	if s.Scenario != "" {
+		sc, err := ParseScenario([]byte(s.Scenario))
+		if err != nil {
+			return 0, fmt.Errorf("scenario: %w", err)
+		}
+		if sc.Script != "" && !s.AllowScripts {
+			return 0, fmt.Errorf("scenario has a script, which only runs with allowScripts")
+		}
+	}
+
+	var cfg *Config
+	if len(s.Auto) > 0 {
+		cfg, err = autoConfig(s.Auto)
+	} else {
+		cfg, err = ParseConfig([]byte(s.Config))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("config: %w", err)
+	}
+	o := fleetOptions{count: s.Leafs, first: s.First, nodeFormat: s.NodeFormat, template: s.Template}
+	if len(cfg.Nodes) > 0 {
+		o.template = ""
+	}
+	nodeIDs, err := o.fleetNodes(cfg)
+	if err != nil {
+		return 0, fmt.Errorf("config: %w", err)
+	}
+	if len(nodeIDs) < s.Members {
+		return 0, fmt.Errorf("%d leafs cannot be shared by %d members", len(nodeIDs), s.Members)
+	}
+	return len(nodeIDs), nil
+}
+
+// hash fingerprints the spec, so that pods restart when it changes
+func (s FleetSpec) hash() string {
+	data, _ := json.Marshal(s)
+	h := fnv.New64a()
+	h.Write(data)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// leaderArgs returns the command line of the fleet pod, or of the cluster
+// leader when several members share the leafs
+func (s FleetSpec) leaderArgs() []string {
+	args := []string{"fleet",
+		"--server", s.Collector,
+		"--interval", s.Interval,
+		"--count", strconv.Itoa(s.Leafs),
+		"--first", strconv.Itoa(s.First),
+		"--node-format", s.NodeFormat,
+	}
+	if s.Template != "" {
+		args = append(args, "--template", s.Template)
+	}
+	if s.FlapChance != nil {
+		args = append(args, "--flap-chance", strconv.FormatFloat(*s.FlapChance, 'g', -1, 64))
+	}
+	if len(s.Auto) > 0 {
+		var settings []string
+		for _, k := range slices.Sorted(maps.Keys(s.Auto)) {
+			settings = append(settings, k+"="+s.Auto[k])
+		}
+		args = append(args, "--auto", strings.Join(settings, ","))
+	} else {
+		args = append(args, "--config", configDir+"/generator.yaml")
+	}
+	if s.Scenario != "" {
+		args = append(args, "--scenario", configDir+"/scenario.yaml")
+	}
+	if s.AllowScripts {
+		args = append(args, "--allow-scripts")
+	}
+	if s.Members > 1 {
+		args = append(args, "--cluster-listen", fmt.Sprintf(":%d", clusterPort), "--followers", strconv.Itoa(s.Members-1))
+	}
+	return args
+}
+
+// metadata returns the metadata of an object owned by the fleet
+func (f *Fleet) metadata(name, role string) map[string]any {
+	return map[string]any{
+		"name":      name,
+		"namespace": f.Metadata.Namespace,
+		"labels":    f.labels(role),
+		"ownerReferences": []map[string]any{{
+			"apiVersion":         fleetGroup + "/" + fleetVersion,
+			"kind":               fleetKind,
+			"name":               f.Metadata.Name,
+			"uid":                f.Metadata.UID,
+			"controller":         true,
+			"blockOwnerDeletion": true,
+		}},
+	}
+}
+
+// labels identify the pods of one role of a fleet
+func (f *Fleet) labels(role string) map[string]any {
+	labels := map[string]any{
+		"app.kubernetes.io/name":       "cisco-mdt-generator",
+		"app.kubernetes.io/managed-by": fieldManager,
+		"mdtsim.io/fleet":              f.Metadata.Name,
+	}
+	if role != "" {
+		labels["mdtsim.io/role"] = role
+	}
+	return labels
+}
+
+// configMap holds the configuration and scenario files of the leader
+func (f *Fleet) configMap() map[string]any {
+	data := map[string]any{"generator.yaml": f.Spec.Config}
+	if f.Spec.Scenario != "" {
+		data["scenario.yaml"] = f.Spec.Scenario
+	}
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   f.metadata(f.Metadata.Name+"-config", ""),
+		"data":       data,
+	}
+}
+
+// leaderDeployment runs the fleet pod or cluster leader. Recreate keeps two
+// leaders from competing for the followers during a rollout.
+func (f *Fleet) leaderDeployment(s FleetSpec) map[string]any {
+	container := map[string]any{
+		"name":         "simulator",
+		"image":        s.Image,
+		"args":         s.leaderArgs(),
+		"volumeMounts": []map[string]any{{"name": "config", "mountPath": configDir, "readOnly": true}},
+	}
+	if s.Members > 1 {
+		container["ports"] = []map[string]any{{"name": "cluster", "containerPort": clusterPort}}
+		container["readinessProbe"] = map[string]any{
+			"grpc":          map[string]any{"port": clusterPort},
+			"periodSeconds": 5,
+		}
+	}
+	pod := map[string]any{
+		"metadata": map[string]any{
+			"labels":      f.labels("leader"),
+			"annotations": map[string]any{"mdtsim.io/spec-hash": s.hash()},
+		},
+		"spec": map[string]any{
+			"containers": []map[string]any{container},
+			"volumes": []map[string]any{{
+				"name":      "config",
+				"configMap": map[string]any{"name": f.Metadata.Name + "-config"},
+			}},
+		},
+	}
+	return f.deployment(f.Metadata.Name+"-leader", "leader", 1, pod, "Recreate")
+}
+
+// followerDeployment runs the followers, which get everything else from
+// the leader
+func (f *Fleet) followerDeployment(s FleetSpec) map[string]any {
+	pod := map[string]any{
+		"metadata": map[string]any{"labels": f.labels("follower")},
+		"spec": map[string]any{
+			"containers": []map[string]any{{
+				"name":  "simulator",
+				"image": s.Image,
+				"args":  []string{"fleet", "--join", fmt.Sprintf("%s-leader:%d", f.Metadata.Name, clusterPort)},
+			}},
+		},
+	}
+	return f.deployment(f.Metadata.Name+"-followers", "follower", s.Members-1, pod, "RollingUpdate")
+}
+
+func (f *Fleet) deployment(name, role string, replicas int, pod map[string]any, strategy string) map[string]any {
+	return map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   f.metadata(name, role),
+		"spec": map[string]any{
+			"replicas": replicas,
+			"selector": map[string]any{"matchLabels": f.labels(role)},
+			"strategy": map[string]any{"type": strategy},
+			"template": pod,
+		},
+	}
+}
+
+// leaderService gives followers a stable address of the leader
+func (f *Fleet) leaderService() map[string]any {
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   f.metadata(f.Metadata.Name+"-leader", "leader"),
+		"spec": map[string]any{
+			"selector": f.labels("leader"),
+			"ports":    []map[string]any{{"name": "cluster", "port": clusterPort, "targetPort": clusterPort}},
+		},
+	}
+}
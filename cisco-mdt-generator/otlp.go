@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"cisco-mdt-generator/pkg/otlp"
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// cumulativeFields are the content fields exported as monotonic sums; every
+// other numeric field is a gauge
+var cumulativeFields = map[string]bool{
+	"ingress-bytes":                     true,
+	"egress-bytes":                      true,
+	"flap-count":                        true,
+	"type2-updates":                     true,
+	"mac-moves":                         true,
+	"cache-hits":                        true,
+	"requests-flooded":                  true,
+	"requests-suppressed":               true,
+	"in-octets":                         true,
+	"in-ucast-pkts":                     true,
+	"in-bcast-pkts":                     true,
+	"in-mcast-pkts":                     true,
+	"in-unknown-ucast-pkts":             true,
+	"storm-control-bcast-drops":         true,
+	"storm-control-mcast-drops":         true,
+	"storm-control-unknown-ucast-drops": true,
+	"storm-control-total-drops":         true,
+	"conformed-packets":                 true,
+	"violated-packets":                  true,
+	"slow-sends":                        true,
+	"telemetry-drops":                   true,
+}
+
+// metricUnit derives a UCUM unit from a field name
+func metricUnit(field string) string {
+	switch {
+	case strings.HasSuffix(field, "-bytes"), strings.HasSuffix(field, "-octets"):
+		return "By"
+	case strings.HasSuffix(field, "-pkts"), strings.HasSuffix(field, "-packets"), strings.HasSuffix(field, "-drops"):
+		return "{packet}"
+	case strings.HasSuffix(field, "-percent"):
+		return "%"
+	case strings.HasSuffix(field, "-seconds"):
+		return "s"
+	case strings.HasSuffix(field, "-ms"):
+		return "ms"
+	}
+	return "1"
+}
+
+// OTLPSink exports the numeric content fields of telemetry as OpenTelemetry
+// metrics named <prefix>.<subscription>.<field>, one resource per node, with
+// row keys as data point attributes
+type OTLPSink struct {
+	cfg    OTLPSinkConfig
+	client *http.Client
+	conn   *grpc.ClientConn
+
+	mu    sync.Mutex
+	start map[string]uint64 // first export per node, the start of cumulative sums
+}
+
+// NewOTLPSink creates a sink exporting over OTLP/HTTP (http:// or https://)
+// or plaintext OTLP/gRPC (grpc://)
+func NewOTLPSink(cfg OTLPSinkConfig) (*OTLPSink, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	s := &OTLPSink{cfg: cfg, start: make(map[string]uint64)}
+	switch u.Scheme {
+	case "http", "https":
+		s.client = &http.Client{Timeout: cfg.Timeout}
+	case "grpc":
+		s.conn, err = grpc.NewClient(u.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported endpoint scheme %q", u.Scheme)
+	}
+	return s, nil
+}
+
+func (s *OTLPSink) Name() string { return "otlp" }
+
+// Write exports one request per call
+func (s *OTLPSink) Write(messages []*telemetry.Telemetry) error {
+	req := s.buildRequest(messages)
+	if len(req.ResourceMetrics) == 0 {
+		return nil
+	}
+	body, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if s.conn != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+		defer cancel()
+		for k, v := range s.cfg.Headers {
+			ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(k), v)
+		}
+		return s.conn.Invoke(ctx, otlp.ExportMethod, &rawMessage{data: body}, &rawMessage{})
+	}
+	return s.post(body)
+}
+
+// post sends an export request over OTLP/HTTP
+func (s *OTLPSink) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.cfg.Endpoint, "/")+otlp.ExportPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("export failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *OTLPSink) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// buildRequest maps a round of telemetry to metrics, grouped by node
+func (s *OTLPSink) buildRequest(messages []*telemetry.Telemetry) *otlp.ExportMetricsServiceRequest {
+	req := &otlp.ExportMetricsServiceRequest{}
+	byNode := make(map[string]*otlp.ScopeMetrics)
+
+	for _, m := range messages {
+		scope, ok := byNode[m.NodeIDStr]
+		if !ok {
+			scope = &otlp.ScopeMetrics{ScopeName: "cisco-mdt-generator", ScopeVersion: version}
+			byNode[m.NodeIDStr] = scope
+			req.ResourceMetrics = append(req.ResourceMetrics, &otlp.ResourceMetrics{
+				Attributes:   s.resourceAttributes(m.NodeIDStr),
+				ScopeMetrics: []*otlp.ScopeMetrics{scope},
+			})
+		}
+		scope.Metrics = append(scope.Metrics, s.buildMetrics(m)...)
+	}
+	return req
+}
+
+// resourceAttributes describes a node, adding the configured attributes
+// such as the site
+func (s *OTLPSink) resourceAttributes(nodeID string) []*otlp.KeyValue {
+	attrs := []*otlp.KeyValue{
+		otlp.String("service.name", "cisco-mdt-generator"),
+		otlp.String("host.name", nodeID),
+		otlp.String("node", nodeID),
+	}
+	keys := make([]string, 0, len(s.cfg.ResourceAttributes))
+	for k := range s.cfg.ResourceAttributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, otlp.String(k, s.cfg.ResourceAttributes[k]))
+	}
+	return attrs
+}
+
+// startTime returns the start of cumulative sums of a node
+func (s *OTLPSink) startTime(nodeID string, ts uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if start, ok := s.start[nodeID]; ok {
+		return start
+	}
+	s.start[nodeID] = ts
+	return ts
+}
+
+// buildMetrics creates one metric per numeric content field of a message
+// with a data point per row
+func (s *OTLPSink) buildMetrics(m *telemetry.Telemetry) []*otlp.Metric {
+	var metrics []*otlp.Metric
+	byName := make(map[string]*otlp.Metric)
+
+	for _, row := range m.DataGpbkv {
+		ts := row.Timestamp
+		if ts == 0 {
+			ts = m.MsgTimestamp
+		}
+		nanos := ts * 1e6
+
+		var attrs []*otlp.KeyValue
+		var content []*telemetry.TelemetryField
+		for _, section := range row.Fields {
+			switch section.Name {
+			case "keys":
+				for _, k := range section.Fields {
+					if v, ok := numericValue(k); ok && k.DoubleValue == nil && k.FloatValue == nil {
+						attrs = append(attrs, otlp.Int(k.Name, int64(v)))
+					} else {
+						attrs = append(attrs, otlp.String(k.Name, fieldString(k)))
+					}
+				}
+			case "content":
+				content = section.Fields
+			}
+		}
+
+		for _, f := range content {
+			point := &otlp.NumberDataPoint{Attributes: attrs, TimeUnixNano: nanos}
+			switch {
+			case f.DoubleValue != nil:
+				point.AsDouble = f.DoubleValue
+			case f.FloatValue != nil:
+				v := float64(*f.FloatValue)
+				point.AsDouble = &v
+			case f.BoolValue != nil:
+				var v int64
+				if *f.BoolValue {
+					v = 1
+				}
+				point.AsInt = &v
+			case f.Uint32Value != nil:
+				v := int64(*f.Uint32Value)
+				point.AsInt = &v
+			case f.Uint64Value != nil:
+				v := int64(min(*f.Uint64Value, math.MaxInt64))
+				point.AsInt = &v
+			case f.Sint32Value != nil:
+				v := int64(*f.Sint32Value)
+				point.AsInt = &v
+			case f.Sint64Value != nil:
+				point.AsInt = f.Sint64Value
+			default:
+				continue
+			}
+
+			name := s.cfg.MetricPrefix + "." + m.SubscriptionIDStr + "." + strings.ReplaceAll(f.Name, "-", "_")
+			metric, ok := byName[name]
+			if !ok {
+				metric = &otlp.Metric{Name: name, Unit: metricUnit(f.Name)}
+				if cumulativeFields[f.Name] {
+					metric.Sum = true
+					metric.Monotonic = true
+				}
+				byName[name] = metric
+				metrics = append(metrics, metric)
+			}
+			if metric.Sum {
+				point.StartTimeUnixNano = s.startTime(m.NodeIDStr, nanos)
+			}
+			metric.DataPoints = append(metric.DataPoints, point)
+		}
+	}
+	return metrics
+}
+
+// rawMessage is a helper for sending pre-encoded protobuf data
+type rawMessage struct {
+	data []byte
+}
+
+func (m *rawMessage) Reset()         {}
+func (m *rawMessage) String() string { return string(m.data) }
+func (m *rawMessage) ProtoMessage()  {}
+
+func (m *rawMessage) Marshal() ([]byte, error) {
+	return m.data, nil
+}
+
+func (m *rawMessage) Unmarshal(b []byte) error {
+	m.data = b
+	return nil
+}
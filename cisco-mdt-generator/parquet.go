@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/parquet"
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// ParquetSink archives decoded telemetry rows into Parquet files, one per
+// encoding path and hour, under Hive-style path=<path>/hour=<hour>
+// directories. Each file's schema is fixed by its first row group: columns
+// for the timestamp, node and subscription, then every key and content leaf.
+type ParquetSink struct {
+	cfg   ParquetSinkConfig
+	runID string
+
+	mu     sync.Mutex
+	parts  map[string]*parquetPartition
+	latest time.Time // newest hour written
+	seq    int
+}
+
+// parquetPartition is an open file of one path and hour
+type parquetPartition struct {
+	path    string // final file name, written as path.tmp until closed
+	hour    time.Time
+	file    *os.File
+	buf     *bufio.Writer
+	writer  *parquet.Writer
+	index   map[string]int
+	rows    [][]parquetLeaf
+	dropped int // leaves missing from, or not matching, the file's schema
+}
+
+// parquetLeaf is a named value of a row
+type parquetLeaf struct {
+	name  string
+	value any
+}
+
+// NewParquetSink creates a sink writing under cfg.Dir
+func NewParquetSink(cfg ParquetSinkConfig) (*ParquetSink, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &ParquetSink{
+		cfg:   cfg,
+		runID: time.Now().UTC().Format("20060102T150405"),
+		parts: make(map[string]*parquetPartition),
+	}, nil
+}
+
+func (s *ParquetSink) Name() string { return "parquet" }
+
+// Write buffers rows into their partitions, writing a row group whenever
+// row_group_size rows are buffered. Partitions of hours before the newest
+// one are closed.
+func (s *ParquetSink) Write(messages []*telemetry.Telemetry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, m := range messages {
+		for _, row := range m.DataGpbkv {
+			ts := row.Timestamp
+			if ts == 0 {
+				ts = m.MsgTimestamp
+			}
+			at := time.UnixMilli(int64(ts)).UTC()
+			hour := at.Truncate(time.Hour)
+			if hour.After(s.latest) {
+				s.latest = hour
+			}
+
+			p := s.partition(m.EncodingPath, hour)
+			p.rows = append(p.rows, parquetRow(m, row, at))
+			if len(p.rows) >= s.cfg.RowGroupSize {
+				if err := p.flush(); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+
+	for key, p := range s.parts {
+		if p.hour.Before(s.latest) {
+			if err := p.close(); err != nil {
+				errs = append(errs, err)
+			}
+			delete(s.parts, key)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// partition returns the open partition of a path and hour
+func (s *ParquetSink) partition(encodingPath string, hour time.Time) *parquetPartition {
+	key := encodingPath + "|" + hour.Format(time.RFC3339)
+	if p, ok := s.parts[key]; ok {
+		return p
+	}
+
+	dir := filepath.Join(s.cfg.Dir,
+		"path="+strings.NewReplacer("/", "_", ":", "_").Replace(encodingPath),
+		"hour="+hour.Format("2006-01-02T15"))
+	s.seq++
+	p := &parquetPartition{
+		path: filepath.Join(dir, fmt.Sprintf("part-%s-%04d.parquet", s.runID, s.seq)),
+		hour: hour,
+	}
+	s.parts[key] = p
+	return p
+}
+
+// Close writes the remaining rows and footers of every open file
+func (s *ParquetSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for key, p := range s.parts {
+		if err := p.close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(s.parts, key)
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group, creating the file and its
+// schema from them on the first call
+func (p *parquetPartition) flush() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	if p.writer == nil {
+		if err := p.create(); err != nil {
+			p.rows = nil
+			return err
+		}
+	}
+
+	columns := p.writer.Columns()
+	rows := make([][]any, len(p.rows))
+	for i, leaves := range p.rows {
+		row := make([]any, len(columns))
+		for _, leaf := range leaves {
+			j, ok := p.index[leaf.name]
+			if !ok {
+				p.dropped++
+				continue
+			}
+			if row[j], ok = parquetValue(columns[j].Type, leaf.value); !ok {
+				row[j] = nil
+				p.dropped++
+			}
+		}
+		rows[i] = row
+	}
+	p.rows = p.rows[:0]
+	return p.writer.WriteRowGroup(rows)
+}
+
+// create opens the temporary file and derives the schema from the buffered
+// rows, in order of first appearance. A leaf seen with different types is
+// stored as a string.
+func (p *parquetPartition) create() error {
+	var columns []parquet.Column
+	p.index = make(map[string]int)
+	for _, leaves := range p.rows {
+		for _, leaf := range leaves {
+			typ := parquetType(leaf.value)
+			if j, ok := p.index[leaf.name]; ok {
+				if columns[j].Type != typ {
+					columns[j].Type = parquet.String
+				}
+				continue
+			}
+			p.index[leaf.name] = len(columns)
+			columns = append(columns, parquet.Column{Name: leaf.name, Type: typ})
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(p.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	p.file = f
+	p.buf = bufio.NewWriter(f)
+	p.writer, err = parquet.NewWriter(p.buf, columns, "cisco-mdt-generator version "+version)
+	return err
+}
+
+// close flushes the partition, writes the footer and renames the file into
+// place, so readers never see an incomplete file
+func (p *parquetPartition) close() error {
+	err := p.flush()
+	if p.writer == nil {
+		return err
+	}
+	if err == nil {
+		err = p.writer.Close()
+	}
+	if err == nil {
+		err = p.buf.Flush()
+	}
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.path, err)
+	}
+	if p.dropped > 0 {
+		log.Printf("parquet sink: %s: dropped %d values not matching the schema of the first row group", p.path, p.dropped)
+	}
+	return os.Rename(p.path+".tmp", p.path)
+}
+
+// parquetRow flattens a row into leaves named by their slash-joined path,
+// after the timestamp, node and subscription columns
+func parquetRow(m *telemetry.Telemetry, row *telemetry.TelemetryField, at time.Time) []parquetLeaf {
+	leaves := []parquetLeaf{
+		{"timestamp", at},
+		{"node", m.NodeIDStr},
+		{"subscription", m.SubscriptionIDStr},
+	}
+	for _, section := range row.Fields {
+		switch section.Name {
+		case "keys", "content":
+			for _, f := range section.Fields {
+				leaves = flattenParquet(f, "", leaves)
+			}
+		}
+	}
+	return leaves
+}
+
+func flattenParquet(f *telemetry.TelemetryField, prefix string, leaves []parquetLeaf) []parquetLeaf {
+	name := f.Name
+	if prefix != "" {
+		name = prefix + "/" + name
+	}
+	if len(f.Fields) > 0 {
+		for _, child := range f.Fields {
+			leaves = flattenParquet(child, name, leaves)
+		}
+		return leaves
+	}
+	if v := fieldJSON(f); v != nil {
+		leaves = append(leaves, parquetLeaf{name, v})
+	}
+	return leaves
+}
+
+// parquetType maps a decoded leaf to a column type
+func parquetType(v any) parquet.Type {
+	switch v.(type) {
+	case time.Time:
+		return parquet.TimestampMillis
+	case bool:
+		return parquet.Bool
+	case uint32, int32, int64:
+		return parquet.Int64
+	case uint64:
+		return parquet.Uint64
+	case float32, float64:
+		return parquet.Double
+	}
+	return parquet.String
+}
+
+// parquetValue converts a decoded leaf to the value of a column type. It
+// reports false for a value the column cannot hold.
+func parquetValue(typ parquet.Type, v any) (any, bool) {
+	switch typ {
+	case parquet.String:
+		if s, ok := v.(string); ok {
+			return s, true
+		}
+		return fmt.Sprint(v), true
+	case parquet.TimestampMillis:
+		t, ok := v.(time.Time)
+		return t.UnixMilli(), ok
+	case parquet.Int64:
+		switch n := v.(type) {
+		case uint32:
+			return int64(n), true
+		case int32:
+			return int64(n), true
+		case int64:
+			return n, true
+		}
+	case parquet.Double:
+		switch f := v.(type) {
+		case float32:
+			return float64(f), true
+		case float64:
+			return f, true
+		}
+	case parquet.Uint64, parquet.Bool:
+		return v, parquetType(v) == typ
+	}
+	return nil, false
+}
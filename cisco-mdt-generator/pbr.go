@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// PBRPolicy is a policy-based routing route-map applied to an interface.
+// Each entry redirects the traffic its ACL matches to the first of its next
+// hops that is up; with none up the traffic is routed normally.
+type PBRPolicy struct {
+	Name      string
+	Interface string
+	Entries   []*PBREntry
+}
+
+// PBREntry is a sequence of a PBR route-map
+type PBREntry struct {
+	Seq      uint32
+	Match    string
+	PPS      uint64
+	PktSize  uint64
+	NextHops []*PBRNextHop
+
+	MatchedPkts  uint64
+	MatchedBytes uint64
+	FallbackPkts uint64 // routed normally while every next hop was down
+}
+
+// PBRNextHop is a tracked next hop of a PBR entry
+type PBRNextHop struct {
+	Address     string
+	Up          bool
+	Transitions uint32
+	Pkts        uint64
+	Bytes       uint64
+}
+
+// initPBRFromConfig creates the PBR policies of the node
+func initPBRFromConfig(cfg *Config) []*PBRPolicy {
+	if !cfg.PBR.Enabled {
+		return nil
+	}
+	var policies []*PBRPolicy
+	for _, pc := range cfg.PBR.Policies {
+		p := &PBRPolicy{Name: pc.Name, Interface: pc.Interface}
+		for _, ec := range pc.Entries {
+			e := &PBREntry{Seq: ec.Seq, Match: ec.Match, PPS: ec.PPS, PktSize: ec.PacketSize}
+			if e.PktSize == 0 {
+				e.PktSize = 600
+			}
+			for _, addr := range ec.NextHops {
+				e.NextHops = append(e.NextHops, &PBRNextHop{Address: addr, Up: true})
+			}
+			p.Entries = append(p.Entries, e)
+		}
+		policies = append(policies, p)
+	}
+	return policies
+}
+
+// active returns the next hop the entry redirects to, or nil when every
+// next hop is down
+func (e *PBREntry) active() *PBRNextHop {
+	for _, nh := range e.NextHops {
+		if nh.Up {
+			return nh
+		}
+	}
+	return nil
+}
+
+// findPBRNextHops returns every PBR next hop with the given address
+func (s *Simulator) findPBRNextHops(address string) []*PBRNextHop {
+	var hops []*PBRNextHop
+	for _, p := range s.PBR {
+		for _, e := range p.Entries {
+			for _, nh := range e.NextHops {
+				if nh.Address == address {
+					hops = append(hops, nh)
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// stepPBR counts the traffic every entry matches on the active next hop
+func (s *Simulator) stepPBR(seconds float64) {
+	for _, p := range s.PBR {
+		for _, e := range p.Entries {
+			pkts := uint64(float64(e.PPS) * seconds * (0.9 + s.rng.Float64()*0.2))
+			e.MatchedPkts += pkts
+			e.MatchedBytes += pkts * e.PktSize
+			if nh := e.active(); nh != nil {
+				nh.Pkts += pkts
+				nh.Bytes += pkts * e.PktSize
+			} else {
+				e.FallbackPkts += pkts
+			}
+		}
+	}
+}
+
+// SetPBRNextHop marks a PBR next hop up or down. Entries using it move their
+// traffic to their next next hop, or back to it.
+func (s *Simulator) SetPBRNextHop(address string, up bool) error {
+	hops := s.findPBRNextHops(address)
+	if len(hops) == 0 {
+		return fmt.Errorf("unknown PBR next hop %s", address)
+	}
+	for _, nh := range hops {
+		if nh.Up != up {
+			nh.Up = up
+			nh.Transitions++
+		}
+	}
+	if up {
+		s.event("pbr_next_hop_up", address, "PBR next hop %s UP, redirecting to it again", address)
+	} else {
+		s.event("pbr_next_hop_down", address, "PBR next hop %s DOWN, redirecting to the next next hop", address)
+	}
+	return nil
+}
+
+// checkPBRNextHopTarget ensures an event targets a PBR next hop
+func checkPBRNextHopTarget(s *Simulator, ev ScenarioEvent) error {
+	if len(s.findPBRNextHops(ev.Target)) == 0 {
+		return fmt.Errorf("unknown PBR next hop %q", ev.Target)
+	}
+	return nil
+}
+
+// pbrNextHopDownAction is the pbr_next_hop_down scenario action
+var pbrNextHopDownAction = scenarioAction{
+	check: checkPBRNextHopTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetPBRNextHop(ev.Target, false)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetPBRNextHop(ev.Target, true)
+	},
+}
+
+// checkPBR ensures the PBR policies are usable
+func checkPBR(cfg PBRConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if len(cfg.Policies) == 0 {
+		return fmt.Errorf("at least one policy must be configured")
+	}
+	for _, p := range cfg.Policies {
+		if p.Name == "" || p.Interface == "" {
+			return fmt.Errorf("policies need a name and an interface")
+		}
+		seqs := make(map[uint32]bool)
+		for _, e := range p.Entries {
+			if e.Seq == 0 || seqs[e.Seq] {
+				return fmt.Errorf("policy %s: entries need unique non-zero seq numbers", p.Name)
+			}
+			seqs[e.Seq] = true
+			if len(e.NextHops) == 0 {
+				return fmt.Errorf("policy %s seq %d: at least one next hop must be configured", p.Name, e.Seq)
+			}
+			for _, nh := range e.NextHops {
+				if net.ParseIP(nh) == nil {
+					return fmt.Errorf("policy %s seq %d: next hop %q is not an IP address", p.Name, e.Seq, nh)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// buildPBRTelemetry reports the hits of every PBR route-map entry
+func buildPBRTelemetry(ts uint64, nodeID string, policies []*PBRPolicy) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, p := range policies {
+		for _, e := range p.Entries {
+			active := ""
+			if nh := e.active(); nh != nil {
+				active = nh.Address
+			}
+			row := telemetry.RowField(
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("route-map", p.Name, ts),
+					telemetry.Uint32Field("seq", e.Seq, ts),
+				},
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("interface", p.Interface, ts),
+					telemetry.StringField("match-acl", e.Match, ts),
+					telemetry.StringField("active-next-hop", active, ts),
+					telemetry.Uint64Field("matched-packets", e.MatchedPkts, ts),
+					telemetry.Uint64Field("matched-bytes", e.MatchedBytes, ts),
+					telemetry.Uint64Field("fallback-packets", e.FallbackPkts, ts),
+				},
+				ts,
+			)
+			rows = append(rows, row)
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "pbr_stats",
+		EncodingPath:        "Cisco-NX-OS-device:System/rpm-items/rtmap-items/Rule-list/ent-items/Entry-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildPBRNextHopTelemetry reports the health and redirected traffic of
+// every PBR next hop
+func buildPBRNextHopTelemetry(ts uint64, nodeID string, policies []*PBRPolicy) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, p := range policies {
+		for _, e := range p.Entries {
+			for i, nh := range e.NextHops {
+				state, code := "down", uint32(0)
+				if nh.Up {
+					state, code = "up", 1
+				}
+				row := telemetry.RowField(
+					[]*telemetry.TelemetryField{
+						telemetry.StringField("route-map", p.Name, ts),
+						telemetry.Uint32Field("seq", e.Seq, ts),
+						telemetry.StringField("next-hop", nh.Address, ts),
+					},
+					[]*telemetry.TelemetryField{
+						telemetry.Uint32Field("preference", uint32(i+1), ts),
+						telemetry.StringField("state", state, ts),
+						telemetry.Uint32Field("state-code", code, ts),
+						telemetry.Uint32Field("transitions", nh.Transitions, ts),
+						telemetry.Uint64Field("redirected-packets", nh.Pkts, ts),
+						telemetry.Uint64Field("redirected-bytes", nh.Bytes, ts),
+					},
+					ts,
+				)
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "pbr_next_hops",
+		EncodingPath:        "Cisco-NX-OS-device:System/pbr-items/nh-items/NextHop-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
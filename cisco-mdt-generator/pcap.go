@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/mdt_dialout"
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// PcapSink writes the telemetry of every node into a pcap file as the
+// packets of a gRPC dial-out, so Wireshark, tshark or tcpdump can inspect
+// it offline. Each node gets a synthetic TCP connection from its own address
+// to the collector: the handshake, the HTTP/2 preface and the request
+// headers of MdtDialout, then a DATA frame per MdtDialoutArgs message.
+type PcapSink struct {
+	cfg       PcapSinkConfig
+	collector *net.TCPAddr
+
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	conns map[string]*pcapConn
+}
+
+// pcapConn is the synthetic TCP connection of one node
+type pcapConn struct {
+	ip    net.IP
+	port  uint16
+	reqID int64
+	seq   uint32 // next sequence number of the node
+	ack   uint32 // of the collector, which sends nothing after the handshake
+	last  time.Time
+}
+
+// Link and frame constants of the synthetic packets
+const (
+	pcapLinkEthernet = 1
+	pcapSnapLen      = 262144
+	http2MaxFrame    = 16384 // default SETTINGS_MAX_FRAME_SIZE
+	tcpFlagFIN       = 0x01
+	tcpFlagSYN       = 0x02
+	tcpFlagPSH       = 0x08
+	tcpFlagACK       = 0x10
+)
+
+// http2Preface starts every HTTP/2 connection
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// NewPcapSink creates the pcap file and writes its header
+func NewPcapSink(cfg PcapSinkConfig) (*PcapSink, error) {
+	collector, err := net.ResolveTCPAddr("tcp4", cfg.Collector)
+	if err != nil || collector.IP == nil {
+		return nil, fmt.Errorf("collector %q must be an IPv4 address and port", cfg.Collector)
+	}
+	f, err := os.Create(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	s := &PcapSink{cfg: cfg, collector: collector, file: f, w: bufio.NewWriter(f), conns: make(map[string]*pcapConn)}
+
+	// Classic pcap header: magic, version 2.4, UTC, accuracy, snap length
+	// and link type
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkEthernet)
+	if _, err := s.w.Write(hdr[:]); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *PcapSink) Name() string { return "pcap" }
+
+// PayloadSize returns the bytes a message takes in the encoding of the
+// capture
+func (s *PcapSink) PayloadSize(m *telemetry.Telemetry) int {
+	return encodedSize(s.cfg.Encoding, m)
+}
+
+// Write appends the packets of every message at its timestamp, opening the
+// connection of a node the first time it sends
+func (s *PcapSink) Write(messages []*telemetry.Telemetry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range messages {
+		payload, err := encodeTelemetry(s.cfg.Encoding, m)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.SubscriptionIDStr, err)
+		}
+		at := time.UnixMilli(int64(m.MsgTimestamp))
+		c, ok := s.conns[m.NodeIDStr]
+		if !ok {
+			c = s.newConn(m.NodeIDStr)
+			if err := s.open(c, at); err != nil {
+				return err
+			}
+		}
+
+		data, _ := (&mdt_dialout.MdtDialoutArgs{ReqId: c.reqID, Data: payload}).Marshal()
+		// gRPC length-prefixed message, in DATA frames of at most the
+		// default frame size
+		msg := make([]byte, 5, 5+len(data))
+		binary.BigEndian.PutUint32(msg[1:], uint32(len(data)))
+		msg = append(msg, data...)
+		for len(msg) > 0 {
+			n := min(len(msg), http2MaxFrame)
+			if err := s.segment(c, at, tcpFlagPSH|tcpFlagACK, true, http2Frame(0x0, 0, 1, msg[:n])); err != nil {
+				return err
+			}
+			msg = msg[n:]
+		}
+	}
+	return s.w.Flush()
+}
+
+// newConn assigns a node the next address of 198.18.0.0/15, the range for
+// benchmark traffic, and a ReqId derived from its name
+func (s *PcapSink) newConn(nodeID string) *pcapConn {
+	n := len(s.conns) + 1
+	h := fnv.New64a()
+	h.Write([]byte(nodeID))
+	c := &pcapConn{
+		ip:    net.IPv4(198, 18+byte(n>>16&1), byte(n>>8), byte(n)).To4(),
+		port:  49152 + uint16(n%16384),
+		reqID: int64(h.Sum64() >> 1),
+		seq:   1000,
+		ack:   5000,
+	}
+	s.conns[nodeID] = c
+	return c
+}
+
+// open writes the TCP handshake of a connection, the HTTP/2 preface with
+// empty settings and the request headers of the MdtDialout stream
+func (s *PcapSink) open(c *pcapConn, at time.Time) error {
+	if err := s.segment(c, at, tcpFlagSYN, true, nil); err != nil {
+		return err
+	}
+	if err := s.segment(c, at, tcpFlagSYN|tcpFlagACK, false, nil); err != nil {
+		return err
+	}
+	if err := s.segment(c, at, tcpFlagACK, true, nil); err != nil {
+		return err
+	}
+
+	var headers []byte
+	for _, h := range [][2]string{
+		{":method", "POST"},
+		{":scheme", "http"},
+		{":path", "/mdt_dialout.gRPCMdtDialout/MdtDialout"},
+		{":authority", s.cfg.Collector},
+		{"content-type", "application/grpc"},
+		{"te", "trailers"},
+	} {
+		headers = hpackLiteral(headers, h[0], h[1])
+	}
+	preface := append([]byte(http2Preface), http2Frame(0x4, 0, 0, nil)...)
+	preface = append(preface, http2Frame(0x1, 0x4, 1, headers)...) // END_HEADERS
+	return s.segment(c, at, tcpFlagPSH|tcpFlagACK, true, preface)
+}
+
+// Close ends every connection with a FIN after its last message and closes
+// the file
+func (s *PcapSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		if err := s.segment(c, c.last, tcpFlagFIN|tcpFlagACK, true, nil); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// segment writes one TCP segment of a connection as a packet, from the node
+// to the collector or back, and advances the sequence numbers
+func (s *PcapSink) segment(c *pcapConn, at time.Time, flags byte, fromNode bool, payload []byte) error {
+	src, dst := c.ip, s.collector.IP.To4()
+	srcPort, dstPort := c.port, uint16(s.collector.Port)
+	seq, ack := c.seq, c.ack
+	if !fromNode {
+		src, dst, srcPort, dstPort, seq, ack = dst, src, dstPort, srcPort, ack, seq
+	}
+
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	if flags&tcpFlagACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], ack)
+	}
+	tcp[12] = 5 << 4 // data offset
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	tcp = append(tcp, payload...)
+	binary.BigEndian.PutUint16(tcp[16:], tcpChecksum(src, dst, tcp))
+
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 0x45 // IPv4, 20-byte header
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64                                 // TTL
+	ip[9] = 6                                  // TCP
+	copy(ip[12:], src)
+	copy(ip[16:], dst)
+	binary.BigEndian.PutUint16(ip[10:], internetChecksum(ip, 0))
+	ip = append(ip, tcp...)
+
+	// Ethernet with locally administered addresses derived from the IPs
+	frame := make([]byte, 14, 14+len(ip))
+	copy(frame[0:], []byte{0x02, 0x00, dst[0], dst[1], dst[2], dst[3]})
+	copy(frame[6:], []byte{0x02, 0x00, src[0], src[1], src[2], src[3]})
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	frame = append(frame, ip...)
+
+	var rec [16]byte
+	binary.LittleEndian.PutUint32(rec[0:], uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(frame)))
+	if _, err := s.w.Write(rec[:]); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+
+	// SYN and FIN count as one byte of the sequence
+	advance := uint32(len(payload))
+	if flags&(tcpFlagSYN|tcpFlagFIN) != 0 {
+		advance++
+	}
+	if fromNode {
+		c.seq += advance
+	} else {
+		c.ack += advance
+	}
+	c.last = at
+	return nil
+}
+
+// http2Frame builds an HTTP/2 frame of a type with flags on a stream
+func http2Frame(typ, flags byte, stream uint32, payload []byte) []byte {
+	frame := make([]byte, 9, 9+len(payload))
+	frame[0], frame[1], frame[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	frame[3], frame[4] = typ, flags
+	binary.BigEndian.PutUint32(frame[5:], stream)
+	return append(frame, payload...)
+}
+
+// hpackLiteral appends a header as an HPACK literal without indexing and
+// without Huffman coding, which every decoder accepts
+func hpackLiteral(buf []byte, name, value string) []byte {
+	buf = append(buf, 0x00)
+	for _, s := range []string{name, value} {
+		buf = hpackInt(buf, len(s))
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+// hpackInt appends a string length as an HPACK integer with a 7-bit prefix
+func hpackInt(buf []byte, n int) []byte {
+	if n < 127 {
+		return append(buf, byte(n))
+	}
+	buf = append(buf, 127)
+	for n -= 127; n >= 128; n >>= 7 {
+		buf = append(buf, byte(n%128+128))
+	}
+	return append(buf, byte(n))
+}
+
+// tcpChecksum is the checksum of a TCP segment and its IPv4 pseudo header
+func tcpChecksum(src, dst net.IP, segment []byte) uint16 {
+	var sum uint32
+	for i := 0; i < 4; i += 2 {
+		sum += uint32(src[i])<<8 | uint32(src[i+1])
+		sum += uint32(dst[i])<<8 | uint32(dst[i+1])
+	}
+	sum += 6 + uint32(len(segment))
+	return internetChecksum(segment, sum)
+}
+
+// internetChecksum is the ones' complement sum of RFC 1071 over data,
+// starting from sum
+func internetChecksum(data []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// checkPcapSink ensures an enabled pcap sink has a file and an IPv4
+// collector
+func checkPcapSink(cfg PcapSinkConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	host, _, err := net.SplitHostPort(cfg.Collector)
+	if ip := net.ParseIP(host); err != nil || ip == nil || ip.To4() == nil {
+		return fmt.Errorf("collector %q must be an IPv4 address and port", cfg.Collector)
+	}
+	if cfg.Encoding != "" && !slices.Contains(encodings, cfg.Encoding) {
+		return fmt.Errorf("encoding must be one of %s", strings.Join(encodings, ", "))
+	}
+	return nil
+}
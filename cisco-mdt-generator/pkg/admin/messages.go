@@ -0,0 +1,612 @@
+// Package admin implements the simulator's gRPC admin service
+// This is a manual implementation matching admin.proto in this directory
+package admin
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// InjectEventRequest applies a scenario action immediately
+type InjectEventRequest struct {
+	Action     string
+	Target     string
+	DurationMs int64
+	Params     map[string]string
+}
+
+// InjectEventResponse is returned when an event was applied
+type InjectEventResponse struct{}
+
+// GetStateRequest requests a state snapshot
+type GetStateRequest struct{}
+
+// BGPNeighborState is the state of a simulated BGP neighbor
+type BGPNeighborState struct {
+	Address          string
+	RemoteAS         uint32
+	State            string
+	StateCode        uint32
+	PrefixesReceived uint32
+	PrefixesSent     uint32
+	FlapCount        uint32
+	Maintenance      bool
+}
+
+// EVPNState holds EVPN route counts
+type EVPNState struct {
+	Type2Routes uint32
+	Type3Routes uint32
+	Type5Routes uint32
+	TotalRoutes uint32
+}
+
+// VNIState is the state of a simulated VNI
+type VNIState struct {
+	VNIID          uint32
+	State          string
+	MACCount       uint32
+	VTEPCount      uint32
+	ARPCount       uint32
+	ARPSuppression bool
+}
+
+// SubscriptionState reports the encoding failures of a subscription
+type SubscriptionState struct {
+	Subscription string
+	EncodeErrors uint64
+	LastError    string
+	Disabled     bool
+}
+
+// SimulatorState is a snapshot of the simulated device
+type SimulatorState struct {
+	NodeID        string
+	ElapsedMs     int64
+	IngressBytes  uint64
+	EgressBytes   uint64
+	BGPNeighbors  []*BGPNeighborState
+	EVPN          *EVPNState
+	VNIs          []*VNIState
+	CPUPercent    float64
+	Subscriptions []*SubscriptionState
+}
+
+// UpdateConfigRequest overlays simulation parameters from YAML
+type UpdateConfigRequest struct {
+	YAML string
+}
+
+// UpdateConfigResponse is returned when the configuration was applied
+type UpdateConfigResponse struct{}
+
+// StreamEventsRequest subscribes to simulation events
+type StreamEventsRequest struct{}
+
+// Event is a ground-truth simulation event
+type Event struct {
+	TimestampMs int64
+	Type        string
+	Target      string
+	Detail      string
+}
+
+// GetCaptureRequest requests the last messages sent
+type GetCaptureRequest struct {
+	Last uint32
+}
+
+// CapturedMessage is a message as it was sent on a dial-out stream
+type CapturedMessage struct {
+	TimestampMs  int64
+	Collector    string
+	ReqID        int64
+	Subscription string
+	EncodingPath string
+	Encoding     string
+	Rows         uint32
+	Data         []byte
+	Errors       string
+	SendError    string
+	TimestampUs  int64
+}
+
+// Capture holds the last messages sent, oldest first
+type Capture struct {
+	Size     uint32
+	Total    uint64
+	Messages []*CapturedMessage
+}
+
+// Wire encoding helpers
+
+func appendString(buf []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendString(buf, v)
+}
+
+func appendBytes(buf []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return buf
+	}
+	return appendMessage(buf, num, v)
+}
+
+func appendVarint(buf []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.VarintType)
+	return protowire.AppendVarint(buf, v)
+}
+
+func appendBool(buf []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return buf
+	}
+	return appendVarint(buf, num, 1)
+}
+
+func appendDouble(buf []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(buf, math.Float64bits(v))
+}
+
+func appendMessage(buf []byte, num protowire.Number, b []byte) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, b)
+}
+
+// field is a single decoded wire field
+type field struct {
+	num    protowire.Number
+	varint uint64
+	fixed  uint64
+	bytes  []byte
+}
+
+// decodeFields walks a message and calls fn for every field
+func decodeFields(b []byte, fn func(f field)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.fixed, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		fn(f)
+	}
+	return nil
+}
+
+// Marshal encodes the request to protobuf wire format
+func (m *InjectEventRequest) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendString(buf, 1, m.Action)
+	buf = appendString(buf, 2, m.Target)
+	buf = appendVarint(buf, 3, uint64(m.DurationMs))
+	for k, v := range m.Params {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, v)
+		buf = appendMessage(buf, 4, entry)
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes the request from protobuf wire format
+func (m *InjectEventRequest) Unmarshal(b []byte) error {
+	*m = InjectEventRequest{}
+	var entryErr error
+	err := decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.Action = string(f.bytes)
+		case 2:
+			m.Target = string(f.bytes)
+		case 3:
+			m.DurationMs = int64(f.varint)
+		case 4:
+			var k, v string
+			if err := decodeFields(f.bytes, func(e field) {
+				switch e.num {
+				case 1:
+					k = string(e.bytes)
+				case 2:
+					v = string(e.bytes)
+				}
+			}); err != nil {
+				entryErr = err
+				return
+			}
+			if m.Params == nil {
+				m.Params = make(map[string]string)
+			}
+			m.Params[k] = v
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return entryErr
+}
+
+func (m *InjectEventResponse) Marshal() ([]byte, error) { return nil, nil }
+func (m *InjectEventResponse) Unmarshal(b []byte) error { return nil }
+func (m *GetStateRequest) Marshal() ([]byte, error)     { return nil, nil }
+func (m *GetStateRequest) Unmarshal(b []byte) error     { return nil }
+func (m *UpdateConfigResponse) Marshal() ([]byte, error) {
+	return nil, nil
+}
+func (m *UpdateConfigResponse) Unmarshal(b []byte) error { return nil }
+func (m *StreamEventsRequest) Marshal() ([]byte, error)  { return nil, nil }
+func (m *StreamEventsRequest) Unmarshal(b []byte) error  { return nil }
+
+// Marshal encodes the neighbor state to protobuf wire format
+func (m *BGPNeighborState) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendString(buf, 1, m.Address)
+	buf = appendVarint(buf, 2, uint64(m.RemoteAS))
+	buf = appendString(buf, 3, m.State)
+	buf = appendVarint(buf, 4, uint64(m.StateCode))
+	buf = appendVarint(buf, 5, uint64(m.PrefixesReceived))
+	buf = appendVarint(buf, 6, uint64(m.PrefixesSent))
+	buf = appendVarint(buf, 7, uint64(m.FlapCount))
+	buf = appendBool(buf, 8, m.Maintenance)
+	return buf, nil
+}
+
+// Unmarshal decodes the neighbor state from protobuf wire format
+func (m *BGPNeighborState) Unmarshal(b []byte) error {
+	*m = BGPNeighborState{}
+	return decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.Address = string(f.bytes)
+		case 2:
+			m.RemoteAS = uint32(f.varint)
+		case 3:
+			m.State = string(f.bytes)
+		case 4:
+			m.StateCode = uint32(f.varint)
+		case 5:
+			m.PrefixesReceived = uint32(f.varint)
+		case 6:
+			m.PrefixesSent = uint32(f.varint)
+		case 7:
+			m.FlapCount = uint32(f.varint)
+		case 8:
+			m.Maintenance = f.varint != 0
+		}
+	})
+}
+
+// Marshal encodes the EVPN state to protobuf wire format
+func (m *EVPNState) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendVarint(buf, 1, uint64(m.Type2Routes))
+	buf = appendVarint(buf, 2, uint64(m.Type3Routes))
+	buf = appendVarint(buf, 3, uint64(m.Type5Routes))
+	buf = appendVarint(buf, 4, uint64(m.TotalRoutes))
+	return buf, nil
+}
+
+// Unmarshal decodes the EVPN state from protobuf wire format
+func (m *EVPNState) Unmarshal(b []byte) error {
+	*m = EVPNState{}
+	return decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.Type2Routes = uint32(f.varint)
+		case 2:
+			m.Type3Routes = uint32(f.varint)
+		case 3:
+			m.Type5Routes = uint32(f.varint)
+		case 4:
+			m.TotalRoutes = uint32(f.varint)
+		}
+	})
+}
+
+// Marshal encodes the VNI state to protobuf wire format
+func (m *VNIState) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendVarint(buf, 1, uint64(m.VNIID))
+	buf = appendString(buf, 2, m.State)
+	buf = appendVarint(buf, 3, uint64(m.MACCount))
+	buf = appendVarint(buf, 4, uint64(m.VTEPCount))
+	buf = appendVarint(buf, 5, uint64(m.ARPCount))
+	buf = appendBool(buf, 6, m.ARPSuppression)
+	return buf, nil
+}
+
+// Unmarshal decodes the VNI state from protobuf wire format
+func (m *VNIState) Unmarshal(b []byte) error {
+	*m = VNIState{}
+	return decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.VNIID = uint32(f.varint)
+		case 2:
+			m.State = string(f.bytes)
+		case 3:
+			m.MACCount = uint32(f.varint)
+		case 4:
+			m.VTEPCount = uint32(f.varint)
+		case 5:
+			m.ARPCount = uint32(f.varint)
+		case 6:
+			m.ARPSuppression = f.varint != 0
+		}
+	})
+}
+
+// Marshal encodes the subscription state to protobuf wire format
+func (m *SubscriptionState) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendString(buf, 1, m.Subscription)
+	buf = appendVarint(buf, 2, m.EncodeErrors)
+	buf = appendString(buf, 3, m.LastError)
+	buf = appendBool(buf, 4, m.Disabled)
+	return buf, nil
+}
+
+// Unmarshal decodes the subscription state from protobuf wire format
+func (m *SubscriptionState) Unmarshal(b []byte) error {
+	*m = SubscriptionState{}
+	return decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.Subscription = string(f.bytes)
+		case 2:
+			m.EncodeErrors = f.varint
+		case 3:
+			m.LastError = string(f.bytes)
+		case 4:
+			m.Disabled = f.varint != 0
+		}
+	})
+}
+
+// Marshal encodes the simulator state to protobuf wire format
+func (m *SimulatorState) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendString(buf, 1, m.NodeID)
+	buf = appendVarint(buf, 2, uint64(m.ElapsedMs))
+	buf = appendVarint(buf, 3, m.IngressBytes)
+	buf = appendVarint(buf, 4, m.EgressBytes)
+	for _, n := range m.BGPNeighbors {
+		b, _ := n.Marshal()
+		buf = appendMessage(buf, 5, b)
+	}
+	if m.EVPN != nil {
+		b, _ := m.EVPN.Marshal()
+		buf = appendMessage(buf, 6, b)
+	}
+	for _, v := range m.VNIs {
+		b, _ := v.Marshal()
+		buf = appendMessage(buf, 7, b)
+	}
+	buf = appendDouble(buf, 8, m.CPUPercent)
+	for _, s := range m.Subscriptions {
+		b, _ := s.Marshal()
+		buf = appendMessage(buf, 9, b)
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes the simulator state from protobuf wire format
+func (m *SimulatorState) Unmarshal(b []byte) error {
+	*m = SimulatorState{}
+	var nestedErr error
+	err := decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.NodeID = string(f.bytes)
+		case 2:
+			m.ElapsedMs = int64(f.varint)
+		case 3:
+			m.IngressBytes = f.varint
+		case 4:
+			m.EgressBytes = f.varint
+		case 5:
+			n := &BGPNeighborState{}
+			if err := n.Unmarshal(f.bytes); err != nil {
+				nestedErr = err
+			}
+			m.BGPNeighbors = append(m.BGPNeighbors, n)
+		case 6:
+			m.EVPN = &EVPNState{}
+			if err := m.EVPN.Unmarshal(f.bytes); err != nil {
+				nestedErr = err
+			}
+		case 7:
+			v := &VNIState{}
+			if err := v.Unmarshal(f.bytes); err != nil {
+				nestedErr = err
+			}
+			m.VNIs = append(m.VNIs, v)
+		case 8:
+			m.CPUPercent = math.Float64frombits(f.fixed)
+		case 9:
+			s := &SubscriptionState{}
+			if err := s.Unmarshal(f.bytes); err != nil {
+				nestedErr = err
+			}
+			m.Subscriptions = append(m.Subscriptions, s)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return nestedErr
+}
+
+// Marshal encodes the request to protobuf wire format
+func (m *UpdateConfigRequest) Marshal() ([]byte, error) {
+	return appendString(nil, 1, m.YAML), nil
+}
+
+// Unmarshal decodes the request from protobuf wire format
+func (m *UpdateConfigRequest) Unmarshal(b []byte) error {
+	*m = UpdateConfigRequest{}
+	return decodeFields(b, func(f field) {
+		if f.num == 1 {
+			m.YAML = string(f.bytes)
+		}
+	})
+}
+
+// Marshal encodes the event to protobuf wire format
+func (m *Event) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendVarint(buf, 1, uint64(m.TimestampMs))
+	buf = appendString(buf, 2, m.Type)
+	buf = appendString(buf, 3, m.Target)
+	buf = appendString(buf, 4, m.Detail)
+	return buf, nil
+}
+
+// Unmarshal decodes the event from protobuf wire format
+func (m *Event) Unmarshal(b []byte) error {
+	*m = Event{}
+	return decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.TimestampMs = int64(f.varint)
+		case 2:
+			m.Type = string(f.bytes)
+		case 3:
+			m.Target = string(f.bytes)
+		case 4:
+			m.Detail = string(f.bytes)
+		}
+	})
+}
+
+// String renders the event for logs
+func (m *Event) String() string {
+	return fmt.Sprintf("%s %s: %s", m.Type, m.Target, m.Detail)
+}
+
+// Marshal encodes the request to protobuf wire format
+func (m *GetCaptureRequest) Marshal() ([]byte, error) {
+	return appendVarint(nil, 1, uint64(m.Last)), nil
+}
+
+// Unmarshal decodes the request from protobuf wire format
+func (m *GetCaptureRequest) Unmarshal(b []byte) error {
+	*m = GetCaptureRequest{}
+	return decodeFields(b, func(f field) {
+		if f.num == 1 {
+			m.Last = uint32(f.varint)
+		}
+	})
+}
+
+// Marshal encodes the captured message to protobuf wire format
+func (m *CapturedMessage) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendVarint(buf, 1, uint64(m.TimestampMs))
+	buf = appendString(buf, 2, m.Collector)
+	buf = appendVarint(buf, 3, uint64(m.ReqID))
+	buf = appendString(buf, 4, m.Subscription)
+	buf = appendString(buf, 5, m.EncodingPath)
+	buf = appendString(buf, 6, m.Encoding)
+	buf = appendVarint(buf, 7, uint64(m.Rows))
+	buf = appendBytes(buf, 8, m.Data)
+	buf = appendString(buf, 9, m.Errors)
+	buf = appendString(buf, 10, m.SendError)
+	buf = appendVarint(buf, 11, uint64(m.TimestampUs))
+	return buf, nil
+}
+
+// Unmarshal decodes the captured message from protobuf wire format
+func (m *CapturedMessage) Unmarshal(b []byte) error {
+	*m = CapturedMessage{}
+	return decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.TimestampMs = int64(f.varint)
+		case 2:
+			m.Collector = string(f.bytes)
+		case 3:
+			m.ReqID = int64(f.varint)
+		case 4:
+			m.Subscription = string(f.bytes)
+		case 5:
+			m.EncodingPath = string(f.bytes)
+		case 6:
+			m.Encoding = string(f.bytes)
+		case 7:
+			m.Rows = uint32(f.varint)
+		case 8:
+			m.Data = append([]byte(nil), f.bytes...)
+		case 9:
+			m.Errors = string(f.bytes)
+		case 10:
+			m.SendError = string(f.bytes)
+		case 11:
+			m.TimestampUs = int64(f.varint)
+		}
+	})
+}
+
+// Marshal encodes the capture to protobuf wire format
+func (m *Capture) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendVarint(buf, 1, uint64(m.Size))
+	buf = appendVarint(buf, 2, m.Total)
+	for _, c := range m.Messages {
+		b, _ := c.Marshal()
+		buf = appendMessage(buf, 3, b)
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes the capture from protobuf wire format
+func (m *Capture) Unmarshal(b []byte) error {
+	*m = Capture{}
+	var nestedErr error
+	err := decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.Size = uint32(f.varint)
+		case 2:
+			m.Total = f.varint
+		case 3:
+			c := &CapturedMessage{}
+			if err := c.Unmarshal(f.bytes); err != nil {
+				nestedErr = err
+			}
+			m.Messages = append(m.Messages, c)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return nestedErr
+}
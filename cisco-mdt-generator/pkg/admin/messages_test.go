@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"reflect"
+	"testing"
+)
+
+type message interface {
+	Marshal() ([]byte, error)
+	Unmarshal(b []byte) error
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   message
+		out  message
+	}{
+		{name: "inject event", out: &InjectEventRequest{}, in: &InjectEventRequest{
+			Action:     "bgp_flap",
+			Target:     "10.0.0.2",
+			DurationMs: 30000,
+			Params:     map[string]string{"state": "idle"},
+		}},
+		{name: "simulator state", out: &SimulatorState{}, in: &SimulatorState{
+			NodeID:       "leaf-101",
+			ElapsedMs:    120000,
+			IngressBytes: 1 << 40,
+			EgressBytes:  1 << 38,
+			BGPNeighbors: []*BGPNeighborState{
+				{Address: "10.0.0.1", RemoteAS: 65000, State: "established", StateCode: 6, PrefixesReceived: 150, PrefixesSent: 20},
+				{Address: "10.0.0.2", RemoteAS: 65000, State: "idle", StateCode: 1, FlapCount: 3, Maintenance: true},
+			},
+			EVPN: &EVPNState{Type2Routes: 40, Type3Routes: 4, Type5Routes: 12, TotalRoutes: 56},
+			VNIs: []*VNIState{
+				{VNIID: 10100, State: "up", MACCount: 20, VTEPCount: 3, ARPCount: 18, ARPSuppression: true},
+			},
+			CPUPercent: 37.5,
+			Subscriptions: []*SubscriptionState{
+				{Subscription: "bgp", EncodeErrors: 2, LastError: "row too large", Disabled: true},
+			},
+		}},
+		{name: "update config", in: &UpdateConfigRequest{YAML: "interval: 5s\n"}, out: &UpdateConfigRequest{}},
+		{name: "event", out: &Event{}, in: &Event{
+			TimestampMs: 1767225600000,
+			Type:        "bgp_flap",
+			Target:      "10.0.0.2",
+			Detail:      "established -> idle",
+		}},
+		{name: "get capture", in: &GetCaptureRequest{Last: 10}, out: &GetCaptureRequest{}},
+		{name: "capture", out: &Capture{}, in: &Capture{
+			Size:  100,
+			Total: 4242,
+			Messages: []*CapturedMessage{
+				{
+					TimestampMs:  1767225600123,
+					Collector:    "collector:57500",
+					ReqID:        7,
+					Subscription: "bgp",
+					EncodingPath: "Cisco-IOS-XR-ipv4-bgp-oper:bgp/instances/instance/instance-active/default-vrf/neighbors/neighbor",
+					Encoding:     "gpbkv",
+					Rows:         4,
+					Data:         []byte{0x0a, 0x08, 'l', 'e', 'a', 'f', '-', '1', '0', '1'},
+					Errors:       "string_fuzz",
+					SendError:    "connection reset",
+					TimestampUs:  1767225600123456,
+				},
+				{TimestampMs: 1767225605000, Subscription: "interfaces", Rows: 48, TimestampUs: 1767225605000001},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.in.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.out.Unmarshal(b); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.out, tt.in) {
+				t.Errorf("decoded %+v, want %+v", tt.out, tt.in)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package admin
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// marshaler is implemented by every admin message
+type marshaler interface {
+	Marshal() ([]byte, error)
+	Unmarshal(b []byte) error
+}
+
+// rawMessage is a helper for sending pre-encoded protobuf data
+type rawMessage struct {
+	data []byte
+}
+
+func (m *rawMessage) Reset()         {}
+func (m *rawMessage) String() string { return string(m.data) }
+func (m *rawMessage) ProtoMessage()  {}
+
+func (m *rawMessage) Marshal() ([]byte, error) {
+	return m.data, nil
+}
+
+func (m *rawMessage) Unmarshal(b []byte) error {
+	m.data = b
+	return nil
+}
+
+// encode wraps a message for the gRPC codec
+func encode(m marshaler) (*rawMessage, error) {
+	data, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &rawMessage{data: data}, nil
+}
+
+// AdminClient is the client interface for the admin service
+type AdminClient interface {
+	InjectEvent(ctx context.Context, in *InjectEventRequest, opts ...grpc.CallOption) (*InjectEventResponse, error)
+	GetState(ctx context.Context, in *GetStateRequest, opts ...grpc.CallOption) (*SimulatorState, error)
+	UpdateConfig(ctx context.Context, in *UpdateConfigRequest, opts ...grpc.CallOption) (*UpdateConfigResponse, error)
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Admin_StreamEventsClient, error)
+	GetCapture(ctx context.Context, in *GetCaptureRequest, opts ...grpc.CallOption) (*Capture, error)
+}
+
+// Admin_StreamEventsClient receives simulation events
+type Admin_StreamEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+// adminClient implements AdminClient
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewAdminClient creates a new admin client
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+// invoke performs a unary call with hand-encoded messages
+func (c *adminClient) invoke(ctx context.Context, method string, in, out marshaler, opts ...grpc.CallOption) error {
+	req, err := encode(in)
+	if err != nil {
+		return err
+	}
+	resp := &rawMessage{}
+	if err := c.cc.Invoke(ctx, "/mdtsim.admin.Admin/"+method, req, resp, opts...); err != nil {
+		return err
+	}
+	return out.Unmarshal(resp.data)
+}
+
+func (c *adminClient) InjectEvent(ctx context.Context, in *InjectEventRequest, opts ...grpc.CallOption) (*InjectEventResponse, error) {
+	out := &InjectEventResponse{}
+	if err := c.invoke(ctx, "InjectEvent", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetState(ctx context.Context, in *GetStateRequest, opts ...grpc.CallOption) (*SimulatorState, error) {
+	out := &SimulatorState{}
+	if err := c.invoke(ctx, "GetState", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) UpdateConfig(ctx context.Context, in *UpdateConfigRequest, opts ...grpc.CallOption) (*UpdateConfigResponse, error) {
+	out := &UpdateConfigResponse{}
+	if err := c.invoke(ctx, "UpdateConfig", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Admin_StreamEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &adminServiceDesc.Streams[0], "/mdtsim.admin.Admin/StreamEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	req, err := encode(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &adminStreamEventsClient{stream}, nil
+}
+
+func (c *adminClient) GetCapture(ctx context.Context, in *GetCaptureRequest, opts ...grpc.CallOption) (*Capture, error) {
+	out := &Capture{}
+	if err := c.invoke(ctx, "GetCapture", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type adminStreamEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminStreamEventsClient) Recv() (*Event, error) {
+	m := &rawMessage{}
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	ev := &Event{}
+	if err := ev.Unmarshal(m.data); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// AdminServer is the server interface for the admin service
+type AdminServer interface {
+	InjectEvent(context.Context, *InjectEventRequest) (*InjectEventResponse, error)
+	GetState(context.Context, *GetStateRequest) (*SimulatorState, error)
+	UpdateConfig(context.Context, *UpdateConfigRequest) (*UpdateConfigResponse, error)
+	StreamEvents(*StreamEventsRequest, Admin_StreamEventsServer) error
+	GetCapture(context.Context, *GetCaptureRequest) (*Capture, error)
+}
+
+// Admin_StreamEventsServer sends simulation events
+type Admin_StreamEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type adminStreamEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminStreamEventsServer) Send(m *Event) error {
+	msg, err := encode(m)
+	if err != nil {
+		return err
+	}
+	return x.ServerStream.SendMsg(msg)
+}
+
+// RegisterAdminServer registers the admin service on a gRPC server
+func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
+	s.RegisterService(&adminServiceDesc, srv)
+}
+
+// decodeRequest reads a unary request into msg
+func decodeRequest(dec func(interface{}) error, msg marshaler) error {
+	m := &rawMessage{}
+	if err := dec(m); err != nil {
+		return err
+	}
+	return msg.Unmarshal(m.data)
+}
+
+// unaryHandler adapts a typed handler to the gRPC method handler signature
+func unaryHandler(method string, newReq func() marshaler, call func(AdminServer, context.Context, marshaler) (marshaler, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newReq()
+			if err := decodeRequest(dec, req); err != nil {
+				return nil, err
+			}
+			handle := func(ctx context.Context, req interface{}) (interface{}, error) {
+				resp, err := call(srv.(AdminServer), ctx, req.(marshaler))
+				if err != nil {
+					return nil, err
+				}
+				return encode(resp)
+			}
+			if interceptor == nil {
+				return handle(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/mdtsim.admin.Admin/" + method}
+			return interceptor(ctx, req, info, handle)
+		},
+	}
+}
+
+func streamEventsHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := &rawMessage{}
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	req := &StreamEventsRequest{}
+	if err := req.Unmarshal(m.data); err != nil {
+		return err
+	}
+	return srv.(AdminServer).StreamEvents(req, &adminStreamEventsServer{stream})
+}
+
+// Service descriptor for gRPC
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mdtsim.admin.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("InjectEvent",
+			func() marshaler { return &InjectEventRequest{} },
+			func(s AdminServer, ctx context.Context, req marshaler) (marshaler, error) {
+				return s.InjectEvent(ctx, req.(*InjectEventRequest))
+			}),
+		unaryHandler("GetState",
+			func() marshaler { return &GetStateRequest{} },
+			func(s AdminServer, ctx context.Context, req marshaler) (marshaler, error) {
+				return s.GetState(ctx, req.(*GetStateRequest))
+			}),
+		unaryHandler("UpdateConfig",
+			func() marshaler { return &UpdateConfigRequest{} },
+			func(s AdminServer, ctx context.Context, req marshaler) (marshaler, error) {
+				return s.UpdateConfig(ctx, req.(*UpdateConfigRequest))
+			}),
+		unaryHandler("GetCapture",
+			func() marshaler { return &GetCaptureRequest{} },
+			func(s AdminServer, ctx context.Context, req marshaler) (marshaler, error) {
+				return s.GetCapture(ctx, req.(*GetCaptureRequest))
+			}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       streamEventsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "admin.proto",
+}
@@ -0,0 +1,267 @@
+// Package bgp encodes and decodes the BGP-4 messages of a minimal speaker
+// (RFC 4271) with IPv4 unicast routes and four-octet AS numbers (RFC 6793)
+package bgp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// Port is the well-known BGP port
+const Port = 179
+
+// Message types
+const (
+	MsgOpen         = 1
+	MsgUpdate       = 2
+	MsgNotification = 3
+	MsgKeepalive    = 4
+)
+
+// HeaderLen is the length of the marker, length and type header
+const HeaderLen = 19
+
+// MaxMessageLen is the largest message a speaker may send
+const MaxMessageLen = 4096
+
+// ASTrans stands in for four-octet AS numbers towards old speakers
+const ASTrans = 23456
+
+// Path attribute type codes
+const (
+	attrOrigin  = 1
+	attrASPath  = 2
+	attrNextHop = 3
+)
+
+// Capability codes advertised in OPEN
+const (
+	capMultiprotocol = 1
+	capFourOctetAS   = 65
+)
+
+// Origin values
+const (
+	OriginIGP        = 0
+	OriginEGP        = 1
+	OriginIncomplete = 2
+)
+
+// Open is the OPEN message of a speaker
+type Open struct {
+	AS       uint32 // four-octet AS number, also sent as a capability
+	HoldTime uint16 // seconds, 0 disables keepalives
+	RouterID netip.Addr
+	AS4      bool // the four-octet AS capability is present
+}
+
+// Update announces NLRI with one set of path attributes and withdraws routes
+type Update struct {
+	Withdrawn []netip.Prefix
+	Origin    uint8
+	ASPath    []uint32 // one AS_SEQUENCE, nearest AS first
+	NextHop   netip.Addr
+	NLRI      []netip.Prefix
+}
+
+// Notification reports an error before the speaker closes the session
+type Notification struct {
+	Code    uint8
+	Subcode uint8
+	Data    []byte
+}
+
+func (n *Notification) Error() string {
+	return fmt.Sprintf("bgp notification code %d subcode %d", n.Code, n.Subcode)
+}
+
+// Cease closes a session administratively
+var Cease = &Notification{Code: 6, Subcode: 2}
+
+// message prepends the header to a message body
+func message(typ byte, body []byte) []byte {
+	msg := make([]byte, HeaderLen, HeaderLen+len(body))
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xFF
+	}
+	binary.BigEndian.PutUint16(msg[16:], uint16(HeaderLen+len(body)))
+	msg[18] = typ
+	return append(msg, body...)
+}
+
+// Marshal encodes the OPEN message, advertising IPv4 unicast and four-octet
+// AS support
+func (o *Open) Marshal() []byte {
+	as2 := uint16(ASTrans)
+	if o.AS <= 0xFFFF {
+		as2 = uint16(o.AS)
+	}
+	caps := []byte{
+		capMultiprotocol, 4, 0, 1, 0, 1, // AFI IPv4, SAFI unicast
+		capFourOctetAS, 4, 0, 0, 0, 0,
+	}
+	binary.BigEndian.PutUint32(caps[8:], o.AS)
+
+	body := []byte{4, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(body[1:], as2)
+	binary.BigEndian.PutUint16(body[3:], o.HoldTime)
+	id := o.RouterID.As4()
+	body = append(body, id[:]...)
+	body = append(body, byte(2+len(caps)), 2, byte(len(caps))) // one capabilities parameter
+	body = append(body, caps...)
+	return message(MsgOpen, body)
+}
+
+// ParseOpen decodes the body of an OPEN message
+func ParseOpen(body []byte) (*Open, error) {
+	if len(body) < 10 {
+		return nil, errors.New("bgp: short open")
+	}
+	if body[0] != 4 {
+		return nil, fmt.Errorf("bgp: unsupported version %d", body[0])
+	}
+	o := &Open{
+		AS:       uint32(binary.BigEndian.Uint16(body[1:])),
+		HoldTime: binary.BigEndian.Uint16(body[3:]),
+		RouterID: netip.AddrFrom4([4]byte(body[5:9])),
+	}
+	params := body[10:]
+	if int(body[9]) != len(params) {
+		return nil, errors.New("bgp: bad optional parameters length")
+	}
+	for len(params) >= 2 {
+		typ, n := params[0], int(params[1])
+		if len(params) < 2+n {
+			return nil, errors.New("bgp: truncated optional parameter")
+		}
+		if typ == 2 {
+			caps := params[2 : 2+n]
+			for len(caps) >= 2 {
+				code, m := caps[0], int(caps[1])
+				if len(caps) < 2+m {
+					return nil, errors.New("bgp: truncated capability")
+				}
+				if code == capFourOctetAS && m == 4 {
+					o.AS = binary.BigEndian.Uint32(caps[2:])
+					o.AS4 = true
+				}
+				caps = caps[2+m:]
+			}
+		}
+		params = params[2+n:]
+	}
+	return o, nil
+}
+
+// Marshal encodes the UPDATE message. AS numbers are four octets when as4
+// is set and AS_TRANS stands in for large ones otherwise. The caller keeps
+// the message within MaxMessageLen.
+func (u *Update) Marshal(as4 bool) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(prefixesLen(u.Withdrawn)))
+	body = appendPrefixes(body, u.Withdrawn)
+
+	var attrs []byte
+	if len(u.NLRI) > 0 {
+		attrs = append(attrs, 0x40, attrOrigin, 1, u.Origin)
+
+		var path []byte
+		if len(u.ASPath) > 0 {
+			path = []byte{2, byte(len(u.ASPath))} // AS_SEQUENCE
+			for _, as := range u.ASPath {
+				if as4 {
+					path = binary.BigEndian.AppendUint32(path, as)
+				} else if as > 0xFFFF {
+					path = binary.BigEndian.AppendUint16(path, ASTrans)
+				} else {
+					path = binary.BigEndian.AppendUint16(path, uint16(as))
+				}
+			}
+		}
+		attrs = append(attrs, 0x40, attrASPath, byte(len(path)))
+		attrs = append(attrs, path...)
+
+		nh := u.NextHop.As4()
+		attrs = append(attrs, 0x40, attrNextHop, 4)
+		attrs = append(attrs, nh[:]...)
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+	body = append(body, attrs...)
+	body = appendPrefixes(body, u.NLRI)
+	return message(MsgUpdate, body)
+}
+
+// Len returns the encoded length of the message
+func (u *Update) Len(as4 bool) int {
+	n := HeaderLen + 4 + prefixesLen(u.Withdrawn) + prefixesLen(u.NLRI)
+	if len(u.NLRI) > 0 {
+		asLen := 2
+		if as4 {
+			asLen = 4
+		}
+		n += 4 + 3 + 7
+		if len(u.ASPath) > 0 {
+			n += 2 + asLen*len(u.ASPath)
+		}
+	}
+	return n
+}
+
+// PrefixLen returns the encoded length of one NLRI prefix
+func PrefixLen(p netip.Prefix) int {
+	return 1 + (p.Bits()+7)/8
+}
+
+func prefixesLen(prefixes []netip.Prefix) int {
+	n := 0
+	for _, p := range prefixes {
+		n += PrefixLen(p)
+	}
+	return n
+}
+
+func appendPrefixes(b []byte, prefixes []netip.Prefix) []byte {
+	for _, p := range prefixes {
+		addr := p.Addr().As4()
+		b = append(b, byte(p.Bits()))
+		b = append(b, addr[:(p.Bits()+7)/8]...)
+	}
+	return b
+}
+
+// Keepalive returns a KEEPALIVE message
+func Keepalive() []byte {
+	return message(MsgKeepalive, nil)
+}
+
+// Marshal encodes the NOTIFICATION message
+func (n *Notification) Marshal() []byte {
+	return message(MsgNotification, append([]byte{n.Code, n.Subcode}, n.Data...))
+}
+
+// ReadMessage reads one message and returns its type and body
+func ReadMessage(r io.Reader) (byte, []byte, error) {
+	var hdr [HeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	for _, b := range hdr[:16] {
+		if b != 0xFF {
+			return 0, nil, errors.New("bgp: bad marker")
+		}
+	}
+	n := int(binary.BigEndian.Uint16(hdr[16:]))
+	if n < HeaderLen || n > MaxMessageLen {
+		return 0, nil, fmt.Errorf("bgp: bad message length %d", n)
+	}
+	body := make([]byte, n-HeaderLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	if hdr[18] == MsgNotification && len(body) >= 2 {
+		return hdr[18], body, &Notification{Code: body[0], Subcode: body[1], Data: body[2:]}
+	}
+	return hdr[18], body, nil
+}
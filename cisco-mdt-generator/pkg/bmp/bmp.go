@@ -0,0 +1,138 @@
+// Package bmp encodes BGP Monitoring Protocol messages (RFC 7854) sent by a
+// monitored router to a BMP station
+package bmp
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// Version is the BMP version of every message
+const Version = 3
+
+// Message types
+const (
+	MsgRouteMonitoring  = 0
+	MsgStatisticsReport = 1
+	MsgPeerDown         = 2
+	MsgPeerUp           = 3
+	MsgInitiation       = 4
+	MsgTermination      = 5
+)
+
+// Peer down reasons
+const (
+	DownLocalNotification   = 1 // followed by the NOTIFICATION sent
+	DownLocalNoNotification = 2 // followed by the FSM event code
+	DownRemoteNotification  = 3 // followed by the NOTIFICATION received
+	DownRemoteNoData        = 4
+)
+
+// Information TLV types of initiation messages
+const (
+	infoString   = 0
+	infoSysDescr = 1
+	infoSysName  = 2
+)
+
+// Termination reasons
+const (
+	TerminationAdminClose = 0
+)
+
+// PeerHeader identifies the global-instance peer a message is about
+type PeerHeader struct {
+	Address    netip.Addr
+	AS         uint32
+	BGPID      netip.Addr
+	PostPolicy bool // routes are Adj-RIB-In post-policy
+	Time       time.Time
+}
+
+// message prepends the common header to a message body
+func message(typ byte, body []byte) []byte {
+	msg := make([]byte, 6, 6+len(body))
+	msg[0] = Version
+	binary.BigEndian.PutUint32(msg[1:], uint32(6+len(body)))
+	msg[5] = typ
+	return append(msg, body...)
+}
+
+// appendAddr appends an address as 16 bytes, IPv4 in the last four
+func appendAddr(b []byte, addr netip.Addr) []byte {
+	a := addr.As16()
+	if addr.Is4() {
+		a = [16]byte{}
+		v4 := addr.As4()
+		copy(a[12:], v4[:])
+	}
+	return append(b, a[:]...)
+}
+
+// append encodes the per-peer header. AS paths are always four octets.
+func (h *PeerHeader) append(b []byte) []byte {
+	var flags byte
+	if h.Address.Is6() {
+		flags |= 0x80
+	}
+	if h.PostPolicy {
+		flags |= 0x40
+	}
+	b = append(b, 0, flags)           // global instance peer
+	b = append(b, make([]byte, 8)...) // peer distinguisher
+	b = appendAddr(b, h.Address)
+	b = binary.BigEndian.AppendUint32(b, h.AS)
+	id := h.BGPID.As4()
+	b = append(b, id[:]...)
+	b = binary.BigEndian.AppendUint32(b, uint32(h.Time.Unix()))
+	return binary.BigEndian.AppendUint32(b, uint32(h.Time.Nanosecond()/1000))
+}
+
+func appendTLV(b []byte, typ uint16, value string) []byte {
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// Initiation returns the first message of a session
+func Initiation(sysName, sysDescr string) []byte {
+	body := appendTLV(nil, infoSysDescr, sysDescr)
+	body = appendTLV(body, infoSysName, sysName)
+	return message(MsgInitiation, body)
+}
+
+// Termination returns the last message of a session
+func Termination(reason uint16, text string) []byte {
+	body := appendTLV(nil, infoString, text)
+	body = binary.BigEndian.AppendUint16(body, 1) // reason TLV
+	body = binary.BigEndian.AppendUint16(body, 2)
+	body = binary.BigEndian.AppendUint16(body, reason)
+	return message(MsgTermination, body)
+}
+
+// PeerUp reports an established session with the OPEN messages exchanged
+func PeerUp(h *PeerHeader, local netip.Addr, localPort, remotePort uint16, sentOpen, receivedOpen []byte) []byte {
+	body := h.append(nil)
+	body = appendAddr(body, local)
+	body = binary.BigEndian.AppendUint16(body, localPort)
+	body = binary.BigEndian.AppendUint16(body, remotePort)
+	body = append(body, sentOpen...)
+	body = append(body, receivedOpen...)
+	return message(MsgPeerUp, body)
+}
+
+// PeerDown reports a closed session, data depending on the reason
+func PeerDown(h *PeerHeader, reason byte, data []byte) []byte {
+	body := h.append(nil)
+	body = append(body, reason)
+	body = append(body, data...)
+	return message(MsgPeerDown, body)
+}
+
+// RouteMonitoring carries an UPDATE received from a peer
+func RouteMonitoring(h *PeerHeader, update []byte) []byte {
+	body := h.append(nil)
+	body = append(body, update...)
+	return message(MsgRouteMonitoring, body)
+}
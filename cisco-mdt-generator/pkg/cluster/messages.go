@@ -0,0 +1,217 @@
+// Package cluster implements the gRPC service coordinating simulator
+// processes. This is a manual implementation matching cluster.proto in this
+// directory.
+package cluster
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// JoinRequest asks the leader for a slice of the fabric
+type JoinRequest struct {
+	Member string
+}
+
+// Assignment is what a member needs to simulate its slice of the fabric in
+// step with the others
+type Assignment struct {
+	Index        uint32
+	Members      uint32
+	StartMs      int64
+	IntervalMs   int64
+	FlapChance   float64
+	Server       string
+	Config       []byte
+	Auto         map[string]string
+	Scenario     []byte
+	NodeFormat   string
+	Count        int64
+	First        int64
+	Template     string
+	NodeIDs      []string
+	AllowScripts bool
+}
+
+// Wire encoding helpers
+
+func appendString(buf []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendString(buf, v)
+}
+
+func appendBytes(buf []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, v)
+}
+
+func appendVarint(buf []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.VarintType)
+	return protowire.AppendVarint(buf, v)
+}
+
+func appendBool(buf []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return buf
+	}
+	return appendVarint(buf, num, 1)
+}
+
+func appendDouble(buf []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(buf, math.Float64bits(v))
+}
+
+// field is a single decoded wire field
+type field struct {
+	num    protowire.Number
+	varint uint64
+	fixed  uint64
+	bytes  []byte
+}
+
+// decodeFields walks a message and calls fn for every field
+func decodeFields(b []byte, fn func(f field)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.fixed, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		fn(f)
+	}
+	return nil
+}
+
+// Marshal encodes the request to protobuf wire format
+func (m *JoinRequest) Marshal() ([]byte, error) {
+	return appendString(nil, 1, m.Member), nil
+}
+
+// Unmarshal decodes the request from protobuf wire format
+func (m *JoinRequest) Unmarshal(b []byte) error {
+	*m = JoinRequest{}
+	return decodeFields(b, func(f field) {
+		if f.num == 1 {
+			m.Member = string(f.bytes)
+		}
+	})
+}
+
+// Marshal encodes the assignment to protobuf wire format
+func (m *Assignment) Marshal() ([]byte, error) {
+	var buf []byte
+	buf = appendVarint(buf, 1, uint64(m.Index))
+	buf = appendVarint(buf, 2, uint64(m.Members))
+	buf = appendVarint(buf, 3, uint64(m.StartMs))
+	buf = appendVarint(buf, 4, uint64(m.IntervalMs))
+	buf = appendDouble(buf, 5, m.FlapChance)
+	buf = appendString(buf, 6, m.Server)
+	buf = appendBytes(buf, 7, m.Config)
+	for k, v := range m.Auto {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, v)
+		buf = protowire.AppendTag(buf, 8, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, entry)
+	}
+	buf = appendBytes(buf, 9, m.Scenario)
+	buf = appendString(buf, 10, m.NodeFormat)
+	buf = appendVarint(buf, 11, uint64(m.Count))
+	buf = appendVarint(buf, 12, uint64(m.First))
+	buf = appendString(buf, 13, m.Template)
+	for _, id := range m.NodeIDs {
+		buf = protowire.AppendTag(buf, 14, protowire.BytesType)
+		buf = protowire.AppendString(buf, id)
+	}
+	buf = appendBool(buf, 15, m.AllowScripts)
+	return buf, nil
+}
+
+// Unmarshal decodes the assignment from protobuf wire format
+func (m *Assignment) Unmarshal(b []byte) error {
+	*m = Assignment{}
+	var entryErr error
+	err := decodeFields(b, func(f field) {
+		switch f.num {
+		case 1:
+			m.Index = uint32(f.varint)
+		case 2:
+			m.Members = uint32(f.varint)
+		case 3:
+			m.StartMs = int64(f.varint)
+		case 4:
+			m.IntervalMs = int64(f.varint)
+		case 5:
+			m.FlapChance = math.Float64frombits(f.fixed)
+		case 6:
+			m.Server = string(f.bytes)
+		case 7:
+			m.Config = f.bytes
+		case 8:
+			var k, v string
+			if err := decodeFields(f.bytes, func(e field) {
+				switch e.num {
+				case 1:
+					k = string(e.bytes)
+				case 2:
+					v = string(e.bytes)
+				}
+			}); err != nil {
+				entryErr = err
+				return
+			}
+			if m.Auto == nil {
+				m.Auto = make(map[string]string)
+			}
+			m.Auto[k] = v
+		case 9:
+			m.Scenario = f.bytes
+		case 10:
+			m.NodeFormat = string(f.bytes)
+		case 11:
+			m.Count = int64(f.varint)
+		case 12:
+			m.First = int64(f.varint)
+		case 13:
+			m.Template = string(f.bytes)
+		case 14:
+			m.NodeIDs = append(m.NodeIDs, string(f.bytes))
+		case 15:
+			m.AllowScripts = f.varint != 0
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return entryErr
+}
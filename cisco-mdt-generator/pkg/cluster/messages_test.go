@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+type message interface {
+	Marshal() ([]byte, error)
+	Unmarshal(b []byte) error
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   message
+		out  message
+	}{
+		{name: "join request", in: &JoinRequest{Member: "sim-2"}, out: &JoinRequest{}},
+		{name: "empty assignment", in: &Assignment{}, out: &Assignment{}},
+		{name: "assignment", out: &Assignment{}, in: &Assignment{
+			Index:        2,
+			Members:      3,
+			StartMs:      1767225600000,
+			IntervalMs:   5000,
+			FlapChance:   0.05,
+			Server:       "collector:57500",
+			Config:       []byte("bgp:\n  neighbors: 4\n"),
+			Auto:         map[string]string{"leaf-101": "10.0.0.1", "leaf-102": "10.0.0.2"},
+			Scenario:     []byte("events: []\n"),
+			NodeFormat:   "leaf-%d",
+			Count:        500,
+			First:        101,
+			Template:     "leaf.yaml.tmpl",
+			NodeIDs:      []string{"leaf-101", "leaf-102", "spine-1"},
+			AllowScripts: true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.in.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.out.Unmarshal(b); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.out, tt.in) {
+				t.Errorf("decoded %+v, want %+v", tt.out, tt.in)
+			}
+		})
+	}
+}
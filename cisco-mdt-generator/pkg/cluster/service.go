@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// rawMessage is a helper for sending pre-encoded protobuf data
+type rawMessage struct {
+	data []byte
+}
+
+func (m *rawMessage) Reset()         {}
+func (m *rawMessage) String() string { return string(m.data) }
+func (m *rawMessage) ProtoMessage()  {}
+
+func (m *rawMessage) Marshal() ([]byte, error) {
+	return m.data, nil
+}
+
+func (m *rawMessage) Unmarshal(b []byte) error {
+	m.data = b
+	return nil
+}
+
+// ClusterClient is the client interface for the cluster service
+type ClusterClient interface {
+	Join(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (Cluster_JoinClient, error)
+}
+
+// Cluster_JoinClient receives the assignment of a follower
+type Cluster_JoinClient interface {
+	Recv() (*Assignment, error)
+	grpc.ClientStream
+}
+
+// clusterClient implements ClusterClient
+type clusterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClusterClient creates a new cluster client
+func NewClusterClient(cc grpc.ClientConnInterface) ClusterClient {
+	return &clusterClient{cc}
+}
+
+func (c *clusterClient) Join(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (Cluster_JoinClient, error) {
+	stream, err := c.cc.NewStream(ctx, &clusterServiceDesc.Streams[0], "/mdtsim.cluster.Cluster/Join", opts...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := in.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(&rawMessage{data: data}); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &clusterJoinClient{stream}, nil
+}
+
+type clusterJoinClient struct {
+	grpc.ClientStream
+}
+
+func (x *clusterJoinClient) Recv() (*Assignment, error) {
+	m := &rawMessage{}
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	a := &Assignment{}
+	if err := a.Unmarshal(m.data); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ClusterServer is the server interface for the cluster service
+type ClusterServer interface {
+	Join(*JoinRequest, Cluster_JoinServer) error
+}
+
+// Cluster_JoinServer sends the assignment of a follower
+type Cluster_JoinServer interface {
+	Send(*Assignment) error
+	grpc.ServerStream
+}
+
+type clusterJoinServer struct {
+	grpc.ServerStream
+}
+
+func (x *clusterJoinServer) Send(m *Assignment) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return x.ServerStream.SendMsg(&rawMessage{data: data})
+}
+
+// RegisterClusterServer registers the cluster service on a gRPC server
+func RegisterClusterServer(s *grpc.Server, srv ClusterServer) {
+	s.RegisterService(&clusterServiceDesc, srv)
+}
+
+func joinHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := &rawMessage{}
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	req := &JoinRequest{}
+	if err := req.Unmarshal(m.data); err != nil {
+		return err
+	}
+	return srv.(ClusterServer).Join(req, &clusterJoinServer{stream})
+}
+
+// Service descriptor for gRPC
+var clusterServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mdtsim.cluster.Cluster",
+	HandlerType: (*ClusterServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Join",
+			Handler:       joinHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "cluster.proto",
+}
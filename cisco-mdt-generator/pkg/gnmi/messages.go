@@ -0,0 +1,543 @@
+// Package gnmi implements the subset of the OpenConfig gNMI service used by
+// the simulator. This is a manual implementation matching the field numbers
+// of gnmi.proto (github.com/openconfig/gnmi), so no generated code is needed.
+package gnmi
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SubscriptionList_Mode is how long a subscription lasts
+type SubscriptionList_Mode int32
+
+const (
+	SubscriptionList_STREAM SubscriptionList_Mode = 0
+	SubscriptionList_ONCE   SubscriptionList_Mode = 1
+	SubscriptionList_POLL   SubscriptionList_Mode = 2
+)
+
+// SubscriptionMode is how updates of a streamed path are triggered
+type SubscriptionMode int32
+
+const (
+	SubscriptionMode_TARGET_DEFINED SubscriptionMode = 0
+	SubscriptionMode_ON_CHANGE      SubscriptionMode = 1
+	SubscriptionMode_SAMPLE         SubscriptionMode = 2
+)
+
+// Encoding is the encoding requested for values
+type Encoding int32
+
+const (
+	Encoding_JSON      Encoding = 0
+	Encoding_BYTES     Encoding = 1
+	Encoding_PROTO     Encoding = 2
+	Encoding_ASCII     Encoding = 3
+	Encoding_JSON_IETF Encoding = 4
+)
+
+// PathElem is one element of a path with its list keys
+type PathElem struct {
+	Name string
+	Key  map[string]string
+}
+
+// Path identifies a node of the data tree
+type Path struct {
+	Origin string
+	Elem   []*PathElem
+	Target string
+}
+
+// TypedValue is a value with its type. Exactly one field is set.
+type TypedValue struct {
+	StringVal   *string
+	IntVal      *int64
+	UintVal     *uint64
+	BoolVal     *bool
+	BytesVal    []byte
+	FloatVal    *float32
+	DoubleVal   *float64
+	JSONVal     []byte
+	JSONIETFVal []byte
+	ASCIIVal    *string
+}
+
+// Update is a value at a path
+type Update struct {
+	Path       *Path
+	Val        *TypedValue
+	Duplicates uint32
+}
+
+// Notification carries updates and deletes sharing a timestamp and prefix
+type Notification struct {
+	Timestamp int64
+	Prefix    *Path
+	Update    []*Update
+	Delete    []*Path
+	Atomic    bool
+}
+
+// Subscription is one subscribed path
+type Subscription struct {
+	Path              *Path
+	Mode              SubscriptionMode
+	SampleInterval    uint64
+	SuppressRedundant bool
+	HeartbeatInterval uint64
+}
+
+// SubscriptionList is the set of paths of a Subscribe call
+type SubscriptionList struct {
+	Prefix       *Path
+	Subscription []*Subscription
+	Mode         SubscriptionList_Mode
+	Encoding     Encoding
+	UpdatesOnly  bool
+}
+
+// SubscribeRequest starts or polls a subscription
+type SubscribeRequest struct {
+	Subscribe *SubscriptionList
+	Poll      bool
+}
+
+// SubscribeResponse carries a notification or the end of the initial sync
+type SubscribeResponse struct {
+	Update       *Notification
+	SyncResponse bool
+}
+
+// Wire encoding helpers
+
+func appendString(buf []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendString(buf, v)
+}
+
+func appendVarint(buf []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.VarintType)
+	return protowire.AppendVarint(buf, v)
+}
+
+func appendBool(buf []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return buf
+	}
+	return appendVarint(buf, num, 1)
+}
+
+func appendMessage(buf []byte, num protowire.Number, b []byte) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, b)
+}
+
+// field is a single decoded wire field
+type field struct {
+	num    protowire.Number
+	varint uint64
+	fixed  uint64
+	bytes  []byte
+}
+
+// decodeFields walks a message and calls fn for every field, stopping at
+// the first error fn returns
+func decodeFields(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.fixed = uint64(v)
+		case protowire.Fixed64Type:
+			f.fixed, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Marshal encodes the path element to protobuf wire format
+func (m *PathElem) Marshal() ([]byte, error) {
+	buf := appendString(nil, 1, m.Name)
+	for k, v := range m.Key {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, v)
+		buf = appendMessage(buf, 2, entry)
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes the path element from protobuf wire format
+func (m *PathElem) Unmarshal(b []byte) error {
+	*m = PathElem{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Name = string(f.bytes)
+		case 2:
+			var k, v string
+			if err := decodeFields(f.bytes, func(e field) error {
+				switch e.num {
+				case 1:
+					k = string(e.bytes)
+				case 2:
+					v = string(e.bytes)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if m.Key == nil {
+				m.Key = make(map[string]string)
+			}
+			m.Key[k] = v
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the path to protobuf wire format
+func (m *Path) Marshal() ([]byte, error) {
+	buf := appendString(nil, 2, m.Origin)
+	for _, e := range m.Elem {
+		b, _ := e.Marshal()
+		buf = appendMessage(buf, 3, b)
+	}
+	buf = appendString(buf, 4, m.Target)
+	return buf, nil
+}
+
+// Unmarshal decodes the path from protobuf wire format
+func (m *Path) Unmarshal(b []byte) error {
+	*m = Path{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 2:
+			m.Origin = string(f.bytes)
+		case 3:
+			e := &PathElem{}
+			if err := e.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+			m.Elem = append(m.Elem, e)
+		case 4:
+			m.Target = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the value to protobuf wire format
+func (m *TypedValue) Marshal() ([]byte, error) {
+	var buf []byte
+	switch {
+	case m.StringVal != nil:
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendString(buf, *m.StringVal)
+	case m.IntVal != nil:
+		buf = protowire.AppendTag(buf, 2, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, uint64(*m.IntVal))
+	case m.UintVal != nil:
+		buf = protowire.AppendTag(buf, 3, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, *m.UintVal)
+	case m.BoolVal != nil:
+		buf = protowire.AppendTag(buf, 4, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, protowire.EncodeBool(*m.BoolVal))
+	case m.BytesVal != nil:
+		buf = appendMessage(buf, 5, m.BytesVal)
+	case m.FloatVal != nil:
+		buf = protowire.AppendTag(buf, 6, protowire.Fixed32Type)
+		buf = protowire.AppendFixed32(buf, math.Float32bits(*m.FloatVal))
+	case m.JSONVal != nil:
+		buf = appendMessage(buf, 10, m.JSONVal)
+	case m.JSONIETFVal != nil:
+		buf = appendMessage(buf, 11, m.JSONIETFVal)
+	case m.ASCIIVal != nil:
+		buf = protowire.AppendTag(buf, 12, protowire.BytesType)
+		buf = protowire.AppendString(buf, *m.ASCIIVal)
+	case m.DoubleVal != nil:
+		buf = protowire.AppendTag(buf, 14, protowire.Fixed64Type)
+		buf = protowire.AppendFixed64(buf, math.Float64bits(*m.DoubleVal))
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes the value from protobuf wire format
+func (m *TypedValue) Unmarshal(b []byte) error {
+	*m = TypedValue{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			v := string(f.bytes)
+			m.StringVal = &v
+		case 2:
+			v := int64(f.varint)
+			m.IntVal = &v
+		case 3:
+			v := f.varint
+			m.UintVal = &v
+		case 4:
+			v := f.varint != 0
+			m.BoolVal = &v
+		case 5:
+			m.BytesVal = append([]byte{}, f.bytes...)
+		case 6:
+			v := math.Float32frombits(uint32(f.fixed))
+			m.FloatVal = &v
+		case 10:
+			m.JSONVal = append([]byte{}, f.bytes...)
+		case 11:
+			m.JSONIETFVal = append([]byte{}, f.bytes...)
+		case 12:
+			v := string(f.bytes)
+			m.ASCIIVal = &v
+		case 14:
+			v := math.Float64frombits(f.fixed)
+			m.DoubleVal = &v
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the update to protobuf wire format
+func (m *Update) Marshal() ([]byte, error) {
+	var buf []byte
+	if m.Path != nil {
+		b, _ := m.Path.Marshal()
+		buf = appendMessage(buf, 1, b)
+	}
+	if m.Val != nil {
+		b, _ := m.Val.Marshal()
+		buf = appendMessage(buf, 3, b)
+	}
+	buf = appendVarint(buf, 4, uint64(m.Duplicates))
+	return buf, nil
+}
+
+// Unmarshal decodes the update from protobuf wire format
+func (m *Update) Unmarshal(b []byte) error {
+	*m = Update{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Path = &Path{}
+			return m.Path.Unmarshal(f.bytes)
+		case 3:
+			m.Val = &TypedValue{}
+			return m.Val.Unmarshal(f.bytes)
+		case 4:
+			m.Duplicates = uint32(f.varint)
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the notification to protobuf wire format
+func (m *Notification) Marshal() ([]byte, error) {
+	buf := appendVarint(nil, 1, uint64(m.Timestamp))
+	if m.Prefix != nil {
+		b, _ := m.Prefix.Marshal()
+		buf = appendMessage(buf, 2, b)
+	}
+	for _, u := range m.Update {
+		b, _ := u.Marshal()
+		buf = appendMessage(buf, 4, b)
+	}
+	for _, p := range m.Delete {
+		b, _ := p.Marshal()
+		buf = appendMessage(buf, 5, b)
+	}
+	buf = appendBool(buf, 6, m.Atomic)
+	return buf, nil
+}
+
+// Unmarshal decodes the notification from protobuf wire format
+func (m *Notification) Unmarshal(b []byte) error {
+	*m = Notification{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Timestamp = int64(f.varint)
+		case 2:
+			m.Prefix = &Path{}
+			return m.Prefix.Unmarshal(f.bytes)
+		case 4:
+			u := &Update{}
+			if err := u.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+			m.Update = append(m.Update, u)
+		case 5:
+			p := &Path{}
+			if err := p.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+			m.Delete = append(m.Delete, p)
+		case 6:
+			m.Atomic = f.varint != 0
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the subscription to protobuf wire format
+func (m *Subscription) Marshal() ([]byte, error) {
+	var buf []byte
+	if m.Path != nil {
+		b, _ := m.Path.Marshal()
+		buf = appendMessage(buf, 1, b)
+	}
+	buf = appendVarint(buf, 2, uint64(m.Mode))
+	buf = appendVarint(buf, 3, m.SampleInterval)
+	buf = appendBool(buf, 4, m.SuppressRedundant)
+	buf = appendVarint(buf, 5, m.HeartbeatInterval)
+	return buf, nil
+}
+
+// Unmarshal decodes the subscription from protobuf wire format
+func (m *Subscription) Unmarshal(b []byte) error {
+	*m = Subscription{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Path = &Path{}
+			return m.Path.Unmarshal(f.bytes)
+		case 2:
+			m.Mode = SubscriptionMode(f.varint)
+		case 3:
+			m.SampleInterval = f.varint
+		case 4:
+			m.SuppressRedundant = f.varint != 0
+		case 5:
+			m.HeartbeatInterval = f.varint
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the subscription list to protobuf wire format
+func (m *SubscriptionList) Marshal() ([]byte, error) {
+	var buf []byte
+	if m.Prefix != nil {
+		b, _ := m.Prefix.Marshal()
+		buf = appendMessage(buf, 1, b)
+	}
+	for _, s := range m.Subscription {
+		b, _ := s.Marshal()
+		buf = appendMessage(buf, 2, b)
+	}
+	buf = appendVarint(buf, 5, uint64(m.Mode))
+	buf = appendVarint(buf, 8, uint64(m.Encoding))
+	buf = appendBool(buf, 9, m.UpdatesOnly)
+	return buf, nil
+}
+
+// Unmarshal decodes the subscription list from protobuf wire format
+func (m *SubscriptionList) Unmarshal(b []byte) error {
+	*m = SubscriptionList{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Prefix = &Path{}
+			return m.Prefix.Unmarshal(f.bytes)
+		case 2:
+			s := &Subscription{}
+			if err := s.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+			m.Subscription = append(m.Subscription, s)
+		case 5:
+			m.Mode = SubscriptionList_Mode(f.varint)
+		case 8:
+			m.Encoding = Encoding(f.varint)
+		case 9:
+			m.UpdatesOnly = f.varint != 0
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the request to protobuf wire format
+func (m *SubscribeRequest) Marshal() ([]byte, error) {
+	var buf []byte
+	if m.Subscribe != nil {
+		b, _ := m.Subscribe.Marshal()
+		buf = appendMessage(buf, 1, b)
+	}
+	if m.Poll {
+		// Poll is an empty message
+		buf = appendMessage(buf, 3, nil)
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes the request from protobuf wire format
+func (m *SubscribeRequest) Unmarshal(b []byte) error {
+	*m = SubscribeRequest{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Subscribe = &SubscriptionList{}
+			return m.Subscribe.Unmarshal(f.bytes)
+		case 3:
+			m.Poll = true
+		}
+		return nil
+	})
+}
+
+// Marshal encodes the response to protobuf wire format
+func (m *SubscribeResponse) Marshal() ([]byte, error) {
+	var buf []byte
+	if m.Update != nil {
+		b, _ := m.Update.Marshal()
+		buf = appendMessage(buf, 1, b)
+	}
+	buf = appendBool(buf, 3, m.SyncResponse)
+	return buf, nil
+}
+
+// Unmarshal decodes the response from protobuf wire format
+func (m *SubscribeResponse) Unmarshal(b []byte) error {
+	*m = SubscribeResponse{}
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Update = &Notification{}
+			return m.Update.Unmarshal(f.bytes)
+		case 3:
+			m.SyncResponse = f.varint != 0
+		}
+		return nil
+	})
+}
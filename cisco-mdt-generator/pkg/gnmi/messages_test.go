@@ -0,0 +1,72 @@
+package gnmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+type message interface {
+	Marshal() ([]byte, error)
+	Unmarshal(b []byte) error
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	name := "leaf-101"
+	uptime := uint64(86400)
+	up := true
+	cpu := 37.5
+
+	interfaces := &Path{Elem: []*PathElem{
+		{Name: "interfaces"},
+		{Name: "interface", Key: map[string]string{"name": "Ethernet1/1"}},
+	}}
+	tests := []struct {
+		name string
+		in   message
+		out  message
+	}{
+		{name: "subscribe", out: &SubscribeRequest{}, in: &SubscribeRequest{
+			Subscribe: &SubscriptionList{
+				Prefix: &Path{Origin: "openconfig", Target: name},
+				Subscription: []*Subscription{
+					{Path: interfaces, Mode: SubscriptionMode_SAMPLE, SampleInterval: 10_000_000_000, SuppressRedundant: true, HeartbeatInterval: 60_000_000_000},
+					{Path: &Path{Elem: []*PathElem{{Name: "system"}}}, Mode: SubscriptionMode_ON_CHANGE},
+				},
+				Mode:        SubscriptionList_POLL,
+				Encoding:    Encoding_JSON_IETF,
+				UpdatesOnly: true,
+			},
+		}},
+		{name: "poll", in: &SubscribeRequest{Poll: true}, out: &SubscribeRequest{}},
+		{name: "notification", out: &SubscribeResponse{}, in: &SubscribeResponse{
+			Update: &Notification{
+				Timestamp: 1767225600123456789,
+				Prefix:    &Path{Origin: "openconfig", Target: name},
+				Update: []*Update{
+					{Path: interfaces, Val: &TypedValue{UintVal: &uptime}, Duplicates: 2},
+					{Path: &Path{Elem: []*PathElem{{Name: "hostname"}}}, Val: &TypedValue{StringVal: &name}},
+					{Path: &Path{Elem: []*PathElem{{Name: "enabled"}}}, Val: &TypedValue{BoolVal: &up}},
+					{Path: &Path{Elem: []*PathElem{{Name: "cpu"}}}, Val: &TypedValue{DoubleVal: &cpu}},
+					{Path: &Path{Elem: []*PathElem{{Name: "state"}}}, Val: &TypedValue{JSONIETFVal: []byte(`{"oper-status":"UP"}`)}},
+				},
+				Delete: []*Path{{Elem: []*PathElem{{Name: "vlan", Key: map[string]string{"id": "100"}}}}},
+				Atomic: true,
+			},
+		}},
+		{name: "sync", in: &SubscribeResponse{SyncResponse: true}, out: &SubscribeResponse{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.in.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.out.Unmarshal(b); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.out, tt.in) {
+				t.Errorf("decoded %+v, want %+v", tt.out, tt.in)
+			}
+		})
+	}
+}
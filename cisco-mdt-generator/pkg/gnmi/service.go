@@ -0,0 +1,214 @@
+package gnmi
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// rawMessage is a helper for sending pre-encoded protobuf data
+type rawMessage struct {
+	data []byte
+}
+
+func (m *rawMessage) Reset()         {}
+func (m *rawMessage) String() string { return string(m.data) }
+func (m *rawMessage) ProtoMessage()  {}
+
+func (m *rawMessage) Marshal() ([]byte, error) {
+	return m.data, nil
+}
+
+func (m *rawMessage) Unmarshal(b []byte) error {
+	m.data = b
+	return nil
+}
+
+// GNMIClient is the client interface for the subscribe part of gNMI
+type GNMIClient interface {
+	Subscribe(ctx context.Context, opts ...grpc.CallOption) (GNMI_SubscribeClient, error)
+}
+
+// GNMI_SubscribeClient sends subscribe requests and receives responses
+type GNMI_SubscribeClient interface {
+	Send(*SubscribeRequest) error
+	Recv() (*SubscribeResponse, error)
+	grpc.ClientStream
+}
+
+// gnmiClient implements GNMIClient
+type gnmiClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewGNMIClient creates a new gNMI client
+func NewGNMIClient(cc grpc.ClientConnInterface) GNMIClient {
+	return &gnmiClient{cc}
+}
+
+var subscribeStreamDesc = grpc.StreamDesc{
+	StreamName:    "Subscribe",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+func (c *gnmiClient) Subscribe(ctx context.Context, opts ...grpc.CallOption) (GNMI_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &subscribeStreamDesc, "/gnmi.gNMI/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &gnmiSubscribeClient{stream}, nil
+}
+
+type gnmiSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *gnmiSubscribeClient) Send(m *SubscribeRequest) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return x.ClientStream.SendMsg(&rawMessage{data: data})
+}
+
+func (x *gnmiSubscribeClient) Recv() (*SubscribeResponse, error) {
+	m := &rawMessage{}
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	resp := &SubscribeResponse{}
+	if err := resp.Unmarshal(m.data); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ParsePath parses a slash-separated path such as
+// "System/bgp-items/inst-items" or "interfaces/interface[name=eth1/1]/state".
+// Key values may contain slashes.
+func ParsePath(s string) *Path {
+	p := &Path{}
+	for _, elem := range splitPath(strings.Trim(s, "/")) {
+		name, keys, _ := strings.Cut(elem, "[")
+		e := &PathElem{Name: name}
+		for keys != "" {
+			var kv string
+			kv, keys, _ = strings.Cut(keys, "]")
+			keys = strings.TrimPrefix(keys, "[")
+			if k, v, ok := strings.Cut(kv, "="); ok {
+				if e.Key == nil {
+					e.Key = make(map[string]string)
+				}
+				e.Key[k] = v
+			}
+		}
+		p.Elem = append(p.Elem, e)
+	}
+	return p
+}
+
+// splitPath splits a path on slashes outside of key brackets
+func splitPath(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var elems []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '/':
+			if depth == 0 {
+				elems = append(elems, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(elems, s[start:])
+}
+
+// String renders the path in the slash-separated form accepted by ParsePath
+func (p *Path) String() string {
+	if p == nil {
+		return ""
+	}
+	var b strings.Builder
+	for i, e := range p.Elem {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		b.WriteString(e.Name)
+		for _, k := range slices.Sorted(maps.Keys(e.Key)) {
+			b.WriteString("[" + k + "=" + e.Key[k] + "]")
+		}
+	}
+	return b.String()
+}
+
+// GNMIServer is the server interface for the subscribe part of gNMI
+type GNMIServer interface {
+	Subscribe(GNMI_SubscribeServer) error
+}
+
+// GNMI_SubscribeServer receives subscribe requests and sends responses
+type GNMI_SubscribeServer interface {
+	Send(*SubscribeResponse) error
+	Recv() (*SubscribeRequest, error)
+	grpc.ServerStream
+}
+
+type gnmiSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *gnmiSubscribeServer) Send(m *SubscribeResponse) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return x.ServerStream.SendMsg(&rawMessage{data: data})
+}
+
+func (x *gnmiSubscribeServer) Recv() (*SubscribeRequest, error) {
+	m := &rawMessage{}
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	req := &SubscribeRequest{}
+	if err := req.Unmarshal(m.data); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// RegisterGNMIServer registers the gNMI service on a gRPC server
+func RegisterGNMIServer(s *grpc.Server, srv GNMIServer) {
+	s.RegisterService(&gnmiServiceDesc, srv)
+}
+
+func subscribeHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GNMIServer).Subscribe(&gnmiSubscribeServer{stream})
+}
+
+// Service descriptor for gRPC. Only Subscribe is served; the other gNMI
+// methods answer Unimplemented.
+var gnmiServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gnmi.gNMI",
+	HandlerType: (*GNMIServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       subscribeHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "gnmi.proto",
+}
@@ -0,0 +1,162 @@
+// Package kube is a minimal client of the Kubernetes API: JSON over HTTPS
+// with the pod's service account, or plain HTTP through kubectl proxy. It
+// covers what the fleet operator needs and nothing more.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Service account files mounted into every pod
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+)
+
+// Client sends requests to the API server
+type Client struct {
+	server    string
+	http      *http.Client
+	tokenFile string // read on every request, as projected tokens rotate
+}
+
+// StatusError is a request the API server refused
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the API server
+func IsNotFound(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Code == http.StatusNotFound
+}
+
+// New returns a client of an API server that needs no authentication, such
+// as http://127.0.0.1:8001 served by kubectl proxy
+func New(server string) *Client {
+	return &Client{
+		server: strings.TrimSuffix(server, "/"),
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// InCluster returns a client of the API server of the cluster the process
+// runs in, authenticated as the pod's service account
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster (KUBERNETES_SERVICE_HOST is not set)")
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+
+	c := New("https://" + net.JoinHostPort(host, port))
+	c.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	c.tokenFile = tokenFile
+	return c, nil
+}
+
+// Get reads the object or list at path into out
+func (c *Client) Get(ctx context.Context, path string, out any) error {
+	return c.do(ctx, http.MethodGet, path, "", nil, out)
+}
+
+// Apply creates or updates the object at path with server-side apply,
+// taking over fields owned by other managers
+func (c *Client) Apply(ctx context.Context, path, fieldManager string, obj any) error {
+	q := url.Values{"fieldManager": {fieldManager}, "force": {"true"}}
+	return c.do(ctx, http.MethodPatch, path+"?"+q.Encode(), "application/apply-patch+yaml", obj, nil)
+}
+
+// MergePatch applies a JSON merge patch to the object at path
+func (c *Client) MergePatch(ctx context.Context, path string, patch any) error {
+	return c.do(ctx, http.MethodPatch, path, "application/merge-patch+json", patch, nil)
+}
+
+// Delete removes the object at path and its dependents. An object that is
+// already gone is not an error.
+func (c *Client) Delete(ctx context.Context, path string) error {
+	body := map[string]any{"kind": "DeleteOptions", "apiVersion": "v1", "propagationPolicy": "Background"}
+	err := c.do(ctx, http.MethodDelete, path, "application/json", body, nil)
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// do sends a request with body encoded as JSON, which is also valid for
+// apply patches, and decodes the response into out
+func (c *Client) do(ctx context.Context, method, path, contentType string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		status := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		return &StatusError{Code: resp.StatusCode, Message: status.Message}
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package mdt_dialout
 
 import (
 	"context"
+	"fmt"
 
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/encoding/protowire"
@@ -40,6 +41,38 @@ func (m *MdtDialoutArgs) Marshal() ([]byte, error) {
 	return buf, nil
 }
 
+// Unmarshal decodes MdtDialoutArgs from protobuf wire format, failing on
+// fields the message does not have
+func (m *MdtDialoutArgs) Unmarshal(b []byte) error {
+	*m = MdtDialoutArgs{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.ReqId = int64(v)
+		case num == 2 && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			m.Data = append([]byte{}, v...)
+		case num == 3 && typ == protowire.BytesType:
+			m.Errors, n = protowire.ConsumeString(b)
+		default:
+			return fmt.Errorf("unexpected field %d of wire type %d", num, typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
 // GRPCMdtDialoutClient is the client interface for MDT dial-out
 type GRPCMdtDialoutClient interface {
 	MdtDialout(ctx context.Context, opts ...grpc.CallOption) (MdtDialout_MdtDialoutClient, error)
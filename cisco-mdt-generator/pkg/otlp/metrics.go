@@ -0,0 +1,192 @@
+// Package otlp encodes OpenTelemetry metrics export requests
+// This is a manual implementation matching the field numbers of
+// opentelemetry/proto/collector/metrics/v1/metrics_service.proto
+package otlp
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ExportPath is the OTLP/HTTP path of metric exports
+const ExportPath = "/v1/metrics"
+
+// ExportMethod is the OTLP/gRPC method of metric exports
+const ExportMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// AggregationTemporalityCumulative marks sums that accumulate since the start time
+const AggregationTemporalityCumulative = 2
+
+// KeyValue is an attribute. Exactly one value is set.
+type KeyValue struct {
+	Key         string
+	StringValue *string
+	IntValue    *int64
+	BoolValue   *bool
+	DoubleValue *float64
+}
+
+// NumberDataPoint is one value of a metric
+type NumberDataPoint struct {
+	Attributes        []*KeyValue
+	StartTimeUnixNano uint64
+	TimeUnixNano      uint64
+	AsDouble          *float64
+	AsInt             *int64
+}
+
+// Metric is a gauge, or a sum when Sum is set
+type Metric struct {
+	Name        string
+	Description string
+	Unit        string
+	DataPoints  []*NumberDataPoint
+	Sum         bool
+	Monotonic   bool
+}
+
+// ScopeMetrics groups the metrics of one instrumentation scope
+type ScopeMetrics struct {
+	ScopeName    string
+	ScopeVersion string
+	Metrics      []*Metric
+}
+
+// ResourceMetrics groups the metrics of one resource
+type ResourceMetrics struct {
+	Attributes   []*KeyValue
+	ScopeMetrics []*ScopeMetrics
+}
+
+// ExportMetricsServiceRequest is the body of a metrics export
+type ExportMetricsServiceRequest struct {
+	ResourceMetrics []*ResourceMetrics
+}
+
+// String returns a string attribute
+func String(key, value string) *KeyValue {
+	return &KeyValue{Key: key, StringValue: &value}
+}
+
+// Int returns an integer attribute
+func Int(key string, value int64) *KeyValue {
+	return &KeyValue{Key: key, IntValue: &value}
+}
+
+func appendString(buf []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendString(buf, v)
+}
+
+func appendMessage(buf []byte, num protowire.Number, b []byte) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, b)
+}
+
+func appendFixed64(buf []byte, num protowire.Number, v uint64) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(buf, v)
+}
+
+// Marshal encodes the attribute to protobuf wire format
+func (kv *KeyValue) Marshal() []byte {
+	var value []byte
+	switch {
+	case kv.StringValue != nil:
+		value = protowire.AppendTag(value, 1, protowire.BytesType)
+		value = protowire.AppendString(value, *kv.StringValue)
+	case kv.BoolValue != nil:
+		value = protowire.AppendTag(value, 2, protowire.VarintType)
+		value = protowire.AppendVarint(value, protowire.EncodeBool(*kv.BoolValue))
+	case kv.IntValue != nil:
+		value = protowire.AppendTag(value, 3, protowire.VarintType)
+		value = protowire.AppendVarint(value, uint64(*kv.IntValue))
+	case kv.DoubleValue != nil:
+		value = appendFixed64(value, 4, math.Float64bits(*kv.DoubleValue))
+	}
+
+	buf := appendString(nil, 1, kv.Key)
+	return appendMessage(buf, 2, value)
+}
+
+// Marshal encodes the data point to protobuf wire format
+func (p *NumberDataPoint) Marshal() []byte {
+	var buf []byte
+	if p.StartTimeUnixNano != 0 {
+		buf = appendFixed64(buf, 2, p.StartTimeUnixNano)
+	}
+	buf = appendFixed64(buf, 3, p.TimeUnixNano)
+	switch {
+	case p.AsDouble != nil:
+		buf = appendFixed64(buf, 4, math.Float64bits(*p.AsDouble))
+	case p.AsInt != nil:
+		// as_int is sfixed64
+		buf = appendFixed64(buf, 6, uint64(*p.AsInt))
+	}
+	for _, kv := range p.Attributes {
+		buf = appendMessage(buf, 7, kv.Marshal())
+	}
+	return buf
+}
+
+// Marshal encodes the metric to protobuf wire format
+func (m *Metric) Marshal() []byte {
+	buf := appendString(nil, 1, m.Name)
+	buf = appendString(buf, 2, m.Description)
+	buf = appendString(buf, 3, m.Unit)
+
+	var data []byte
+	for _, p := range m.DataPoints {
+		data = appendMessage(data, 1, p.Marshal())
+	}
+	if !m.Sum {
+		return appendMessage(buf, 5, data)
+	}
+
+	data = protowire.AppendTag(data, 2, protowire.VarintType)
+	data = protowire.AppendVarint(data, AggregationTemporalityCumulative)
+	if m.Monotonic {
+		data = protowire.AppendTag(data, 3, protowire.VarintType)
+		data = protowire.AppendVarint(data, 1)
+	}
+	return appendMessage(buf, 7, data)
+}
+
+// Marshal encodes the scope metrics to protobuf wire format
+func (s *ScopeMetrics) Marshal() []byte {
+	scope := appendString(nil, 1, s.ScopeName)
+	scope = appendString(scope, 2, s.ScopeVersion)
+
+	buf := appendMessage(nil, 1, scope)
+	for _, m := range s.Metrics {
+		buf = appendMessage(buf, 2, m.Marshal())
+	}
+	return buf
+}
+
+// Marshal encodes the resource metrics to protobuf wire format
+func (r *ResourceMetrics) Marshal() []byte {
+	var resource []byte
+	for _, kv := range r.Attributes {
+		resource = appendMessage(resource, 1, kv.Marshal())
+	}
+
+	buf := appendMessage(nil, 1, resource)
+	for _, s := range r.ScopeMetrics {
+		buf = appendMessage(buf, 2, s.Marshal())
+	}
+	return buf
+}
+
+// Marshal encodes the export request to protobuf wire format
+func (e *ExportMetricsServiceRequest) Marshal() ([]byte, error) {
+	var buf []byte
+	for _, r := range e.ResourceMetrics {
+		buf = appendMessage(buf, 1, r.Marshal())
+	}
+	return buf, nil
+}
@@ -0,0 +1,304 @@
+// Package parquet writes flat Parquet files
+// This is a minimal manual implementation of the format: optional columns,
+// one uncompressed PLAIN data page per column chunk, and the file metadata
+// encoded with the Thrift compact protocol as in parquet.thrift
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const magic = "PAR1"
+
+// Type is the type of a column
+type Type int
+
+const (
+	String Type = iota
+	Bool
+	Int64
+	Uint64
+	Double
+	TimestampMillis
+)
+
+// Physical types, converted types and encodings of parquet.thrift
+const (
+	typeBoolean   = 0
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+	convertedUint64          = 14
+
+	encodingPlain = 0
+	encodingRLE   = 3
+)
+
+// Column describes one optional column
+type Column struct {
+	Name string
+	Type Type
+}
+
+func (c Column) physicalType() int32 {
+	switch c.Type {
+	case Bool:
+		return typeBoolean
+	case Int64, Uint64, TimestampMillis:
+		return typeInt64
+	case Double:
+		return typeDouble
+	}
+	return typeByteArray
+}
+
+// chunkMeta locates a written column chunk
+type chunkMeta struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+type rowGroupMeta struct {
+	numRows int64
+	size    int64
+	chunks  []chunkMeta
+}
+
+// Writer writes row groups of a fixed schema to a Parquet file
+type Writer struct {
+	w         io.Writer
+	offset    int64
+	columns   []Column
+	rowGroups []rowGroupMeta
+	createdBy string
+}
+
+// NewWriter starts a Parquet file with the given columns
+func NewWriter(w io.Writer, columns []Column, createdBy string) (*Writer, error) {
+	pw := &Writer{w: w, columns: columns, createdBy: createdBy}
+	if err := pw.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Columns returns the schema of the file
+func (w *Writer) Columns() []Column {
+	return w.columns
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+// WriteRowGroup writes rows holding one value per column, nil for null.
+// Values must match the column type: string, bool, int64, uint64 or float64;
+// timestamps are int64 milliseconds.
+func (w *Writer) WriteRowGroup(rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	rg := rowGroupMeta{numRows: int64(len(rows))}
+	for i, col := range w.columns {
+		page, err := encodePage(col, rows, i)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col.Name, err)
+		}
+
+		header := pageHeader(len(rows), len(page))
+		chunk := chunkMeta{offset: w.offset, size: int64(len(header) + len(page)), numValues: int64(len(rows))}
+		if err := w.write(header); err != nil {
+			return err
+		}
+		if err := w.write(page); err != nil {
+			return err
+		}
+		rg.chunks = append(rg.chunks, chunk)
+		rg.size += chunk.size
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	return nil
+}
+
+// Close writes the file footer. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	footer := w.fileMetadata()
+	if err := w.write(footer); err != nil {
+		return err
+	}
+	if err := w.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))); err != nil {
+		return err
+	}
+	return w.write([]byte(magic))
+}
+
+// encodePage encodes the definition levels and PLAIN values of a column
+func encodePage(col Column, rows [][]any, i int) ([]byte, error) {
+	levels := make([]byte, len(rows))
+	var values []byte
+	var bits []bool
+
+	for r, row := range rows {
+		v := row[i]
+		if v == nil {
+			continue
+		}
+		levels[r] = 1
+
+		switch col.Type {
+		case String:
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("row %d: %T is not a string", r, v)
+			}
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(s)))
+			values = append(values, s...)
+		case Bool:
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("row %d: %T is not a bool", r, v)
+			}
+			bits = append(bits, b)
+		case Int64, TimestampMillis:
+			n, ok := v.(int64)
+			if !ok {
+				return nil, fmt.Errorf("row %d: %T is not an int64", r, v)
+			}
+			values = binary.LittleEndian.AppendUint64(values, uint64(n))
+		case Uint64:
+			n, ok := v.(uint64)
+			if !ok {
+				return nil, fmt.Errorf("row %d: %T is not a uint64", r, v)
+			}
+			values = binary.LittleEndian.AppendUint64(values, n)
+		case Double:
+			f, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("row %d: %T is not a float64", r, v)
+			}
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(f))
+		}
+	}
+
+	// Booleans are bit-packed, least significant bit first
+	if col.Type == Bool {
+		values = make([]byte, (len(bits)+7)/8)
+		for j, b := range bits {
+			if b {
+				values[j/8] |= 1 << (j % 8)
+			}
+		}
+	}
+
+	encoded := encodeLevels(levels)
+	page := binary.LittleEndian.AppendUint32(nil, uint32(len(encoded)))
+	page = append(page, encoded...)
+	return append(page, values...), nil
+}
+
+// encodeLevels encodes definition levels of bit width 1 as RLE runs of the
+// RLE/bit-packing hybrid encoding
+func encodeLevels(levels []byte) []byte {
+	var buf []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		buf = append(buf, levels[i])
+		i = j
+	}
+	return buf
+}
+
+// pageHeader encodes the PageHeader of an uncompressed v1 data page
+func pageHeader(numValues, size int) []byte {
+	var e thriftEncoder
+	e.i32(1, 0) // DATA_PAGE
+	e.i32(2, int32(size))
+	e.i32(3, int32(size))
+	e.beginStruct(5)
+	e.i32(1, int32(numValues))
+	e.i32(2, encodingPlain)
+	e.i32(3, encodingRLE)
+	e.i32(4, encodingRLE)
+	e.endStruct()
+	e.endStruct()
+	return e.buf
+}
+
+// fileMetadata encodes the FileMetaData footer
+func (w *Writer) fileMetadata() []byte {
+	var numRows int64
+	for _, rg := range w.rowGroups {
+		numRows += rg.numRows
+	}
+
+	var e thriftEncoder
+	e.i32(1, 1)
+
+	e.beginList(2, thriftStruct, len(w.columns)+1)
+	e.beginElement()
+	e.binary(4, "schema")
+	e.i32(5, int32(len(w.columns)))
+	e.endStruct()
+	for _, col := range w.columns {
+		e.beginElement()
+		e.i32(1, col.physicalType())
+		e.i32(3, 1) // OPTIONAL
+		e.binary(4, col.Name)
+		switch col.Type {
+		case String:
+			e.i32(6, convertedUTF8)
+		case Uint64:
+			e.i32(6, convertedUint64)
+		case TimestampMillis:
+			e.i32(6, convertedTimestampMillis)
+		}
+		e.endStruct()
+	}
+
+	e.i64(3, numRows)
+
+	e.beginList(4, thriftStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		e.beginElement()
+		e.beginList(1, thriftStruct, len(rg.chunks))
+		for i, chunk := range rg.chunks {
+			col := w.columns[i]
+			e.beginElement()
+			e.i64(2, chunk.offset)
+			e.beginStruct(3)
+			e.i32(1, col.physicalType())
+			e.beginList(2, thriftI32, 2)
+			e.listI32(encodingPlain)
+			e.listI32(encodingRLE)
+			e.beginList(3, thriftBinary, 1)
+			e.listBinary(col.Name)
+			e.i32(4, 0) // UNCOMPRESSED
+			e.i64(5, chunk.numValues)
+			e.i64(6, chunk.size)
+			e.i64(7, chunk.size)
+			e.i64(9, chunk.offset)
+			e.endStruct()
+			e.endStruct()
+		}
+		e.i64(2, rg.size)
+		e.i64(3, rg.numRows)
+		e.endStruct()
+	}
+
+	e.binary(6, w.createdBy)
+	e.endStruct()
+	return e.buf
+}
@@ -0,0 +1,90 @@
+package parquet
+
+import "encoding/binary"
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftEncoder writes Thrift compact protocol structs. Field ids are delta
+// encoded against the previous field of the enclosing struct.
+type thriftEncoder struct {
+	buf  []byte
+	last []int16 // previous field id per open struct, innermost last
+}
+
+func (e *thriftEncoder) field(id int16, typ byte) {
+	if len(e.last) == 0 {
+		e.last = append(e.last, 0)
+	}
+	prev := &e.last[len(e.last)-1]
+	if delta := id - *prev; delta > 0 && delta <= 15 {
+		e.buf = append(e.buf, byte(delta)<<4|typ)
+	} else {
+		e.buf = append(e.buf, typ)
+		e.buf = binary.AppendVarint(e.buf, int64(id))
+	}
+	*prev = id
+}
+
+func (e *thriftEncoder) i32(id int16, v int32) {
+	e.field(id, thriftI32)
+	e.buf = binary.AppendVarint(e.buf, int64(v))
+}
+
+func (e *thriftEncoder) i64(id int16, v int64) {
+	e.field(id, thriftI64)
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *thriftEncoder) binary(id int16, s string) {
+	e.field(id, thriftBinary)
+	e.listBinary(s)
+}
+
+// beginStruct starts a struct field, closed by endStruct
+func (e *thriftEncoder) beginStruct(id int16) {
+	e.field(id, thriftStruct)
+	e.last = append(e.last, 0)
+}
+
+// beginElement starts a struct element of a list, closed by endStruct
+func (e *thriftEncoder) beginElement() {
+	if len(e.last) == 0 {
+		e.last = append(e.last, 0)
+	}
+	e.last = append(e.last, 0)
+}
+
+// endStruct writes the stop field of the innermost struct
+func (e *thriftEncoder) endStruct() {
+	e.buf = append(e.buf, 0)
+	if len(e.last) > 0 {
+		e.last = e.last[:len(e.last)-1]
+	}
+}
+
+// beginList starts a list field of n elements
+func (e *thriftEncoder) beginList(id int16, elem byte, n int) {
+	e.field(id, thriftList)
+	if n < 15 {
+		e.buf = append(e.buf, byte(n)<<4|elem)
+		return
+	}
+	e.buf = append(e.buf, 0xF0|elem)
+	e.buf = binary.AppendUvarint(e.buf, uint64(n))
+}
+
+func (e *thriftEncoder) listI32(v int32) {
+	e.buf = binary.AppendVarint(e.buf, int64(v))
+}
+
+func (e *thriftEncoder) listBinary(s string) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
@@ -0,0 +1,131 @@
+//go:build wasip1
+
+// Package plugin is the Go binding of the host API of sensor plugins. A
+// plugin exports a collect function, called every interval, which emits the
+// rows of one telemetry message:
+//
+//	//go:wasmexport collect
+//	func collect() {
+//		plugin.Row()
+//		plugin.KeyString("name", "eth1/1")
+//		plugin.FieldDouble("rx-power", -2.1)
+//	}
+//
+// Build plugins as WASI reactors:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o optics.wasm
+package plugin
+
+import (
+	"time"
+	"unsafe"
+)
+
+//go:wasmimport mdtsim clock_ms
+func clockMs() int64
+
+//go:wasmimport mdtsim node_id
+func nodeID(ptr unsafe.Pointer, size uint32) uint32
+
+//go:wasmimport mdtsim log
+func logMessage(ptr unsafe.Pointer, size uint32)
+
+//go:wasmimport mdtsim row
+func row()
+
+//go:wasmimport mdtsim key_string
+func keyString(name unsafe.Pointer, nameLen uint32, value unsafe.Pointer, valueLen uint32)
+
+//go:wasmimport mdtsim key_uint64
+func keyUint64(name unsafe.Pointer, nameLen uint32, value uint64)
+
+//go:wasmimport mdtsim field_string
+func fieldString(name unsafe.Pointer, nameLen uint32, value unsafe.Pointer, valueLen uint32)
+
+//go:wasmimport mdtsim field_uint64
+func fieldUint64(name unsafe.Pointer, nameLen uint32, value uint64)
+
+//go:wasmimport mdtsim field_int64
+func fieldInt64(name unsafe.Pointer, nameLen uint32, value int64)
+
+//go:wasmimport mdtsim field_double
+func fieldDouble(name unsafe.Pointer, nameLen uint32, value float64)
+
+//go:wasmimport mdtsim field_bool
+func fieldBool(name unsafe.Pointer, nameLen uint32, value uint32)
+
+// ptr returns the address and length of a string in plugin memory
+func ptr(s string) (unsafe.Pointer, uint32) {
+	return unsafe.Pointer(unsafe.StringData(s)), uint32(len(s))
+}
+
+// Clock returns the timestamp of the message being collected, which follows
+// the simulation clock rather than the wall clock
+func Clock() time.Time {
+	return time.UnixMilli(clockMs())
+}
+
+// NodeID returns the node-id-str of the simulated node
+func NodeID() string {
+	buf := make([]byte, 256)
+	n := nodeID(unsafe.Pointer(&buf[0]), uint32(len(buf)))
+	return string(buf[:min(int(n), len(buf))])
+}
+
+// Log writes a message to the simulator log
+func Log(msg string) {
+	logMessage(ptr(msg))
+}
+
+// Row starts a new row. Keys and fields are added to the current row.
+func Row() {
+	row()
+}
+
+// KeyString adds a string key to the current row
+func KeyString(name, value string) {
+	n, nl := ptr(name)
+	v, vl := ptr(value)
+	keyString(n, nl, v, vl)
+}
+
+// KeyUint64 adds an unsigned key to the current row
+func KeyUint64(name string, value uint64) {
+	n, nl := ptr(name)
+	keyUint64(n, nl, value)
+}
+
+// FieldString adds a string field to the current row
+func FieldString(name, value string) {
+	n, nl := ptr(name)
+	v, vl := ptr(value)
+	fieldString(n, nl, v, vl)
+}
+
+// FieldUint64 adds an unsigned field to the current row
+func FieldUint64(name string, value uint64) {
+	n, nl := ptr(name)
+	fieldUint64(n, nl, value)
+}
+
+// FieldInt64 adds a signed field to the current row
+func FieldInt64(name string, value int64) {
+	n, nl := ptr(name)
+	fieldInt64(n, nl, value)
+}
+
+// FieldDouble adds a floating point field to the current row
+func FieldDouble(name string, value float64) {
+	n, nl := ptr(name)
+	fieldDouble(n, nl, value)
+}
+
+// FieldBool adds a boolean field to the current row
+func FieldBool(name string, value bool) {
+	n, nl := ptr(name)
+	var v uint32
+	if value {
+		v = 1
+	}
+	fieldBool(n, nl, v)
+}
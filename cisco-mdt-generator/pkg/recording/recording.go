@@ -0,0 +1,114 @@
+// Package recording reads and writes recorded telemetry streams
+//
+// A recording starts with the magic line "MDTREC2\n" followed by one record
+// per telemetry message: the send time in microseconds since the epoch and
+// the payload length as unsigned varints, then the encoded Telemetry message.
+// Recordings of version 1, "MDTREC1\n", hold the send time in milliseconds
+// and are still read.
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// magic identifies a recording file and its format version
+const magic = "MDTREC2\n"
+
+// magicV1 identifies recordings with millisecond timestamps
+const magicV1 = "MDTREC1\n"
+
+// maxPayload guards against reading garbage as a huge length
+const maxPayload = 64 << 20
+
+// Record is a single recorded telemetry message
+type Record struct {
+	Timestamp time.Time
+	Payload   []byte
+}
+
+// Writer appends records to a recording
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter writes the recording header and returns a writer for records
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(magic); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw}, nil
+}
+
+// Write appends a record
+func (w *Writer) Write(r Record) error {
+	var hdr [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(r.Timestamp.UnixMicro()))
+	n += binary.PutUvarint(hdr[n:], uint64(len(r.Payload)))
+	if _, err := w.w.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(r.Payload)
+	return err
+}
+
+// Flush writes buffered records to the underlying writer
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Reader reads records from a recording
+type Reader struct {
+	r    *bufio.Reader
+	unit time.Duration // of the timestamps
+}
+
+// NewReader checks the recording header and returns a reader for records
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, fmt.Errorf("not a telemetry recording")
+	}
+	switch string(hdr) {
+	case magic:
+		return &Reader{r: br, unit: time.Microsecond}, nil
+	case magicV1:
+		return &Reader{r: br, unit: time.Millisecond}, nil
+	}
+	return nil, fmt.Errorf("not a telemetry recording")
+}
+
+// Next returns the next record, or io.EOF at the end of the recording
+func (r *Reader) Next() (Record, error) {
+	ts, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return Record{}, err
+	}
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return Record{}, truncated(err)
+	}
+	if size > maxPayload {
+		return Record{}, fmt.Errorf("record of %d bytes exceeds limit", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return Record{}, truncated(err)
+	}
+	return Record{Timestamp: time.Unix(0, int64(ts)*int64(r.unit)), Payload: payload}, nil
+}
+
+// truncated reports an end of file in the middle of a record as an error
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,811 @@
+// Package ssh implements the server side of the subset of SSH 2.0 (RFC 4251
+// to 4254) a simulated device CLI needs: curve25519-sha256 key exchange, an
+// ssh-ed25519 host key, aes128-ctr with hmac-sha2-256, password
+// authentication and one session channel running a shell or a command.
+// Rekeying, port forwarding and further channels are refused.
+package ssh
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message numbers
+const (
+	msgDisconnect          = 1
+	msgIgnore              = 2
+	msgUnimplemented       = 3
+	msgDebug               = 4
+	msgServiceRequest      = 5
+	msgServiceAccept       = 6
+	msgKexInit             = 20
+	msgNewKeys             = 21
+	msgKexECDHInit         = 30
+	msgKexECDHReply        = 31
+	msgUserauthRequest     = 50
+	msgUserauthFailure     = 51
+	msgUserauthSuccess     = 52
+	msgGlobalRequest       = 80
+	msgRequestFailure      = 82
+	msgChannelOpen         = 90
+	msgChannelOpenConfirm  = 91
+	msgChannelOpenFailure  = 92
+	msgChannelWindowAdjust = 93
+	msgChannelData         = 94
+	msgChannelEOF          = 96
+	msgChannelClose        = 97
+	msgChannelRequest      = 98
+	msgChannelSuccess      = 99
+	msgChannelFailure      = 100
+)
+
+// The only algorithms offered
+const (
+	kexAlgo     = "curve25519-sha256"
+	kexAlgoOld  = "curve25519-sha256@libssh.org"
+	hostKeyAlgo = "ssh-ed25519"
+	cipherAlgo  = "aes128-ctr"
+	macAlgo     = "hmac-sha2-256"
+)
+
+const (
+	maxPacket      = 35000 // largest packet accepted, as RFC 4253 requires
+	channelWindow  = 1 << 20
+	channelPacket  = 32768
+	handshakeLimit = 30 * time.Second // to exchange keys and authenticate
+	maxAuthTries   = 6
+)
+
+// Config is the identity and login policy of a server
+type Config struct {
+	HostKey ed25519.PrivateKey
+	Version string // identification line, e.g. SSH-2.0-Cisco-1.25
+
+	// Password checks a login. Without it every user logs in without a
+	// password.
+	Password func(user, password string) bool
+}
+
+// Session is the session channel of an authenticated client
+type Session struct {
+	User    string
+	Command string // of an exec request, empty for a shell
+	PTY     bool   // the client asked for a terminal, so the server echoes input
+
+	c  *conn
+	in inbox
+}
+
+// Read returns the data the client sent, or io.EOF once it sent no more
+func (s *Session) Read(p []byte) (int, error) {
+	return s.in.read(p)
+}
+
+// Write sends data to the client within its channel window
+func (s *Session) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n, err := s.c.reserve(len(p))
+		if err != nil {
+			return written, err
+		}
+		var w writer
+		w.byte(msgChannelData)
+		w.uint32(s.c.remote)
+		w.string(p[:n])
+		if err := s.c.t.writePacket(w); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Serve runs the protocol on an accepted connection until the client
+// disconnects, calling handle for the session channel once it starts a shell
+// or a command. When handle returns, its result is sent as the exit status
+// and the channel is closed. The connection is closed on return.
+func Serve(nc net.Conn, cfg *Config, handle func(s *Session) uint32) error {
+	defer nc.Close()
+	t := &transport{conn: nc, r: bufio.NewReader(nc)}
+
+	nc.SetDeadline(time.Now().Add(handshakeLimit))
+	if err := t.handshake(cfg); err != nil {
+		return fmt.Errorf("key exchange: %w", err)
+	}
+	user, err := t.authenticate(cfg)
+	if err != nil {
+		return fmt.Errorf("authentication: %w", err)
+	}
+	nc.SetDeadline(time.Time{})
+
+	c := &conn{t: t, user: user}
+	c.cond = sync.NewCond(&c.mu)
+	defer c.shutdown()
+	return c.serve(handle)
+}
+
+// transport is the binary packet protocol of a connection
+type transport struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+	in   direction
+	out  direction
+	keys bool // the first key exchange is done
+}
+
+// direction is the sequence number and keys of one direction
+type direction struct {
+	seq    uint32
+	stream cipher.Stream // nil before the keys are exchanged
+	mac    hash.Hash
+}
+
+// blockSize is the size packets of a direction are padded to
+func (d *direction) blockSize() int {
+	if d.stream != nil {
+		return aes.BlockSize
+	}
+	return 8
+}
+
+// readPacket reads, decrypts and checks the next packet and returns its
+// payload
+func (t *transport) readPacket() ([]byte, error) {
+	d := &t.in
+	bs := d.blockSize()
+	packet := make([]byte, bs)
+	if _, err := io.ReadFull(t.r, packet); err != nil {
+		return nil, err
+	}
+	if d.stream != nil {
+		d.stream.XORKeyStream(packet, packet)
+	}
+	length := binary.BigEndian.Uint32(packet)
+	if length > maxPacket || int(length)+4 < bs || (int(length)+4)%bs != 0 {
+		return nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	packet = append(packet, make([]byte, int(length)+4-bs)...)
+	if _, err := io.ReadFull(t.r, packet[bs:]); err != nil {
+		return nil, err
+	}
+	if d.stream != nil {
+		d.stream.XORKeyStream(packet[bs:], packet[bs:])
+	}
+	if d.mac != nil {
+		mac := make([]byte, d.mac.Size())
+		if _, err := io.ReadFull(t.r, mac); err != nil {
+			return nil, err
+		}
+		if !hmac.Equal(mac, d.sum(packet)) {
+			return nil, errors.New("message authentication failed")
+		}
+	}
+	d.seq++
+
+	padding := int(packet[4])
+	if 5+padding > len(packet) {
+		return nil, fmt.Errorf("invalid padding length %d", padding)
+	}
+	return packet[5 : len(packet)-padding], nil
+}
+
+// writePacket pads, authenticates and encrypts a payload and sends it
+func (t *transport) writePacket(payload []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	d := &t.out
+	bs := d.blockSize()
+	padding := bs - (5+len(payload))%bs
+	if padding < 4 {
+		padding += bs
+	}
+	packet := make([]byte, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	packet[4] = byte(padding)
+	copy(packet[5:], payload)
+	rand.Read(packet[5+len(payload):])
+
+	var mac []byte
+	if d.mac != nil {
+		mac = d.sum(packet)
+	}
+	if d.stream != nil {
+		d.stream.XORKeyStream(packet, packet)
+	}
+	d.seq++
+	_, err := t.conn.Write(append(packet, mac...))
+	return err
+}
+
+// sum returns the MAC of an unencrypted packet
+func (d *direction) sum(packet []byte) []byte {
+	d.mac.Reset()
+	binary.Write(d.mac, binary.BigEndian, d.seq)
+	d.mac.Write(packet)
+	return d.mac.Sum(nil)
+}
+
+// next returns the next payload that is not transport chatter. A
+// disconnect ends the connection with io.EOF.
+func (t *transport) next() ([]byte, error) {
+	for {
+		p, err := t.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if len(p) == 0 {
+			return nil, errors.New("empty packet")
+		}
+		switch p[0] {
+		case msgIgnore, msgDebug, msgUnimplemented:
+			continue
+		case msgDisconnect:
+			return nil, io.EOF
+		case msgKexInit:
+			if t.keys {
+				return nil, errors.New("rekeying is not supported")
+			}
+		}
+		return p, nil
+	}
+}
+
+// handshake exchanges versions and keys. The server offers a single
+// algorithm of each kind and fails when the client does not support it.
+func (t *transport) handshake(cfg *Config) error {
+	if _, err := fmt.Fprintf(t.conn, "%s\r\n", cfg.Version); err != nil {
+		return err
+	}
+	var clientVersion string
+	for {
+		line, err := t.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		// Lines before the identification are ignored
+		if strings.HasPrefix(line, "SSH-") {
+			clientVersion = line
+			break
+		}
+	}
+	if !strings.HasPrefix(clientVersion, "SSH-2.0-") && !strings.HasPrefix(clientVersion, "SSH-1.99-") {
+		return fmt.Errorf("unsupported client version %q", clientVersion)
+	}
+
+	var serverInit writer
+	serverInit.byte(msgKexInit)
+	cookie := make([]byte, 16)
+	rand.Read(cookie)
+	serverInit = append(serverInit, cookie...)
+	for _, list := range []string{kexAlgo + "," + kexAlgoOld, hostKeyAlgo, cipherAlgo, cipherAlgo, macAlgo, macAlgo, "none", "none", "", ""} {
+		serverInit.string([]byte(list))
+	}
+	serverInit.byte(0) // first_kex_packet_follows
+	serverInit.uint32(0)
+	if err := t.writePacket(serverInit); err != nil {
+		return err
+	}
+
+	clientInit, err := t.next()
+	if err != nil {
+		return err
+	}
+	if clientInit[0] != msgKexInit {
+		return fmt.Errorf("expected key exchange init, got message %d", clientInit[0])
+	}
+	// The message type and the 16-byte cookie precede the name-lists
+	if len(clientInit) < 17 {
+		return fmt.Errorf("key exchange init of %d bytes is too short", len(clientInit))
+	}
+	r := reader{b: clientInit[17:]}
+	var lists [10][]string
+	for i := range lists {
+		lists[i] = strings.Split(string(r.string()), ",")
+	}
+	follows := r.bool()
+	if r.err != nil {
+		return r.err
+	}
+	kex := ""
+	for _, k := range lists[0] {
+		if k == kexAlgo || k == kexAlgoOld {
+			kex = k
+			break
+		}
+	}
+	kinds := []string{"key exchange", "host key", "cipher", "cipher", "MAC", "MAC", "compression", "compression"}
+	for i, want := range []string{kex, hostKeyAlgo, cipherAlgo, cipherAlgo, macAlgo, macAlgo, "none", "none"} {
+		if want == "" {
+			want = kexAlgo
+		}
+		if !slices.Contains(lists[i], want) {
+			return fmt.Errorf("client does not support %s algorithm %s", kinds[i], want)
+		}
+	}
+	if follows && (lists[0][0] != kex || lists[1][0] != hostKeyAlgo) {
+		// A wrongly guessed key exchange packet is ignored
+		if _, err := t.readPacket(); err != nil {
+			return err
+		}
+	}
+
+	p, err := t.next()
+	if err != nil {
+		return err
+	}
+	if p[0] != msgKexECDHInit {
+		return fmt.Errorf("expected ECDH init, got message %d", p[0])
+	}
+	r = reader{b: p[1:]}
+	clientPub := r.string()
+	if r.err != nil {
+		return r.err
+	}
+	peer, err := ecdh.X25519().NewPublicKey(clientPub)
+	if err != nil {
+		return err
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	secret, err := priv.ECDH(peer)
+	if err != nil {
+		return err
+	}
+	var k writer
+	k.mpint(secret)
+
+	var hostKey writer
+	hostKey.string([]byte(hostKeyAlgo))
+	hostKey.string(cfg.HostKey.Public().(ed25519.PublicKey))
+
+	var exchange writer
+	exchange.string([]byte(clientVersion))
+	exchange.string([]byte(cfg.Version))
+	exchange.string(clientInit)
+	exchange.string(serverInit)
+	exchange.string(hostKey)
+	exchange.string(clientPub)
+	exchange.string(priv.PublicKey().Bytes())
+	exchange = append(exchange, k...)
+	h := sha256.Sum256(exchange)
+
+	var sig writer
+	sig.string([]byte(hostKeyAlgo))
+	sig.string(ed25519.Sign(cfg.HostKey, h[:]))
+
+	var reply writer
+	reply.byte(msgKexECDHReply)
+	reply.string(hostKey)
+	reply.string(priv.PublicKey().Bytes())
+	reply.string(sig)
+	if err := t.writePacket(reply); err != nil {
+		return err
+	}
+	if err := t.writePacket([]byte{msgNewKeys}); err != nil {
+		return err
+	}
+	// The exchange hash of the first key exchange is the session ID
+	t.out.setKeys(k, h[:], h[:], 'B', 'D', 'F')
+
+	if p, err = t.next(); err != nil {
+		return err
+	}
+	if p[0] != msgNewKeys {
+		return fmt.Errorf("expected new keys, got message %d", p[0])
+	}
+	t.in.setKeys(k, h[:], h[:], 'A', 'C', 'E')
+	t.keys = true
+	return nil
+}
+
+// setKeys derives the IV, cipher key and MAC key of a direction from the
+// shared secret, as RFC 4253 section 7.2 describes
+func (d *direction) setKeys(k, h, sessionID []byte, iv, key, mac byte) {
+	block, _ := aes.NewCipher(deriveKey(k, h, sessionID, key, 16))
+	d.stream = cipher.NewCTR(block, deriveKey(k, h, sessionID, iv, aes.BlockSize))
+	d.mac = hmac.New(sha256.New, deriveKey(k, h, sessionID, mac, sha256.Size))
+}
+
+// deriveKey returns n bytes of HASH(K || H || letter || session_id),
+// extended with HASH(K || H || key so far) as needed
+func deriveKey(k, h, sessionID []byte, letter byte, n int) []byte {
+	d := sha256.New()
+	d.Write(k)
+	d.Write(h)
+	d.Write([]byte{letter})
+	d.Write(sessionID)
+	key := d.Sum(nil)
+	for len(key) < n {
+		d.Reset()
+		d.Write(k)
+		d.Write(h)
+		d.Write(key)
+		key = d.Sum(key)
+	}
+	return key[:n]
+}
+
+// authenticate accepts the user authentication service and returns the
+// user once it logged in
+func (t *transport) authenticate(cfg *Config) (string, error) {
+	p, err := t.next()
+	if err != nil {
+		return "", err
+	}
+	r := reader{b: p[1:]}
+	if service := string(r.string()); p[0] != msgServiceRequest || service != "ssh-userauth" {
+		return "", fmt.Errorf("expected ssh-userauth service request, got message %d", p[0])
+	}
+	var accept writer
+	accept.byte(msgServiceAccept)
+	accept.string([]byte("ssh-userauth"))
+	if err := t.writePacket(accept); err != nil {
+		return "", err
+	}
+
+	failure := writer{msgUserauthFailure}
+	failure.string([]byte("password"))
+	failure.byte(0)
+	for tries := 0; tries < maxAuthTries; {
+		p, err := t.next()
+		if err != nil {
+			return "", err
+		}
+		if p[0] != msgUserauthRequest {
+			return "", fmt.Errorf("expected authentication request, got message %d", p[0])
+		}
+		r := reader{b: p[1:]}
+		user := string(r.string())
+		r.string() // service
+		method := string(r.string())
+		ok := cfg.Password == nil
+		if !ok && method == "password" {
+			r.bool()
+			password := string(r.string())
+			ok = r.err == nil && cfg.Password(user, password)
+			tries++
+		}
+		if ok {
+			return user, t.writePacket([]byte{msgUserauthSuccess})
+		}
+		if err := t.writePacket(failure); err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("too many failed logins")
+}
+
+// conn is the connection protocol of an authenticated client with its
+// session channel
+type conn struct {
+	t    *transport
+	user string
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	open       bool   // the session channel is open
+	remote     uint32 // channel number of the client
+	window     uint32 // bytes the client accepts
+	packetSize uint32
+	closed     bool // a close was sent or the connection ended
+	session    *Session
+}
+
+// serve handles the requests of the client until it closes the channel or
+// disconnects
+func (c *conn) serve(handle func(s *Session) uint32) error {
+	pty := false
+	for {
+		p, err := c.t.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r := reader{b: p[1:]}
+		switch p[0] {
+		case msgGlobalRequest:
+			r.string()
+			if r.bool() {
+				err = c.t.writePacket([]byte{msgRequestFailure})
+			}
+
+		case msgChannelOpen:
+			err = c.openChannel(&r)
+
+		case msgChannelRequest:
+			r.uint32()
+			typ := string(r.string())
+			wantReply := r.bool()
+			ok := false
+			switch typ {
+			case "pty-req":
+				pty, ok = true, c.session == nil
+			case "shell", "exec":
+				if c.session == nil {
+					s := &Session{User: c.user, PTY: pty, c: c}
+					if typ == "exec" {
+						s.Command = string(r.string())
+					}
+					if ok = r.err == nil; ok {
+						c.session = s
+					}
+				}
+			}
+			if wantReply {
+				reply := writer{msgChannelFailure}
+				if ok {
+					reply[0] = msgChannelSuccess
+				}
+				reply.uint32(c.remote)
+				err = c.t.writePacket(reply)
+			}
+			// The shell starts once the client knows it was accepted
+			if err == nil && ok && (typ == "shell" || typ == "exec") {
+				go c.run(c.session, handle)
+			}
+
+		case msgChannelData:
+			r.uint32()
+			data := r.string()
+			if r.err != nil {
+				return r.err
+			}
+			if c.session != nil {
+				c.session.in.write(data)
+			}
+			// Input is buffered without limit, so the window is
+			// restored right away
+			adjust := writer{msgChannelWindowAdjust}
+			adjust.uint32(c.remote)
+			adjust.uint32(uint32(len(data)))
+			err = c.t.writePacket(adjust)
+
+		case msgChannelWindowAdjust:
+			r.uint32()
+			n := r.uint32()
+			c.mu.Lock()
+			c.window += n
+			c.cond.Broadcast()
+			c.mu.Unlock()
+
+		case msgChannelEOF:
+			if c.session != nil {
+				c.session.in.close()
+			}
+
+		case msgChannelClose:
+			c.close()
+			return nil
+
+		case msgChannelSuccess, msgChannelFailure:
+
+		default:
+			unimplemented := writer{msgUnimplemented}
+			unimplemented.uint32(c.t.in.seq - 1)
+			err = c.t.writePacket(unimplemented)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// openChannel accepts the first session channel and refuses any other
+func (c *conn) openChannel(r *reader) error {
+	typ := string(r.string())
+	sender, window, packetSize := r.uint32(), r.uint32(), r.uint32()
+	if r.err != nil {
+		return r.err
+	}
+	var w writer
+	c.mu.Lock()
+	if typ != "session" || c.open {
+		c.mu.Unlock()
+		w.byte(msgChannelOpenFailure)
+		w.uint32(sender)
+		w.uint32(1) // administratively prohibited
+		w.string([]byte("only one session channel is supported"))
+		w.string(nil)
+		return c.t.writePacket(w)
+	}
+	c.open, c.remote, c.window, c.packetSize = true, sender, window, packetSize
+	c.mu.Unlock()
+	w.byte(msgChannelOpenConfirm)
+	w.uint32(sender)
+	w.uint32(0)
+	w.uint32(channelWindow)
+	w.uint32(channelPacket)
+	return c.t.writePacket(w)
+}
+
+// run calls the handler of a session, then sends its exit status and
+// closes the channel
+func (c *conn) run(s *Session, handle func(s *Session) uint32) {
+	status := handle(s)
+	exit := writer{msgChannelRequest}
+	exit.uint32(c.remote)
+	exit.string([]byte("exit-status"))
+	exit.byte(0)
+	exit.uint32(status)
+	eof := writer{msgChannelEOF}
+	eof.uint32(c.remote)
+	if c.t.writePacket(exit) == nil && c.t.writePacket(eof) == nil {
+		c.close()
+	}
+}
+
+// close sends the close of the channel, once
+func (c *conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.cond.Broadcast()
+	if c.open {
+		w := writer{msgChannelClose}
+		w.uint32(c.remote)
+		c.t.writePacket(w)
+	}
+}
+
+// shutdown ends a session still running when the connection ends
+func (c *conn) shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	if c.session != nil {
+		c.session.in.close()
+	}
+}
+
+// reserve waits until the client accepts data and takes up to n bytes of
+// its window
+func (c *conn) reserve(n int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.window == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n = min(n, int(c.window), int(c.packetSize), channelPacket)
+	c.window -= uint32(n)
+	return n, nil
+}
+
+// inbox buffers the data a client sent until the session reads it
+type inbox struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+func (b *inbox) init() {
+	if b.cond == nil {
+		b.cond = sync.NewCond(&b.mu)
+	}
+}
+
+func (b *inbox) write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.buf = append(b.buf, p...)
+	b.cond.Broadcast()
+}
+
+func (b *inbox) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+func (b *inbox) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	for len(b.buf) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// writer appends SSH wire types
+type writer []byte
+
+func (w *writer) byte(b byte) { *w = append(*w, b) }
+
+func (w *writer) uint32(v uint32) { *w = binary.BigEndian.AppendUint32(*w, v) }
+
+func (w *writer) string(b []byte) {
+	w.uint32(uint32(len(b)))
+	*w = append(*w, b...)
+}
+
+// mpint appends an unsigned big-endian integer as a two's complement mpint
+func (w *writer) mpint(b []byte) {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	w.string(b)
+}
+
+// reader consumes SSH wire types, keeping the first error
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) need(n int) bool {
+	if r.err == nil && len(r.b) < n {
+		r.err = errors.New("truncated message")
+	}
+	return r.err == nil
+}
+
+func (r *reader) bool() bool {
+	if !r.need(1) {
+		return false
+	}
+	v := r.b[0] != 0
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) uint32() uint32 {
+	if !r.need(4) {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *reader) string() []byte {
+	n := r.uint32()
+	if !r.need(int(n)) {
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
@@ -0,0 +1,243 @@
+package telemetry
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Limits of the string value cache of an Encoder. Longer values and values
+// beyond the limit are encoded every time; values that change every
+// collection would otherwise fill the cache.
+const (
+	maxCachedString  = 64
+	maxCachedStrings = 1 << 16
+)
+
+// Encoder marshals messages reusing the encoding of what stays the same
+// between collections. Field names and short string values, such as row
+// keys, states and descriptions, are encoded once and copied afterwards, and
+// the size of every embedded field is computed once per message rather than
+// again at every level it is nested in. An Encoder is not safe for
+// concurrent use; Marshal shares a pool of them.
+type Encoder struct {
+	names   map[string][]byte // tag 2, length and name
+	strings map[string][]byte // tag 5, length and value
+	fields  []encodedField    // of the message, in encoding order
+	err     error             // first problem found sizing the message
+}
+
+// encodedField is what sizing a field found out for encoding it: its size
+// and the cached encoding of its name and string value, if any
+type encodedField struct {
+	size        int
+	name, value []byte
+}
+
+// NewEncoder creates an encoder with empty caches
+func NewEncoder() *Encoder {
+	return &Encoder{names: make(map[string][]byte), strings: make(map[string][]byte)}
+}
+
+// encoders are the encoders of Marshal
+var encoders = sync.Pool{New: func() any { return NewEncoder() }}
+
+// Marshal encodes the message into a new buffer of exactly its size. It
+// fails on messages with field names or string values that are not valid
+// UTF-8, which collectors decoding the string fields reject.
+func (e *Encoder) Marshal(t *Telemetry) ([]byte, error) {
+	e.fields, e.err = e.fields[:0], nil
+	n := t.headerSize()
+	for _, field := range t.DataGpbkv {
+		n += sizeMessage(11, e.size(field))
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	if t.CollectionEndTime != 0 {
+		n += protowire.SizeTag(13) + protowire.SizeVarint(t.CollectionEndTime)
+	}
+
+	buf := t.appendHeader(make([]byte, 0, n))
+	next := 0
+	for _, field := range t.DataGpbkv {
+		buf = protowire.AppendTag(buf, 11, protowire.BytesType)
+		buf, next = e.appendField(buf, field, next)
+	}
+	return t.appendTrailer(buf), nil
+}
+
+// fail records the first problem found sizing a message
+func (e *Encoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// name returns the encoded name field of a field name
+func (e *Encoder) name(name string) []byte {
+	b, ok := e.names[name]
+	if !ok {
+		b = protowire.AppendTag(nil, 2, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+		if !utf8.ValidString(name) {
+			e.fail(fmt.Errorf("field name %q is not valid UTF-8", name))
+			return b
+		}
+		e.names[name] = b
+	}
+	return b
+}
+
+// stringValue returns the encoded string_value field of a short value, or
+// nil for a value not worth caching. Values are checked for valid UTF-8
+// before they are cached, and every time when they are not.
+func (e *Encoder) stringValue(name, v string) []byte {
+	if len(v) > maxCachedString {
+		if !utf8.ValidString(v) {
+			e.fail(fmt.Errorf("field %q: string value is not valid UTF-8", name))
+		}
+		return nil
+	}
+	b, ok := e.strings[v]
+	if !ok {
+		if !utf8.ValidString(v) {
+			e.fail(fmt.Errorf("field %q: string value %q is not valid UTF-8", name, v))
+			return nil
+		}
+		if len(e.strings) >= maxCachedStrings {
+			clear(e.strings)
+		}
+		b = protowire.AppendTag(nil, 5, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+		e.strings[v] = b
+	}
+	return b
+}
+
+// size returns the encoded size of a field, recording it and the sizes of
+// its children in encoding order
+func (e *Encoder) size(f *TelemetryField) int {
+	slot := len(e.fields)
+	e.fields = append(e.fields, encodedField{})
+
+	var ef encodedField
+	n := 0
+	if f.Timestamp != 0 {
+		n += protowire.SizeTag(1) + protowire.SizeVarint(f.Timestamp)
+	}
+	if f.Name != "" {
+		ef.name = e.name(f.Name)
+		n += len(ef.name)
+	}
+	if f.BytesValue != nil {
+		n += protowire.SizeTag(4) + protowire.SizeBytes(len(f.BytesValue))
+	}
+	if f.StringValue != nil {
+		if ef.value = e.stringValue(f.Name, *f.StringValue); ef.value != nil {
+			n += len(ef.value)
+		} else {
+			n += protowire.SizeTag(5) + protowire.SizeBytes(len(*f.StringValue))
+		}
+	}
+	n += f.scalarSize()
+	for _, child := range f.Fields {
+		n += sizeMessage(15, e.size(child))
+	}
+	ef.size = n
+	e.fields[slot] = ef
+	return n
+}
+
+// appendField appends the length and encoding of the field recorded at
+// fields[next], returning the index of the next recorded field
+func (e *Encoder) appendField(buf []byte, f *TelemetryField, next int) ([]byte, int) {
+	ef := e.fields[next]
+	buf = protowire.AppendVarint(buf, uint64(ef.size))
+	next++
+
+	if f.Timestamp != 0 {
+		buf = protowire.AppendTag(buf, 1, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, f.Timestamp)
+	}
+	buf = append(buf, ef.name...)
+	if f.BytesValue != nil {
+		buf = protowire.AppendTag(buf, 4, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, f.BytesValue)
+	}
+	if ef.value != nil {
+		buf = append(buf, ef.value...)
+	} else if f.StringValue != nil {
+		buf = protowire.AppendTag(buf, 5, protowire.BytesType)
+		buf = protowire.AppendString(buf, *f.StringValue)
+	}
+	buf = f.appendScalars(buf)
+	for _, child := range f.Fields {
+		buf = protowire.AppendTag(buf, 15, protowire.BytesType)
+		buf, next = e.appendField(buf, child, next)
+	}
+	return buf, next
+}
+
+// scalarSize returns the encoded size of the bool and numeric values
+func (f *TelemetryField) scalarSize() int {
+	n := 0
+	if f.BoolValue != nil {
+		n += protowire.SizeTag(6) + 1
+	}
+	if f.Uint32Value != nil {
+		n += protowire.SizeTag(7) + protowire.SizeVarint(uint64(*f.Uint32Value))
+	}
+	if f.Uint64Value != nil {
+		n += protowire.SizeTag(8) + protowire.SizeVarint(*f.Uint64Value)
+	}
+	if f.Sint32Value != nil {
+		n += protowire.SizeTag(9) + protowire.SizeVarint(protowire.EncodeZigZag(int64(*f.Sint32Value)))
+	}
+	if f.Sint64Value != nil {
+		n += protowire.SizeTag(10) + protowire.SizeVarint(protowire.EncodeZigZag(*f.Sint64Value))
+	}
+	if f.DoubleValue != nil {
+		n += protowire.SizeTag(11) + protowire.SizeFixed64()
+	}
+	if f.FloatValue != nil {
+		n += protowire.SizeTag(12) + protowire.SizeFixed32()
+	}
+	return n
+}
+
+// appendScalars appends the bool and numeric values, fields 6 to 12
+func (f *TelemetryField) appendScalars(buf []byte) []byte {
+	if f.BoolValue != nil {
+		buf = protowire.AppendTag(buf, 6, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, protowire.EncodeBool(*f.BoolValue))
+	}
+	if f.Uint32Value != nil {
+		buf = protowire.AppendTag(buf, 7, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, uint64(*f.Uint32Value))
+	}
+	if f.Uint64Value != nil {
+		buf = protowire.AppendTag(buf, 8, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, *f.Uint64Value)
+	}
+	if f.Sint32Value != nil {
+		buf = protowire.AppendTag(buf, 9, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, protowire.EncodeZigZag(int64(*f.Sint32Value)))
+	}
+	if f.Sint64Value != nil {
+		buf = protowire.AppendTag(buf, 10, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, protowire.EncodeZigZag(*f.Sint64Value))
+	}
+	if f.DoubleValue != nil {
+		buf = protowire.AppendTag(buf, 11, protowire.Fixed64Type)
+		buf = protowire.AppendFixed64(buf, math.Float64bits(*f.DoubleValue))
+	}
+	if f.FloatValue != nil {
+		buf = protowire.AppendTag(buf, 12, protowire.Fixed32Type)
+		buf = protowire.AppendFixed32(buf, math.Float32bits(*f.FloatValue))
+	}
+	return buf
+}
@@ -0,0 +1,161 @@
+package telemetry
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// CompactSchema encodes messages in compact GPB: every row of a message
+// becomes a TelemetryRowGPB of data_gpb, whose keys and content are
+// protobuf messages with a field number per field name instead of the name
+// itself. Numbers are assigned per encoding path and container in the order
+// names are first seen and never change afterwards, so every node sharing
+// the schema uses the same numbers. Fields repeated in a container are
+// repeated fields; containers are embedded messages. A CompactSchema is safe
+// for concurrent use.
+type CompactSchema struct {
+	mu      sync.Mutex
+	numbers map[string]map[string]protowire.Number // by container path, then field name
+}
+
+// NewCompactSchema creates a schema without field numbers
+func NewCompactSchema() *CompactSchema {
+	return &CompactSchema{numbers: make(map[string]map[string]protowire.Number)}
+}
+
+// Marshal encodes the message in compact GPB. Rows are expected to have
+// keys and content sections; other sections are not encoded. It fails on
+// string values that are not valid UTF-8.
+func (s *CompactSchema) Marshal(t *Telemetry) ([]byte, error) {
+	var table []byte
+	for _, row := range t.DataGpbkv {
+		r, err := s.appendRow(nil, t.EncodingPath, row)
+		if err != nil {
+			return nil, err
+		}
+		// TelemetryGPBTable field 1: row
+		table = protowire.AppendTag(table, 1, protowire.BytesType)
+		table = protowire.AppendBytes(table, r)
+	}
+
+	n := t.headerSize() + sizeMessage(12, len(table))
+	if t.CollectionEndTime != 0 {
+		n += protowire.SizeTag(13) + protowire.SizeVarint(t.CollectionEndTime)
+	}
+	buf := t.appendHeader(make([]byte, 0, n))
+	// Field 12: data_gpb (TelemetryGPBTable)
+	buf = protowire.AppendTag(buf, 12, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, table)
+	return t.appendTrailer(buf), nil
+}
+
+// appendRow appends a TelemetryRowGPB: timestamp (1), keys (10) and
+// content (11)
+func (s *CompactSchema) appendRow(buf []byte, path string, row *TelemetryField) ([]byte, error) {
+	if row.Timestamp != 0 {
+		buf = protowire.AppendTag(buf, 1, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, row.Timestamp)
+	}
+	for _, section := range row.Fields {
+		var num protowire.Number
+		switch section.Name {
+		case "keys":
+			num = 10
+		case "content":
+			num = 11
+		default:
+			continue
+		}
+		b, err := s.appendFields(nil, path+"/"+section.Name, section.Fields)
+		if err != nil {
+			return nil, err
+		}
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, b)
+	}
+	return buf, nil
+}
+
+// appendFields appends the fields of a container as the fields of its
+// message
+func (s *CompactSchema) appendFields(buf []byte, container string, fields []*TelemetryField) ([]byte, error) {
+	for _, f := range fields {
+		num := s.number(container, f.Name)
+		var err error
+		if buf, err = s.appendValue(buf, num, container+"/"+f.Name, f); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendValue appends the value of a field with its number
+func (s *CompactSchema) appendValue(buf []byte, num protowire.Number, path string, f *TelemetryField) ([]byte, error) {
+	switch {
+	case len(f.Fields) > 0:
+		b, err := s.appendFields(nil, path, f.Fields)
+		if err != nil {
+			return nil, err
+		}
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+		return protowire.AppendBytes(buf, b), nil
+	case f.StringValue != nil:
+		if !utf8.ValidString(*f.StringValue) {
+			return nil, fmt.Errorf("field %q: string value %q is not valid UTF-8", f.Name, *f.StringValue)
+		}
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+		return protowire.AppendString(buf, *f.StringValue), nil
+	case f.BytesValue != nil:
+		buf = protowire.AppendTag(buf, num, protowire.BytesType)
+		return protowire.AppendBytes(buf, f.BytesValue), nil
+	case f.BoolValue != nil:
+		buf = protowire.AppendTag(buf, num, protowire.VarintType)
+		return protowire.AppendVarint(buf, protowire.EncodeBool(*f.BoolValue)), nil
+	case f.Uint32Value != nil:
+		buf = protowire.AppendTag(buf, num, protowire.VarintType)
+		return protowire.AppendVarint(buf, uint64(*f.Uint32Value)), nil
+	case f.Uint64Value != nil:
+		buf = protowire.AppendTag(buf, num, protowire.VarintType)
+		return protowire.AppendVarint(buf, *f.Uint64Value), nil
+	case f.Sint32Value != nil:
+		buf = protowire.AppendTag(buf, num, protowire.VarintType)
+		return protowire.AppendVarint(buf, protowire.EncodeZigZag(int64(*f.Sint32Value))), nil
+	case f.Sint64Value != nil:
+		buf = protowire.AppendTag(buf, num, protowire.VarintType)
+		return protowire.AppendVarint(buf, protowire.EncodeZigZag(*f.Sint64Value)), nil
+	case f.DoubleValue != nil:
+		buf = protowire.AppendTag(buf, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(buf, math.Float64bits(*f.DoubleValue)), nil
+	case f.FloatValue != nil:
+		buf = protowire.AppendTag(buf, num, protowire.Fixed32Type)
+		return protowire.AppendFixed32(buf, math.Float32bits(*f.FloatValue)), nil
+	}
+	// An empty container is an empty embedded message
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, nil), nil
+}
+
+// number returns the field number of a name in a container, assigning the
+// next one when the name is new
+func (s *CompactSchema) number(container, name string) protowire.Number {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names, ok := s.numbers[container]
+	if !ok {
+		names = make(map[string]protowire.Number)
+		s.numbers[container] = names
+	}
+	num, ok := names[name]
+	if !ok {
+		num = protowire.Number(len(names) + 1)
+		if num >= protowire.FirstReservedNumber {
+			num += protowire.LastReservedNumber - protowire.FirstReservedNumber + 1
+		}
+		names[name] = num
+	}
+	return num
+}
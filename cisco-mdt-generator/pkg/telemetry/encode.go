@@ -0,0 +1,209 @@
+package telemetry
+
+import (
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Size returns the length of the encoded message
+func (t *Telemetry) Size() int {
+	n := t.headerSize()
+	for _, field := range t.DataGpbkv {
+		n += sizeMessage(11, field.Size())
+	}
+	if t.CollectionEndTime != 0 {
+		n += protowire.SizeTag(13) + protowire.SizeVarint(t.CollectionEndTime)
+	}
+	return n
+}
+
+// headerSize returns the length of the fields encoded before the rows
+func (t *Telemetry) headerSize() int {
+	n := 0
+	if t.NodeIDStr != "" {
+		n += protowire.SizeTag(1) + protowire.SizeBytes(len(t.NodeIDStr))
+	}
+	if t.SubscriptionIDStr != "" {
+		n += protowire.SizeTag(3) + protowire.SizeBytes(len(t.SubscriptionIDStr))
+	}
+	if t.EncodingPath != "" {
+		n += protowire.SizeTag(6) + protowire.SizeBytes(len(t.EncodingPath))
+	}
+	if t.CollectionID != 0 {
+		n += protowire.SizeTag(8) + protowire.SizeVarint(t.CollectionID)
+	}
+	if t.CollectionStartTime != 0 {
+		n += protowire.SizeTag(9) + protowire.SizeVarint(t.CollectionStartTime)
+	}
+	if t.MsgTimestamp != 0 {
+		n += protowire.SizeTag(10) + protowire.SizeVarint(t.MsgTimestamp)
+	}
+	return n
+}
+
+// Size returns the length of the encoded field and its children
+func (f *TelemetryField) Size() int {
+	n := 0
+	if f.Timestamp != 0 {
+		n += protowire.SizeTag(1) + protowire.SizeVarint(f.Timestamp)
+	}
+	if f.Name != "" {
+		n += protowire.SizeTag(2) + protowire.SizeBytes(len(f.Name))
+	}
+	if f.BytesValue != nil {
+		n += protowire.SizeTag(4) + protowire.SizeBytes(len(f.BytesValue))
+	}
+	if f.StringValue != nil {
+		n += protowire.SizeTag(5) + protowire.SizeBytes(len(*f.StringValue))
+	}
+	n += f.scalarSize()
+	for _, child := range f.Fields {
+		n += sizeMessage(15, child.Size())
+	}
+	return n
+}
+
+// sizeMessage returns the length of an embedded message of size n
+func sizeMessage(num protowire.Number, n int) int {
+	return protowire.SizeTag(num) + protowire.SizeBytes(n)
+}
+
+// AppendTo appends the encoded message to buf. Embedded fields are encoded
+// in place, without intermediate buffers.
+func (t *Telemetry) AppendTo(buf []byte) []byte {
+	buf = t.appendHeader(buf)
+	for _, field := range t.DataGpbkv {
+		buf = appendRow(buf, field)
+	}
+	return t.appendTrailer(buf)
+}
+
+// appendHeader appends the fields encoded before the rows
+func (t *Telemetry) appendHeader(buf []byte) []byte {
+	// Field 1: node_id_str (string)
+	if t.NodeIDStr != "" {
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendString(buf, t.NodeIDStr)
+	}
+
+	// Field 3: subscription_id_str (string)
+	if t.SubscriptionIDStr != "" {
+		buf = protowire.AppendTag(buf, 3, protowire.BytesType)
+		buf = protowire.AppendString(buf, t.SubscriptionIDStr)
+	}
+
+	// Field 6: encoding_path (string)
+	if t.EncodingPath != "" {
+		buf = protowire.AppendTag(buf, 6, protowire.BytesType)
+		buf = protowire.AppendString(buf, t.EncodingPath)
+	}
+
+	// Field 8: collection_id (uint64)
+	if t.CollectionID != 0 {
+		buf = protowire.AppendTag(buf, 8, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, t.CollectionID)
+	}
+
+	// Field 9: collection_start_time (uint64)
+	if t.CollectionStartTime != 0 {
+		buf = protowire.AppendTag(buf, 9, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, t.CollectionStartTime)
+	}
+
+	// Field 10: msg_timestamp (uint64)
+	if t.MsgTimestamp != 0 {
+		buf = protowire.AppendTag(buf, 10, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, t.MsgTimestamp)
+	}
+	return buf
+}
+
+// appendRow appends one entry of data_gpbkv (field 11)
+func appendRow(buf []byte, field *TelemetryField) []byte {
+	buf = protowire.AppendTag(buf, 11, protowire.BytesType)
+	buf = protowire.AppendVarint(buf, uint64(field.Size()))
+	return field.AppendTo(buf)
+}
+
+// appendTrailer appends the fields encoded after the rows
+func (t *Telemetry) appendTrailer(buf []byte) []byte {
+	// Field 13: collection_end_time (uint64)
+	if t.CollectionEndTime != 0 {
+		buf = protowire.AppendTag(buf, 13, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, t.CollectionEndTime)
+	}
+	return buf
+}
+
+// AppendTo appends the encoded field and its children to buf
+func (f *TelemetryField) AppendTo(buf []byte) []byte {
+	// Field 1: timestamp (uint64)
+	if f.Timestamp != 0 {
+		buf = protowire.AppendTag(buf, 1, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, f.Timestamp)
+	}
+
+	// Field 2: name (string)
+	if f.Name != "" {
+		buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+		buf = protowire.AppendString(buf, f.Name)
+	}
+
+	// Value fields (oneof - only one should be set)
+	// Field 4: bytes_value
+	if f.BytesValue != nil {
+		buf = protowire.AppendTag(buf, 4, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, f.BytesValue)
+	}
+
+	// Field 5: string_value
+	if f.StringValue != nil {
+		buf = protowire.AppendTag(buf, 5, protowire.BytesType)
+		buf = protowire.AppendString(buf, *f.StringValue)
+	}
+
+	// Fields 6 to 12: bool and numeric values
+	buf = f.appendScalars(buf)
+
+	// Field 15: fields (repeated TelemetryField)
+	for _, child := range f.Fields {
+		buf = protowire.AppendTag(buf, 15, protowire.BytesType)
+		buf = protowire.AppendVarint(buf, uint64(child.Size()))
+		buf = child.AppendTo(buf)
+	}
+	return buf
+}
+
+// WriteTo encodes the message to w one row at a time, so only the largest
+// row is held in memory besides the message tree. It implements io.WriterTo.
+func (t *Telemetry) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	write := func(b []byte) error {
+		n, err := w.Write(b)
+		written += int64(n)
+		return err
+	}
+
+	buf := t.appendHeader(nil)
+	for _, field := range t.DataGpbkv {
+		if err := write(buf); err != nil {
+			return written, err
+		}
+		buf = appendRow(buf[:0], field)
+	}
+	buf = t.appendTrailer(buf)
+	return written, write(buf)
+}
+
+// WriteDelimited writes the message to w prefixed with its length as a
+// varint, the framing of length-delimited protobuf streams
+func (t *Telemetry) WriteDelimited(w io.Writer) (int64, error) {
+	prefix := protowire.AppendVarint(nil, uint64(t.Size()))
+	n, err := w.Write(prefix)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := t.WriteTo(w)
+	return int64(n) + m, err
+}
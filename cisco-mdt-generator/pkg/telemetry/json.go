@@ -0,0 +1,174 @@
+package telemetry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// EncodeJSON encodes the message as self-describing JSON, the header
+// fields under their telemetry.proto names and every row as an object of
+// data_json with its timestamp, keys and content:
+//
+//	{"node_id_str":"leaf-101","subscription_id_str":"1","encoding_path":"sys/bgp",
+//	 "collection_id":7,"collection_start_time":...,"msg_timestamp":...,
+//	 "data_json":[{"timestamp":...,"keys":{...},"content":{...}}],
+//	 "collection_end_time":...}
+//
+// Containers are objects and fields repeated in a container are arrays,
+// in the order they were added. It fails on string values that are not
+// valid UTF-8 and on numbers JSON cannot represent.
+func (t *Telemetry) EncodeJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	buf = appendJSONKey(buf, "node_id_str", true)
+	buf = appendJSONString(buf, t.NodeIDStr)
+	buf = appendJSONKey(buf, "subscription_id_str", false)
+	buf = appendJSONString(buf, t.SubscriptionIDStr)
+	buf = appendJSONKey(buf, "encoding_path", false)
+	buf = appendJSONString(buf, t.EncodingPath)
+	buf = appendJSONKey(buf, "collection_id", false)
+	buf = strconv.AppendUint(buf, t.CollectionID, 10)
+	buf = appendJSONKey(buf, "collection_start_time", false)
+	buf = strconv.AppendUint(buf, t.CollectionStartTime, 10)
+	buf = appendJSONKey(buf, "msg_timestamp", false)
+	buf = strconv.AppendUint(buf, t.MsgTimestamp, 10)
+	buf = appendJSONKey(buf, "data_json", false)
+	buf = append(buf, '[')
+	for i, row := range t.DataGpbkv {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '{')
+		buf = appendJSONKey(buf, "timestamp", true)
+		buf = strconv.AppendUint(buf, row.Timestamp, 10)
+		var err error
+		if buf, err = appendJSONFields(buf, row.Fields, false); err != nil {
+			return nil, err
+		}
+		buf = append(buf, '}')
+	}
+	buf = append(buf, ']')
+	buf = appendJSONKey(buf, "collection_end_time", false)
+	buf = strconv.AppendUint(buf, t.CollectionEndTime, 10)
+	return append(buf, '}'), nil
+}
+
+// appendJSONFields appends the fields of a container as members of an
+// object, the values of a repeated name as one array member
+func appendJSONFields(buf []byte, fields []*TelemetryField, first bool) ([]byte, error) {
+	var names []string
+	byName := make(map[string][]*TelemetryField, len(fields))
+	for _, f := range fields {
+		if _, ok := byName[f.Name]; !ok {
+			names = append(names, f.Name)
+		}
+		byName[f.Name] = append(byName[f.Name], f)
+	}
+
+	for _, name := range names {
+		if !utf8.ValidString(name) {
+			return nil, fmt.Errorf("field name %q is not valid UTF-8", name)
+		}
+		buf = appendJSONKey(buf, name, first)
+		first = false
+
+		var err error
+		values := byName[name]
+		if len(values) == 1 {
+			if buf, err = appendJSONValue(buf, values[0]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		buf = append(buf, '[')
+		for i, f := range values {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if buf, err = appendJSONValue(buf, f); err != nil {
+				return nil, err
+			}
+		}
+		buf = append(buf, ']')
+	}
+	return buf, nil
+}
+
+// appendJSONValue appends the value of a field: an object for a container,
+// null for a field without a value
+func appendJSONValue(buf []byte, f *TelemetryField) ([]byte, error) {
+	switch {
+	case len(f.Fields) > 0:
+		buf = append(buf, '{')
+		buf, err := appendJSONFields(buf, f.Fields, true)
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, '}'), nil
+	case f.StringValue != nil:
+		if !utf8.ValidString(*f.StringValue) {
+			return nil, fmt.Errorf("field %q: string value %q is not valid UTF-8", f.Name, *f.StringValue)
+		}
+		return appendJSONString(buf, *f.StringValue), nil
+	case f.BytesValue != nil:
+		return appendJSONString(buf, base64.StdEncoding.EncodeToString(f.BytesValue)), nil
+	case f.BoolValue != nil:
+		return strconv.AppendBool(buf, *f.BoolValue), nil
+	case f.Uint32Value != nil:
+		return strconv.AppendUint(buf, uint64(*f.Uint32Value), 10), nil
+	case f.Uint64Value != nil:
+		return strconv.AppendUint(buf, *f.Uint64Value, 10), nil
+	case f.Sint32Value != nil:
+		return strconv.AppendInt(buf, int64(*f.Sint32Value), 10), nil
+	case f.Sint64Value != nil:
+		return strconv.AppendInt(buf, *f.Sint64Value, 10), nil
+	case f.DoubleValue != nil:
+		return appendJSONFloat(buf, f.Name, *f.DoubleValue, 64)
+	case f.FloatValue != nil:
+		return appendJSONFloat(buf, f.Name, float64(*f.FloatValue), 32)
+	}
+	return append(buf, "null"...), nil
+}
+
+// appendJSONFloat appends a number, failing on NaN and infinities
+func appendJSONFloat(buf []byte, name string, v float64, bits int) ([]byte, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("field %q: %v is not a JSON number", name, v)
+	}
+	return strconv.AppendFloat(buf, v, 'g', -1, bits), nil
+}
+
+// appendJSONKey appends a member name, preceded by a comma unless it is the
+// first member
+func appendJSONKey(buf []byte, key string, first bool) []byte {
+	if !first {
+		buf = append(buf, ',')
+	}
+	buf = appendJSONString(buf, key)
+	return append(buf, ':')
+}
+
+// appendJSONString appends a quoted JSON string of valid UTF-8
+func appendJSONString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, '\\', 'n')
+		case c == '\r':
+			buf = append(buf, '\\', 'r')
+		case c == '\t':
+			buf = append(buf, '\\', 't')
+		case c < 0x20:
+			buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '"')
+}
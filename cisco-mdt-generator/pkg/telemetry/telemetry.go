@@ -3,6 +3,7 @@
 package telemetry
 
 import (
+	"fmt"
 	"math"
 
 	"google.golang.org/protobuf/encoding/protowire"
@@ -36,151 +37,188 @@ type TelemetryField struct {
 	Sint64Value *int64
 }
 
-// Marshal encodes the Telemetry message to protobuf wire format
+// Marshal encodes the Telemetry message to protobuf wire format, with one of
+// a pool of encoders caching the static parts of messages
 func (t *Telemetry) Marshal() ([]byte, error) {
-	var buf []byte
-
-	// Field 1: node_id_str (string)
-	if t.NodeIDStr != "" {
-		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
-		buf = protowire.AppendString(buf, t.NodeIDStr)
-	}
-
-	// Field 3: subscription_id_str (string)
-	if t.SubscriptionIDStr != "" {
-		buf = protowire.AppendTag(buf, 3, protowire.BytesType)
-		buf = protowire.AppendString(buf, t.SubscriptionIDStr)
-	}
-
-	// Field 6: encoding_path (string)
-	if t.EncodingPath != "" {
-		buf = protowire.AppendTag(buf, 6, protowire.BytesType)
-		buf = protowire.AppendString(buf, t.EncodingPath)
-	}
-
-	// Field 8: collection_id (uint64)
-	if t.CollectionID != 0 {
-		buf = protowire.AppendTag(buf, 8, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, t.CollectionID)
-	}
-
-	// Field 9: collection_start_time (uint64)
-	if t.CollectionStartTime != 0 {
-		buf = protowire.AppendTag(buf, 9, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, t.CollectionStartTime)
-	}
-
-	// Field 10: msg_timestamp (uint64)
-	if t.MsgTimestamp != 0 {
-		buf = protowire.AppendTag(buf, 10, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, t.MsgTimestamp)
-	}
-
-	// Field 11: data_gpbkv (repeated TelemetryField)
-	for _, field := range t.DataGpbkv {
-		fieldBytes, err := field.Marshal()
-		if err != nil {
-			return nil, err
-		}
-		buf = protowire.AppendTag(buf, 11, protowire.BytesType)
-		buf = protowire.AppendBytes(buf, fieldBytes)
-	}
-
-	// Field 13: collection_end_time (uint64)
-	if t.CollectionEndTime != 0 {
-		buf = protowire.AppendTag(buf, 13, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, t.CollectionEndTime)
-	}
-
-	return buf, nil
+	e := encoders.Get().(*Encoder)
+	defer encoders.Put(e)
+	return e.Marshal(t)
 }
 
 // Marshal encodes a TelemetryField to protobuf wire format
 func (f *TelemetryField) Marshal() ([]byte, error) {
-	var buf []byte
-
-	// Field 1: timestamp (uint64)
-	if f.Timestamp != 0 {
-		buf = protowire.AppendTag(buf, 1, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, f.Timestamp)
-	}
-
-	// Field 2: name (string)
-	if f.Name != "" {
-		buf = protowire.AppendTag(buf, 2, protowire.BytesType)
-		buf = protowire.AppendString(buf, f.Name)
-	}
-
-	// Value fields (oneof - only one should be set)
-	// Field 4: bytes_value
-	if f.BytesValue != nil {
-		buf = protowire.AppendTag(buf, 4, protowire.BytesType)
-		buf = protowire.AppendBytes(buf, f.BytesValue)
-	}
+	return f.AppendTo(make([]byte, 0, f.Size())), nil
+}
 
-	// Field 5: string_value
-	if f.StringValue != nil {
-		buf = protowire.AppendTag(buf, 5, protowire.BytesType)
-		buf = protowire.AppendString(buf, *f.StringValue)
+// Unmarshal decodes a Telemetry message from protobuf wire format. Fields
+// this package does not model, such as data_gpb, are skipped.
+func Unmarshal(b []byte) (*Telemetry, error) {
+	t := &Telemetry{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return consumeString(b, &t.NodeIDStr)
+		case num == 3 && typ == protowire.BytesType:
+			return consumeString(b, &t.SubscriptionIDStr)
+		case num == 6 && typ == protowire.BytesType:
+			return consumeString(b, &t.EncodingPath)
+		case num == 8 && typ == protowire.VarintType:
+			return consumeVarint(b, &t.CollectionID)
+		case num == 9 && typ == protowire.VarintType:
+			return consumeVarint(b, &t.CollectionStartTime)
+		case num == 10 && typ == protowire.VarintType:
+			return consumeVarint(b, &t.MsgTimestamp)
+		case num == 13 && typ == protowire.VarintType:
+			return consumeVarint(b, &t.CollectionEndTime)
+		case num == 11 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			f, err := unmarshalField(v)
+			if err != nil {
+				return 0, fmt.Errorf("data_gpbkv[%d]: %w", len(t.DataGpbkv), err)
+			}
+			t.DataGpbkv = append(t.DataGpbkv, f)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return t, nil
+}
 
-	// Field 6: bool_value
-	if f.BoolValue != nil {
-		buf = protowire.AppendTag(buf, 6, protowire.VarintType)
-		val := uint64(0)
-		if *f.BoolValue {
-			val = 1
+// unmarshalField decodes a TelemetryField and its children
+func unmarshalField(b []byte) (*TelemetryField, error) {
+	f := &TelemetryField{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			return consumeVarint(b, &f.Timestamp)
+		case num == 2 && typ == protowire.BytesType:
+			return consumeString(b, &f.Name)
+		case num == 4 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			f.BytesValue = append([]byte{}, v...)
+			return n, nil
+		case num == 5 && typ == protowire.BytesType:
+			f.StringValue = new(string)
+			return consumeString(b, f.StringValue)
+		case num == 6 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f.BoolValue = new(bool)
+			*f.BoolValue = v != 0
+			return n, nil
+		case num == 7 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f.Uint32Value = new(uint32)
+			*f.Uint32Value = uint32(v)
+			return n, nil
+		case num == 8 && typ == protowire.VarintType:
+			f.Uint64Value = new(uint64)
+			return consumeVarint(b, f.Uint64Value)
+		case num == 9 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f.Sint32Value = new(int32)
+			*f.Sint32Value = int32(protowire.DecodeZigZag(v))
+			return n, nil
+		case num == 10 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f.Sint64Value = new(int64)
+			*f.Sint64Value = protowire.DecodeZigZag(v)
+			return n, nil
+		case num == 11 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			f.DoubleValue = new(float64)
+			*f.DoubleValue = math.Float64frombits(v)
+			return n, nil
+		case num == 12 && typ == protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			f.FloatValue = new(float32)
+			*f.FloatValue = math.Float32frombits(v)
+			return n, nil
+		case num == 15 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			child, err := unmarshalField(v)
+			if err != nil {
+				return 0, err
+			}
+			f.Fields = append(f.Fields, child)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if err != nil {
+		if f.Name != "" {
+			err = fmt.Errorf("%s: %w", f.Name, err)
 		}
-		buf = protowire.AppendVarint(buf, val)
+		return nil, err
 	}
+	return f, nil
+}
 
-	// Field 7: uint32_value
-	if f.Uint32Value != nil {
-		buf = protowire.AppendTag(buf, 7, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, uint64(*f.Uint32Value))
+// consumeFields calls fn with every field of a message. fn consumes the
+// field value and returns its length, or a negative protowire error code.
+func consumeFields(b []byte, fn func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		m, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if m < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(m))
+		}
+		b = b[m:]
 	}
+	return nil
+}
 
-	// Field 8: uint64_value
-	if f.Uint64Value != nil {
-		buf = protowire.AppendTag(buf, 8, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, *f.Uint64Value)
-	}
+func consumeString(b []byte, s *string) (int, error) {
+	v, n := protowire.ConsumeString(b)
+	*s = v
+	return n, nil
+}
 
-	// Field 9: sint32_value
-	if f.Sint32Value != nil {
-		buf = protowire.AppendTag(buf, 9, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, protowire.EncodeZigZag(int64(*f.Sint32Value)))
-	}
+func consumeVarint(b []byte, u *uint64) (int, error) {
+	v, n := protowire.ConsumeVarint(b)
+	*u = v
+	return n, nil
+}
 
-	// Field 10: sint64_value
-	if f.Sint64Value != nil {
-		buf = protowire.AppendTag(buf, 10, protowire.VarintType)
-		buf = protowire.AppendVarint(buf, protowire.EncodeZigZag(*f.Sint64Value))
-	}
+// Clone returns a deep copy of the message tree. Leaf values are shared,
+// since fields are only ever changed by replacing them.
+func (t *Telemetry) Clone() *Telemetry {
+	c := *t
+	c.DataGpbkv = cloneFields(t.DataGpbkv)
+	return &c
+}
 
-	// Field 11: double_value (fixed64)
-	if f.DoubleValue != nil {
-		buf = protowire.AppendTag(buf, 11, protowire.Fixed64Type)
-		buf = protowire.AppendFixed64(buf, math.Float64bits(*f.DoubleValue))
-	}
+// Clone returns a deep copy of the field tree
+func (f *TelemetryField) Clone() *TelemetryField {
+	c := *f
+	c.Fields = cloneFields(f.Fields)
+	return &c
+}
 
-	// Field 12: float_value (fixed32)
-	if f.FloatValue != nil {
-		buf = protowire.AppendTag(buf, 12, protowire.Fixed32Type)
-		buf = protowire.AppendFixed32(buf, math.Float32bits(*f.FloatValue))
+func cloneFields(fields []*TelemetryField) []*TelemetryField {
+	if fields == nil {
+		return nil
 	}
-
-	// Field 15: fields (repeated TelemetryField)
-	for _, child := range f.Fields {
-		childBytes, err := child.Marshal()
-		if err != nil {
-			return nil, err
-		}
-		buf = protowire.AppendTag(buf, 15, protowire.BytesType)
-		buf = protowire.AppendBytes(buf, childBytes)
+	c := make([]*TelemetryField, len(fields))
+	for i, f := range fields {
+		c[i] = f.Clone()
 	}
-
-	return buf, nil
+	return c
 }
 
 // Helper functions for creating TelemetryField values
@@ -209,6 +247,22 @@ func Uint64Field(name string, value uint64, ts uint64) *TelemetryField {
 	}
 }
 
+func DoubleField(name string, value float64, ts uint64) *TelemetryField {
+	return &TelemetryField{
+		Name:        name,
+		Timestamp:   ts,
+		DoubleValue: &value,
+	}
+}
+
+func BoolField(name string, value bool, ts uint64) *TelemetryField {
+	return &TelemetryField{
+		Name:      name,
+		Timestamp: ts,
+		BoolValue: &value,
+	}
+}
+
 func ContainerField(name string, children []*TelemetryField, ts uint64) *TelemetryField {
 	return &TelemetryField{
 		Name:      name,
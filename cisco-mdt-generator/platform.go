@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Device personalities, selecting the data model a node streams
+const (
+	platformNXOS  = "nxos"
+	platformIOSXR = "iosxr"
+)
+
+// platforms lists every device personality
+var platforms = []string{platformNXOS, platformIOSXR}
+
+// xrBaseVersion is the IOS-XR release an iosxr node reports before any
+// upgrade
+const xrBaseVersion = "7.10.2"
+
+// checkPlatform ensures a device personality is known
+func checkPlatform(platform string) error {
+	if !slices.Contains(platforms, platform) {
+		return fmt.Errorf("unknown platform %q, expected %s", platform, strings.Join(platforms, " or "))
+	}
+	return nil
+}
+
+// xrPath is how the rows of an NX-OS subscription look on IOS-XR. Fields
+// are renamed, then split into the XR keys and content.
+type xrPath struct {
+	path   string
+	keys   []string                       // XR key fields in order, the rest is content
+	rename map[string]string              // NX-OS field name to XR name
+	remove []string                       // NX-OS fields the XR model lacks
+	add    map[string]string              // string fields by XR name, in keys or content
+	values map[string]func(string) string // of string fields by XR name
+	adjust func(content []*telemetry.TelemetryField, ts uint64) []*telemetry.TelemetryField
+}
+
+// xrBGPNeighbor is the shared shape of the XR BGP neighbor paths
+var xrBGPNeighbor = xrPath{
+	rename: map[string]string{
+		"state":             "connection-state",
+		"prefixes-received": "prefixes-accepted",
+		"prefixes-sent":     "prefixes-advertised",
+		"uptime-seconds":    "connection-established-time",
+		"flap-count":        "connection-down-count",
+	},
+	remove: []string{"state-code", "table-mode"},
+	add:    map[string]string{"instance-name": "default"},
+	values: map[string]func(string) string{"connection-state": xrBGPState},
+}
+
+// xrGenericCounters is the shared shape of the XR interface counter path
+var xrGenericCounters = xrPath{
+	path: "Cisco-IOS-XR-infra-statsd-oper:infra-statistics/interfaces/interface/latest/generic-counters",
+	keys: []string{"interface-name"},
+	rename: map[string]string{
+		"id":                        "interface-name",
+		"in-octets":                 "bytes-received",
+		"out-octets":                "bytes-sent",
+		"in-pkts":                   "packets-received",
+		"out-pkts":                  "packets-sent",
+		"in-ucast-pkts":             "packets-received",
+		"in-mcast-pkts":             "multicast-packets-received",
+		"in-bcast-pkts":             "broadcast-packets-received",
+		"in-unknown-ucast-pkts":     "unknown-protocol-packets-received",
+		"storm-control-total-drops": "input-drops",
+	},
+	remove: []string{
+		"storm-control-bcast-drops", "storm-control-mcast-drops", "storm-control-unknown-ucast-drops",
+		"oper-state", "oper-state-code",
+	},
+	values: map[string]func(string) string{"interface-name": xrInterfaceName},
+}
+
+// xrPaths are the IOS-XR oper model paths of the NX-OS subscriptions that
+// have one. The SRv6 paths are XR already.
+var xrPaths = map[string]xrPath{
+	"bgp_neighbors": xrBGPNeighbor.at("Cisco-IOS-XR-ipv4-bgp-oper:bgp/instances/instance/instance-active/default-vrf/neighbors/neighbor",
+		"instance-name", "neighbor-address"),
+	"external_bgp": xrBGPNeighbor.at("Cisco-IOS-XR-ipv4-bgp-oper:bgp/instances/instance/instance-active/vrfs/vrf/neighbors/neighbor",
+		"instance-name", "vrf-name", "neighbor-address"),
+	"interface_counters": xrGenericCounters,
+	"svi_counters":       xrGenericCounters,
+	"cpu_utilization": {
+		path:   "Cisco-IOS-XR-wdsysmon-fd-oper:system-monitoring/cpu-utilization",
+		keys:   []string{"node-name"},
+		rename: map[string]string{"cpu": "node-name"},
+		values: map[string]func(string) string{"node-name": func(string) string { return "0/RP0/CPU0" }},
+		adjust: xrCPUTotals,
+	},
+	"inventory": {
+		path:   "Cisco-IOS-XR-install-oper:install/version",
+		rename: map[string]string{"nxosVersion": "label"},
+		remove: []string{"hostName"},
+		values: map[string]func(string) string{"label": xrVersion},
+	},
+	"evpn_routes": {
+		path: "Cisco-IOS-XR-evpn-oper:evpn/active/summary",
+		rename: map[string]string{
+			"total-routes":  "total-count",
+			"type2-routes":  "mac-routes",
+			"type2-updates": "mac-route-updates",
+			"type3-routes":  "imet-routes",
+			"type5-routes":  "ip-prefix-routes",
+		},
+		remove: []string{"address-family"},
+	},
+	"vni_state": {
+		path:   "Cisco-IOS-XR-tunnel-nve-oper:nve/vnis/vni",
+		keys:   []string{"vni"},
+		rename: map[string]string{"vni-id": "vni"},
+		remove: []string{"state-code"},
+		values: map[string]func(string) string{"state": strings.ToLower},
+	},
+}
+
+// at returns a copy of a path shape at an encoding path with its keys
+func (p xrPath) at(path string, keys ...string) xrPath {
+	p.path, p.keys = path, keys
+	return p
+}
+
+// applyPlatform rewrites the messages of an iosxr node to the XR data model.
+// NX-OS subscriptions without an XR path are not streamed; plugins and
+// paths that are XR already are left alone.
+func applyPlatform(messages []*telemetry.Telemetry, platform string) []*telemetry.Telemetry {
+	if platform != platformIOSXR {
+		return messages
+	}
+	return slices.DeleteFunc(messages, func(m *telemetry.Telemetry) bool {
+		p, ok := xrPaths[m.SubscriptionIDStr]
+		if !ok {
+			return strings.HasPrefix(m.EncodingPath, "Cisco-NX-OS-device:")
+		}
+		m.EncodingPath = p.path
+		for _, row := range m.DataGpbkv {
+			p.row(row, m.MsgTimestamp)
+		}
+		return false
+	})
+}
+
+// row rewrites the keys and content of one row
+func (p xrPath) row(row *telemetry.TelemetryField, ts uint64) {
+	var fields []*telemetry.TelemetryField
+	for _, section := range row.Fields {
+		for _, f := range section.Fields {
+			if slices.Contains(p.remove, f.Name) {
+				continue
+			}
+			if name, ok := p.rename[f.Name]; ok {
+				f.Name = name
+			}
+			if convert, ok := p.values[f.Name]; ok && f.StringValue != nil {
+				v := convert(*f.StringValue)
+				f.StringValue = &v
+			}
+			fields = append(fields, f)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.add)) {
+		fields = append(fields, telemetry.StringField(name, p.add[name], ts))
+	}
+
+	keys := make([]*telemetry.TelemetryField, 0, len(p.keys))
+	for _, name := range p.keys {
+		if i := slices.IndexFunc(fields, func(f *telemetry.TelemetryField) bool { return f.Name == name }); i >= 0 {
+			keys = append(keys, fields[i])
+			fields = slices.Delete(fields, i, i+1)
+		}
+	}
+	if p.adjust != nil {
+		fields = p.adjust(fields, ts)
+	}
+	row.Fields = telemetry.RowField(keys, fields, row.Timestamp).Fields
+}
+
+// xrBGPState names a BGP session state like the XR BGP oper model, e.g.
+// bgp-st-estab
+func xrBGPState(state string) string {
+	if state == "Established" {
+		state = "estab"
+	}
+	return "bgp-st-" + strings.ToLower(state)
+}
+
+var (
+	nxosEthernet = regexp.MustCompile(`^(?i)eth(?:ernet)?(\d+)/(\d+)$`)
+	nxosVLAN     = regexp.MustCompile(`^(?i)vlan(\d+)$`)
+)
+
+// xrInterfaceName names an NX-OS interface like XR: Ethernet ports become
+// HundredGigE rack/slot/instance/port counted from 0, SVIs become BVIs
+func xrInterfaceName(name string) string {
+	if m := nxosEthernet.FindStringSubmatch(name); m != nil {
+		slot, _ := strconv.Atoi(m[1])
+		port, _ := strconv.Atoi(m[2])
+		return fmt.Sprintf("HundredGigE0/%d/0/%d", slot-1, port-1)
+	}
+	if m := nxosVLAN.FindStringSubmatch(name); m != nil {
+		return "BVI" + m[1]
+	}
+	return name
+}
+
+// xrVersion reports the simulated release as an XR one until an upgrade
+// names another
+func xrVersion(version string) string {
+	if version == baseSoftwareVersion {
+		return xrBaseVersion
+	}
+	return version
+}
+
+// xrCPUTotals replaces the user, kernel and idle shares with the total CPU
+// percentages XR reports
+func xrCPUTotals(content []*telemetry.TelemetryField, ts uint64) []*telemetry.TelemetryField {
+	total := 0.0
+	kept := slices.DeleteFunc(content, func(f *telemetry.TelemetryField) bool {
+		if f.DoubleValue == nil {
+			return false
+		}
+		if f.Name != "idle-percent" {
+			total += *f.DoubleValue
+		}
+		return true
+	})
+	busy := uint32(math.Round(total))
+	return append(kept,
+		telemetry.Uint32Field("total-cpu-one-minute", busy, ts),
+		telemetry.Uint32Field("total-cpu-five-minute", busy, ts),
+		telemetry.Uint32Field("total-cpu-fifteen-minute", busy, ts),
+	)
+}
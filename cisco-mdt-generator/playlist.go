@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Playlist is a battery of scenarios checked headless from one invocation,
+// e.g. for nightly regression runs
+type Playlist struct {
+	Name      string          `yaml:"name"`
+	Parallel  bool            `yaml:"parallel"` // check every scenario at once instead of one after the other
+	Scenarios []PlaylistEntry `yaml:"scenarios"`
+}
+
+// PlaylistEntry is one scenario of a playlist and the node group it runs on.
+// Paths are relative to the playlist file.
+type PlaylistEntry struct {
+	Name     string        `yaml:"name,omitempty"`     // of its reports, the scenario file name when empty
+	Scenario string        `yaml:"scenario"`           // scenario file
+	Config   string        `yaml:"config,omitempty"`   // configuration, --config when empty
+	Nodes    []string      `yaml:"nodes,omitempty"`    // node group, --node when empty
+	Minutes  int           `yaml:"minutes,omitempty"`  // virtual minutes, --minutes when 0
+	Interval time.Duration `yaml:"interval,omitempty"` // between steps, --interval when 0
+	Seed     int64         `yaml:"seed,omitempty"`     // of the simulation, --seed or simulation.seed when 0
+}
+
+// LoadPlaylist loads a playlist, resolving its paths against its directory
+func LoadPlaylist(path string) (*Playlist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	p := &Playlist{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse playlist YAML: %w", err)
+	}
+	if len(p.Scenarios) == 0 {
+		return nil, fmt.Errorf("playlist %s has no scenarios", path)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+	names := make(map[string]bool)
+	for i := range p.Scenarios {
+		e := &p.Scenarios[i]
+		if e.Scenario == "" {
+			return nil, fmt.Errorf("scenarios[%d]: scenario is required", i)
+		}
+		if e.Minutes < 0 || e.Interval < 0 {
+			return nil, fmt.Errorf("scenarios[%d]: minutes and interval must be non-negative", i)
+		}
+		e.Scenario, e.Config = resolve(e.Scenario), resolve(e.Config)
+		if e.Name == "" {
+			e.Name = strings.TrimSuffix(filepath.Base(e.Scenario), filepath.Ext(e.Scenario))
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("scenarios[%d]: name %q is used twice, set distinct names", i, e.Name)
+		}
+		names[e.Name] = true
+	}
+	return p, nil
+}
+
+// playlistOptions are the flags of the playlist command
+type playlistOptions struct {
+	simOptions
+	minutes   int
+	reportDir string // a directory of reports per scenario
+	parallel  bool
+	verbose   bool
+}
+
+func newPlaylistCmd() *cobra.Command {
+	var o playlistOptions
+	cmd := &cobra.Command{
+		Use:   "playlist <playlist file>",
+		Short: "Check a playlist of scenarios headless, one after the other or in parallel",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exitWith(runPlaylist(o, args[0]))
+		},
+	}
+	addSimFlags(cmd, &o.simOptions)
+	cmd.Flags().IntVar(&o.minutes, "minutes", 60, "Virtual minutes to simulate each scenario that sets none")
+	cmd.Flags().StringVar(&o.reportDir, "report-dir", "", "Write the outcome and statistics report of every scenario under this directory")
+	cmd.Flags().BoolVar(&o.parallel, "parallel", false, "Check every scenario at once, as with parallel: true")
+	cmd.Flags().BoolVarP(&o.verbose, "verbose", "v", false, "Show simulation log output")
+	cmd.Flags().MarkHidden("scenario")
+	cmd.MarkFlagFilename("report-dir")
+	return cmd
+}
+
+// runPlaylist checks every scenario of a playlist on its node group and
+// prints the outcome of each. It returns the process exit code: 1 when a
+// scenario failed, 2 when one could not run.
+func runPlaylist(o playlistOptions, path string) int {
+	p, err := LoadPlaylist(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if !o.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	results := make([]playlistResult, len(p.Scenarios))
+	done := make([]chan struct{}, len(p.Scenarios))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	check := func(i int) {
+		results[i] = o.checkEntry(p.Scenarios[i])
+		close(done[i])
+	}
+	start := time.Now()
+	if p.Parallel || o.parallel {
+		for i := range p.Scenarios {
+			go check(i)
+		}
+	} else {
+		go func() {
+			for i := range p.Scenarios {
+				check(i)
+			}
+		}()
+	}
+
+	// Outcomes are printed in playlist order as they complete
+	code := 0
+	for i, e := range p.Scenarios {
+		<-done[i]
+		r := results[i]
+		status := "PASS"
+		switch {
+		case r.err != nil:
+			status, code = "ERROR", 2
+		case !r.ok:
+			status, code = "FAIL", max(code, 1)
+		}
+		fmt.Printf("%-5s %s (%s, %d virtual minutes, %s)\n", status, e.Name, strings.Join(r.nodes, " "), r.minutes, r.elapsed.Round(time.Millisecond))
+		if r.err != nil {
+			fmt.Printf("      %v\n", r.err)
+		} else if !r.ok && o.reportDir == "" {
+			for _, line := range strings.Split(strings.TrimSpace(r.output), "\n") {
+				fmt.Printf("      %s\n", line)
+			}
+		}
+	}
+
+	passed := 0
+	for _, r := range results {
+		if r.ok && r.err == nil {
+			passed++
+		}
+	}
+	fmt.Printf("Playlist %s: %d of %d scenarios passed in %s\n", p.Name, passed, len(results), time.Since(start).Round(time.Millisecond))
+	return code
+}
+
+// playlistResult is the outcome of one scenario of a playlist
+type playlistResult struct {
+	ok      bool
+	err     error
+	output  string // the check output of every node
+	nodes   []string
+	minutes int
+	elapsed time.Duration
+}
+
+// checkEntry checks a scenario on every node of its group, each with its own
+// configuration, simulator and scenario engine, and writes its reports
+func (o playlistOptions) checkEntry(e PlaylistEntry) playlistResult {
+	start := time.Now()
+	so := o.simOptions
+	so.scenarioPath, so.scenario = e.Scenario, nil
+	if e.Config != "" {
+		so.configPath, so.auto = e.Config, nil
+	}
+	if e.Interval > 0 {
+		so.interval = e.Interval
+	}
+	if e.Seed != 0 {
+		so.seed = e.Seed
+	}
+	minutes := o.minutes
+	if e.Minutes > 0 {
+		minutes = e.Minutes
+	}
+	nodes := e.Nodes
+	if len(nodes) == 0 {
+		nodes = []string{o.nodeID}
+	}
+	r := playlistResult{ok: true, nodes: nodes, minutes: minutes}
+
+	dir := ""
+	if o.reportDir != "" {
+		dir = filepath.Join(o.reportDir, e.Name)
+		if r.err = os.MkdirAll(dir, 0o755); r.err != nil {
+			return r
+		}
+	}
+
+	var out bytes.Buffer
+	for _, node := range nodes {
+		cfg, err := so.config()
+		if err != nil {
+			r.err = fmt.Errorf("failed to load configuration: %w", err)
+			return r
+		}
+		report := ""
+		if dir != "" {
+			report = filepath.Join(dir, node+"-stats.txt")
+		}
+		fmt.Fprintf(&out, "%s:\n", node)
+		ok, err := checkNode(so, cfg, node, minutes, report, &out)
+		if err != nil {
+			r.err = fmt.Errorf("%s: %w", node, err)
+			return r
+		}
+		r.ok = r.ok && ok
+	}
+	r.output = out.String()
+	r.elapsed = time.Since(start)
+	if dir != "" {
+		r.err = os.WriteFile(filepath.Join(dir, "result.txt"), out.Bytes(), 0o644)
+	}
+	return r
+}
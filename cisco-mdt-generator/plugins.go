@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+const (
+	// pluginHostModule is the import module of the host API
+	pluginHostModule = "mdtsim"
+	// pluginCallTimeout bounds one collect call; a plugin that exceeds it is
+	// stopped
+	pluginCallTimeout = time.Second
+	// pluginMemoryPages caps the memory of each plugin instance (64 KiB pages)
+	pluginMemoryPages = 1024
+)
+
+// The WebAssembly runtime and compiled plugins are shared by every node of
+// the process; each node gets its own instance of each plugin
+var (
+	pluginRuntimeOnce sync.Once
+	pluginRuntime     wazero.Runtime
+	pluginRuntimeErr  error
+
+	pluginModulesMu sync.Mutex
+	pluginModules   = make(map[string]wazero.CompiledModule)
+)
+
+// Plugin is one node's instance of a sensor generator compiled to
+// WebAssembly. Every interval its collect export is called and emits the
+// rows of one telemetry message through the host API.
+type Plugin struct {
+	cfg     PluginConfig
+	nodeID  string
+	module  api.Module
+	collect api.Function
+	stopped bool
+}
+
+// pluginCall collects the rows a plugin emits during one collect call
+type pluginCall struct {
+	plugin *Plugin
+	ts     uint64
+	rows   []*telemetry.TelemetryField
+	keys   []*telemetry.TelemetryField // of the current row
+	fields []*telemetry.TelemetryField
+	open   bool
+}
+
+type pluginCallKey struct{}
+
+// newPlugins instantiates every configured plugin for a node
+func newPlugins(cfgs []PluginConfig, nodeID string) ([]*Plugin, error) {
+	var plugins []*Plugin
+	for _, cfg := range cfgs {
+		p, err := newPlugin(cfg, nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", cfg.Path, err)
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// newPlugin instantiates a plugin, running its initialization
+func newPlugin(cfg PluginConfig, nodeID string) (*Plugin, error) {
+	compiled, err := compilePlugin(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Plugin{cfg: cfg, nodeID: nodeID}
+
+	// Plugins see no files, environment or arguments, only the host API
+	ctx, cancel := p.context(&pluginCall{plugin: p})
+	defer cancel()
+	module, err := pluginRuntime.InstantiateModule(ctx, compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate: %w", err)
+	}
+	p.module, p.collect = module, module.ExportedFunction("collect")
+	return p, nil
+}
+
+// context returns the context of a call into the plugin, which host
+// functions read the call from
+func (p *Plugin) context(c *pluginCall) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithValue(context.Background(), pluginCallKey{}, c), pluginCallTimeout)
+}
+
+// compilePlugin compiles a plugin once per process and checks it exports collect
+func compilePlugin(path string) (wazero.CompiledModule, error) {
+	pluginRuntimeOnce.Do(startPluginRuntime)
+	if pluginRuntimeErr != nil {
+		return nil, pluginRuntimeErr
+	}
+
+	pluginModulesMu.Lock()
+	defer pluginModulesMu.Unlock()
+	if compiled, ok := pluginModules[path]; ok {
+		return compiled, nil
+	}
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin: %w", err)
+	}
+	compiled, err := pluginRuntime.CompileModule(context.Background(), code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile plugin: %w", err)
+	}
+	if _, ok := compiled.ExportedFunctions()["collect"]; !ok {
+		return nil, fmt.Errorf("plugin exports no collect function")
+	}
+	pluginModules[path] = compiled
+	return compiled, nil
+}
+
+// startPluginRuntime creates the runtime with WASI, which languages need
+// for their own runtime, and the host API
+func startPluginRuntime() {
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(pluginMemoryPages))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		pluginRuntimeErr = fmt.Errorf("failed to set up WASI: %w", err)
+		return
+	}
+
+	host := r.NewHostModuleBuilder(pluginHostModule)
+	export := func(name string, fn any) {
+		host.NewFunctionBuilder().WithFunc(fn).Export(name)
+	}
+	export("clock_ms", func(ctx context.Context) int64 {
+		return int64(callOf(ctx).ts)
+	})
+	export("node_id", func(ctx context.Context, m api.Module, ptr, size uint32) uint32 {
+		id := callOf(ctx).plugin.nodeID
+		m.Memory().Write(ptr, []byte(id)[:min(len(id), int(size))])
+		return uint32(len(id))
+	})
+	export("log", func(ctx context.Context, m api.Module, ptr, size uint32) {
+		c := callOf(ctx)
+		log.Printf("Plugin %s on %s: %s", c.plugin.cfg.Subscription, c.plugin.nodeID, readString(m, ptr, size))
+	})
+	export("row", func(ctx context.Context) {
+		callOf(ctx).row()
+	})
+	export("key_string", func(ctx context.Context, m api.Module, name, nameLen, value, valueLen uint32) {
+		c := callOf(ctx)
+		c.key(telemetry.StringField(readString(m, name, nameLen), readString(m, value, valueLen), c.ts))
+	})
+	export("key_uint64", func(ctx context.Context, m api.Module, name, nameLen uint32, value uint64) {
+		c := callOf(ctx)
+		c.key(telemetry.Uint64Field(readString(m, name, nameLen), value, c.ts))
+	})
+	export("field_string", func(ctx context.Context, m api.Module, name, nameLen, value, valueLen uint32) {
+		c := callOf(ctx)
+		c.field(telemetry.StringField(readString(m, name, nameLen), readString(m, value, valueLen), c.ts))
+	})
+	export("field_uint64", func(ctx context.Context, m api.Module, name, nameLen uint32, value uint64) {
+		c := callOf(ctx)
+		c.field(telemetry.Uint64Field(readString(m, name, nameLen), value, c.ts))
+	})
+	export("field_int64", func(ctx context.Context, m api.Module, name, nameLen uint32, value int64) {
+		c := callOf(ctx)
+		c.field(&telemetry.TelemetryField{Name: readString(m, name, nameLen), Sint64Value: &value, Timestamp: c.ts})
+	})
+	export("field_double", func(ctx context.Context, m api.Module, name, nameLen uint32, value float64) {
+		c := callOf(ctx)
+		c.field(telemetry.DoubleField(readString(m, name, nameLen), value, c.ts))
+	})
+	export("field_bool", func(ctx context.Context, m api.Module, name, nameLen, value uint32) {
+		c := callOf(ctx)
+		c.field(telemetry.BoolField(readString(m, name, nameLen), value != 0, c.ts))
+	})
+	if _, err := host.Instantiate(ctx); err != nil {
+		pluginRuntimeErr = fmt.Errorf("failed to set up the host API: %w", err)
+		return
+	}
+	pluginRuntime = r
+}
+
+// callOf returns the collect call a host function runs in
+func callOf(ctx context.Context) *pluginCall {
+	return ctx.Value(pluginCallKey{}).(*pluginCall)
+}
+
+// readString copies a string out of plugin memory. Out of range reads
+// yield an empty string.
+func readString(m api.Module, ptr, size uint32) string {
+	b, _ := m.Memory().Read(ptr, size)
+	return string(b)
+}
+
+// row finishes the current row and starts a new one
+func (c *pluginCall) row() {
+	if c.open && (len(c.keys) > 0 || len(c.fields) > 0) {
+		c.rows = append(c.rows, telemetry.RowField(c.keys, c.fields, c.ts))
+	}
+	c.keys, c.fields, c.open = nil, nil, true
+}
+
+// key adds a key to the current row, starting one if needed
+func (c *pluginCall) key(f *telemetry.TelemetryField) {
+	if !c.open {
+		c.row()
+	}
+	c.keys = append(c.keys, f)
+}
+
+// field adds a content field to the current row, starting one if needed
+func (c *pluginCall) field(f *telemetry.TelemetryField) {
+	if !c.open {
+		c.row()
+	}
+	c.fields = append(c.fields, f)
+}
+
+// Collect calls the plugin and returns the telemetry of the rows it emitted,
+// or nil when it emitted none. A plugin that fails or runs too long is
+// stopped for the rest of the run.
+func (p *Plugin) Collect(now time.Time) *telemetry.Telemetry {
+	if p.stopped {
+		return nil
+	}
+	c := &pluginCall{plugin: p, ts: uint64(now.UnixMilli())}
+	ctx, cancel := p.context(c)
+	defer cancel()
+	if _, err := p.collect.Call(ctx); err != nil {
+		log.Printf("Plugin %s on %s stopped: %v", p.cfg.Subscription, p.nodeID, err)
+		p.stopped = true
+		return nil
+	}
+	c.row()
+	if len(c.rows) == 0 {
+		return nil
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           p.nodeID,
+		SubscriptionIDStr:   p.cfg.Subscription,
+		EncodingPath:        p.cfg.EncodingPath,
+		CollectionStartTime: c.ts,
+		CollectionEndTime:   c.ts,
+		MsgTimestamp:        c.ts,
+		DataGpbkv:           c.rows,
+	}
+}
+
+// checkPlugins ensures plugins are configured with a distinct subscription
+// that does not shadow a built-in one
+func checkPlugins(plugins []PluginConfig) error {
+	builtIn := sensorSubscriptions()
+	seen := make(map[string]bool)
+	for i, p := range plugins {
+		if p.Path == "" || p.Subscription == "" || p.EncodingPath == "" {
+			return fmt.Errorf("plugin %d needs a path, subscription and encoding_path", i)
+		}
+		if slices.Contains(builtIn, p.Subscription) || seen[p.Subscription] {
+			return fmt.Errorf("plugin %s: subscription %q is already used", p.Path, p.Subscription)
+		}
+		seen[p.Subscription] = true
+	}
+	return nil
+}
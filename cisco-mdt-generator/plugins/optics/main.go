@@ -0,0 +1,43 @@
+//go:build wasip1
+
+// Command optics is an example sensor plugin: transceiver digital optical
+// monitoring of four host ports, with receive power drifting slowly and
+// temperature following a daily cycle. Build it with
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o optics.wasm ./plugins/optics
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"cisco-mdt-generator/pkg/plugin"
+)
+
+// rxPower is the receive power of each port in dBm
+var rxPower = []float64{-2.1, -2.4, -1.9, -3.0}
+
+func main() {}
+
+//go:wasmexport collect
+func collect() {
+	now := plugin.Clock()
+	hour := float64(now.Hour()) + float64(now.Minute())/60
+	temperature := 38 + 4*math.Sin(2*math.Pi*(hour-9)/24)
+
+	for i := range rxPower {
+		rxPower[i] = min(max(rxPower[i]+(rand.Float64()-0.5)*0.05, -14), 0.5)
+		if rxPower[i] < -12 {
+			plugin.Log(fmt.Sprintf("eth1/%d receive power low: %.2f dBm", i+1, rxPower[i]))
+		}
+
+		plugin.Row()
+		plugin.KeyString("id", fmt.Sprintf("eth1/%d", i+1))
+		plugin.FieldDouble("rx-power", math.Round(rxPower[i]*100)/100)
+		plugin.FieldDouble("tx-power", -1.5)
+		plugin.FieldDouble("temperature", math.Round((temperature+float64(i)*0.3)*10)/10)
+		plugin.FieldDouble("voltage", 3.3)
+		plugin.FieldString("type", "QSFP-100G-SR4")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// PoolsConfig allocates the uplink addressing of every node, so large fabrics
+// get valid, unique point-to-point subnets without listing them per node
+type PoolsConfig struct {
+	Spines    int      `yaml:"spines"`     // uplinks allocated per node, 0 keeps the configured bgp_neighbors
+	Underlay  []string `yaml:"underlay"`   // IPv4 prefixes carved into /31 uplinks, spine side first
+	SpineASNs []uint32 `yaml:"spine_asns"` // one ASN shared by every spine, or a [first, last] range
+	LeafASNs  []uint32 `yaml:"leaf_asns"`  // one ASN shared by every leaf, or a [first, last] range, for spine nodes
+}
+
+// checkPools ensures the pools are well formed, do not overlap and have an
+// ASN for every spine
+func checkPools(p PoolsConfig) error {
+	if p.Spines < 0 {
+		return fmt.Errorf("spines must not be negative")
+	}
+	var prefixes []netip.Prefix
+	for _, s := range p.Underlay {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil || !prefix.Addr().Is4() || prefix.Bits() > 31 {
+			return fmt.Errorf("underlay %q is not an IPv4 prefix of /31 or shorter", s)
+		}
+		if prefix != prefix.Masked() {
+			return fmt.Errorf("underlay %s has host bits set, expected %s", s, prefix.Masked())
+		}
+		for _, other := range prefixes {
+			if prefix.Overlaps(other) {
+				return fmt.Errorf("underlay %s overlaps %s", prefix, other)
+			}
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	switch len(p.SpineASNs) {
+	case 0, 1:
+	case 2:
+		if p.SpineASNs[0] > p.SpineASNs[1] {
+			return fmt.Errorf("spine_asns range %d-%d is reversed", p.SpineASNs[0], p.SpineASNs[1])
+		}
+		if n := int(p.SpineASNs[1]-p.SpineASNs[0]) + 1; n < p.Spines {
+			return fmt.Errorf("spine_asns range has %d ASNs for %d spines", n, p.Spines)
+		}
+	default:
+		return fmt.Errorf("spine_asns must be one ASN or a [first, last] range")
+	}
+	if slices.Contains(p.SpineASNs, 0) {
+		return fmt.Errorf("spine_asns must not contain 0")
+	}
+	switch len(p.LeafASNs) {
+	case 0, 1:
+	case 2:
+		if p.LeafASNs[0] > p.LeafASNs[1] {
+			return fmt.Errorf("leaf_asns range %d-%d is reversed", p.LeafASNs[0], p.LeafASNs[1])
+		}
+	default:
+		return fmt.Errorf("leaf_asns must be one ASN or a [first, last] range")
+	}
+	if slices.Contains(p.LeafASNs, 0) {
+		return fmt.Errorf("leaf_asns must not contain 0")
+	}
+
+	if p.Spines > 0 && (len(p.Underlay) == 0 || len(p.SpineASNs) == 0) {
+		return fmt.Errorf("allocating %d spines needs underlay and spine_asns", p.Spines)
+	}
+	return nil
+}
+
+// uplinks allocates the neighbors of the node at index in the node list:
+// link index*spines+j is the j-th /31 of the underlay prefixes, in order.
+// Prefix counts are kept from the configured neighbors.
+func (p PoolsConfig) uplinks(index int, configured []BGPNeighborConfig) ([]BGPNeighborConfig, error) {
+	neighbors := make([]BGPNeighborConfig, p.Spines)
+	for j := range neighbors {
+		if len(configured) > 0 {
+			neighbors[j] = configured[j%len(configured)]
+		}
+
+		addr, ok := p.linkAddr(index*p.Spines + j)
+		if !ok {
+			return nil, fmt.Errorf("underlay pool exhausted: %d /31 uplinks for %d spines per node", p.links(), p.Spines)
+		}
+		neighbors[j].Address = addr.String()
+
+		neighbors[j].RemoteAS = p.SpineASNs[0]
+		if len(p.SpineASNs) == 2 {
+			neighbors[j].RemoteAS += uint32(j)
+		}
+	}
+	return neighbors, nil
+}
+
+// downlinks allocates the neighbors of the spine at index among the spine
+// nodes: the leaf side of the index-th uplink of each of the leafs, with the
+// leaf's ASN. Prefix counts are kept from the configured neighbors.
+func (p PoolsConfig) downlinks(index, leafs int, configured []BGPNeighborConfig) ([]BGPNeighborConfig, error) {
+	if index >= p.Spines {
+		return nil, fmt.Errorf("spine %d has no uplinks, pools allocate %d spines per leaf", index+1, p.Spines)
+	}
+	if len(p.LeafASNs) == 0 {
+		return nil, fmt.Errorf("spine nodes need leaf_asns")
+	}
+	if len(p.LeafASNs) == 2 && int(p.LeafASNs[1]-p.LeafASNs[0])+1 < leafs {
+		return nil, fmt.Errorf("leaf_asns range has %d ASNs for %d leafs", p.LeafASNs[1]-p.LeafASNs[0]+1, leafs)
+	}
+	neighbors := make([]BGPNeighborConfig, leafs)
+	for i := range neighbors {
+		if len(configured) > 0 {
+			neighbors[i] = configured[i%len(configured)]
+		}
+
+		addr, ok := p.linkAddr(i*p.Spines + index)
+		if !ok {
+			return nil, fmt.Errorf("underlay pool exhausted: %d /31 uplinks for %d spines per node", p.links(), p.Spines)
+		}
+		neighbors[i].Address = addr.Next().String()
+
+		neighbors[i].RemoteAS = p.LeafASNs[0]
+		if len(p.LeafASNs) == 2 {
+			neighbors[i].RemoteAS += uint32(i)
+		}
+	}
+	return neighbors, nil
+}
+
+// links returns how many /31 uplinks the underlay prefixes hold
+func (p PoolsConfig) links() int {
+	n := 0
+	for _, s := range p.Underlay {
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			n += 1 << (31 - prefix.Bits())
+		}
+	}
+	return n
+}
+
+// linkAddr returns the spine side address of an uplink
+func (p PoolsConfig) linkAddr(link int) (netip.Addr, bool) {
+	for _, s := range p.Underlay {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			continue
+		}
+		size := 1 << (31 - prefix.Bits())
+		if link >= size {
+			link -= size
+			continue
+		}
+		base := prefix.Addr().As4()
+		var addr [4]byte
+		binary.BigEndian.PutUint32(addr[:], binary.BigEndian.Uint32(base[:])+uint32(2*link))
+		return netip.AddrFrom4(addr), true
+	}
+	return netip.Addr{}, false
+}
+
+// checkAddressing resolves every node and reports uplink addresses used by
+// more than one node, e.g. a per-node override colliding with an allocated
+// uplink. It only applies when pools allocate point-to-point uplinks: peering
+// every leaf with the same spine loopbacks is valid otherwise.
+func checkAddressing(cfg *Config) error {
+	if cfg.Pools.Spines == 0 {
+		return nil
+	}
+	owners := make(map[string]string)
+	for _, nodeID := range cfg.NodeIDs() {
+		nodeCfg, err := cfg.configFor(nodeID)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodeCfg.BGPNeighbors {
+			if owner, ok := owners[n.Address]; ok && owner != nodeID {
+				return fmt.Errorf("neighbor address %s of node %s collides with node %s", n.Address, nodeID, owner)
+			}
+			owners[n.Address] = nodeID
+		}
+	}
+	return nil
+}
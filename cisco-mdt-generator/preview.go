@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// previewOptions are the flags of the preview command
+type previewOptions struct {
+	simOptions
+	subscriptions []string
+	pathsOnly     bool
+	verbose       bool
+}
+
+func newPreviewCmd() *cobra.Command {
+	var o previewOptions
+	cmd := &cobra.Command{
+		Use:   "preview",
+		Short: "Print the telemetry one collection of the configuration emits",
+		Long: "Builds one collection of the simulated node without connecting anywhere,\n" +
+			"encodes and decodes every message and prints its field tree, so paths and\n" +
+			"fields can be confirmed before a run is aimed at a shared collector.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPreview(os.Stdout, o)
+		},
+	}
+	addSimFlags(cmd, &o.simOptions)
+	cmd.Flags().StringArrayVarP(&o.subscriptions, "subscription", "s", nil, "Only print this subscription, repeatable")
+	cmd.Flags().BoolVar(&o.pathsOnly, "paths", false, "Print one line per subscription with its encoding path, rows and size instead of the field trees")
+	cmd.Flags().BoolVarP(&o.verbose, "verbose", "v", false, "Show simulation log output")
+	return cmd
+}
+
+// runPreview simulates one interval of the node and prints the messages it
+// would send, after a round trip through the GPB encoding
+func runPreview(w io.Writer, o previewOptions) error {
+	if !o.verbose {
+		log.SetOutput(io.Discard)
+	}
+	cfg, err := o.config()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	// Previews never send syslog anywhere
+	cfg.Syslog = SyslogConfig{}
+
+	start := time.Now()
+	sim, scenario, err := o.newNode(cfg, o.nodeID, start)
+	if err != nil {
+		return err
+	}
+	now := start.Add(o.interval)
+	scenario.Advance(sim, now)
+	sim.Step(now)
+	messages := sim.BuildTelemetry(now)
+
+	known := make(map[string]bool)
+	for _, m := range messages {
+		known[m.SubscriptionIDStr] = true
+	}
+	for _, sub := range o.subscriptions {
+		if !known[sub] {
+			return fmt.Errorf("node %s sends no subscription %q", o.nodeID, sub)
+		}
+	}
+
+	printed, size := 0, 0
+	for _, m := range messages {
+		if len(o.subscriptions) > 0 && !slices.Contains(o.subscriptions, m.SubscriptionIDStr) {
+			continue
+		}
+		payload, err := m.Marshal()
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.SubscriptionIDStr, err)
+		}
+		decoded, err := telemetry.Unmarshal(payload)
+		if err != nil {
+			return fmt.Errorf("%s: encoded message does not decode: %w", m.SubscriptionIDStr, err)
+		}
+		printed++
+		size += len(payload)
+
+		if o.pathsOnly {
+			fmt.Fprintf(w, "%-24s %-60s %4d rows %7d bytes\n", decoded.SubscriptionIDStr, decoded.EncodingPath, len(decoded.DataGpbkv), len(payload))
+			continue
+		}
+		fmt.Fprintf(w, "# %s: %d bytes\n", decoded.SubscriptionIDStr, len(payload))
+		printTelemetry(w, decodeOptions{}, decoded)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s: %d messages, %d bytes per collection\n", o.nodeID, printed, size)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Subscription priority classes
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+// priorityClasses lists the classes in the order they are shed
+var priorityClasses = []string{priorityLow, priorityNormal, priorityHigh}
+
+// Priorities maps subscriptions to priority classes, like the QoS a device
+// applies to its telemetry. Unlisted subscriptions are normal.
+type Priorities map[string]string
+
+// Class returns the priority class of a subscription
+func (p Priorities) Class(subscription string) string {
+	if class, ok := p[subscription]; ok {
+		return class
+	}
+	return priorityNormal
+}
+
+// rank orders classes from shed first (0) to shed last
+func (p Priorities) rank(subscription string) int {
+	return slices.Index(priorityClasses, p.Class(subscription))
+}
+
+// checkPriorities ensures every configured class is known
+func checkPriorities(p Priorities) error {
+	for sub, class := range p {
+		if !slices.Contains(priorityClasses, class) {
+			return fmt.Errorf("subscription %s: unknown class %q, expected high, normal or low", sub, class)
+		}
+	}
+	return nil
+}
+
+// classDrops counts dropped messages per priority class
+type classDrops map[string]uint64
+
+// add counts the messages of a subscription
+func (d classDrops) add(p Priorities, m *telemetry.Telemetry) {
+	d[p.Class(m.SubscriptionIDStr)]++
+}
+
+// total returns the drops of every class
+func (d classDrops) total() uint64 {
+	var n uint64
+	for _, v := range d {
+		n += v
+	}
+	return n
+}
+
+// String formats the counters in shedding order, e.g. "3 low, 1 normal"
+func (d classDrops) String() string {
+	s := ""
+	for _, class := range priorityClasses {
+		if d[class] == 0 {
+			continue
+		}
+		if s != "" {
+			s += ", "
+		}
+		s += fmt.Sprintf("%d %s", d[class], class)
+	}
+	return s
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+const (
+	// probeSubscription and probeEncodingPath identify probe messages
+	probeSubscription = "slo-probe"
+	probeEncodingPath = "mdtsim:probe"
+	// probeSeqField and probeSentField are the content fields of probe rows;
+	// the receiver matches them by suffix, whatever prefix the collector adds
+	probeSeqField  = "probe_seq"
+	probeSentField = "probe_sent_ms"
+)
+
+// Probe numbers one probe message per interval and matches the messages the
+// collector pipeline delivers back to the probe receiver, measuring their
+// end-to-end latency
+type Probe struct {
+	mu         sync.Mutex
+	seq        uint64
+	sent       []time.Time     // send time of each sequence number, from 1
+	latency    []time.Duration // of each sequence number, -1 until delivered
+	duplicates int
+}
+
+// NewProbe returns a probe that has sent nothing yet
+func NewProbe() *Probe {
+	return &Probe{}
+}
+
+// BuildTelemetry numbers the next probe message and records when it is sent
+func (p *Probe) BuildTelemetry(ts uint64, nodeID string) *telemetry.Telemetry {
+	p.mu.Lock()
+	p.seq++
+	seq := p.seq
+	p.sent = append(p.sent, time.Now())
+	p.latency = append(p.latency, -1)
+	p.mu.Unlock()
+
+	row := telemetry.RowField(
+		[]*telemetry.TelemetryField{telemetry.StringField("node", nodeID, ts)},
+		[]*telemetry.TelemetryField{
+			telemetry.Uint64Field(probeSeqField, seq, ts),
+			telemetry.Uint64Field(probeSentField, ts, ts),
+		}, ts)
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   probeSubscription,
+		EncodingPath:        probeEncodingPath,
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           []*telemetry.TelemetryField{row},
+	}
+}
+
+// deliver records the arrival of a probe message. Sequence numbers the probe
+// never sent, e.g. from an earlier run, are ignored.
+func (p *Probe) deliver(seq uint64, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if seq == 0 || seq > p.seq {
+		return
+	}
+	if p.latency[seq-1] >= 0 {
+		p.duplicates++
+		return
+	}
+	p.latency[seq-1] = max(at.Sub(p.sent[seq-1]), 0)
+}
+
+// ServeHTTP accepts InfluxDB line protocol writes (/write and /api/v2/write),
+// so the collector's InfluxDB output can point at the probe
+func (p *Probe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	at := time.Now()
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		if seq, ok := probeSeq(scanner.Text()); ok {
+			p.deliver(seq, at)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// probeSeq returns the sequence number of a line protocol line carrying a
+// probe row
+func probeSeq(line string) (uint64, bool) {
+	if !strings.Contains(line, probeSeqField) {
+		return 0, false
+	}
+	// measurement,tags fields timestamp
+	sections := splitUnescaped(line, ' ')
+	if len(sections) < 2 {
+		return 0, false
+	}
+	for _, field := range splitUnescaped(sections[1], ',') {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || !strings.HasSuffix(name, probeSeqField) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimRight(value, "iu"), 10, 64)
+		return seq, err == nil
+	}
+	return 0, false
+}
+
+// splitUnescaped splits s at every sep that is neither escaped with a
+// backslash nor inside a quoted string
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// probeResult summarizes the probe messages sent before a cutoff
+type probeResult struct {
+	settled    int // sent before the cutoff
+	delivered  int
+	within     int // delivered within the latency objective
+	gaps       int // not delivered by the cutoff
+	duplicates int
+	p50, p99   time.Duration
+	maxLatency time.Duration
+}
+
+// result evaluates the messages sent at least grace ago against the latency
+// objective. Messages not delivered within grace count as gaps.
+func (p *Probe) result(now time.Time, grace, objective time.Duration) probeResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r := probeResult{duplicates: p.duplicates}
+	var latencies []time.Duration
+	for i, sent := range p.sent {
+		if now.Sub(sent) < grace {
+			break
+		}
+		r.settled++
+		l := p.latency[i]
+		if l < 0 || l > grace {
+			r.gaps++
+			continue
+		}
+		r.delivered++
+		if l <= objective {
+			r.within++
+		}
+		latencies = append(latencies, l)
+	}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		r.p50 = latencies[(len(latencies)-1)*50/100]
+		r.p99 = latencies[(len(latencies)-1)*99/100]
+		r.maxLatency = latencies[len(latencies)-1]
+	}
+	return r
+}
+
+// late returns how many settled messages missed the latency objective
+func (r probeResult) late() int {
+	return r.settled - r.within
+}
+
+// String summarizes a result for the status lines and the final report
+func (r probeResult) String() string {
+	within := 100.0
+	if r.settled > 0 {
+		within = 100 * float64(r.within) / float64(r.settled)
+	}
+	return fmt.Sprintf("%d sent, %d delivered, %.2f%% within objective, %d gaps, %d duplicates, latency p50 %s p99 %s max %s",
+		r.settled, r.delivered, within, r.gaps, r.duplicates,
+		r.p50.Round(time.Millisecond), r.p99.Round(time.Millisecond), r.maxLatency.Round(time.Millisecond))
+}
+
+// probeOptions are the flags of the probe command
+type probeOptions struct {
+	simOptions
+	server      string
+	listen      string
+	latency     time.Duration
+	percentile  float64
+	maxGaps     int
+	grace       time.Duration
+	duration    time.Duration
+	statusEvery time.Duration
+	verbose     bool
+}
+
+func newProbeCmd() *cobra.Command {
+	var o probeOptions
+	cmd := &cobra.Command{
+		Use:   "probe",
+		Short: "Stream through a collector pipeline and assert delivery latency and gap SLOs",
+		Long: "Streams the simulated node with one numbered probe message per interval and\n" +
+			"receives the probe rows back from the collector's InfluxDB output. It fails\n" +
+			"as soon as an SLO can no longer be met, and otherwise at the end of the run.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exitWith(runProbe(o))
+		},
+	}
+	addSimFlags(cmd, &o.simOptions)
+	fs := cmd.Flags()
+	addServerFlag(fs, &o.server)
+	fs.StringVar(&o.listen, "listen", ":8186", "Address of the receiver for the collector's InfluxDB line protocol writes")
+	fs.DurationVar(&o.latency, "latency", 2*time.Second, "Latency objective from sending a message to its delivery")
+	fs.Float64Var(&o.percentile, "percentile", 99, "Percentage of messages that must be delivered within --latency")
+	fs.IntVar(&o.maxGaps, "max-gaps", 0, "Messages that may go undelivered")
+	fs.DurationVar(&o.grace, "grace", 30*time.Second, "How long a message may take before it counts as a gap")
+	fs.DurationVar(&o.duration, "duration", 10*time.Minute, "How long to stream, 0 until interrupted")
+	fs.DurationVar(&o.statusEvery, "status-every", 10*time.Second, "Interval between status lines")
+	fs.BoolVarP(&o.verbose, "verbose", "v", false, "Show simulation log output")
+	return cmd
+}
+
+// runProbe streams probe messages until the duration ends or an SLO is
+// violated, then waits out the grace period and reports. It returns the
+// process exit code: 1 when an SLO was violated or the stream failed, 2 when
+// the probe could not run.
+func runProbe(o probeOptions) int {
+	if o.percentile <= 0 || o.percentile > 100 {
+		fmt.Fprintf(os.Stderr, "--percentile must be in (0, 100]\n")
+		return 2
+	}
+	if o.grace < o.latency {
+		fmt.Fprintf(os.Stderr, "--grace must be at least --latency\n")
+		return 2
+	}
+	cfg, err := o.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 2
+	}
+	if !o.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	sim, scenario, err := o.newNode(cfg, o.nodeID, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	probe := NewProbe()
+	sim.Probe = probe
+	sim.Discovery, err = NewDiscovery(context.Background(), cfg.Dialout.Discovery, o.server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Collector discovery: %v\n", err)
+		return 2
+	}
+	o.server = sim.Discovery.Server(o.server)
+
+	ln, err := net.Listen("tcp", o.listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start probe receiver: %v\n", err)
+		return 2
+	}
+	receiver := &http.Server{Handler: probe}
+	go receiver.Serve(ln)
+	defer receiver.Close()
+
+	sim.Middleware = NewMiddleware(cfg.Sinks.Middleware, cfg.Priorities)
+	sink, err := openSinks(cfg, sim.Middleware, o.server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if sink != nil {
+		defer sink.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	streamCtx, stopStream := context.WithCancel(ctx)
+	defer stopStream()
+	streamErr := make(chan error, 1)
+	go sim.Discovery.Run(streamCtx)
+	go func() { streamErr <- streamNode(streamCtx, o.server, o.interval, sim, scenario, sink, nil) }()
+
+	target := o.server
+	if target == "" {
+		target = "the sinks"
+	}
+	fmt.Printf("Probing %s: %g%% of messages within %s, at most %d gaps, receiver on %s\n",
+		target, o.percentile, o.latency, o.maxGaps, ln.Addr())
+
+	// With a fixed duration, the latency objective is violated for good once
+	// more messages were late than the whole run may have
+	budget := -1
+	var deadline <-chan time.Time
+	if o.duration > 0 {
+		budget = int(float64(o.duration/o.interval) * (100 - o.percentile) / 100)
+		deadline = time.After(o.duration)
+	}
+
+	status := time.NewTicker(o.statusEvery)
+	defer status.Stop()
+	violation := ""
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-deadline:
+			break loop
+		case err := <-streamErr:
+			if ctx.Err() == nil {
+				violation = fmt.Sprintf("stream failed: %v", err)
+			}
+			break loop
+		case now := <-status.C:
+			r := probe.result(now, o.grace, o.latency)
+			fmt.Printf("%s %s\n", now.Format(time.TimeOnly), r)
+			switch {
+			case r.gaps > o.maxGaps:
+				violation = fmt.Sprintf("%d gaps exceed --max-gaps %d", r.gaps, o.maxGaps)
+			case budget >= 0 && r.late() > budget:
+				violation = fmt.Sprintf("%d late messages exceed the %d the run may have", r.late(), budget)
+			}
+			if violation != "" {
+				break loop
+			}
+		}
+	}
+	stopStream()
+
+	// Messages still in flight get the grace period to arrive
+	if violation == "" {
+		fmt.Printf("Stream stopped, waiting %s for messages in flight\n", o.grace)
+		select {
+		case <-time.After(o.grace):
+		case <-ctx.Done():
+		}
+	}
+
+	r := probe.result(time.Now(), o.grace, o.latency)
+	fmt.Println(r)
+	if violation == "" {
+		switch {
+		case r.settled == 0:
+			violation = "no probe messages were sent"
+		case r.gaps > o.maxGaps:
+			violation = fmt.Sprintf("%d gaps exceed --max-gaps %d", r.gaps, o.maxGaps)
+		case float64(r.within) < float64(r.settled)*o.percentile/100:
+			violation = fmt.Sprintf("%d of %d messages delivered within %s, below %g%%", r.within, r.settled, o.latency, o.percentile)
+		}
+	}
+	if violation != "" {
+		fmt.Printf("FAIL %s\n", violation)
+		return 1
+	}
+	fmt.Println("OK: SLOs met")
+	return 0
+}
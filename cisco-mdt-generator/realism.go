@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// entropyBins is the number of equal-width histogram bins used to estimate
+// the entropy of a series
+const entropyBins = 16
+
+// SeriesStats collects every numeric content field the simulator emits so
+// the statistical shape of each series can be reported at the end of a run
+type SeriesStats struct {
+	series map[string]*series
+}
+
+// series holds the samples of one field of one row
+type series struct {
+	times  []float64
+	values []float64
+}
+
+// seriesSummary is the report line of one series. Counters are summarized
+// by their per-second rate, gauges by their values.
+type seriesSummary struct {
+	name     string
+	kind     string
+	samples  int
+	mean     float64
+	variance float64
+	autocorr float64
+	entropy  float64
+}
+
+func NewSeriesStats() *SeriesStats {
+	return &SeriesStats{series: make(map[string]*series)}
+}
+
+// Observe records the numeric content fields of one round of telemetry
+func (st *SeriesStats) Observe(messages []*telemetry.Telemetry) {
+	for _, m := range messages {
+		for _, row := range m.DataGpbkv {
+			var keys []string
+			var content []*telemetry.TelemetryField
+			for _, section := range row.Fields {
+				switch section.Name {
+				case "keys":
+					for _, k := range section.Fields {
+						keys = append(keys, fieldString(k))
+					}
+				case "content":
+					content = section.Fields
+				}
+			}
+
+			prefix := m.SubscriptionIDStr
+			if len(keys) > 0 {
+				prefix += "[" + strings.Join(keys, ",") + "]"
+			}
+			for _, f := range content {
+				v, ok := numericValue(f)
+				if !ok {
+					continue
+				}
+				name := prefix + " " + f.Name
+				s, ok := st.series[name]
+				if !ok {
+					s = &series{}
+					st.series[name] = s
+				}
+				s.times = append(s.times, float64(row.Timestamp)/1000)
+				s.values = append(s.values, v)
+			}
+		}
+	}
+}
+
+// fieldString renders a key field value for use in a series name
+func fieldString(f *telemetry.TelemetryField) string {
+	if f.StringValue != nil {
+		return *f.StringValue
+	}
+	if v, ok := numericValue(f); ok {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return f.Name
+}
+
+// numericValue returns the value of a numeric telemetry field
+func numericValue(f *telemetry.TelemetryField) (float64, bool) {
+	switch {
+	case f.Uint32Value != nil:
+		return float64(*f.Uint32Value), true
+	case f.Uint64Value != nil:
+		return float64(*f.Uint64Value), true
+	case f.Sint32Value != nil:
+		return float64(*f.Sint32Value), true
+	case f.Sint64Value != nil:
+		return float64(*f.Sint64Value), true
+	case f.DoubleValue != nil:
+		return *f.DoubleValue, true
+	case f.FloatValue != nil:
+		return float64(*f.FloatValue), true
+	}
+	return 0, false
+}
+
+// summarize computes the report line of a series. A series that never
+// decreases and grows at least once is a counter and is summarized by its
+// per-second rate between samples.
+func (s *series) summarize(name string) seriesSummary {
+	sum := seriesSummary{name: name, kind: "gauge", samples: len(s.values)}
+	values := s.values
+
+	counter, grew := len(values) > 1, false
+	for i := 1; i < len(values) && counter; i++ {
+		counter = values[i] >= values[i-1]
+		grew = grew || values[i] > values[i-1]
+	}
+	if counter && grew {
+		sum.kind = "counter"
+		rates := make([]float64, 0, len(values)-1)
+		for i := 1; i < len(values); i++ {
+			if dt := s.times[i] - s.times[i-1]; dt > 0 {
+				rates = append(rates, (values[i]-values[i-1])/dt)
+			}
+		}
+		values = rates
+	}
+
+	sum.mean, sum.variance = meanVariance(values)
+	sum.autocorr = autocorrelation(values, sum.mean, sum.variance)
+	sum.entropy = entropy(values)
+	return sum
+}
+
+// meanVariance returns the mean and population variance of values
+func meanVariance(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, variance / float64(len(values))
+}
+
+// autocorrelation returns the lag-1 autocorrelation of values, 0 for a
+// constant series
+func autocorrelation(values []float64, mean, variance float64) float64 {
+	if len(values) < 2 || variance == 0 {
+		return 0
+	}
+	var cov float64
+	for i := 1; i < len(values); i++ {
+		cov += (values[i] - mean) * (values[i-1] - mean)
+	}
+	return cov / (variance * float64(len(values)))
+}
+
+// entropy returns the Shannon entropy in bits of values over an equal-width
+// histogram spanning their range, from 0 (constant) to log2(entropyBins)
+func entropy(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if hi == lo {
+		return 0
+	}
+
+	var bins [entropyBins]int
+	for _, v := range values {
+		b := int((v - lo) / (hi - lo) * entropyBins)
+		bins[min(b, entropyBins-1)]++
+	}
+
+	var h float64
+	for _, n := range bins {
+		if n > 0 {
+			p := float64(n) / float64(len(values))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// summaries returns the report lines of every series sorted by name
+func (st *SeriesStats) summaries() []seriesSummary {
+	names := make([]string, 0, len(st.series))
+	for name := range st.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]seriesSummary, 0, len(names))
+	for _, name := range names {
+		out = append(out, st.series[name].summarize(name))
+	}
+	return out
+}
+
+// WriteReport writes the statistics report to path, "-" for stdout. Paths
+// ending in .csv get CSV, anything else an aligned table.
+func (st *SeriesStats) WriteReport(path string) error {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if strings.HasSuffix(path, ".csv") {
+		return writeStatsCSV(w, st.summaries())
+	}
+	return writeStatsTable(w, st.summaries())
+}
+
+func writeStatsTable(w io.Writer, summaries []seriesSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIES\tKIND\tSAMPLES\tMEAN\tVARIANCE\tSTDDEV\tAUTOCORR\tENTROPY")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.4g\t%.4g\t%.4g\t%.3f\t%.2f\n", s.name, s.kind, s.samples,
+			s.mean, s.variance, math.Sqrt(s.variance), s.autocorr, s.entropy)
+	}
+	return tw.Flush()
+}
+
+func writeStatsCSV(w io.Writer, summaries []seriesSummary) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"series", "kind", "samples", "mean", "variance", "stddev", "autocorr", "entropy"})
+	format := func(v float64) string { return strconv.FormatFloat(v, 'g', 6, 64) }
+	for _, s := range summaries {
+		cw.Write([]string{s.name, s.kind, strconv.Itoa(s.samples), format(s.mean), format(s.variance),
+			format(math.Sqrt(s.variance)), format(s.autocorr), format(s.entropy)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
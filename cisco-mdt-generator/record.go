@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"cisco-mdt-generator/pkg/mdt_dialout"
+	"cisco-mdt-generator/pkg/recording"
+)
+
+func newRecordCmd() *cobra.Command {
+	var o simOptions
+	var out, report string
+	var minutes int
+	cmd := &cobra.Command{
+		Use:   "record",
+		Short: "Simulate on a virtual clock and record the telemetry to a file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRecord(o, out, minutes, report)
+		},
+	}
+	addSimFlags(cmd, &o)
+	cmd.Flags().StringVarP(&out, "out", "o", "", "Recording file to write")
+	cmd.Flags().IntVar(&minutes, "minutes", 60, "Virtual minutes to record")
+	addReportFlag(cmd.Flags(), &report)
+	cmd.MarkFlagRequired("out")
+	return cmd
+}
+
+// runRecord simulates a node on a virtual clock starting now and writes
+// every telemetry message it would have sent to a recording
+func runRecord(o simOptions, out string, minutes int, report string) error {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// A seeded recording starts at a fixed time, so that recordings with
+	// the same seed are identical
+	start := time.Now().Truncate(time.Second)
+	if cfg.Simulation.Seed != 0 {
+		start = seededStart
+	}
+	sim, scenario, err := o.newNode(cfg, o.nodeID, start)
+	if err != nil {
+		return err
+	}
+	if report != "" {
+		sim.Stats = NewSeriesStats()
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := recording.NewWriter(f)
+	if err != nil {
+		return err
+	}
+
+	steps := int(time.Duration(minutes) * time.Minute / o.interval)
+	records := 0
+	for i := 1; i <= steps; i++ {
+		now := start.Add(time.Duration(i) * o.interval)
+		scenario.Advance(sim, now)
+		sim.Step(now)
+
+		messages := sim.BuildTelemetry(now)
+		sim.OnChange.Commit(messages)
+		for _, telem := range messages {
+			payload, err := telem.Marshal()
+			if err != nil {
+				return fmt.Errorf("failed to marshal %s: %w", telem.SubscriptionIDStr, err)
+			}
+			if err := w.Write(recording.Record{Timestamp: now, Payload: payload}); err != nil {
+				return err
+			}
+			records++
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	log.Printf("Recorded %d messages over %d virtual minutes to %s", records, minutes, out)
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if report != "" {
+		return sim.Stats.WriteReport(report)
+	}
+	return nil
+}
+
+func newReplayCmd() *cobra.Command {
+	var server, in, pacing string
+	var speed float64
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Send a recording to a collector with its original timing",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReplay(server, in, speed, pacing)
+		},
+	}
+	addServerFlag(cmd.Flags(), &server)
+	cmd.Flags().StringVarP(&in, "in", "i", "", "Recording file to replay")
+	cmd.Flags().Float64Var(&speed, "speed", 1, "Replay speed multiplier, 0 sends as fast as possible")
+	cmd.Flags().StringVar(&pacing, "pacing", pacingSleep, "How sends wait for their time: sleep, or spin to busy-wait the last stretch for microsecond gaps at the cost of a core")
+	cmd.MarkFlagRequired("in")
+	return cmd
+}
+
+// Pacing modes of replay
+const (
+	pacingSleep = "sleep"
+	pacingSpin  = "spin"
+)
+
+// spinWindow is how long before a send is due spin pacing stops sleeping and
+// polls the clock, longer than the timer slack of common kernels
+const spinWindow = 2 * time.Millisecond
+
+// pacer waits for the send times of a replay and records how late each send
+// started
+type pacer struct {
+	spin bool
+	late []time.Duration
+}
+
+// wait returns once due has passed
+func (p *pacer) wait(due time.Time) {
+	if p.spin {
+		if d := time.Until(due) - spinWindow; d > 0 {
+			time.Sleep(d)
+		}
+		// Yield while spinning, so the transport can flush the previous
+		// send even on a single core
+		for time.Now().Before(due) {
+			runtime.Gosched()
+		}
+	} else {
+		time.Sleep(time.Until(due))
+	}
+	p.late = append(p.late, time.Since(due))
+}
+
+// report logs how late the sends started, to judge whether the replay kept
+// the burst structure of the recording
+func (p *pacer) report() {
+	if len(p.late) == 0 {
+		return
+	}
+	slices.Sort(p.late)
+	var sum time.Duration
+	for _, d := range p.late {
+		sum += d
+	}
+	p99 := p.late[(len(p.late)-1)*99/100]
+	log.Printf("Sends started late by %s on average, %s at p99, %s at most",
+		sum/time.Duration(len(p.late)), p99, p.late[len(p.late)-1])
+}
+
+// runReplay streams the messages of a recording to the collector, pacing
+// them by their recorded timestamps
+func runReplay(server, in string, speed float64, pacing string) error {
+	if speed < 0 {
+		return fmt.Errorf("speed must be non-negative")
+	}
+	if pacing != pacingSleep && pacing != pacingSpin {
+		return fmt.Errorf("unknown pacing %q, expected %s or %s", pacing, pacingSleep, pacingSpin)
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := recording.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+
+	log.Printf("Connecting to MDT collector at %s ...", server)
+	conn, err := grpc.NewClient(server, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to dial collector: %w", err)
+	}
+	defer conn.Close()
+
+	stream, err := mdt_dialout.NewGRPCMdtDialoutClient(conn).MdtDialout(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to open MdtDialout stream: %w", err)
+	}
+
+	reqID := int64(rand.Int63())
+	p := &pacer{spin: pacing == pacingSpin}
+	var first time.Time
+	replayStart := time.Now()
+	sent := 0
+
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", in, err)
+		}
+
+		if first.IsZero() {
+			first = rec.Timestamp
+		}
+		if speed > 0 {
+			p.wait(replayStart.Add(time.Duration(float64(rec.Timestamp.Sub(first)) / speed)))
+		}
+
+		if err := stream.Send(&mdt_dialout.MdtDialoutArgs{ReqId: reqID, Data: rec.Payload}); err != nil {
+			return fmt.Errorf("failed to send MdtDialoutArgs: %w", err)
+		}
+		sent++
+	}
+
+	log.Printf("Replayed %d messages from %s", sent, in)
+	p.report()
+	// Collectors end the stream without a reply, so only report real failures
+	_, err = stream.CloseAndRecv()
+	if err != nil && !errors.Is(err, io.EOF) && status.Code(err) != codes.Internal {
+		return fmt.Errorf("collector closed the stream: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisClient is a minimal RESP client for the GET and SET commands the
+// state store needs. It connects on first use and again after any error.
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu   sync.Mutex // guards conn and r, one command at a time
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisClient parses a redis://[:password@]host[:port][/db] address
+func newRedisClient(rawURL string, timeout time.Duration) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("address %q is not redis://host:port", rawURL)
+	}
+	c := &redisClient{addr: u.Host, timeout: timeout}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+		if c.password == "" {
+			c.password = u.User.Username()
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("database %q is not a number", db)
+		}
+	}
+	return c, nil
+}
+
+// Get returns the value of key, or nil when it is not set
+func (c *redisClient) Get(ctx context.Context, key string) ([]byte, error) {
+	return c.do(ctx, "GET", []byte(key))
+}
+
+// Set stores value under key
+func (c *redisClient) Set(ctx context.Context, key string, value []byte) error {
+	_, err := c.do(ctx, "SET", []byte(key), value)
+	return err
+}
+
+// Close closes the connection, if any
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// do sends a command and reads its reply, connecting first if needed. A
+// failed connection is dropped so the next command reconnects.
+func (c *redisClient) do(ctx context.Context, cmd string, args ...[]byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.timeout)
+	}
+	c.conn.SetDeadline(deadline)
+	reply, err := c.roundTrip(cmd, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// connect dials the server, authenticates and selects the database
+func (c *redisClient) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: c.timeout}
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if c.password != "" {
+		if _, err = c.roundTrip("AUTH", []byte(c.password)); err != nil {
+			err = fmt.Errorf("auth: %w", err)
+		}
+	}
+	if err == nil && c.db != 0 {
+		if _, err = c.roundTrip("SELECT", []byte(strconv.Itoa(c.db))); err != nil {
+			err = fmt.Errorf("select %d: %w", c.db, err)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		c.conn = nil
+	}
+	return err
+}
+
+// roundTrip writes a command as a RESP array of bulk strings and reads the
+// reply
+func (c *redisClient) roundTrip(cmd string, args ...[]byte) ([]byte, error) {
+	var b []byte
+	b = fmt.Appendf(b, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd)
+	for _, a := range args {
+		b = fmt.Appendf(b, "$%d\r\n", len(a))
+		b = append(b, a...)
+		b = append(b, '\r', '\n')
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// readReply reads a simple string, error, integer or bulk string reply. A
+// null bulk string is returned as nil.
+func (c *redisClient) readReply() ([]byte, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("bad bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"slices"
+)
+
+// reqIDStrategies are the ways of assigning the ReqId of MdtDialoutArgs.
+// Collectors key internal state off ReqId, so each strategy exercises
+// different paths:
+//
+//	stream        one random ReqId per dial-out stream, like a real switch
+//	constant      req_id_value on every node and subscription
+//	subscription  one ReqId per subscription, shared by every node
+//	node          one ReqId per node, stable across restarts
+//	message       a random ReqId for every message
+var reqIDStrategies = []string{"stream", "constant", "subscription", "node", "message"}
+
+// checkReqIDStrategy ensures a ReqId strategy is known
+func checkReqIDStrategy(strategy string) error {
+	if !slices.Contains(reqIDStrategies, strategy) {
+		return fmt.Errorf("unknown req_id strategy %q, expected one of %v", strategy, reqIDStrategies)
+	}
+	return nil
+}
+
+// newReqIDs returns the ReqId assignment of a node's dial-out stream, which
+// maps the subscription of each message to its ReqId. Random ReqIds are
+// drawn from rng.
+func newReqIDs(cfg DialoutConfig, nodeID string, rng *rand.Rand) func(subscription string) int64 {
+	switch cfg.ReqID {
+	case "constant":
+		return func(string) int64 { return cfg.ReqIDValue }
+	case "subscription":
+		return func(subscription string) int64 { return stableReqID(subscription) }
+	case "node":
+		id := stableReqID(nodeID)
+		return func(string) int64 { return id }
+	case "message":
+		return func(string) int64 { return rng.Int63() }
+	default:
+		id := rng.Int63()
+		return func(string) int64 { return id }
+	}
+}
+
+// stableReqID derives a positive ReqId from a name
+func stableReqID(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64() >> 1)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// RESTCONFConfig serves the DME objects of a node over a read-only,
+// NX-API-like RESTCONF endpoint, for pollers that mix REST queries with
+// streaming telemetry
+type RESTCONFConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Listen   string `yaml:"listen"`    // host:port, set per node in the nodes section for a fleet
+	Username string `yaml:"username"`  // empty serves without authentication
+	Password string `yaml:"password"`  // HTTP basic authentication
+	CertFile string `yaml:"cert_file"` // serves HTTPS when set, with key_file
+	KeyFile  string `yaml:"key_file"`
+}
+
+// RESTCONF answers GET requests from the telemetry of a node. It is a sink:
+// every collection replaces the messages of its subscriptions, so a poller
+// reads the values last streamed.
+//
+// A resource is addressed by its encoding path below /restconf/data, with
+// the keys of a list entry after the list name in the order of the row
+// keys, e.g.
+// /restconf/data/Cisco-NX-OS-device:System/bgp-items/inst-items/dom-items/Dom-list/peer-items/Peer-list=10.0.0.1,65001/state.
+// Lists nested in another list are read at their own path.
+type RESTCONF struct {
+	mu       sync.Mutex
+	messages map[string][]*telemetry.Telemetry // latest messages by subscription
+	order    []string                          // subscriptions in the order first collected
+}
+
+// restNode is an element of the encoding paths, with the rows of the paths
+// ending there
+type restNode struct {
+	module   string
+	children map[string]*restNode
+	rows     []*telemetry.TelemetryField
+}
+
+// restSegment is an element of a requested path
+type restSegment struct {
+	name string
+	keys []string // nil unless the element selects list entries
+}
+
+// NewRESTCONF creates an endpoint without collections
+func NewRESTCONF() *RESTCONF {
+	return &RESTCONF{messages: make(map[string][]*telemetry.Telemetry)}
+}
+
+func (r *RESTCONF) Name() string { return "restconf" }
+
+// Write replaces the messages of the collected subscriptions. Subscriptions
+// missing from a collection keep their messages.
+func (r *RESTCONF) Write(messages []*telemetry.Telemetry) error {
+	latest := make(map[string][]*telemetry.Telemetry)
+	for _, m := range messages {
+		latest[m.SubscriptionIDStr] = append(latest[m.SubscriptionIDStr], m)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for sub, m := range latest {
+		if _, ok := r.messages[sub]; !ok {
+			r.order = append(r.order, sub)
+		}
+		r.messages[sub] = m
+	}
+	return nil
+}
+
+// Close does nothing; the listener stops with the node
+func (r *RESTCONF) Close() error { return nil }
+
+// tree builds the elements of the latest encoding paths
+func (r *RESTCONF) tree() *restNode {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	root := &restNode{}
+	for _, sub := range r.order {
+		for _, m := range r.messages[sub] {
+			module, _, found := strings.Cut(m.EncodingPath, ":")
+			if !found {
+				module = ""
+			}
+			node := root
+			for _, e := range gnmiPath(m.EncodingPath, "").Elem {
+				node = node.child(e.Name, module)
+			}
+			node.rows = append(node.rows, m.DataGpbkv...)
+		}
+	}
+	return root
+}
+
+// child returns the element name below n, adding it when missing
+func (n *restNode) child(name, module string) *restNode {
+	if n.children == nil {
+		n.children = make(map[string]*restNode)
+	}
+	c, ok := n.children[name]
+	if !ok {
+		c = &restNode{module: module}
+		n.children[name] = c
+	}
+	return c
+}
+
+// list tells whether the rows of n are entries of a list
+func (n *restNode) list() bool {
+	for _, row := range n.rows {
+		if len(restSection(row, "keys")) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// value converts n to its JSON value: the entries of a list, or an object
+// with the content of its row and its children
+func (n *restNode) value() any {
+	if n.list() {
+		entries := make([]any, 0, len(n.rows))
+		for _, row := range n.rows {
+			entries = append(entries, restEntry(row))
+		}
+		return entries
+	}
+	obj := make(map[string]any)
+	for _, row := range n.rows {
+		for name, v := range restEntry(row) {
+			obj[name] = v
+		}
+	}
+	for name, c := range n.children {
+		obj[name] = c.value()
+	}
+	return obj
+}
+
+// filter returns a copy of n with the rows whose keys start with values, or
+// nil when none does
+func (n *restNode) filter(values []string) *restNode {
+	f := &restNode{module: n.module}
+	for _, row := range n.rows {
+		if restKeysMatch(row, values) {
+			f.rows = append(f.rows, row)
+		}
+	}
+	for name, c := range n.children {
+		if c = c.filter(values); c != nil {
+			f.child(name, c.module)
+			f.children[name] = c
+		}
+	}
+	if len(f.rows) == 0 && len(f.children) == 0 {
+		return nil
+	}
+	return f
+}
+
+// restEntry merges the keys and content of a row into one object
+func restEntry(row *telemetry.TelemetryField) map[string]any {
+	obj := fieldsJSON(restSection(row, "keys"))
+	for name, v := range fieldsJSON(restSection(row, "content")) {
+		obj[name] = v
+	}
+	return obj
+}
+
+// restSection returns the fields of the keys or content of a row
+func restSection(row *telemetry.TelemetryField, name string) []*telemetry.TelemetryField {
+	for _, section := range row.Fields {
+		if section.Name == name {
+			return section.Fields
+		}
+	}
+	return nil
+}
+
+// restKeysMatch tells whether the keys of a row start with values
+func restKeysMatch(row *telemetry.TelemetryField, values []string) bool {
+	keys := restSection(row, "keys")
+	if len(values) > len(keys) {
+		return false
+	}
+	for i, v := range values {
+		if keyValue(keys[i]) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRESTPath splits a path below /restconf/data into its elements,
+// dropping YANG module prefixes and unescaping key values
+func parseRESTPath(path string) ([]restSegment, error) {
+	var segments []restSegment
+	for _, s := range strings.Split(strings.Trim(path, "/"), "/") {
+		if s == "" {
+			continue
+		}
+		name, keys, hasKeys := strings.Cut(s, "=")
+		name, err := url.PathUnescape(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, local, found := strings.Cut(name, ":"); found {
+			name = local
+		}
+		seg := restSegment{name: name}
+		if hasKeys {
+			for _, k := range strings.Split(keys, ",") {
+				v, err := url.PathUnescape(k)
+				if err != nil {
+					return nil, err
+				}
+				seg.keys = append(seg.keys, v)
+			}
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// resolve returns the JSON value at the requested path and its YANG module
+func (n *restNode) resolve(segments []restSegment) (any, string, error) {
+	node := n
+	selected := false // by the keys of a list without rows of its own
+	for i, seg := range segments {
+		c, ok := node.children[seg.name]
+		if !ok {
+			return nil, "", fmt.Errorf("no resource %q", seg.name)
+		}
+		node = c
+		last := i == len(segments)-1
+		if seg.keys == nil {
+			// The single row selected above is read like an entry
+			if selected && !last && node.list() && len(node.rows) == 1 {
+				v, err := restDescend(restEntry(node.rows[0]), segments[i+1:])
+				return v, node.module, err
+			}
+			continue
+		}
+		if !node.list() {
+			// The keys of a list without rows of its own, such as
+			// PhysIf-list, select the rows below it
+			if node = node.filter(seg.keys); node == nil {
+				return nil, "", fmt.Errorf("no %s entry %s", seg.name, strings.Join(seg.keys, ","))
+			}
+			if last {
+				return []any{node.value()}, node.module, nil
+			}
+			selected = true
+			continue
+		}
+		var entries []any
+		for _, row := range node.rows {
+			if restKeysMatch(row, seg.keys) {
+				entries = append(entries, restEntry(row))
+			}
+		}
+		if len(entries) == 0 {
+			return nil, "", fmt.Errorf("no %s entry %s", seg.name, strings.Join(seg.keys, ","))
+		}
+		if last {
+			return entries, node.module, nil
+		}
+		v, err := restDescend(entries[0], segments[i+1:])
+		return v, node.module, err
+	}
+	return node.value(), node.module, nil
+}
+
+// restDescend returns the container or leaf of an entry at segments
+func restDescend(v any, segments []restSegment) (any, error) {
+	for _, s := range segments {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("no resource %q", s.name)
+		}
+		if v, ok = obj[s.name]; !ok {
+			return nil, fmt.Errorf("no resource %q", s.name)
+		}
+	}
+	return v, nil
+}
+
+// ServeHTTP answers GET requests below /restconf/data, and the root
+// discovery of RFC 8040
+func (r *RESTCONF) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		restError(w, http.StatusMethodNotAllowed, "operation-not-supported", "the endpoint is read-only")
+		return
+	}
+	if req.URL.Path == "/.well-known/host-meta" {
+		w.Header().Set("Content-Type", "application/xrd+xml")
+		fmt.Fprint(w, "<XRD xmlns='http://docs.oasis-open.org/ns/xri/xrd-1.0'>\n  <Link rel='restconf' href='/restconf'/>\n</XRD>\n")
+		return
+	}
+	path, ok := strings.CutPrefix(req.URL.EscapedPath(), "/restconf/data")
+	if !ok || (path != "" && path[0] != '/') {
+		restError(w, http.StatusNotFound, "invalid-value", "resources are below /restconf/data")
+		return
+	}
+	segments, err := parseRESTPath(path)
+	if err != nil {
+		restError(w, http.StatusBadRequest, "malformed-message", err.Error())
+		return
+	}
+
+	root := r.tree()
+	var body map[string]any
+	if len(segments) == 0 {
+		// The data root holds every top-level container
+		body = make(map[string]any)
+		for name, c := range root.children {
+			body[restName(c.module, name)] = c.value()
+		}
+	} else {
+		v, module, err := root.resolve(segments)
+		if err != nil {
+			restError(w, http.StatusNotFound, "invalid-value", err.Error())
+			return
+		}
+		body = map[string]any{restName(module, segments[len(segments)-1].name): v}
+	}
+	data, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		restError(w, http.StatusInternalServerError, "operation-failed", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/yang-data+json")
+	w.Write(append(data, '\n'))
+}
+
+// restName qualifies a top-level member with its YANG module
+func restName(module, name string) string {
+	if module == "" {
+		return name
+	}
+	return module + ":" + name
+}
+
+// restError writes an error in the RESTCONF errors format
+func restError(w http.ResponseWriter, code int, tag, message string) {
+	w.Header().Set("Content-Type", "application/yang-data+json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"ietf-restconf:errors": map[string]any{
+			"error": []any{map[string]any{
+				"error-type":    "application",
+				"error-tag":     tag,
+				"error-message": message,
+			}},
+		},
+	})
+}
+
+// restAuth requires the configured credentials, when set
+func restAuth(cfg RESTCONFConfig, next http.Handler) http.Handler {
+	if cfg.Password == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		if !ok || (cfg.Username != "" && user != cfg.Username) ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(cfg.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="restconf"`)
+			restError(w, http.StatusUnauthorized, "access-denied", "authentication required")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// runRESTCONF serves the endpoint of a node until ctx is done. A node that
+// cannot listen logs why and streams on without it.
+func runRESTCONF(ctx context.Context, sim *Simulator, r *RESTCONF) {
+	cfg := sim.cfg.RESTCONF
+	lis, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Printf("%s: restconf: %v", sim.nodeID, err)
+		return
+	}
+	server := &http.Server{Handler: restAuth(cfg, r), ReadHeaderTimeout: 10 * time.Second}
+	context.AfterFunc(ctx, func() { server.Close() })
+
+	scheme := "http"
+	if cfg.CertFile != "" {
+		scheme = "https"
+	}
+	log.Printf("%s: restconf listening on %s://%s/restconf", sim.nodeID, scheme, lis.Addr())
+	if cfg.CertFile != "" {
+		err = server.ServeTLS(lis, cfg.CertFile, cfg.KeyFile)
+	} else {
+		err = server.Serve(lis)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("%s: restconf: %v", sim.nodeID, err)
+	}
+}
+
+func checkRESTCONF(cfg RESTCONFConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	return nil
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a timeline of scripted events loaded from a YAML file
+type Scenario struct {
+	Name   string          `yaml:"name"`
+	Events []ScenarioEvent `yaml:"events"`
+	// Script is Starlark source run alongside the events, only with --allow-scripts
+	Script string `yaml:"script,omitempty"`
+
+	program *starlark.Program
+}
+
+// ScenarioEvent is a single scripted action at an offset from the start of the run.
+// Events with a duration are reverted automatically when it elapses.
+type ScenarioEvent struct {
+	At       time.Duration     `yaml:"at"`
+	Action   string            `yaml:"action"`
+	Target   string            `yaml:"target,omitempty"`
+	Duration time.Duration     `yaml:"duration,omitempty"`
+	Params   map[string]string `yaml:"params,omitempty"`
+
+	// If fires the event at At only when the condition holds, and schedules
+	// the Else branch instead when it does not
+	If string `yaml:"if,omitempty"`
+	// When holds the event from At until the condition holds. Within bounds
+	// the wait, after which the Else branch is scheduled.
+	When   string        `yaml:"when,omitempty"`
+	Within time.Duration `yaml:"within,omitempty"`
+	// Then and Else are branches of events timed relative to the moment the
+	// event fires or its condition fails
+	Then []ScenarioEvent `yaml:"then,omitempty"`
+	Else []ScenarioEvent `yaml:"else,omitempty"`
+
+	// Assert checks a condition when the event fires, after its action
+	// starts. A failed assertion makes the run exit non-zero.
+	Assert string `yaml:"assert,omitempty"`
+}
+
+// Param returns an action-specific parameter, or def when it is not set
+func (ev ScenarioEvent) Param(key, def string) string {
+	if v, ok := ev.Params[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// scenarioAction applies and reverts one kind of scripted event
+type scenarioAction struct {
+	check func(s *Simulator, ev ScenarioEvent) error
+	start func(s *Simulator, ev ScenarioEvent, now time.Time) error
+	end   func(s *Simulator, ev ScenarioEvent, now time.Time) error
+}
+
+// scenarioActions maps action names used in scenario files to their implementation
+var scenarioActions = map[string]scenarioAction{
+	"spine_maintenance": {
+		check: checkNeighborTarget,
+		start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			return s.StartSpineMaintenance(ev.Target, now)
+		},
+		end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			return s.EndSpineMaintenance(ev.Target, now)
+		},
+	},
+	"bgp_flap": {
+		check: checkNeighborTarget,
+		start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			return s.FlapNeighbor(ev.Target, now)
+		},
+		// The session recovers on its own
+		end: func(s *Simulator, ev ScenarioEvent, now time.Time) error { return nil },
+	},
+	"mac_flap": {
+		check: checkVNITarget,
+		start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			vni, err := parseVNITarget(ev.Target)
+			if err != nil {
+				return err
+			}
+			vteps := strings.Split(ev.Param("vteps", "10.200.0.11,10.200.0.12"), ",")
+			return s.StartMACFlap(vni, ev.Param("mac", "0050.56a0.0001"), vteps)
+		},
+		end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			vni, err := parseVNITarget(ev.Target)
+			if err != nil {
+				return err
+			}
+			s.StopMACFlap(vni, ev.Param("mac", "0050.56a0.0001"))
+			return nil
+		},
+	},
+	"arp_suppression_off": {
+		check: checkVNITarget,
+		start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			vni, err := parseVNITarget(ev.Target)
+			if err != nil {
+				return err
+			}
+			return s.SetARPSuppression(vni, false)
+		},
+		end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			vni, err := parseVNITarget(ev.Target)
+			if err != nil {
+				return err
+			}
+			return s.SetARPSuppression(vni, true)
+		},
+	},
+	"broadcast_storm": {
+		check: checkInterfaceTarget,
+		start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			pps, err := strconv.ParseUint(ev.Param("pps", "2000000"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid pps: %w", err)
+			}
+			return s.StartBroadcastStorm(ev.Target, pps)
+		},
+		end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			return s.StopBroadcastStorm(ev.Target)
+		},
+	},
+	"sensor_error":          sensorErrorAction,
+	"isp_flap":              ispFlapAction,
+	"itd_node_failure":      itdNodeFailureAction,
+	"pbr_next_hop_down":     pbrNextHopDownAction,
+	"tunnel_keepalive_loss": tunnelKeepaliveLossAction,
+	"srv6_locator_down":     srv6LocatorDownAction,
+	"ecmp_skew":             ecmpSkewAction,
+	"asic_error":            asicErrorAction,
+	"fex_offline":           fexOfflineAction,
+	"vni_down":              vniDownAction,
+	"traffic_ramp":          trafficRampAction,
+	"config_change":         configChangeAction,
+	"aaa_server_outage":     aaaServerOutageAction,
+	"ssh_brute_force":       sshBruteForceAction,
+	"software_upgrade": {
+		check: checkUpgradeTarget,
+		start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			s.StartSoftwareUpgrade(ev.Target, now)
+			return nil
+		},
+		end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+			s.EndSoftwareUpgrade(now)
+			return nil
+		},
+	},
+}
+
+// checkNeighborTarget ensures an event targets a configured BGP neighbor
+func checkNeighborTarget(s *Simulator, ev ScenarioEvent) error {
+	if s.FindNeighbor(ev.Target) == nil {
+		return fmt.Errorf("unknown BGP neighbor %q", ev.Target)
+	}
+	return nil
+}
+
+// checkVNITarget ensures an event targets a configured VNI
+func checkVNITarget(s *Simulator, ev ScenarioEvent) error {
+	vni, err := parseVNITarget(ev.Target)
+	if err != nil {
+		return err
+	}
+	if s.FindVNI(vni) == nil {
+		return fmt.Errorf("unknown VNI %d", vni)
+	}
+	return nil
+}
+
+// checkInterfaceTarget ensures an event targets a configured interface
+func checkInterfaceTarget(s *Simulator, ev ScenarioEvent) error {
+	if s.FindInterface(ev.Target) == nil {
+		return fmt.Errorf("unknown interface %q", ev.Target)
+	}
+	return nil
+}
+
+// LoadScenario loads a scenario timeline from a YAML file
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+	return ParseScenario(data)
+}
+
+// ParseScenario parses and validates a scenario timeline in YAML
+func ParseScenario(data []byte) (*Scenario, error) {
+	scenario := &Scenario{}
+	if err := yaml.Unmarshal(data, scenario); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario YAML: %w", err)
+	}
+
+	if err := validateScenario(scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	if scenario.Script != "" {
+		program, err := compileScript(scenario.Name, scenario.Script)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scenario script: %w", err)
+		}
+		scenario.program = program
+	}
+
+	return scenario, nil
+}
+
+// validateScenario ensures every event uses a known action and sane timing
+func validateScenario(scenario *Scenario) error {
+	return validateEvents(scenario.Events)
+}
+
+// validateEvents validates events and their branches
+func validateEvents(events []ScenarioEvent) error {
+	for i, ev := range events {
+		if err := validateEvent(ev); err != nil {
+			return fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validateEvent validates a single event and its branches
+func validateEvent(ev ScenarioEvent) error {
+	if ev.Action == "" && ev.Assert == "" && len(ev.Then) == 0 && len(ev.Else) == 0 {
+		return fmt.Errorf("needs an action, an assertion or a branch")
+	}
+	if _, ok := scenarioActions[ev.Action]; !ok && ev.Action != "" {
+		return fmt.Errorf("unknown action %q", ev.Action)
+	}
+	if ev.At < 0 || ev.Duration < 0 || ev.Within < 0 {
+		return fmt.Errorf("at, duration and within must be non-negative")
+	}
+	if ev.If != "" && ev.When != "" {
+		return fmt.Errorf("if and when are mutually exclusive")
+	}
+	if ev.Within > 0 && ev.When == "" {
+		return fmt.Errorf("within needs a when condition")
+	}
+	if len(ev.Else) > 0 && ev.If == "" && ev.Within == 0 {
+		return fmt.Errorf("else needs an if condition, or a when condition with within")
+	}
+	for _, text := range []string{ev.If, ev.When, ev.Assert} {
+		if text == "" {
+			continue
+		}
+		if _, err := parseCondition(text); err != nil {
+			return fmt.Errorf("condition %q: %w", text, err)
+		}
+	}
+	if err := validateEvents(ev.Then); err != nil {
+		return fmt.Errorf("then %w", err)
+	}
+	if err := validateEvents(ev.Else); err != nil {
+		return fmt.Errorf("else %w", err)
+	}
+	return nil
+}
+
+// scheduledStep is a start or end of a scenario event at an absolute offset
+type scheduledStep struct {
+	at    time.Duration
+	event ScenarioEvent
+	end   bool
+	// planned is set on the start of an unconditional event, whose end and
+	// then branch are scheduled along with it
+	planned bool
+}
+
+// waitingStep is the start of an event waiting for its when condition
+type waitingStep struct {
+	step     scheduledStep
+	deadline time.Duration // 0 waits until the end of the run
+}
+
+// ScenarioEngine fires scenario events as the simulation clock advances
+type ScenarioEngine struct {
+	name    string
+	start   time.Time
+	steps   []scheduledStep
+	next    int
+	waiting []waitingStep
+	script  *scenarioScript
+
+	source   *Scenario         // the scenario the engine runs
+	recorder *ScenarioRecorder // of injected events, nil unless recording
+
+	asserted int      // assertions evaluated so far
+	failures []string // assertions that failed, with the values seen
+}
+
+// NewScenarioEngine schedules every event of the scenario relative to start
+func NewScenarioEngine(scenario *Scenario, start time.Time) *ScenarioEngine {
+	var steps []scheduledStep
+	for _, ev := range scenario.Events {
+		steps = append(steps, plan(ev, ev.At)...)
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].at < steps[j].at })
+
+	e := &ScenarioEngine{name: scenario.Name, start: start, steps: steps, source: scenario}
+	if scenario.program != nil {
+		e.script = &scenarioScript{name: scenario.Name, program: scenario.program}
+	}
+	return e
+}
+
+// plan returns the steps of an event starting at an absolute offset. The
+// end and then branch of a conditional event are only known once it fires.
+func plan(ev ScenarioEvent, at time.Duration) []scheduledStep {
+	steps := []scheduledStep{{at: at, event: ev}}
+	if ev.If != "" || ev.When != "" {
+		return steps
+	}
+	steps[0].planned = true
+	return append(steps, follow(ev, at)...)
+}
+
+// follow returns the end and then branch of an event fired at an absolute offset
+func follow(ev ScenarioEvent, at time.Duration) []scheduledStep {
+	var steps []scheduledStep
+	if ev.Duration > 0 && ev.Action != "" {
+		steps = append(steps, scheduledStep{at: at + ev.Duration, event: ev, end: true})
+	}
+	return append(steps, branch(ev.Then, at)...)
+}
+
+// branch returns the steps of the events of a branch taken at an absolute offset
+func branch(events []ScenarioEvent, at time.Duration) []scheduledStep {
+	var steps []scheduledStep
+	for _, ev := range events {
+		steps = append(steps, plan(ev, at+ev.At)...)
+	}
+	return steps
+}
+
+// CheckTargets ensures every event refers to objects that exist in the simulation
+func (e *ScenarioEngine) CheckTargets(sim *Simulator) error {
+	for _, step := range e.steps {
+		if err := checkEventTargets(sim, step.event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEventTargets ensures an event, its conditions and its branches refer
+// to objects that exist in the simulation
+func checkEventTargets(sim *Simulator, ev ScenarioEvent) error {
+	if ev.Action != "" {
+		if err := scenarioActions[ev.Action].check(sim, ev); err != nil {
+			return fmt.Errorf("%s at t=%s: %w", ev.Action, ev.At, err)
+		}
+	}
+	for _, text := range []string{ev.If, ev.When, ev.Assert} {
+		if text == "" {
+			continue
+		}
+		c, err := parseCondition(text)
+		if err == nil {
+			err = c.check(sim)
+		}
+		if err != nil {
+			return fmt.Errorf("condition %q at t=%s: %w", text, ev.At, err)
+		}
+	}
+	for _, child := range append(ev.Then, ev.Else...) {
+		if err := checkEventTargets(sim, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Advance applies every step that has come due by now, starting with
+// waiting events whose condition holds, then runs the scenario script
+func (e *ScenarioEngine) Advance(sim *Simulator, now time.Time) {
+	elapsed := now.Sub(e.start)
+
+	waiting := e.waiting[:0]
+	for _, w := range e.waiting {
+		ev := w.step.event
+		switch {
+		case conditionHolds(sim, ev.When):
+			e.condition(sim, ev, "when", true, elapsed, now)
+			e.schedule(follow(ev, elapsed))
+			e.apply(sim, scheduledStep{at: elapsed, event: ev}, now)
+		case w.deadline > 0 && elapsed >= w.deadline:
+			e.condition(sim, ev, "when", false, elapsed, now)
+			e.schedule(branch(ev.Else, elapsed))
+		default:
+			waiting = append(waiting, w)
+		}
+	}
+	e.waiting = waiting
+
+	for e.next < len(e.steps) && e.steps[e.next].at <= elapsed {
+		step := e.steps[e.next]
+		e.next++
+		if !step.end && !step.planned && !e.fire(sim, step, now) {
+			continue
+		}
+		e.apply(sim, step, now)
+	}
+
+	if e.script != nil {
+		e.script.run(sim, e, now)
+	}
+}
+
+// fire evaluates the condition of a conditional event that has come due and
+// schedules what follows from it. It reports whether the event starts now.
+func (e *ScenarioEngine) fire(sim *Simulator, step scheduledStep, now time.Time) bool {
+	ev := step.event
+	if ev.If != "" {
+		holds := conditionHolds(sim, ev.If)
+		e.condition(sim, ev, "if", holds, step.at, now)
+		if !holds {
+			e.schedule(branch(ev.Else, step.at))
+			return false
+		}
+	}
+	if ev.When != "" && !conditionHolds(sim, ev.When) {
+		w := waitingStep{step: step}
+		if ev.Within > 0 {
+			w.deadline = step.at + ev.Within
+		}
+		e.waiting = append(e.waiting, w)
+		log.Printf("Scenario %q: waiting for %s at t=%s", e.name, ev.When, step.at)
+		return false
+	}
+	e.schedule(follow(ev, step.at))
+	return true
+}
+
+// condition logs and publishes the outcome of a condition
+func (e *ScenarioEngine) condition(sim *Simulator, ev ScenarioEvent, kind string, holds bool, at time.Duration, now time.Time) {
+	text := ev.If
+	if kind == "when" {
+		text = ev.When
+	}
+	log.Printf("Scenario %q: %s %s is %t at t=%s", e.name, kind, text, holds, at)
+	sim.Events.Publish(SimEvent{Time: now, Type: "scenario_condition", Target: ev.Target,
+		Detail: fmt.Sprintf("%s %s: %t", kind, text, holds)})
+}
+
+// apply starts or ends the action of a step and checks the assertion of a
+// start. Branch-only events have no action.
+func (e *ScenarioEngine) apply(sim *Simulator, step scheduledStep, now time.Time) {
+	if !step.end && step.event.Assert != "" {
+		defer e.assert(sim, step.event, step.at, now)
+	}
+	if step.event.Action == "" {
+		return
+	}
+	action := scenarioActions[step.event.Action]
+	apply, phase := action.start, "start"
+	if step.end {
+		apply, phase = action.end, "end"
+	}
+
+	log.Printf("Scenario %q: %s %s %s at t=%s", e.name, phase, step.event.Action, step.event.Target, step.at)
+	sim.Events.Publish(SimEvent{Time: now, Type: "scenario_" + phase, Target: step.event.Target, Detail: step.event.Action})
+	if err := apply(sim, step.event, now); err != nil {
+		log.Printf("Scenario %q: %s failed: %v", e.name, step.event.Action, err)
+	}
+}
+
+// assert evaluates the assertion of an event that fired, logging and
+// publishing its outcome
+func (e *ScenarioEngine) assert(sim *Simulator, ev ScenarioEvent, at time.Duration, now time.Time) {
+	c, err := parseCondition(ev.Assert)
+	if err != nil {
+		return
+	}
+	e.asserted++
+	outcome := "passed"
+	if !c.holds(sim) {
+		outcome = "FAILED"
+		e.failures = append(e.failures, fmt.Sprintf("t=%s: %s (%s)", at, ev.Assert, c.values(sim)))
+	}
+	log.Printf("Scenario %q: assert %s %s at t=%s (%s)", e.name, ev.Assert, outcome, at, c.values(sim))
+	sim.Events.Publish(SimEvent{Time: now, Type: "scenario_assert", Target: ev.Target,
+		Detail: fmt.Sprintf("%s: %s", ev.Assert, outcome)})
+}
+
+// Assertions returns how many assertions were evaluated and the failed ones
+func (e *ScenarioEngine) Assertions() (int, []string) {
+	return e.asserted, e.failures
+}
+
+// assertionFailures logs the failed assertions of the scenarios of nodes
+// and returns an error when there are any
+func assertionFailures(sims []*Simulator, scenarios []*ScenarioEngine) error {
+	failed := 0
+	for i, sim := range sims {
+		sim.Lock()
+		_, failures := scenarios[i].Assertions()
+		sim.Unlock()
+		for _, f := range failures {
+			log.Printf("%s: scenario assertion failed at %s", sim.nodeID, f)
+		}
+		failed += len(failures)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d scenario assertions failed", failed)
+	}
+	return nil
+}
+
+// schedule inserts steps that become known while the scenario runs, after
+// the steps already due at the same offset
+func (e *ScenarioEngine) schedule(steps []scheduledStep) {
+	for _, step := range steps {
+		i := e.next
+		for i < len(e.steps) && e.steps[i].at <= step.at {
+			i++
+		}
+		e.steps = slices.Insert(e.steps, i, step)
+	}
+}
+
+// conditionHolds evaluates a condition validated with the scenario
+func conditionHolds(sim *Simulator, text string) bool {
+	c, err := parseCondition(text)
+	return err == nil && c.holds(sim)
+}
+
+// Inject applies an event immediately, outside of the scripted timeline. An
+// event with a duration is reverted when it elapses, like a scripted one.
+// While the engine records, the event is added to the recorded scenario.
+func (e *ScenarioEngine) Inject(sim *Simulator, ev ScenarioEvent, now time.Time) error {
+	if err := e.inject(sim, ev, now); err != nil {
+		return err
+	}
+	if e.recorder != nil {
+		ev.At = now.Sub(e.start).Round(time.Millisecond)
+		if err := e.recorder.Record(ev); err != nil {
+			log.Printf("Scenario %q: failed to record %s %s: %v", e.name, ev.Action, ev.Target, err)
+		}
+	}
+	return nil
+}
+
+// inject applies an event immediately without recording it, for events the
+// scenario's own script injects
+func (e *ScenarioEngine) inject(sim *Simulator, ev ScenarioEvent, now time.Time) error {
+	action, ok := scenarioActions[ev.Action]
+	if !ok {
+		return fmt.Errorf("unknown action %q", ev.Action)
+	}
+	if ev.Duration < 0 {
+		return fmt.Errorf("duration must be non-negative")
+	}
+	if err := action.check(sim, ev); err != nil {
+		return err
+	}
+
+	ev.At = now.Sub(e.start)
+	log.Printf("Scenario %q: inject %s %s at t=%s", e.name, ev.Action, ev.Target, ev.At)
+	sim.Events.Publish(SimEvent{Time: now, Type: "scenario_start", Target: ev.Target, Detail: ev.Action})
+	if err := action.start(sim, ev, now); err != nil {
+		return err
+	}
+
+	if ev.Duration > 0 {
+		e.schedule([]scheduledStep{{at: ev.At + ev.Duration, event: ev, end: true}})
+	}
+	return nil
+}
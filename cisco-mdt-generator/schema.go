@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// encodings lists the telemetry encodings the simulator can emit
+var encodings = []string{encodingGPBKV, encodingCompact, encodingJSON}
+
+// Schema listing and fingerprint are computed once on first use
+var (
+	schemaOnce        sync.Once
+	schemaCache       []string
+	schemaFingerprint string
+)
+
+// schemaLines describes every sensor path the simulator can emit as sorted
+// "subscription path section/field:type" lines
+func schemaLines() []string {
+	schemaOnce.Do(func() {
+		schemaCache = buildSchemaLines()
+		sum := sha256.Sum256([]byte(strings.Join(schemaCache, "\n")))
+		schemaFingerprint = hex.EncodeToString(sum[:8])
+	})
+	return schemaCache
+}
+
+// fingerprint is a short hash of the supported paths, fields and encodings.
+// It changes whenever the emitted schema changes, so test artifacts can
+// record exactly which schema generated them.
+func fingerprint() string {
+	schemaLines()
+	return schemaFingerprint
+}
+
+// buildSchemaLines lists the schema of a default simulator
+func buildSchemaLines() []string {
+	// A default simulator with every optional path populated
+	syslog, _ := NewSyslog(SyslogConfig{}, "schema")
+	start := time.Unix(0, 0)
+	cfg := DefaultConfig()
+	cfg.Border.Enabled = true
+	cfg.Border.Peers = []ExternalPeerConfig{{Address: "192.0.2.1", RemoteAS: 64496}}
+	cfg.Border.Leaks = []VRFLeakConfig{{From: "default", To: "tenant"}}
+	cfg.ITD.Enabled = true
+	cfg.ITD.Services = []ITDServiceConfig{{Name: "schema", VIP: "192.0.2.100", Nodes: []string{"192.0.2.11", "192.0.2.12"}}}
+	cfg.Tunnels = []TunnelConfig{{Name: "Tunnel1", Source: "192.0.2.31", Destination: "192.0.2.32"}}
+	cfg.VLANs = []VLANConfig{{ID: 100, VNI: cfg.VNIStates[0].VNIID, Interfaces: []string{cfg.Interfaces[0].Name}, SVI: true}}
+	cfg.ASIC.Enabled = true
+	cfg.Management.Enabled = true
+	cfg.AAA.Enabled = true
+	cfg.AAA.Servers = []AAAServerConfig{{Address: "192.0.2.41"}}
+	cfg.FEX.Enabled = true
+	cfg.FEX.Units = []FEXUnitConfig{{ID: 101, Uplinks: []string{"eth1/47"}, HostPorts: 1}}
+	cfg.ECMP.Enabled = true
+	cfg.ECMP.Groups = []ECMPGroupConfig{{Name: "schema", NextHops: []ECMPNextHopConfig{{Address: "192.0.2.31"}, {Address: "192.0.2.32"}}}}
+	cfg.SRv6.Enabled = true
+	cfg.SRv6.Locators = []SRv6LocatorConfig{{Name: "schema", Prefix: "fcbb:bb00:1::/48", Adjacencies: 1, VRFs: []string{"schema"}}}
+	cfg.PBR.Enabled = true
+	cfg.PBR.Policies = []PBRPolicyConfig{{Name: "schema", Interface: "vlan100", Entries: []PBREntryConfig{{Seq: 10, NextHops: []string{"192.0.2.21"}}}}}
+	sim := NewSimulator(cfg, "schema", 0, syslog, start)
+	sim.MACMobility = append(sim.MACMobility, &MACMobilityEntry{
+		VNIID: sim.VNIs[0].VNIID,
+		MAC:   "0000.0000.0001",
+		VTEPs: []string{"192.0.2.1", "192.0.2.2"},
+	})
+	sim.Mgmt.Attackers = []*MgmtAttacker{{Source: "192.0.2.51", User: "admin"}}
+	sim.ConfigChanges = []*ConfigChange{{ID: 1, User: "admin", Terminal: "pts/0", Commands: []string{"hostname schema"}}}
+
+	// Inventory is added explicitly: it carries the fingerprint computed here
+	sim.lastInventory = start
+	messages := sim.BuildTelemetry(start)
+	messages = append(messages,
+		NewBackpressure(BackpressureConfig{}, nil).BuildTelemetry(0, "schema", time.Second),
+		buildInventoryTelemetry(0, "schema", baseSoftwareVersion, ""))
+
+	seen := make(map[string]bool)
+	var lines []string
+	for _, m := range messages {
+		prefix := m.SubscriptionIDStr + " " + m.EncodingPath + " "
+		for _, row := range m.DataGpbkv {
+			for _, section := range row.Fields {
+				for _, f := range section.Fields {
+					line := prefix + section.Name + "/" + f.Name + ":" + fieldType(f)
+					if !seen[line] {
+						seen[line] = true
+						lines = append(lines, line)
+					}
+				}
+			}
+		}
+	}
+	for _, enc := range encodings {
+		lines = append(lines, "encoding "+enc)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// sensorPaths returns the distinct encoding paths the simulator can emit
+func sensorPaths() []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, line := range schemaLines() {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && !seen[fields[1]] {
+			seen[fields[1]] = true
+			paths = append(paths, fields[1])
+		}
+	}
+	return paths
+}
+
+// sensorSubscriptions returns the distinct subscriptions the simulator can
+// emit
+func sensorSubscriptions() []string {
+	seen := make(map[string]bool)
+	var subs []string
+	for _, line := range schemaLines() {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && !seen[fields[0]] {
+			seen[fields[0]] = true
+			subs = append(subs, fields[0])
+		}
+	}
+	return subs
+}
+
+// fieldType names the value type of a telemetry field
+func fieldType(f *telemetry.TelemetryField) string {
+	switch {
+	case f.StringValue != nil:
+		return "string"
+	case f.Uint32Value != nil:
+		return "uint32"
+	case f.Uint64Value != nil:
+		return "uint64"
+	case f.BoolValue != nil:
+		return "bool"
+	case f.DoubleValue != nil:
+		return "double"
+	case f.FloatValue != nil:
+		return "float"
+	case f.Sint32Value != nil:
+		return "sint32"
+	case f.Sint64Value != nil:
+		return "sint64"
+	case f.BytesValue != nil:
+		return "bytes"
+	case len(f.Fields) > 0:
+		return "container"
+	}
+	return "empty"
+}
+
+// printSchema writes the schema listing behind the fingerprint
+func printSchema(w io.Writer) {
+	for _, line := range schemaLines() {
+		fmt.Fprintln(w, line)
+	}
+}
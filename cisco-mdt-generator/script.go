@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// scriptMaxSteps bounds the Starlark computation of one hook call, so a
+// runaway loop cannot stall the simulation
+const scriptMaxSteps = 1_000_000
+
+// Keys of the thread-local values the sim module reads
+const (
+	scriptSimKey    = "sim"
+	scriptEngineKey = "engine"
+	scriptNowKey    = "now"
+)
+
+// compileScript compiles the Starlark script of a scenario. Scripts have no
+// access to files, the network or load(); all they can reach is the sim module.
+func compileScript(name, src string) (*starlark.Program, error) {
+	_, program, err := starlark.SourceProgramOptions(&syntax.FileOptions{}, name+".star", src,
+		func(name string) bool { return name == "sim" })
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// scenarioScript runs the script of a scenario alongside its timeline. The
+// script's tick function is called every interval with a dict that keeps its
+// state between calls.
+type scenarioScript struct {
+	name    string
+	program *starlark.Program
+	tick    starlark.Callable
+	state   *starlark.Dict
+	started bool
+	stopped bool
+}
+
+// run executes the script once, then calls its tick function. A script that
+// fails is stopped for the rest of the run.
+func (sc *scenarioScript) run(sim *Simulator, e *ScenarioEngine, now time.Time) {
+	if sc.stopped {
+		return
+	}
+	thread := sc.thread(sim, e, now)
+
+	if !sc.started {
+		sc.started = true
+		globals, err := sc.program.Init(thread, starlark.StringDict{"sim": scriptModule})
+		if err != nil {
+			sc.fail(err)
+			return
+		}
+		tick, ok := globals["tick"].(starlark.Callable)
+		if !ok {
+			log.Printf("Scenario %q: script defines no tick function", sc.name)
+			sc.stopped = true
+			return
+		}
+		sc.tick, sc.state = tick, starlark.NewDict(0)
+		thread = sc.thread(sim, e, now)
+	}
+
+	if _, err := starlark.Call(thread, sc.tick, starlark.Tuple{sc.state}, nil); err != nil {
+		sc.fail(err)
+	}
+}
+
+// thread returns a thread for one call into the script, with a fresh step budget
+func (sc *scenarioScript) thread(sim *Simulator, e *ScenarioEngine, now time.Time) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name:  sc.name,
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("Scenario %q: %s", sc.name, msg) },
+	}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	thread.SetLocal(scriptSimKey, sim)
+	thread.SetLocal(scriptEngineKey, e)
+	thread.SetLocal(scriptNowKey, now)
+	return thread
+}
+
+// fail logs a script error and stops the script
+func (sc *scenarioScript) fail(err error) {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		err = fmt.Errorf("%s", evalErr.Backtrace())
+	}
+	log.Printf("Scenario %q: script stopped: %v", sc.name, err)
+	sc.stopped = true
+}
+
+// scriptModule is the sim module predeclared in scripts
+var scriptModule = &starlarkstruct.Module{
+	Name: "sim",
+	Members: starlark.StringDict{
+		"elapsed":    starlark.NewBuiltin("elapsed", scriptElapsed),
+		"node":       starlark.NewBuiltin("node", scriptNode),
+		"metric":     starlark.NewBuiltin("metric", scriptMetric),
+		"inject":     starlark.NewBuiltin("inject", scriptInject),
+		"neighbors":  starlark.NewBuiltin("neighbors", scriptNeighbors),
+		"vnis":       starlark.NewBuiltin("vnis", scriptVNIs),
+		"interfaces": starlark.NewBuiltin("interfaces", scriptInterfaces),
+	},
+}
+
+// scriptState returns the simulator, scenario engine and time of the call
+func scriptState(thread *starlark.Thread) (*Simulator, *ScenarioEngine, time.Time) {
+	return thread.Local(scriptSimKey).(*Simulator),
+		thread.Local(scriptEngineKey).(*ScenarioEngine),
+		thread.Local(scriptNowKey).(time.Time)
+}
+
+// scriptElapsed implements sim.elapsed(): seconds since the start of the run
+func scriptElapsed(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	_, e, now := scriptState(thread)
+	return starlark.Float(now.Sub(e.start).Seconds()), nil
+}
+
+// scriptNode implements sim.node(): the node-id-str of the simulated leaf
+func scriptNode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	sim, _, _ := scriptState(thread)
+	return starlark.String(sim.nodeID), nil
+}
+
+// scriptMetric implements sim.metric(name, target=""), reading the metrics
+// of scenario conditions
+func scriptMetric(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var target starlark.Value = starlark.String("")
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "target?", &target); err != nil {
+		return nil, err
+	}
+	metric, ok := conditionMetrics[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown metric %q", b.Name(), name)
+	}
+	sim, _, _ := scriptState(thread)
+	v, err := metric.value(sim, scriptString(target))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.Float(v), nil
+}
+
+// scriptInject implements sim.inject(action, target, duration="0s", **params),
+// applying an event like the admin service does
+func scriptInject(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var action, duration string
+	var target starlark.Value
+	ev := ScenarioEvent{Params: make(map[string]string)}
+	var named []starlark.Tuple
+	for _, kv := range kwargs {
+		switch key := string(kv[0].(starlark.String)); key {
+		case "action", "target", "duration":
+			named = append(named, kv)
+		default:
+			ev.Params[key] = scriptString(kv[1])
+		}
+	}
+	if err := starlark.UnpackArgs(b.Name(), args, named, "action", &action, "target", &target, "duration?", &duration); err != nil {
+		return nil, err
+	}
+	ev.Action, ev.Target = action, scriptString(target)
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid duration: %w", b.Name(), err)
+		}
+		ev.Duration = d
+	}
+
+	sim, e, now := scriptState(thread)
+	if err := e.inject(sim, ev, now); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+// scriptNeighbors implements sim.neighbors(): the BGP neighbor addresses
+func scriptNeighbors(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	sim, _, _ := scriptState(thread)
+	var list []starlark.Value
+	for _, n := range sim.BGPNeighbors {
+		list = append(list, starlark.String(n.Address))
+	}
+	return starlark.NewList(list), nil
+}
+
+// scriptVNIs implements sim.vnis(): the VNI IDs
+func scriptVNIs(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	sim, _, _ := scriptState(thread)
+	var list []starlark.Value
+	for _, v := range sim.VNIs {
+		list = append(list, starlark.MakeInt(int(v.VNIID)))
+	}
+	return starlark.NewList(list), nil
+}
+
+// scriptInterfaces implements sim.interfaces(): the interface names
+func scriptInterfaces(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	sim, _, _ := scriptState(thread)
+	var list []starlark.Value
+	for _, i := range sim.Interfaces {
+		list = append(list, starlark.String(i.Name))
+	}
+	return starlark.NewList(list), nil
+}
+
+// scriptString converts a script value to the string form of event targets
+// and parameters, so VNIs may be given as numbers
+func scriptString(v starlark.Value) string {
+	if s, ok := starlark.AsString(v); ok {
+		return s
+	}
+	return v.String()
+}
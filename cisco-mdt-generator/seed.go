@@ -0,0 +1,23 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// seededStart is the virtual start of recordings with a seed, so that the
+// timestamps of two recordings match as well
+var seededStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newRand returns the random source of a node or stream. With a seed it
+// draws the same sequence on every run, mixed with the name so that the
+// nodes of a fleet differ; seed 0 draws a different one every run.
+func newRand(seed int64, name string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+}
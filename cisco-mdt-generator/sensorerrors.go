@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sensorErrorKinds are representative errors a switch reports in the Errors
+// field of MdtDialoutArgs when collecting a subscription fails. {path} is
+// replaced by the encoding path.
+var sensorErrorKinds = map[string]string{
+	"collection_failed": "Sensor path data collection failed: {path}",
+	"invalid_path":      "Invalid sensor path: {path}",
+	"timeout":           "Timed out collecting data for sensor path {path}",
+	"resource":          "Sensor group suspended, memory usage above threshold: {path}",
+	"unsupported":       "Sensor path not supported on this platform: {path}",
+}
+
+// sensorError is an error reported on a subscription while a sensor_error
+// event is active
+type sensorError struct {
+	text string // may contain {path}
+	keep bool   // send the data along with the error
+}
+
+// StartSensorError reports an error on every message of a subscription
+func (s *Simulator) StartSensorError(subscription, text string, keep bool) {
+	if s.sensorErrors == nil {
+		s.sensorErrors = make(map[string]sensorError)
+	}
+	s.sensorErrors[subscription] = sensorError{text: text, keep: keep}
+	s.event("sensor_error", subscription, "subscription %s reports errors: %s", subscription, text)
+}
+
+// StopSensorError clears the error of a subscription
+func (s *Simulator) StopSensorError(subscription string) {
+	delete(s.sensorErrors, subscription)
+	s.event("sensor_error_cleared", subscription, "subscription %s reports no more errors", subscription)
+}
+
+// SensorErrors returns a copy of the active subscription errors, for the
+// dial-out stream to read outside of the simulator lock
+func (s *Simulator) SensorErrors() map[string]sensorError {
+	s.Lock()
+	defer s.Unlock()
+	return maps.Clone(s.sensorErrors)
+}
+
+// sensorErrorText returns the error text of a sensor_error event: the
+// message param, or the text of its kind
+func sensorErrorText(ev ScenarioEvent) (string, error) {
+	if msg := ev.Param("message", ""); msg != "" {
+		return msg, nil
+	}
+	kind := ev.Param("kind", "collection_failed")
+	text, ok := sensorErrorKinds[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown kind %q, expected one of %s", kind, strings.Join(slices.Sorted(maps.Keys(sensorErrorKinds)), ", "))
+	}
+	return text, nil
+}
+
+// checkSensorErrorTarget ensures a sensor_error event targets a streamed
+// subscription and names a known kind of error
+func checkSensorErrorTarget(s *Simulator, ev ScenarioEvent) error {
+	known := sensorSubscriptions()
+	for _, p := range s.cfg.Plugins {
+		known = append(known, p.Subscription)
+	}
+	if !slices.Contains(known, ev.Target) {
+		return fmt.Errorf("unknown subscription %q", ev.Target)
+	}
+	if data := ev.Param("data", "drop"); data != "drop" && data != "keep" {
+		return fmt.Errorf("invalid data %q, expected drop or keep", data)
+	}
+	_, err := sensorErrorText(ev)
+	return err
+}
+
+// sensorErrorAction is the sensor_error scenario action
+var sensorErrorAction = scenarioAction{
+	check: checkSensorErrorTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		text, err := sensorErrorText(ev)
+		if err != nil {
+			return err
+		}
+		s.StartSensorError(ev.Target, text, ev.Param("data", "drop") == "keep")
+		return nil
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		s.StopSensorError(ev.Target)
+		return nil
+	},
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScenarioRecorder writes the events injected into a running simulation to
+// a scenario file, so an exploratory session can be replayed later as a
+// regression test. The file holds the events of the scenario the session
+// started with, followed by the injected events at their offsets from the
+// start of the session, and is rewritten after every injection.
+type ScenarioRecorder struct {
+	path string
+	base *Scenario
+
+	mu       sync.Mutex
+	injected []ScenarioEvent
+}
+
+// RecordTo starts recording the events injected into the engine to a
+// scenario file, which is written right away
+func (e *ScenarioEngine) RecordTo(path string) error {
+	r := &ScenarioRecorder{path: path, base: e.source}
+	if err := r.write(); err != nil {
+		return err
+	}
+	e.recorder = r
+	return nil
+}
+
+// Record adds an injected event, timed by its At offset, and rewrites the file
+func (r *ScenarioRecorder) Record(ev ScenarioEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.injected = append(r.injected, ev)
+	return r.write()
+}
+
+// write replaces the scenario file with the recorded scenario
+func (r *ScenarioRecorder) write() error {
+	name := "session"
+	if r.base.Name != "" && r.base.Name != "admin" {
+		name = r.base.Name
+	}
+	scenario := Scenario{
+		Name:   fmt.Sprintf("%s recorded %s", name, time.Now().Format(time.DateOnly)),
+		Events: append(append([]ScenarioEvent(nil), r.base.Events...), r.injected...),
+		Script: r.base.Script,
+	}
+	data, err := yaml.Marshal(&scenario)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(r.path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed to write recorded scenario: %w", err)
+	}
+	return os.Rename(r.path+".tmp", r.path)
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Simulator holds the simulated state of a single NX-OS leaf and advances
+// it one collection interval at a time
+type Simulator struct {
+	// Mutex guards the state against admin requests while a step runs
+	sync.Mutex
+
+	cfg        *Config
+	nodeID     string
+	flapChance float64
+	rng        *rand.Rand // every random draw of the node, see newRand
+	startTime  time.Time
+	lastStep   time.Time
+
+	// lastInventory is when inventory telemetry was last emitted
+	lastInventory time.Time
+
+	// warmUp is set until the node has ramped up to steady state
+	warmUp *warmUp
+
+	// trafficRamp scales the VXLAN traffic during a traffic_ramp event
+	trafficRamp *trafficRamp
+
+	// sampled is when each subscription with a sample interval was last sent
+	sampled map[string]time.Time
+
+	IngressBytes uint64
+	EgressBytes  uint64
+	BGPNeighbors []*BGPNeighbor
+	EVPN         *EVPNState
+	VNIs         []*VNIState
+	MACMobility  []*MACMobilityEntry
+	Interfaces   []*InterfaceState
+	VLANs        []*VLANState
+	Tunnels      []*TunnelState
+	CoPP         []*CoPPClass
+	CPU          CPUState
+
+	// Border is the external connectivity of a border leaf, nil on other nodes
+	Border *BorderState
+
+	// ITD are the Intelligent Traffic Director services of the node
+	ITD []*ITDService
+
+	// PBR are the policy-based routing route-maps of the node
+	PBR []*PBRPolicy
+
+	// SRv6 are the SRv6 locators of the node
+	SRv6 []*SRv6Locator
+
+	// ECMP are the weighted ECMP groups of the node
+	ECMP []*ECMPGroup
+
+	// ASICs are the forwarding ASICs of the node and their error counters
+	ASICs []*ASICState
+
+	// FEX are the Fabric Extenders attached to the node
+	FEX []*FEXState
+
+	// AAA is the login authentication of the node, nil without AAA servers
+	AAA *AAAState
+
+	// Mgmt is the SSH management plane, nil when not enabled
+	Mgmt *MgmtState
+
+	// ConfigChanges are the configuration changes reported this interval,
+	// committed since the previous step into pendingChanges
+	ConfigChanges  []*ConfigChange
+	pendingChanges []*ConfigChange
+	configVersion  uint64
+
+	// SoftwareVersion is set after a software_upgrade event and switches
+	// drifting paths to their post-upgrade schema
+	SoftwareVersion string
+
+	// sensorErrors are the subscriptions reporting errors on the dial-out
+	// stream during sensor_error events
+	sensorErrors map[string]sensorError
+
+	// Breaker counts encoding failures per subscription and disables those
+	// that keep failing
+	Breaker *EncodeBreaker
+
+	// OnChange withholds the unchanged rows of on-change subscriptions
+	OnChange *OnChange
+
+	Syslog *Syslog
+	Events *EventBus
+
+	// MessagesSent counts the telemetry messages of the collections sent
+	MessagesSent uint64
+
+	// Paused is set while the control API holds the stream: the node is
+	// still simulated, but its collections are not sent
+	Paused bool
+
+	// Stats, when set, collects every emitted series for the realism report
+	Stats *SeriesStats
+
+	// Rates, when set, counts the telemetry sent for the console UI
+	Rates *SendRates
+
+	// Bandwidth, when set, counts the messages and bytes sent per
+	// subscription for the bandwidth report and metrics
+	Bandwidth *Bandwidth
+
+	// Capture, when set, keeps the last messages sent to the collectors
+	Capture *Capture
+
+	// Middleware, when set, is the middleware chain of the run shared by
+	// its nodes, whose bandwidth caps police the outputs as a whole
+	Middleware *Middleware
+
+	// Budget, when set, stretches the interval while the process is over
+	// its resource budget
+	Budget *Budget
+
+	// Marshal, when set, encodes the collections sent to the collector on
+	// the workers shared by the nodes of the process
+	Marshal *MarshalPool
+
+	// State, when set, saves a checkpoint of the node every collection
+	State *StateStore
+
+	// Discovery, when set, picks the collector the node streams to among
+	// those found in DNS
+	Discovery *Discovery
+
+	// Plugins are the node's instances of the configured sensor plugins
+	Plugins []*Plugin
+
+	// Probe, when set, adds a numbered message per interval for the probe
+	// command to measure delivery through the collector pipeline
+	Probe *Probe
+}
+
+// NewSimulator creates a simulator with state initialized from configuration
+func NewSimulator(cfg *Config, nodeID string, flapChance float64, syslog *Syslog, startTime time.Time) *Simulator {
+	rng := newRand(cfg.Simulation.Seed, nodeID)
+	s := &Simulator{
+		cfg:          cfg,
+		nodeID:       nodeID,
+		flapChance:   flapChance,
+		rng:          rng,
+		startTime:    startTime,
+		lastStep:     startTime,
+		IngressBytes: cfg.VXLAN.InitialIngressBytes,
+		EgressBytes:  cfg.VXLAN.InitialEgressBytes,
+		BGPNeighbors: initBGPNeighborsFromConfig(cfg, startTime),
+		EVPN:         initEVPNStateFromConfig(cfg),
+		VNIs:         initVNIStatesFromConfig(cfg),
+		Interfaces:   initInterfacesFromConfig(cfg),
+		Tunnels:      initTunnelsFromConfig(cfg),
+		CoPP:         initCoPPClasses(),
+		Border:       initBorderFromConfig(cfg, startTime, rng),
+		ITD:          initITDFromConfig(cfg, rng),
+		PBR:          initPBRFromConfig(cfg),
+		SRv6:         initSRv6FromConfig(cfg),
+		ECMP:         initECMPFromConfig(cfg),
+		ASICs:        initASICsFromConfig(cfg),
+		FEX:          initFEXFromConfig(cfg),
+		AAA:          initAAAFromConfig(cfg),
+		Mgmt:         initMgmtFromConfig(cfg),
+		Syslog:       syslog,
+		Events:       NewEventBus(),
+		Breaker:      NewEncodeBreaker(cfg.Encode, nodeID),
+		OnChange:     NewOnChange(cfg.OnChange),
+		Capture:      NewCapture(cfg.Dialout.Capture, nodeID),
+	}
+	s.VLANs = s.initVLANsFromConfig(cfg)
+	s.startWarmUp(startTime)
+	return s
+}
+
+// FindNeighbor returns the BGP neighbor with the given address, or nil
+func (s *Simulator) FindNeighbor(address string) *BGPNeighbor {
+	for _, n := range s.BGPNeighbors {
+		if n.Address == address {
+			return n
+		}
+	}
+	return nil
+}
+
+// FindVNI returns the VNI state with the given ID, or nil
+func (s *Simulator) FindVNI(id uint32) *VNIState {
+	for _, v := range s.VNIs {
+		if v.VNIID == id {
+			return v
+		}
+	}
+	return nil
+}
+
+// Step advances the simulated state to the given time
+func (s *Simulator) Step(now time.Time) {
+	counters := s.cfg.Simulation.Counters
+	seconds := now.Sub(s.lastStep).Seconds()
+	s.lastStep = now
+	s.stepConfigChanges()
+
+	// A node warming up carries a growing share of its steady-state traffic
+	ramp := 1.0
+	if s.warmUp != nil {
+		ramp = s.warmUp.ramp(now)
+	}
+
+	// Update VXLAN counters using config ranges, carried by the VNIs that
+	// are up and scaled by a scripted traffic ramp
+	vxlan := ramp * s.vniUpShare() * s.trafficRamp.scale(now)
+	s.IngressBytes += uint64(vxlan * float64(counters.VXLANIngressMin+
+		s.rng.Intn(counters.VXLANIngressMax-counters.VXLANIngressMin)))
+	s.EgressBytes += uint64(vxlan * float64(counters.VXLANEgressMin+
+		s.rng.Intn(counters.VXLANEgressMax-counters.VXLANEgressMin)))
+
+	// Sessions, routes and hosts ramp up before normal fluctuation begins
+	if s.warmUp != nil {
+		s.stepWarmUp(now, ramp)
+	} else {
+		s.stepRouting(now)
+	}
+
+	// ARP suppression and flooding per VNI
+	s.stepARPSuppression()
+
+	// Move flapping MACs between VTEPs
+	s.stepMACMobility(now)
+
+	// Interface counters feed punted traffic into CoPP and CPU load
+	punted := s.stepInterfaces(now, seconds*ramp)
+	s.stepControlPlane(punted, seconds)
+	s.stepVLANs()
+
+	// External sessions and leaked routes of a border leaf
+	s.stepBorder(now, seconds)
+
+	// ITD probes, bucket reassignment and traffic distribution
+	s.stepITD(seconds * ramp)
+	s.stepPBR(seconds * ramp)
+	s.stepTunnels(seconds)
+	s.stepSRv6(seconds * ramp)
+	s.stepECMP(seconds * ramp)
+	s.stepASICs(now, seconds)
+	s.stepFEX(seconds * ramp)
+	s.stepAAA(now, seconds)
+	s.stepMgmt(now, seconds)
+}
+
+// stepRouting fluctuates BGP sessions, EVPN routes and VNI hosts in steady
+// state
+func (s *Simulator) stepRouting(now time.Time) {
+	counters := s.cfg.Simulation.Counters
+
+	// Update BGP neighbor state (simulate occasional flaps)
+	for _, neighbor := range s.BGPNeighbors {
+		if neighbor.Maintenance {
+			s.stepMaintenance(neighbor, now)
+			continue
+		}
+
+		if neighbor.State == "Established" {
+			neighbor.Uptime = uint64(now.Sub(neighbor.LastFlap).Seconds())
+			// Random flap chance
+			if s.rng.Float64() < s.flapChance {
+				s.flap(neighbor, now)
+			} else {
+				// Small fluctuation in prefixes using config
+				neighbor.PrefixesRecv = s.walk("bgp_prefixes_received", neighbor.PrefixesRecv, counters.BGPPrefixFluctuation)
+			}
+		} else {
+			// Recover from flap using config time range
+			recoveryTime := time.Duration(s.cfg.Simulation.FlapRecoveryMin+
+				s.rng.Intn(s.cfg.Simulation.FlapRecoveryMax-s.cfg.Simulation.FlapRecoveryMin)) * time.Second
+
+			if now.Sub(neighbor.LastFlap) > recoveryTime {
+				neighbor.State = "Established"
+				neighbor.StateCode = 6
+				neighbor.PrefixesRecv = uint32(s.bound("bgp_prefixes_received").apply(float64(140 + s.rng.Intn(20))))
+				neighbor.LastFlap = now
+				s.event("bgp_recover", neighbor.Address, "BGP neighbor %s RECOVERED to Established", neighbor.Address)
+			}
+		}
+	}
+
+	// Update EVPN route counts using config fluctuations
+	s.EVPN.Type2Routes = s.walk("evpn_type2_routes", s.EVPN.Type2Routes, counters.EVPNType2Fluctuation)
+	s.EVPN.Type3Routes = s.walk("evpn_type3_routes", s.EVPN.Type3Routes, counters.EVPNType3Fluctuation)
+	s.EVPN.Type5Routes = s.walk("evpn_type5_routes", s.EVPN.Type5Routes, counters.EVPNType5Fluctuation)
+
+	s.EVPN.TotalRoutes = s.EVPN.Type2Routes + s.EVPN.Type3Routes + s.EVPN.Type5Routes
+
+	// Update VNI state using config fluctuations
+	for _, vni := range s.VNIs {
+		if vni.StateCode != 1 {
+			continue
+		}
+		vni.MACCount = s.walk("vni_mac_count", vni.MACCount, counters.VNIMACFluctuation)
+		vni.ARPCount = s.walk("vni_arp_count", vni.ARPCount, counters.VNIARPFluctuation)
+	}
+}
+
+// BuildTelemetry builds all telemetry messages for the current state
+func (s *Simulator) BuildTelemetry(now time.Time) []*telemetry.Telemetry {
+	messages := buildAllTelemetry(now, s.nodeID, s.IngressBytes, s.EgressBytes, s.BGPNeighbors, s.EVPN, s.VNIs, s.cfg)
+	ts := uint64(now.UnixMilli())
+
+	messages = append(messages, buildARPSuppressionTelemetry(ts, s.nodeID, s.VNIs))
+	messages = append(messages, buildInterfaceTelemetry(ts, s.nodeID, s.Interfaces))
+	if len(s.VLANs) > 0 {
+		messages = append(messages, buildVLANTelemetry(ts, s.nodeID, s.VLANs))
+		messages = append(messages, buildSVITelemetry(ts, s.nodeID, s.VLANs))
+	}
+	messages = append(messages, buildCoPPTelemetry(ts, s.nodeID, s.CoPP))
+	messages = append(messages, buildCPUTelemetry(ts, s.nodeID, s.CPU))
+
+	// Inventory changes rarely and is sent at a slow cadence
+	if s.lastInventory.IsZero() || now.Sub(s.lastInventory) >= inventoryInterval {
+		messages = append(messages, buildInventoryTelemetry(ts, s.nodeID, s.Version(), fingerprint()))
+		s.lastInventory = now
+	}
+
+	if s.Border != nil {
+		messages = append(messages, buildExternalBGPTelemetry(ts, s.nodeID, s.Border.Peers))
+		if len(s.Border.Leaks) > 0 {
+			messages = append(messages, buildVRFLeakTelemetry(ts, s.nodeID, s.Border.Leaks))
+		}
+	}
+
+	if len(s.ITD) > 0 {
+		messages = append(messages, buildITDNodeTelemetry(ts, s.nodeID, s.ITD))
+		messages = append(messages, buildITDBucketTelemetry(ts, s.nodeID, s.ITD))
+	}
+
+	if len(s.Tunnels) > 0 {
+		messages = append(messages, buildTunnelTelemetry(ts, s.nodeID, s.Tunnels))
+	}
+	if s.Mgmt != nil {
+		messages = append(messages, buildMgmtSessionTelemetry(ts, s.nodeID, s.Mgmt, s.cfg.Management.MaxSessions))
+		messages = append(messages, buildMgmtACLTelemetry(ts, s.nodeID, s.Mgmt, s.cfg.Management.ACL))
+		if len(s.Mgmt.Attackers) > 0 {
+			messages = append(messages, buildMgmtLoginFailureTelemetry(ts, s.nodeID, s.Mgmt.Attackers))
+		}
+	}
+	if s.AAA != nil {
+		messages = append(messages, buildAAAServerTelemetry(ts, s.nodeID, s.AAA))
+		messages = append(messages, buildAAAAuthTelemetry(ts, s.nodeID, s.AAA))
+	}
+	if len(s.FEX) > 0 {
+		messages = append(messages, buildFEXTelemetry(ts, s.nodeID, s.FEX))
+		messages = append(messages, buildFEXUplinkTelemetry(ts, s.nodeID, s.FEX))
+		messages = append(messages, buildFEXHostPortTelemetry(ts, s.nodeID, s.FEX))
+	}
+	if len(s.ASICs) > 0 {
+		messages = append(messages, buildASICTelemetry(ts, s.nodeID, s.ASICs))
+	}
+	if len(s.ECMP) > 0 {
+		messages = append(messages, buildECMPGroupTelemetry(ts, s.nodeID, s.ECMP))
+		messages = append(messages, buildECMPNextHopTelemetry(ts, s.nodeID, s.ECMP))
+	}
+	if len(s.SRv6) > 0 && s.cfg.Platform == platformIOSXR {
+		messages = append(messages, buildSRv6LocatorTelemetry(ts, s.nodeID, s.SRv6))
+		messages = append(messages, buildSRv6SIDTelemetry(ts, s.nodeID, s.SRv6))
+		messages = append(messages, buildSRv6BehaviorTelemetry(ts, s.nodeID, s.SRv6))
+	}
+	if len(s.PBR) > 0 {
+		messages = append(messages, buildPBRTelemetry(ts, s.nodeID, s.PBR))
+		messages = append(messages, buildPBRNextHopTelemetry(ts, s.nodeID, s.PBR))
+	}
+
+	// Configuration changes are streamed on change only
+	if len(s.ConfigChanges) > 0 {
+		messages = append(messages, buildConfigChangeTelemetry(ts, s.nodeID, s.ConfigChanges))
+	}
+
+	// MAC mobility entries only exist while a MAC is flapping
+	if len(s.MACMobility) > 0 {
+		messages = append(messages, buildMACMobilityTelemetry(ts, s.nodeID, s.MACMobility))
+	}
+
+	for _, p := range s.Plugins {
+		if m := p.Collect(now); m != nil {
+			messages = append(messages, m)
+		}
+	}
+
+	messages = applyPlatform(messages, s.cfg.Platform)
+
+	// A node streams only the subscriptions of its sensor set, each at its
+	// sample interval
+	if sensors := s.cfg.StreamedSensors(); len(sensors) > 0 {
+		messages = slices.DeleteFunc(messages, func(m *telemetry.Telemetry) bool {
+			return !slices.Contains(sensors, m.SubscriptionIDStr)
+		})
+	}
+	messages = s.sample(messages, now)
+	messages = s.OnChange.Filter(messages, now)
+
+	// Statistics describe the traffic model, not drift or injected faults
+	if s.Stats != nil {
+		s.Stats.Observe(messages)
+	}
+	if s.Probe != nil {
+		messages = append(messages, s.Probe.BuildTelemetry(ts, s.nodeID))
+	}
+
+	if s.SoftwareVersion != "" {
+		applySchemaDrift(messages, s.cfg.SchemaDrift)
+	}
+	applyFaults(messages, s.cfg.Faults, s.rng)
+
+	return messages
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Sink receives the telemetry of every simulated node alongside, or instead
+// of, the dial-out stream. Sinks are shared by all nodes of a fleet and must
+// be safe for concurrent use.
+type Sink interface {
+	Name() string
+	Write(messages []*telemetry.Telemetry) error
+	Close() error
+}
+
+// multiSink writes to several sinks, continuing past failures
+type multiSink []Sink
+
+func (m multiSink) Name() string { return "sinks" }
+
+func (m multiSink) Write(messages []*telemetry.Telemetry) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(messages); err != nil {
+			errs = append(errs, fmt.Errorf("%s sink: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s sink: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sinkType is a sink the configuration can enable, by the output name the
+// sink reports and middleware stages apply to
+type sinkType struct {
+	name    string
+	enabled func(cfg SinksConfig) bool
+	open    func(cfg SinksConfig) (Sink, error)
+}
+
+// sinkTypes lists every sink in the order they are written to
+var sinkTypes = []sinkType{
+	{"influx", func(c SinksConfig) bool { return c.Influx.Enabled }, func(c SinksConfig) (Sink, error) { return NewInfluxSink(c.Influx) }},
+	{"otlp", func(c SinksConfig) bool { return c.OTLP.Enabled }, func(c SinksConfig) (Sink, error) { return NewOTLPSink(c.OTLP) }},
+	{"nats", func(c SinksConfig) bool { return c.NATS.Enabled }, func(c SinksConfig) (Sink, error) { return NewNATSSink(c.NATS) }},
+	{"amqp", func(c SinksConfig) bool { return c.AMQP.Enabled }, func(c SinksConfig) (Sink, error) { return NewAMQPSink(c.AMQP) }},
+	{"elasticsearch", func(c SinksConfig) bool { return c.Elasticsearch.Enabled }, func(c SinksConfig) (Sink, error) { return NewElasticsearchSink(c.Elasticsearch) }},
+	{"mqtt", func(c SinksConfig) bool { return c.MQTT.Enabled }, func(c SinksConfig) (Sink, error) { return NewMQTTSink(c.MQTT) }},
+	{"parquet", func(c SinksConfig) bool { return c.Parquet.Enabled }, func(c SinksConfig) (Sink, error) { return NewParquetSink(c.Parquet) }},
+	{"pcap", func(c SinksConfig) bool { return c.Pcap.Enabled }, func(c SinksConfig) (Sink, error) { return NewPcapSink(c.Pcap) }},
+}
+
+// newSinks creates every enabled sink wrapped in the middleware chain. It
+// returns nil when none is enabled.
+func newSinks(cfg SinksConfig, mw *Middleware) (multiSink, error) {
+	var sinks multiSink
+	for _, t := range sinkTypes {
+		if !t.enabled(cfg) {
+			continue
+		}
+		s, err := t.open(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s sink: %w", t.name, err)
+		}
+		sinks = append(sinks, mw.Wrap(s))
+	}
+	return sinks, nil
+}
+
+// openSinks creates the enabled sinks of a run, followed by the extra sinks
+// the run serves itself, each wrapped in the middleware chain of the run.
+// Without a collector address at least one sink must be enabled.
+func openSinks(cfg *Config, mw *Middleware, server string, extra ...Sink) (Sink, error) {
+	sinks, err := newSinks(cfg.Sinks, mw)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range extra {
+		sinks = append(sinks, mw.Wrap(s))
+	}
+	if len(sinks) == 0 {
+		if server == "" {
+			return nil, fmt.Errorf("no collector address and no sink enabled")
+		}
+		return nil, nil
+	}
+	return sinks, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errSoak is the cause of a run stopped by the soak guard
+var errSoak = errors.New("soak guard")
+
+// SoakGuard watches the simulator's own memory and goroutines over a long
+// run and stops it once either grows too far above the level measured after
+// warm-up, so leaks show up as a failed run with a dump instead of a host
+// running out of memory days later.
+type SoakGuard struct {
+	cfg SoakConfig
+
+	baseRSS        uint64
+	baseGoroutines int
+}
+
+// NewSoakGuard returns the guard of the configuration, or nil when it is
+// disabled
+func NewSoakGuard(cfg SoakConfig) *SoakGuard {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &SoakGuard{cfg: cfg}
+}
+
+// Watch takes the baseline once the warm-up has passed and then checks
+// growth every interval until ctx ends. When a threshold is exceeded it
+// writes a dump and calls fail with an error wrapping errSoak.
+func (g *SoakGuard) Watch(ctx context.Context, fail context.CancelCauseFunc) {
+	if g == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(g.cfg.Warmup):
+	}
+	runtime.GC()
+	g.baseRSS, g.baseGoroutines = rssBytes(), runtime.NumGoroutine()
+	log.Printf("Soak guard baseline: RSS %d MiB, %d goroutines", g.baseRSS>>20, g.baseGoroutines)
+
+	ticker := time.NewTicker(g.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.check(); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}
+}
+
+// check compares RSS and goroutines with the baseline, writing a dump and
+// returning an error when either has grown beyond its threshold
+func (g *SoakGuard) check() error {
+	rss, goroutines := rssBytes(), runtime.NumGoroutine()
+	var grown []string
+	if g.cfg.MaxRSSGrowthMB > 0 && rss > g.baseRSS && (rss-g.baseRSS)>>20 > uint64(g.cfg.MaxRSSGrowthMB) {
+		grown = append(grown, fmt.Sprintf("RSS grew from %d to %d MiB", g.baseRSS>>20, rss>>20))
+	}
+	if g.cfg.MaxGoroutineGrowth > 0 && goroutines-g.baseGoroutines > g.cfg.MaxGoroutineGrowth {
+		grown = append(grown, fmt.Sprintf("goroutines grew from %d to %d", g.baseGoroutines, goroutines))
+	}
+	if len(grown) == 0 {
+		return nil
+	}
+	reason := strings.Join(grown, ", ")
+	log.Printf("level=error msg=\"soak guard tripped\" %s", reason)
+	files, err := g.dump()
+	if err != nil {
+		return fmt.Errorf("%w: %s (dump failed: %v)", errSoak, reason, err)
+	}
+	return fmt.Errorf("%w: %s, dumped to %s", errSoak, reason, strings.Join(files, ", "))
+}
+
+// dump writes the stacks of all goroutines and a heap profile to the dump
+// directory, returning the files written
+func (g *SoakGuard) dump() ([]string, error) {
+	dir := g.cfg.DumpDir
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	prefix := filepath.Join(dir, fmt.Sprintf("mdtsim-soak-%d-%s", os.Getpid(), time.Now().Format("20060102-150405")))
+
+	var files []string
+	for _, p := range []struct {
+		profile, suffix string
+		debug           int
+	}{
+		{"goroutine", "-goroutines.txt", 2},
+		{"heap", "-heap.pprof", 0},
+	} {
+		path := prefix + p.suffix
+		f, err := os.Create(path)
+		if err != nil {
+			return files, err
+		}
+		err = pprof.Lookup(p.profile).WriteTo(f, p.debug)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return files, err
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// rssBytes returns the resident set size of the process. Where /proc is not
+// available it falls back to the memory the Go runtime holds from the OS.
+func rssBytes() uint64 {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	return memoryInUse()
+}
+
+// checkSoak ensures the soak guard settings are usable
+func checkSoak(s SoakConfig) error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.Warmup < 0 || s.Interval <= 0 {
+		return fmt.Errorf("warmup must be non-negative and interval positive")
+	}
+	if s.MaxRSSGrowthMB < 0 || s.MaxGoroutineGrowth < 0 {
+		return fmt.Errorf("max_rss_growth_mb and max_goroutine_growth must be non-negative")
+	}
+	if s.MaxRSSGrowthMB == 0 && s.MaxGoroutineGrowth == 0 {
+		return fmt.Errorf("max_rss_growth_mb or max_goroutine_growth must be set")
+	}
+	return nil
+}
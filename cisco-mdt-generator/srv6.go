@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// SRv6 endpoint behaviors of the micro-SID (uSID) flavor: the node SID,
+// adjacency SIDs and per-VRF decapsulation SIDs
+const (
+	srv6BehaviorUN   = "uN"
+	srv6BehaviorUA   = "uA"
+	srv6BehaviorUDT4 = "uDT4"
+	srv6BehaviorUDT6 = "uDT6"
+)
+
+// srv6Behaviors lists the behaviors in report order
+var srv6Behaviors = []string{srv6BehaviorUN, srv6BehaviorUA, srv6BehaviorUDT4, srv6BehaviorUDT6}
+
+// SRv6Locator is an SRv6 locator of an IOS-XR core router and the SIDs
+// allocated from it
+type SRv6Locator struct {
+	Name         string
+	Prefix       netip.Prefix
+	Algorithm    uint32
+	PPS          uint64
+	Up           bool
+	StateChanges uint32
+	SIDs         []*SRv6SID
+}
+
+// SRv6SID is a local SID and the traffic it processed
+type SRv6SID struct {
+	SID      string
+	Behavior string
+	Context  string // adjacency or VRF of the SID
+	share    float64
+	Packets  uint64
+	Bytes    uint64
+	Dropped  uint64 // received while the locator was down
+}
+
+// initSRv6FromConfig creates the locators of the node and allocates their
+// SIDs: one uN, a uA per adjacency and a uDT4 and uDT6 per VRF
+func initSRv6FromConfig(cfg *Config) []*SRv6Locator {
+	if !cfg.SRv6.Enabled {
+		return nil
+	}
+	var locators []*SRv6Locator
+	for _, lc := range cfg.SRv6.Locators {
+		prefix, _ := netip.ParsePrefix(lc.Prefix)
+		l := &SRv6Locator{Name: lc.Name, Prefix: prefix.Masked(), Algorithm: lc.Algorithm, PPS: lc.PPS, Up: true}
+
+		// Transit traffic takes half, decapsulation most of the rest
+		l.addSID(0x0000, srv6BehaviorUN, "", 0.5)
+		for i := range lc.Adjacencies {
+			l.addSID(0xe000+uint16(i), srv6BehaviorUA, fmt.Sprintf("adjacency-%d", i+1), 0.1/float64(lc.Adjacencies))
+		}
+		for i, vrf := range lc.VRFs {
+			share := 0.4 / float64(len(lc.VRFs))
+			l.addSID(0xe100+uint16(2*i), srv6BehaviorUDT4, vrf, share*0.6)
+			l.addSID(0xe101+uint16(2*i), srv6BehaviorUDT6, vrf, share*0.4)
+		}
+		locators = append(locators, l)
+	}
+	return locators
+}
+
+// addSID allocates the SID with the given function right after the locator
+// bits, e.g. fcbb:bb00:1:e000:: in fcbb:bb00:1::/48
+func (l *SRv6Locator) addSID(function uint16, behavior, context string, share float64) {
+	addr := l.Prefix.Addr().As16()
+	at := l.Prefix.Bits() / 8
+	addr[at] = byte(function >> 8)
+	addr[at+1] = byte(function)
+	sid := netip.AddrFrom16(addr)
+	l.SIDs = append(l.SIDs, &SRv6SID{SID: sid.String(), Behavior: behavior, Context: context, share: share})
+}
+
+// FindSRv6Locator returns the locator with the given name, or nil
+func (s *Simulator) FindSRv6Locator(name string) *SRv6Locator {
+	for _, l := range s.SRv6 {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// stepSRv6 counts the traffic of every SID. While a locator is down its
+// SIDs are withdrawn, and what still arrives for them is dropped.
+func (s *Simulator) stepSRv6(seconds float64) {
+	for _, l := range s.SRv6 {
+		for _, sid := range l.SIDs {
+			pkts := uint64(float64(l.PPS) * seconds * sid.share * (0.9 + s.rng.Float64()*0.2))
+			if !l.Up {
+				// Only traffic of stale routes still arrives
+				sid.Dropped += pkts / 20
+				continue
+			}
+			sid.Packets += pkts
+			sid.Bytes += pkts * uint64(400+s.rng.Intn(800))
+		}
+	}
+}
+
+// SetSRv6Locator brings a locator up or down
+func (s *Simulator) SetSRv6Locator(name string, up bool) error {
+	l := s.FindSRv6Locator(name)
+	if l == nil {
+		return fmt.Errorf("unknown SRv6 locator %s", name)
+	}
+	if l.Up == up {
+		return nil
+	}
+	l.Up = up
+	l.StateChanges++
+	if up {
+		s.event("srv6_locator_up", l.Name, "SRv6 locator %s (%s) UP, %d SIDs allocated", l.Name, l.Prefix, len(l.SIDs))
+	} else {
+		s.event("srv6_locator_down", l.Name, "SRv6 locator %s (%s) DOWN, %d SIDs withdrawn", l.Name, l.Prefix, len(l.SIDs))
+	}
+	return nil
+}
+
+// checkSRv6LocatorTarget ensures an event targets a configured locator
+func checkSRv6LocatorTarget(s *Simulator, ev ScenarioEvent) error {
+	if s.FindSRv6Locator(ev.Target) == nil {
+		return fmt.Errorf("unknown SRv6 locator %q", ev.Target)
+	}
+	return nil
+}
+
+// srv6LocatorDownAction is the srv6_locator_down scenario action
+var srv6LocatorDownAction = scenarioAction{
+	check: checkSRv6LocatorTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetSRv6Locator(ev.Target, false)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetSRv6Locator(ev.Target, true)
+	},
+}
+
+// checkSRv6 ensures the SRv6 locators are usable on a node of the platform.
+// They are reported on IOS-XR paths, so only iosxr nodes have them.
+func checkSRv6(cfg SRv6Config, platform string) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if platform != platformIOSXR {
+		return fmt.Errorf("only %s nodes report SRv6, not %s ones", platformIOSXR, platform)
+	}
+	if len(cfg.Locators) == 0 {
+		return fmt.Errorf("at least one locator must be configured")
+	}
+	names := make(map[string]bool)
+	for _, l := range cfg.Locators {
+		if l.Name == "" || names[l.Name] {
+			return fmt.Errorf("locators need unique names")
+		}
+		names[l.Name] = true
+		prefix, err := netip.ParsePrefix(l.Prefix)
+		if err != nil || !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+			return fmt.Errorf("locator %s: prefix %q is not an IPv6 prefix", l.Name, l.Prefix)
+		}
+		if prefix.Bits()%16 != 0 || prefix.Bits() < 32 || prefix.Bits() > 112 {
+			return fmt.Errorf("locator %s: prefix length must be a multiple of 16 from 32 to 112", l.Name)
+		}
+		if l.Adjacencies < 0 || l.Adjacencies > 256 || len(l.VRFs) > 128 {
+			return fmt.Errorf("locator %s: at most 256 adjacencies and 128 VRFs", l.Name)
+		}
+	}
+	return nil
+}
+
+// buildSRv6LocatorTelemetry reports the state of every locator
+func buildSRv6LocatorTelemetry(ts uint64, nodeID string, locators []*SRv6Locator) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, l := range locators {
+		state, code := "down", uint32(0)
+		if l.Up {
+			state, code = "up", 1
+		}
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("locator-name", l.Name, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("prefix", l.Prefix.String(), ts),
+				telemetry.Uint32Field("algorithm", l.Algorithm, ts),
+				telemetry.StringField("oper-state", state, ts),
+				telemetry.Uint32Field("oper-state-code", code, ts),
+				telemetry.Uint32Field("sid-count", uint32(len(l.SIDs)), ts),
+				telemetry.Uint32Field("state-changes", l.StateChanges, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "srv6_locators",
+		EncodingPath:        "Cisco-IOS-XR-segment-routing-srv6-oper:srv6/active/locators/locator",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildSRv6SIDTelemetry reports the counters of every local SID
+func buildSRv6SIDTelemetry(ts uint64, nodeID string, locators []*SRv6Locator) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, l := range locators {
+		state := "in-use"
+		if !l.Up {
+			state = "withdrawn"
+		}
+		for _, sid := range l.SIDs {
+			row := telemetry.RowField(
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("locator-name", l.Name, ts),
+					telemetry.StringField("sid", sid.SID, ts),
+				},
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("behavior", sid.Behavior, ts),
+					telemetry.StringField("context", sid.Context, ts),
+					telemetry.StringField("state", state, ts),
+					telemetry.Uint64Field("packets", sid.Packets, ts),
+					telemetry.Uint64Field("bytes", sid.Bytes, ts),
+					telemetry.Uint64Field("dropped-packets", sid.Dropped, ts),
+				},
+				ts,
+			)
+			rows = append(rows, row)
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "srv6_sids",
+		EncodingPath:        "Cisco-IOS-XR-segment-routing-srv6-oper:srv6/active/locator-all-sids/locator-all-sid",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildSRv6BehaviorTelemetry reports the SIDs and traffic of every behavior
+// per locator
+func buildSRv6BehaviorTelemetry(ts uint64, nodeID string, locators []*SRv6Locator) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, l := range locators {
+		for _, behavior := range srv6Behaviors {
+			var sids uint32
+			var pkts, bytes, dropped uint64
+			for _, sid := range l.SIDs {
+				if sid.Behavior == behavior {
+					sids++
+					pkts += sid.Packets
+					bytes += sid.Bytes
+					dropped += sid.Dropped
+				}
+			}
+			if sids == 0 {
+				continue
+			}
+			row := telemetry.RowField(
+				[]*telemetry.TelemetryField{
+					telemetry.StringField("locator-name", l.Name, ts),
+					telemetry.StringField("behavior", behavior, ts),
+				},
+				[]*telemetry.TelemetryField{
+					telemetry.Uint32Field("sid-count", sids, ts),
+					telemetry.Uint64Field("packets", pkts, ts),
+					telemetry.Uint64Field("bytes", bytes, ts),
+					telemetry.Uint64Field("dropped-packets", dropped, ts),
+				},
+				ts,
+			)
+			rows = append(rows, row)
+		}
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "srv6_behaviors",
+		EncodingPath:        "Cisco-IOS-XR-segment-routing-srv6-oper:srv6/active/manager/sid-mgr-summary/behavior-counters",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
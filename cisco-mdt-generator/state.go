@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Backends of the state store
+const (
+	stateRedis = "redis"
+	stateEtcd  = "etcd"
+)
+
+// stateBackend stores the checkpoints of nodes by key
+type stateBackend interface {
+	// Get returns the value of key, or nil when it is not set
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte) error
+	Close() error
+}
+
+// StateStore keeps a checkpoint of every node in Redis or etcd, so an
+// instance replacing a recycled simulator host resumes its nodes mid-run:
+// counters continue from where they were, and the scenario from where it
+// was, instead of starting over. A checkpoint is saved every collection,
+// before its telemetry is sent, so no counter goes back after a takeover.
+type StateStore struct {
+	cfg     StateConfig
+	backend stateBackend
+
+	mu      sync.Mutex
+	configs map[string]string // configuration fingerprint of every node
+	failing map[string]bool   // nodes whose last save failed, logged once
+}
+
+// checkpoint is the state of a node saved after a collection
+type checkpoint struct {
+	Node     string              `json:"node"`
+	Config   string              `json:"config"` // fingerprint of the node's configuration
+	Saved    time.Time           `json:"saved"`
+	Start    time.Time           `json:"start"`
+	LastStep time.Time           `json:"last_step"`
+	Scenario *scenarioCheckpoint `json:"scenario,omitempty"`
+	State    json.RawMessage     `json:"state"`
+}
+
+// scenarioCheckpoint is the progress of the scenario engine of a node: the
+// steps still to come, including ends of events in progress and branches
+// scheduled by conditions, and the events waiting for a condition
+type scenarioCheckpoint struct {
+	Name    string               `json:"name"`
+	Steps   []checkpointStep     `json:"steps"`
+	Waiting []checkpointWaitStep `json:"waiting,omitempty"`
+}
+
+// checkpointStep is a scheduledStep as saved
+type checkpointStep struct {
+	At      time.Duration `json:"at"`
+	Event   ScenarioEvent `json:"event"`
+	End     bool          `json:"end,omitempty"`
+	Planned bool          `json:"planned,omitempty"`
+}
+
+// checkpointWaitStep is a waitingStep as saved
+type checkpointWaitStep struct {
+	Step     checkpointStep `json:"step"`
+	Deadline time.Duration  `json:"deadline,omitempty"`
+}
+
+// simState is the device state of a simulator that is saved: its exported
+// state, without the shared helpers attached to it
+type simState struct {
+	IngressBytes    uint64              `json:"ingress_bytes"`
+	EgressBytes     uint64              `json:"egress_bytes"`
+	BGPNeighbors    []*BGPNeighbor      `json:"bgp_neighbors"`
+	EVPN            *EVPNState          `json:"evpn"`
+	VNIs            []*VNIState         `json:"vnis"`
+	MACMobility     []*MACMobilityEntry `json:"mac_mobility"`
+	Interfaces      []*InterfaceState   `json:"interfaces"`
+	VLANs           []*VLANState        `json:"vlans"`
+	Tunnels         []*TunnelState      `json:"tunnels"`
+	CoPP            []*CoPPClass        `json:"copp"`
+	CPU             CPUState            `json:"cpu"`
+	Border          *BorderState        `json:"border"`
+	ITD             []*ITDService       `json:"itd"`
+	PBR             []*PBRPolicy        `json:"pbr"`
+	SRv6            []*SRv6Locator      `json:"srv6"`
+	ECMP            []*ECMPGroup        `json:"ecmp"`
+	ASICs           []*ASICState        `json:"asics"`
+	FEX             []*FEXState         `json:"fex"`
+	AAA             *AAAState           `json:"aaa"`
+	Mgmt            *MgmtState          `json:"mgmt"`
+	SoftwareVersion string              `json:"software_version"`
+}
+
+// NewStateStore connects to the configured backend. It returns nil when no
+// backend is configured.
+func NewStateStore(cfg StateConfig) (*StateStore, error) {
+	var backend stateBackend
+	var err error
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case stateRedis:
+		backend, err = newRedisClient(cfg.Address, cfg.Timeout)
+	case stateEtcd:
+		backend, err = newEtcdClient(cfg.Address, cfg.Timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("state %s: %w", cfg.Backend, err)
+	}
+	log.Printf("Persisting simulator state in %s at %s", cfg.Backend, cfg.Address)
+	return &StateStore{cfg: cfg, backend: backend, configs: make(map[string]string), failing: make(map[string]bool)}, nil
+}
+
+// Close releases the connection to the backend. Close does nothing on a nil
+// StateStore.
+func (s *StateStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.backend.Close()
+}
+
+// Restore resumes a new node from its checkpoint, if one was saved with the
+// same configuration: its device state, start time and scenario progress.
+// Restore does nothing on a nil StateStore.
+func (s *StateStore) Restore(sim *Simulator, scenario *ScenarioEngine) error {
+	if s == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	data, err := s.backend.Get(ctx, s.cfg.Prefix+sim.nodeID)
+	if err != nil {
+		return fmt.Errorf("failed to load the state of %s: %w", sim.nodeID, err)
+	}
+	if data == nil {
+		return nil
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("state of %s: %w", sim.nodeID, err)
+	}
+	if cp.Config != s.fingerprint(sim) {
+		log.Printf("%s: configuration changed since its state was saved at %s, starting over",
+			sim.nodeID, cp.Saved.Format(time.RFC3339))
+		return nil
+	}
+	// Decoding into the node's own state keeps what is not saved, such as
+	// the random sources of its objects
+	state := sim.state()
+	if err := json.Unmarshal(cp.State, &state); err != nil {
+		return fmt.Errorf("state of %s: %w", sim.nodeID, err)
+	}
+	sim.setState(state)
+
+	if w := sim.warmUp; w != nil {
+		shift := cp.Start.Sub(w.start)
+		w.start = cp.Start
+		for i := range w.establish {
+			w.establish[i] = w.establish[i].Add(shift)
+		}
+	}
+	sim.startTime, sim.lastStep = cp.Start, cp.LastStep
+	scenario.restore(cp.Start, cp.Scenario)
+
+	log.Printf("%s: resumed from the state saved at %s, %s into the run",
+		sim.nodeID, cp.Saved.Format(time.RFC3339), cp.LastStep.Sub(cp.Start).Round(time.Second))
+	return nil
+}
+
+// Checkpoint encodes the state of a node and its scenario. The caller holds
+// the simulator lock. Checkpoint returns nil on a nil StateStore.
+func (s *StateStore) Checkpoint(sim *Simulator, scenario *ScenarioEngine) []byte {
+	if s == nil {
+		return nil
+	}
+	state, err := json.Marshal(sim.state())
+	if err != nil {
+		log.Printf("%s: failed to encode state: %v", sim.nodeID, err)
+		return nil
+	}
+	data, err := json.Marshal(checkpoint{
+		Node:     sim.nodeID,
+		Config:   s.fingerprint(sim),
+		Saved:    time.Now(),
+		Start:    sim.startTime,
+		LastStep: sim.lastStep,
+		Scenario: scenario.checkpoint(),
+		State:    state,
+	})
+	if err != nil {
+		log.Printf("%s: failed to encode state: %v", sim.nodeID, err)
+		return nil
+	}
+	return data
+}
+
+// Save writes a checkpoint of a node. Failures are logged when a node
+// starts and stops failing, rather than every collection; the run goes on
+// either way. Save does nothing on a nil StateStore or checkpoint.
+func (s *StateStore) Save(nodeID string, data []byte) {
+	if s == nil || data == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	err := s.backend.Set(ctx, s.cfg.Prefix+nodeID, data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil && !s.failing[nodeID]:
+		log.Printf("%s: failed to save state to %s: %v", nodeID, s.cfg.Backend, err)
+		s.failing[nodeID] = true
+	case err == nil && s.failing[nodeID]:
+		log.Printf("%s: saving state to %s again", nodeID, s.cfg.Backend)
+		delete(s.failing, nodeID)
+	}
+}
+
+// state returns the saved part of the simulator's state
+func (s *Simulator) state() simState {
+	return simState{
+		IngressBytes:    s.IngressBytes,
+		EgressBytes:     s.EgressBytes,
+		BGPNeighbors:    s.BGPNeighbors,
+		EVPN:            s.EVPN,
+		VNIs:            s.VNIs,
+		MACMobility:     s.MACMobility,
+		Interfaces:      s.Interfaces,
+		VLANs:           s.VLANs,
+		Tunnels:         s.Tunnels,
+		CoPP:            s.CoPP,
+		CPU:             s.CPU,
+		Border:          s.Border,
+		ITD:             s.ITD,
+		PBR:             s.PBR,
+		SRv6:            s.SRv6,
+		ECMP:            s.ECMP,
+		ASICs:           s.ASICs,
+		FEX:             s.FEX,
+		AAA:             s.AAA,
+		Mgmt:            s.Mgmt,
+		SoftwareVersion: s.SoftwareVersion,
+	}
+}
+
+// setState replaces the saved part of the simulator's state
+func (s *Simulator) setState(st simState) {
+	s.IngressBytes, s.EgressBytes = st.IngressBytes, st.EgressBytes
+	s.BGPNeighbors, s.EVPN, s.VNIs = st.BGPNeighbors, st.EVPN, st.VNIs
+	s.MACMobility, s.Interfaces, s.VLANs = st.MACMobility, st.Interfaces, st.VLANs
+	s.Tunnels, s.CoPP, s.CPU = st.Tunnels, st.CoPP, st.CPU
+	s.Border, s.ITD, s.PBR, s.SRv6, s.ECMP = st.Border, st.ITD, st.PBR, st.SRv6, st.ECMP
+	s.ASICs, s.FEX, s.AAA, s.Mgmt = st.ASICs, st.FEX, st.AAA, st.Mgmt
+	s.SoftwareVersion = st.SoftwareVersion
+}
+
+// checkpoint returns the progress of the engine
+func (e *ScenarioEngine) checkpoint() *scenarioCheckpoint {
+	cp := &scenarioCheckpoint{Name: e.name}
+	for _, step := range e.steps[e.next:] {
+		cp.Steps = append(cp.Steps, checkpointStep{At: step.at, Event: step.event, End: step.end, Planned: step.planned})
+	}
+	for _, w := range e.waiting {
+		s := w.step
+		cp.Waiting = append(cp.Waiting, checkpointWaitStep{
+			Step:     checkpointStep{At: s.at, Event: s.event, End: s.end, Planned: s.planned},
+			Deadline: w.deadline,
+		})
+	}
+	return cp
+}
+
+// restore continues the engine from a checkpoint of the same scenario. The
+// variables of a scenario script are not saved and start over.
+func (e *ScenarioEngine) restore(start time.Time, cp *scenarioCheckpoint) {
+	e.start = start
+	if cp == nil || cp.Name != e.name {
+		return
+	}
+	e.steps, e.next, e.waiting = nil, 0, nil
+	for _, s := range cp.Steps {
+		e.steps = append(e.steps, scheduledStep{at: s.At, event: s.Event, end: s.End, planned: s.Planned})
+	}
+	for _, w := range cp.Waiting {
+		s := w.Step
+		e.waiting = append(e.waiting, waitingStep{
+			step:     scheduledStep{at: s.At, event: s.Event, end: s.End, planned: s.Planned},
+			deadline: w.Deadline,
+		})
+	}
+}
+
+// fingerprint returns the configuration fingerprint of a node, computed
+// once
+func (s *StateStore) fingerprint(sim *Simulator) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fp, ok := s.configs[sim.nodeID]
+	if !ok {
+		fp = configFingerprint(sim.cfg)
+		s.configs[sim.nodeID] = fp
+	}
+	return fp
+}
+
+// configFingerprint identifies a node's configuration, so state saved with
+// another one is not applied
+func configFingerprint(cfg *Config) string {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkState ensures the state store settings are usable
+func checkState(c StateConfig) error {
+	switch c.Backend {
+	case "":
+		return nil
+	case stateRedis, stateEtcd:
+	default:
+		return fmt.Errorf("backend must be %s or %s", stateRedis, stateEtcd)
+	}
+	if c.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unknownKeyError matches the error yaml.v3 reports for a key that no field
+// of the decoded type is tagged with
+var unknownKeyError = regexp.MustCompile(`^line (\d+): field (.+) not found in type [\w.]*\.(\w+)$`)
+
+// unknownKeys returns the unknown keys a strict decode reported, each with
+// its line and the closest known key, or false when err has other problems
+func unknownKeys(err error) ([]string, bool) {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return nil, false
+	}
+	known := configKeys()
+	var keys []string
+	for _, e := range typeErr.Errors {
+		m := unknownKeyError.FindStringSubmatch(e)
+		if m == nil {
+			return nil, false
+		}
+		key := fmt.Sprintf("line %s: unknown key %q", m[1], m[2])
+		if s := closestKey(m[2], known[m[3]]); s != "" {
+			key += fmt.Sprintf(", did you mean %q?", s)
+		}
+		keys = append(keys, key)
+	}
+	return keys, true
+}
+
+// configKeys returns the YAML keys of every struct type reachable from
+// Config, by type name
+func configKeys() map[string][]string {
+	keys := make(map[string][]string)
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		if _, seen := keys[t.Name()]; seen {
+			return
+		}
+		keys[t.Name()] = nil
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "-" || !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			keys[t.Name()] = append(keys[t.Name()], name)
+			walk(f.Type)
+		}
+	}
+	walk(reflect.TypeOf(Config{}))
+	return keys
+}
+
+// closestKey returns the known key within two edits of key, if any
+func closestKey(key string, known []string) string {
+	best, bestDist := "", 3
+	for _, k := range known {
+		if d := editDistance(key, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
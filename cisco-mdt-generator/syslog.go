@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// Syslog severities used by simulated NX-OS messages
+const (
+	SeverityCritical = 2
+	SeverityError    = 3
+	SeverityWarning  = 4
+	SeverityNotice   = 5
+	SeverityInfo     = 6
+)
+
+// syslogFacilityLocal7 is the NX-OS default logging facility
+const syslogFacilityLocal7 = 23
+
+// Syslog emits NX-OS style syslog messages for simulated events. Messages are
+// always logged locally and additionally sent over UDP when a server is set.
+type Syslog struct {
+	hostname string
+	conn     net.Conn
+}
+
+// NewSyslog creates a syslog emitter for the given node, dialing the
+// configured UDP server if any
+func NewSyslog(cfg SyslogConfig, hostname string) (*Syslog, error) {
+	s := &Syslog{hostname: hostname}
+	if cfg.Server == "" {
+		return s, nil
+	}
+
+	conn, err := net.Dial("udp", cfg.Server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial syslog server: %w", err)
+	}
+	s.conn = conn
+	return s, nil
+}
+
+// Emit sends a message formatted like NX-OS logging, e.g.
+// "%L2RIB-2-L2RIB_MAC_DUP_DETECTED: Duplicate MAC ..."
+func (s *Syslog) Emit(now time.Time, severity int, facility, mnemonic, text string) {
+	msg := fmt.Sprintf("%%%s-%d-%s: %s", facility, severity, mnemonic, text)
+	log.Printf("SYSLOG %s: %s", s.hostname, msg)
+
+	if s.conn == nil {
+		return
+	}
+
+	pri := syslogFacilityLocal7*8 + severity
+	line := fmt.Sprintf("<%d>%s %s : %s: %s",
+		pri, now.UTC().Format(time.Stamp), s.hostname, now.UTC().Format("2006 Jan _2 15:04:05 MST"), msg)
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		log.Printf("failed to send syslog message: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// nodePlaceholder is replaced by the node-id-str in TLS file paths and the
+// server name, so every simulated node presents its own identity
+const nodePlaceholder = "{node}"
+
+// ForNode returns the TLS settings with the node placeholder expanded
+func (c TLSConfig) ForNode(nodeID string) TLSConfig {
+	expand := func(s string) string {
+		return strings.ReplaceAll(s, nodePlaceholder, nodeID)
+	}
+	c.CAFile = expand(c.CAFile)
+	c.CertFile = expand(c.CertFile)
+	c.KeyFile = expand(c.KeyFile)
+	c.ServerName = expand(c.ServerName)
+	return c
+}
+
+// dialCredentials builds the transport credentials a node uses to dial the
+// collector: plaintext unless TLS is enabled, optionally with a client
+// certificate so collectors authenticating devices by certificate see a
+// distinct device per node
+func dialCredentials(cfg TLSConfig, nodeID string) (credentials.TransportCredentials, error) {
+	if !cfg.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+	cfg = cfg.ForNode(nodeID)
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate for %s: %w", nodeID, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			log.Printf("Node %s presents client certificate CN=%q", nodeID, leaf.Subject.CommonName)
+		}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// trafficRamp scales the VXLAN traffic linearly from its normal rate to
+// factor times it over duration, then holds it there
+type trafficRamp struct {
+	start    time.Time
+	duration time.Duration
+	factor   float64
+}
+
+// scale returns the scale of the VXLAN traffic at now, 1 without a ramp
+func (r *trafficRamp) scale(now time.Time) float64 {
+	if r == nil {
+		return 1
+	}
+	if r.duration <= 0 || !now.Before(r.start.Add(r.duration)) {
+		return r.factor
+	}
+	progress := max(float64(now.Sub(r.start))/float64(r.duration), 0)
+	return 1 + (r.factor-1)*progress
+}
+
+// StartTrafficRamp ramps the VXLAN traffic to factor times its normal rate
+// over duration; without a duration it changes at once
+func (s *Simulator) StartTrafficRamp(factor float64, duration time.Duration, now time.Time) {
+	s.trafficRamp = &trafficRamp{start: now, duration: duration, factor: factor}
+	s.event("traffic_ramp", s.nodeID, "VXLAN traffic ramping to %gx over %s", factor, duration)
+}
+
+// StopTrafficRamp returns the VXLAN traffic to its normal rate
+func (s *Simulator) StopTrafficRamp() {
+	s.trafficRamp = nil
+	s.event("traffic_normal", s.nodeID, "VXLAN traffic back to its normal rate")
+}
+
+// parseTrafficFactor parses the factor parameter of a traffic_ramp event
+func parseTrafficFactor(ev ScenarioEvent) (float64, error) {
+	factor, err := strconv.ParseFloat(ev.Param("factor", "10"), 64)
+	if err != nil || factor < 0 {
+		return 0, fmt.Errorf("invalid factor %q", ev.Param("factor", "10"))
+	}
+	return factor, nil
+}
+
+// trafficRampAction is the traffic_ramp scenario action. The ramp lasts
+// params.over, by default the duration of the event.
+var trafficRampAction = scenarioAction{
+	check: func(s *Simulator, ev ScenarioEvent) error {
+		if _, err := parseTrafficFactor(ev); err != nil {
+			return err
+		}
+		if over := ev.Param("over", ""); over != "" {
+			if _, err := time.ParseDuration(over); err != nil {
+				return fmt.Errorf("invalid over: %w", err)
+			}
+		}
+		return nil
+	},
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		factor, err := parseTrafficFactor(ev)
+		if err != nil {
+			return err
+		}
+		over := ev.Duration
+		if v := ev.Param("over", ""); v != "" {
+			if over, err = time.ParseDuration(v); err != nil {
+				return fmt.Errorf("invalid over: %w", err)
+			}
+		}
+		s.StartTrafficRamp(factor, over, now)
+		return nil
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		s.StopTrafficRamp()
+		return nil
+	},
+}
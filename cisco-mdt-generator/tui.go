@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// Console UI layout
+const (
+	tuiRefresh    = time.Second
+	tuiRateWindow = 10 * time.Second // send rates are averaged over this window
+	tuiMaxRows    = 10               // rows of the neighbor, VNI and node tables
+	tuiEvents     = 8
+	tuiLogLines   = 3
+)
+
+// ANSI sequences of the console UI
+const (
+	ansiAltScreen  = "\x1b[?1049h\x1b[?25l"
+	ansiMainScreen = "\x1b[?25h\x1b[?1049l"
+	ansiHome       = "\x1b[H\x1b[2J"
+	ansiBold       = "\x1b[1m"
+	ansiGreen      = "\x1b[32m"
+	ansiRed        = "\x1b[31m"
+	ansiReset      = "\x1b[0m"
+)
+
+// SendRates counts the telemetry sent per subscription, by every node of a
+// run, for the console UI
+type SendRates struct {
+	mu    sync.Mutex
+	start time.Time
+	subs  map[string]*subscriptionRate
+}
+
+// subscriptionRate holds the totals and recent sends of one subscription
+type subscriptionRate struct {
+	messages, bytes uint64
+	recent          []rateSample
+}
+
+// rateSample is one send of a subscription
+type rateSample struct {
+	at    time.Time
+	bytes int
+}
+
+// NewSendRates creates empty counters
+func NewSendRates() *SendRates {
+	return &SendRates{start: time.Now(), subs: make(map[string]*subscriptionRate)}
+}
+
+// Observe counts sent messages
+func (r *SendRates) Observe(messages []*telemetry.Telemetry) {
+	now := time.Now()
+	sizes := make([]int, len(messages))
+	for i, m := range messages {
+		sizes[i] = m.Size()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, m := range messages {
+		sub, ok := r.subs[m.SubscriptionIDStr]
+		if !ok {
+			sub = &subscriptionRate{}
+			r.subs[m.SubscriptionIDStr] = sub
+		}
+		sub.messages++
+		sub.bytes += uint64(sizes[i])
+		sub.recent = append(sub.recent, rateSample{at: now, bytes: sizes[i]})
+	}
+}
+
+// rateRow is the rate of one subscription over the window
+type rateRow struct {
+	subscription    string
+	msgsPerSec      float64
+	bytesPerSec     float64
+	messages, bytes uint64
+}
+
+// rows returns the rate of every subscription, sorted by name
+func (r *SendRates) rows(now time.Time) []rateRow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Shorter than the window until it has filled up
+	window := min(now.Sub(r.start), tuiRateWindow).Seconds()
+	rows := make([]rateRow, 0, len(r.subs))
+	for name, sub := range r.subs {
+		i := 0
+		for i < len(sub.recent) && now.Sub(sub.recent[i].at) > tuiRateWindow {
+			i++
+		}
+		sub.recent = sub.recent[i:]
+
+		row := rateRow{subscription: name, messages: sub.messages, bytes: sub.bytes}
+		for _, s := range sub.recent {
+			row.msgsPerSec++
+			row.bytesPerSec += float64(s.bytes)
+		}
+		row.msgsPerSec /= window
+		row.bytesPerSec /= window
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].subscription < rows[j].subscription })
+	return rows
+}
+
+// consoleUI redraws live send rates, BGP and VNI state and recent events in
+// place of the log output of a run
+type consoleUI struct {
+	out    io.Writer
+	sims   []*Simulator
+	rates  *SendRates
+	server string
+	start  time.Time
+
+	mu     sync.Mutex
+	events []string
+	logs   []string
+	last   []byte // partial log line
+}
+
+// startConsoleUI takes over the terminal until the returned function is
+// called, which restores it and prints the last log lines
+func startConsoleUI(ctx context.Context, sims []*Simulator, rates *SendRates, server string) func() {
+	ui := &consoleUI{out: os.Stdout, sims: sims, rates: rates, server: server, start: time.Now()}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+
+	for _, sim := range sims {
+		events, unsubscribe := sim.Events.Subscribe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer unsubscribe()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case ev := <-events:
+					ui.addEvent(fmt.Sprintf("%s %-10s %-16s %s", ev.Time.Format("15:04:05"), sim.nodeID, ev.Type, ev.Target))
+				}
+			}
+		}()
+	}
+
+	log.SetOutput(ui)
+	fmt.Fprint(ui.out, ansiAltScreen)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(tuiRefresh)
+		defer ticker.Stop()
+		for {
+			ui.render()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+		fmt.Fprint(ui.out, ansiMainScreen)
+		log.SetOutput(os.Stderr)
+		ui.mu.Lock()
+		defer ui.mu.Unlock()
+		for _, line := range ui.logs {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+}
+
+// Write keeps the last log lines for the status area
+func (ui *consoleUI) Write(p []byte) (int, error) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.last = append(ui.last, p...)
+	for {
+		i := bytes.IndexByte(ui.last, '\n')
+		if i < 0 {
+			break
+		}
+		ui.logs = append(ui.logs, string(ui.last[:i]))
+		ui.last = ui.last[i+1:]
+	}
+	if len(ui.logs) > tuiLogLines {
+		ui.logs = ui.logs[len(ui.logs)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+func (ui *consoleUI) addEvent(line string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.events = append(ui.events, line)
+	if len(ui.events) > tuiEvents {
+		ui.events = ui.events[len(ui.events)-tuiEvents:]
+	}
+}
+
+// render draws one frame
+func (ui *consoleUI) render() {
+	now := time.Now()
+	var b strings.Builder
+	b.WriteString(ansiHome)
+
+	target := ui.server
+	if target == "" {
+		target = "sinks only"
+	}
+	fmt.Fprintf(&b, "%scisco-mdt-generator %s%s  %d node(s) -> %s  up %s\n\n",
+		ansiBold, version, ansiReset, len(ui.sims), target, now.Sub(ui.start).Truncate(time.Second))
+
+	// Send rates
+	fmt.Fprintf(&b, "%s%-20s %10s %12s %12s %14s%s\n", ansiBold, "SUBSCRIPTION", "MSGS/S", "BYTES/S", "MESSAGES", "BYTES", ansiReset)
+	var total rateRow
+	for _, r := range ui.rates.rows(now) {
+		fmt.Fprintf(&b, "%-20s %10.1f %12.0f %12d %14d\n", r.subscription, r.msgsPerSec, r.bytesPerSec, r.messages, r.bytes)
+		total.msgsPerSec += r.msgsPerSec
+		total.bytesPerSec += r.bytesPerSec
+		total.messages += r.messages
+		total.bytes += r.bytes
+	}
+	fmt.Fprintf(&b, "%-20s %10.1f %12.0f %12d %14d\n\n", "total", total.msgsPerSec, total.bytesPerSec, total.messages, total.bytes)
+
+	if len(ui.sims) == 1 {
+		ui.renderNode(&b, ui.sims[0])
+	} else {
+		ui.renderFleet(&b)
+	}
+
+	// Recent events and log lines
+	ui.mu.Lock()
+	fmt.Fprintf(&b, "%sRECENT EVENTS%s\n", ansiBold, ansiReset)
+	if len(ui.events) == 0 {
+		b.WriteString("(none yet)\n")
+	}
+	for _, line := range ui.events {
+		b.WriteString(line + "\n")
+	}
+	fmt.Fprintf(&b, "\n%sLOG%s\n", ansiBold, ansiReset)
+	for _, line := range ui.logs {
+		b.WriteString(line + "\n")
+	}
+	ui.mu.Unlock()
+
+	io.WriteString(ui.out, b.String())
+}
+
+// renderNode draws the BGP neighbors and VNIs of a single node
+func (ui *consoleUI) renderNode(b *strings.Builder, sim *Simulator) {
+	sim.Lock()
+	defer sim.Unlock()
+
+	fmt.Fprintf(b, "%s%-16s %-8s %-14s %10s %6s%s\n", ansiBold, "NEIGHBOR", "AS", "STATE", "PFX-RCVD", "FLAPS", ansiReset)
+	for i, n := range sim.BGPNeighbors {
+		if i == tuiMaxRows {
+			fmt.Fprintf(b, "... %d more\n", len(sim.BGPNeighbors)-i)
+			break
+		}
+		fmt.Fprintf(b, "%-16s %-8d %s %10d %6d\n", n.Address, n.RemoteAS, colorState(n.State, 14), n.PrefixesRecv, n.FlapCount)
+	}
+
+	fmt.Fprintf(b, "\n%s%-8s %-6s %6s %6s %6s %6s%s\n", ansiBold, "VNI", "STATE", "MACS", "VTEPS", "ARP", "MOVES", ansiReset)
+	for i, v := range sim.VNIs {
+		if i == tuiMaxRows {
+			fmt.Fprintf(b, "... %d more\n", len(sim.VNIs)-i)
+			break
+		}
+		fmt.Fprintf(b, "%-8d %s %6d %6d %6d %6d\n", v.VNIID, colorState(v.State, 6), v.MACCount, v.VTEPCount, v.ARPCount, v.MACMoves)
+	}
+	b.WriteString("\n")
+}
+
+// renderFleet draws one summary row per node
+func (ui *consoleUI) renderFleet(b *strings.Builder) {
+	fmt.Fprintf(b, "%s%-16s %12s %8s %10s %8s%s\n", ansiBold, "NODE", "BGP UP", "FLAPS", "VNIS UP", "ROUTES", ansiReset)
+	for i, sim := range ui.sims {
+		if i == tuiMaxRows {
+			fmt.Fprintf(b, "... %d more\n", len(ui.sims)-i)
+			break
+		}
+		sim.Lock()
+		var up, flaps, vnisUp int
+		for _, n := range sim.BGPNeighbors {
+			if n.State == "Established" {
+				up++
+			}
+			flaps += int(n.FlapCount)
+		}
+		for _, v := range sim.VNIs {
+			if v.State == "Up" {
+				vnisUp++
+			}
+		}
+		bgp := fmt.Sprintf("%d/%d", up, len(sim.BGPNeighbors))
+		vnis := fmt.Sprintf("%d/%d", vnisUp, len(sim.VNIs))
+		if up < len(sim.BGPNeighbors) {
+			bgp = ansiRed + fmt.Sprintf("%12s", bgp) + ansiReset
+		} else {
+			bgp = fmt.Sprintf("%12s", bgp)
+		}
+		fmt.Fprintf(b, "%-16s %s %8d %10s %8d\n", sim.nodeID, bgp, flaps, vnis, sim.EVPN.TotalRoutes)
+		sim.Unlock()
+	}
+	b.WriteString("\n")
+}
+
+// colorState pads a state to width, green when it is up and red otherwise
+func colorState(state string, width int) string {
+	color := ansiRed
+	if state == "Established" || state == "Up" {
+		color = ansiGreen
+	}
+	return color + fmt.Sprintf("%-*s", width, state) + ansiReset
+}
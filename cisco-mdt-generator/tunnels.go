@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// TunnelState tracks a GRE or IP-in-IP tunnel interface, e.g. a DCI link
+// between sites
+type TunnelState struct {
+	Name        string
+	Mode        string // gre or ipip
+	Source      string
+	Destination string
+	Keepalive   time.Duration // GRE keepalive period, 0 for none
+	Retries     int
+	LoadMbps    uint64
+
+	Up           bool
+	StateChanges uint32
+	Unreachable  bool // set while a tunnel_keepalive_loss event cuts the path
+
+	KeepalivesSent  uint64
+	KeepalivesRecv  uint64
+	KeepaliveFails  uint64 // keepalive periods without a reply
+	missed          int    // consecutive unanswered keepalives
+	keepaliveCredit float64
+
+	InPkts, InBytes   uint64
+	OutPkts, OutBytes uint64
+	OutDrops          uint64 // sent into a tunnel that is down
+}
+
+// initTunnelsFromConfig creates the tunnel interfaces of the node, all up
+func initTunnelsFromConfig(cfg *Config) []*TunnelState {
+	var tunnels []*TunnelState
+	for _, tc := range cfg.Tunnels {
+		t := &TunnelState{
+			Name:        tc.Name,
+			Mode:        tc.Mode,
+			Source:      tc.Source,
+			Destination: tc.Destination,
+			Keepalive:   tc.Keepalive,
+			Retries:     tc.KeepaliveRetries,
+			LoadMbps:    tc.LoadMbps,
+			Up:          true,
+		}
+		if t.Mode == "" {
+			t.Mode = "gre"
+		}
+		if t.Retries == 0 {
+			t.Retries = 3
+		}
+		tunnels = append(tunnels, t)
+	}
+	return tunnels
+}
+
+// FindTunnel returns the tunnel interface with the given name, or nil
+func (s *Simulator) FindTunnel(name string) *TunnelState {
+	for _, t := range s.Tunnels {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// stepTunnels exchanges keepalives and counts the traffic of every tunnel.
+// A GRE tunnel with keepalives goes down after retries unanswered
+// keepalives and up on the first answered one; a tunnel without keepalives
+// follows the reachability of its destination right away.
+func (s *Simulator) stepTunnels(seconds float64) {
+	for _, t := range s.Tunnels {
+		up := !t.Unreachable
+		if t.Mode == "gre" && t.Keepalive > 0 {
+			up = t.Up
+			t.keepaliveCredit += seconds / t.Keepalive.Seconds()
+			for ; t.keepaliveCredit >= 1; t.keepaliveCredit-- {
+				t.KeepalivesSent++
+				if t.Unreachable {
+					t.KeepaliveFails++
+					t.missed++
+					if t.missed >= t.Retries {
+						up = false
+					}
+					continue
+				}
+				t.KeepalivesRecv++
+				t.missed = 0
+				up = true
+			}
+		}
+		if up != t.Up {
+			t.Up = up
+			t.StateChanges++
+			if up {
+				s.event("tunnel_up", t.Name, "Interface %s, changed state to up (%s to %s)", t.Name, t.Mode, t.Destination)
+			} else {
+				s.event("tunnel_down", t.Name, "Interface %s, changed state to down (%s to %s, %d keepalives missed)",
+					t.Name, t.Mode, t.Destination, t.missed)
+			}
+		}
+
+		// Offered load in both directions, with 24 bytes of outer headers
+		// on every packet
+		pkts := uint64(float64(t.LoadMbps) * 1e6 / 8 / 900 * seconds * (0.8 + s.rng.Float64()*0.4))
+		if !t.Up {
+			t.OutDrops += pkts
+			continue
+		}
+		overhead := uint64(20)
+		if t.Mode == "gre" {
+			overhead = 24
+		}
+		t.OutPkts += pkts
+		t.OutBytes += pkts * (900 + overhead)
+		in := pkts * uint64(90+s.rng.Intn(20)) / 100
+		t.InPkts += in
+		t.InBytes += in * (900 + overhead)
+	}
+}
+
+// SetTunnelReachable cuts or restores the path to a tunnel's destination
+func (s *Simulator) SetTunnelReachable(name string, reachable bool) error {
+	t := s.FindTunnel(name)
+	if t == nil {
+		return fmt.Errorf("unknown tunnel %s", name)
+	}
+	t.Unreachable = !reachable
+	if reachable {
+		s.event("tunnel_path_restored", t.Name, "Path of %s to %s restored", t.Name, t.Destination)
+	} else {
+		s.event("tunnel_path_lost", t.Name, "Path of %s to %s lost, keepalives unanswered", t.Name, t.Destination)
+	}
+	return nil
+}
+
+// checkTunnelTarget ensures an event targets a configured tunnel
+func checkTunnelTarget(s *Simulator, ev ScenarioEvent) error {
+	if s.FindTunnel(ev.Target) == nil {
+		return fmt.Errorf("unknown tunnel %q", ev.Target)
+	}
+	return nil
+}
+
+// tunnelKeepaliveLossAction is the tunnel_keepalive_loss scenario action
+var tunnelKeepaliveLossAction = scenarioAction{
+	check: checkTunnelTarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetTunnelReachable(ev.Target, false)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		return s.SetTunnelReachable(ev.Target, true)
+	},
+}
+
+// checkTunnels ensures the tunnel interfaces are usable
+func checkTunnels(tunnels []TunnelConfig) error {
+	names := make(map[string]bool)
+	for _, t := range tunnels {
+		if t.Name == "" || names[t.Name] {
+			return fmt.Errorf("tunnels need unique names")
+		}
+		names[t.Name] = true
+		if t.Mode != "" && t.Mode != "gre" && t.Mode != "ipip" {
+			return fmt.Errorf("tunnel %s: mode must be gre or ipip", t.Name)
+		}
+		if net.ParseIP(t.Source) == nil || net.ParseIP(t.Destination) == nil {
+			return fmt.Errorf("tunnel %s: source and destination must be IP addresses", t.Name)
+		}
+		if t.Keepalive < 0 || t.KeepaliveRetries < 0 {
+			return fmt.Errorf("tunnel %s: keepalive and keepalive_retries must be non-negative", t.Name)
+		}
+		if t.Keepalive > 0 && t.Mode == "ipip" {
+			return fmt.Errorf("tunnel %s: keepalives need mode gre", t.Name)
+		}
+	}
+	return nil
+}
+
+// buildTunnelTelemetry reports the state, keepalives and counters of every
+// tunnel interface
+func buildTunnelTelemetry(ts uint64, nodeID string, tunnels []*TunnelState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, t := range tunnels {
+		state, code := "down", uint32(2)
+		if t.Up {
+			state, code = "up", 1
+		}
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("interface", t.Name, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("tunnel-mode", t.Mode, ts),
+				telemetry.StringField("source", t.Source, ts),
+				telemetry.StringField("destination", t.Destination, ts),
+				telemetry.StringField("oper-state", state, ts),
+				telemetry.Uint32Field("oper-state-code", code, ts),
+				telemetry.Uint32Field("state-changes", t.StateChanges, ts),
+				telemetry.Uint64Field("keepalives-sent", t.KeepalivesSent, ts),
+				telemetry.Uint64Field("keepalives-received", t.KeepalivesRecv, ts),
+				telemetry.Uint64Field("keepalive-failures", t.KeepaliveFails, ts),
+				telemetry.Uint64Field("in-packets", t.InPkts, ts),
+				telemetry.Uint64Field("in-bytes", t.InBytes, ts),
+				telemetry.Uint64Field("out-packets", t.OutPkts, ts),
+				telemetry.Uint64Field("out-bytes", t.OutBytes, ts),
+				telemetry.Uint64Field("out-drops", t.OutDrops, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "tunnel_interfaces",
+		EncodingPath:        "Cisco-NX-OS-device:System/tunnelif-items/If-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
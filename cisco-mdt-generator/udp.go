@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"syscall"
+
+	"cisco-mdt-generator/pkg/mdt_dialout"
+)
+
+// Transports of the dial-out streams
+const (
+	transportGRPC = "grpc"
+	transportUDP  = "udp"
+)
+
+// transports lists every dial-out transport
+var transports = []string{transportGRPC, transportUDP}
+
+// checkTransport ensures a dial-out transport is known
+func checkTransport(transport string) error {
+	if !slices.Contains(transports, transport) {
+		return fmt.Errorf("unknown transport %q, expected %s", transport, strings.Join(transports, " or "))
+	}
+	return nil
+}
+
+// nxosUDPHeaderLen is the header NX-OS puts before every telemetry message
+// sent over UDP: version 1, encoding, the 16-bit message size, a secure flag
+// and padding
+const nxosUDPHeaderLen = 6
+
+// maxUDPPayload is the largest datagram UDP carries over IPv4
+const maxUDPPayload = 65507
+
+// udpEncoding returns the encoding of a payload as the UDP headers number
+// it: 1 for GPB, 2 for JSON
+func udpEncoding(encoding string) uint16 {
+	if encoding == encodingJSON {
+		return 2
+	}
+	return 1
+}
+
+// udpFrame frames a payload in the UDP header of a platform: the NX-OS
+// telemetry header, or on IOS-XR the header of its TCP and UDP dial-out
+func udpFrame(platform, encoding string, payload []byte) []byte {
+	if platform == platformIOSXR {
+		frame := make([]byte, tcpDialoutHeaderLen, tcpDialoutHeaderLen+len(payload))
+		binary.BigEndian.PutUint16(frame[0:], 1) // message type: data
+		binary.BigEndian.PutUint16(frame[2:], udpEncoding(encoding))
+		binary.BigEndian.PutUint16(frame[4:], 1) // header version
+		binary.BigEndian.PutUint32(frame[8:], uint32(len(payload)))
+		return append(frame, payload...)
+	}
+	frame := make([]byte, nxosUDPHeaderLen, nxosUDPHeaderLen+len(payload))
+	frame[0] = 1 // version
+	frame[1] = byte(udpEncoding(encoding))
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	return append(frame, payload...)
+}
+
+// udpStream sends the payloads of dial-out messages as datagrams to the
+// collector. Like a switch it gets no feedback: a collector that is not
+// listening is logged, not fatal.
+type udpStream struct {
+	conn     net.Conn
+	nodeID   string
+	platform string
+	encoding string
+	refused  bool // a datagram was refused, logged once
+}
+
+// dialUDP opens the UDP stream of one node to a collector address, in the
+// pinned address family when there is one
+func dialUDP(server string, sim *Simulator, encoding string) (dialoutStream, func(), error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return nil, nil, fmt.Errorf("collector address %q must be host:port, with IPv6 literals in brackets", server)
+	}
+	log.Printf("Sending telemetry over UDP to %s ...", server)
+	cfg := sim.cfg.Dialout
+	network := "udp"
+	family := cfg.AddressFamily
+	if pinned, ok := cfg.AddressFamilies[server]; ok {
+		family = pinned
+	}
+	switch family {
+	case "ipv4":
+		network = "udp4"
+	case "ipv6":
+		network = "udp6"
+	}
+	conn, err := net.Dial(network, server)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	s := &udpStream{conn: conn, nodeID: sim.nodeID, platform: sim.cfg.Platform, encoding: encoding}
+	return s, func() { conn.Close() }, nil
+}
+
+// Send frames the payload of a message and sends it as one datagram.
+// Messages without data, such as sensor errors, have nothing to carry over
+// UDP; messages too large for a datagram are dropped.
+func (s *udpStream) Send(msg *mdt_dialout.MdtDialoutArgs) error {
+	if len(msg.Data) == 0 {
+		return nil
+	}
+	frame := udpFrame(s.platform, s.encoding, msg.Data)
+	if len(frame) > maxUDPPayload {
+		log.Printf("%s: dropping a message of %d bytes, too large for a UDP datagram", s.nodeID, len(msg.Data))
+		return nil
+	}
+	// The port unreachable of an earlier datagram fails the next write
+	_, err := s.conn.Write(frame)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		if !s.refused {
+			log.Printf("%s: collector %s refuses UDP datagrams, still sending", s.nodeID, s.conn.RemoteAddr())
+			s.refused = true
+		}
+		return nil
+	}
+	return err
+}
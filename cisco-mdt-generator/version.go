@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// buildCommit returns the VCS revision the binary was built from, if known
+func buildCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			if len(s.Value) > 12 {
+				return s.Value[:12]
+			}
+			return s.Value
+		}
+	}
+	return "unknown"
+}
+
+func newVersionCmd() *cobra.Command {
+	var schema bool
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the simulator version and schema fingerprint",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("cisco-mdt-generator %s (commit %s, %s %s/%s)\n",
+				version, buildCommit(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+			fmt.Printf("schema fingerprint %s (%d sensor paths, encodings: %s)\n",
+				fingerprint(), len(sensorPaths()), strings.Join(encodings, ", "))
+			if schema {
+				fmt.Println()
+				printSchema(os.Stdout)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&schema, "schema", false, "Also list every path, field and type behind the fingerprint")
+	return cmd
+}
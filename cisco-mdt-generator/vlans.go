@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+
+	"cisco-mdt-generator/pkg/telemetry"
+)
+
+// defaultRoutedPercent is the share of a VLAN's unicast routed through its SVI
+const defaultRoutedPercent = 30
+
+// VLANState counts the traffic of a VLAN and its SVI. A VLAN carries an
+// equal share of what its member interfaces forward, so the VLANs of an
+// interface add up to its counters after storm-control.
+type VLANState struct {
+	ID         uint32
+	Name       string
+	VNI        *VNIState // mapped L2VNI, nil for a local VLAN
+	Interfaces []*InterfaceState
+	SVI        bool
+	Routed     float64 // share of unicast routed through the SVI
+
+	InOctets       uint64
+	InUcastPkts    uint64
+	InBcastPkts    uint64
+	InMcastPkts    uint64
+	InUnkUcastPkts uint64
+
+	SVIInPkts    uint64
+	SVIInOctets  uint64
+	SVIOutPkts   uint64
+	SVIOutOctets uint64
+	SVIInBcast   uint64 // ARP and other broadcasts to the gateway
+}
+
+// initVLANsFromConfig creates the VLANs of the node with their member
+// interfaces and mapped VNI
+func (s *Simulator) initVLANsFromConfig(cfg *Config) []*VLANState {
+	var vlans []*VLANState
+	for _, vc := range cfg.VLANs {
+		v := &VLANState{ID: vc.ID, Name: vc.Name, SVI: vc.SVI, Routed: vc.RoutedPercent / 100}
+		if v.Name == "" {
+			v.Name = fmt.Sprintf("VLAN%04d", v.ID)
+		}
+		if vc.RoutedPercent == 0 {
+			v.Routed = defaultRoutedPercent / 100.0
+		}
+		if vc.VNI != 0 {
+			v.VNI = s.FindVNI(vc.VNI)
+		}
+		for _, name := range vc.Interfaces {
+			if intf := s.FindInterface(name); intf != nil {
+				v.Interfaces = append(v.Interfaces, intf)
+			}
+		}
+		vlans = append(vlans, v)
+	}
+	return vlans
+}
+
+// up reports whether the VLAN forwards: a VLAN mapped to a VNI that is down
+// carries no traffic and its SVI is down
+func (v *VLANState) up() bool {
+	return v.VNI == nil || v.VNI.State == "Up"
+}
+
+// stepVLANs shares the traffic every interface forwarded in the last
+// interval among its VLANs and routes part of their unicast through the SVIs
+func (s *Simulator) stepVLANs() {
+	members := make(map[*InterfaceState]int)
+	for _, v := range s.VLANs {
+		for _, intf := range v.Interfaces {
+			members[intf]++
+		}
+	}
+	for _, v := range s.VLANs {
+		if !v.up() {
+			continue
+		}
+		var p ifPackets
+		for _, intf := range v.Interfaces {
+			n := uint64(members[intf])
+			p.ucast += intf.forwarded.ucast / n
+			p.bcast += intf.forwarded.bcast / n
+			p.mcast += intf.forwarded.mcast / n
+			p.unkUcast += intf.forwarded.unkUcast / n
+		}
+		v.InUcastPkts += p.ucast
+		v.InBcastPkts += p.bcast
+		v.InMcastPkts += p.mcast
+		v.InUnkUcastPkts += p.unkUcast
+		v.InOctets += p.ucast*ifAvgUcastBytes + (p.bcast+p.mcast+p.unkUcast)*ifAvgBUMBytes
+
+		if !v.SVI {
+			continue
+		}
+		// Routed traffic enters the SVI from the VLAN and leaves it towards
+		// the hosts, with the broadcasts of the VLAN reaching the gateway
+		routed := uint64(float64(p.ucast) * v.Routed)
+		v.SVIInPkts += routed + p.bcast
+		v.SVIInOctets += routed*ifAvgUcastBytes + p.bcast*ifAvgBUMBytes
+		v.SVIOutPkts += routed
+		v.SVIOutOctets += routed * ifAvgUcastBytes
+		v.SVIInBcast += p.bcast
+	}
+}
+
+// checkVLANs ensures the VLANs are usable and reference configured
+// interfaces and VNIs
+func checkVLANs(cfg *Config) error {
+	interfaces := make(map[string]bool)
+	for _, intf := range cfg.Interfaces {
+		interfaces[intf.Name] = true
+	}
+	vnis := make(map[uint32]bool)
+	for _, v := range cfg.VNIStates {
+		vnis[v.VNIID] = true
+	}
+	ids := make(map[uint32]bool)
+	for _, v := range cfg.VLANs {
+		if v.ID == 0 || v.ID > 4094 || ids[v.ID] {
+			return fmt.Errorf("vlans need unique ids from 1 to 4094")
+		}
+		ids[v.ID] = true
+		if v.VNI != 0 && !vnis[v.VNI] {
+			return fmt.Errorf("vlan %d: vni %d is not in vni_states", v.ID, v.VNI)
+		}
+		for _, name := range v.Interfaces {
+			if !interfaces[name] {
+				return fmt.Errorf("vlan %d: interface %s is not in interfaces", v.ID, name)
+			}
+		}
+		if v.RoutedPercent < 0 || v.RoutedPercent > 100 {
+			return fmt.Errorf("vlan %d: routed_percent must be between 0 and 100", v.ID)
+		}
+	}
+	return nil
+}
+
+// buildVLANTelemetry reports the traffic of every VLAN and the MACs learned
+// in it
+func buildVLANTelemetry(ts uint64, nodeID string, vlans []*VLANState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, v := range vlans {
+		var vni, macs uint32
+		if v.VNI != nil {
+			vni, macs = v.VNI.VNIID, v.VNI.MACCount
+		}
+		state := "active"
+		if !v.up() {
+			state = "suspended"
+		}
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.Uint32Field("vlan-id", v.ID, ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("name", v.Name, ts),
+				telemetry.StringField("oper-state", state, ts),
+				telemetry.Uint32Field("vni", vni, ts),
+				telemetry.Uint32Field("mac-count", macs, ts),
+				telemetry.Uint32Field("member-ports", uint32(len(v.Interfaces)), ts),
+				telemetry.Uint64Field("in-octets", v.InOctets, ts),
+				telemetry.Uint64Field("in-ucast-pkts", v.InUcastPkts, ts),
+				telemetry.Uint64Field("in-bcast-pkts", v.InBcastPkts, ts),
+				telemetry.Uint64Field("in-mcast-pkts", v.InMcastPkts, ts),
+				telemetry.Uint64Field("in-unknown-ucast-pkts", v.InUnkUcastPkts, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "vlan_counters",
+		EncodingPath:        "Cisco-NX-OS-device:System/bd-items/bd-items/BD-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
+
+// buildSVITelemetry reports the state and counters of every SVI
+func buildSVITelemetry(ts uint64, nodeID string, vlans []*VLANState) *telemetry.Telemetry {
+	var rows []*telemetry.TelemetryField
+
+	for _, v := range vlans {
+		if !v.SVI {
+			continue
+		}
+		state, code := "down", uint32(2)
+		if v.up() {
+			state, code = "up", 1
+		}
+		row := telemetry.RowField(
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("id", fmt.Sprintf("vlan%d", v.ID), ts),
+			},
+			[]*telemetry.TelemetryField{
+				telemetry.StringField("oper-state", state, ts),
+				telemetry.Uint32Field("oper-state-code", code, ts),
+				telemetry.Uint64Field("in-pkts", v.SVIInPkts, ts),
+				telemetry.Uint64Field("in-octets", v.SVIInOctets, ts),
+				telemetry.Uint64Field("in-bcast-pkts", v.SVIInBcast, ts),
+				telemetry.Uint64Field("out-pkts", v.SVIOutPkts, ts),
+				telemetry.Uint64Field("out-octets", v.SVIOutOctets, ts),
+			},
+			ts,
+		)
+		rows = append(rows, row)
+	}
+
+	return &telemetry.Telemetry{
+		NodeIDStr:           nodeID,
+		SubscriptionIDStr:   "svi_counters",
+		EncodingPath:        "Cisco-NX-OS-device:System/intf-items/svi-items/If-list",
+		CollectionStartTime: ts,
+		CollectionEndTime:   ts,
+		MsgTimestamp:        ts,
+		DataGpbkv:           rows,
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetVNIUp brings a VNI down or back up. A VNI going down loses its hosts,
+// withdrawing their EVPN type-2 routes, and stops carrying VXLAN traffic; it
+// relearns them when it comes back up.
+func (s *Simulator) SetVNIUp(vniID uint32, up bool) error {
+	vni := s.FindVNI(vniID)
+	if vni == nil {
+		return fmt.Errorf("unknown VNI %d", vniID)
+	}
+	if (vni.StateCode == 1) == up {
+		return nil
+	}
+
+	if up {
+		vni.State, vni.StateCode = "Up", 1
+		vni.MACCount, vni.ARPCount = vni.downMACs, vni.downARPs
+		s.EVPN.Type2Routes += vni.MACCount
+		s.event("vni_up", fmt.Sprint(vniID), "VNI %d is UP, %d MACs relearned", vniID, vni.MACCount)
+	} else {
+		vni.State, vni.StateCode = "Down", 0
+		vni.downMACs, vni.downARPs = vni.MACCount, vni.ARPCount
+		s.EVPN.Type2Routes -= min(s.EVPN.Type2Routes, vni.MACCount)
+		vni.MACCount, vni.ARPCount = 0, 0
+		s.event("vni_down", fmt.Sprint(vniID), "VNI %d is DOWN, %d MACs withdrawn", vniID, vni.downMACs)
+	}
+	s.EVPN.TotalRoutes = s.EVPN.Type2Routes + s.EVPN.Type3Routes + s.EVPN.Type5Routes
+	return nil
+}
+
+// vniUpShare is the share of the VNIs that are up, which carry the VXLAN
+// traffic
+func (s *Simulator) vniUpShare() float64 {
+	if len(s.VNIs) == 0 {
+		return 1
+	}
+	up := 0
+	for _, v := range s.VNIs {
+		if v.StateCode == 1 {
+			up++
+		}
+	}
+	return float64(up) / float64(len(s.VNIs))
+}
+
+// vniDownAction is the vni_down scenario action
+var vniDownAction = scenarioAction{
+	check: checkVNITarget,
+	start: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		vni, err := parseVNITarget(ev.Target)
+		if err != nil {
+			return err
+		}
+		return s.SetVNIUp(vni, false)
+	},
+	end: func(s *Simulator, ev ScenarioEvent, now time.Time) error {
+		vni, err := parseVNITarget(ev.Target)
+		if err != nil {
+			return err
+		}
+		return s.SetVNIUp(vni, true)
+	},
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+)
+
+// warmUp ramps a node from an empty state to the configured steady state,
+// like a leaf joining the fabric: sessions come up one by one while routes,
+// hosts and traffic grow towards their configured levels
+type warmUp struct {
+	start    time.Time
+	duration time.Duration
+
+	establish []time.Time // when each neighbor's session comes up
+	prefixes  []uint32    // steady-state prefixes of each neighbor
+	evpn      EVPNState
+	macs      []uint32
+	arps      []uint32
+}
+
+// startWarmUp empties the state of a new simulator and records the steady
+// state to ramp towards, when a warm-up is configured
+func (s *Simulator) startWarmUp(start time.Time) {
+	duration := s.cfg.Simulation.WarmUp
+	if duration <= 0 {
+		return
+	}
+
+	w := &warmUp{start: start, duration: duration, evpn: *s.EVPN}
+	for _, n := range s.BGPNeighbors {
+		// Sessions come up during the first half, leaving routes time to grow
+		w.establish = append(w.establish, start.Add(time.Duration(s.rng.Int63n(int64(duration/2)+1))))
+		w.prefixes = append(w.prefixes, n.PrefixesRecv)
+		n.State, n.StateCode = "Idle", 1
+		n.PrefixesRecv = 0
+	}
+	for _, v := range s.VNIs {
+		w.macs = append(w.macs, v.MACCount)
+		w.arps = append(w.arps, v.ARPCount)
+		v.MACCount, v.ARPCount = 0, 0
+	}
+	s.EVPN.Type2Routes, s.EVPN.Type3Routes, s.EVPN.Type5Routes, s.EVPN.TotalRoutes = 0, 0, 0, 0
+	s.warmUp = w
+}
+
+// ramp returns how far the warm-up has progressed, from 0 to 1
+func (w *warmUp) ramp(now time.Time) float64 {
+	return min(float64(now.Sub(w.start))/float64(w.duration), 1)
+}
+
+// stepWarmUp brings established sessions, routes and hosts to their share of
+// the steady state, and ends the warm-up once it is reached
+func (s *Simulator) stepWarmUp(now time.Time, ramp float64) {
+	w := s.warmUp
+	scale := func(v uint32) uint32 { return uint32(float64(v) * ramp) }
+
+	for i, n := range s.BGPNeighbors {
+		if n.Maintenance || i >= len(w.establish) {
+			continue
+		}
+		if n.State != "Established" && !now.Before(w.establish[i]) {
+			n.State, n.StateCode = "Established", 6
+			n.LastFlap = now
+			s.event("bgp_established", n.Address, "BGP neighbor %s Established while warming up", n.Address)
+		}
+		if n.State == "Established" {
+			n.Uptime = uint64(now.Sub(n.LastFlap).Seconds())
+			n.PrefixesRecv = scale(w.prefixes[i])
+		}
+	}
+
+	s.EVPN.Type2Routes = scale(w.evpn.Type2Routes)
+	s.EVPN.Type3Routes = scale(w.evpn.Type3Routes)
+	s.EVPN.Type5Routes = scale(w.evpn.Type5Routes)
+	s.EVPN.TotalRoutes = s.EVPN.Type2Routes + s.EVPN.Type3Routes + s.EVPN.Type5Routes
+
+	for i, v := range s.VNIs {
+		if i < len(w.macs) && v.StateCode == 1 {
+			v.MACCount, v.ARPCount = scale(w.macs[i]), scale(w.arps[i])
+		}
+	}
+
+	if ramp >= 1 {
+		s.warmUp = nil
+		s.event("warm_up_complete", s.nodeID, "%s reached steady state after a warm-up of %s", s.nodeID, w.duration)
+	}
+}